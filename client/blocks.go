@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+// GetBlocks returns spanX blocks of uncompressed voxels along X, starting from the given
+// block coordinate, of an imageblk instance.  The caller must close the returned body.
+func (i Instance) GetBlocks(ctx context.Context, blockCoord [3]int32, spanX int) (io.ReadCloser, error) {
+	return i.Get(ctx, nil, "blocks", coord(blockCoord), strconv.Itoa(spanX))
+}
+
+// PutBlocks stores spanX blocks of uncompressed voxels along X, starting from the given
+// block coordinate, of an imageblk instance.  Storing the same voxels again has the same
+// effect, so the request is retried after failures.
+func (i Instance) PutBlocks(ctx context.Context, blockCoord [3]int32, spanX int, data []byte) error {
+	return i.Post(Idempotent(ctx), nil, data, "blocks", coord(blockCoord), strconv.Itoa(spanX))
+}
+
+// GetLabelBlocks returns the compressed label blocks of a labelarray instance within a
+// block-aligned subvolume, streamed as sent by the server.  The compression can be ""
+// for the server default, e.g., "blocks" or "uncompressed".  The caller must close the
+// returned body.
+func (i Instance) GetLabelBlocks(ctx context.Context, size, offset [3]int32, compression string) (io.ReadCloser, error) {
+	var query url.Values
+	if compression != "" {
+		query = url.Values{"compression": []string{compression}}
+	}
+	return i.Get(ctx, query, "blocks", coord(size), coord(offset))
+}
+
+// PutLabelBlocks streams label blocks, in the format given by GetLabelBlocks, to a
+// labelarray instance.
+func (i Instance) PutLabelBlocks(ctx context.Context, data io.Reader) error {
+	return i.PostStream(ctx, nil, data, "blocks")
+}
+
+// GetSubvolume returns the voxels of a subvolume of an imageblk, labelblk, or
+// labelarray instance in X, Y, then Z order.  The caller must close the returned body.
+func (i Instance) GetSubvolume(ctx context.Context, size, offset [3]int32) (io.ReadCloser, error) {
+	return i.Get(ctx, nil, "raw", "0_1_2", coord(size), coord(offset))
+}
+
+// PutSubvolume stores the voxels of a subvolume, given in X, Y, then Z order, of an
+// imageblk, labelblk, or labelarray instance.  Like PutBlocks, it's retried after
+// failures.
+func (i Instance) PutSubvolume(ctx context.Context, size, offset [3]int32, data []byte) error {
+	return i.Post(Idempotent(ctx), nil, data, "raw", "0_1_2", coord(size), coord(offset))
+}
@@ -0,0 +1,269 @@
+/*
+	Package client provides a typed Go API for DVID's HTTP API, so ingestion tools and
+	other programs can use repos, versions, and data instances without building URLs.
+
+	A Client holds a pool of connections to one server and retries idempotent requests
+	that fail from network errors or server overload.  POSTs are only retried if their
+	context is marked by Idempotent, as the typed writes below that set rather than
+	append data do.  Handles for repos, version nodes, and data instances are cheap values
+	derived from the Client:
+
+		c := client.New("http://emdata.janelia.org:8000")
+		node := c.Node("3f8c")
+		if err := node.Instance("annotations").PutKey(ctx, "synapse-1", data); err != nil {
+			...
+		}
+
+	Only the HTTP API is covered.  The server's gRPC service runs the commands of the dvid
+	terminal client, e.g., backups, and has no data operations, so the client doesn't use
+	it.
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries is the default number of times a failed request is retried.
+	DefaultMaxRetries = 3
+
+	// DefaultRetryWait is the default wait before the first retry, which doubles for each
+	// later retry unless the server gives a Retry-After.
+	DefaultRetryWait = 500 * time.Millisecond
+
+	// DefaultMaxConnsPerHost is the default number of idle connections kept to the server.
+	DefaultMaxConnsPerHost = 32
+)
+
+// Client makes requests to a DVID server's HTTP API.  It's safe for concurrent use.
+type Client struct {
+	// Address is the server's URL, e.g., "http://localhost:8000".
+	Address string
+
+	// Token is sent as a bearer token if set, e.g., for servers requiring API tokens.
+	Token string
+
+	// MaxRetries is the number of times a request is retried after network errors or
+	// responses with status 429, 502, 503, or 504.  Only GET, HEAD, PUT, and DELETE
+	// requests are retried unless the context is marked by Idempotent, since a POST may
+	// have been applied before the failure.  Requests with bodies that can't be replayed,
+	// i.e., given as an io.Reader, are not retried.
+	MaxRetries int
+
+	// RetryWait is the wait before the first retry.
+	RetryWait time.Duration
+
+	// HTTPClient makes the requests.  New gives a client pooling connections to the server.
+	HTTPClient *http.Client
+}
+
+// New returns a Client for the server at the given address, e.g., "localhost:8000" or
+// "https://emdata.janelia.org".  The scheme defaults to http.
+func New(address string) *Client {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        DefaultMaxConnsPerHost,
+		MaxIdleConnsPerHost: DefaultMaxConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &Client{
+		Address:    strings.TrimRight(address, "/"),
+		MaxRetries: DefaultMaxRetries,
+		RetryWait:  DefaultRetryWait,
+		HTTPClient: &http.Client{Transport: transport},
+	}
+}
+
+// StatusError is returned for responses with an unexpected HTTP status.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Message    string // response body, usually DVID's error message.
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s returned status %d: %s", e.Method, e.URL, e.StatusCode, strings.TrimSpace(e.Message))
+}
+
+// IsNotFound returns true if an error is a response with status 404 (Not Found).
+func IsNotFound(err error) bool {
+	se, ok := err.(*StatusError)
+	return ok && se.StatusCode == http.StatusNotFound
+}
+
+// request describes a request to the API.
+type request struct {
+	method string
+	path   string // path after "/api", e.g., "/repos/info".
+	query  url.Values
+	header http.Header
+
+	body   []byte    // replayable body, so the request can be retried.
+	stream io.Reader // body that is streamed once.
+}
+
+type idempotentKey struct{}
+
+// Idempotent returns a context whose requests are retried like GETs even if their
+// method isn't idempotent, e.g., for a POST that sets rather than appends data, so
+// repeating it has the same effect as making it once.
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// idempotent returns true if a request with the given method and context can be sent
+// more than once.
+func idempotent(ctx context.Context, method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	marked, _ := ctx.Value(idempotentKey{}).(bool)
+	return marked
+}
+
+// retryable returns true if a response status indicates the request may succeed later.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do sends a request, retrying if possible, and returns a response with a 2xx status.
+// Other responses are returned as a *StatusError.  The caller must close the response
+// body.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	u := c.Address + "/api" + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	maxRetries := c.MaxRetries
+	if req.stream != nil || !idempotent(ctx, req.method) {
+		maxRetries = 0
+	}
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		body := req.stream
+		if req.body != nil {
+			body = bytes.NewReader(req.body)
+		}
+		hreq, err := http.NewRequest(req.method, u, body)
+		if err != nil {
+			return nil, err
+		}
+		hreq = hreq.WithContext(ctx)
+		for key, values := range req.header {
+			hreq.Header[key] = values
+		}
+		if c.Token != "" {
+			hreq.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+		resp, err := c.HTTPClient.Do(hreq)
+		if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+		if err == nil {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+			err = &StatusError{Method: req.method, URL: u, StatusCode: resp.StatusCode, Message: string(msg)}
+			if !retryable(resp.StatusCode) {
+				return nil, err
+			}
+			if secs, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && secs > 0 {
+				wait = time.Duration(secs) * time.Second
+			}
+		}
+		if attempt >= maxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// getJSON decodes the JSON response of a GET into v.
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	resp, err := c.do(ctx, request{method: "GET", path: path, query: query})
+	if err != nil {
+		return err
+	}
+	return decodeJSON(resp, v)
+}
+
+// decodeJSON decodes a JSON response into v and closes the response body.
+func decodeJSON(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// postJSON posts the JSON encoding of in and, if out isn't nil, decodes the JSON
+// response into out.
+func (c *Client) postJSON(ctx context.Context, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	resp, err := c.do(ctx, request{method: "POST", path: path, header: header, body: body})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ServerInfo returns the server's properties, e.g., its DVID version and storage engines.
+func (c *Client) ServerInfo(ctx context.Context) (map[string]string, error) {
+	var info map[string]string
+	err := c.getJSON(ctx, "/server/info", nil, &info)
+	return info, err
+}
+
+// ReposInfo returns the JSON for each repo keyed by root UUID.
+func (c *Client) ReposInfo(ctx context.Context) (map[string]json.RawMessage, error) {
+	var repos map[string]json.RawMessage
+	err := c.getJSON(ctx, "/repos/info", nil, &repos)
+	return repos, err
+}
+
+// NewRepo creates a repo and returns a handle to it.
+func (c *Client) NewRepo(ctx context.Context, alias, description string) (Repo, error) {
+	in := map[string]string{"alias": alias, "description": description}
+	var out struct {
+		Root string `json:"root"`
+	}
+	if err := c.postJSON(ctx, "/repos", in, &out); err != nil {
+		return Repo{}, err
+	}
+	return c.Repo(out.Root), nil
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetries(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			http.Error(w, "server busy", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("expected bearer token, got %q\n", r.Header.Get("Authorization"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL)
+	c.Token = "secret"
+	c.RetryWait = time.Millisecond
+	if err := c.Node("abc").Instance("kv").PutKey(context.Background(), "k", []byte("v")); err != nil {
+		t.Fatalf("expected success after retries, got %v\n", err)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d\n", requests)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	c.MaxRetries = 1
+	err := c.Node("abc").Instance("kv").PutKey(context.Background(), "k", []byte("v"))
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 status error after retries ran out, got %v\n", err)
+	}
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "server busy", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL)
+	c.RetryWait = time.Millisecond
+	c.MaxRetries = 2
+	node := c.Node("abc")
+	if _, err := node.NewVersion(context.Background(), "note"); err == nil {
+		t.Fatalf("expected error from busy server\n")
+	}
+	if requests != 1 {
+		t.Errorf("expected POST not to be retried, got %d requests\n", requests)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	if err := node.Instance("kv").DeleteKey(context.Background(), "k"); err == nil {
+		t.Fatalf("expected error from busy server\n")
+	}
+	if requests != 3 {
+		t.Errorf("expected DELETE to be retried twice, got %d requests\n", requests)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	if err := node.Instance("kv").Post(Idempotent(context.Background()), nil, []byte("v"), "key", "k"); err == nil {
+		t.Fatalf("expected error from busy server\n")
+	}
+	if requests != 3 {
+		t.Errorf("expected POST marked idempotent to be retried twice, got %d requests\n", requests)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	keys := []string{"a1", "a2", "a3", "b1", "b2"}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/node/abc/kv/key/missing":
+			http.Error(w, "Key not found", http.StatusNotFound)
+			return
+		case r.URL.Path != "/api/node/abc/kv/keys":
+			t.Errorf("unexpected request %s\n", r.URL)
+			return
+		}
+		query := r.URL.Query()
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		start := sort.SearchStrings(keys, query.Get("cursor"))
+		if start < len(keys) && keys[start] == query.Get("cursor") {
+			start++
+		}
+		var page []string
+		for _, key := range keys[start:] {
+			if strings.HasPrefix(key, query.Get("prefix")) {
+				page = append(page, key)
+			}
+		}
+		if len(page) > limit {
+			page = page[:limit]
+			w.Header().Set("X-Next-Cursor", page[limit-1])
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer ts.Close()
+
+	KeyPageSize = 2
+	defer func() { KeyPageSize = 10000 }()
+	kv := New(ts.URL).Node("abc").Instance("kv")
+	var listed []string
+	err := kv.Keys(context.Background(), "a", func(key string) bool {
+		listed = append(listed, key)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("error listing keys: %v\n", err)
+	}
+	if strings.Join(listed, ",") != "a1,a2,a3" {
+		t.Errorf("expected keys with prefix a over pages, got %v\n", listed)
+	}
+
+	if _, err := kv.GetKey(context.Background(), "missing"); !IsNotFound(err) {
+		t.Errorf("expected not found error, got %v\n", err)
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	const content = "0123456789"
+	for _, ranged := range []bool{true, false} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ranged {
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+			} else {
+				w.Write([]byte(content))
+			}
+		}))
+		body, err := New(ts.URL).Node("abc").Instance("grayscale").ReadRange(context.Background(), 3, 4, "raw", "0_1_2", "10_1_1", "0_0_0")
+		if err != nil {
+			t.Fatalf("error reading range: %v\n", err)
+		}
+		data, _ := ioutil.ReadAll(body)
+		body.Close()
+		ts.Close()
+		if string(data) != "3456" {
+			t.Errorf("expected bytes 3-6 when server handles ranges is %t, got %q\n", ranged, data)
+		}
+	}
+}
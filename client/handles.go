@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Repo is a handle to a repo given by the UUID of any of its version nodes.
+type Repo struct {
+	c    *Client
+	UUID string
+}
+
+// Repo returns a handle to the repo with a version of the given UUID.
+func (c *Client) Repo(uuid string) Repo {
+	return Repo{c: c, UUID: uuid}
+}
+
+// Info returns the repo's JSON, including its DAG and data instances.
+func (r Repo) Info(ctx context.Context) (json.RawMessage, error) {
+	var info json.RawMessage
+	err := r.c.getJSON(ctx, "/repo/"+r.UUID+"/info", nil, &info)
+	return info, err
+}
+
+// Instances returns the JSON of the repo's data instances, optionally only of a type.
+func (r Repo) Instances(ctx context.Context, typename string) ([]json.RawMessage, error) {
+	var query url.Values
+	if typename != "" {
+		query = url.Values{"type": []string{typename}}
+	}
+	var instances []json.RawMessage
+	err := r.c.getJSON(ctx, "/repo/"+r.UUID+"/instances", query, &instances)
+	return instances, err
+}
+
+// NewInstance creates a data instance of the given type in the repo.  Any settings,
+// e.g., "BlockSize" or "Versioned", are sent with the type and name.
+func (r Repo) NewInstance(ctx context.Context, typename, name string, settings map[string]string) error {
+	in := map[string]string{"typename": typename, "dataname": name}
+	for key, value := range settings {
+		in[key] = value
+	}
+	return r.c.postJSON(ctx, "/repo/"+r.UUID+"/instance", in, nil)
+}
+
+// Node is a handle to a version node of a repo.
+type Node struct {
+	c    *Client
+	UUID string
+}
+
+// Node returns a handle to the version with the given UUID, which can be abbreviated to
+// any unique prefix.
+func (c *Client) Node(uuid string) Node {
+	return Node{c: c, UUID: uuid}
+}
+
+// Repo returns a handle to the node's repo.
+func (n Node) Repo() Repo {
+	return Repo{c: n.c, UUID: n.UUID}
+}
+
+// Commit locks the node with a note and log messages.
+func (n Node) Commit(ctx context.Context, note string, log []string) error {
+	in := struct {
+		Note string   `json:"note"`
+		Log  []string `json:"log,omitempty"`
+	}{note, log}
+	return n.c.postJSON(ctx, "/node/"+n.UUID+"/commit", in, nil)
+}
+
+// NewVersion creates a child of the node, which must be committed, on the same branch.
+func (n Node) NewVersion(ctx context.Context, note string) (Node, error) {
+	var out struct {
+		Child string `json:"child"`
+	}
+	if err := n.c.postJSON(ctx, "/node/"+n.UUID+"/newversion", map[string]string{"note": note}, &out); err != nil {
+		return Node{}, err
+	}
+	return n.c.Node(out.Child), nil
+}
+
+// Branch creates a child of the node, which must be committed, on a new branch.
+func (n Node) Branch(ctx context.Context, branch, note string) (Node, error) {
+	var out struct {
+		Child string `json:"child"`
+	}
+	if err := n.c.postJSON(ctx, "/node/"+n.UUID+"/branch", map[string]string{"branch": branch, "note": note}, &out); err != nil {
+		return Node{}, err
+	}
+	return n.c.Node(out.Child), nil
+}
+
+// Instance returns a handle to a data instance at this version.
+func (n Node) Instance(name string) Instance {
+	return Instance{c: n.c, Node: n.UUID, Name: name}
+}
+
+// Instance is a handle to a data instance at a version.
+type Instance struct {
+	c    *Client
+	Node string // UUID of the version.
+	Name string
+}
+
+// path returns the API path of the instance's endpoint, escaping each part.
+func (i Instance) path(endpoint string, parts ...string) string {
+	p := fmt.Sprintf("/node/%s/%s/%s", i.Node, url.PathEscape(i.Name), endpoint)
+	for _, part := range parts {
+		p += "/" + url.PathEscape(part)
+	}
+	return p
+}
+
+// Info returns the JSON describing the instance.
+func (i Instance) Info(ctx context.Context) (json.RawMessage, error) {
+	var info json.RawMessage
+	err := i.c.getJSON(ctx, i.path("info"), nil, &info)
+	return info, err
+}
+
+// Get returns the response body of a GET of any endpoint of the instance, e.g.,
+// Get(ctx, nil, "sparsevol", "23"), for endpoints without a typed method.  The caller
+// must close the returned body.
+func (i Instance) Get(ctx context.Context, query url.Values, endpoint string, parts ...string) (io.ReadCloser, error) {
+	resp, err := i.c.do(ctx, request{method: "GET", path: i.path(endpoint, parts...), query: query})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Post sends data to any endpoint of the instance.
+func (i Instance) Post(ctx context.Context, query url.Values, data []byte, endpoint string, parts ...string) error {
+	return i.post(ctx, request{method: "POST", path: i.path(endpoint, parts...), query: query, body: data})
+}
+
+// PostStream streams data to any endpoint of the instance.  Unlike Post, the request
+// isn't retried since the data can't be sent again.
+func (i Instance) PostStream(ctx context.Context, query url.Values, data io.Reader, endpoint string, parts ...string) error {
+	return i.post(ctx, request{method: "POST", path: i.path(endpoint, parts...), query: query, stream: data})
+}
+
+func (i Instance) post(ctx context.Context, req request) error {
+	resp, err := i.c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return err
+}
+
+// ReadRange returns length bytes starting at offset of the response to a GET of an
+// endpoint, using an HTTP Range request so large downloads can be resumed or fetched in
+// parallel.  A negative length reads to the end.
+func (i Instance) ReadRange(ctx context.Context, offset, length int64, endpoint string, parts ...string) (io.ReadCloser, error) {
+	rangeSpec := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		rangeSpec = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	req := request{
+		method: "GET",
+		path:   i.path(endpoint, parts...),
+		header: http.Header{"Range": []string{rangeSpec}},
+	}
+	resp, err := i.c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		return resp.Body, nil
+	}
+	// The server sent the whole response, so skip to the range.
+	if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if length >= 0 {
+		return readCloser{io.LimitReader(resp.Body, length), resp.Body}, nil
+	}
+	return resp.Body, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// coord formats a coordinate as DVID expects in URLs, e.g., "10_20_30".
+func coord(c [3]int32) string {
+	s := make([]string, 3)
+	for i, v := range c {
+		s[i] = fmt.Sprintf("%d", v)
+	}
+	return strings.Join(s, "_")
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+)
+
+// GetKey returns the value of a key of a keyvalue instance.  Missing keys return an
+// error for which IsNotFound is true.
+func (i Instance) GetKey(ctx context.Context, key string) ([]byte, error) {
+	body, err := i.Get(ctx, nil, "key", key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// PutKey sets the value of a key of a keyvalue instance.  Setting a value again has the
+// same effect, so the request is retried after failures.
+func (i Instance) PutKey(ctx context.Context, key string, value []byte) error {
+	return i.Post(Idempotent(ctx), nil, value, "key", key)
+}
+
+// DeleteKey removes a key of a keyvalue instance.
+func (i Instance) DeleteKey(ctx context.Context, key string) error {
+	return i.post(ctx, request{method: "DELETE", path: i.path("key", key)})
+}
+
+// KeyPageSize is the number of keys requested per page when listing keys.
+var KeyPageSize = 10000
+
+// Keys calls fn with the keys of a keyvalue instance in order, optionally only those
+// with the given prefix, until fn returns false.  Keys are read a page at a time so
+// instances with huge numbers of keys can be listed.
+func (i Instance) Keys(ctx context.Context, prefix string, fn func(key string) bool) error {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	return i.keyPages(ctx, query, fn, "keys")
+}
+
+// KeyRange calls fn with the keys of a keyvalue instance from first to last inclusive,
+// in order, until fn returns false.
+func (i Instance) KeyRange(ctx context.Context, first, last string, fn func(key string) bool) error {
+	return i.keyPages(ctx, url.Values{}, fn, "keyrange", first, last)
+}
+
+func (i Instance) keyPages(ctx context.Context, query url.Values, fn func(key string) bool, endpoint string, parts ...string) error {
+	query.Set("limit", strconv.Itoa(KeyPageSize))
+	for {
+		resp, err := i.c.do(ctx, request{method: "GET", path: i.path(endpoint, parts...), query: query})
+		if err != nil {
+			return err
+		}
+		var keys []string
+		if err := decodeJSON(resp, &keys); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if !fn(key) {
+				return nil
+			}
+		}
+		cursor := resp.Header.Get("X-Next-Cursor")
+		if cursor == "" {
+			return nil
+		}
+		query.Set("cursor", cursor)
+	}
+}
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -53,6 +54,17 @@ var (
 
 	rpcAddress = flag.String("rpc", server.DefaultRPCAddress, "")
 
+	grpcAddress = flag.String("grpc", server.DefaultGRPCAddress, "")
+
+	// Use the legacy RPC connection for commands, e.g., with servers lacking gRPC.
+	useLegacyRPC = flag.Bool("legacyrpc", false, "")
+
+	// Return once a command is started without waiting for the jobs it starts.
+	detach = flag.Bool("detach", false, "")
+
+	// API token sent with commands, required for many commands if the server requires tokens.
+	apiToken = flag.String("token", os.Getenv("DVID_TOKEN"), "")
+
 	// msgAddress = flag.String("message", message.DefaultAddress, "")
 
 	// Profile CPU usage using standard gotest system.
@@ -75,7 +87,11 @@ dvid is a command-line interface to a distributed, versioned image-oriented data
 Usage: dvid [options] <command>
 
       -readonly   (flag)    HTTP API ignores anything but GET and HEAD requests.
-      -rpc        =string   Address for RPC communication.
+      -grpc       =string   Address for gRPC commands to a server.
+      -rpc        =string   Address for legacy RPC communication.
+      -legacyrpc  (flag)    Send commands over legacy RPC instead of gRPC.
+      -detach     (flag)    Don't wait for background jobs started by a command.
+      -token      =string   API token for commands, defaulting to $DVID_TOKEN.
       -cpuprofile =string   Write CPU profile to this file.
       -memprofile =string   Write memory profile to this file on ctrl-C.
       -numcpu     =number   Number of logical CPUs to use for DVID.
@@ -89,6 +105,10 @@ Commands that can be performed without a running server:
     about
     help
     serve  <configuration path>
+    validate <configuration path>
+
+        Checks the configuration without starting a server, listing all problems found,
+        e.g., unknown engines or stores, paths that can't be created, or ports in use.
 
 For storage engines that have repair ability (e.g., basholeveldb):
 
@@ -101,7 +121,10 @@ To get help for a remote DVID server:
 
     help server
 
-        Use the -rpc flag to set the remote DVID rpc port if not the default.
+        Use the -grpc flag to set the remote DVID gRPC port if not the default.
+
+Commands that start background jobs on the server, e.g., "backup", report the jobs'
+progress until they finish.  Ctrl-C cancels the jobs unless -detach is given.
 
 `
 
@@ -140,7 +163,7 @@ func main() {
 
 	if help && flag.NArg() == 2 && strings.ToLower(flag.Args()[0]) == "server" {
 		if err := DoCommand(dvid.Command([]string{"help"})); err != nil {
-			fmt.Printf("Unable to get 'help' from DVID server at %q.\n%v\n", rpcAddress, err)
+			fmt.Printf("Unable to get 'help' from DVID server at %q.\n%v\n", *grpcAddress, err)
 		}
 		os.Exit(0)
 	}
@@ -203,11 +226,13 @@ func DoCommand(cmd dvid.Command) error {
 		return DoServe(cmd)
 	case "repair":
 		return DoRepair(cmd)
+	case "validate":
+		return DoValidate(cmd)
 	case "about":
 		fmt.Println(server.About())
 	// Send everything else to server via DVID terminal
 	default:
-		request := datastore.Request{Command: cmd}
+		request := datastore.Request{Command: cmd, Token: *apiToken}
 		if *useStdin {
 			var err error
 			request.Input, err = ioutil.ReadAll(os.Stdin)
@@ -215,11 +240,33 @@ func DoCommand(cmd dvid.Command) error {
 				return fmt.Errorf("Error in reading from standard input: %v", err)
 			}
 		}
-		return server.SendRPC(*rpcAddress, request)
+		if *useLegacyRPC {
+			return server.SendRPC(*rpcAddress, request)
+		}
+		return sendCommand(request)
 	}
 	return nil
 }
 
+// sendCommand sends a request to the server's gRPC command service, cancelling it and
+// any jobs it started on an interrupt.
+func sendCommand(request datastore.Request) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			fmt.Fprintln(os.Stderr, "Cancelling command...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return server.SendCommand(ctx, *grpcAddress, request, *detach, os.Stdout)
+}
+
 // DoRepair performs the "repair" command, trying to repair a storage engine
 func DoRepair(cmd dvid.Command) error {
 	engineName := cmd.Argument(1)
@@ -234,6 +281,24 @@ func DoRepair(cmd dvid.Command) error {
 	return nil
 }
 
+// DoValidate performs the "validate" command, printing all problems in a configuration.
+func DoValidate(cmd dvid.Command) error {
+	configPath := cmd.Argument(1)
+	if configPath == "" {
+		return fmt.Errorf("validate command must be followed by the path to the TOML configuration file")
+	}
+	errs := server.ValidateConfig(configPath)
+	if len(errs) == 0 {
+		fmt.Printf("Configuration file %q is valid.\n", configPath)
+		return nil
+	}
+	fmt.Printf("Configuration file %q has %d problem(s):\n", configPath, len(errs))
+	for _, err := range errs {
+		fmt.Printf("  - %v\n", err)
+	}
+	return fmt.Errorf("invalid configuration file %q", configPath)
+}
+
 // DoServe opens a datastore then creates both web and rpc servers for the datastore
 func DoServe(cmd dvid.Command) error {
 	// Capture ctrl+c and other interrupts.  Then handle graceful shutdown.
@@ -261,6 +326,19 @@ func DoServe(cmd dvid.Command) error {
 	}()
 	signal.Notify(stopSig, os.Interrupt, os.Kill, syscall.SIGTERM)
 
+	// Reopen the log file on SIGHUP, e.g., after logrotate moves it.
+	hupSig := make(chan os.Signal, 1)
+	go func() {
+		for range hupSig {
+			if err := dvid.ReopenLog(); err != nil {
+				log.Printf("Unable to reopen log file: %v\n", err)
+			} else {
+				dvid.Infof("Reopened log file after SIGHUP.\n")
+			}
+		}
+	}()
+	signal.Notify(hupSig, syscall.SIGHUP)
+
 	// Load server configuration.
 	configPath := cmd.Argument(1)
 	if configPath == "" {
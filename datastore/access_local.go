@@ -0,0 +1,130 @@
+// +build !clustered,!gcloud
+
+/*
+	This file records when each version and data instance was last read and written, so
+	stale branches and unused data can be found.  Access times are kept in memory and
+	saved with the repo when it's next saved or every AccessSaveInterval.
+*/
+
+package datastore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// AccessSaveInterval is how often repos with newly recorded access times are saved.
+var AccessSaveInterval = 10 * time.Minute
+
+// accessTimes holds the last read and write times of a node or data instance.
+type accessTimes struct {
+	LastRead  time.Time
+	LastWrite time.Time
+}
+
+func (a accessTimes) lastRead() *time.Time {
+	if a.LastRead.IsZero() {
+		return nil
+	}
+	return &a.LastRead
+}
+
+func (a accessTimes) lastWrite() *time.Time {
+	if a.LastWrite.IsZero() {
+		return nil
+	}
+	return &a.LastWrite
+}
+
+func (a *accessTimes) record(t time.Time, write bool) {
+	if write {
+		a.LastWrite = t
+	} else {
+		a.LastRead = t
+	}
+}
+
+var startAccessSaves sync.Once
+
+// RecordAccess notes a read or write of the data instance in the version with the given
+// UUID.
+func RecordAccess(uuid dvid.UUID, data dvid.Data, write bool) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	startAccessSaves.Do(func() { go saveAccessPeriodically() })
+	return manager.recordAccess(uuid, data, write)
+}
+
+func (m *repoManager) recordAccess(uuid dvid.UUID, data dvid.Data, write bool) error {
+	v, err := m.versionFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	m.RLock()
+	r, found := m.repos[uuid]
+	m.RUnlock()
+	if !found || r.stub {
+		return ErrInvalidUUID
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+	node, found := r.dag.nodes[v]
+	if !found {
+		return ErrInvalidVersion
+	}
+	t := time.Now()
+	r.accessMu.Lock()
+	defer r.accessMu.Unlock()
+	node.access.record(t, write)
+	if r.dataAccess == nil {
+		r.dataAccess = make(map[dvid.UUID]*accessTimes)
+	}
+	access, found := r.dataAccess[data.DataUUID()]
+	if !found {
+		access = new(accessTimes)
+		r.dataAccess[data.DataUUID()] = access
+	}
+	access.record(t, write)
+	r.accessDirty = true
+	return nil
+}
+
+// saveAccessPeriodically saves repos with newly recorded access times every
+// AccessSaveInterval.
+func saveAccessPeriodically() {
+	for {
+		time.Sleep(AccessSaveInterval)
+		if m := manager; m != nil {
+			m.saveAccess()
+		}
+	}
+}
+
+func (m *repoManager) saveAccess() {
+	m.RLock()
+	repos := make([]*repoT, 0, len(m.repoToUUID))
+	for _, uuid := range m.repoToUUID {
+		if r, found := m.repos[uuid]; found && !r.stub {
+			repos = append(repos, r)
+		}
+	}
+	m.RUnlock()
+
+	for _, r := range repos {
+		r.Lock()
+		r.accessMu.Lock()
+		dirty := r.accessDirty
+		r.accessDirty = false
+		r.accessMu.Unlock()
+		if dirty {
+			if err := r.save(); err != nil {
+				dvid.Errorf("Unable to save access times of repo %s: %v\n", r.uuid, err)
+			}
+		}
+		r.Unlock()
+	}
+}
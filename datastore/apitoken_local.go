@@ -0,0 +1,292 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports server-managed API tokens, which are required on mutating requests
+	when the server enforces token authentication.  Only a hash of each token is persisted
+	in the metadata store, so a token can't be recovered after it's created.
+*/
+
+package datastore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Scopes that can be granted to API tokens.
+const (
+	// ScopeWrite allows modification of repos and data.
+	ScopeWrite = "write"
+
+	// ScopeAdmin allows server administration, e.g., managing API tokens, and includes
+	// all other scopes.
+	ScopeAdmin = "admin"
+
+	// ScopeRead allows reading repos in a private namespace.  It's only granted for a
+	// namespace, e.g., "read:lab1".
+	ScopeRead = "read"
+)
+
+// NamespaceScope returns a scope limited to the repos of a namespace, e.g., "write:lab1".
+// Scopes for a namespace include the lesser scopes for it, so "admin:lab1" includes
+// "write:lab1", which includes "read:lab1".
+func NamespaceScope(scope, namespace string) string {
+	return scope + ":" + namespace
+}
+
+// scopeRank orders scopes so each includes the ones ranked below it.
+var scopeRank = map[string]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// parseScope splits a scope into its unqualified scope and namespace, if any.
+func parseScope(s string) (scope, namespace string) {
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// ErrInvalidAPIToken is returned when a token is malformed, unknown, or revoked.
+var ErrInvalidAPIToken = errors.New("invalid API token")
+
+// APIToken describes an API token without its secret.
+type APIToken struct {
+	ID      string
+	Name    string
+	Scopes  []string
+	Created time.Time
+}
+
+// HasScope returns true if the token was granted the given scope.
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// HasNamespaceScope returns true if the token was granted the given scope, or a greater
+// one, for all repos or for the repos of the given namespace.
+func (t APIToken) HasNamespaceScope(scope, namespace string) bool {
+	for _, s := range t.Scopes {
+		granted, ns := parseScope(s)
+		if (ns == "" || ns == namespace) && scopeRank[granted] >= scopeRank[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAnyNamespaceScope returns true if the token was granted the given scope, or a greater
+// one, for any namespace.
+func (t APIToken) HasAnyNamespaceScope(scope string) bool {
+	for _, s := range t.Scopes {
+		granted, ns := parseScope(s)
+		if ns != "" && scopeRank[granted] >= scopeRank[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokenRecord is the persisted form of an API token.
+type apiTokenRecord struct {
+	APIToken
+	Hash []byte // SHA-256 of the full token
+}
+
+const (
+	apiTokenIDBytes     = 8
+	apiTokenSecretBytes = 24
+)
+
+// CreateAPIToken creates and persists a token with the given name and scopes, returning
+// the token, which must be passed on requests and can't be retrieved later.
+func CreateAPIToken(name string, scopes []string) (token string, info APIToken, err error) {
+	if manager == nil {
+		return "", APIToken{}, ErrManagerNotInitialized
+	}
+	return manager.createAPIToken(name, scopes)
+}
+
+// RevokeAPIToken deletes the token with the given ID.
+func RevokeAPIToken(id string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	return manager.revokeAPIToken(id)
+}
+
+// APITokens returns all tokens in order of creation.
+func APITokens() ([]APIToken, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	return manager.apiTokens(), nil
+}
+
+// HasAdminToken returns true if any token has the admin scope.
+func HasAdminToken() (bool, error) {
+	if manager == nil {
+		return false, ErrManagerNotInitialized
+	}
+	for _, t := range manager.apiTokens() {
+		if t.HasScope(ScopeAdmin) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CheckAPIToken returns the token's description if it's valid and has the given scope,
+// which isn't checked if empty.  ErrInvalidAPIToken is returned for unknown tokens.
+func CheckAPIToken(token, scope string) (APIToken, error) {
+	if manager == nil {
+		return APIToken{}, ErrManagerNotInitialized
+	}
+	t, err := manager.checkAPIToken(token)
+	if err != nil {
+		return APIToken{}, err
+	}
+	if scope != "" && !t.HasScope(scope) {
+		return t, fmt.Errorf("API token %s (%s) doesn't have %q scope", t.ID, t.Name, scope)
+	}
+	return t, nil
+}
+
+func checkScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("API tokens must have at least one scope")
+	}
+	for _, s := range scopes {
+		scope, namespace := parseScope(s)
+		if namespace != "" {
+			if !namespaceName.MatchString(namespace) || scopeRank[scope] == 0 {
+				return fmt.Errorf("bad API token scope %q, must be %q, %q, or %q for a namespace", s,
+					NamespaceScope(ScopeRead, namespace), NamespaceScope(ScopeWrite, namespace), NamespaceScope(ScopeAdmin, namespace))
+			}
+			continue
+		}
+		if scope != ScopeWrite && scope != ScopeAdmin {
+			return fmt.Errorf("unknown API token scope %q, must be %q, %q, or one limited to a namespace, e.g., %q",
+				s, ScopeWrite, ScopeAdmin, NamespaceScope(ScopeWrite, "lab1"))
+		}
+	}
+	return nil
+}
+
+func hashAPIToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+func (m *repoManager) createAPIToken(name string, scopes []string) (string, APIToken, error) {
+	if name == "" {
+		return "", APIToken{}, fmt.Errorf("API tokens must have a name")
+	}
+	if err := checkScopes(scopes); err != nil {
+		return "", APIToken{}, err
+	}
+	random := make([]byte, apiTokenIDBytes+apiTokenSecretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", APIToken{}, fmt.Errorf("unable to generate API token: %v", err)
+	}
+	id := hex.EncodeToString(random[:apiTokenIDBytes])
+	token := id + "." + hex.EncodeToString(random[apiTokenIDBytes:])
+	record := &apiTokenRecord{
+		APIToken: APIToken{
+			ID:      id,
+			Name:    name,
+			Scopes:  append([]string{}, scopes...),
+			Created: time.Now(),
+		},
+		Hash: hashAPIToken(token),
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+
+	m.tokenMutex.Lock()
+	defer m.tokenMutex.Unlock()
+	var ctx storage.MetadataContext
+	if err := m.store.Put(ctx, storage.NewTKey(apiTokenKey, []byte(id)), value); err != nil {
+		return "", APIToken{}, err
+	}
+	if m.tokens == nil {
+		m.tokens = make(map[string]*apiTokenRecord)
+	}
+	m.tokens[id] = record
+	return token, record.APIToken, nil
+}
+
+func (m *repoManager) revokeAPIToken(id string) error {
+	m.tokenMutex.Lock()
+	defer m.tokenMutex.Unlock()
+	if _, found := m.tokens[id]; !found {
+		return fmt.Errorf("no API token with id %q", id)
+	}
+	var ctx storage.MetadataContext
+	if err := m.store.Delete(ctx, storage.NewTKey(apiTokenKey, []byte(id))); err != nil {
+		return err
+	}
+	delete(m.tokens, id)
+	return nil
+}
+
+func (m *repoManager) apiTokens() []APIToken {
+	m.tokenMutex.RLock()
+	tokens := make([]APIToken, 0, len(m.tokens))
+	for _, record := range m.tokens {
+		tokens = append(tokens, record.APIToken)
+	}
+	m.tokenMutex.RUnlock()
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Created.Before(tokens[j].Created) })
+	return tokens
+}
+
+func (m *repoManager) checkAPIToken(token string) (APIToken, error) {
+	pos := strings.Index(token, ".")
+	if pos < 0 {
+		return APIToken{}, ErrInvalidAPIToken
+	}
+	m.tokenMutex.RLock()
+	record, found := m.tokens[token[:pos]]
+	m.tokenMutex.RUnlock()
+	if !found || subtle.ConstantTimeCompare(record.Hash, hashAPIToken(token)) != 1 {
+		return APIToken{}, ErrInvalidAPIToken
+	}
+	return record.APIToken, nil
+}
+
+// loadAPITokens loads all persisted tokens.
+func (m *repoManager) loadAPITokens() error {
+	var ctx storage.MetadataContext
+	kvs, err := m.store.GetRange(ctx, storage.MinTKey(apiTokenKey), storage.MaxTKey(apiTokenKey))
+	if err != nil {
+		return err
+	}
+	tokens := make(map[string]*apiTokenRecord, len(kvs))
+	for _, kv := range kvs {
+		record := new(apiTokenRecord)
+		if err := json.Unmarshal(kv.V, record); err != nil {
+			return fmt.Errorf("bad API token in metadata: %v", err)
+		}
+		tokens[record.ID] = record
+	}
+	m.tokenMutex.Lock()
+	m.tokens = tokens
+	m.tokenMutex.Unlock()
+	return nil
+}
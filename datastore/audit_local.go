@@ -0,0 +1,139 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports the audit log, an append-only record of mutating requests kept in
+	the metadata store for groups subject to data-governance rules.  Records are keyed by
+	an ID that increases with the time of the request, so they can be queried by time
+	range and paged through by ID.  There is no way to change or remove records through
+	DVID.
+*/
+
+package datastore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// AuditRecord describes a mutating HTTP request or command.
+type AuditRecord struct {
+	ID       uint64
+	Time     time.Time
+	User     string            `json:",omitempty"` // principal of the request, if authenticated
+	Method   string            // HTTP method or "RPC" for commands
+	Route    string            // URL path or command, e.g., "repos new"
+	Repo     dvid.UUID         `json:",omitempty"` // root UUID of the repo
+	UUID     dvid.UUID         `json:",omitempty"` // version node
+	Instance dvid.InstanceName `json:",omitempty"`
+	Digest   string            `json:",omitempty"` // "sha256:<hex>" of the payload
+	Bytes    int64             // payload bytes
+	Status   int               // HTTP status; for commands, 200 or 400 if it failed
+	Error    string            `json:",omitempty"` // error of a failed command or rolled back request
+}
+
+// AuditQuery selects audit records.  Zero values don't restrict the records.
+type AuditQuery struct {
+	After    uint64 // only records with larger IDs, e.g., the last ID of a previous page
+	Since    time.Time
+	Until    time.Time
+	User     string
+	Repo     dvid.UUID // root UUID
+	Instance dvid.InstanceName
+	Limit    int
+}
+
+func (q AuditQuery) matches(rec AuditRecord) bool {
+	return (q.User == "" || rec.User == q.User) &&
+		(q.Repo == "" || rec.Repo == q.Repo) &&
+		(q.Instance == "" || rec.Instance == q.Instance)
+}
+
+var auditIDs struct {
+	sync.Mutex
+	last uint64
+}
+
+// nextAuditID returns an ID for a record at the given time, which is the time in
+// nanoseconds unless records in the same nanosecond or a clock step back require a later
+// ID.
+func nextAuditID(t time.Time) uint64 {
+	auditIDs.Lock()
+	defer auditIDs.Unlock()
+	id := uint64(t.UnixNano())
+	if id <= auditIDs.last {
+		id = auditIDs.last + 1
+	}
+	auditIDs.last = id
+	return id
+}
+
+func auditTKey(id uint64) storage.TKey {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return storage.NewTKey(auditKey, b)
+}
+
+// RecordAudit appends a record to the audit log, setting its ID and, if not given, its
+// time.
+func RecordAudit(rec AuditRecord) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	rec.ID = nextAuditID(rec.Time)
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	var ctx storage.MetadataContext
+	return manager.store.Put(ctx, auditTKey(rec.ID), value)
+}
+
+// errAuditPageFull stops the scan of audit records once a query's limit is reached.
+var errAuditPageFull = errors.New("audit page full")
+
+// QueryAudit returns the audit records selected by the query in order of ID.
+func QueryAudit(q AuditQuery) ([]AuditRecord, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	begin, end := q.After+1, uint64(1<<64-1)
+	if !q.Since.IsZero() && uint64(q.Since.UnixNano()) > begin {
+		begin = uint64(q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		end = uint64(q.Until.UnixNano())
+	}
+	records := []AuditRecord{}
+	if begin > end {
+		return records, nil
+	}
+	var ctx storage.MetadataContext
+	err := manager.store.ProcessRange(ctx, auditTKey(begin), auditTKey(end), nil, func(c *storage.Chunk) error {
+		var rec AuditRecord
+		if err := json.Unmarshal(c.V, &rec); err != nil {
+			return fmt.Errorf("bad audit record in metadata: %v", err)
+		}
+		if !q.matches(rec) {
+			return nil
+		}
+		records = append(records, rec)
+		if q.Limit > 0 && len(records) >= q.Limit {
+			return errAuditPageFull
+		}
+		return nil
+	})
+	if err != nil && err != errAuditPageFull {
+		return nil, err
+	}
+	return records, nil
+}
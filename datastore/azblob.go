@@ -0,0 +1,5 @@
+// +build azblob
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/azblob"
@@ -0,0 +1,854 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports full backup and restore of a datastore, i.e., all repo metadata and
+	the key-value pairs of all data instances, to and from a portable archive.
+
+	An archive is a directory holding a JSON manifest and one file per segment, where a
+	segment is either the metadata or the key-value pairs of one data instance.  Each
+	segment file is a sequence of records: uvarint key length, key, uvarint value length,
+	value.  Since the keys are independent of the storage engine, an archive can be
+	restored to a server with different stores.
+
+	If mutations are tracked, a backup records the high-water mutation sequence number,
+	and an incremental backup since that number holds the metadata and, for each tracked
+	store, a "changes" segment of records that each begin with an op byte: a range
+	deletion followed by the begin and end keys, or a put followed by key and value.
+	Incremental archives must be restored in order on top of the archive they follow.  A
+	chain of archives can be restored up to a mutation sequence number or time, recovering
+	the datastore as of the last backup before that point.
+*/
+
+package datastore
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+const (
+	backupFormatVersion = 1
+
+	backupManifestFile  = "manifest.json"
+	restoreProgressFile = "restore.json"
+	backupMetadataFile  = "metadata.kv"
+
+	// ops for records in changes segments of incremental backups.
+	backupOpDeleteRange byte = 'D'
+	backupOpPut         byte = 'P'
+
+	// progress is checkpointed after this many bytes of key-value pairs.
+	backupCheckpointBytes = 64 << 20
+)
+
+type backupManifest struct {
+	Version   int
+	Started   time.Time
+	Finished  time.Time // zero if the backup is incomplete.
+	Since     uint64    // non-zero for incremental backups of mutations after Since.
+	HighWater uint64    // all mutations up to this sequence number are captured.
+	Segments  []*backupSegment
+}
+
+type backupSegment struct {
+	File     string
+	Instance dvid.InstanceID // zero for metadata.
+	DataName dvid.InstanceName
+	DataUUID dvid.UUID
+	Changes  bool // true for changes segments of incremental backups.
+	Done     bool
+	LastKey  storage.Key // last key written before Offset, used to resume.
+	LastSeq  uint64      // last mutation written before Offset for changes segments.
+	Offset   int64
+	NumKV    uint64
+}
+
+func readJSONFile(path string, v interface{}) (found bool, err error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("bad JSON in %q: %v", path, err)
+	}
+	return true, nil
+}
+
+// writeJSONFile atomically replaces the file so an interruption leaves the old version.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (m *backupManifest) segment(file string) *backupSegment {
+	for _, seg := range m.Segments {
+		if seg.File == file {
+			return seg
+		}
+	}
+	seg := &backupSegment{File: file}
+	m.Segments = append(m.Segments, seg)
+	return seg
+}
+
+// Backup streams all repo metadata and data instance key-value pairs into an archive in
+// the target directory.  It can run while the server is live, although writes during the
+// backup may or may not be captured.  If interrupted, calling Backup with the same target
+// resumes from the last checkpoint.
+func Backup(target string) error {
+	return backup(context.Background(), target, func(string) {})
+}
+
+// BackupJob runs a backup as a background job and returns the job ID.  If since is
+// non-zero, it's an incremental backup as with BackupSince.  Cancelling the job stops
+// the backup before the next data instance or store, and the backup can be resumed
+// later like an interrupted one.
+func BackupJob(target string, since uint64) uint64 {
+	kind := "backup"
+	if since != 0 {
+		kind = fmt.Sprintf("backup since mutation %d", since)
+	}
+	return RunJob(kind, target, func(ctx context.Context, step func(string)) error {
+		if since == 0 {
+			return backup(ctx, target, step)
+		}
+		return backupSince(ctx, target, since, step)
+	})
+}
+
+func backup(ctx context.Context, target string, step func(string)) error {
+	step("writing metadata")
+	manifest, err := startBackup(target, 0)
+	if err != nil {
+		return err
+	}
+	timedLog := dvid.NewTimeLog()
+
+	instances, err := backupMetadata(target, manifest)
+	if err != nil {
+		return err
+	}
+	for _, d := range instances {
+		seg := manifest.segment(fmt.Sprintf("data-%s.kv", d.DataUUID()))
+		if seg.Done {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ErrJobCancelled
+		}
+		step(fmt.Sprintf("backing up data %q (instance %d)", d.DataName(), d.InstanceID()))
+		seg.Instance = d.InstanceID()
+		seg.DataName = d.DataName()
+		seg.DataUUID = d.DataUUID()
+
+		storer, ok := d.(storage.Accessor)
+		if !ok {
+			return fmt.Errorf("unable to backup data %q: unable to access backing store", d.DataName())
+		}
+		db, err := storer.GetOrderedKeyValueDB()
+		if err != nil {
+			return fmt.Errorf("unable to get backing store for data %q: %v", d.DataName(), err)
+		}
+		dataCtx := storage.NewDataContext(d, 0)
+		begKey, endKey := dataCtx.KeyRange()
+		if seg.LastKey != nil {
+			begKey = append(append(storage.Key{}, seg.LastKey...), 0)
+		}
+		if err := backupSegmentKVs(target, manifest, seg, db, begKey, endKey); err != nil {
+			return fmt.Errorf("backup of data %q failed: %v", d.DataName(), err)
+		}
+		dvid.Infof("Backed up %d key-value pairs of data %q\n", seg.NumKV, d.DataName())
+	}
+
+	if err := finishBackup(target, manifest); err != nil {
+		return err
+	}
+	timedLog.Infof("Completed backup of %d data instances to %q", len(instances), target)
+	return nil
+}
+
+// BackupSince streams all repo metadata and the key-value pairs changed by mutations
+// after the given sequence number, which is the high-water mark recorded in the manifest
+// of a previous backup.  Mutations must be tracked by the storage configuration.  If
+// interrupted, calling BackupSince with the same target resumes from the last checkpoint.
+func BackupSince(target string, since uint64) error {
+	return backupSince(context.Background(), target, since, func(string) {})
+}
+
+func backupSince(ctx context.Context, target string, since uint64, step func(string)) error {
+	if since == 0 {
+		return fmt.Errorf("incremental backup requires a mutation sequence number")
+	}
+	stores := storage.MutationStores()
+	if len(stores) == 0 {
+		return fmt.Errorf("incremental backup requires mutation tracking to be enabled")
+	}
+	manifest, err := startBackup(target, since)
+	if err != nil {
+		return err
+	}
+	if manifest.HighWater < since {
+		return fmt.Errorf("mutation %d is after the current high-water mark %d", since, manifest.HighWater)
+	}
+	timedLog := dvid.NewTimeLog()
+
+	step("writing metadata")
+	if _, err := backupMetadata(target, manifest); err != nil {
+		return err
+	}
+	var numKV uint64
+	for alias, db := range stores {
+		seg := manifest.segment(fmt.Sprintf("changes-%s.kv", alias))
+		seg.Changes = true
+		if !seg.Done {
+			if ctx.Err() != nil {
+				return ErrJobCancelled
+			}
+			step(fmt.Sprintf("backing up changes in store %q", alias))
+			if err := backupChanges(target, manifest, seg, db); err != nil {
+				return fmt.Errorf("backup of changes in store %q failed: %v", alias, err)
+			}
+		}
+		numKV += seg.NumKV
+	}
+
+	if err := finishBackup(target, manifest); err != nil {
+		return err
+	}
+	timedLog.Infof("Completed backup of %d changed key-value pairs for mutations %d to %d to %q",
+		numKV, since+1, manifest.HighWater, target)
+	return nil
+}
+
+// startBackup returns the manifest for a new backup or one being resumed.
+func startBackup(target string, since uint64) (*backupManifest, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, err
+	}
+	manifest := new(backupManifest)
+	found, err := readJSONFile(filepath.Join(target, backupManifestFile), manifest)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		// Mutations after the high-water mark may or may not be captured, so the next
+		// incremental backup starts after it.
+		*manifest = backupManifest{
+			Version:   backupFormatVersion,
+			Started:   time.Now(),
+			Since:     since,
+			HighWater: storage.MutationHighWater(),
+		}
+		return manifest, nil
+	}
+	if manifest.Version != backupFormatVersion {
+		return nil, fmt.Errorf("backup in %q has format version %d, expected %d", target, manifest.Version, backupFormatVersion)
+	}
+	if !manifest.Finished.IsZero() {
+		return nil, fmt.Errorf("backup in %q was already completed at %s", target, manifest.Finished)
+	}
+	if manifest.Since != since {
+		return nil, fmt.Errorf("backup in %q is of mutations since %d, not %d", target, manifest.Since, since)
+	}
+	dvid.Infof("Resuming backup to %q started at %s\n", target, manifest.Started)
+	return manifest, nil
+}
+
+func finishBackup(target string, manifest *backupManifest) error {
+	manifest.Finished = time.Now()
+	return writeJSONFile(filepath.Join(target, backupManifestFile), manifest)
+}
+
+// backupMetadata writes the metadata segment and returns the data instances it describes.
+// Metadata is small and always rewritten so it covers instances added since any
+// interrupted backup.
+func backupMetadata(target string, manifest *backupManifest) ([]DataService, error) {
+	// Hold the manager lock so no repos or instances are added while metadata is read.
+	manager.RLock()
+	defer manager.RUnlock()
+	seg := manifest.segment(backupMetadataFile)
+	*seg = backupSegment{File: backupMetadataFile}
+	metaBeg := storage.Key{0}
+	metaEnd := storage.Key{1}
+	if err := backupSegmentKVs(target, manifest, seg, manager.store, metaBeg, metaEnd); err != nil {
+		return nil, fmt.Errorf("backup of metadata failed: %v", err)
+	}
+	var instances []DataService
+	for _, d := range manager.iids {
+		instances = append(instances, d)
+	}
+	return instances, nil
+}
+
+// rangeKVs calls f for each key-value pair in the inclusive key range.
+func rangeKVs(db storage.OrderedKeyValueDB, begKey, endKey storage.Key, keysOnly bool, f func(*storage.KeyValue) error) error {
+	ch := make(chan *storage.KeyValue, 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queryErr := make(chan error, 1)
+	go func() {
+		queryErr <- db.RawRangeQuery(ctx, begKey, endKey, keysOnly, ch)
+		close(ch)
+	}()
+
+	var ferr error
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		if ferr = f(kv); ferr != nil {
+			break
+		}
+	}
+	if ferr != nil {
+		cancel()
+		for range ch {
+		}
+		return ferr
+	}
+	return <-queryErr
+}
+
+// backupWriter appends records to a segment file and checkpoints progress in the manifest.
+type backupWriter struct {
+	f            *os.File
+	w            *bufio.Writer
+	manifestPath string
+	manifest     *backupManifest
+	lenBuf       []byte
+}
+
+// newBackupWriter opens the segment's file, discarding anything written after the last
+// checkpoint.
+func newBackupWriter(dir string, manifest *backupManifest, seg *backupSegment) (*backupWriter, error) {
+	f, err := os.OpenFile(filepath.Join(dir, seg.File), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(seg.Offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(seg.Offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &backupWriter{
+		f:            f,
+		w:            bufio.NewWriterSize(f, 1<<20),
+		manifestPath: filepath.Join(dir, backupManifestFile),
+		manifest:     manifest,
+		lenBuf:       make([]byte, binary.MaxVarintLen64),
+	}, nil
+}
+
+// write appends length-prefixed byte slices and returns the number of bytes written.
+func (bw *backupWriter) write(bs ...[]byte) (int, error) {
+	var n int
+	for _, b := range bs {
+		nlen := binary.PutUvarint(bw.lenBuf, uint64(len(b)))
+		if _, err := bw.w.Write(bw.lenBuf[:nlen]); err != nil {
+			return n, err
+		}
+		if _, err := bw.w.Write(b); err != nil {
+			return n, err
+		}
+		n += nlen + len(b)
+	}
+	return n, nil
+}
+
+func (bw *backupWriter) writeOp(op byte, a, b []byte) (int, error) {
+	if err := bw.w.WriteByte(op); err != nil {
+		return 0, err
+	}
+	n, err := bw.write(a, b)
+	return n + 1, err
+}
+
+func (bw *backupWriter) checkpoint() error {
+	if err := bw.w.Flush(); err != nil {
+		return err
+	}
+	if err := bw.f.Sync(); err != nil {
+		return err
+	}
+	return writeJSONFile(bw.manifestPath, bw.manifest)
+}
+
+func (bw *backupWriter) Close() error {
+	return bw.f.Close()
+}
+
+// backupSegmentKVs appends all key-value pairs in the key range to the segment's file,
+// periodically checkpointing progress in the manifest.
+func backupSegmentKVs(dir string, manifest *backupManifest, seg *backupSegment, db storage.OrderedKeyValueDB, begKey, endKey storage.Key) error {
+	bw, err := newBackupWriter(dir, manifest, seg)
+	if err != nil {
+		return err
+	}
+	defer bw.Close()
+
+	var unsaved int64
+	err = rangeKVs(db, begKey, endKey, false, func(kv *storage.KeyValue) error {
+		n, err := bw.write(kv.K, kv.V)
+		if err != nil {
+			return err
+		}
+		seg.Offset += int64(n)
+		seg.LastKey = kv.K
+		seg.NumKV++
+		if unsaved += int64(n); unsaved >= backupCheckpointBytes {
+			unsaved = 0
+			return bw.checkpoint()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	seg.Done = true
+	return bw.checkpoint()
+}
+
+// backupChanges appends to the segment's file, for each key range changed by mutations
+// indexed in the store, a deletion of the range followed by the range's current key-value
+// pairs.  Progress is checkpointed only between mutations so a resumed backup restarts
+// with the mutation after the last one checkpointed.
+func backupChanges(dir string, manifest *backupManifest, seg *backupSegment, db storage.OrderedKeyValueDB) error {
+	bw, err := newBackupWriter(dir, manifest, seg)
+	if err != nil {
+		return err
+	}
+	defer bw.Close()
+
+	if seg.LastSeq < manifest.Since {
+		seg.LastSeq = manifest.Since
+	}
+	written := make(map[string]struct{}) // ranges already in this segment
+	var unsaved int64
+	err = storage.ReadMutations(db, seg.LastSeq, manifest.HighWater, func(seq uint64, ranges []storage.MutationRange) error {
+		for _, r := range ranges {
+			id := string(r.Beg) + "\x00" + string(r.End)
+			if _, found := written[id]; found {
+				continue
+			}
+			written[id] = struct{}{}
+			n, err := bw.writeOp(backupOpDeleteRange, r.Beg, r.End)
+			if err != nil {
+				return err
+			}
+			seg.Offset += int64(n)
+			unsaved += int64(n)
+			err = rangeKVs(db, r.Beg, r.End, false, func(kv *storage.KeyValue) error {
+				n, err := bw.writeOp(backupOpPut, kv.K, kv.V)
+				if err != nil {
+					return err
+				}
+				seg.Offset += int64(n)
+				seg.NumKV++
+				unsaved += int64(n)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		seg.LastSeq = seq
+		if unsaved >= backupCheckpointBytes {
+			unsaved = 0
+			return bw.checkpoint()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	seg.Done = true
+	return bw.checkpoint()
+}
+
+type restoreProgress struct {
+	Done   map[string]bool // segment files that have been restored.
+	File   string          // segment file being restored.
+	Offset int64           // bytes of File that have been restored.
+}
+
+// Restore loads an archive written by Backup into this datastore, which must be empty
+// of repos unless a previous restore from the same archive was interrupted, in which
+// case the restore resumes.  An incremental archive written by BackupSince instead
+// requires a datastore restored from the archives preceding it, and replaces all
+// metadata and the changed key ranges.  The server should be restarted after a restore
+// so all data instances are initialized from the restored metadata.
+func Restore(source string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	manifest, err := readBackupManifest(source)
+	if err != nil {
+		return err
+	}
+
+	progressPath := filepath.Join(source, restoreProgressFile)
+	var progress restoreProgress
+	found, err := readJSONFile(progressPath, &progress)
+	if err != nil {
+		return err
+	}
+	if !found {
+		manager.RLock()
+		numRepos := len(manager.repos)
+		manager.RUnlock()
+		if manifest.Since == 0 && numRepos != 0 {
+			return fmt.Errorf("restore requires a datastore without repos, found %d repos", numRepos)
+		}
+		if manifest.Since != 0 && numRepos == 0 {
+			return fmt.Errorf("restore of changes since mutation %d requires a previously restored datastore", manifest.Since)
+		}
+		progress.Done = make(map[string]bool)
+	}
+	timedLog := dvid.NewTimeLog()
+
+	for _, seg := range manifest.Segments {
+		if progress.Done[seg.File] {
+			continue
+		}
+		if progress.File != seg.File {
+			progress.File = seg.File
+			progress.Offset = 0
+		}
+		switch {
+		case seg.Changes:
+			err = restoreChanges(source, seg, &progress, progressPath)
+		case seg.Instance == 0:
+			if manifest.Since != 0 && progress.Offset == 0 {
+				if err = deleteMetadata(); err != nil {
+					break
+				}
+			}
+			err = restoreSegmentKVs(source, seg, manager.store, &progress, progressPath)
+		default:
+			var db storage.OrderedKeyValueDB
+			if db, err = dataStore(seg.DataUUID, seg.DataName); err == nil {
+				err = restoreSegmentKVs(source, seg, db, &progress, progressPath)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("restore of %q failed: %v", seg.File, err)
+		}
+		progress.Done[seg.File] = true
+		progress.File = ""
+		progress.Offset = 0
+		if err := writeJSONFile(progressPath, &progress); err != nil {
+			return err
+		}
+
+		// Data instances must be known before their key-value pairs are restored.
+		if seg.Instance == 0 && !seg.Changes {
+			if err := ReloadMetadata(); err != nil {
+				return fmt.Errorf("unable to load restored metadata: %v", err)
+			}
+		}
+	}
+	timedLog.Infof("Restored %d segments from backup %q", len(manifest.Segments), source)
+	return nil
+}
+
+// readBackupManifest returns the manifest of a completed backup.
+func readBackupManifest(source string) (*backupManifest, error) {
+	manifest := new(backupManifest)
+	found, err := readJSONFile(filepath.Join(source, backupManifestFile), manifest)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("no backup manifest found in %q", source)
+	}
+	if manifest.Version != backupFormatVersion {
+		return nil, fmt.Errorf("backup in %q has format version %d, expected %d", source, manifest.Version, backupFormatVersion)
+	}
+	if manifest.Finished.IsZero() {
+		return nil, fmt.Errorf("backup in %q is incomplete", source)
+	}
+	return manifest, nil
+}
+
+// RestorePoint limits a point-in-time restore.  Zero fields impose no limit.
+type RestorePoint struct {
+	Mutation uint64    // no mutations after this sequence number are restored.
+	Time     time.Time // no writes after this time are restored.
+}
+
+// includes returns true if all writes captured by the backup are within the point.  Writes
+// during a backup may or may not be captured, so a backup includes all mutations up to
+// its HighWater and possibly later ones made before it finished.
+func (p RestorePoint) includes(m *backupManifest) bool {
+	if p.Mutation != 0 && m.HighWater > p.Mutation {
+		return false
+	}
+	if !p.Time.IsZero() && m.Finished.After(p.Time) {
+		return false
+	}
+	return true
+}
+
+// RestoreTo replays a chain of archives into this datastore up to the given point, e.g.,
+// to recover from an operator error by restoring into a fresh datastore all mutations
+// before the error.  The chain is a full backup followed by incremental backups, each
+// made since the HighWater of the one before it.  Since the mutation index records which
+// keys changed but not their values, the recovery points are the ends of backups: all
+// archives within the point are restored in order and the rest are skipped.  If
+// interrupted, calling RestoreTo with the same archives resumes the restore.
+func RestoreTo(sources []string, point RestorePoint) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("restore requires at least one archive")
+	}
+	var restored []string
+	var last *backupManifest
+	for i, source := range sources {
+		manifest, err := readBackupManifest(source)
+		if err != nil {
+			return err
+		}
+		switch {
+		case i == 0 && manifest.Since != 0:
+			return fmt.Errorf("first archive %q is an incremental backup since mutation %d, not a full backup", source, manifest.Since)
+		case i != 0 && manifest.Since == 0:
+			return fmt.Errorf("archive %q is a full backup, not an incremental backup following %q", source, sources[i-1])
+		case i != 0 && manifest.Since != last.HighWater:
+			return fmt.Errorf("archive %q is of mutations since %d, not since the high-water mark %d of %q",
+				source, manifest.Since, last.HighWater, sources[i-1])
+		}
+		if !point.includes(manifest) {
+			if i == 0 {
+				return fmt.Errorf("full backup %q with high-water mark %d finished at %s is after the restore point",
+					source, manifest.HighWater, manifest.Finished)
+			}
+			break
+		}
+		restored = append(restored, source)
+		last = manifest
+	}
+	for _, source := range restored {
+		dvid.Infof("Restoring archive %q...\n", source)
+		if err := Restore(source); err != nil {
+			return err
+		}
+	}
+	dvid.Infof("Restored %d of %d archives through mutation %d, backed up at %s\n",
+		len(restored), len(sources), last.HighWater, last.Finished)
+	return nil
+}
+
+func dataStore(dataUUID dvid.UUID, name dvid.InstanceName) (storage.OrderedKeyValueDB, error) {
+	d, err := GetDataByDataUUID(dataUUID)
+	if err != nil {
+		return nil, fmt.Errorf("restored metadata has no data %q (%s): %v", name, dataUUID, err)
+	}
+	storer, ok := d.(storage.Accessor)
+	if !ok {
+		return nil, fmt.Errorf("unable to restore data %q: unable to access backing store", name)
+	}
+	db, err := storer.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get backing store for data %q: %v", name, err)
+	}
+	return db, nil
+}
+
+// deleteMetadata removes all metadata so it can be replaced by restored metadata.
+func deleteMetadata() error {
+	var keys []storage.Key
+	err := rangeKVs(manager.store, storage.Key{0}, storage.Key{1}, true, func(kv *storage.KeyValue) error {
+		keys = append(keys, kv.K)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := manager.store.RawDelete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupReader reads records from a segment file, tracking the bytes read.
+type backupReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+func (br *backupReader) read() ([]byte, error) {
+	size, err := binary.ReadUvarint(br.r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(br.r, b); err != nil {
+		return nil, err
+	}
+	br.offset += int64(binary.PutUvarint(make([]byte, binary.MaxVarintLen64), size)) + int64(size)
+	return b, nil
+}
+
+func openBackupReader(dir string, seg *backupSegment, offset int64) (*os.File, *backupReader, error) {
+	f, err := os.Open(filepath.Join(dir, seg.File))
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, &backupReader{r: bufio.NewReaderSize(f, 1<<20), offset: offset}, nil
+}
+
+func restoreSegmentKVs(dir string, seg *backupSegment, db storage.OrderedKeyValueDB, progress *restoreProgress, progressPath string) error {
+	f, br, err := openBackupReader(dir, seg, progress.Offset)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var unsaved int64
+	for progress.Offset < seg.Offset {
+		k, err := br.read()
+		if err != nil {
+			return fmt.Errorf("bad key at offset %d: %v", progress.Offset, err)
+		}
+		v, err := br.read()
+		if err != nil {
+			return fmt.Errorf("bad value at offset %d: %v", progress.Offset, err)
+		}
+		if err := db.RawPut(k, v); err != nil {
+			return err
+		}
+		unsaved += br.offset - progress.Offset
+		progress.Offset = br.offset
+		if unsaved >= backupCheckpointBytes {
+			if err := writeJSONFile(progressPath, progress); err != nil {
+				return err
+			}
+			unsaved = 0
+		}
+	}
+	return nil
+}
+
+// restoreChanges applies the range deletions and puts of a changes segment.  Records are
+// idempotent, so an interrupted restore can resume at any record.
+func restoreChanges(dir string, seg *backupSegment, progress *restoreProgress, progressPath string) error {
+	f, br, err := openBackupReader(dir, seg, progress.Offset)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Data keys start with a 0x01 prefix followed by the instance ID, which gives the store.
+	stores := make(map[dvid.InstanceID]storage.OrderedKeyValueDB)
+	storeForKey := func(k storage.Key) (storage.OrderedKeyValueDB, error) {
+		if len(k) < 1+dvid.InstanceIDSize || k[0] != 1 {
+			return nil, fmt.Errorf("bad data key %v", k)
+		}
+		id := dvid.InstanceIDFromBytes(k[1 : 1+dvid.InstanceIDSize])
+		if db, found := stores[id]; found {
+			return db, nil
+		}
+		manager.RLock()
+		d, found := manager.iids[id]
+		manager.RUnlock()
+		if !found {
+			dvid.Infof("Skipping restored changes to deleted instance %d\n", id)
+			stores[id] = nil
+			return nil, nil
+		}
+		db, err := dataStore(d.DataUUID(), d.DataName())
+		if err != nil {
+			return nil, err
+		}
+		stores[id] = db
+		return db, nil
+	}
+
+	var unsaved int64
+	for progress.Offset < seg.Offset {
+		op, err := br.r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("bad record at offset %d: %v", progress.Offset, err)
+		}
+		br.offset++
+		a, err := br.read()
+		if err != nil {
+			return fmt.Errorf("bad key at offset %d: %v", progress.Offset, err)
+		}
+		b, err := br.read()
+		if err != nil {
+			return fmt.Errorf("bad record at offset %d: %v", progress.Offset, err)
+		}
+		db, err := storeForKey(a)
+		if err != nil {
+			return err
+		}
+		if db != nil {
+			switch op {
+			case backupOpDeleteRange:
+				var keys []storage.Key
+				err = rangeKVs(db, a, b, true, func(kv *storage.KeyValue) error {
+					keys = append(keys, kv.K)
+					return nil
+				})
+				for _, k := range keys {
+					if err != nil {
+						break
+					}
+					err = db.RawDelete(k)
+				}
+			case backupOpPut:
+				err = db.RawPut(a, b)
+			default:
+				err = fmt.Errorf("bad record op %q at offset %d", op, progress.Offset)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		unsaved += br.offset - progress.Offset
+		progress.Offset = br.offset
+		if unsaved >= backupCheckpointBytes {
+			if err := writeJSONFile(progressPath, progress); err != nil {
+				return err
+			}
+			unsaved = 0
+		}
+	}
+	return nil
+}
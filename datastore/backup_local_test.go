@@ -0,0 +1,156 @@
+// +build !clustered,!gcloud
+
+package datastore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func TestBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dvid-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	OpenTest()
+	uuid, v := NewTestRepo()
+	d, err := NewData(uuid, &TestType{}, "backedup", dvid.NewConfig())
+	if err != nil {
+		CloseTest()
+		t.Fatal(err)
+	}
+	db, err := d.(*TestData).GetOrderedKeyValueDB()
+	if err != nil {
+		CloseTest()
+		t.Fatal(err)
+	}
+	ctx := storage.NewDataContext(d, v)
+	for _, s := range []string{"a", "b", "c"} {
+		if err := db.Put(ctx, storage.NewTKey(1, []byte(s)), []byte("value "+s)); err != nil {
+			CloseTest()
+			t.Fatalf("error on put: %v\n", err)
+		}
+	}
+	err = Backup(dir)
+	CloseTest()
+	if err != nil {
+		t.Fatalf("error on backup: %v\n", err)
+	}
+	manifest, err := readBackupManifest(dir)
+	if err != nil {
+		t.Fatalf("bad manifest after backup: %v\n", err)
+	}
+	if len(manifest.Segments) != 2 {
+		t.Fatalf("expected metadata and data segments, got %d segments\n", len(manifest.Segments))
+	}
+	if err := Backup(dir); err == nil {
+		t.Errorf("expected error on backup to completed archive\n")
+	}
+
+	// Restore into a fresh datastore.
+	OpenTest()
+	defer CloseTest()
+	if err := Restore(dir); err != nil {
+		t.Fatalf("error on restore: %v\n", err)
+	}
+	restored, err := GetDataByUUIDName(uuid, "backedup")
+	if err != nil {
+		t.Fatalf("expected restored data instance: %v\n", err)
+	}
+	if restored.DataUUID() != d.DataUUID() {
+		t.Errorf("expected restored data %s, got %s\n", d.DataUUID(), restored.DataUUID())
+	}
+	db, err = restored.(*TestData).GetOrderedKeyValueDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = storage.NewDataContext(restored, v)
+	for _, s := range []string{"a", "b", "c"} {
+		value, err := db.Get(ctx, storage.NewTKey(1, []byte(s)))
+		if err != nil {
+			t.Fatalf("error on get: %v\n", err)
+		}
+		if string(value) != "value "+s {
+			t.Errorf("expected restored value %q, got %q\n", "value "+s, value)
+		}
+	}
+
+	// A datastore with repos can't be restored from a full backup.
+	os.Remove(filepath.Join(dir, restoreProgressFile))
+	if err := Restore(dir); err == nil {
+		t.Errorf("expected error on restore into datastore with repos\n")
+	}
+}
+
+func TestRestoreToChain(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	finished := time.Now()
+	manifests := []*backupManifest{
+		{Version: backupFormatVersion, HighWater: 10, Finished: finished},
+		{Version: backupFormatVersion, Since: 10, HighWater: 20, Finished: finished.Add(time.Hour)},
+		{Version: backupFormatVersion, Since: 20, HighWater: 30, Finished: finished.Add(2 * time.Hour)},
+	}
+	var dirs []string
+	for _, m := range manifests {
+		dir, err := ioutil.TempDir("", "dvid-backup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := writeJSONFile(filepath.Join(dir, backupManifestFile), m); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	points := []struct {
+		point    RestorePoint
+		included int
+	}{
+		{RestorePoint{}, 3},
+		{RestorePoint{Mutation: 20}, 2},
+		{RestorePoint{Mutation: 25}, 2},
+		{RestorePoint{Mutation: 5}, 0},
+		{RestorePoint{Time: finished.Add(90 * time.Minute)}, 2},
+		{RestorePoint{Mutation: 30, Time: finished.Add(time.Minute)}, 1},
+	}
+	for _, p := range points {
+		var included int
+		for _, m := range manifests {
+			if p.point.includes(m) {
+				included++
+			}
+		}
+		if included != p.included {
+			t.Errorf("expected %d backups within %+v, got %d\n", p.included, p.point, included)
+		}
+	}
+
+	bad := []struct {
+		sources []string
+		point   RestorePoint
+		problem string
+	}{
+		{nil, RestorePoint{}, "no archives"},
+		{dirs[1:], RestorePoint{}, "chain starting with an incremental backup"},
+		{[]string{dirs[0], dirs[0]}, RestorePoint{}, "full backup after the first"},
+		{[]string{dirs[0], dirs[2]}, RestorePoint{}, "gap in the chain"},
+		{dirs, RestorePoint{Mutation: 5}, "full backup after the restore point"},
+		{[]string{dirs[0], filepath.Join(dirs[0], "missing")}, RestorePoint{}, "missing archive"},
+	}
+	for _, b := range bad {
+		if err := RestoreTo(b.sources, b.point); err == nil {
+			t.Errorf("expected error on restore with %s\n", b.problem)
+		}
+	}
+}
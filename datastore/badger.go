@@ -0,0 +1,5 @@
+// +build badger
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/badger"
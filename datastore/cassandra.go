@@ -0,0 +1,5 @@
+// +build cassandra
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/cassandra"
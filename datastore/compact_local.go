@@ -0,0 +1,45 @@
+// +build !clustered,!gcloud
+
+package datastore
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// CompactStore compacts the store with the given alias, reclaiming the space used by
+// deleted and overwritten key-value pairs, e.g., after deleting data instances.
+func CompactStore(alias storage.Alias) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	return storage.CompactStore(alias)
+}
+
+// CompactData compacts the keys of a data instance, e.g., after large deletions within
+// the data instance.  The data instance's store must have an alias, so data instances
+// using caches or tiered stores can't be compacted this way; compact their stores instead.
+func CompactData(uuid dvid.UUID, name dvid.InstanceName) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	d, err := GetDataByUUIDName(uuid, name)
+	if err != nil {
+		return err
+	}
+	return compactDataInstance(d)
+}
+
+func compactDataInstance(d dvid.Data) error {
+	store, err := d.KVStore()
+	if err != nil {
+		return err
+	}
+	alias, found := storeAliases()[store]
+	if !found {
+		return fmt.Errorf("data %q store %s has no alias, so compact its underlying store instead", d.DataName(), store)
+	}
+	return storage.CompactInstance(alias, d.InstanceID())
+}
@@ -9,7 +9,9 @@
 package datastore
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -175,14 +177,20 @@ func CopyInstance(uuid dvid.UUID, source, target dvid.InstanceName, c dvid.Confi
 		fs = storage.FilterSpec(fstxt)
 	}
 
-	// Get flatten or not
+	// Get flatten, lazy, or full copy
 	transmit, found, err := c.GetString("transmit")
 	if err != nil {
 		return err
 	}
-	var flatten bool
-	if transmit == "flatten" {
+	var flatten, lazy bool
+	switch transmit {
+	case "flatten":
 		flatten = true
+	case "lazy":
+		if fs != "" {
+			return fmt.Errorf("filters can't be used with lazy copies of data %q", source)
+		}
+		lazy = true
 	}
 
 	// Get the source data instance.
@@ -230,6 +238,19 @@ func CopyInstance(uuid dvid.UUID, source, target dvid.InstanceName, c dvid.Confi
 		return fmt.Errorf("unable to get backing store for data %q: %v\n", d2.DataName(), err)
 	}
 
+	// A lazy copy clones the source in its store, so key-value pairs are only copied
+	// as either data instance changes.
+	if lazy {
+		if oldKV != newKV {
+			return fmt.Errorf("lazy copy of data %q requires %q to use the same store", d1.DataName(), d2.DataName())
+		}
+		if err := storage.CloneInstance(newKV, d1.InstanceID(), d2.InstanceID()); err != nil {
+			return fmt.Errorf("unable to clone data %q to %q: %v", d1.DataName(), d2.DataName(), err)
+		}
+		dvid.Infof("Cloned data %q (%s) to data %q\n", d1.DataName(), newKV, d2.DataName())
+		return nil
+	}
+
 	dvid.Infof("Copying data %q (%s) to data %q (%s)...\n", d1.DataName(), oldKV, d2.DataName(), newKV)
 
 	// See if this data instance implements a Send filter.
@@ -247,6 +268,126 @@ func CopyInstance(uuid dvid.UUID, source, target dvid.InstanceName, c dvid.Confi
 	return copyData(oldKV, newKV, d1, d2, uuid, filter, flatten)
 }
 
+// FlattenRepo creates a new repo whose root node holds the state of all data instances
+// at the given committed node without any of the node's history, and returns the new
+// root UUID after the data has been copied.  Data instances keep their names, properties,
+// and syncs among the copied instances, and the new repo is in the original's namespace.
+// The original repo can then be deleted to reclaim the space used by its history.
+func FlattenRepo(uuid dvid.UUID, alias, description, passcode string) (dvid.UUID, error) {
+	if manager == nil {
+		return dvid.NilUUID, ErrManagerNotInitialized
+	}
+	locked, err := manager.lockedUUID(uuid)
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+	if !locked {
+		return dvid.NilUUID, fmt.Errorf("node %s must be committed before it can be flattened", uuid)
+	}
+	v, err := manager.versionFromUUID(uuid)
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+
+	// Only data instances created in the node or its ancestors are visible at the node.
+	r.RLock()
+	var sources []DataService
+	for _, d := range r.data {
+		sources = append(sources, d)
+	}
+	var props map[string]interface{}
+	if namespace := r.namespace(); namespace != "" {
+		props = map[string]interface{}{namespaceProperty: namespace}
+	}
+	r.RUnlock()
+	sort.Slice(sources, func(i, j int) bool { return sources[i].DataName() < sources[j].DataName() })
+	var visible []DataService
+	for _, d := range sources {
+		rootV, err := manager.versionFromUUID(d.RootUUID())
+		if err != nil {
+			return dvid.NilUUID, err
+		}
+		if rootV != v {
+			if ancestor, err := manager.isAncestor(rootV, v); err != nil {
+				return dvid.NilUUID, err
+			} else if !ancestor {
+				continue
+			}
+		}
+		visible = append(visible, d)
+	}
+
+	flat, err := manager.newRepo(alias, description, nil, passcode, props)
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+	root := flat.uuid
+	rootV := flat.version
+
+	// Create the data instances before syncing them.
+	copies := make(map[dvid.UUID]DataService, len(visible))
+	for _, d1 := range visible {
+		t, err := TypeServiceByName(d1.TypeName())
+		if err != nil {
+			return root, err
+		}
+		d2, err := manager.newData(root, t, d1.DataName(), dvid.NewConfig())
+		if err != nil {
+			return root, err
+		}
+		if copier, ok := d2.(PropertyCopier); ok {
+			if err := copier.CopyPropertiesFrom(d1, ""); err != nil {
+				return root, err
+			}
+			if err := SaveDataByUUID(root, d2); err != nil {
+				return root, err
+			}
+		}
+		copies[d1.DataUUID()] = d2
+	}
+	for _, d1 := range visible {
+		syncer, ok := d1.(Syncer)
+		if !ok || len(syncer.SyncedData()) == 0 {
+			continue
+		}
+		syncs := make(dvid.UUIDSet)
+		for dataUUID := range syncer.SyncedData() {
+			if d2, found := copies[dataUUID]; found {
+				syncs[d2.DataUUID()] = struct{}{}
+			}
+		}
+		if err := manager.setSync(copies[d1.DataUUID()], syncs, true); err != nil {
+			return root, err
+		}
+	}
+
+	for _, d1 := range visible {
+		d2 := copies[d1.DataUUID()]
+		oldKV, err := getOrderedKeyValueDB(d1)
+		if err != nil {
+			return root, err
+		}
+		newKV, err := getOrderedKeyValueDB(d2)
+		if err != nil {
+			return root, err
+		}
+		if err := copyVersionData(oldKV, newKV, d1, d2, v, rootV, nil, true); err != nil {
+			return root, err
+		}
+	}
+
+	msg := fmt.Sprintf("Flattened node %s of repo %s into this repo", uuid, r.uuid)
+	if err := manager.addToRepoLog(root, []string{msg}); err != nil {
+		return root, err
+	}
+	dvid.Infof("Flattened node %s into new repo with root %s\n", uuid, root)
+	return root, nil
+}
+
 // copyData copies all key-value pairs pertinent to the given data instance d2.  If d2 is nil,
 // the destination data instance is d1, useful for migration of data to a new store.
 // Each datatype can implement filters that can restrict the transmitted key-value pairs
@@ -257,14 +398,22 @@ func copyData(oldKV, newKV storage.OrderedKeyValueDB, d1, d2 dvid.Data, uuid dvi
 	if err != nil {
 		return err
 	}
-	srcCtx := NewVersionedCtx(d1, v)
+	return copyVersionData(oldKV, newKV, d1, d2, v, v, f, flatten)
+}
+
+// copyVersionData is copyData given versions.  If flatten is true, the key-value pairs
+// visible at version srcV are copied into version dstV, which can be a node of another
+// repo.  Otherwise, all versions of the key-value pairs are copied.
+func copyVersionData(oldKV, newKV storage.OrderedKeyValueDB, d1, d2 dvid.Data, srcV, dstV dvid.VersionID, f storage.Filter, flatten bool) error {
+	srcCtx := NewVersionedCtx(d1, srcV)
 	var dstCtx *VersionedCtx
 	if d2 == nil {
 		d2 = d1
-		dstCtx = srcCtx
+		dstCtx = NewVersionedCtx(d1, dstV)
 	} else {
-		dstCtx = NewVersionedCtx(d2, v)
+		dstCtx = NewVersionedCtx(d2, dstV)
 	}
+	dstCtx.lockedWrites = true // copies into a version, e.g., a flattened instance, are admin operations.
 
 	// Send this instance's key-value pairs
 	var wg sync.WaitGroup
@@ -371,7 +520,7 @@ func copyData(oldKV, newKV storage.OrderedKeyValueDB, d1, d2 dvid.Data, uuid dvi
 		}()
 
 		begKey, endKey := srcCtx.KeyRange()
-		if err = oldKV.RawRangeQuery(begKey, endKey, keysOnly, ch, nil); err != nil {
+		if err := oldKV.RawRangeQuery(context.Background(), begKey, endKey, keysOnly, ch); err != nil {
 			return fmt.Errorf("push voxels %q range query: %v", d1.DataName(), err)
 		}
 	}
@@ -32,12 +32,14 @@ func init() {
 type Request struct {
 	dvid.Command
 	Input []byte
+	Token string // API token, required for some commands if the server requires tokens
 }
 
 // Response supports RPC responses from DVID.
 type Response struct {
 	dvid.Response
 	Output []byte
+	Jobs   []uint64 // background jobs started by the request.
 }
 
 // Writes a RPC response to a writer.
@@ -60,10 +62,43 @@ func (r *Response) Write(w io.Writer) error {
 // have a version DAG.
 type VersionedCtx struct {
 	*storage.DataContext
+
+	lockedWrites bool // allow writes even if the version is locked.
 }
 
 func NewVersionedCtx(data dvid.Data, versionID dvid.VersionID) *VersionedCtx {
-	return &VersionedCtx{storage.NewDataContext(data, versionID)}
+	return &VersionedCtx{DataContext: storage.NewDataContext(data, versionID)}
+}
+
+// CheckWrite implements storage.WriteChecker, returning a *ReadOnlyRepoError if the
+// context's repo has the read-only setting or a *LockedNodeError if the context's version
+// is locked and writes to locked nodes haven't been allowed via SetLockedWrites.
+// Unversioned data, which always uses the root version, can be written unless the repo
+// is read-only.
+func (vctx *VersionedCtx) CheckWrite() error {
+	if vctx.lockedWrites || manager == nil {
+		return nil
+	}
+	data := vctx.Data()
+	if data == nil {
+		return nil
+	}
+	v := vctx.VersionID()
+	if root, readOnly, err := manager.repoReadOnly(v); err == nil && readOnly {
+		return &ReadOnlyRepoError{UUID: root, Data: data.DataName()}
+	}
+	if LockedWritesAllowed() || !data.Versioned() {
+		return nil
+	}
+	locked, err := manager.lockedVersion(v)
+	if err != nil || !locked {
+		return nil // versions not in a DAG, e.g., during their deletion, are left to callers.
+	}
+	uuid, err := manager.uuidFromVersion(v)
+	if err != nil {
+		return err
+	}
+	return &LockedNodeError{UUID: uuid, Data: data.DataName()}
 }
 
 // VersionedKeyValue returns the key-value pair corresponding to this key's version
@@ -239,6 +274,26 @@ type VersionInitializer interface {
 	InitVersion(dvid.UUID, dvid.VersionID) error
 }
 
+// VersionMerger is a data instance that resolves a merge of versions in a type-specific
+// way, e.g., by combining rather than choosing between conflicting values.  It returns the
+// key-value pairs to be written in the merged child, where a nil value is a deletion.
+// Instances that don't implement it have conflicting key-value pairs resolved generically.
+type VersionMerger interface {
+	MergeVersions(parents []dvid.VersionID, strategy MergeStrategy) ([]*storage.TKeyValue, error)
+}
+
+// VersionDiffer is a data instance that computes the changes between two versions in a
+// type-specific way, e.g., by ignoring keys of derived data.
+type VersionDiffer interface {
+	DiffVersions(from, to dvid.VersionID, withKeys bool) (*VersionDiff, error)
+}
+
+// TKeyDescriber is a data instance that can describe its type-specific keys, e.g., for
+// listing the keys changed between versions.
+type TKeyDescriber interface {
+	DescribeTKey(storage.TKey) (string, error)
+}
+
 // DataInitializer is a data instance that needs to be initialized, e.g., start
 // long-lived goroutines that handle data syncs, etc.  Initialization should only
 // constitute supporting data and goroutines and not change the data itself like
@@ -356,6 +411,17 @@ type Data struct {
 	kvStore  dvid.Store       // key-value store
 	logStore storage.WriteLog // append-only log
 
+	// alias of a store explicitly chosen via the "store" option on creation.  If empty,
+	// the kv store is assigned using the backend configuration.
+	storeAlias storage.Alias
+
+	// user-supplied metadata to make instances in large repos easier to navigate.
+	description string
+	owner       string
+	tags        []string
+	created     time.Time
+	modified    time.Time // last change to the instance's configuration or metadata.
+
 	// an atomic operation ID used for non-persistent operations like coordinating
 	// multiple sync deltas.
 	mutID uint64
@@ -390,6 +456,12 @@ func (d *Data) MarshalJSON() ([]byte, error) {
 		Checksum    string
 		Syncs       []dvid.InstanceName
 		Versioned   bool
+		Store       storage.Alias `json:",omitempty"`
+		Description string        `json:",omitempty"`
+		Owner       string        `json:",omitempty"`
+		Tags        []string      `json:",omitempty"`
+		Created     time.Time
+		Modified    time.Time
 	}{
 		TypeName:    d.typename,
 		TypeURL:     d.typeurl,
@@ -401,6 +473,12 @@ func (d *Data) MarshalJSON() ([]byte, error) {
 		Checksum:    d.checksum.String(),
 		Syncs:       syncs,
 		Versioned:   !d.unversioned,
+		Store:       d.storeAlias,
+		Description: d.description,
+		Owner:       d.owner,
+		Tags:        d.tags,
+		Created:     d.created,
+		Modified:    d.modified,
 	})
 }
 
@@ -412,10 +490,39 @@ var reservedNames = map[string]struct{}{
 	"newversion": struct{}{},
 }
 
+// Compression and checksum used for new data instances unless given in the instance config.
+var (
+	defaultCompression, _ = dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
+	defaultChecksum       = dvid.DefaultChecksum
+)
+
+// SetInstanceDefaults sets the compression and checksum, e.g., "zstd:3" and "crc32",
+// used for new data instances that don't specify them.  Empty strings leave the
+// current defaults of LZ4 compression and no checksum.
+func SetInstanceDefaults(compression, checksum string) error {
+	if compression != "" {
+		c, err := dvid.ParseCompression(compression)
+		if err != nil {
+			return err
+		}
+		defaultCompression = c
+	}
+	if checksum != "" {
+		c, err := dvid.ParseChecksum(checksum)
+		if err != nil {
+			return err
+		}
+		defaultChecksum = c
+	}
+	return nil
+}
+
 // NewDataService returns a new Data instance that fulfills the DataService interface.
 // The UUID passed in corresponds to the root UUID of the DAG subgraph that should hold the data.
 // This returned Data struct is usually embedded by datatype-specific data instances.
-// By default, LZ4 and the default checksum is used.
+// Compression and checksum are set by "Compression" and "Checksum" settings in the config,
+// falling back to the server defaults.  A "store" setting in the config selects a store
+// by its alias in the TOML configuration instead of the backend assignment.
 func NewDataService(t TypeService, rootUUID dvid.UUID, id dvid.InstanceID, name dvid.InstanceName, c dvid.Config) (*Data, error) {
 	if _, reserved := reservedNames[string(name)]; reserved {
 		return nil, fmt.Errorf("cannot use reserved name %q", name)
@@ -427,8 +534,17 @@ func NewDataService(t TypeService, rootUUID dvid.UUID, id dvid.InstanceID, name
 	// 	return nil, fmt.Errorf("cannot create data instance %q when one already exists in repo with UUID %s", name, rootUUID)
 	// }
 
-	// See if a store was defined for a particular data instance.
-	kvStore, err := storage.GetAssignedStore(name, rootUUID, t.GetTypeName())
+	// See if a store was requested or defined for a particular data instance.
+	alias, found, err := c.GetString("store")
+	if err != nil {
+		return nil, err
+	}
+	var kvStore dvid.Store
+	if found && alias != "" {
+		kvStore, err = storage.GetAliasedStore(storage.Alias(alias), name, rootUUID, t.GetTypeName())
+	} else {
+		kvStore, err = storage.GetAssignedStore(name, rootUUID, t.GetTypeName())
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -444,7 +560,7 @@ func NewDataService(t TypeService, rootUUID dvid.UUID, id dvid.InstanceID, name
 	}
 
 	// Setup the basic data instance structure.
-	compression, _ := dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
+	created := time.Now()
 	data := &Data{
 		typename:    t.GetTypeName(),
 		typeurl:     t.GetTypeURL(),
@@ -453,13 +569,16 @@ func NewDataService(t TypeService, rootUUID dvid.UUID, id dvid.InstanceID, name
 		id:          id,
 		name:        name,
 		rootUUID:    rootUUID,
-		compression: compression,
-		checksum:    dvid.DefaultChecksum,
+		compression: defaultCompression,
+		checksum:    defaultChecksum,
 		syncNames:   []dvid.InstanceName{},
 		syncData:    dvid.UUIDSet{},
 		unversioned: false,
 		kvStore:     kvStore,
 		logStore:    logStore,
+		storeAlias:  storage.Alias(alias),
+		created:     created,
+		modified:    created,
 	}
 	return data, data.ModifyConfig(c)
 }
@@ -495,6 +614,17 @@ func (d *Data) KVStore() (dvid.Store, error) {
 	return d.kvStore, nil
 }
 
+// StoreAlias returns the alias of the store chosen on creation or the empty string if
+// the store is assigned by the backend configuration.
+func (d *Data) StoreAlias() storage.Alias {
+	return d.storeAlias
+}
+
+// ClearStoreAlias removes any store chosen on creation so the backend configuration is used.
+func (d *Data) ClearStoreAlias() {
+	d.storeAlias = ""
+}
+
 func (d *Data) LogStore() (storage.WriteLog, error) {
 	if d.logStore == nil {
 		return storage.DefaultLogStore()
@@ -544,6 +674,12 @@ func (d *Data) SetLogStore(logStore storage.WriteLog) {
 	d.logStore = logStore
 }
 
+// SetCompression sets the compression used for newly written values.  Values already
+// stored remain readable since the format is recorded with each value.
+func (d *Data) SetCompression(c dvid.Compression) {
+	d.compression = c
+}
+
 // ---------------
 
 func (d *Data) GobDecode(b []byte) error {
@@ -590,6 +726,25 @@ func (d *Data) GobDecode(b []byte) error {
 			dvid.Infof("Data %q has legacy sync names, will convert to data UUIDs...\n", d.name)
 		}
 	}
+	if err := dec.Decode(&(d.storeAlias)); err != nil {
+		d.storeAlias = ""
+	}
+	// user metadata may not exist.
+	if err := dec.Decode(&(d.description)); err != nil {
+		return nil
+	}
+	if err := dec.Decode(&(d.owner)); err != nil {
+		return err
+	}
+	if err := dec.Decode(&(d.tags)); err != nil {
+		return err
+	}
+	if err := dec.Decode(&(d.created)); err != nil {
+		return err
+	}
+	if err := dec.Decode(&(d.modified)); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -633,6 +788,24 @@ func (d *Data) GobEncode() ([]byte, error) {
 	if err := enc.Encode(d.syncData); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(d.storeAlias); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(d.description); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(d.owner); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(d.tags); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(d.created); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(d.modified); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -664,6 +837,49 @@ func (d *Data) Checksum() dvid.Checksum {
 	return d.checksum
 }
 
+// CorruptValueError reports a stored value of a data instance that failed checksum
+// verification.
+type CorruptValueError struct {
+	Data    dvid.InstanceName
+	Version dvid.VersionID // version being read
+	Key     storage.TKey
+	Err     error
+}
+
+func (e *CorruptValueError) Error() string {
+	return fmt.Sprintf("corrupt value in data %q, version %d, key %v: %v", e.Data, e.Version, e.Key, e.Err)
+}
+
+// DeserializeValue deserializes a value stored under the given key and read for the
+// given version, returning a *CorruptValueError if the stored checksum doesn't match.
+func (d *Data) DeserializeValue(v dvid.VersionID, tk storage.TKey, value []byte, uncompress bool) ([]byte, dvid.CompressionFormat, error) {
+	data, format, err := dvid.DeserializeData(value, uncompress)
+	if cerr, ok := err.(*dvid.ChecksumError); ok {
+		d.logCorruption(v, tk, cerr)
+		return nil, format, &CorruptValueError{d.name, v, tk, cerr}
+	}
+	return data, format, err
+}
+
+// VerifyValue checks the checksum of a serialized value stored under the given key and
+// read for the given version if checksum verification is on.  It should be used when a
+// stored value is sent as-is to clients.
+func (d *Data) VerifyValue(v dvid.VersionID, tk storage.TKey, value []byte) error {
+	if !dvid.VerifyChecksums {
+		return nil
+	}
+	err := dvid.VerifyChecksum(value)
+	if cerr, ok := err.(*dvid.ChecksumError); ok {
+		d.logCorruption(v, tk, cerr)
+		return &CorruptValueError{d.name, v, tk, cerr}
+	}
+	return err
+}
+
+func (d *Data) logCorruption(v dvid.VersionID, tk storage.TKey, err error) {
+	dvid.Criticalf("Corrupt value in data %q, version %d, key %v: %v\n", d.name, v, tk, err)
+}
+
 // --- DataService implementation -----
 
 // IsMutationRequest is the default definition of mutation requests.
@@ -687,59 +903,57 @@ func (d *Data) GetType() TypeService {
 }
 
 func (d *Data) ModifyConfig(config dvid.Config) error {
+	// The store can only be chosen on creation since data isn't migrated.
+	alias, found, err := config.GetString("store")
+	if err != nil {
+		return err
+	}
+	if found && storage.Alias(alias) != d.storeAlias {
+		return fmt.Errorf("cannot change store of data %q from %q to %q after creation", d.name, d.storeAlias, alias)
+	}
+
 	// Set compression for this instance
 	s, found, err := config.GetString("Compression")
 	if err != nil {
 		return err
 	}
 	if found {
-		format := strings.ToLower(s)
-		switch format {
-		case "none":
-			d.compression, _ = dvid.NewCompression(dvid.Uncompressed, dvid.DefaultCompression)
-		case "snappy":
-			d.compression, _ = dvid.NewCompression(dvid.Snappy, dvid.DefaultCompression)
-		case "lz4":
-			d.compression, _ = dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
-		case "gzip":
-			d.compression, _ = dvid.NewCompression(dvid.Gzip, dvid.DefaultCompression)
-		case "jpeg":
+		compression, err := dvid.ParseCompression(s)
+		if err != nil {
+			return err
+		}
+		switch compression.Format() {
+		case dvid.JPEG:
 			// Jpeg should only be used on datatypes with a BlockSize property
-			// and should only be used on uint8blk dim1 < 256 -- not enforced
-
-			// default dim1 setting
-			firstdim := 32
-
-			blockstr, found, err := config.GetString("BlockSize")
-			if err != nil {
-				return err
-			}
-			if found {
-				// extract the first block dimension size
-				bparts := strings.Split(blockstr, ",")
-				firstdim, err = strconv.Atoi(bparts[0])
+			// and should only be used on uint8blk dim1 < 256 -- not enforced.
+			// Unless given, the level is the first block dimension.
+			if compression.Level() == dvid.DefaultCompression {
+				firstdim := 32
+				blockstr, found, err := config.GetString("BlockSize")
 				if err != nil {
-					return fmt.Errorf("Unable to parse first block dim (%q).", bparts[0])
+					return err
 				}
-				if firstdim <= 0 {
-					return fmt.Errorf("Invalid blocksize dim for jpeg compression")
+				if found {
+					// extract the first block dimension size
+					bparts := strings.Split(blockstr, ",")
+					firstdim, err = strconv.Atoi(bparts[0])
+					if err != nil {
+						return fmt.Errorf("Unable to parse first block dim (%q).", bparts[0])
+					}
+					if firstdim <= 0 {
+						return fmt.Errorf("Invalid blocksize dim for jpeg compression")
+					}
 				}
+				// all data stored must be divisible by firstdim -- which will be the case for block datatypes
+				compression, _ = dvid.NewCompression(dvid.JPEG, dvid.CompressionLevel(firstdim))
 			}
-			// all data stored must be divisible by firstdim -- which will be the case for block datatypes
-			d.compression, _ = dvid.NewCompression(dvid.JPEG, dvid.CompressionLevel(firstdim))
-		default:
-			// Check for gzip + compression level
-			parts := strings.Split(format, ":")
-			if len(parts) == 2 && parts[0] == "gzip" {
-				level, err := strconv.Atoi(parts[1])
-				if err != nil {
-					return fmt.Errorf("Unable to parse gzip compression level (%q).  Should be 'gzip:<level>'.", parts[1])
-				}
-				d.compression, _ = dvid.NewCompression(dvid.Gzip, dvid.CompressionLevel(level))
-			} else {
-				return fmt.Errorf("Illegal compression specified: %s", s)
+		case dvid.Zstd:
+			// keep any dictionary already trained for this instance.
+			if compression, err = dvid.NewZstdCompression(compression.Level(), d.compression.Dict()); err != nil {
+				return err
 			}
 		}
+		d.compression = compression
 	}
 
 	// Set checksum for this instance
@@ -748,14 +962,8 @@ func (d *Data) ModifyConfig(config dvid.Config) error {
 		return err
 	}
 	if found {
-		checksum := strings.ToLower(s)
-		switch checksum {
-		case "none":
-			d.checksum = dvid.NoChecksum
-		case "crc32":
-			d.checksum = dvid.CRC32
-		default:
-			return fmt.Errorf("Illegal checksum specified: %s", s)
+		if d.checksum, err = dvid.ParseChecksum(s); err != nil {
+			return err
 		}
 	}
 
@@ -775,9 +983,69 @@ func (d *Data) ModifyConfig(config dvid.Config) error {
 			return fmt.Errorf("Illegal setting for 'versioned' (needs to be 'false', '0', 'true', or '1'): %s", s)
 		}
 	}
+	return d.ModifyMetadata(config)
+}
+
+// ModifyMetadata sets any "Description", "Owner", or "Tags" given in the configuration
+// and the time of modification.  Tags can be a comma-separated string or a JSON array of
+// strings and replace any previous tags.  Data types that override ModifyConfig should
+// call this so user metadata can be set for any instance.
+func (d *Data) ModifyMetadata(config dvid.Config) error {
+	description, found, err := config.GetString("Description")
+	if err != nil {
+		return err
+	}
+	if found {
+		d.description = description
+	}
+	owner, found, err := config.GetString("Owner")
+	if err != nil {
+		return err
+	}
+	if found {
+		d.owner = owner
+	}
+	if value, found := config.Get("Tags"); found {
+		var tags []string
+		switch v := value.(type) {
+		case string:
+			for _, tag := range strings.Split(v, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					tags = append(tags, tag)
+				}
+			}
+		case []interface{}:
+			for _, elem := range v {
+				tag, ok := elem.(string)
+				if !ok {
+					return fmt.Errorf("tags of data %q must be strings, got %v", d.name, elem)
+				}
+				tags = append(tags, tag)
+			}
+		default:
+			return fmt.Errorf("tags of data %q must be a comma-separated string or array of strings", d.name)
+		}
+		d.tags = tags
+	}
+	d.modified = time.Now()
 	return nil
 }
 
+// Description returns the user-supplied description of the data instance.
+func (d *Data) Description() string {
+	return d.description
+}
+
+// Owner returns the user-supplied owner of the data instance.
+func (d *Data) Owner() string {
+	return d.owner
+}
+
+// Tags returns the user-supplied tags of the data instance.
+func (d *Data) Tags() []string {
+	return d.tags
+}
+
 // PushData is the base implementation of pushing data instance key-value pairs
 // to a remote DVID without any datatype-specific filtering of data.
 func (d *Data) PushData(p *PushSession) error {
@@ -1,12 +1,14 @@
 package datastore
 
 import (
+	"bytes"
 	"encoding/gob"
 	"net/http"
 	"reflect"
 	"testing"
 
 	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
 )
 
 func init() {
@@ -45,6 +47,21 @@ func (d *TestData) Help() string {
 	return "no help here!"
 }
 
+func (d *TestData) GobDecode(b []byte) error {
+	buf := bytes.NewBuffer(b)
+	dec := gob.NewDecoder(buf)
+	return dec.Decode(&(d.Data))
+}
+
+func (d *TestData) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(d.Data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func TestDataGobEncoding(t *testing.T) {
 	compression, _ := dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
 	data := &TestData{&Data{
@@ -58,6 +75,7 @@ func TestDataGobEncoding(t *testing.T) {
 		compression: compression,
 		checksum:    dvid.DefaultChecksum,
 		syncData:    dvid.UUIDSet{"moo": struct{}{}, "bar": struct{}{}, "baz": struct{}{}},
+		storeAlias:  "fast",
 	}}
 
 	encoding, err := data.GobEncode()
@@ -72,3 +90,61 @@ func TestDataGobEncoding(t *testing.T) {
 		t.Errorf("Bad Gob roundtrip:\nOriginal: %v\nReturned: %v\n", data, data2)
 	}
 }
+
+func TestDataStoreAlias(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	uuid, _ := NewTestRepo()
+	alias := testStore.backend.DefaultKVDB
+	c := dvid.NewConfig()
+	c.Set("store", string(alias))
+	dataservice, err := (&TestType{}).NewDataService(uuid, 1, "aliased", c)
+	if err != nil {
+		t.Fatalf("unable to create data with store %q: %v\n", alias, err)
+	}
+	data := dataservice.(*TestData)
+	if data.StoreAlias() != alias {
+		t.Errorf("expected store alias %q, got %q\n", alias, data.StoreAlias())
+	}
+	if store, err := data.KVStore(); err != nil || store == nil {
+		t.Errorf("expected store for alias %q, got %v: %v\n", alias, store, err)
+	}
+
+	c.Set("store", "elsewhere")
+	if err := data.ModifyConfig(c); err == nil {
+		t.Errorf("expected error on changing store after creation\n")
+	}
+	if _, err := (&TestType{}).NewDataService(uuid, 2, "unaliased", c); err == nil {
+		t.Errorf("expected error on creating data with unknown store alias\n")
+	}
+}
+
+func TestCorruptValue(t *testing.T) {
+	data := &Data{name: "corruptible"}
+	compression, _ := dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
+	value, err := dvid.SerializeData([]byte("some stored value"), compression, dvid.CRC32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := storage.NewTKey(2, []byte("key"))
+	if out, _, err := data.DeserializeValue(3, tk, value, true); err != nil || string(out) != "some stored value" {
+		t.Fatalf("bad deserialization of valid value: %q, %v\n", out, err)
+	}
+
+	value[len(value)-1] ^= 0x01
+	_, _, err = data.DeserializeValue(3, tk, value, true)
+	cerr, ok := err.(*CorruptValueError)
+	if !ok {
+		t.Fatalf("expected corrupt value error, got %v\n", err)
+	}
+	if cerr.Data != "corruptible" || cerr.Version != 3 || !bytes.Equal(cerr.Key, tk) {
+		t.Errorf("expected corrupt value error with instance, version, and key, got %+v\n", cerr)
+	}
+
+	dvid.VerifyChecksums = true
+	defer func() { dvid.VerifyChecksums = false }()
+	if _, ok := data.VerifyValue(3, tk, value).(*CorruptValueError); !ok {
+		t.Errorf("expected corrupt value error on verification\n")
+	}
+}
@@ -11,9 +11,13 @@ package datastore
 
 import (
 	"bytes"
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/storage"
@@ -42,6 +46,9 @@ func Types() (map[dvid.URLString]TypeService, error) {
 	if manager == nil {
 		return nil, ErrManagerNotInitialized
 	}
+	if err := manager.pageInAll(); err != nil {
+		return nil, err
+	}
 	return manager.types()
 }
 
@@ -51,6 +58,9 @@ func MarshalJSON() ([]byte, error) {
 	if manager == nil {
 		return nil, ErrManagerNotInitialized
 	}
+	if err := manager.pageInAll(); err != nil {
+		return nil, err
+	}
 	return manager.MarshalJSON()
 }
 
@@ -77,12 +87,24 @@ func VersionFromUUID(uuid dvid.UUID) (dvid.VersionID, error) {
 	return manager.versionFromUUID(uuid)
 }
 
-// MatchingUUID returns version identifiers that uniquely matches a uuid string.
+// MetadataLoaded returns true if the repo metadata has been loaded and the datastore can
+// handle requests.
+func MetadataLoaded() bool {
+	return manager != nil
+}
+
+// MatchingUUID returns version identifiers that uniquely matches a uuid string, which can
+// be a prefix of a UUID or a tag set by SetTag.  If repos are lazily loaded, the matching
+// version's repo is loaded.
 func MatchingUUID(uuidStr string) (dvid.UUID, dvid.VersionID, error) {
 	if manager == nil {
 		return dvid.NilUUID, 0, ErrManagerNotInitialized
 	}
-	return manager.matchingUUID(uuidStr)
+	uuid, v, err := manager.matchingUUID(uuidStr)
+	if err != nil {
+		return uuid, v, err
+	}
+	return uuid, v, manager.pageIn(uuid)
 }
 
 // ----- Repo functions -----------
@@ -93,19 +115,47 @@ func NewRepo(alias, description string, assign *dvid.UUID, passcode string) (dvi
 	if manager == nil {
 		return dvid.NilUUID, ErrManagerNotInitialized
 	}
-	r, err := manager.newRepo(alias, description, assign, passcode)
+	r, err := manager.newRepo(alias, description, assign, passcode, nil)
 	if err != nil {
 		return dvid.NilUUID, err
 	}
+	PublishEvent(Event{Type: EventNewRepo, Repo: r.uuid, UUID: r.uuid, Note: description})
 	return r.uuid, err
 }
 
-// DeleteRepo deletes a Repo holding a node with UUID.
-func DeleteRepo(uuid dvid.UUID, passcode string) error {
+// RepoDeleteTokenTimeout is how long a token from RepoDeleteToken can confirm a deletion.
+const RepoDeleteTokenTimeout = 10 * time.Minute
+
+// RepoDeleteToken returns a confirmation token that must be passed to DeleteRepo to delete
+// the repo with the given root UUID.  The repo's passcode, if any, must be supplied.
+// Requiring the token prevents accidental deletion of a repo with a single request.
+func RepoDeleteToken(uuid dvid.UUID, passcode string) (string, error) {
+	if manager == nil {
+		return "", ErrManagerNotInitialized
+	}
+	return manager.repoDeleteToken(uuid, passcode)
+}
+
+// DeleteRepo deletes the Repo with the given root UUID, including all its data instances'
+// key-value pairs, which are deleted asynchronously.  The confirmation token must be
+// the most recent one returned by RepoDeleteToken for the repo within RepoDeleteTokenTimeout,
+// and each token can only be tried once.
+func DeleteRepo(uuid dvid.UUID, confirmToken string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	return manager.deleteRepo(uuid, confirmToken)
+}
+
+// DeleteVersion deletes a node from its repo's DAG along with the key-value pairs
+// written in it, e.g., to clean up a failed experimental branch.  A node with children
+// can only be deleted if withDescendants is true, in which case its entire branch is
+// deleted.  Deletion of key-value pairs and compaction are done asynchronously.
+func DeleteVersion(uuid dvid.UUID, withDescendants bool, passcode string) error {
 	if manager == nil {
 		return ErrManagerNotInitialized
 	}
-	return manager.deleteRepo(uuid, passcode)
+	return manager.deleteVersion(uuid, withDescendants, passcode)
 }
 
 func GetRepoRoot(uuid dvid.UUID) (dvid.UUID, error) {
@@ -171,6 +221,69 @@ func AddToRepoLog(uuid dvid.UUID, msgs []string) error {
 	return manager.addToRepoLog(uuid, msgs)
 }
 
+// SetTag names the node with given UUID so the tag can be used wherever a UUID string is
+// accepted.  If the tag already names a node in the repo, it is moved to the given node.
+// Tags must be unique across repos and can't be mistaken for UUIDs, so they must include a
+// character that isn't hexadecimal.  Tag changes are recorded in the repo log.
+func SetTag(uuid dvid.UUID, tag string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	return manager.setTag(uuid, tag)
+}
+
+// DeleteTag removes a tag from the repo containing the node with given UUID.
+func DeleteTag(uuid dvid.UUID, tag string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	return manager.deleteTag(uuid, tag)
+}
+
+// GetTags returns the tags of the repo containing the node with given UUID.
+func GetTags(uuid dvid.UUID) (map[string]dvid.UUID, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	return manager.getTags(uuid)
+}
+
+// DAGNode is a version node of a DAGGraph.
+type DAGNode struct {
+	UUID    dvid.UUID
+	Branch  string `json:",omitempty"`
+	Note    string `json:",omitempty"`
+	Locked  bool
+	Tags    []string `json:",omitempty"`
+	Created time.Time
+	Updated time.Time
+}
+
+// DAGEdge connects a parent node to a child node of a DAGGraph.  Merge is true if the
+// parent isn't the child's first parent, i.e., the edge was added by a merge.
+type DAGEdge struct {
+	Parent dvid.UUID
+	Child  dvid.UUID
+	Merge  bool
+}
+
+// DAGGraph is the version DAG of a repo as lists of nodes and edges, which is easier to
+// render than the nested repo JSON.  Nodes are ordered by creation time.
+type DAGGraph struct {
+	Root  dvid.UUID
+	Alias string
+	Nodes []DAGNode
+	Edges []DAGEdge
+}
+
+// GetDAG returns the version DAG of the repo containing the node with given UUID.
+func GetDAG(uuid dvid.UUID) (*DAGGraph, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	return manager.getDAG(uuid)
+}
+
 func GetNodeNote(uuid dvid.UUID) (string, error) {
 	if manager == nil {
 		return "", ErrManagerNotInitialized
@@ -242,11 +355,137 @@ func LockedVersion(v dvid.VersionID) (bool, error) {
 	return manager.lockedVersion(v)
 }
 
+// LockedNodeError is returned when versioned data is written in a locked node without
+// an admin override.
+type LockedNodeError struct {
+	UUID dvid.UUID
+	Data dvid.InstanceName
+}
+
+func (e *LockedNodeError) Error() string {
+	return fmt.Sprintf("cannot write data %q in locked node %s", e.Data, e.UUID)
+}
+
+var lockedWrites int32
+
+// SetLockedWrites allows or refuses writes of versioned data in locked nodes.  Writes are
+// refused by default and should only be allowed by an administrator.
+func SetLockedWrites(allow bool) {
+	if allow {
+		atomic.StoreInt32(&lockedWrites, 1)
+	} else {
+		atomic.StoreInt32(&lockedWrites, 0)
+	}
+}
+
+// LockedWritesAllowed returns true if writes of versioned data in locked nodes are allowed.
+func LockedWritesAllowed() bool {
+	return atomic.LoadInt32(&lockedWrites) != 0
+}
+
+// autoBranchProperty is the repo property enabling auto-branching.
+const autoBranchProperty = "auto-branch"
+
+// SetAutoBranch enables or disables auto-branching for the repo with the given UUID.  With
+// auto-branching, a write to a locked node of the repo is applied to a new child of the
+// node, created via AutoBranchVersion, instead of failing.
+func SetAutoBranch(uuid dvid.UUID, on bool) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	return manager.setRepoProperty(uuid, autoBranchProperty, on)
+}
+
+// AutoBranch returns true if auto-branching is enabled for the repo with the given UUID.
+func AutoBranch(uuid dvid.UUID) (bool, error) {
+	if manager == nil {
+		return false, ErrManagerNotInitialized
+	}
+	value, err := manager.getRepoProperty(uuid, autoBranchProperty)
+	if err != nil || value == nil {
+		return false, err
+	}
+	on, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("bad auto-branch setting for repo %s: %v", uuid, value)
+	}
+	return on, nil
+}
+
+// AutoBranchVersion creates an open child of the locked node with the given UUID for a
+// write, returning the child's UUID and version.  The child continues the node's branch
+// unless the node already has a child on that branch, in which case the child starts a
+// branch named after its UUID.
+func AutoBranchVersion(uuid dvid.UUID) (dvid.UUID, dvid.VersionID, error) {
+	if manager == nil {
+		return dvid.NilUUID, 0, ErrManagerNotInitialized
+	}
+	note := fmt.Sprintf("auto-branched on write to locked node %s", uuid)
+	child, err := manager.newVersion(uuid, note, "", nil)
+	if err == ErrBranchUnique {
+		assign := dvid.NewUUID()
+		child, err = manager.newVersion(uuid, note, "auto-"+string(assign), &assign)
+	}
+	if err != nil {
+		return dvid.NilUUID, 0, err
+	}
+	v, err := manager.versionFromUUID(child)
+	if err != nil {
+		return dvid.NilUUID, 0, err
+	}
+	dvid.Infof("Auto-branched child %s from locked node %s for write\n", child, uuid)
+	return child, v, nil
+}
+
+// CommitInfo records why a version was committed and by whom.
+type CommitInfo struct {
+	Message string
+	User    string    `json:",omitempty"`
+	App     string    `json:",omitempty"` // the application used to commit
+	Time    time.Time // set on commit
+}
+
 func Commit(uuid dvid.UUID, note string, log []string) error {
+	return CommitWithInfo(uuid, CommitInfo{Message: note}, log)
+}
+
+// CommitWithInfo commits (locks) a version like Commit, recording the user and application
+// with the commit message.  The message becomes the node's note if it isn't empty, and the
+// commit is appended to the node log after any given log messages.
+func CommitWithInfo(uuid dvid.UUID, info CommitInfo, log []string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	if err := manager.commit(uuid, info, log); err != nil {
+		return err
+	}
+	publishVersionEvent(Event{Type: EventLock, Note: info.Message}, uuid)
+	return nil
+}
+
+// Unlock reopens a committed (locked) node so fixes can be written in it, recording who
+// unlocked it and why in the node log.  Since writes to a node change the data seen by
+// its descendants, only nodes without children can be unlocked.  A reason is required,
+// and the repo's passcode, if any, must be supplied.  The node's commit information is
+// cleared, and it must be committed again before it can be branched.
+func Unlock(uuid dvid.UUID, reason, user, passcode string) error {
 	if manager == nil {
 		return ErrManagerNotInitialized
 	}
-	return manager.commit(uuid, note, log)
+	if err := manager.unlock(uuid, reason, user, passcode); err != nil {
+		return err
+	}
+	publishVersionEvent(Event{Type: EventUnlock, Note: reason}, uuid)
+	return nil
+}
+
+// GetCommitInfo returns the commit information for a version or nil if it hasn't been
+// committed or was committed before commit information was recorded.
+func GetCommitInfo(uuid dvid.UUID) (*CommitInfo, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	return manager.getCommitInfo(uuid)
 }
 
 func Merge(parents []dvid.UUID, note string, mt MergeType) (dvid.UUID, error) {
@@ -265,7 +504,33 @@ func NewData(uuid dvid.UUID, t TypeService, name dvid.InstanceName, c dvid.Confi
 	if manager == nil {
 		return nil, ErrManagerNotInitialized
 	}
-	return manager.newData(uuid, t, name, c)
+	d, err := manager.newData(uuid, t, name, c)
+	if err != nil {
+		return nil, err
+	}
+	publishDataEvent(EventInstanceCreated, uuid, d, "")
+	return d, nil
+}
+
+// NewInstanceID allocates and persists a new data instance ID using the server's
+// allocator, e.g., for data instances created outside NewData.
+func NewInstanceID() (dvid.InstanceID, error) {
+	if manager == nil {
+		return 0, ErrManagerNotInitialized
+	}
+	return manager.newInstanceID()
+}
+
+// SetInstanceIDAllocator replaces the allocator of new data instance IDs chosen by the
+// server configuration, e.g., with one coordinating IDs across a cluster of servers
+// sharing a store.
+func SetInstanceIDAllocator(a InstanceIDAllocator) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	manager.setInstanceIDAllocator(a)
+	dvid.Infof("Allocating new instance ids with %s allocator\n", a)
+	return nil
 }
 
 // SaveDataByUUID persists metadata for a data instance with given uuid.
@@ -288,6 +553,15 @@ func SaveDataByVersion(v dvid.VersionID, data DataService) error {
 	return manager.saveRepoByVersion(v)
 }
 
+// SaveZstdDict registers a zstd dictionary and persists it in the metadata store so it
+// is available for decompression after restarts.  The dictionary ID is returned.
+func SaveZstdDict(dict []byte) (uint32, error) {
+	if manager == nil {
+		return 0, ErrManagerNotInitialized
+	}
+	return manager.putZstdDict(dict)
+}
+
 // getDataByInstanceID returns a data service given a server-specific instance ID.
 func getDataByInstanceID(id dvid.InstanceID) (DataService, error) {
 	if manager == nil {
@@ -312,6 +586,14 @@ func GetDataByUUIDName(uuid dvid.UUID, name dvid.InstanceName) (DataService, err
 	return manager.getDataByUUIDName(uuid, name)
 }
 
+// GetRepoData returns the data instances of the repo with the given UUID sorted by name.
+func GetRepoData(uuid dvid.UUID) ([]DataService, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	return manager.getRepoData(uuid)
+}
+
 // GetDataByVersionName returns a data service given an instance name and version.
 func GetDataByVersionName(v dvid.VersionID, name dvid.InstanceName) (DataService, error) {
 	if manager == nil {
@@ -320,15 +602,87 @@ func GetDataByVersionName(v dvid.VersionID, name dvid.InstanceName) (DataService
 	return manager.getDataByVersionName(v, name)
 }
 
-// DeleteDataByName returns a data service given an instance name and UUID.
+// DeleteData removes a data instance given its name and UUID from the repo's metadata
+// and returns the ID of a job, which can be queried via GetJob, that deletes the instance's
+// key-value pairs and compacts its store in the background.
+func DeleteData(uuid dvid.UUID, name dvid.InstanceName, passcode string) (jobID uint64, err error) {
+	if manager == nil {
+		return 0, ErrManagerNotInitialized
+	}
+	d, _ := manager.getDataByUUIDName(uuid, name)
+	if jobID, err = manager.deleteDataByName(uuid, name, passcode); err != nil {
+		return 0, err
+	}
+	publishDataEvent(EventInstanceDeleted, uuid, d, "")
+	return jobID, nil
+}
+
+// DeleteDataByName deletes a data instance given its name and UUID.  See DeleteData.
 func DeleteDataByName(uuid dvid.UUID, name dvid.InstanceName, passcode string) error {
+	_, err := DeleteData(uuid, name, passcode)
+	return err
+}
+
+// DefaultTrashRetention is how long trashed data instances are kept if the server
+// configuration doesn't specify a period.
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// TrashRetention is how long a data instance moved to the trash via TrashData can be
+// restored before it is deleted like DeleteData.
+var TrashRetention = DefaultTrashRetention
+
+// TrashedData describes a data instance in a repo's trash.
+type TrashedData struct {
+	Name     dvid.InstanceName
+	Type     dvid.TypeString
+	DataUUID dvid.UUID
+	Trashed  time.Time
+	Expires  time.Time
+}
+
+// TrashData removes a data instance given its name and UUID from the repo like DeleteData,
+// but keeps its key-value pairs so it can be restored via RestoreData until TrashRetention
+// has passed.
+func TrashData(uuid dvid.UUID, name dvid.InstanceName, passcode string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	d, _ := manager.getDataByUUIDName(uuid, name)
+	if err := manager.trashData(uuid, name, passcode); err != nil {
+		return err
+	}
+	publishDataEvent(EventInstanceDeleted, uuid, d, "trash")
+	return nil
+}
+
+// RestoreData returns the most recently trashed data instance with the given name to the
+// repo.  The restore fails if the name is now used by another instance in the repo.
+func RestoreData(uuid dvid.UUID, name dvid.InstanceName) error {
 	if manager == nil {
 		return ErrManagerNotInitialized
 	}
-	return manager.deleteDataByName(uuid, name, passcode)
+	if err := manager.restoreData(uuid, name); err != nil {
+		return err
+	}
+	d, _ := manager.getDataByUUIDName(uuid, name)
+	publishDataEvent(EventInstanceCreated, uuid, d, "restore")
+	return nil
 }
 
-// RenameData renames a data service given an old instance name and UUID.
+// GetTrash returns the data instances in the trash of the repo with the given UUID,
+// ordered by the time they were trashed.
+func GetTrash(uuid dvid.UUID) ([]TrashedData, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	return manager.getTrash(uuid)
+}
+
+// RenameData renames a data service given an old instance name and UUID.  The data
+// instance keeps its key-value pairs and syncs, which are independent of its name.  The
+// rename fails without effect if the new name is used in the repo, if the rename can't be
+// persisted, or if the backend configuration assigns stores by instance name differently
+// for the old and new names.
 func RenameData(uuid dvid.UUID, oldname, newname dvid.InstanceName, passcode string) error {
 	if manager == nil {
 		return ErrManagerNotInitialized
@@ -341,9 +695,19 @@ func DeleteDataByVersion(v dvid.VersionID, name dvid.InstanceName, passcode stri
 	if manager == nil {
 		return ErrManagerNotInitialized
 	}
-	return manager.deleteDataByVersion(v, name, passcode)
+	d, _ := manager.getDataByVersionName(v, name)
+	if _, err := manager.deleteDataByVersion(v, name, passcode); err != nil {
+		return err
+	}
+	if uuid, err := manager.uuidFromVersion(v); err == nil {
+		publishDataEvent(EventInstanceDeleted, uuid, d, "")
+	}
+	return nil
 }
 
+// ModifyDataConfigByName modifies the configuration of a data instance given its name
+// and UUID, e.g., its user metadata, and saves the repo.  Only settings within the passed
+// config are modified.
 func ModifyDataConfigByName(uuid dvid.UUID, name dvid.InstanceName, c dvid.Config) error {
 	if manager == nil {
 		return ErrManagerNotInitialized
@@ -535,7 +899,7 @@ func DeleteConflicts(uuid dvid.UUID, data DataService, oldParents, newParents []
 
 	minKey, maxKey := baseCtx.KeyRange()
 	keysOnly := true
-	if err := store.RawRangeQuery(minKey, maxKey, keysOnly, ch, nil); err != nil {
+	if err := store.RawRangeQuery(context.Background(), minKey, maxKey, keysOnly, ch); err != nil {
 		return err
 	}
 	wg.Wait()
@@ -553,6 +917,320 @@ func DeleteConflicts(uuid dvid.UUID, data DataService, oldParents, newParents []
 	return nil
 }
 
+// MergeWithStrategy creates a child of the given committed parents like Merge, but first
+// resolves key-value pairs that conflict between parents using the given strategy or,
+// for data instances whose type is in typeStrategies, the strategy for that type.  Resolved
+// values are written in the child, so unlike a conflict-free merge, GETs on the child never
+// fail due to conflicts.  With MergeConflictError, no child is created if any data conflicts.
+func MergeWithStrategy(parents []dvid.UUID, note string, strategy MergeStrategy, typeStrategies map[dvid.TypeString]MergeStrategy) (dvid.UUID, error) {
+	if manager == nil {
+		return dvid.NilUUID, ErrManagerNotInitialized
+	}
+	return manager.mergeWithStrategy(parents, note, strategy, typeStrategies)
+}
+
+// MergeKeyValues returns the key-value pairs of a data instance that conflict between the
+// given parent versions, resolved using the strategy, where a nil value is a deletion.
+// A key conflicts if parents see different writes of it, including deletions, and no one
+// write is descended from all the others.  Datatypes implementing VersionMerger can use
+// this as a default for key spaces without type-specific merging.
+func MergeKeyValues(data DataService, parents []dvid.VersionID, strategy MergeStrategy) ([]*storage.TKeyValue, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	if len(parents) < 2 {
+		return nil, fmt.Errorf("Must have more than one parent to merge.")
+	}
+	if strategy > MergeConflictError {
+		return nil, fmt.Errorf("bad merge strategy: %s", strategy)
+	}
+	store, err := getOrderedKeyValueDB(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the parent whose view of each conflicting key wins.
+	type winner struct {
+		tk      storage.TKey
+		parentV dvid.VersionID
+		deleted bool
+	}
+	var winners []winner
+	var numConflicts int
+	resolve := func(tk storage.TKey, kvv kvVersions) error {
+		// Find the write each parent sees.
+		writes := make([]dvid.VersionID, len(parents))
+		deleted := make(map[dvid.VersionID]bool)
+		for i, parentV := range parents {
+			kv, v, err := kvv.matchWrite(parentV)
+			if err != nil {
+				return err
+			}
+			if kv == nil && v != 0 {
+				deleted[v] = true
+			}
+			writes[i] = v
+		}
+
+		// Drop writes that are superseded by a descendant write seen by another parent.
+		var heads []dvid.VersionID
+		for _, v := range writes {
+			if v == 0 {
+				continue
+			}
+			var superseded bool
+			for _, other := range writes {
+				if other == 0 || other == v {
+					continue
+				}
+				isAncestor, err := manager.isAncestor(v, other)
+				if err != nil {
+					return err
+				}
+				if isAncestor {
+					superseded = true
+					break
+				}
+			}
+			if !superseded && !containsVersion(heads, v) {
+				heads = append(heads, v)
+			}
+		}
+		numDeleted := 0
+		for _, v := range heads {
+			if deleted[v] {
+				numDeleted++
+			}
+		}
+		if len(heads) < 2 || numDeleted == len(heads) {
+			return nil
+		}
+		numConflicts++
+		if strategy == MergeConflictError {
+			return nil
+		}
+
+		// The earliest parent wins for MergeOurs and the latest for MergeTheirs.
+		var winnerI int
+		for i, v := range writes {
+			if containsVersion(heads, v) {
+				winnerI = i
+				if strategy == MergeOurs {
+					break
+				}
+			}
+		}
+		winners = append(winners, winner{tk, parents[winnerI], deleted[writes[winnerI]]})
+		return nil
+	}
+
+	if err := forEachKeyVersions(store, data, resolve); err != nil {
+		return nil, err
+	}
+	if numConflicts != 0 && strategy == MergeConflictError {
+		return nil, fmt.Errorf("%d keys conflict between parents %v", numConflicts, parents)
+	}
+
+	// Get the winning values as seen by their parents.
+	kvs := make([]*storage.TKeyValue, len(winners))
+	for i, w := range winners {
+		kvs[i] = &storage.TKeyValue{K: w.tk}
+		if w.deleted {
+			continue
+		}
+		if kvs[i].V, err = store.Get(NewVersionedCtx(data, w.parentV), w.tk); err != nil {
+			return nil, err
+		}
+	}
+	return kvs, nil
+}
+
+// VersionDiff summarizes the key-value pairs of a data instance that differ between
+// two versions.
+type VersionDiff struct {
+	Added    int
+	Modified int
+	Deleted  int
+
+	BytesAdded   int64 // bytes of values in the later version that aren't in the earlier
+	BytesDeleted int64 // bytes of values in the earlier version that aren't in the later
+
+	Changes []KeyChange `json:",omitempty"`
+}
+
+// KeyChange describes a key whose value differs between two versions.
+type KeyChange struct {
+	Key    string // a type-specific description of the key or its hex encoding
+	Change string // "added", "modified", or "deleted"
+}
+
+// DiffVersions returns the changes to a data instance going from one version to another,
+// including the changed keys if requested.  Data instances implementing VersionDiffer
+// compute the diff in a type-specific way.
+func DiffVersions(data DataService, from, to dvid.VersionID, withKeys bool) (*VersionDiff, error) {
+	if differ, ok := data.(VersionDiffer); ok {
+		return differ.DiffVersions(from, to, withKeys)
+	}
+	return DiffKeyValues(data, from, to, withKeys)
+}
+
+// DiffKeyValues compares the values of each key of a data instance as seen by two versions.
+// Only keys are scanned, so values are read just for keys with different writes in the versions.
+func DiffKeyValues(data DataService, from, to dvid.VersionID, withKeys bool) (*VersionDiff, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	diff := new(VersionDiff)
+	if !data.Versioned() || from == to {
+		return diff, nil
+	}
+	store, err := getOrderedKeyValueDB(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []storage.TKey
+	err = forEachKeyVersions(store, data, func(tk storage.TKey, kvv kvVersions) error {
+		fromKV, _, err := kvv.matchWrite(from)
+		if err != nil {
+			return err
+		}
+		toKV, _, err := kvv.matchWrite(to)
+		if err != nil {
+			return err
+		}
+		if fromKV == nil && toKV == nil {
+			return nil
+		}
+		if fromKV != nil && toKV != nil && bytes.Equal(fromKV.K, toKV.K) {
+			return nil
+		}
+		changed = append(changed, tk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	describer, describable := data.(TKeyDescriber)
+	fromCtx, toCtx := NewVersionedCtx(data, from), NewVersionedCtx(data, to)
+	for _, tk := range changed {
+		fromVal, err := store.Get(fromCtx, tk)
+		if err != nil {
+			return nil, err
+		}
+		toVal, err := store.Get(toCtx, tk)
+		if err != nil {
+			return nil, err
+		}
+		var change string
+		switch {
+		case fromVal == nil:
+			change = "added"
+			diff.Added++
+		case toVal == nil:
+			change = "deleted"
+			diff.Deleted++
+		case bytes.Equal(fromVal, toVal):
+			continue // rewritten with the same value
+		default:
+			change = "modified"
+			diff.Modified++
+		}
+		diff.BytesAdded += int64(len(toVal))
+		diff.BytesDeleted += int64(len(fromVal))
+		if !withKeys {
+			continue
+		}
+		key := hex.EncodeToString(tk)
+		if describable {
+			if key, err = describer.DescribeTKey(tk); err != nil {
+				return nil, err
+			}
+		}
+		diff.Changes = append(diff.Changes, KeyChange{Key: key, Change: change})
+	}
+	return diff, nil
+}
+
+// matchWrite returns the key-value pair seen by a version and the version it was written in,
+// or a nil key-value pair if it was deleted in that version.  Zero is returned as the version
+// if no write is seen.  Unlike FindMatch, the key-value versions are not modified, so many
+// versions can be matched against them.
+func (kvv kvVersions) matchWrite(v dvid.VersionID) (*storage.KeyValue, dvid.VersionID, error) {
+	vkvv := make(kvVersions, len(kvv))
+	for v, n := range kvv {
+		vkvv[v] = n
+	}
+	kv, writeV, err := manager.findMatch(vkvv, v)
+	if err != nil || kv != nil {
+		return kv, writeV, err
+	}
+	if n, found := kvv[writeV]; !found || !n.kv.K.IsTombstone() {
+		return nil, 0, nil
+	}
+	return nil, writeV, nil
+}
+
+// forEachKeyVersions calls f with all stored versions of each key of a data instance in turn.
+// Only keys are read from the store.
+func forEachKeyVersions(store storage.OrderedKeyValueDB, data DataService, f func(storage.TKey, kvVersions) error) error {
+	baseCtx := NewVersionedCtx(data, 0)
+	ch := make(chan *storage.KeyValue, 1000)
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		var batchTK storage.TKey
+		kvv := kvVersions{}
+		for kv := range ch {
+			if kv == nil {
+				break
+			}
+			if err != nil {
+				continue // drain the range query after an error
+			}
+			var v dvid.VersionID
+			var tk storage.TKey
+			if v, err = baseCtx.VersionFromKey(kv.K); err != nil {
+				continue
+			}
+			if tk, err = storage.TKeyFromKey(kv.K); err != nil {
+				continue
+			}
+			if batchTK != nil && !bytes.Equal(tk, batchTK) {
+				if err = f(batchTK, kvv); err != nil {
+					continue
+				}
+				kvv = kvVersions{}
+			}
+			batchTK = tk
+			kvv[v] = kvvNode{kv: kv}
+		}
+		if err == nil && len(kvv) != 0 {
+			err = f(batchTK, kvv)
+		}
+		errCh <- err
+	}()
+
+	minKey, maxKey := baseCtx.KeyRange()
+	keysOnly := true
+	err := store.RawRangeQuery(context.Background(), minKey, maxKey, keysOnly, ch)
+	close(ch)
+	if fErr := <-errCh; err == nil {
+		err = fErr
+	}
+	return err
+}
+
+func containsVersion(versions []dvid.VersionID, v dvid.VersionID) bool {
+	for _, other := range versions {
+		if other == v {
+			return true
+		}
+	}
+	return false
+}
+
 // GetStorageBreakdown returns JSON for all the data instances in the stores.
 func GetStorageBreakdown() (string, error) {
 	stores, err := storage.AllStores()
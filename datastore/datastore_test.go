@@ -1,6 +1,7 @@
 package datastore
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -38,3 +39,108 @@ func TestNewInstanceIDs(t *testing.T) {
 		}
 	}
 }
+
+func TestInstanceIDAllocators(t *testing.T) {
+	if _, err := NewInstanceIDAllocator("bogus", 0); err == nil {
+		t.Errorf("expected error for unknown instance id generation\n")
+	}
+	if _, err := NewInstanceIDAllocator("prefixed", MaxInstancePrefix+1); err == nil {
+		t.Errorf("expected error for too large instance id prefix\n")
+	}
+
+	counter := dvid.InstanceID(100)
+	seq, err := NewInstanceIDAllocator("sequential", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := seq.AllocateID(&counter); err != nil || id != 100 || counter != 101 {
+		t.Errorf("bad sequential allocation: id %d, counter %d, error %v\n", id, counter, err)
+	}
+
+	prefixed, err := NewInstanceIDAllocator("prefixed", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := prefixed.AllocateID(&counter); err != nil || id != 3<<24|101 || counter != 102 {
+		t.Errorf("bad prefixed allocation: id %d, counter %d, error %v\n", id, counter, err)
+	}
+	counter = 1 << 24
+	if _, err := prefixed.AllocateID(&counter); err == nil {
+		t.Errorf("expected error when prefixed ids are exhausted\n")
+	}
+
+	OpenTest()
+	defer CloseTest()
+	if err := SetInstanceIDAllocator(prefixed); err != nil {
+		t.Fatal(err)
+	}
+	id, err := NewInstanceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id>>24 != 3 {
+		t.Errorf("expected instance id %d to have prefix 3\n", id)
+	}
+}
+
+func TestDataETag(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	uuid, _ := NewTestRepo()
+	data := &TestData{&Data{name: "etagged", dataUUID: dvid.NewUUID()}}
+	etag, err := DataETag(uuid, data)
+	if err != nil {
+		t.Fatalf("unable to get ETag: %v\n", err)
+	}
+	if etag == "" || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		t.Fatalf("expected quoted ETag, got %q\n", etag)
+	}
+	if etag2, _ := DataETag(uuid, data); etag2 != etag {
+		t.Errorf("expected unchanged ETag %q without mutations, got %q\n", etag, etag2)
+	}
+	RecordMutation(data.DataUUID())
+	if etag2, _ := DataETag(uuid, data); etag2 == etag {
+		t.Errorf("expected ETag to change after mutation, got %q\n", etag2)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	started := make(chan struct{})
+	id := RunJob("test", "nothing", func(ctx context.Context, step func(string)) error {
+		step("waiting")
+		close(started)
+		<-ctx.Done()
+		return ErrJobCancelled
+	})
+	<-started
+	if job, err := GetJob(id); err != nil || job.State != JobRunning || job.Step != "waiting" {
+		t.Fatalf("expected running job at step \"waiting\", got %v, %v\n", job, err)
+	}
+	if err := CancelJob(id); err != nil {
+		t.Fatalf("unable to cancel job: %v\n", err)
+	}
+	for i := 0; i < 100; i++ {
+		if job, _ := GetJob(id); job.State != JobRunning {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job, _ := GetJob(id); job.State != JobCancelled {
+		t.Errorf("expected cancelled job, got %v\n", job)
+	}
+	if err := CancelJob(id); err == nil {
+		t.Errorf("expected error cancelling a finished job\n")
+	}
+
+	id = RunJob("test", "nothing", func(ctx context.Context, step func(string)) error { return nil })
+	for i := 0; i < 100; i++ {
+		if job, _ := GetJob(id); job.State != JobRunning {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job, _ := GetJob(id); job.State != JobDone {
+		t.Errorf("expected done job, got %v\n", job)
+	}
+}
@@ -71,6 +71,30 @@ type TypeService interface {
 	Do(Request, *Response) error
 }
 
+// Route describes a HTTP endpoint for the server's OpenAPI specification.
+type Route struct {
+	Method  string // e.g., "GET"
+	Path    string // pattern with ":name" parameters, relative to the data instance for datatypes, e.g., "/key/:key".
+	Summary string
+	Query   []RouteParam // query-string parameters
+	Body    string       // media type of the request body, or "" if there is none.
+	Returns string       // media type of a successful response, or "" if there is none.
+}
+
+// RouteParam describes a query-string parameter of a Route.
+type RouteParam struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// RouteDescriber is a datatype that describes its HTTP endpoints, which are included in
+// the server's OpenAPI specification.  Endpoints of other datatypes are only described by
+// their help.
+type RouteDescriber interface {
+	Routes() []Route
+}
+
 var (
 	// Compiled is the set of registered datatypes compiled into DVID and
 	// held as a global variable initialized at runtime.
@@ -0,0 +1,5 @@
+// +build dynamodb
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/dynamodb"
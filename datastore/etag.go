@@ -0,0 +1,62 @@
+/*
+	This file counts mutations of data instances so responses can be given ETags that
+	change whenever the data they were read from might have changed.
+*/
+
+package datastore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+var (
+	mutationMu     sync.RWMutex
+	mutationCounts = make(map[dvid.UUID]uint64) // keyed by data UUID
+
+	// etagEpoch distinguishes ETags of different server runs since counts aren't
+	// persisted.
+	etagEpoch string
+)
+
+func init() {
+	b := make([]byte, 4)
+	rand.Read(b)
+	etagEpoch = hex.EncodeToString(b)
+}
+
+// RecordMutation notes that a data instance may have been modified in some version.
+func RecordMutation(dataUUID dvid.UUID) {
+	mutationMu.Lock()
+	mutationCounts[dataUUID]++
+	mutationMu.Unlock()
+}
+
+// MutationCount returns the number of mutations recorded for a data instance since the
+// server started.
+func MutationCount(dataUUID dvid.UUID) uint64 {
+	mutationMu.RLock()
+	defer mutationMu.RUnlock()
+	return mutationCounts[dataUUID]
+}
+
+// DataETag returns a strong ETag for responses to GETs of a data instance in the version
+// with the given UUID, or "" if the data could change without a recorded mutation, i.e.,
+// it syncs with other data that may update it asynchronously in an unlocked version.
+func DataETag(uuid dvid.UUID, data dvid.Data) (string, error) {
+	locked := false
+	if data.Versioned() {
+		var err error
+		if locked, err = LockedUUID(uuid); err != nil {
+			return "", err
+		}
+	}
+	if syncer, ok := data.(Syncer); ok && !locked && len(syncer.SyncedData()) > 0 {
+		return "", nil
+	}
+	return fmt.Sprintf(`"%s-%s-%d-%s"`, uuid, data.DataUUID(), MutationCount(data.DataUUID()), etagEpoch), nil
+}
@@ -0,0 +1,157 @@
+/*
+	This file provides a publish/subscribe system for datastore events, e.g., the creation
+	of repos, versions, and data instances, so datatypes, the server, and external
+	notifiers can react to changes without polling.  Unlike the sync subscriptions between
+	data instances, events are delivered asynchronously and may be dropped if a subscriber
+	falls behind.
+*/
+
+package datastore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// EventType is the kind of a datastore event.
+type EventType string
+
+const (
+	EventNewRepo         EventType = "NewRepo"
+	EventNewVersion      EventType = "NewVersion" // includes merges, which have many parents.
+	EventLock            EventType = "Lock"
+	EventUnlock          EventType = "Unlock"
+	EventDataModified    EventType = "DataModified"
+	EventInstanceCreated EventType = "InstanceCreated"
+	EventInstanceDeleted EventType = "InstanceDeleted"
+)
+
+// EventBufferSize is the number of events buffered for each subscriber.  Events published
+// while a subscriber's buffer is full are dropped for that subscriber.
+const EventBufferSize = 1000
+
+// Event describes a change to the datastore.
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	Repo     dvid.UUID         // root UUID of the repo
+	UUID     dvid.UUID         `json:",omitempty"` // the affected version
+	Parents  []dvid.UUID       `json:",omitempty"` // parents of a new version
+	Data     dvid.InstanceName `json:",omitempty"`
+	DataUUID dvid.UUID         `json:",omitempty"`
+	Action   string            `json:",omitempty"` // datatype-specific modification, e.g., a sync event.
+	Note     string            `json:",omitempty"`
+}
+
+type eventSub struct {
+	name    string
+	types   map[EventType]struct{} // all types if empty.
+	ch      chan Event
+	dropped uint64
+}
+
+var eventSubs struct {
+	sync.RWMutex
+	lastID uint64
+	byID   map[uint64]*eventSub
+}
+
+// Subscribe calls the given function in its own goroutine for each published event of
+// the given types, or all events if no types are given, returning a subscription ID for
+// Unsubscribe.  The name identifies the subscriber in logs.
+func Subscribe(name string, f func(Event), types ...EventType) uint64 {
+	sub := &eventSub{
+		name:  name,
+		types: make(map[EventType]struct{}, len(types)),
+		ch:    make(chan Event, EventBufferSize),
+	}
+	for _, t := range types {
+		sub.types[t] = struct{}{}
+	}
+	go func() {
+		for e := range sub.ch {
+			f(e)
+		}
+	}()
+
+	eventSubs.Lock()
+	defer eventSubs.Unlock()
+	if eventSubs.byID == nil {
+		eventSubs.byID = make(map[uint64]*eventSub)
+	}
+	eventSubs.lastID++
+	eventSubs.byID[eventSubs.lastID] = sub
+	return eventSubs.lastID
+}
+
+// Unsubscribe stops delivery of events to the subscription with the given ID after any
+// buffered events are handled.
+func Unsubscribe(id uint64) {
+	eventSubs.Lock()
+	defer eventSubs.Unlock()
+	if sub, found := eventSubs.byID[id]; found {
+		close(sub.ch)
+		delete(eventSubs.byID, id)
+	}
+}
+
+// PublishEvent sends an event to all subscribers of its type without waiting for them.
+// The event's time is set if it's zero.
+func PublishEvent(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	eventSubs.RLock()
+	defer eventSubs.RUnlock()
+	for _, sub := range eventSubs.byID {
+		if len(sub.types) != 0 {
+			if _, found := sub.types[e.Type]; !found {
+				continue
+			}
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			// Only log the first of a run of drops to avoid flooding the log.
+			if atomic.AddUint64(&sub.dropped, 1) == 1 {
+				dvid.Errorf("Dropping %s events for subscriber %q, which has fallen behind\n", e.Type, sub.name)
+			}
+			continue
+		}
+		atomic.StoreUint64(&sub.dropped, 0)
+	}
+}
+
+// EventsSubscribed returns true if there are any event subscribers, so publishers can
+// skip the work of constructing events nobody will receive.
+func EventsSubscribed() bool {
+	eventSubs.RLock()
+	defer eventSubs.RUnlock()
+	return len(eventSubs.byID) != 0
+}
+
+// publishVersionEvent publishes an event for a version, filling in its repo and UUID.
+func publishVersionEvent(e Event, uuid dvid.UUID) {
+	if manager == nil {
+		return
+	}
+	root, err := manager.getRepoRoot(uuid)
+	if err != nil {
+		dvid.Errorf("Unable to publish %s event for node %s: %v\n", e.Type, uuid, err)
+		return
+	}
+	e.Repo, e.UUID = root, uuid
+	PublishEvent(e)
+}
+
+// publishDataEvent publishes an event for a data instance in a version.  The data may be
+// nil if it couldn't be found, in which case the event isn't published.
+func publishDataEvent(t EventType, uuid dvid.UUID, d dvid.Data, action string) {
+	if d == nil {
+		return
+	}
+	publishVersionEvent(Event{Type: t, Data: d.DataName(), DataUUID: d.DataUUID(), Action: action}, uuid)
+}
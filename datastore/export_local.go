@@ -0,0 +1,245 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports export of a single repo to a self-describing archive stream that
+	can be imported into another DVID server or kept offline.
+
+	An export stream begins with a magic string followed by records, each a uvarint length
+	and that many bytes.  The first record is a JSON header describing the repo and its
+	data instances, and the second is the repo metadata.  Then, for each data instance in
+	header order, there are key and value records for all the instance's key-value pairs,
+	ending with an empty key.  Since instance and version IDs are local to a server, keys
+	are rewritten with new local IDs on import.
+*/
+
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+const (
+	exportFormatVersion = 1
+	exportMagic         = "DVIDREPO"
+)
+
+type exportHeader struct {
+	Version     int
+	Exported    time.Time
+	Root        dvid.UUID
+	Alias       string
+	Description string
+	Nodes       []dvid.UUID
+	Instances   []exportInstance
+}
+
+type exportInstance struct {
+	Name       dvid.InstanceName
+	Type       dvid.TypeString
+	DataUUID   dvid.UUID
+	InstanceID dvid.InstanceID // the exporting server's ID, used in exported keys.
+}
+
+type exportWriter struct {
+	w      *bufio.Writer
+	lenBuf []byte
+}
+
+func (ew *exportWriter) write(bs ...[]byte) error {
+	for _, b := range bs {
+		nlen := binary.PutUvarint(ew.lenBuf, uint64(len(b)))
+		if _, err := ew.w.Write(ew.lenBuf[:nlen]); err != nil {
+			return err
+		}
+		if _, err := ew.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportRepo writes the repo holding the given UUID, including all versions of its data
+// instances' key-value pairs, as an archive stream that can be passed to ImportRepo.
+// Writes to the repo during the export may or may not be captured.
+func ExportRepo(uuid dvid.UUID, w io.Writer) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+
+	r.RLock()
+	metadata, err := r.GobEncode()
+	if err != nil {
+		r.RUnlock()
+		return err
+	}
+	header := exportHeader{
+		Version:     exportFormatVersion,
+		Exported:    time.Now(),
+		Root:        r.uuid,
+		Alias:       r.alias,
+		Description: r.description,
+	}
+	for _, node := range r.dag.nodes {
+		header.Nodes = append(header.Nodes, node.uuid)
+	}
+	var instances []DataService
+	for _, d := range r.data {
+		instances = append(instances, d)
+	}
+	r.RUnlock()
+	sort.Slice(instances, func(i, j int) bool { return instances[i].DataName() < instances[j].DataName() })
+	for _, d := range instances {
+		header.Instances = append(header.Instances, exportInstance{d.DataName(), d.TypeName(), d.DataUUID(), d.InstanceID()})
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	ew := &exportWriter{w: bufio.NewWriterSize(w, 1<<20), lenBuf: make([]byte, binary.MaxVarintLen64)}
+	if _, err := ew.w.WriteString(exportMagic); err != nil {
+		return err
+	}
+	if err := ew.write(headerJSON, metadata); err != nil {
+		return err
+	}
+	for _, d := range instances {
+		db, err := getOrderedKeyValueDB(d)
+		if err != nil {
+			return fmt.Errorf("unable to get backing store for data %q: %v", d.DataName(), err)
+		}
+		var numKV uint64
+		begKey, endKey := storage.NewDataContext(d, 0).KeyRange()
+		err = rangeKVs(db, begKey, endKey, false, func(kv *storage.KeyValue) error {
+			numKV++
+			return ew.write(kv.K, kv.V)
+		})
+		if err != nil {
+			return fmt.Errorf("export of data %q failed: %v", d.DataName(), err)
+		}
+		if err := ew.write(nil); err != nil {
+			return err
+		}
+		dvid.Infof("Exported %d key-value pairs of data %q\n", numKV, d.DataName())
+	}
+	if err := ew.w.Flush(); err != nil {
+		return err
+	}
+	dvid.Infof("Exported repo %s with %d data instances\n", r.uuid, len(instances))
+	return nil
+}
+
+// ImportRepo adds a repo from an archive stream written by ExportRepo and returns its
+// root UUID.  None of the repo's nodes may already be on this server.  Data instances
+// are assigned to stores using this server's configuration, and tags already used by
+// other repos on this server are dropped.  The server should be restarted after an
+// import so any syncs between the imported data instances are started.
+func ImportRepo(in io.Reader) (dvid.UUID, error) {
+	if manager == nil {
+		return dvid.NilUUID, ErrManagerNotInitialized
+	}
+	br := &backupReader{r: bufio.NewReaderSize(in, 1<<20)}
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(br.r, magic); err != nil || string(magic) != exportMagic {
+		return dvid.NilUUID, fmt.Errorf("import stream is not a DVID repo export")
+	}
+	headerJSON, err := br.read()
+	if err != nil {
+		return dvid.NilUUID, fmt.Errorf("bad import header: %v", err)
+	}
+	var header exportHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return dvid.NilUUID, fmt.Errorf("bad import header: %v", err)
+	}
+	if header.Version != exportFormatVersion {
+		return dvid.NilUUID, fmt.Errorf("import has format version %d, expected %d", header.Version, exportFormatVersion)
+	}
+	for _, uuid := range header.Nodes {
+		if _, err := manager.versionFromUUID(uuid); err == nil {
+			return dvid.NilUUID, fmt.Errorf("node %s of imported repo %s already exists on this server", uuid, header.Root)
+		}
+	}
+
+	metadata, err := br.read()
+	if err != nil {
+		return dvid.NilUUID, fmt.Errorf("bad import metadata: %v", err)
+	}
+	r := new(repoT)
+	if err := r.GobDecode(metadata); err != nil {
+		return dvid.NilUUID, err
+	}
+	instanceMap, versionMap, err := r.localize()
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+	for tag := range r.tags {
+		if _, found, err := manager.taggedUUID(tag); err != nil || found {
+			dvid.Infof("Dropping tag %q of imported repo %s since it's used by another repo\n", tag, r.uuid)
+			delete(r.tags, tag)
+		}
+	}
+
+	for _, instance := range header.Instances {
+		d, found := r.data[instance.Name]
+		if !found || d.DataUUID() != instance.DataUUID {
+			return dvid.NilUUID, fmt.Errorf("imported metadata has no data %q (%s)", instance.Name, instance.DataUUID)
+		}
+		db, err := getOrderedKeyValueDB(d)
+		if err != nil {
+			return dvid.NilUUID, fmt.Errorf("unable to get backing store for data %q: %v", instance.Name, err)
+		}
+		var numKV, skipped uint64
+		for {
+			k, err := br.read()
+			if err != nil {
+				return dvid.NilUUID, fmt.Errorf("bad key of data %q: %v", instance.Name, err)
+			}
+			if len(k) == 0 {
+				break
+			}
+			v, err := br.read()
+			if err != nil {
+				return dvid.NilUUID, fmt.Errorf("bad value of data %q: %v", instance.Name, err)
+			}
+			oldInstance, oldVersion, _, err := storage.DataKeyToLocalIDs(k)
+			if err != nil {
+				return dvid.NilUUID, err
+			}
+			if oldInstance != instance.InstanceID {
+				return dvid.NilUUID, fmt.Errorf("key with instance id %d in stream of data %q", oldInstance, instance.Name)
+			}
+			newVersion, found := versionMap[oldVersion]
+			if !found {
+				skipped++ // e.g., left by a version deletion in progress during export.
+				continue
+			}
+			if err := storage.UpdateDataKey(k, instanceMap[oldInstance], newVersion, 0); err != nil {
+				return dvid.NilUUID, fmt.Errorf("unable to update data key %v: %v", k, err)
+			}
+			if err := db.RawPut(k, v); err != nil {
+				return dvid.NilUUID, err
+			}
+			numKV++
+		}
+		dvid.Infof("Imported %d key-value pairs of data %q, skipping %d of versions not in the repo\n", numKV, instance.Name, skipped)
+	}
+
+	if err := manager.addRepo(r); err != nil {
+		return dvid.NilUUID, err
+	}
+	dvid.Infof("Imported repo %s with %d data instances\n", r.uuid, len(header.Instances))
+	return r.uuid, nil
+}
@@ -0,0 +1,6 @@
+// +build fdb
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/fdb"
+import _ "github.com/janelia-flyem/dvid/storage/filelog"
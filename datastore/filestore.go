@@ -0,0 +1,6 @@
+// +build filestore
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/filestore"
+import _ "github.com/janelia-flyem/dvid/storage/filelog"
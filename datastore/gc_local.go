@@ -0,0 +1,140 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports garbage collection of key-value pairs written in versions that no
+	longer exist in any repo DAG, e.g., left behind by a version deletion that was
+	interrupted by a server restart.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// gcBatchSize is the number of unreachable keys deleted at a time during garbage collection.
+const gcBatchSize = 10000
+
+// gcStats summarizes the garbage collection of one data instance.
+type gcStats struct {
+	Scanned  uint64
+	Deleted  uint64
+	Versions []dvid.VersionID // unreachable versions with deleted key-value pairs.
+}
+
+// CollectGarbage starts a job that scans the key-value pairs of all data instances and
+// deletes those written in versions that aren't reachable in any repo DAG, returning the
+// job ID.  Data instance stores with deletions are compacted afterwards.  It shouldn't be
+// run while repos are being pushed to or imported into this server since their versions
+// aren't in a repo DAG until the transfer completes.
+func CollectGarbage() (jobID uint64, err error) {
+	if manager == nil {
+		return 0, ErrManagerNotInitialized
+	}
+	reachable, nextVersion := manager.reachableVersions()
+
+	manager.RLock()
+	instances := make([]DataService, 0, len(manager.iids))
+	for _, d := range manager.iids {
+		instances = append(instances, d)
+	}
+	manager.RUnlock()
+	sort.Slice(instances, func(i, j int) bool { return instances[i].InstanceID() < instances[j].InstanceID() })
+
+	job := newJob("garbage collection", fmt.Sprintf("%d data instances", len(instances)))
+	go func() {
+		var failed error
+		for _, d := range instances {
+			if jobCancelled(job) {
+				failed = ErrJobCancelled
+				break
+			}
+			setJobStep(job, fmt.Sprintf("scanning data %q (instance %d)", d.DataName(), d.InstanceID()))
+			stats, err := collectDataGarbage(d, reachable, nextVersion)
+			if err != nil {
+				dvid.Errorf("Garbage collection of data %q failed: %v\n", d.DataName(), err)
+				failed = err
+				continue
+			}
+			if stats.Deleted == 0 {
+				continue
+			}
+			dvid.Infof("Garbage collection deleted %d of %d key-value pairs of data %q in unreachable versions %v\n",
+				stats.Deleted, stats.Scanned, d.DataName(), stats.Versions)
+			setJobStep(job, fmt.Sprintf("compacting data %q (instance %d)", d.DataName(), d.InstanceID()))
+			if err := compactDataInstance(d); err != nil {
+				dvid.Infof("Skipping compaction after garbage collection of data %q: %v\n", d.DataName(), err)
+			}
+		}
+		finishJob(job, failed)
+	}()
+	return job, nil
+}
+
+// reachableVersions returns the versions in all repo DAGs and the next version ID to be
+// allocated.  Versions at or beyond that ID are created after the call and so are also
+// reachable.
+func (m *repoManager) reachableVersions() (map[dvid.VersionID]struct{}, dvid.VersionID) {
+	m.idMutex.RLock()
+	defer m.idMutex.RUnlock()
+	reachable := make(map[dvid.VersionID]struct{}, len(m.versionToUUID))
+	for v := range m.versionToUUID {
+		reachable[v] = struct{}{}
+	}
+	return reachable, m.versionID
+}
+
+// collectDataGarbage deletes the key-value pairs of a data instance written in versions
+// below nextVersion that aren't reachable.
+func collectDataGarbage(d dvid.Data, reachable map[dvid.VersionID]struct{}, nextVersion dvid.VersionID) (*gcStats, error) {
+	db, err := getOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, err
+	}
+	stats := new(gcStats)
+	unreachable := make(map[dvid.VersionID]struct{})
+	var batch []storage.Key
+	flush := func() error {
+		for _, k := range batch {
+			if err := db.RawDelete(k); err != nil {
+				return err
+			}
+		}
+		stats.Deleted += uint64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	begKey, endKey := storage.NewDataContext(d, 0).KeyRange()
+	err = rangeKVs(db, begKey, endKey, true, func(kv *storage.KeyValue) error {
+		stats.Scanned++
+		_, v, _, err := storage.DataKeyToLocalIDs(kv.K)
+		if err != nil {
+			return err
+		}
+		if _, found := reachable[v]; found || v >= nextVersion {
+			return nil
+		}
+		unreachable[v] = struct{}{}
+		batch = append(batch, kv.K)
+		if len(batch) >= gcBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	if err := flush(); err != nil {
+		return stats, err
+	}
+	for v := range unreachable {
+		stats.Versions = append(stats.Versions, v)
+	}
+	sort.Slice(stats.Versions, func(i, j int) bool { return stats.Versions[i] < stats.Versions[j] })
+	return stats, nil
+}
@@ -0,0 +1,96 @@
+/*
+	This file defines the allocators of new data instance IDs.  The allocator is chosen by
+	the server configuration, and clustered deployments can supply their own, e.g., one
+	that reserves IDs through a coordination service.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// InstancePrefixBits is the number of high bits of instance IDs given by the server
+// prefix for "prefixed" allocation.  The rest of the bits are allocated sequentially.
+const InstancePrefixBits = 8
+
+// MaxInstancePrefix is the largest server prefix for "prefixed" allocation.
+const MaxInstancePrefix = 1<<InstancePrefixBits - 1
+
+// InstanceIDAllocator generates new data instance IDs.  IDs already used by a data
+// instance are skipped by the caller, which calls the allocator again.
+type InstanceIDAllocator interface {
+	// AllocateID returns a new instance ID.  The counter is the persisted next sequential
+	// ID, which the allocator may use and advance.
+	AllocateID(counter *dvid.InstanceID) (dvid.InstanceID, error)
+
+	fmt.Stringer
+}
+
+// NewInstanceIDAllocator returns an allocator for the given method, one of "sequential",
+// "random", or "prefixed".  The prefix, which must be at most MaxInstancePrefix, is only
+// used by "prefixed" allocation, which gives each server sharing a store its own prefix so
+// sequentially allocated IDs don't collide.
+func NewInstanceIDAllocator(gen string, prefix uint32) (InstanceIDAllocator, error) {
+	switch gen {
+	case "", "sequential":
+		return sequentialAllocator{}, nil
+	case "random":
+		return &randomAllocator{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}, nil
+	case "prefixed":
+		if prefix > MaxInstancePrefix {
+			return nil, fmt.Errorf("instance id prefix %d is larger than the maximum of %d", prefix, MaxInstancePrefix)
+		}
+		return prefixedAllocator{prefix: dvid.InstanceID(prefix)}, nil
+	default:
+		return nil, fmt.Errorf("unknown instance id generation %q", gen)
+	}
+}
+
+type sequentialAllocator struct{}
+
+func (sequentialAllocator) AllocateID(counter *dvid.InstanceID) (dvid.InstanceID, error) {
+	if *counter == dvid.MaxInstanceID {
+		return 0, fmt.Errorf("no more sequential instance ids")
+	}
+	id := *counter
+	*counter++
+	return id, nil
+}
+
+func (sequentialAllocator) String() string { return "sequential" }
+
+type randomAllocator struct {
+	sync.Mutex
+	rnd *rand.Rand
+}
+
+func (a *randomAllocator) AllocateID(counter *dvid.InstanceID) (dvid.InstanceID, error) {
+	a.Lock()
+	defer a.Unlock()
+	return dvid.InstanceID(a.rnd.Uint32()), nil
+}
+
+func (a *randomAllocator) String() string { return "random" }
+
+type prefixedAllocator struct {
+	prefix dvid.InstanceID
+}
+
+const instanceSuffixBits = 32 - InstancePrefixBits
+
+func (a prefixedAllocator) AllocateID(counter *dvid.InstanceID) (dvid.InstanceID, error) {
+	if *counter >= 1<<instanceSuffixBits {
+		return 0, fmt.Errorf("no more instance ids with prefix %d", a.prefix)
+	}
+	id := a.prefix<<instanceSuffixBits | *counter
+	*counter++
+	return id, nil
+}
+
+func (a prefixedAllocator) String() string { return fmt.Sprintf("prefixed (%d)", a.prefix) }
@@ -0,0 +1,190 @@
+/*
+	This file supports tracking of background jobs, e.g., the deletion of a data instance's
+	key-value pairs, so their progress can be queried after the request starting them
+	returns.  Jobs can be cancelled, which stops jobs that check for cancellation at their
+	next step.
+*/
+
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// MaxFinishedJobs is the number of finished jobs kept for queries.
+const MaxFinishedJobs = 100
+
+// JobState is the state of a background job.
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobDone      JobState = "done"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// ErrJobCancelled is returned by jobs that stop because they were cancelled.
+var ErrJobCancelled = errors.New("job cancelled")
+
+// Job describes the progress of a background job.
+type Job struct {
+	ID       uint64
+	Kind     string // e.g., "delete data"
+	Target   string // what the job operates on, e.g., a data instance.
+	State    JobState
+	Step     string // the current step of a running job.
+	Error    string `json:",omitempty"`
+	Started  time.Time
+	Finished time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var jobs struct {
+	sync.RWMutex
+	lastID uint64
+	byID   map[uint64]*Job
+}
+
+// newJob registers a running job.
+func newJob(kind, target string) uint64 {
+	jobs.Lock()
+	defer jobs.Unlock()
+	if jobs.byID == nil {
+		jobs.byID = make(map[uint64]*Job)
+	}
+	jobs.lastID++
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs.byID[jobs.lastID] = &Job{
+		ID:      jobs.lastID,
+		Kind:    kind,
+		Target:  target,
+		State:   JobRunning,
+		Started: time.Now(),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	return jobs.lastID
+}
+
+// jobContext returns a context that is done when the job is cancelled.
+func jobContext(id uint64) context.Context {
+	jobs.RLock()
+	defer jobs.RUnlock()
+	if job, found := jobs.byID[id]; found {
+		return job.ctx
+	}
+	return context.Background()
+}
+
+// jobCancelled returns true if the job has been cancelled.
+func jobCancelled(id uint64) bool {
+	return jobContext(id).Err() != nil
+}
+
+func setJobStep(id uint64, step string) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	if job, found := jobs.byID[id]; found {
+		job.Step = step
+	}
+}
+
+// finishJob records the end of a job, forgetting the oldest finished jobs beyond
+// MaxFinishedJobs.
+func finishJob(id uint64, err error) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	job, found := jobs.byID[id]
+	if !found {
+		return
+	}
+	job.Step = ""
+	job.Finished = time.Now()
+	switch {
+	case err != nil && job.ctx.Err() != nil:
+		job.State = JobCancelled
+	case err != nil:
+		job.State = JobFailed
+		job.Error = err.Error()
+	default:
+		job.State = JobDone
+	}
+	job.cancel()
+
+	var finished []uint64
+	for id, job := range jobs.byID {
+		if job.State != JobRunning {
+			finished = append(finished, id)
+		}
+	}
+	if len(finished) > MaxFinishedJobs {
+		sort.Slice(finished, func(i, j int) bool { return finished[i] < finished[j] })
+		for _, id := range finished[:len(finished)-MaxFinishedJobs] {
+			delete(jobs.byID, id)
+		}
+	}
+}
+
+// RunJob runs a function as a background job, returning the job's ID.  The function is
+// given a context that is done if the job is cancelled and a function to report the
+// job's current step.
+func RunJob(kind, target string, fn func(ctx context.Context, step func(string)) error) uint64 {
+	id := newJob(kind, target)
+	go func() {
+		err := fn(jobContext(id), func(step string) { setJobStep(id, step) })
+		if err != nil {
+			dvid.Errorf("%s of %s failed: %v\n", kind, target, err)
+		}
+		finishJob(id, err)
+	}()
+	return id
+}
+
+// CancelJob cancels a running job.  Jobs stop at their next check for cancellation, so
+// the job may run for a while.
+func CancelJob(id uint64) error {
+	jobs.RLock()
+	defer jobs.RUnlock()
+	job, found := jobs.byID[id]
+	if !found {
+		return fmt.Errorf("no job %d, which may have finished long ago", id)
+	}
+	if job.State != JobRunning {
+		return fmt.Errorf("job %d already %s", id, job.State)
+	}
+	job.cancel()
+	return nil
+}
+
+// GetJob returns the progress of the background job with the given ID.
+func GetJob(id uint64) (Job, error) {
+	jobs.RLock()
+	defer jobs.RUnlock()
+	job, found := jobs.byID[id]
+	if !found {
+		return Job{}, fmt.Errorf("no job %d, which may have finished long ago", id)
+	}
+	return *job, nil
+}
+
+// GetJobs returns the running jobs and recently finished jobs in the order they started.
+func GetJobs() []Job {
+	jobs.RLock()
+	defer jobs.RUnlock()
+	list := make([]Job, 0, len(jobs.byID))
+	for _, job := range jobs.byID {
+		list = append(list, *job)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
@@ -0,0 +1,5 @@
+// +build kafka
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/kafka"
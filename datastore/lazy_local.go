@@ -0,0 +1,202 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports lazy loading of repos so servers with many repos can start quickly.
+	Only a stub with the id and root of each repo is created at startup, mapped to the
+	repo's versions through a summary stored apart from the repo.  Stubs are replaced by
+	the fully loaded repo when the repo is first accessed through a UUID or when paged in
+	by a background goroutine.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// RepoPageSize is the number of stored repos read at a time when paging in repos in the
+// background.
+const RepoPageSize = 100
+
+// loadRepoStubs adds a stub for each repo with a stored summary.  Repos without a summary,
+// e.g., those last saved before lazy loading was added, are loaded fully.  It returns true
+// if the manager's version caches were repaired and should be saved.  The caller must hold
+// the manager's locks.
+func (m *repoManager) loadRepoStubs() (saveCache bool, err error) {
+	var ctx storage.MetadataContext
+	minTKey := storage.NewTKey(repoSummaryKey, dvid.RepoID(0).Bytes())
+	maxTKey := storage.NewTKey(repoSummaryKey, dvid.RepoID(dvid.MaxRepoID).Bytes())
+	kvList, err := m.store.GetRange(ctx, minTKey, maxTKey)
+	if err != nil {
+		return false, err
+	}
+	stubbed := make(map[dvid.RepoID]struct{}, len(kvList))
+	for _, kv := range kvList {
+		ibytes, err := kv.K.ClassBytes(repoSummaryKey)
+		if err != nil {
+			return false, err
+		}
+		repoID := dvid.RepoIDFromBytes(ibytes)
+		var summary repoSummary
+		if err := gob.NewDecoder(bytes.NewReader(kv.V)).Decode(&summary); err != nil {
+			return false, fmt.Errorf("Error gob decoding summary of repo %d: %v", repoID, err)
+		}
+		if m.repoToUUID[repoID] != summary.Root {
+			dvid.Errorf("Summary of repo %d has root %s not in map.  Loading full repo...\n", repoID, summary.Root)
+			continue
+		}
+		uuids := make([]dvid.UUID, len(summary.Versions))
+		for i, v := range summary.Versions {
+			uuid, found := m.versionToUUID[v]
+			if !found {
+				uuids = nil
+				break
+			}
+			uuids[i] = uuid
+		}
+		if uuids == nil {
+			dvid.Errorf("Summary of repo %d has versions not in cache map.  Loading full repo...\n", repoID)
+			continue
+		}
+		stub := &repoT{id: repoID, uuid: summary.Root, version: summary.Version, stub: true}
+		for _, uuid := range uuids {
+			m.repos[uuid] = stub
+		}
+		stubbed[repoID] = struct{}{}
+	}
+
+	for repoID := range m.repoToUUID {
+		if _, found := stubbed[repoID]; found {
+			continue
+		}
+		value, err := m.store.Get(ctx, storage.NewTKey(repoKey, repoID.Bytes()))
+		if err != nil {
+			return false, err
+		}
+		if value == nil {
+			continue
+		}
+		repaired, err := m.loadRepo(repoID, value)
+		if err != nil {
+			return false, err
+		}
+		saveCache = saveCache || repaired
+		if r, found := m.repos[m.repoToUUID[repoID]]; found {
+			if err := r.saveSummary(); err != nil {
+				return false, err
+			}
+		}
+	}
+	dvid.Infof("Added %d repo stubs to be loaded on first access.\n", len(stubbed))
+	return saveCache, nil
+}
+
+// pageIn loads the repo with the given version if it's a stub.
+func (m *repoManager) pageIn(uuid dvid.UUID) error {
+	m.RLock()
+	r, found := m.repos[uuid]
+	m.RUnlock()
+	if !found || !r.stub {
+		return nil
+	}
+	return m.pageInRepo(r.id, nil)
+}
+
+// pageInAll loads all repos that are stubs.
+func (m *repoManager) pageInAll() error {
+	for _, repoID := range m.stubIDs() {
+		if err := m.pageInRepo(repoID, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageInRepos loads all stubs in the background, reading a page of repos at a time.
+func (m *repoManager) pageInRepos() {
+	repoIDs := m.stubIDs()
+	var ctx storage.MetadataContext
+	for start := 0; start < len(repoIDs); start += RepoPageSize {
+		end := start + RepoPageSize
+		if end > len(repoIDs) {
+			end = len(repoIDs)
+		}
+		minTKey := storage.NewTKey(repoKey, repoIDs[start].Bytes())
+		maxTKey := storage.NewTKey(repoKey, repoIDs[end-1].Bytes())
+		kvList, err := m.store.GetRange(ctx, minTKey, maxTKey)
+		if err != nil {
+			dvid.Errorf("Stopped paging in repos after error reading stored repos: %v\n", err)
+			return
+		}
+		for _, kv := range kvList {
+			ibytes, err := kv.K.ClassBytes(repoKey)
+			if err != nil {
+				dvid.Errorf("Skipping bad repo key while paging in repos: %v\n", err)
+				continue
+			}
+			repoID := dvid.RepoIDFromBytes(ibytes)
+			if err := m.pageInRepo(repoID, kv.V); err != nil {
+				dvid.Errorf("Unable to page in repo %d: %v\n", repoID, err)
+			}
+		}
+	}
+	dvid.Infof("Finished paging in %d repos.\n", len(repoIDs))
+}
+
+// stubIDs returns the sorted ids of repos that are stubs.
+func (m *repoManager) stubIDs() []dvid.RepoID {
+	m.RLock()
+	defer m.RUnlock()
+	var repoIDs []dvid.RepoID
+	for repoID, uuid := range m.repoToUUID {
+		if r, found := m.repos[uuid]; found && r.stub {
+			repoIDs = append(repoIDs, repoID)
+		}
+	}
+	sort.Slice(repoIDs, func(i, j int) bool { return repoIDs[i] < repoIDs[j] })
+	return repoIDs
+}
+
+// pageInRepo replaces the stub of a repo with the loaded repo, reading the repo from the
+// metadata store if no stored value is given.  It does nothing if the repo was already
+// loaded or deleted.  Since stubs aren't modified, a value read while the repo was a stub
+// is current.
+func (m *repoManager) pageInRepo(repoID dvid.RepoID, value []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.idMutex.Lock()
+	defer m.idMutex.Unlock()
+
+	uuid, found := m.repoToUUID[repoID]
+	if !found {
+		return nil
+	}
+	if r, found := m.repos[uuid]; !found || !r.stub {
+		return nil
+	}
+	if value == nil {
+		var ctx storage.MetadataContext
+		var err error
+		if value, err = m.store.Get(ctx, storage.NewTKey(repoKey, repoID.Bytes())); err != nil {
+			return err
+		}
+		if value == nil {
+			return fmt.Errorf("repo %d has no stored metadata", repoID)
+		}
+	}
+	saveCache, err := m.loadRepo(repoID, value)
+	if err != nil {
+		return err
+	}
+	dvid.Infof("Paged in repo %s (id %d).\n", uuid, repoID)
+	if saveCache {
+		return m.putCaches()
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+// +build memstore
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/memstore"
+import _ "github.com/janelia-flyem/dvid/storage/filelog"
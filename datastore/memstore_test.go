@@ -0,0 +1,55 @@
+// +build memstore
+
+package datastore
+
+import (
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func TestMemstoreLockedWrites(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	uuid, v := NewTestRepo()
+	dataservice, err := (&TestType{}).NewDataService(uuid, 1, "lockedmem", dvid.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit(uuid, "locked", nil); err != nil {
+		t.Fatalf("unable to commit root node %s: %v\n", uuid, err)
+	}
+	store, err := dataservice.KVStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, ok := store.(storage.OrderedKeyValueDB)
+	if !ok {
+		t.Fatalf("store %s isn't an ordered key-value db\n", store)
+	}
+	if _, ok := store.(storage.TransactionDB); !ok {
+		t.Fatalf("guarded memstore should still be transactional\n")
+	}
+
+	ctx := NewVersionedCtx(dataservice, v)
+	tk := storage.TKey("a")
+	if _, ok := db.Put(ctx, tk, []byte("locked")).(*LockedNodeError); !ok {
+		t.Errorf("expected locked node error on put to committed node\n")
+	}
+	err = storage.Patch(db, ctx, tk, func(value []byte) ([]byte, error) {
+		return []byte("patched"), nil
+	})
+	if _, ok := err.(*LockedNodeError); !ok {
+		t.Errorf("expected locked node error on patch of committed node, got %v\n", err)
+	}
+	batch := store.(storage.KeyValueBatcher).NewBatch(ctx)
+	batch.Put(tk, []byte("batched"))
+	if _, ok := batch.Commit().(*LockedNodeError); !ok {
+		t.Errorf("expected locked node error on batch commit to committed node\n")
+	}
+	if value, err := db.Get(ctx, tk); err != nil || value != nil {
+		t.Errorf("expected no value in committed node, got %q: %v\n", value, err)
+	}
+}
@@ -0,0 +1,47 @@
+// +build !clustered,!gcloud
+
+package datastore
+
+import (
+	"sort"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// InstanceMetrics are the storage operation metrics for a data instance.
+type InstanceMetrics struct {
+	Name       dvid.InstanceName `json:",omitempty"`
+	DataType   dvid.TypeString   `json:",omitempty"`
+	DataUUID   dvid.UUID         `json:",omitempty"`
+	InstanceID dvid.InstanceID
+	Seconds    float64 // total time spent in all operations.
+	Ops        map[string]storage.OpMetrics
+}
+
+// GetStoreMetrics returns the storage operation metrics of each data instance, sorted by
+// descending time spent in storage operations so slow instances are listed first.
+// Operations on metadata are given with instance ID 0 and no name.
+func GetStoreMetrics() ([]InstanceMetrics, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	metrics := storage.GetStoreMetrics()
+	manager.RLock()
+	defer manager.RUnlock()
+	out := make([]InstanceMetrics, 0, len(metrics))
+	for id, ops := range metrics {
+		im := InstanceMetrics{InstanceID: id, Ops: ops}
+		if d, found := manager.iids[id]; found {
+			im.Name = d.DataName()
+			im.DataType = d.TypeName()
+			im.DataUUID = d.DataUUID()
+		}
+		for _, m := range ops {
+			im.Seconds += m.Seconds
+		}
+		out = append(out, im)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Seconds > out[j].Seconds })
+	return out, nil
+}
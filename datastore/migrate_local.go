@@ -0,0 +1,139 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports migration of stored metadata between format versions.  The metadata
+	store has a format version for manager-wide keys, and each repo has its own format
+	version so repos can be upgraded when loaded, e.g., when paged in lazily.  Each change to
+	the metadata layout bumps RepoFormatVersion and adds a migration that upgrades the
+	previous format, which is run automatically when the metadata is opened.
+*/
+
+package datastore
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// metadataMigration upgrades metadata from the format version given by its index in
+// metadataMigrations to the next version.
+type metadataMigration struct {
+	description string
+
+	// store upgrades manager-wide keys before any repo is loaded.  It may be nil.
+	store func(m *repoManager) error
+
+	// repo upgrades a decoded repo when it's loaded, before its data instances are
+	// initialized.  Since repos are decoded before migration, changes to the repo
+	// encoding must still decode older repos.  It may be nil.
+	repo func(r *repoT) error
+}
+
+// metadataMigrations holds the migration from each format version to the next, so there
+// must be RepoFormatVersion migrations.
+var metadataMigrations = []metadataMigration{
+	{
+		// Data instances are upgraded through InstanceMutator.LoadMutable.
+		description: "labelvol max labels are loaded from key-value pairs",
+	},
+	{
+		description: "repos have their own format version",
+	},
+}
+
+// repoFormatsAdded is the first format version where each repo has a stored format version.
+const repoFormatsAdded = 2
+
+// migrateMetadata runs the store migrations from the stored format version to the current
+// one, saving the format version after each so an interrupted migration is resumed.  Repos
+// are migrated when loaded.  The caller must hold the manager's locks.
+func (m *repoManager) migrateMetadata() error {
+	if m.formatVersion > RepoFormatVersion {
+		return fmt.Errorf("metadata format version %d is newer than this server's version %d", m.formatVersion, RepoFormatVersion)
+	}
+	if m.formatVersion < repoFormatsAdded {
+		if err := m.recordRepoFormats(m.formatVersion); err != nil {
+			return fmt.Errorf("unable to record format version of repos: %v", err)
+		}
+	}
+	for m.formatVersion < RepoFormatVersion {
+		migration := metadataMigrations[m.formatVersion]
+		if migration.store != nil {
+			if err := migration.store(m); err != nil {
+				return fmt.Errorf("unable to migrate metadata from format version %d (%s): %v", m.formatVersion, migration.description, err)
+			}
+		}
+		m.formatVersion++
+		if err := m.putData(formatKey, &(m.formatVersion)); err != nil {
+			return err
+		}
+		dvid.Infof("Migrated metadata to format version %d: %s\n", m.formatVersion, migration.description)
+	}
+	return nil
+}
+
+// recordRepoFormats stores the given format version for all repos without one, i.e.,
+// those last saved before repos had their own format version.
+func (m *repoManager) recordRepoFormats(formatVersion uint64) error {
+	var ctx storage.MetadataContext
+	minTKey := storage.NewTKey(repoKey, dvid.RepoID(0).Bytes())
+	maxTKey := storage.NewTKey(repoKey, dvid.RepoID(dvid.MaxRepoID).Bytes())
+	keys, err := m.store.KeysInRange(ctx, minTKey, maxTKey)
+	if err != nil {
+		return err
+	}
+	for _, tk := range keys {
+		ibytes, err := tk.ClassBytes(repoKey)
+		if err != nil {
+			return err
+		}
+		formatTKey := storage.NewTKey(repoFormatKey, ibytes)
+		value, err := m.store.Get(ctx, formatTKey)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			continue
+		}
+		if err := m.store.Put(ctx, formatTKey, encodeRepoRevision(formatVersion)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrate runs the repo migrations from the repo's stored format version, returning the
+// stored version.  The repo's format version is updated when it's next saved.
+func (r *repoT) migrate() (storedVersion uint64, err error) {
+	storedVersion = r.formatVersion
+	if storedVersion > RepoFormatVersion {
+		return storedVersion, fmt.Errorf("repo %s format version %d is newer than this server's version %d", r.uuid, storedVersion, RepoFormatVersion)
+	}
+	for v := storedVersion; v < RepoFormatVersion; v++ {
+		migration := metadataMigrations[v]
+		if migration.repo == nil {
+			continue
+		}
+		if err := migration.repo(r); err != nil {
+			return storedVersion, fmt.Errorf("unable to migrate repo %s from format version %d (%s): %v", r.uuid, v, migration.description, err)
+		}
+	}
+	return storedVersion, nil
+}
+
+// loadFormatVersion sets the repo's format version to the one in the given metadata store.
+// Format versions are stored like revisions.
+func (r *repoT) loadFormatVersion(store storage.KeyValueDB) error {
+	var ctx storage.MetadataContext
+	value, err := store.Get(ctx, storage.NewTKey(repoFormatKey, r.id.Bytes()))
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return fmt.Errorf("repo %s has no stored format version", r.uuid)
+	}
+	r.formatVersion, err = decodeRepoRevision(value)
+	return err
+}
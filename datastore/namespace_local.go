@@ -0,0 +1,244 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports namespaces, which group repos so one server can host several labs.
+	Each namespace can have a storage quota for all its repos, API token scopes limited to
+	its repos, e.g., "write:lab1", and its own instance ID space.  Namespaces are defined
+	by the server configuration, and a repo's namespace is set when the repo is created and
+	persisted as its "namespace" property.  Repos created without a namespace are in the
+	default namespace, which is unrestricted.
+*/
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// namespaceProperty is the repo property naming the namespace of the repo.
+const namespaceProperty = "namespace"
+
+// NamespaceConfig gives the settings of a namespace.
+type NamespaceConfig struct {
+	Quota    uint64 // storage quota in bytes for all repos in the namespace; no limit if 0.
+	IDPrefix uint32 // if non-zero, the prefix of all instance IDs allocated in the namespace.
+	Private  bool   // if true, reading the namespace's repos requires a scope for it.
+}
+
+var namespaces struct {
+	sync.RWMutex
+	configs map[string]NamespaceConfig
+}
+
+var namespaceName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// CheckNamespaces returns an error if a namespace configuration is invalid.  Names can
+// only use letters, digits, "-", and "_", and instance ID prefixes must be unique and at
+// most MaxInstancePrefix.
+func CheckNamespaces(configs map[string]NamespaceConfig) error {
+	prefixes := make(map[uint32]string, len(configs))
+	for name, config := range configs {
+		if !namespaceName.MatchString(name) {
+			return fmt.Errorf("bad namespace name %q: only letters, digits, '-', and '_' allowed", name)
+		}
+		if config.IDPrefix == 0 {
+			continue
+		}
+		if config.IDPrefix > MaxInstancePrefix {
+			return fmt.Errorf("namespace %q instance id prefix %d is larger than the maximum of %d", name, config.IDPrefix, MaxInstancePrefix)
+		}
+		if other, found := prefixes[config.IDPrefix]; found {
+			return fmt.Errorf("namespaces %q and %q have the same instance id prefix %d", other, name, config.IDPrefix)
+		}
+		prefixes[config.IDPrefix] = name
+	}
+	return nil
+}
+
+// SetNamespaces replaces the defined namespaces after checking them like CheckNamespaces.
+// Repos in namespaces that are no longer defined keep their namespace, but it has no
+// quota, and reads of its repos aren't restricted.
+func SetNamespaces(configs map[string]NamespaceConfig) error {
+	if err := CheckNamespaces(configs); err != nil {
+		return err
+	}
+	copied := make(map[string]NamespaceConfig, len(configs))
+	for name, config := range configs {
+		copied[name] = config
+	}
+	namespaces.Lock()
+	namespaces.configs = copied
+	namespaces.Unlock()
+	return nil
+}
+
+// Namespaces returns the names of the defined namespaces in sorted order.
+func Namespaces() []string {
+	namespaces.RLock()
+	defer namespaces.RUnlock()
+	names := make([]string, 0, len(namespaces.configs))
+	for name := range namespaces.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetNamespace returns the settings of a defined namespace.
+func GetNamespace(name string) (NamespaceConfig, error) {
+	namespaces.RLock()
+	defer namespaces.RUnlock()
+	config, found := namespaces.configs[name]
+	if !found {
+		return NamespaceConfig{}, fmt.Errorf("no namespace %q is defined", name)
+	}
+	return config, nil
+}
+
+// namespaceIDPrefix returns the instance ID prefix of a namespace or 0 if it doesn't have
+// its own ID space.
+func namespaceIDPrefix(name string) uint32 {
+	if name == "" {
+		return 0
+	}
+	namespaces.RLock()
+	defer namespaces.RUnlock()
+	return namespaces.configs[name].IDPrefix
+}
+
+// namespace returns the repo's namespace or "" for the default namespace.  The caller
+// must hold the repo lock.
+func (r *repoT) namespace() string {
+	namespace, _ := r.properties[namespaceProperty].(string)
+	return namespace
+}
+
+// RepoNamespace returns the namespace of the repo with the given UUID or "" if the repo
+// is in the default namespace.
+func RepoNamespace(uuid dvid.UUID) (string, error) {
+	if manager == nil {
+		return "", ErrManagerNotInitialized
+	}
+	r, err := manager.repoFromUUIDLocked(uuid)
+	if err != nil {
+		return "", err
+	}
+	r.RLock()
+	defer r.RUnlock()
+	return r.namespace(), nil
+}
+
+// NewNamespaceRepo creates a repo in a defined namespace like NewRepo.  A
+// QuotaExceededError is returned if the namespace has used its storage quota.
+func NewNamespaceRepo(namespace, alias, description string, assign *dvid.UUID, passcode string) (dvid.UUID, error) {
+	if manager == nil {
+		return dvid.NilUUID, ErrManagerNotInitialized
+	}
+	if err := checkNamespaceQuota(namespace); err != nil {
+		return dvid.NilUUID, err
+	}
+	props := map[string]interface{}{namespaceProperty: namespace}
+	r, err := manager.newRepo(alias, description, assign, passcode, props)
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+	PublishEvent(Event{Type: EventNewRepo, Repo: r.uuid, UUID: r.uuid, Note: description})
+	return r.uuid, nil
+}
+
+// NamespaceRepos returns the root UUIDs of the repos in a namespace, or in the default
+// namespace if the name is "", in sorted order.
+func NamespaceRepos(namespace string) ([]dvid.UUID, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	if err := manager.pageInAll(); err != nil {
+		return nil, err
+	}
+	manager.RLock()
+	defer manager.RUnlock()
+	var uuids []dvid.UUID
+	for _, uuid := range manager.repoToUUID {
+		r, found := manager.repos[uuid]
+		if !found {
+			continue
+		}
+		r.RLock()
+		if r.namespace() == namespace {
+			uuids = append(uuids, uuid)
+		}
+		r.RUnlock()
+	}
+	sort.Slice(uuids, func(i, j int) bool { return uuids[i] < uuids[j] })
+	return uuids, nil
+}
+
+// GetNamespaceQuota returns the storage quota in bytes of a defined namespace and the
+// approximate bytes used by its repos.  A zero quota means there is no limit.
+func GetNamespaceQuota(namespace string) (quota, used uint64, err error) {
+	if manager == nil {
+		return 0, 0, ErrManagerNotInitialized
+	}
+	config, err := GetNamespace(namespace)
+	if err != nil {
+		return 0, 0, err
+	}
+	used, err = namespaceBytesUsed(namespace)
+	return config.Quota, used, err
+}
+
+// checkNamespaceQuota returns a QuotaExceededError if a namespace has used its quota.
+func checkNamespaceQuota(namespace string) error {
+	config, err := GetNamespace(namespace)
+	if err != nil || config.Quota == 0 {
+		return err
+	}
+	used, err := namespaceBytesUsed(namespace)
+	if err != nil {
+		return err
+	}
+	if used >= config.Quota {
+		return QuotaExceededError{Namespace: namespace, Quota: config.Quota, Used: used}
+	}
+	return nil
+}
+
+func namespaceBytesUsed(namespace string) (uint64, error) {
+	uuids, err := NamespaceRepos(namespace)
+	if err != nil {
+		return 0, err
+	}
+	var used uint64
+	for _, uuid := range uuids {
+		n, err := repoBytesUsed(uuid)
+		if err != nil {
+			return 0, err
+		}
+		used += n
+	}
+	return used, nil
+}
+
+// loadNamespaceIDs loads the next instance ID suffix of each namespace.
+func (m *repoManager) loadNamespaceIDs() error {
+	var ctx storage.MetadataContext
+	value, err := m.store.Get(ctx, storage.NewTKey(namespaceIDsKey, nil))
+	if err != nil || value == nil {
+		return err
+	}
+	return json.Unmarshal(value, &(m.namespaceIDs))
+}
+
+// namespaceIDsValue returns the stored value of the next instance ID suffix of each
+// namespace.  The caller must hold idMutex.
+func (m *repoManager) namespaceIDsValue() []byte {
+	value, _ := json.Marshal(m.namespaceIDs) // a map of strings to integers always encodes.
+	return value
+}
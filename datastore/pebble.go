@@ -0,0 +1,6 @@
+// +build pebble
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/pebble"
+import _ "github.com/janelia-flyem/dvid/storage/filelog"
@@ -0,0 +1,6 @@
+// +build postgres
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/postgres"
+import _ "github.com/janelia-flyem/dvid/storage/filelog"
@@ -173,6 +173,17 @@ func NotifySubscribers(e SyncEvent, m SyncMessage) error {
 		return err
 	}
 
+	RecordMutation(e.Data)
+
 	// Use the repo notification system to notify internal subscribers.
-	return repo.notifySubscribers(e, m)
+	if err := repo.notifySubscribers(e, m); err != nil {
+		return err
+	}
+	if EventsSubscribed() {
+		if uuid, err := manager.uuidFromVersion(m.Version); err == nil {
+			d, _ := manager.getDataByDataUUID(e.Data)
+			publishDataEvent(EventDataModified, uuid, d, e.Event)
+		}
+	}
+	return nil
 }
@@ -13,6 +13,7 @@
 package datastore
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"sync"
@@ -307,7 +308,7 @@ func PushData(d dvid.Data, p *PushSession) error {
 		}()
 
 		begKey, endKey := ctx.KeyRange()
-		if err = store.RawRangeQuery(begKey, endKey, keysOnly, ch, nil); err != nil {
+		if err = store.RawRangeQuery(context.Background(), begKey, endKey, keysOnly, ch); err != nil {
 			return fmt.Errorf("push voxels %q range query: %v", d.DataName(), err)
 		}
 	}
@@ -474,36 +475,11 @@ func (p *pusher) readRepo(m *repoTxMsg) (map[dvid.VersionID]struct{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	repoID, err := manager.newRepoID()
-	if err != nil {
-		return nil, err
-	}
-	p.repo.id = repoID
-
-	p.instanceMap, p.versionMap, err = p.repo.remapLocalIDs()
+	p.instanceMap, p.versionMap, err = p.repo.localize()
 	if err != nil {
 		return nil, err
 	}
 
-	// After getting remote repo, adjust data instances for local settings.
-	for _, d := range p.repo.data {
-		// see if it needs to adjust versions.
-		dv, needsUpdate := d.(VersionRemapper)
-		if needsUpdate {
-			if err := dv.RemapVersions(p.versionMap); err != nil {
-				return nil, err
-			}
-		}
-
-		// check if we have an assigned store for this data instance.
-		store, err := storage.GetAssignedStore(d.DataName(), d.RootUUID(), d.TypeName())
-		if err != nil {
-			return nil, err
-		}
-		d.SetKVStore(store)
-		dvid.Debugf("Assigning as default store of data instance %q @ %s: %s\n", d.DataName(), d.RootUUID(), store)
-	}
-
 	var versions map[dvid.VersionID]struct{}
 	switch m.Transmit {
 	case rpc.TransmitFlatten:
@@ -536,6 +512,53 @@ func (p *pusher) readRepo(m *repoTxMsg) (map[dvid.VersionID]struct{}, error) {
 	return versions, nil
 }
 
+// localize gives a repo received from another server new local repo, instance, and
+// version IDs and assigns its data instances to local stores, returning the maps from
+// the received to the new local IDs.
+func (r *repoT) localize() (dvid.InstanceMap, dvid.VersionMap, error) {
+	repoID, err := manager.newRepoID()
+	if err != nil {
+		return nil, nil, err
+	}
+	r.id = repoID
+	r.revision = 0
+
+	// Trashed data instances and their key-value pairs aren't transferred.
+	r.trash = make(map[dvid.UUID]trashedData)
+
+	instanceMap, versionMap, err := r.remapLocalIDs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// After getting remote repo, adjust data instances for local settings.
+	for _, d := range r.data {
+		// see if it needs to adjust versions.
+		dv, needsUpdate := d.(VersionRemapper)
+		if needsUpdate {
+			if err := dv.RemapVersions(versionMap); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		// check if we have an assigned store for this data instance.  A store chosen
+		// on the remote server may not exist here, and received data is written to the
+		// store assigned by this server's backend configuration.
+		if ca, ok := d.(interface {
+			ClearStoreAlias()
+		}); ok {
+			ca.ClearStoreAlias()
+		}
+		store, err := storage.GetAssignedStore(d.DataName(), d.RootUUID(), d.TypeName())
+		if err != nil {
+			return nil, nil, err
+		}
+		d.SetKVStore(store)
+		dvid.Debugf("Assigning as default store of data instance %q @ %s: %s\n", d.DataName(), d.RootUUID(), store)
+	}
+	return instanceMap, versionMap, nil
+}
+
 // compares remote Repo with local one, determining a list of versions that
 // need to be sent from remote to bring the local DVID up-to-date.
 func getDeltaAll(remote *repoT, uuid dvid.UUID) (map[dvid.VersionID]struct{}, error) {
@@ -0,0 +1,186 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports per-repo storage quotas.  A repo's usage is the approximate size of
+	its data instances in their stores, which is periodically measured, plus the bytes
+	written to the repo since the last measurement.  Repos in a namespace are also limited
+	by any quota of the namespace (see namespace_local.go).
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// quotaProperty is the repo property holding the storage quota in bytes.
+const quotaProperty = "storage-quota"
+
+// QuotaRefresh is how often a repo's usage is measured from its stores.
+var QuotaRefresh = 10 * time.Minute
+
+// QuotaExceededError is returned when a write would exceed the storage quota of a repo
+// or its namespace.
+type QuotaExceededError struct {
+	Repo      dvid.UUID // root UUID of the repo
+	Namespace string    // set if the namespace's quota was exceeded
+	Quota     uint64
+	Used      uint64
+}
+
+func (e QuotaExceededError) Error() string {
+	if e.Namespace != "" {
+		return fmt.Sprintf("namespace %q has used %s of its %s storage quota", e.Namespace,
+			humanize.Bytes(e.Used), humanize.Bytes(e.Quota))
+	}
+	return fmt.Sprintf("repo %s has used %s of its %s storage quota", e.Repo,
+		humanize.Bytes(e.Used), humanize.Bytes(e.Quota))
+}
+
+type repoUsageT struct {
+	measured time.Time
+	stored   uint64 // approximate bytes in stores at last measurement.
+	written  uint64 // bytes written since last measurement.
+}
+
+var (
+	usageMu    sync.Mutex
+	repoUsages map[dvid.UUID]*repoUsageT
+)
+
+// SetRepoQuota sets the storage quota in bytes for the repo with the given UUID.  A zero
+// quota removes any limit.
+func SetRepoQuota(uuid dvid.UUID, quota uint64) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	return manager.setRepoProperty(uuid, quotaProperty, quota)
+}
+
+// GetRepoQuota returns the storage quota in bytes and the approximate bytes used by the
+// repo with the given UUID.  A zero quota means there is no limit.
+func GetRepoQuota(uuid dvid.UUID) (quota, used uint64, err error) {
+	if manager == nil {
+		return 0, 0, ErrManagerNotInitialized
+	}
+	if quota, err = repoQuota(uuid); err != nil {
+		return
+	}
+	used, err = repoBytesUsed(uuid)
+	return
+}
+
+// CheckRepoQuota returns a QuotaExceededError if the repo with the given UUID or its
+// namespace has used all of its storage quota.
+func CheckRepoQuota(uuid dvid.UUID) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	quota, err := repoQuota(uuid)
+	if err != nil {
+		return err
+	}
+	if quota != 0 {
+		used, err := repoBytesUsed(uuid)
+		if err != nil {
+			return err
+		}
+		if used >= quota {
+			root, err := manager.getRepoRoot(uuid)
+			if err != nil {
+				return err
+			}
+			return QuotaExceededError{Repo: root, Quota: quota, Used: used}
+		}
+	}
+	namespace, err := RepoNamespace(uuid)
+	if err != nil || namespace == "" {
+		return err
+	}
+	if _, err := GetNamespace(namespace); err != nil {
+		return nil // namespaces no longer defined have no quota.
+	}
+	if err := checkNamespaceQuota(namespace); err != nil {
+		if qerr, ok := err.(QuotaExceededError); ok {
+			qerr.Repo, _ = manager.getRepoRoot(uuid)
+			return qerr
+		}
+		return err
+	}
+	return nil
+}
+
+// AddRepoBytes accounts for bytes written to the repo with the given UUID since its usage
+// was last measured.
+func AddRepoBytes(uuid dvid.UUID, n uint64) {
+	if manager == nil {
+		return
+	}
+	root, err := manager.getRepoRoot(uuid)
+	if err != nil {
+		return
+	}
+	usageMu.Lock()
+	if usage, found := repoUsages[root]; found {
+		usage.written += n
+	}
+	usageMu.Unlock()
+}
+
+func repoQuota(uuid dvid.UUID) (uint64, error) {
+	value, err := manager.getRepoProperty(uuid, quotaProperty)
+	if err != nil || value == nil {
+		return 0, err
+	}
+	quota, ok := value.(uint64)
+	if !ok {
+		return 0, fmt.Errorf("bad storage quota for repo %s: %v", uuid, value)
+	}
+	return quota, nil
+}
+
+// repoBytesUsed returns the approximate bytes used by a repo, measuring its stores if the
+// last measurement is stale.
+func repoBytesUsed(uuid dvid.UUID) (uint64, error) {
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return 0, err
+	}
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	if repoUsages == nil {
+		repoUsages = make(map[dvid.UUID]*repoUsageT)
+	}
+	usage, found := repoUsages[r.uuid]
+	if found && time.Since(usage.measured) < QuotaRefresh {
+		return usage.stored + usage.written, nil
+	}
+
+	r.RLock()
+	instances := make([]DataService, 0, len(r.data))
+	for _, d := range r.data {
+		instances = append(instances, d)
+	}
+	r.RUnlock()
+	sizes, errs := instanceSizes(instances)
+	var stored uint64
+	for _, size := range sizes {
+		stored += size
+	}
+	unmeasured := len(errs) != 0
+	for id, err := range errs {
+		dvid.Errorf("unable to measure repo %s usage of instance %d: %v\n", r.uuid, id, err)
+	}
+
+	// Stores that can't be measured only have their writes accounted, so keep the total.
+	if found && unmeasured && usage.stored+usage.written > stored {
+		stored = usage.stored + usage.written
+	}
+	repoUsages[r.uuid] = &repoUsageT{measured: time.Now(), stored: stored}
+	return stored, nil
+}
@@ -0,0 +1,6 @@
+// +build redis
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/redis"
+import _ "github.com/janelia-flyem/dvid/storage/filelog"
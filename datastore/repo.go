@@ -7,7 +7,10 @@
 
 package datastore
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // MergeType describes the expectation of processing for the merge, e.g., is it
 // expected to be free of conflicts at the key-value level, require automated
@@ -27,6 +30,44 @@ const (
 	MergeExternalData
 )
 
+// MergeStrategy describes how key-value pairs that differ between parents are resolved
+// in the child of a merge.
+type MergeStrategy uint8
+
+const (
+	// MergeOurs resolves conflicts in favor of the earliest parent in the merge list.
+	MergeOurs MergeStrategy = iota
+
+	// MergeTheirs resolves conflicts in favor of the latest parent in the merge list.
+	MergeTheirs
+
+	// MergeConflictError fails the merge if any key-value pair conflicts.
+	MergeConflictError
+)
+
+func (s MergeStrategy) String() string {
+	switch s {
+	case MergeOurs:
+		return "ours"
+	case MergeTheirs:
+		return "theirs"
+	case MergeConflictError:
+		return "conflict-error"
+	default:
+		return fmt.Sprintf("merge strategy %d", s)
+	}
+}
+
+// ParseMergeStrategy returns the MergeStrategy with the given name.
+func ParseMergeStrategy(s string) (MergeStrategy, error) {
+	for strategy := MergeOurs; strategy <= MergeConflictError; strategy++ {
+		if s == strategy.String() {
+			return strategy, nil
+		}
+	}
+	return MergeOurs, fmt.Errorf("unknown merge strategy %q, must be ours, theirs, or conflict-error", s)
+}
+
 var (
 	ErrManagerNotInitialized = errors.New("datastore repo manager not initialized")
 	ErrBadMergeType          = errors.New("bad merge type")
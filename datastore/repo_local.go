@@ -16,20 +16,22 @@ package datastore
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
-	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/storage"
 )
 
-// The current repo metadata format version
-const RepoFormatVersion = 1
+// The current repo metadata format version.  See migrate_local.go for how to change it.
+const RepoFormatVersion = 2
 
 // Key space handling for metadata
 const (
@@ -39,7 +41,18 @@ const (
 	newIDsKey
 	repoKey
 	formatKey
-	ServerLockKey // name of key for locking metadata globally
+	ServerLockKey   // name of key for locking metadata globally
+	zstdDictKey     // zstd dictionaries keyed by dictionary ID
+	repoRevisionKey // revision of each repo's metadata, incremented on every save
+	repoSummaryKey  // versions of each repo for lazy loading
+	repoFormatKey   // format version of each repo's metadata
+	apiTokenKey     // API tokens keyed by token ID
+	repoRolesKey    // role assignments of each repo
+	uploadKey       // upload sessions keyed by session ID
+	uploadBytesKey  // bytes received by upload sessions keyed by session ID and range
+	namespaceIDsKey // next instance ID suffix of each namespace with its own ID space
+	auditKey        // audit records of mutating requests keyed by record ID
+	repoSaveLockKey // lock held while checking and writing a repo's revision
 )
 
 func Close() error {
@@ -48,10 +61,22 @@ func Close() error {
 	return nil
 }
 
-// InstanceConfig specifies how new instance IDs are generated
+// InstanceConfig specifies how new instance IDs are generated, the default
+// compression and checksum of new data instances, and how long trashed data
+// instances are kept.
 type InstanceConfig struct {
-	Gen   string
-	Start dvid.InstanceID
+	Gen    string          // "sequential" (default), "random", or "prefixed"
+	Start  dvid.InstanceID // minimum of sequentially allocated ids or their suffixes
+	Prefix uint32          // server prefix of ids for "prefixed" allocation
+
+	Compression string
+	Checksum    string
+
+	TrashRetention time.Duration // if zero, DefaultTrashRetention is used.
+
+	// LazyLoad defers loading repos until they are first accessed, with all repos
+	// paged in the background after startup.
+	LazyLoad bool
 }
 
 // Initialize creates a repositories manager that is handled through package functions.
@@ -65,11 +90,12 @@ func Initialize(initMetadata bool, iconfig *InstanceConfig) error {
 		versionID:       1,
 		iids:            make(map[dvid.InstanceID]DataService),
 		dataByUUID:      make(map[dvid.UUID]DataService),
-		instanceIDGen:   iconfig.Gen,
 		instanceIDStart: iconfig.Start,
+		lazy:            iconfig.LazyLoad,
 	}
-	if iconfig.Gen == "" {
-		m.instanceIDGen = "sequential"
+	var err error
+	if m.instanceIDs, err = NewInstanceIDAllocator(iconfig.Gen, iconfig.Prefix); err != nil {
+		return err
 	}
 	if iconfig.Start > 1 {
 		m.instanceID = iconfig.Start
@@ -77,7 +103,15 @@ func Initialize(initMetadata bool, iconfig *InstanceConfig) error {
 		m.instanceID = 1
 	}
 
-	var err error
+	if err := SetInstanceDefaults(iconfig.Compression, iconfig.Checksum); err != nil {
+		return err
+	}
+	if iconfig.TrashRetention > 0 {
+		TrashRetention = iconfig.TrashRetention
+	} else {
+		TrashRetention = DefaultTrashRetention
+	}
+
 	m.store, err = storage.MetaDataKVStore()
 	if err != nil {
 		return err
@@ -86,6 +120,7 @@ func Initialize(initMetadata bool, iconfig *InstanceConfig) error {
 
 	// Set the package variable.  We are good to go...
 	manager = m
+	defer startTrashPurge.Do(func() { go purgeTrashPeriodically() })
 	m.Lock()
 	defer m.Unlock()
 	m.idMutex.Lock()
@@ -105,29 +140,33 @@ func Initialize(initMetadata bool, iconfig *InstanceConfig) error {
 	if initMetadata {
 		// Initialize repo management data in storage
 		dvid.Infof("Initializing repo management data in storage...\n")
-		if err := m.putNewIDs(); err != nil {
-			return err
-		}
 		if err := m.putCaches(); err != nil {
 			return err
 		}
 		m.formatVersion = RepoFormatVersion
+		if err := m.putData(formatKey, &(m.formatVersion)); err != nil {
+			return err
+		}
 	} else {
 		// Load the repo metadata
 		dvid.Infof("Loading metadata from storage...\n")
 		if err = m.loadMetadata(); err != nil {
 			return fmt.Errorf("Error loading metadata: %v", err)
 		}
+		if m.lazy {
+			go m.pageInRepos()
+		}
 	}
 	return nil
 }
 
-// MetadataUniversalLock locks shared databases (currently those implementing transactions)
+// MetadataUniversalLock locks shared databases (currently those implementing transactions
+// that other servers can use)
 func MetadataUniversalLock() error {
 	// if db supports transaction, apply a system-wide lock and reload meta
 	store, _ := storage.MetaDataKVStore()
-	transdb, hastrans := store.(storage.TransactionDB)
-	if hastrans {
+	if storage.IsShared(store) {
+		transdb := store.(storage.TransactionDB)
 		var ctx storage.MetadataContext
 		key := ctx.ConstructKey(storage.NewTKey(ServerLockKey, nil))
 		// TODO: automatically remove stale locks
@@ -147,8 +186,8 @@ func MetadataUniversalLock() error {
 // MetadataUniversalUnlock releases the shared lock
 func MetadataUniversalUnlock() {
 	store, _ := storage.MetaDataKVStore()
-	transdb, hastrans := store.(storage.TransactionDB)
-	if hastrans {
+	if storage.IsShared(store) {
+		transdb := store.(storage.TransactionDB)
 		var ctx storage.MetadataContext
 		key := ctx.ConstructKey(storage.NewTKey(ServerLockKey, nil))
 		transdb.UnlockKey(key)
@@ -170,7 +209,7 @@ func ReloadMetadata() error {
 		versionID:       manager.versionID,
 		iids:            make(map[dvid.InstanceID]DataService),
 		dataByUUID:      make(map[dvid.UUID]DataService),
-		instanceIDGen:   manager.instanceIDGen,
+		instanceIDs:     manager.instanceIDs,
 		instanceIDStart: manager.instanceIDStart,
 	}
 
@@ -239,14 +278,41 @@ type repoManager struct {
 	dataByUUID map[dvid.UUID]DataService
 
 	// instance id generation
-	instanceIDGen   string
+	instanceIDs     InstanceIDAllocator
 	instanceIDStart dvid.InstanceID
 
+	// Next instance ID suffixes of namespaces with their own ID space, protected by idMutex.
+	namespaceIDs map[string]dvid.InstanceID
+
 	// Verified metadata storage for ease of use.
 	store storage.OrderedKeyValueDB
 
 	// Mutexes for concurrent use of ids and their maps.
 	idMutex sync.RWMutex
+
+	// Confirmation tokens of requested repo deletions, keyed by root UUID and
+	// protected by the broad mutex.
+	deleteTokens map[dvid.UUID]deleteToken
+
+	// If lazy, repos are added as stubs on load and paged in on first access.
+	lazy bool
+
+	// API tokens keyed by token ID, protected by their own mutex.
+	tokenMutex sync.RWMutex
+	tokens     map[string]*apiTokenRecord
+
+	// Role assignments of repos with restricted access, protected by their own mutex.
+	roleMutex sync.RWMutex
+	roles     map[dvid.RepoID]map[string]Role
+
+	// Serializes updates of upload sessions.
+	uploadMutex sync.Mutex
+}
+
+// deleteToken is a single-use confirmation token for a repo deletion.
+type deleteToken struct {
+	token   string
+	expires time.Time
 }
 
 func (m *repoManager) Shutdown() {
@@ -353,12 +419,101 @@ func (m *repoManager) loadData(t storage.TKeyClass, data interface{}) (found boo
 
 func (m *repoManager) putData(t storage.TKeyClass, data interface{}) error {
 	var ctx storage.MetadataContext
+	value, err := encodeData(data)
+	if err != nil {
+		return err
+	}
+	return m.store.Put(ctx, storage.NewTKey(t, nil), value)
+}
+
+func encodeData(data interface{}) ([]byte, error) {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 	if err := enc.Encode(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// newMetadataBatch returns a batch for writing several metadata keys together.  If the
+// metadata store supports batches, which are atomic for stores like leveldb, a crash can't
+// leave some of the keys updated and others not.  Otherwise the batch's operations are
+// written in order when it's committed.
+func (m *repoManager) newMetadataBatch() storage.Batch {
+	var ctx storage.MetadataContext
+	if batcher, ok := m.store.(storage.KeyValueBatcher); ok {
+		return batcher.NewBatch(ctx)
+	}
+	return &orderedBatch{store: m.store}
+}
+
+// orderedBatch writes its operations in order for metadata stores without batches.
+type orderedBatch struct {
+	store storage.KeyValueDB
+	ops   []orderedBatchOp
+}
+
+type orderedBatchOp struct {
+	tk     storage.TKey
+	v      []byte
+	delete bool
+}
+
+func (b *orderedBatch) Delete(tk storage.TKey) {
+	b.ops = append(b.ops, orderedBatchOp{tk: tk, delete: true})
+}
+
+func (b *orderedBatch) Put(tk storage.TKey, v []byte) {
+	b.ops = append(b.ops, orderedBatchOp{tk: tk, v: v})
+}
+
+func (b *orderedBatch) Commit() error {
+	var ctx storage.MetadataContext
+	for _, op := range b.ops {
+		var err error
+		if op.delete {
+			err = b.store.Delete(ctx, op.tk)
+		} else {
+			err = b.store.Put(ctx, op.tk, op.v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	b.ops = nil
+	return nil
+}
+
+// Load and register all zstd dictionaries.
+func (m *repoManager) loadZstdDicts() error {
+	var ctx storage.MetadataContext
+	kvs, err := m.store.GetRange(ctx, storage.MinTKey(zstdDictKey), storage.MaxTKey(zstdDictKey))
+	if err != nil {
 		return err
 	}
-	return m.store.Put(ctx, storage.NewTKey(t, nil), buf.Bytes())
+	for _, kv := range kvs {
+		if _, err := dvid.RegisterZstdDict(kv.V); err != nil {
+			return fmt.Errorf("bad zstd dictionary in metadata: %v", err)
+		}
+	}
+	if len(kvs) != 0 {
+		dvid.Infof("Loaded %d zstd dictionaries from metadata store.\n", len(kvs))
+	}
+	return nil
+}
+
+func (m *repoManager) putZstdDict(dict []byte) (uint32, error) {
+	id, err := dvid.RegisterZstdDict(dict)
+	if err != nil {
+		return 0, err
+	}
+	var ctx storage.MetadataContext
+	idBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(idBytes, id)
+	if err := m.store.Put(ctx, storage.NewTKey(zstdDictKey, idBytes), dict); err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
 // Load the next ids to be used for RepoID, VersionID, and InstanceID.
@@ -382,19 +537,31 @@ func (m *repoManager) loadNewIDs() error {
 
 func (m *repoManager) putNewIDs() error {
 	var ctx storage.MetadataContext
+	return m.store.Put(ctx, storage.NewTKey(newIDsKey, nil), m.newIDsValue())
+}
+
+// newIDsValue returns the stored value of the next ids.  The caller must hold idMutex.
+func (m *repoManager) newIDsValue() []byte {
 	value := append(m.repoID.Bytes(), m.versionID.Bytes()...)
-	value = append(value, m.instanceID.Bytes()...)
-	return m.store.Put(ctx, storage.NewTKey(newIDsKey, nil), value)
+	return append(value, m.instanceID.Bytes()...)
 }
 
+// putCaches writes the repo and version maps along with the next ids in one batch so
+// they're consistent after a crash.
 func (m *repoManager) putCaches() error {
-	if err := m.putData(repoToUUIDKey, m.repoToUUID); err != nil {
+	repoToUUID, err := encodeData(m.repoToUUID)
+	if err != nil {
 		return err
 	}
-	if err := m.putData(versionToUUIDKey, m.versionToUUID); err != nil {
+	versionToUUID, err := encodeData(m.versionToUUID)
+	if err != nil {
 		return err
 	}
-	return nil
+	batch := m.newMetadataBatch()
+	batch.Put(storage.NewTKey(repoToUUIDKey, nil), repoToUUID)
+	batch.Put(storage.NewTKey(versionToUUIDKey, nil), versionToUUID)
+	batch.Put(storage.NewTKey(newIDsKey, nil), m.newIDsValue())
+	return batch.Commit()
 }
 
 func (m *repoManager) loadVersion0() error {
@@ -408,225 +575,280 @@ func (m *repoManager) loadVersion0() error {
 	if err := m.loadNewIDs(); err != nil {
 		return fmt.Errorf("Error loading new local ids: %s", err)
 	}
+	if err := m.loadNamespaceIDs(); err != nil {
+		return fmt.Errorf("Error loading namespace instance ids: %s", err)
+	}
+	if err := m.loadZstdDicts(); err != nil {
+		return fmt.Errorf("Error loading zstd dictionaries: %s", err)
+	}
+	if err := m.loadAPITokens(); err != nil {
+		return fmt.Errorf("Error loading API tokens: %s", err)
+	}
+	if err := m.loadRepoRoles(); err != nil {
+		return fmt.Errorf("Error loading repo roles: %s", err)
+	}
 
 	// Generate the inverse UUID to VersionID mapping.
 	for v, uuid := range m.versionToUUID {
 		m.uuidToVersion[uuid] = v
 	}
 
-	// Load all the repo data
-	var ctx storage.MetadataContext
-	minRepo := dvid.RepoID(0)
-	maxRepo := dvid.RepoID(dvid.MaxRepoID)
+	// Load all the repo data, or only stubs for lazily loaded repos.
+	var saveCache bool
+	if m.lazy {
+		var err error
+		if saveCache, err = m.loadRepoStubs(); err != nil {
+			return err
+		}
+	} else {
+		var ctx storage.MetadataContext
+		minRepo := dvid.RepoID(0)
+		maxRepo := dvid.RepoID(dvid.MaxRepoID)
 
-	minTKey := storage.NewTKey(repoKey, minRepo.Bytes())
-	maxTKey := storage.NewTKey(repoKey, maxRepo.Bytes())
-	kvList, err := m.store.GetRange(ctx, minTKey, maxTKey)
-	if err != nil {
+		minTKey := storage.NewTKey(repoKey, minRepo.Bytes())
+		maxTKey := storage.NewTKey(repoKey, maxRepo.Bytes())
+		kvList, err := m.store.GetRange(ctx, minTKey, maxTKey)
+		if err != nil {
+			return err
+		}
+		for _, kv := range kvList {
+			ibytes, err := kv.K.ClassBytes(repoKey)
+			if err != nil {
+				return err
+			}
+			repaired, err := m.loadRepo(dvid.RepoIDFromBytes(ibytes), kv.V)
+			if err != nil {
+				return err
+			}
+			saveCache = saveCache || repaired
+		}
+	}
+	if err := m.verifyCompiledTypes(); err != nil {
 		return err
 	}
 
-	var saveCache bool
-	for _, kv := range kvList {
-		var saveRepo bool
+	for id, uuid := range m.repoToUUID {
+		if m.repos[uuid] == nil {
+			dvid.Infof("Found empty repo id %d (uuid %s)... deleting.\n", id, uuid)
+			delete(m.repoToUUID, id)
+			saveCache = true
+		}
+	}
 
-		ibytes, err := kv.K.ClassBytes(repoKey)
-		if err != nil {
+	// If we noticed missing or corrupt cache entries, save current metadata.
+	if saveCache {
+		if err := m.putCaches(); err != nil {
 			return err
 		}
-		repoID := dvid.RepoIDFromBytes(ibytes)
+	}
+	dvid.Infof("Loaded %d repositories from metadata store.", len(m.repos))
+	return nil
+}
 
-		// Load each repo
-		_, found := m.repoToUUID[repoID]
+// loadRepo decodes a stored repo, initializing and migrating its data instances, and
+// adds it to the manager in place of any stub.  It returns true if the manager's version
+// caches were repaired and should be saved.  The caller must hold the manager's locks.
+func (m *repoManager) loadRepo(repoID dvid.RepoID, value []byte) (saveCache bool, err error) {
+	var saveRepo bool
+
+	_, found := m.repoToUUID[repoID]
+	if !found {
+		return false, fmt.Errorf("Retrieved repo with id %d that is not in map.  Corrupt DB?", repoID)
+	}
+	r := &repoT{
+		log:        []string{},
+		properties: make(map[string]interface{}),
+		data:       make(map[dvid.InstanceName]DataService),
+	}
+	if err = dvid.Deserialize(value, r); err != nil {
+		return false, fmt.Errorf("Error gob decoding repo %d: %v", repoID, err)
+	}
+	if err = r.loadRevision(m.store); err != nil {
+		return false, fmt.Errorf("Error loading revision of repo %d: %v", repoID, err)
+	}
+	if err = r.loadFormatVersion(m.store); err != nil {
+		return false, fmt.Errorf("Error loading format version of repo %d: %v", repoID, err)
+	}
+	storedVersion, err := r.migrate()
+	if err != nil {
+		return false, err
+	}
+	if storedVersion != RepoFormatVersion {
+		saveRepo = true
+	}
+
+	// Cache all UUID from nodes into our high-level cache.  The repo is added to the
+	// manager after its data instances are initialized.
+	var dagVersions []dvid.VersionID
+	var uuids []dvid.UUID
+	for v, node := range r.dag.nodes {
+		dagVersions = append(dagVersions, v)
+		uuid, found := m.versionToUUID[v]
 		if !found {
-			return fmt.Errorf("Retrieved repo with id %d that is not in map.  Corrupt DB?", repoID)
-		}
-		r := &repoT{
-			log:        []string{},
-			properties: make(map[string]interface{}),
-			data:       make(map[dvid.InstanceName]DataService),
+			dvid.Errorf("Version id %d found in repo %s (id %d) not in cache map. Adding it...", v, r.uuid, r.id)
+			m.versionToUUID[v] = node.uuid
+			m.uuidToVersion[node.uuid] = v
+			uuid = node.uuid
+			saveCache = true
 		}
-		if err = dvid.Deserialize(kv.V, r); err != nil {
-			return fmt.Errorf("Error gob decoding repo %d: %v", repoID, err)
+		uuids = append(uuids, uuid)
+	}
+
+	// Populate the instance id -> dataservice map and convert/upgrade any deprecated data instance.
+	for dataname, dataservice := range r.data {
+
+		dataUUID := dataservice.DataUUID()
+		if dataUUID == "" {
+			dataUUID = dvid.NewUUID()
+			dataservice.SetDataUUID(dataUUID)
+			dvid.Infof("Assigned data %q to data UUID %s.\n", dataname, dataservice.DataUUID())
+			saveRepo = true
 		}
 
-		// Cache all UUID from nodes into our high-level cache
-		var dagVersions []dvid.VersionID
-		for v, node := range r.dag.nodes {
-			dagVersions = append(dagVersions, v)
-			uuid, found := m.versionToUUID[v]
-			if !found {
-				dvid.Errorf("Version id %d found in repo %s (id %d) not in cache map. Adding it...", v, r.uuid, r.id)
-				m.versionToUUID[v] = node.uuid
-				m.uuidToVersion[node.uuid] = v
-				uuid = node.uuid
-				saveCache = true
+		migrator, doMigrate := dataservice.(TypeMigrator)
+		if doMigrate {
+			dvid.Infof("Migrating instance %q of type %q to ...\n", dataservice.DataName(), dataservice.TypeName())
+			dataservice, err = migrator.MigrateData(dagVersions)
+			if err != nil {
+				return false, fmt.Errorf("Error migrating data instance: %v", err)
 			}
-			m.repos[uuid] = r
+			r.data[dataname] = dataservice
+			saveRepo = true
+			dvid.Infof("Now instance %q of type %q ...\n", dataservice.DataName(), dataservice.TypeName())
 		}
 
-		// Populate the instance id -> dataservice map and convert/upgrade any deprecated data instance.
-		for dataname, dataservice := range r.data {
-
-			dataUUID := dataservice.DataUUID()
-			if dataUUID == "" {
-				dataUUID = dvid.NewUUID()
-				dataservice.SetDataUUID(dataUUID)
-				dvid.Infof("Assigned data %q to data UUID %s.\n", dataname, dataservice.DataUUID())
-				saveRepo = true
+		upgrader, upgradable := dataservice.(TypeUpgrader)
+		if upgradable {
+			oldV := dataservice.TypeVersion()
+			dvid.Infof("Upgrading instance %q, type %q from version %s...\n", dataservice.DataName(), dataservice.TypeName(), oldV)
+			upgraded, err := upgrader.UpgradeData()
+			if err != nil {
+				return false, fmt.Errorf("Error upgrading data instance %q: %v", dataservice.DataName(), err)
 			}
-
-			migrator, doMigrate := dataservice.(TypeMigrator)
-			if doMigrate {
-				dvid.Infof("Migrating instance %q of type %q to ...\n", dataservice.DataName(), dataservice.TypeName())
-				dataservice, err = migrator.MigrateData(dagVersions)
-				if err != nil {
-					return fmt.Errorf("Error migrating data instance: %v", err)
-				}
-				r.data[dataname] = dataservice
+			if upgraded {
 				saveRepo = true
-				dvid.Infof("Now instance %q of type %q ...\n", dataservice.DataName(), dataservice.TypeName())
+				dvid.Infof("Upgraded instance %q, type %q from version %s to %s\n", dataservice.DataName(), dataservice.TypeName(), oldV, dataservice.TypeVersion())
 			}
+		}
 
-			upgrader, upgradable := dataservice.(TypeUpgrader)
-			if upgradable {
-				oldV := dataservice.TypeVersion()
-				dvid.Infof("Upgrading instance %q, type %q from version %s...\n", dataservice.DataName(), dataservice.TypeName(), oldV)
-				upgraded, err := upgrader.UpgradeData()
-				if err != nil {
-					return fmt.Errorf("Error upgrading data instance %q: %v", dataservice.DataName(), err)
-				}
-				if upgraded {
-					saveRepo = true
-					dvid.Infof("Upgraded instance %q, type %q from version %s to %s\n", dataservice.DataName(), dataservice.TypeName(), oldV, dataservice.TypeVersion())
-				}
-			}
+		m.iids[dataservice.InstanceID()] = dataservice
+		m.dataByUUID[dataservice.DataUUID()] = dataservice
 
-			m.iids[dataservice.InstanceID()] = dataservice
-			m.dataByUUID[dataservice.DataUUID()] = dataservice
+		// Cache the assigned store, which may have been chosen on creation.
+		store, err := assignedStore(dataservice)
+		if err != nil {
+			return false, err
+		}
+		dataservice.SetKVStore(store)
 
-			// Cache the assigned store.
-			typename := dataservice.TypeName()
-			store, err := storage.GetAssignedStore(dataname, dataservice.RootUUID(), typename)
+		// Initialize any dataservice that's initializable, e.g., start sync processing goroutines.
+		initializer, initializable := dataservice.(DataInitializer)
+		if initializable {
+			err := initializer.InitDataHandlers()
 			if err != nil {
-				return err
+				return false, err
 			}
-			dataservice.SetKVStore(store)
+			dvid.Infof("Initialized data handlers for instance %q on repo load.\n", dataservice.DataName())
+		}
+	}
 
-			// Initialize any dataservice that's initializable, e.g., start sync processing goroutines.
-			initializer, initializable := dataservice.(DataInitializer)
-			if initializable {
-				err := initializer.InitDataHandlers()
-				if err != nil {
-					return err
-				}
-				dvid.Infof("Initialized data handlers for instance %q on repo load.\n", dataservice.DataName())
+	// Trashed data instances keep their ids and stores so they can be restored or purged.
+	for _, t := range r.trash {
+		m.iids[t.Data.InstanceID()] = t.Data
+		m.dataByUUID[t.Data.DataUUID()] = t.Data
+		store, err := assignedStore(t.Data)
+		if err != nil {
+			return false, err
+		}
+		t.Data.SetKVStore(store)
+		if initializer, initializable := t.Data.(DataInitializer); initializable {
+			if err := initializer.InitDataHandlers(); err != nil {
+				return false, err
 			}
 		}
+	}
 
-		// Recreate the sync graph for this repo, taking into account possible legacy sync names.
-		for _, dataservice := range r.data {
-			syncer, syncable := dataservice.(Syncer)
-			if syncable {
-				syncUUIDs := syncer.SyncedData()
-				if len(syncUUIDs) != 0 {
-					for u := range syncUUIDs {
-						// get the dataservice associated with this synced data.
-						syncedData, found := m.dataByUUID[u]
-						if found {
-							subs, err := syncer.GetSyncSubs(syncedData)
-							if err != nil {
-								dvid.Criticalf("Skipping bad sync of data %q to data %q: %v\n", dataservice.DataName(), syncedData.DataName(), err)
-								continue
-							}
-							r.addSyncGraph(subs)
-						} else {
-							dvid.Errorf("Skipping bad sync of %q with missing data uuid %s", dataservice.DataName(), u)
+	// Recreate the sync graph for this repo, taking into account possible legacy sync names.
+	for _, dataservice := range r.data {
+		syncer, syncable := dataservice.(Syncer)
+		if syncable {
+			syncUUIDs := syncer.SyncedData()
+			if len(syncUUIDs) != 0 {
+				for u := range syncUUIDs {
+					// get the dataservice associated with this synced data.
+					syncedData, found := m.dataByUUID[u]
+					if found {
+						subs, err := syncer.GetSyncSubs(syncedData)
+						if err != nil {
+							dvid.Criticalf("Skipping bad sync of data %q to data %q: %v\n", dataservice.DataName(), syncedData.DataName(), err)
+							continue
 						}
+						r.addSyncGraph(subs)
+					} else {
+						dvid.Errorf("Skipping bad sync of %q with missing data uuid %s", dataservice.DataName(), u)
 					}
-				} else {
-					// TODO: Remove when we no longer have to support legacy dvid installs.
-					syncNames := syncer.SyncedNames()
-					if len(syncNames) == 0 {
-						continue
-					}
-					dvid.Infof("Converting data %q %d legacy sync names to data UUIDs...\n", dataservice.DataName(), len(syncNames))
-					syncs := dvid.UUIDSet{}
-					for _, name := range syncNames {
-						// get the dataservice associated with this synced data.
-						syncedData, found := r.data[name]
-						if found {
-							subs, err := syncer.GetSyncSubs(syncedData)
-							if err != nil {
-								dvid.Criticalf("Skipping bad sync of data %q to data %q: %v\n", dataservice.DataName(), syncedData.DataName(), err)
-								continue
-							}
-							r.addSyncGraph(subs)
-							// convert the sync names to data UUIDs
-							syncs[syncedData.DataUUID()] = struct{}{}
-							dvid.Infof("  Converted synced data %q to its UUID: %s\n", name, syncedData.DataUUID())
-						} else {
-							dvid.Errorf(" Skipping sync of %q with missing data %q for repo @ %s", dataservice.DataName(), name, r.uuid)
+				}
+			} else {
+				// TODO: Remove when we no longer have to support legacy dvid installs.
+				syncNames := syncer.SyncedNames()
+				if len(syncNames) == 0 {
+					continue
+				}
+				dvid.Infof("Converting data %q %d legacy sync names to data UUIDs...\n", dataservice.DataName(), len(syncNames))
+				syncs := dvid.UUIDSet{}
+				for _, name := range syncNames {
+					// get the dataservice associated with this synced data.
+					syncedData, found := r.data[name]
+					if found {
+						subs, err := syncer.GetSyncSubs(syncedData)
+						if err != nil {
+							dvid.Criticalf("Skipping bad sync of data %q to data %q: %v\n", dataservice.DataName(), syncedData.DataName(), err)
+							continue
 						}
+						r.addSyncGraph(subs)
+						// convert the sync names to data UUIDs
+						syncs[syncedData.DataUUID()] = struct{}{}
+						dvid.Infof("  Converted synced data %q to its UUID: %s\n", name, syncedData.DataUUID())
+					} else {
+						dvid.Errorf(" Skipping sync of %q with missing data %q for repo @ %s", dataservice.DataName(), name, r.uuid)
 					}
-					dvid.Infof("After conversion data %q has syncs: %v\n", dataservice.DataName(), syncs)
-					dataservice.SetSync(syncs)
-					dvid.Infof("After calling SetSync we get back: %v\n", syncer.SyncedData())
-					saveRepo = true
 				}
+				dvid.Infof("After conversion data %q has syncs: %v\n", dataservice.DataName(), syncs)
+				dataservice.SetSync(syncs)
+				dvid.Infof("After calling SetSync we get back: %v\n", syncer.SyncedData())
+				saveRepo = true
 			}
 		}
+	}
 
-		// Load any mutable properties for the data instances.
-		for _, dataservice := range r.data {
-			mutator, mutable := dataservice.(InstanceMutator)
-			if mutable {
-				modified, err := mutator.LoadMutable(r.version, m.formatVersion, RepoFormatVersion)
-				if err != nil {
-					return err
-				}
-				if modified {
-					saveRepo = true
-				}
+	// Load any mutable properties for the data instances.
+	for _, dataservice := range r.data {
+		mutator, mutable := dataservice.(InstanceMutator)
+		if mutable {
+			modified, err := mutator.LoadMutable(r.version, storedVersion, RepoFormatVersion)
+			if err != nil {
+				return false, err
 			}
-		}
-
-		// If updates had to be made, save the migrated repo metadata.
-		if saveRepo {
-			dvid.Infof("Re-saved repo with root %s due to migrations.\n", r.uuid)
-			if err := r.save(); err != nil {
-				return err
+			if modified {
+				saveRepo = true
 			}
 		}
 	}
-	if err := m.verifyCompiledTypes(); err != nil {
-		return err
-	}
-
-	for id, uuid := range m.repoToUUID {
-		if m.repos[uuid] == nil {
-			dvid.Infof("Found empty repo id %d (uuid %s)... deleting.\n", id, uuid)
-			delete(m.repoToUUID, id)
-			saveCache = true
-		}
-	}
 
-	// If we noticed missing or corrupt cache entries, save current metadata.
-	if saveCache {
-		if err := m.putCaches(); err != nil {
-			return err
+	// If updates had to be made, save the migrated repo metadata.
+	if saveRepo {
+		dvid.Infof("Re-saved repo with root %s due to migrations.\n", r.uuid)
+		if err := r.save(); err != nil {
+			return false, err
 		}
+	} else {
+		r.markSaved(value)
 	}
 
-	if m.formatVersion != RepoFormatVersion {
-		dvid.Infof("Updated metadata from version %d to version %d\n", m.formatVersion, RepoFormatVersion)
-		m.formatVersion = RepoFormatVersion
-		if err := m.putData(formatKey, &(m.formatVersion)); err != nil {
-			return err
-		}
+	for _, uuid := range uuids {
+		m.repos[uuid] = r
 	}
-	dvid.Infof("Loaded %d repositories from metadata store.", len(m.repos))
-	return nil
+	return saveCache, nil
 }
 
 func (m *repoManager) loadMetadata() error {
@@ -642,24 +864,17 @@ func (m *repoManager) loadMetadata() error {
 		m.formatVersion = 0
 	}
 
-	switch m.formatVersion {
-	case 0:
-		err = m.loadVersion0()
-	case 1:
-		// We aren't changing any metadata, just the labelvol datatype props.
-		err = m.loadVersion0()
-	default:
-		err = fmt.Errorf("Unknown metadata format %d", m.formatVersion)
+	if err := m.migrateMetadata(); err != nil {
+		return err
 	}
-
-	if err != nil {
+	if err := m.loadVersion0(); err != nil {
 		return err
 	}
 
 	saveIDs := false
 
 	// Handle instance ID management
-	if m.instanceIDGen == "sequential" && m.instanceIDStart > m.instanceID {
+	if m.instanceIDStart > m.instanceID {
 		m.instanceID = m.instanceIDStart
 		saveIDs = true
 	}
@@ -691,27 +906,46 @@ func (m *repoManager) newInstanceID() (dvid.InstanceID, error) {
 	m.idMutex.Lock()
 	defer m.idMutex.Unlock()
 
+	curid, err := m.allocInstanceID("")
+	if err != nil {
+		return 0, err
+	}
+	return curid, m.putNewIDs()
+}
+
+// allocInstanceID generates a new instance ID for a data instance in a repo of the given
+// namespace without persisting the next ids, which the caller must do.  Namespaces with
+// their own ID space get IDs with their prefix.  The caller must hold idMutex.
+func (m *repoManager) allocInstanceID(namespace string) (dvid.InstanceID, error) {
+	alloc, counter := m.instanceIDs, &(m.instanceID)
+	if prefix := namespaceIDPrefix(namespace); prefix != 0 {
+		if m.namespaceIDs == nil {
+			m.namespaceIDs = make(map[string]dvid.InstanceID)
+		}
+		next := m.namespaceIDs[namespace]
+		if next == 0 {
+			next = 1
+		}
+		defer func() { m.namespaceIDs[namespace] = next }()
+		alloc, counter = prefixedAllocator{prefix: dvid.InstanceID(prefix)}, &next
+	}
 	// Generate a new instance ID based on the method in configuration.
-	var err error
-	var curid dvid.InstanceID
-	invalidID := true
-	for invalidID {
-		switch m.instanceIDGen {
-		case "sequential":
-			curid = m.instanceID
-			m.instanceID++
-			err = m.putNewIDs()
-		case "random":
-			s1 := rand.NewSource(time.Now().UnixNano())
-			r1 := rand.New(s1)
-			curid = dvid.InstanceID(r1.Uint32())
-		}
-		_, found := m.iids[curid]
-		if !found {
-			invalidID = false
+	for {
+		curid, err := alloc.AllocateID(counter)
+		if err != nil {
+			return 0, err
+		}
+		if _, found := m.iids[curid]; !found {
+			return curid, nil
 		}
 	}
-	return curid, err
+}
+
+// setInstanceIDAllocator replaces the allocator of new instance IDs.
+func (m *repoManager) setInstanceIDAllocator(a InstanceIDAllocator) {
+	m.idMutex.Lock()
+	defer m.idMutex.Unlock()
+	m.instanceIDs = a
 }
 
 func (m *repoManager) newRepoID() (dvid.RepoID, error) {
@@ -741,9 +975,7 @@ func (m *repoManager) newVersionID(uuid dvid.UUID, save bool) (dvid.VersionID, e
 	if save {
 		m.versionToUUID[curid] = uuid
 		m.uuidToVersion[uuid] = curid
-		if err := m.putCaches(); err != nil {
-			return curid, err
-		}
+		return curid, m.putCaches()
 	}
 	return curid, m.putNewIDs()
 }
@@ -764,10 +996,7 @@ func (m *repoManager) newUUID(assign *dvid.UUID) (dvid.UUID, dvid.VersionID, err
 	m.versionToUUID[curid] = uuid
 	m.uuidToVersion[uuid] = curid
 	m.versionID++
-	if err := m.putCaches(); err != nil {
-		return uuid, curid, err
-	}
-	return uuid, curid, m.putNewIDs()
+	return uuid, curid, m.putCaches()
 }
 
 func (m *repoManager) uuidFromVersion(versionID dvid.VersionID) (dvid.UUID, error) {
@@ -799,8 +1028,6 @@ func (m *repoManager) versionFromUUID(uuid dvid.UUID) (dvid.VersionID, error) {
 // allow UUID strings of less than 3 letters just to prevent mistakes.)
 func (m *repoManager) matchingUUID(str string) (dvid.UUID, dvid.VersionID, error) {
 	m.idMutex.RLock()
-	defer m.idMutex.RUnlock()
-
 	var bestVersion dvid.VersionID
 	var bestUUID dvid.UUID
 	numMatches := 0
@@ -811,15 +1038,199 @@ func (m *repoManager) matchingUUID(str string) (dvid.UUID, dvid.VersionID, error
 			bestUUID = uuid
 		}
 	}
+	m.idMutex.RUnlock()
+
 	var err error
 	if numMatches > 1 {
 		err = fmt.Errorf("More than one UUID matches %s!", str)
 	} else if numMatches == 0 {
+		// Tags can't be UUID prefixes, so only check them if no UUID matches.
+		if uuid, found, tagErr := m.taggedUUID(str); tagErr != nil {
+			return dvid.NilUUID, 0, tagErr
+		} else if found {
+			v, err := m.versionFromUUID(uuid)
+			return uuid, v, err
+		}
 		err = fmt.Errorf("Could not find UUID with partial match to %s!", str)
 	}
 	return bestUUID, bestVersion, err
 }
 
+// taggedUUID returns the UUID of the node with the given tag across all repos.
+func (m *repoManager) taggedUUID(tag string) (uuid dvid.UUID, found bool, err error) {
+	if err := m.pageInAll(); err != nil {
+		return dvid.NilUUID, false, err
+	}
+	m.RLock()
+	defer m.RUnlock()
+
+	var tagRepo *repoT
+	for _, r := range m.repos {
+		if r == tagRepo {
+			continue
+		}
+		r.RLock()
+		tagged, ok := r.tags[tag]
+		r.RUnlock()
+		if !ok {
+			continue
+		}
+		if found {
+			return dvid.NilUUID, false, fmt.Errorf("tag %q is used in more than one repo, so a UUID must be given", tag)
+		}
+		uuid, found, tagRepo = tagged, true, r
+	}
+	return uuid, found, nil
+}
+
+// validTag returns an error if a tag could be confused with a UUID or can't be used in a URL path.
+func validTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("tags can't be empty")
+	}
+	if strings.IndexFunc(tag, func(c rune) bool { return c == '/' || unicode.IsSpace(c) }) >= 0 {
+		return fmt.Errorf("tag %q can't have slashes or whitespace", tag)
+	}
+	if strings.Trim(strings.ToLower(tag), "0123456789abcdef") == "" {
+		return fmt.Errorf("tag %q must have a non-hexadecimal character to distinguish it from UUIDs", tag)
+	}
+	return nil
+}
+
+func (m *repoManager) setTag(uuid dvid.UUID, tag string) error {
+	if err := validTag(tag); err != nil {
+		return err
+	}
+	m.RLock()
+	r, found := m.repos[uuid]
+	m.RUnlock()
+	if !found {
+		return ErrInvalidUUID
+	}
+	tagged, found, err := m.taggedUUID(tag)
+	if err != nil {
+		return err
+	}
+	m.RLock()
+	tagRepo := m.repos[tagged]
+	m.RUnlock()
+	if found && tagRepo != r {
+		return fmt.Errorf("tag %q is already used in another repo", tag)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	prev, moved := r.tags[tag]
+	if moved && prev == uuid {
+		return nil
+	}
+	r.tags[tag] = uuid
+	tm := time.Now()
+	r.updated = tm
+	msg := fmt.Sprintf("Tagged node %s as %q", uuid, tag)
+	if moved {
+		msg = fmt.Sprintf("Moved tag %q from node %s to %s", tag, prev, uuid)
+	}
+	r.log = append(r.log, fmt.Sprintf("%s  %s", tm.Format(time.RFC3339), msg))
+	return r.save()
+}
+
+func (m *repoManager) deleteTag(uuid dvid.UUID, tag string) error {
+	m.RLock()
+	r, found := m.repos[uuid]
+	m.RUnlock()
+	if !found {
+		return ErrInvalidUUID
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	tagged, found := r.tags[tag]
+	if !found {
+		return fmt.Errorf("no tag %q in repo %s", tag, r.uuid)
+	}
+	delete(r.tags, tag)
+	tm := time.Now()
+	r.updated = tm
+	msg := fmt.Sprintf("Removed tag %q from node %s", tag, tagged)
+	r.log = append(r.log, fmt.Sprintf("%s  %s", tm.Format(time.RFC3339), msg))
+	return r.save()
+}
+
+func (m *repoManager) getTags(uuid dvid.UUID) (map[string]dvid.UUID, error) {
+	m.RLock()
+	r, found := m.repos[uuid]
+	m.RUnlock()
+	if !found {
+		return nil, ErrInvalidUUID
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+	tags := make(map[string]dvid.UUID, len(r.tags))
+	for tag, tagged := range r.tags {
+		tags[tag] = tagged
+	}
+	return tags, nil
+}
+
+func (m *repoManager) getDAG(uuid dvid.UUID) (*DAGGraph, error) {
+	m.RLock()
+	r, found := m.repos[uuid]
+	m.RUnlock()
+	if !found {
+		return nil, ErrInvalidUUID
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+
+	tags := make(map[dvid.UUID][]string)
+	for tag, tagged := range r.tags {
+		tags[tagged] = append(tags[tagged], tag)
+	}
+	graph := &DAGGraph{
+		Root:  r.uuid,
+		Alias: r.alias,
+		Nodes: make([]DAGNode, 0, len(r.dag.nodes)),
+	}
+	for _, node := range r.dag.nodes {
+		node.RLock()
+		sort.Strings(tags[node.uuid])
+		graph.Nodes = append(graph.Nodes, DAGNode{
+			UUID:    node.uuid,
+			Branch:  node.branch,
+			Note:    node.note,
+			Locked:  node.locked,
+			Tags:    tags[node.uuid],
+			Created: node.created,
+			Updated: node.updated,
+		})
+		for i, parentV := range node.parents {
+			parent, found := r.dag.nodes[parentV]
+			if !found {
+				dvid.Errorf("node %s of repo %s has missing parent version %d\n", node.uuid, r.uuid, parentV)
+				continue
+			}
+			graph.Edges = append(graph.Edges, DAGEdge{Parent: parent.uuid, Child: node.uuid, Merge: i > 0})
+		}
+		node.RUnlock()
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		if graph.Nodes[i].Created.Equal(graph.Nodes[j].Created) {
+			return graph.Nodes[i].UUID < graph.Nodes[j].UUID
+		}
+		return graph.Nodes[i].Created.Before(graph.Nodes[j].Created)
+	})
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].Child == graph.Edges[j].Child {
+			return !graph.Edges[i].Merge && graph.Edges[j].Merge
+		}
+		return graph.Edges[i].Child < graph.Edges[j].Child
+	})
+	return graph, nil
+}
+
 // addRepo adds a preallocated repo with valid local instance and version IDs to
 // the repoManager.
 func (m *repoManager) addRepo(r *repoT) error {
@@ -834,6 +1245,7 @@ func (m *repoManager) addRepo(r *repoT) error {
 		m.dataByUUID[dataservice.DataUUID()] = dataservice
 	}
 	for v, node := range r.dag.nodes {
+		m.repos[node.uuid] = r
 		m.versionToUUID[v] = node.uuid
 		m.uuidToVersion[node.uuid] = v
 	}
@@ -845,12 +1257,39 @@ func (m *repoManager) addRepo(r *repoT) error {
 	return r.save()
 }
 
-func (m *repoManager) deleteRepo(uuid dvid.UUID, passcode string) error {
+// repoDeleteToken checks the repo with the given root UUID can be deleted and returns a
+// token that must be given to deleteRepo within RepoDeleteTokenTimeout.
+func (m *repoManager) repoDeleteToken(uuid dvid.UUID, passcode string) (string, error) {
 	m.Lock()
 	defer m.Unlock()
 
-	m.idMutex.RLock()
-	defer m.idMutex.RUnlock()
+	r, found := m.repos[uuid]
+	if !found {
+		return "", ErrInvalidUUID
+	}
+	if r.uuid != uuid {
+		return "", fmt.Errorf("UUID for repo deletion must match UUID of repo's root")
+	}
+	if r.passcode != "" && r.passcode != passcode {
+		return "", fmt.Errorf("Passcode does not match repo %s passcode", uuid)
+	}
+
+	token := string(dvid.NewUUID())
+	if token == "" {
+		return "", fmt.Errorf("unable to generate deletion token for repo %s", uuid)
+	}
+	if m.deleteTokens == nil {
+		m.deleteTokens = make(map[dvid.UUID]deleteToken)
+	}
+	m.deleteTokens[uuid] = deleteToken{token, time.Now().Add(RepoDeleteTokenTimeout)}
+	return token, nil
+}
+
+// deleteRepo deletes the repo with the given root UUID if the token was issued for it by
+// repoDeleteToken and hasn't expired.  Any deletion attempt invalidates the issued token.
+func (m *repoManager) deleteRepo(uuid dvid.UUID, token string) error {
+	m.Lock()
+	defer m.Unlock()
 
 	r, found := m.repos[uuid]
 	if !found {
@@ -861,38 +1300,199 @@ func (m *repoManager) deleteRepo(uuid dvid.UUID, passcode string) error {
 		return fmt.Errorf("UUID for repo deletion must match UUID of repo's root")
 	}
 
-	if r.passcode != "" && r.passcode != passcode {
-		return fmt.Errorf("Passcode does not match repo %s passcode", uuid)
+	issued, found := m.deleteTokens[uuid]
+	delete(m.deleteTokens, uuid)
+	if !found || issued.token != token {
+		return fmt.Errorf("invalid confirmation token for deletion of repo %s", uuid)
+	}
+	if time.Now().After(issued.expires) {
+		return fmt.Errorf("confirmation token for deletion of repo %s has expired", uuid)
 	}
 
 	r.Lock()
 
 	// Start deletion of all data instances.
 	for _, data := range r.data {
-		go func(data dvid.Data) {
-			if err := storage.DeleteDataInstance(data); err != nil {
-				dvid.Errorf("Error trying to do async data instance %q deletion: %v\n", data.DataName(), err)
+		reclaimData(data)
+	}
+	for _, t := range r.trash {
+		reclaimData(t.Data)
+	}
+
+	// Delete the repo off the datastore.
+	if err := r.delete(); err != nil {
+		r.Unlock()
+		return fmt.Errorf("Unable to delete repo from datastore: %v", err)
+	}
+	r.Unlock()
+
+	m.idMutex.Lock()
+	defer m.idMutex.Unlock()
+
+	// Delete all UUIDs in this repo from metadata
+	delete(m.repoToUUID, r.id)
+	for v := range r.dag.nodes {
+		u, found := m.versionToUUID[v]
+		if !found {
+			dvid.Errorf("Found version id %d with no corresponding UUID on delete of repo %s!\n", v, uuid)
+			continue
+		}
+		delete(m.repos, u)
+		delete(m.uuidToVersion, u)
+		delete(m.versionToUUID, v)
+	}
+	for _, data := range r.data {
+		delete(m.iids, data.InstanceID())
+		delete(m.dataByUUID, data.DataUUID())
+	}
+	for _, t := range r.trash {
+		delete(m.iids, t.Data.InstanceID())
+		delete(m.dataByUUID, t.Data.DataUUID())
+	}
+	return m.putCaches()
+}
+
+// deleteVersion removes a node and, if withDescendants is true, all of its descendants
+// from a repo's DAG.  The key-value pairs written in the removed versions are then
+// deleted from all versioned data instances and their stores compacted asynchronously.
+// Since descendants of the removed node can't remain, the node must be a leaf unless
+// withDescendants is set, and no removed descendant may have been merged with a node
+// outside the removed branch.
+func (m *repoManager) deleteVersion(uuid dvid.UUID, withDescendants bool, passcode string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	r, found := m.repos[uuid]
+	if !found {
+		return ErrInvalidUUID
+	}
+	if r.passcode != "" && r.passcode != passcode {
+		return fmt.Errorf("Passcode does not match repo %s passcode", r.uuid)
+	}
+	if r.uuid == uuid {
+		return fmt.Errorf("can't delete root node %s of repo, delete the repo instead", uuid)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	var v dvid.VersionID
+	for nodeV, node := range r.dag.nodes {
+		if node.uuid == uuid {
+			v = nodeV
+			break
+		}
+	}
+	if v == 0 {
+		return ErrInvalidUUID
+	}
+
+	// Gather the versions in the branch rooted at the node.
+	removed := map[dvid.VersionID]struct{}{v: {}}
+	branch := []dvid.VersionID{v}
+	for i := 0; i < len(branch); i++ {
+		node := r.dag.nodes[branch[i]]
+		if len(node.children) != 0 && !withDescendants {
+			return fmt.Errorf("node %s has children, so its descendants must also be deleted", uuid)
+		}
+		for _, child := range node.children {
+			if _, found := removed[child]; !found {
+				removed[child] = struct{}{}
+				branch = append(branch, child)
 			}
-		}(data)
+		}
+	}
+	for _, dv := range branch[1:] {
+		node := r.dag.nodes[dv]
+		for _, parent := range node.parents {
+			if _, found := removed[parent]; !found {
+				return fmt.Errorf("node %s descends from node %s outside the deleted branch, so it can't be deleted", node.uuid, r.dag.nodes[parent].uuid)
+			}
+		}
+	}
+
+	// Remove the branch from the DAG and persist.
+	tm := time.Now()
+	for _, parent := range r.dag.nodes[v].parents {
+		node := r.dag.nodes[parent]
+		node.Lock()
+		for i, child := range node.children {
+			if child == v {
+				node.children = append(node.children[:i], node.children[i+1:]...)
+				break
+			}
+		}
+		node.updated = tm
+		node.Unlock()
+	}
+	uuids := make(map[dvid.UUID]dvid.VersionID, len(branch))
+	for _, dv := range branch {
+		uuids[r.dag.nodes[dv].uuid] = dv
+		delete(r.dag.nodes, dv)
 	}
+	for tag, tagged := range r.tags {
+		if _, found := uuids[tagged]; found {
+			delete(r.tags, tag)
+		}
+	}
+	r.updated = tm
+	msg := fmt.Sprintf("Deleted node %s", uuid)
+	if len(branch) > 1 {
+		msg += fmt.Sprintf(" and its %d descendants", len(branch)-1)
+	}
+	r.log = append(r.log, fmt.Sprintf("%s  %s", tm.Format(time.RFC3339), msg))
+	if err := r.save(); err != nil {
+		return err
+	}
+
+	m.idMutex.Lock()
+	for u, dv := range uuids {
+		delete(m.repos, u)
+		delete(m.uuidToVersion, u)
+		delete(m.versionToUUID, dv)
+	}
+	err := m.putCaches()
+	m.idMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Delete the key-value pairs of the removed versions and reclaim their space.
+	var instances []DataService
+	for _, data := range r.data {
+		if data.Versioned() {
+			instances = append(instances, data)
+		}
+	}
+	for _, t := range r.trash {
+		if t.Data.Versioned() {
+			instances = append(instances, t.Data)
+		}
+	}
+	go func() {
+		for _, data := range instances {
+			if err := deleteVersionsData(data, branch); err != nil {
+				dvid.Errorf("Error trying to do async deletion of versions of data %q: %v\n", data.DataName(), err)
+			}
+		}
+	}()
+	return nil
+}
 
-	// Delete the repo off the datastore.
-	if err := r.delete(); err != nil {
-		return fmt.Errorf("Unable to delete repo from datastore: %v", err)
+// deleteVersionsData deletes the key-value pairs written in the given versions of
+// a data instance and compacts the instance's keys if possible.
+func deleteVersionsData(data DataService, versions []dvid.VersionID) error {
+	store, err := getOrderedKeyValueDB(data)
+	if err != nil {
+		return err
 	}
-	r.Unlock()
-
-	// Delete all UUIDs in this repo from metadata
-	delete(m.repoToUUID, r.id)
-	for v := range r.dag.nodes {
-		u, found := m.versionToUUID[v]
-		if !found {
-			dvid.Errorf("Found version id %d with no corresponding UUID on delete of repo %s!\n", v, uuid)
-			continue
+	for _, v := range versions {
+		if err := store.DeleteAll(NewVersionedCtx(data, v), false); err != nil {
+			return err
 		}
-		delete(m.repos, u)
-		delete(m.uuidToVersion, u)
-		delete(m.versionToUUID, v)
+	}
+	if err := compactDataInstance(data); err != nil {
+		dvid.Infof("Skipping compaction after deleting versions of data %q: %v\n", data.DataName(), err)
 	}
 	return nil
 }
@@ -934,7 +1534,7 @@ func (m *repoManager) repoFromID(repoID dvid.RepoID) (*repoT, error) {
 }
 
 // newRepo creates a new Repo with a new unique UUID unless one is provided as last parameter.
-func (m *repoManager) newRepo(alias, description string, assign *dvid.UUID, passcode string) (*repoT, error) {
+func (m *repoManager) newRepo(alias, description string, assign *dvid.UUID, passcode string, props map[string]interface{}) (*repoT, error) {
 	if assign != nil {
 		// Make sure there's not already a repo with this UUID.
 		if _, found := m.repos[*assign]; found {
@@ -958,12 +1558,20 @@ func (m *repoManager) newRepo(alias, description string, assign *dvid.UUID, pass
 
 	r.alias = alias
 	r.description = description
+	for name, value := range props {
+		r.properties[name] = value
+	}
 
+	// Save the caches first so a crash before the repo is saved leaves an empty repo id,
+	// which is removed on load, rather than a repo that isn't in the caches.
+	if err := m.putCaches(); err != nil {
+		return r, err
+	}
 	if err := r.save(); err != nil {
 		return r, err
 	}
 	dvid.Infof("Created and saved new repo %q, id %d\n", uuid, id)
-	return r, m.putCaches()
+	return r, nil
 }
 
 func (m *repoManager) saveRepoByUUID(uuid dvid.UUID) error {
@@ -1103,7 +1711,11 @@ func (m *repoManager) setRepoProperty(uuid dvid.UUID, name string, value interfa
 	r.Lock()
 	defer r.Unlock()
 	r.updated = time.Now()
-	r.properties[name] = value
+	if value == nil {
+		delete(r.properties, name)
+	} else {
+		r.properties[name] = value
+	}
 	return r.save()
 }
 
@@ -1117,7 +1729,11 @@ func (m *repoManager) setRepoProperties(uuid dvid.UUID, props map[string]interfa
 	defer r.Unlock()
 	r.updated = time.Now()
 	for k, v := range props {
-		r.properties[k] = v
+		if v == nil {
+			delete(r.properties, k)
+		} else {
+			r.properties[k] = v
+		}
 	}
 	return r.save()
 }
@@ -1219,6 +1835,27 @@ func (m *repoManager) getNodeLog(uuid dvid.UUID) ([]string, error) {
 	return msgs, nil
 }
 
+func (m *repoManager) getCommitInfo(uuid dvid.UUID) (*CommitInfo, error) {
+	r, found := m.repos[uuid]
+	if !found {
+		return nil, ErrInvalidUUID
+	}
+
+	v, err := m.versionFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	node, found := r.dag.nodes[v]
+	if !found {
+		return nil, ErrInvalidVersion
+	}
+
+	node.RLock()
+	defer node.RUnlock()
+	return node.commitInfo(), nil
+}
+
 func (m *repoManager) addToNodeLog(uuid dvid.UUID, msgs []string) error {
 	r, found := m.repos[uuid]
 	if !found {
@@ -1257,6 +1894,30 @@ func (m *repoManager) getParentsByVersion(v dvid.VersionID) ([]dvid.VersionID, e
 	return r.dag.getParents(v)
 }
 
+// isAncestor returns true if version a is a proper ancestor of version v.
+func (m *repoManager) isAncestor(a, v dvid.VersionID) (bool, error) {
+	visited := map[dvid.VersionID]struct{}{v: {}}
+	toVisit := []dvid.VersionID{v}
+	for len(toVisit) != 0 {
+		cur := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		parents, err := m.getParentsByVersion(cur)
+		if err != nil {
+			return false, err
+		}
+		for _, parent := range parents {
+			if parent == a {
+				return true, nil
+			}
+			if _, found := visited[parent]; !found {
+				visited[parent] = struct{}{}
+				toVisit = append(toVisit, parent)
+			}
+		}
+	}
+	return false, nil
+}
+
 func (m *repoManager) getChildrenByVersion(v dvid.VersionID) ([]dvid.VersionID, error) {
 	r, err := m.repoFromVersion(v)
 	if err != nil {
@@ -1301,7 +1962,7 @@ func (m *repoManager) lockedVersion(v dvid.VersionID) (bool, error) {
 	return node.locked, nil
 }
 
-func (m *repoManager) commit(uuid dvid.UUID, note string, log []string) error {
+func (m *repoManager) commit(uuid dvid.UUID, info CommitInfo, log []string) error {
 	v, err := m.versionFromUUID(uuid)
 	if err != nil {
 		return err
@@ -1325,14 +1986,27 @@ func (m *repoManager) commit(uuid dvid.UUID, note string, log []string) error {
 	node.locked = true
 	t := time.Now()
 
-	if len(note) != 0 {
-		node.note = note
+	if len(info.Message) != 0 {
+		node.note = info.Message
 	}
+	info.Time = t
+	node.commit = info
 
-	if len(log) != 0 {
-		if err := node.addToLog(log); err != nil {
-			return err
-		}
+	entry := "committed"
+	if info.User != "" {
+		entry += " by " + info.User
+	}
+	if info.App != "" {
+		entry += " using " + info.App
+	}
+	if info.Message != "" {
+		entry += ": " + info.Message
+	}
+	if err := node.addToLog(log); err != nil {
+		return err
+	}
+	if err := node.addToLog([]string{entry}); err != nil {
+		return err
 	}
 
 	// Notify any data instances in this repo that wants notification on node commit.
@@ -1347,6 +2021,54 @@ func (m *repoManager) commit(uuid dvid.UUID, note string, log []string) error {
 	return r.save()
 }
 
+func (m *repoManager) unlock(uuid dvid.UUID, reason, user, passcode string) error {
+	if strings.TrimSpace(reason) == "" {
+		return fmt.Errorf("a reason is required to unlock node %s", uuid)
+	}
+	v, err := m.versionFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	r, err := m.repoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.passcode != "" && r.passcode != passcode {
+		return fmt.Errorf("Passcode does not match repo %s passcode", r.uuid)
+	}
+	node, found := r.dag.nodes[v]
+	if !found {
+		return ErrInvalidVersion
+	}
+
+	node.Lock()
+	defer node.Unlock()
+
+	if !node.locked {
+		return fmt.Errorf("node %s isn't locked", uuid)
+	}
+	if len(node.children) != 0 {
+		return fmt.Errorf("node %s has children, which would see any changes, so it can't be unlocked", uuid)
+	}
+	node.locked = false
+	node.commit = CommitInfo{}
+
+	entry := "unlocked"
+	if user != "" {
+		entry += " by " + user
+	}
+	entry += ": " + reason
+	if err := node.addToLog([]string{entry}); err != nil {
+		return err
+	}
+	r.updated = node.updated
+	return r.save()
+}
+
 // newVersion creates a new version as a child of the given parent.  If the
 // assign parameter is not nil, the new node is given the UUID.
 func (m *repoManager) newVersion(parent dvid.UUID, note string, branchname string, assign *dvid.UUID) (dvid.UUID, error) {
@@ -1368,10 +2090,9 @@ func (m *repoManager) newVersion(parent dvid.UUID, note string, branchname strin
 		return dvid.NilUUID, ErrInvalidVersion
 	}
 
-	node.Lock()
-	defer node.Unlock()
-
+	node.RLock()
 	if !node.locked {
+		node.RUnlock()
 		return dvid.NilUUID, ErrBranchUnlockedNode
 	}
 
@@ -1384,35 +2105,84 @@ func (m *repoManager) newVersion(parent dvid.UUID, note string, branchname strin
 			// check if there is already a branch here
 			sisternode, found := r.dag.nodes[sister]
 			if !found {
+				node.RUnlock()
 				return dvid.NilUUID, fmt.Errorf("cannot find sibling nodes")
 			}
 			if sisternode.branch == branchname {
+				node.RUnlock()
 				return dvid.NilUUID, ErrBranchUnique
 			}
 		}
 	} else { // check if branch name used anywhere in DAG
 		for _, othernode := range r.dag.nodes {
 			if othernode.branch == branchname {
+				node.RUnlock()
 				return dvid.NilUUID, ErrBranchUnique
 			}
 		}
 
 	}
+	node.RUnlock()
+
+	child, err := m.newChild(r, []dvid.VersionID{v}, note, branchname, assign)
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+	if err := r.save(); err != nil {
+		return dvid.NilUUID, err
+	}
+	r.publishNewVersion(child)
+	return child.uuid, nil
+}
+
+// newChild adds a node with the given parents, which must be locked nodes of the repo, to
+// the repo's DAG and version maps.  A node with more than one parent is a merge node whose
+// parents are listed in priority order.  All parents are checked before the child is
+// created so a failure leaves the DAG unchanged.  The repo must be write locked, and the
+// caller is responsible for saving the repo.
+func (m *repoManager) newChild(r *repoT, parents []dvid.VersionID, note, branchname string, assign *dvid.UUID) (*nodeT, error) {
+	if len(parents) == 0 {
+		return nil, ErrInvalidVersion
+	}
+	parentNodes := make([]*nodeT, len(parents))
+	for i, v := range parents {
+		for _, prior := range parents[:i] {
+			if prior == v {
+				return nil, fmt.Errorf("version %d given more than once as a parent", v)
+			}
+		}
+		node, found := r.dag.nodes[v]
+		if !found {
+			return nil, ErrInvalidVersion
+		}
+		node.RLock()
+		locked := node.locked
+		node.RUnlock()
+		if !locked {
+			return nil, ErrBranchUnlockedNode
+		}
+		parentNodes[i] = node
+	}
 
 	// Add the child node.  Since it's new and unavailable, no need to lock it.
 	childUUID, childV, err := m.newUUID(assign)
 	if err != nil {
-		return dvid.NilUUID, err
+		return nil, err
 	}
 	child := newNode(childUUID, childV)
-	child.parents = []dvid.VersionID{v}
+	child.parents = append([]dvid.VersionID{}, parents...)
 	child.note = note
 	child.branch = branchname
 
 	m.repos[childUUID] = r
 
-	node.children = append(node.children, childV)
-	node.updated = time.Now()
+	// Set up pointers with parents
+	for _, node := range parentNodes {
+		node.Lock()
+		node.children = append(node.children, childV)
+		node.updated = time.Now()
+		node.Unlock()
+	}
 
 	r.dag.nodes[childV] = child
 
@@ -1423,12 +2193,11 @@ func (m *repoManager) newVersion(parent dvid.UUID, note string, branchname strin
 		initializer, ok := dataservice.(VersionInitializer)
 		if ok {
 			if err := initializer.InitVersion(childUUID, childV); err != nil {
-				return dvid.NilUUID, err
+				return nil, err
 			}
 		}
 	}
-
-	return child.uuid, r.save()
+	return child, nil
 }
 
 func (m *repoManager) merge(parents []dvid.UUID, note string, mt MergeType) (dvid.UUID, error) {
@@ -1439,83 +2208,143 @@ func (m *repoManager) merge(parents []dvid.UUID, note string, mt MergeType) (dvi
 		return dvid.NilUUID, ErrInvalidUUID
 	}
 
+	// TODO: we'd like to lock this child node but locked nodes have other
+	//  side effects like the ability to be branched or cloned.  Perhaps add
+	//  another node-level property saying it's read-only at this time, not
+	//  for all time.  Could require separate API call to retrieve final child
+	//  UUID given an immediately returned token.
+	switch mt {
+	case MergeConflictFree:
+		// No processing needs to be done except for metadata changes.
+		// Any issues will be noted during key-value lookup while traversing the DAG.
+
+	case MergeTypeSpecificAuto:
+		return dvid.NilUUID, fmt.Errorf("the type-specific auto merge has not been implemented yet")
+		// go r.asyncMerge(parentNode1, parentNode2, child)
+
+	case MergeExternalData:
+		return dvid.NilUUID, fmt.Errorf("merging with external data has not been implemented yet")
+
+	default:
+		return dvid.NilUUID, ErrBadMergeType
+	}
+
 	r, found := m.repos[parents[0]]
 	if !found {
 		return dvid.NilUUID, ErrInvalidUUID
 	}
-
-	// Add the child node.  Since it's new and unavailable, no need to lock it.
-	childUUID, childV, err := m.newUUID(nil)
-	if err != nil {
-		return dvid.NilUUID, err
+	parentsV := make([]dvid.VersionID, len(parents))
+	for i, parent := range parents {
+		if m.repos[parent] != r {
+			return dvid.NilUUID, fmt.Errorf("parent %s is not in the repo of parent %s", parent, parents[0])
+		}
+		v, err := m.versionFromUUID(parent)
+		if err != nil {
+			return dvid.NilUUID, err
+		}
+		parentsV[i] = v
 	}
-	child := newNode(childUUID, childV)
-	child.note = note
-
-	m.repos[childUUID] = r
 
 	r.Lock()
 	defer r.Unlock()
 
-	r.dag.nodes[childV] = child
+	child, err := m.newChild(r, parentsV, note, "", nil)
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+	if err := r.save(); err != nil {
+		return dvid.NilUUID, err
+	}
+	r.publishNewVersion(child)
+	return child.uuid, nil
+}
 
-	// Set up pointers with parents
-	for _, parent := range parents {
+// mergeWithStrategy resolves conflicts between the parents' data before creating the merged
+// child, so a conflict error leaves the DAG unchanged, and then writes the resolved key-value
+// pairs in the child.  The parents are locked, so their data can be read without holding locks.
+func (m *repoManager) mergeWithStrategy(parents []dvid.UUID, note string, strategy MergeStrategy, typeStrategies map[dvid.TypeString]MergeStrategy) (dvid.UUID, error) {
+	if len(parents) < 2 {
+		return dvid.NilUUID, ErrInvalidUUID
+	}
+	m.RLock()
+	r, found := m.repos[parents[0]]
+	m.RUnlock()
+	if !found {
+		return dvid.NilUUID, ErrInvalidUUID
+	}
+	parentsV := make([]dvid.VersionID, len(parents))
+	for i, parent := range parents {
 		v, err := m.versionFromUUID(parent)
 		if err != nil {
 			return dvid.NilUUID, err
 		}
-		node, found := r.dag.nodes[v]
-		if !found {
-			return dvid.NilUUID, ErrInvalidVersion
+		locked, err := m.lockedVersion(v)
+		if err != nil {
+			return dvid.NilUUID, err
 		}
-
-		node.Lock()
-		defer node.Unlock()
-
-		if !node.locked {
+		if !locked {
 			return dvid.NilUUID, ErrBranchUnlockedNode
 		}
-
-		// Add this parent node
-		child.parents = append(child.parents, v)
-		node.children = append(node.children, childV)
-		node.updated = time.Now()
+		parentsV[i] = v
 	}
 
-	// Notify data instances that we have a new child in case they have to do some kind of initialization.
-	for _, dataservice := range r.data {
-		initializer, ok := dataservice.(VersionInitializer)
-		if ok {
-			if err := initializer.InitVersion(childUUID, childV); err != nil {
-				return dvid.NilUUID, err
-			}
+	r.RLock()
+	var instances []DataService
+	for _, data := range r.data {
+		if data.Versioned() {
+			instances = append(instances, data)
 		}
 	}
+	r.RUnlock()
 
-	// TODO: we'd like to lock this child node but locked nodes have other
-	//  side effects like the ability to be branched or cloned.  Perhaps add
-	//  another node-level property saying it's read-only at this time, not
-	//  for all time.  Could require separate API call to retrieve final child
-	//  UUID given an immediately returned token.
-	switch mt {
-	case MergeConflictFree:
-		// No processing needs to be done except for metadata changes.
-		// Any issues will be noted during key-value lookup while traversing the DAG.
-
-	case MergeTypeSpecificAuto:
-		return dvid.NilUUID, fmt.Errorf("the type-specific auto merge has not been implemented yet")
-		// go r.asyncMerge(parentNode1, parentNode2, child)
-
-	case MergeExternalData:
-		return dvid.NilUUID, fmt.Errorf("merging with external data has not been implemented yet")
-
-	default:
-		return dvid.NilUUID, ErrBadMergeType
+	resolved := make(map[DataService][]*storage.TKeyValue, len(instances))
+	for _, data := range instances {
+		s := strategy
+		if ts, found := typeStrategies[data.TypeName()]; found {
+			s = ts
+		}
+		var kvs []*storage.TKeyValue
+		var err error
+		if merger, ok := data.(VersionMerger); ok {
+			kvs, err = merger.MergeVersions(parentsV, s)
+		} else {
+			kvs, err = MergeKeyValues(data, parentsV, s)
+		}
+		if err != nil {
+			return dvid.NilUUID, fmt.Errorf("unable to merge data %q: %v", data.DataName(), err)
+		}
+		if len(kvs) != 0 {
+			resolved[data] = kvs
+		}
 	}
 
-	r.updated = time.Now()
-	return child.uuid, r.save()
+	childUUID, err := m.merge(parents, note, MergeConflictFree)
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+	childV, err := m.versionFromUUID(childUUID)
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+	for data, kvs := range resolved {
+		store, err := getOrderedKeyValueDB(data)
+		if err != nil {
+			return dvid.NilUUID, err
+		}
+		ctx := NewVersionedCtx(data, childV)
+		for _, kv := range kvs {
+			if kv.V == nil {
+				err = store.Delete(ctx, kv.K)
+			} else {
+				err = store.Put(ctx, kv.K, kv.V)
+			}
+			if err != nil {
+				return dvid.NilUUID, fmt.Errorf("merged child %s created but unable to write resolved data %q: %v", childUUID, data.DataName(), err)
+			}
+		}
+		dvid.Infof("Resolved %d conflicting key-value pairs of data %q in merged child %s\n", len(kvs), data.DataName(), childUUID)
+	}
+	return childUUID, nil
 }
 
 func (m *repoManager) invalidateAncestors(kvv kvVersions, v dvid.VersionID) error {
@@ -1624,50 +2453,123 @@ func (m *repoManager) findMatch(kvv kvVersions, v dvid.VersionID) (*storage.KeyV
 
 // ----- Repo-level data instance functions -----
 
+// storeAliaser is implemented by data instances that can be assigned a store on creation.
+type storeAliaser interface {
+	StoreAlias() storage.Alias
+}
+
+// assignedStore returns the store for a data instance, preferring any store chosen
+// on creation over the backend configuration.
+func assignedStore(d dvid.Data) (dvid.Store, error) {
+	if sa, ok := d.(storeAliaser); ok && sa.StoreAlias() != "" {
+		return storage.GetAliasedStore(sa.StoreAlias(), d.DataName(), d.RootUUID(), d.TypeName())
+	}
+	return storage.GetAssignedStore(d.DataName(), d.RootUUID(), d.TypeName())
+}
+
+// newData creates a data instance, persisting its instance ID allocation and the repo in
+// one metadata batch.  If the instance can't be initialized or saved, the in-memory repo
+// and manager are left unchanged, and its instance ID is released unless another ID was
+// allocated since.  The released ID was never persisted with an instance, so it's safe
+// to allocate again even if a partial save persisted the advanced ID counter.
 func (m *repoManager) newData(uuid dvid.UUID, t TypeService, name dvid.InstanceName, c dvid.Config) (DataService, error) {
-	id, err := m.newInstanceID()
+	r, err := m.repoFromUUID(uuid)
 	if err != nil {
 		return nil, err
 	}
-	r, err := m.repoFromUUID(uuid)
+	r.RLock()
+	namespace := r.namespace()
+	r.RUnlock()
+
+	m.idMutex.Lock()
+	prevID, prevNamespaceID := m.instanceID, m.namespaceIDs[namespace]
+	id, err := m.allocInstanceID(namespace)
+	nextID, nextNamespaceID := m.instanceID, m.namespaceIDs[namespace]
+	m.idMutex.Unlock()
 	if err != nil {
 		return nil, err
 	}
+	releaseID := func() {
+		m.idMutex.Lock()
+		defer m.idMutex.Unlock()
+		if m.instanceID == nextID {
+			m.instanceID = prevID
+		}
+		if nextNamespaceID != prevNamespaceID && m.namespaceIDs[namespace] == nextNamespaceID {
+			if prevNamespaceID == 0 {
+				delete(m.namespaceIDs, namespace)
+			} else {
+				m.namespaceIDs[namespace] = prevNamespaceID
+			}
+		}
+	}
 
 	r.Lock()
 	defer r.Unlock()
 
 	// Only allow unique data name per repo
 	if _, found := r.data[name]; found {
+		releaseID()
 		return nil, fmt.Errorf("Data named %q already exists in repo (root %s)", name, r.uuid)
 	}
+	r.setDefaultCompression(&c)
 	dataservice, err := t.NewDataService(uuid, id, name, c)
 	if err != nil {
+		releaseID()
 		return nil, err
 	}
+	oldUpdated, oldLogLen := r.updated, len(r.log)
 	r.data[name] = dataservice
 	m.iids[id] = dataservice
-	m.dataByUUID[dataservice.DataUUID()] = dataservice
-
-	r.updated = time.Now()
+	m.dataByUUID[dataservice.DataUUID()] = dataservice
+	rollback := func() {
+		delete(r.data, name)
+		delete(m.iids, id)
+		delete(m.dataByUUID, dataservice.DataUUID())
+		if len(r.log) >= oldLogLen {
+			r.updated, r.log = oldUpdated, r.log[:oldLogLen]
+		}
+		releaseID()
+	}
 
 	// If it can be initialized (e.g., start sync handlers, etc), do it.
 	initializer, initializable := dataservice.(DataInitializer)
 	if initializable {
 		err := initializer.InitDataHandlers()
 		if err != nil {
+			rollback()
 			return nil, err
 		}
 		dvid.Infof("Initialized data handlers for instance %q on repo load.\n", dataservice.DataName())
 	}
 
-	// Add to log and save repo
+	// Add to log and save repo with the next ids, which are locked so a concurrent
+	// allocation can't be overwritten by the older value.
 	tm := time.Now()
 	r.updated = tm
 	msg := fmt.Sprintf("New data instance %q of type %q with config %v", name, dataservice.TypeName(), c)
 	message := fmt.Sprintf("%s  %s", tm.Format(time.RFC3339), msg)
 	r.log = append(r.log, message)
-	return dataservice, r.save()
+
+	batch := m.newMetadataBatch()
+	m.idMutex.RLock()
+	batch.Put(storage.NewTKey(newIDsKey, nil), m.newIDsValue())
+	if len(m.namespaceIDs) != 0 {
+		batch.Put(storage.NewTKey(namespaceIDsKey, nil), m.namespaceIDsValue())
+	}
+	err = r.saveWithBatch(batch)
+	m.idMutex.RUnlock()
+	if err != nil {
+		rollback()
+		if shutdowner, ok := dataservice.(Shutdowner); ok && initializable {
+			wg := new(sync.WaitGroup)
+			wg.Add(1)
+			go shutdowner.Shutdown(wg)
+			wg.Wait()
+		}
+		return nil, err
+	}
+	return dataservice, nil
 }
 
 // Replaces or appends to any previous syncs the given ones and sets up the sync graph for pub/sub.
@@ -1770,6 +2672,22 @@ func (m *repoManager) getDataByUUIDName(uuid dvid.UUID, name dvid.InstanceName)
 	return data, nil
 }
 
+func (m *repoManager) getRepoData(uuid dvid.UUID) ([]DataService, error) {
+	r, err := m.repoFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	r.RLock()
+	instances := make([]DataService, 0, len(r.data))
+	for _, data := range r.data {
+		instances = append(instances, data)
+	}
+	r.RUnlock()
+	sort.Slice(instances, func(i, j int) bool { return instances[i].DataName() < instances[j].DataName() })
+	return instances, nil
+}
+
 func (m *repoManager) getDataByVersionName(v dvid.VersionID, name dvid.InstanceName) (DataService, error) {
 	r, err := m.repoFromVersion(v)
 	if err != nil {
@@ -1795,10 +2713,14 @@ func (m *repoManager) renameDataByName(uuid dvid.UUID, oldname, newname dvid.Ins
 		return fmt.Errorf("incorrect passcode for repo %s", r.uuid)
 	}
 
+	if newname == "" {
+		return fmt.Errorf("data instance %q can't be renamed to an empty name", oldname)
+	}
+
 	r.Lock()
 	defer r.Unlock()
 
-	_, found := r.data[oldname]
+	data, found := r.data[oldname]
 	if !found {
 		return ErrInvalidDataName
 	}
@@ -1807,40 +2729,53 @@ func (m *repoManager) renameDataByName(uuid dvid.UUID, oldname, newname dvid.Ins
 		return ErrExistingDataName
 	}
 
+	// Stores assigned by instance name in the backend configuration would change on restart.
+	if !storage.SameInstanceAssignment(data.RootUUID(), oldname, newname) {
+		return fmt.Errorf("data %q has stores assigned by name in the backend configuration, which must be assigned to %q before renaming", oldname, newname)
+	}
+
 	// Rename this data instance in the repository and persist.
 	tm := time.Now()
 	r.updated = tm
 	msg := fmt.Sprintf("Renamed data instance %q to %q", oldname, newname)
 	message := fmt.Sprintf("%s  %s", tm.Format(time.RFC3339), msg)
 	r.log = append(r.log, message)
-	r.data[newname] = r.data[oldname]
-	r.data[newname].SetName(newname)
+	r.data[newname] = data
+	data.SetName(newname)
 	delete(r.data, oldname)
 
-	return r.save()
+	// Undo the rename if it can't be persisted.
+	if err := r.save(); err != nil {
+		r.log = r.log[:len(r.log)-1]
+		r.data[oldname] = data
+		data.SetName(oldname)
+		delete(r.data, newname)
+		return err
+	}
+	return nil
 }
 
 // deleteDataByName deletes all data associated with the data instance and removes
-// it from the Repo.
-func (m *repoManager) deleteDataByName(uuid dvid.UUID, name dvid.InstanceName, passcode string) error {
+// it from the Repo, returning the ID of the job reclaiming the instance's space.
+func (m *repoManager) deleteDataByName(uuid dvid.UUID, name dvid.InstanceName, passcode string) (uint64, error) {
 	r, err := m.repoFromUUID(uuid)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	return m.deleteData(r, name, passcode)
 }
 
-func (m *repoManager) deleteDataByVersion(v dvid.VersionID, name dvid.InstanceName, passcode string) error {
+func (m *repoManager) deleteDataByVersion(v dvid.VersionID, name dvid.InstanceName, passcode string) (uint64, error) {
 	r, err := m.repoFromVersion(v)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	return m.deleteData(r, name, passcode)
 }
 
-func (m *repoManager) deleteData(r *repoT, name dvid.InstanceName, passcode string) error {
+func (m *repoManager) deleteData(r *repoT, name dvid.InstanceName, passcode string) (uint64, error) {
 	if r.passcode != "" && r.passcode != passcode {
-		return fmt.Errorf("incorrect passcode for repo %s", r.uuid)
+		return 0, fmt.Errorf("incorrect passcode for repo %s", r.uuid)
 	}
 
 	r.Lock()
@@ -1848,7 +2783,7 @@ func (m *repoManager) deleteData(r *repoT, name dvid.InstanceName, passcode stri
 
 	data, found := r.data[name]
 	if !found {
-		return ErrInvalidDataName
+		return 0, ErrInvalidDataName
 	}
 
 	// Delete entries in the sync graph if this data needs to be synced with another data instance.
@@ -1864,15 +2799,205 @@ func (m *repoManager) deleteData(r *repoT, name dvid.InstanceName, passcode stri
 	message := fmt.Sprintf("%s  %s", tm.Format(time.RFC3339), msg)
 	r.log = append(r.log, message)
 	delete(r.data, name)
+	if err := r.save(); err != nil {
+		return 0, err
+	}
 
 	// For all data tiers of storage, remove data key-value pairs that would be associated with this instance id.
+	return reclaimData(data), nil
+}
+
+// reclaimData starts a job that deletes all key-value pairs of a deleted data instance
+// and compacts them in its store, returning the job ID.
+func reclaimData(data dvid.Data) uint64 {
+	job := newJob("delete data", fmt.Sprintf("data %q (instance %d)", data.DataName(), data.InstanceID()))
 	go func() {
+		setJobStep(job, "deleting key-value pairs")
 		if err := storage.DeleteDataInstance(data); err != nil {
-			dvid.Errorf("Error trying to do async data instance deletion: %v\n", err)
+			dvid.Errorf("Error trying to do async data instance %q deletion: %v\n", data.DataName(), err)
+			finishJob(job, err)
+			return
+		}
+		setJobStep(job, "compacting")
+		if err := compactDataInstance(data); err != nil {
+			dvid.Infof("Skipping compaction after deleting data %q: %v\n", data.DataName(), err)
 		}
+		finishJob(job, nil)
 	}()
+	return job
+}
 
-	return r.save()
+// trashedData is a data instance removed from a repo that can be restored until it is
+// purged after TrashRetention.
+type trashedData struct {
+	Data    DataService
+	Trashed time.Time
+}
+
+// trashData removes the named data instance from the repo like deleteData but keeps it
+// in the repo's trash instead of reclaiming its key-value pairs.
+func (m *repoManager) trashData(uuid dvid.UUID, name dvid.InstanceName, passcode string) error {
+	r, err := m.repoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	if r.passcode != "" && r.passcode != passcode {
+		return fmt.Errorf("incorrect passcode for repo %s", r.uuid)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	data, found := r.data[name]
+	if !found {
+		return ErrInvalidDataName
+	}
+	if _, syncable := data.(Syncer); syncable {
+		r.deleteSyncGraph(data, false)
+	}
+
+	tm := time.Now()
+	r.updated = tm
+	msg := fmt.Sprintf("Moved data instance '%s' of type '%s' to trash", name, data.TypeName())
+	message := fmt.Sprintf("%s  %s", tm.Format(time.RFC3339), msg)
+	r.log = append(r.log, message)
+	delete(r.data, name)
+	r.trash[data.DataUUID()] = trashedData{data, tm}
+	if err := r.save(); err != nil {
+		r.log = r.log[:len(r.log)-1]
+		delete(r.trash, data.DataUUID())
+		r.data[name] = data
+		r.addDataSyncs(data)
+		return err
+	}
+	return nil
+}
+
+// restoreData moves the most recently trashed data instance with the given name back
+// into the repo and restores its syncs.
+func (m *repoManager) restoreData(uuid dvid.UUID, name dvid.InstanceName) error {
+	r, err := m.repoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if _, found := r.data[name]; found {
+		return fmt.Errorf("cannot restore data %q since repo %s already has an instance with that name", name, r.uuid)
+	}
+	var trashed *trashedData
+	for _, t := range r.trash {
+		if t.Data.DataName() == name && (trashed == nil || t.Trashed.After(trashed.Trashed)) {
+			t := t
+			trashed = &t
+		}
+	}
+	if trashed == nil {
+		return fmt.Errorf("no data %q in trash of repo %s", name, r.uuid)
+	}
+	data := trashed.Data
+
+	tm := time.Now()
+	r.updated = tm
+	msg := fmt.Sprintf("Restored data instance '%s' of type '%s' from trash", name, data.TypeName())
+	message := fmt.Sprintf("%s  %s", tm.Format(time.RFC3339), msg)
+	r.log = append(r.log, message)
+	delete(r.trash, data.DataUUID())
+	r.data[name] = data
+	if err := r.save(); err != nil {
+		r.log = r.log[:len(r.log)-1]
+		delete(r.data, name)
+		r.trash[data.DataUUID()] = *trashed
+		return err
+	}
+	r.addDataSyncs(data)
+	return nil
+}
+
+func (m *repoManager) getTrash(uuid dvid.UUID) ([]TrashedData, error) {
+	r, err := m.repoFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+
+	trash := make([]TrashedData, 0, len(r.trash))
+	for _, t := range r.trash {
+		trash = append(trash, TrashedData{
+			Name:     t.Data.DataName(),
+			Type:     t.Data.TypeName(),
+			DataUUID: t.Data.DataUUID(),
+			Trashed:  t.Trashed,
+			Expires:  t.Trashed.Add(TrashRetention),
+		})
+	}
+	sort.Slice(trash, func(i, j int) bool { return trash[i].Trashed.Before(trash[j].Trashed) })
+	return trash, nil
+}
+
+// purgeTrash deletes data instances that have been in the trash longer than TrashRetention,
+// starting jobs to reclaim their key-value pairs.
+func (m *repoManager) purgeTrash() {
+	m.RLock()
+	repos := make([]*repoT, 0, len(m.repoToUUID))
+	for _, uuid := range m.repoToUUID {
+		if r, found := m.repos[uuid]; found {
+			repos = append(repos, r)
+		}
+	}
+	m.RUnlock()
+
+	cutoff := time.Now().Add(-TrashRetention)
+	for _, r := range repos {
+		r.Lock()
+		var expired []trashedData
+		for dataUUID, t := range r.trash {
+			if t.Trashed.Before(cutoff) {
+				expired = append(expired, t)
+				delete(r.trash, dataUUID)
+			}
+		}
+		if len(expired) == 0 {
+			r.Unlock()
+			continue
+		}
+		tm := time.Now()
+		r.updated = tm
+		for _, t := range expired {
+			msg := fmt.Sprintf("Delete trashed data instance '%s' of type '%s'", t.Data.DataName(), t.Data.TypeName())
+			r.log = append(r.log, fmt.Sprintf("%s  %s", tm.Format(time.RFC3339), msg))
+		}
+		err := r.save()
+		r.Unlock()
+		if err != nil {
+			dvid.Errorf("Unable to save repo %s after purging its trash: %v\n", r.uuid, err)
+			continue
+		}
+		for _, t := range expired {
+			job := reclaimData(t.Data)
+			dvid.Infof("Purged data %q from trash of repo %s, reclaiming its space in job %d\n", t.Data.DataName(), r.uuid, job)
+		}
+	}
+}
+
+// trashPurgeInterval is how often trashed data instances are checked for expiration.
+const trashPurgeInterval = time.Hour
+
+var startTrashPurge sync.Once
+
+// purgeTrashPeriodically purges expired trash of the current repo manager now and
+// every trashPurgeInterval.
+func purgeTrashPeriodically() {
+	for {
+		if m := manager; m != nil {
+			m.purgeTrash()
+		}
+		time.Sleep(trashPurgeInterval)
+	}
 }
 
 // modifyData modifies preexisting Data within a Repo.  Settings can be passed
@@ -1919,6 +3044,9 @@ type repoT struct {
 
 	properties map[string]interface{}
 
+	// tags are names for nodes that can be used in place of their UUIDs.
+	tags map[string]dvid.UUID
+
 	created time.Time
 	updated time.Time
 
@@ -1926,9 +3054,38 @@ type repoT struct {
 
 	data map[dvid.InstanceName]DataService
 
+	// trash holds data instances removed via trashData, keyed by data UUID, until they
+	// are restored or purged.
+	trash map[dvid.UUID]trashedData
+
 	// subs holds subscriptions to change events for each data instance.
 	// This is not persisted.  It is built on load or modification of syncs.
 	subs map[SyncEvent]SyncSubs
+
+	// revision is the stored revision of the repo's metadata when last loaded or saved.
+	// It is persisted under its own key rather than with the repo.
+	revision uint64
+
+	// saved is the repo's serialization when last loaded or saved, and savedData and
+	// savedTrash its data instances then.  The repo is restored to them if a save fails
+	// because the repo was saved elsewhere.
+	saved      []byte
+	savedData  map[dvid.InstanceName]DataService
+	savedTrash map[dvid.UUID]trashedData
+
+	// formatVersion is the stored format version of the repo's metadata, which is
+	// persisted under its own key and migrated to RepoFormatVersion when loaded.
+	formatVersion uint64
+
+	// stub is true for a repo that hasn't been paged in, which only has its id and root.
+	// Stubs are replaced by the loaded repo rather than modified.
+	stub bool
+
+	// accessMu protects the access times of nodes and data instances, which are recorded
+	// without locking the repo for writing and saved periodically if dirty.
+	accessMu    sync.Mutex
+	accessDirty bool
+	dataAccess  map[dvid.UUID]*accessTimes // keyed by data UUID
 }
 
 // newRepo creates a new repository given a UUID, version, and RepoID,
@@ -1944,7 +3101,10 @@ func newRepo(uuid dvid.UUID, v dvid.VersionID, id dvid.RepoID, passcode string)
 		passcode:   passcode,
 		log:        []string{},
 		properties: make(map[string]interface{}),
+		tags:       make(map[string]dvid.UUID),
 		data:       make(map[dvid.InstanceName]DataService),
+		trash:      make(map[dvid.UUID]trashedData),
+		dataAccess: make(map[dvid.UUID]*accessTimes),
 		created:    t,
 		updated:    t,
 	}
@@ -1994,6 +3154,16 @@ func (r *repoT) duplicate(versions map[dvid.VersionID]struct{}, names dvid.Insta
 
 	dup.dag = r.dag.duplicate(versions)
 
+	dup.tags = make(map[string]dvid.UUID, len(r.tags))
+	for tag, uuid := range r.tags {
+		for _, node := range dup.dag.nodes {
+			if node.uuid == uuid {
+				dup.tags[tag] = uuid
+				break
+			}
+		}
+	}
+
 	if len(names) == 0 {
 		dup.data = make(map[dvid.InstanceName]DataService, len(r.data))
 		for k, v := range r.data {
@@ -2010,6 +3180,8 @@ func (r *repoT) duplicate(versions map[dvid.VersionID]struct{}, names dvid.Insta
 		}
 	}
 
+	dup.trash = make(map[dvid.UUID]trashedData)
+
 	dup.subs = make(map[SyncEvent]SyncSubs, len(r.subs))
 	for k, v := range r.subs {
 		dup.subs[k] = v
@@ -2051,15 +3223,27 @@ func (r *repoT) GobDecode(b []byte) error {
 	if err := dec.Decode(&(r.dag)); err != nil {
 		return err
 	}
-	// passcode may not exist.
+	// passcode, tags, and trash may not exist.
 	if err := dec.Decode(&(r.passcode)); err != nil {
 		r.passcode = ""
 	}
+	if err := dec.Decode(&(r.tags)); err != nil || r.tags == nil {
+		r.tags = make(map[string]dvid.UUID)
+	}
+	if err := dec.Decode(&(r.trash)); err != nil || r.trash == nil {
+		r.trash = make(map[dvid.UUID]trashedData)
+	}
+	if err := dec.Decode(&(r.dataAccess)); err != nil || r.dataAccess == nil {
+		r.dataAccess = make(map[dvid.UUID]*accessTimes)
+	}
 	r.version = r.dag.rootV
 	return nil
 }
 
 func (r *repoT) GobEncode() ([]byte, error) {
+	r.accessMu.Lock()
+	defer r.accessMu.Unlock()
+
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 	if err := enc.Encode(r.id); err != nil {
@@ -2095,17 +3279,37 @@ func (r *repoT) GobEncode() ([]byte, error) {
 	if err := enc.Encode(r.passcode); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(r.tags); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(r.trash); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(r.dataAccess); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
 func (r *repoT) MarshalJSON() ([]byte, error) {
+	r.accessMu.Lock()
+	defer r.accessMu.Unlock()
+
+	dataAccess := make(map[dvid.InstanceName]*accessTimes)
+	for name, d := range r.data {
+		if access, found := r.dataAccess[d.DataUUID()]; found {
+			dataAccess[name] = access
+		}
+	}
 	return json.Marshal(struct {
 		Root        dvid.UUID
 		Alias       string
 		Description string
 		Log         []string
 		Properties  map[string]interface{}
-		Data        map[dvid.InstanceName]DataService `json:"DataInstances"`
+		Tags        map[string]dvid.UUID
+		Data        map[dvid.InstanceName]DataService  `json:"DataInstances"`
+		DataAccess  map[dvid.InstanceName]*accessTimes `json:",omitempty"`
 		DAG         *dagT
 		Created     time.Time
 		Updated     time.Time
@@ -2115,7 +3319,9 @@ func (r *repoT) MarshalJSON() ([]byte, error) {
 		r.description,
 		r.log,
 		r.properties,
+		r.tags,
 		r.data,
+		dataAccess,
 		r.dag,
 		r.created,
 		r.updated,
@@ -2151,7 +3357,23 @@ func (r *repoT) notifySubscribers(e SyncEvent, m SyncMessage) error {
 	return nil
 }
 
+// publishNewVersion publishes the creation of a child node.  The repo must be locked.
+func (r *repoT) publishNewVersion(child *nodeT) {
+	e := Event{Type: EventNewVersion, Repo: r.uuid, UUID: child.uuid, Note: child.note}
+	for _, v := range child.parents {
+		if parent, found := r.dag.nodes[v]; found {
+			e.Parents = append(e.Parents, parent.uuid)
+		}
+	}
+	PublishEvent(e)
+}
+
 func (r *repoT) save() error {
+	return r.saveWithBatch(manager.newMetadataBatch())
+}
+
+// saveWithBatch saves the repo along with any operations already in the given batch.
+func (r *repoT) saveWithBatch(batch storage.Batch) error {
 	compression, err := dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
 	if err != nil {
 		return err
@@ -2161,15 +3383,188 @@ func (r *repoT) save() error {
 		return err
 	}
 
+	summary, err := r.encodeSummary()
+	if err != nil {
+		return err
+	}
+
+	// The stored revision is checked and the next one written with the repo, its format
+	// version, and its summary in one batch, so a crash can't leave them inconsistent and
+	// a concurrent save of the same repo by another goroutine or, for metadata stores
+	// with transactions, another server fails instead of being silently overwritten.
+	revisionMu.Lock()
+	defer revisionMu.Unlock()
+	var ctx storage.MetadataContext
+	if tdb, ok := manager.store.(storage.TransactionDB); ok {
+		lockKey := ctx.ConstructKey(storage.NewTKey(repoSaveLockKey, r.id.Bytes()))
+		if err := tdb.LockKey(lockKey); err != nil {
+			return err
+		}
+		defer tdb.UnlockKey(lockKey)
+	}
+	revisionTKey := storage.NewTKey(repoRevisionKey, r.id.Bytes())
+	value, err := manager.store.Get(ctx, revisionTKey)
+	if err != nil {
+		return err
+	}
+	stored, err := decodeRepoRevision(value)
+	if err != nil {
+		return err
+	}
+	if stored != r.revision {
+		err := &RepoConflictError{UUID: r.uuid, Expected: r.revision, Stored: stored}
+		if rerr := r.restore(); rerr != nil {
+			dvid.Errorf("Unable to restore repo %s after failed save: %v\n", r.uuid, rerr)
+		}
+		return err
+	}
+
+	batch.Put(revisionTKey, encodeRepoRevision(stored+1))
+	batch.Put(storage.NewTKey(repoKey, r.id.Bytes()), serialization)
+	if r.formatVersion != RepoFormatVersion {
+		batch.Put(storage.NewTKey(repoFormatKey, r.id.Bytes()), encodeRepoRevision(RepoFormatVersion))
+	}
+	batch.Put(storage.NewTKey(repoSummaryKey, r.id.Bytes()), summary)
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	r.revision = stored + 1
+	r.formatVersion = RepoFormatVersion
+	r.markSaved(serialization)
+	return nil
+}
+
+// revisionMu serializes the revision checks and writes of repo saves in this process.
+var revisionMu sync.Mutex
+
+// markSaved records the serialization and data instances of the repo as last loaded or
+// saved, so the repo can be restored to them.
+func (r *repoT) markSaved(serialization []byte) {
+	r.saved = serialization
+	r.savedData = make(map[dvid.InstanceName]DataService, len(r.data))
+	for name, d := range r.data {
+		r.savedData[name] = d
+	}
+	r.savedTrash = make(map[dvid.UUID]trashedData, len(r.trash))
+	for dataUUID, t := range r.trash {
+		r.savedTrash[dataUUID] = t
+	}
+}
+
+// restore reverts the repo's metadata to when it was last loaded or saved, discarding
+// changes that couldn't be saved.  Data instances are restored as the same objects, so
+// their handlers and any state outside the repo's metadata are kept.
+func (r *repoT) restore() error {
+	if r.saved == nil {
+		return fmt.Errorf("repo %s has no saved state", r.uuid)
+	}
+	old := new(repoT)
+	if err := dvid.Deserialize(r.saved, old); err != nil {
+		return err
+	}
+	r.alias, r.description, r.passcode = old.alias, old.description, old.passcode
+	r.log, r.properties, r.tags = old.log, old.properties, old.tags
+	r.created, r.updated = old.created, old.updated
+	r.dag, r.version = old.dag, old.version
+	r.data = make(map[dvid.InstanceName]DataService, len(r.savedData))
+	for name, d := range r.savedData {
+		r.data[name] = d
+	}
+	r.trash = make(map[dvid.UUID]trashedData, len(r.savedTrash))
+	for dataUUID, t := range r.savedTrash {
+		r.trash[dataUUID] = t
+	}
+	return nil
+}
+
+// repoSummary is stored apart from each repo so a stub for the repo can be mapped to all
+// its versions without decoding the repo.
+type repoSummary struct {
+	Root     dvid.UUID
+	Version  dvid.VersionID
+	Versions []dvid.VersionID
+}
+
+func (r *repoT) saveSummary() error {
+	summary, err := r.encodeSummary()
+	if err != nil {
+		return err
+	}
 	var ctx storage.MetadataContext
-	return manager.store.Put(ctx, storage.NewTKey(repoKey, r.id.Bytes()), serialization)
+	return manager.store.Put(ctx, storage.NewTKey(repoSummaryKey, r.id.Bytes()), summary)
+}
+
+func (r *repoT) encodeSummary() ([]byte, error) {
+	summary := repoSummary{Root: r.uuid, Version: r.version}
+	for v := range r.dag.nodes {
+		summary.Versions = append(summary.Versions, v)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&summary); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 // deletes a Repo from the datastore
 func (r *repoT) delete() error {
+	batch := manager.newMetadataBatch()
+	batch.Delete(storage.NewTKey(repoKey, r.id.Bytes()))
+	batch.Delete(storage.NewTKey(repoSummaryKey, r.id.Bytes()))
+	batch.Delete(storage.NewTKey(repoFormatKey, r.id.Bytes()))
+	batch.Delete(storage.NewTKey(repoRevisionKey, r.id.Bytes()))
+	batch.Delete(storage.NewTKey(repoRolesKey, r.id.Bytes()))
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	manager.roleMutex.Lock()
+	delete(manager.roles, r.id)
+	manager.roleMutex.Unlock()
+	return nil
+}
+
+// RepoConflictError is returned when a repo's metadata can't be saved because it was
+// saved elsewhere, e.g., by another server sharing the metadata store, since it was
+// loaded.  The repo's unsaved changes are discarded, and it must be reloaded before it
+// can be modified.
+type RepoConflictError struct {
+	UUID     dvid.UUID // root of the repo
+	Expected uint64    // revision when loaded or last saved
+	Stored   uint64    // revision in the metadata store
+}
+
+func (e *RepoConflictError) Error() string {
+	return fmt.Sprintf("repo %s metadata is at revision %d, not %d, and was modified elsewhere; reload before changing it", e.UUID, e.Stored, e.Expected)
+}
+
+// loadRevision sets the repo's revision to the one in the given metadata store.
+func (r *repoT) loadRevision(store storage.KeyValueDB) error {
 	var ctx storage.MetadataContext
-	tkey := storage.NewTKey(repoKey, r.id.Bytes())
-	return manager.store.Delete(ctx, tkey)
+	value, err := store.Get(ctx, storage.NewTKey(repoRevisionKey, r.id.Bytes()))
+	if err != nil {
+		return err
+	}
+	r.revision, err = decodeRepoRevision(value)
+	return err
+}
+
+// encodeRepoRevision and decodeRepoRevision convert between a revision and its stored
+// value.  Repos saved before revisions were tracked have no stored value and are at
+// revision 0.
+func encodeRepoRevision(revision uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, revision)
+	return buf
+}
+
+func decodeRepoRevision(value []byte) (uint64, error) {
+	if value == nil {
+		return 0, nil
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("bad repo revision value of %d bytes", len(value))
+	}
+	return binary.BigEndian.Uint64(value), nil
 }
 
 // relatively slow function compared to manager's cache, but can be used for
@@ -2245,6 +3640,36 @@ func (r *repoT) addSyncGraph(subs SyncSubs) {
 	}
 }
 
+// Adds subscriptions to and from a data instance given the syncs of it and the other
+// data instances in the repo, e.g., when restoring a trashed instance.
+func (r *repoT) addDataSyncs(data DataService) {
+	for _, d := range r.data {
+		if syncer, syncable := data.(Syncer); syncable {
+			if _, found := syncer.SyncedData()[d.DataUUID()]; found {
+				subs, err := syncer.GetSyncSubs(d)
+				if err != nil {
+					dvid.Criticalf("Skipping bad sync of data %q to data %q: %v\n", data.DataName(), d.DataName(), err)
+				} else {
+					r.addSyncGraph(subs)
+				}
+			}
+		}
+		if d == data {
+			continue
+		}
+		if syncer, syncable := d.(Syncer); syncable {
+			if _, found := syncer.SyncedData()[data.DataUUID()]; found {
+				subs, err := syncer.GetSyncSubs(data)
+				if err != nil {
+					dvid.Criticalf("Skipping bad sync of data %q to data %q: %v\n", d.DataName(), data.DataName(), err)
+				} else {
+					r.addSyncGraph(subs)
+				}
+			}
+		}
+	}
+}
+
 // Deletes subscriptions to and from a data instance unless the onlyFor parameter is true.
 // This does not close whatever event handlers are running in a data instance, since
 // these are closed on server Shutdown.
@@ -2485,6 +3910,7 @@ type nodeT struct {
 	uuid    dvid.UUID
 	version dvid.VersionID
 	locked  bool
+	commit  CommitInfo // zero value if not committed or committed before it was recorded
 
 	// In the case of multiple parents, parents[0] is the default traversal for
 	// an ancestor path.  It's assumed that any merger operation either creates
@@ -2494,6 +3920,8 @@ type nodeT struct {
 
 	created time.Time
 	updated time.Time
+
+	access accessTimes // protected by the repo's accessMu.
 }
 
 // duplicate creates a duplicate node, limiting the data instances
@@ -2510,6 +3938,7 @@ func (node *nodeT) duplicate(versions map[dvid.VersionID]struct{}) *nodeT {
 	dup.uuid = node.uuid
 	dup.version = node.version
 	dup.locked = node.locked
+	dup.commit = node.commit
 
 	dup.parents = make([]dvid.VersionID, len(node.parents))
 	dup.children = make([]dvid.VersionID, len(node.children))
@@ -2586,8 +4015,14 @@ func (node *nodeT) GobDecode(b []byte) error {
 		return err
 	}
 
-	// support unspecified branches for legacy dvid instances
-	dec.Decode(&(node.branch))
+	// support unspecified branches and commit info for legacy dvid instances
+	if err := dec.Decode(&(node.branch)); err != nil {
+		return nil
+	}
+	if err := dec.Decode(&(node.commit)); err != nil {
+		return nil
+	}
+	dec.Decode(&(node.access))
 
 	return nil
 }
@@ -2632,6 +4067,12 @@ func (node *nodeT) GobEncode() ([]byte, error) {
 	if err := enc.Encode(node.branch); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(node.commit); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(node.access); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -2643,10 +4084,13 @@ func (node *nodeT) MarshalJSON() ([]byte, error) {
 		UUID      dvid.UUID
 		VersionID dvid.VersionID
 		Locked    bool
+		Commit    *CommitInfo `json:",omitempty"`
 		Parents   []dvid.VersionID
 		Children  []dvid.VersionID
 		Created   time.Time
 		Updated   time.Time
+		LastRead  *time.Time `json:",omitempty"`
+		LastWrite *time.Time `json:",omitempty"`
 	}{
 		node.branch,
 		node.note,
@@ -2654,10 +4098,13 @@ func (node *nodeT) MarshalJSON() ([]byte, error) {
 		node.uuid,
 		node.version,
 		node.locked,
+		node.commitInfo(),
 		node.parents,
 		node.children,
 		node.created,
 		node.updated,
+		node.access.lastRead(),
+		node.access.lastWrite(),
 	})
 }
 
@@ -2674,6 +4121,15 @@ func newNode(uuid dvid.UUID, versionID dvid.VersionID) *nodeT {
 	}
 }
 
+// commitInfo returns the node's commit information or nil if none was recorded.
+func (node *nodeT) commitInfo() *CommitInfo {
+	if !node.locked || node.commit.Time.IsZero() {
+		return nil
+	}
+	info := node.commit
+	return &info
+}
+
 func (node *nodeT) addToLog(msgs []string) error {
 	t := time.Now()
 	for _, msg := range msgs {
@@ -3,10 +3,15 @@
 package datastore
 
 import (
+	"fmt"
+	"io/ioutil"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
 )
 
 func TestRepoGobEncoding(t *testing.T) {
@@ -128,6 +133,42 @@ func TestRepoPersistence(t *testing.T) {
 	}
 }
 
+func TestLazyRepoLoading(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	makeTestVersions(t)
+	jsonBytes, err := MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart with lazy loading.
+	storage.Close()
+	initMetadata, err := storage.Initialize(dvid.Config{}, testStore.backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Initialize(initMetadata, &InstanceConfig{LazyLoad: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	uuid, _, err := MatchingUUID("0c8bc973dba7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonBytes2, err := MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(jsonBytes, jsonBytes2) {
+		t.Errorf("\nRepo metadata JSON changes on lazy load:\n\nOld:\n%s\n\nNew:\n%s\n", string(jsonBytes), string(jsonBytes2))
+	}
+	if err := SetNodeNote(uuid, "note after lazy load"); err != nil {
+		t.Fatalf("couldn't modify lazily loaded repo: %v\n", err)
+	}
+}
+
 // Make sure each new repo has a different local ID.
 func TestNewRepoDifferent(t *testing.T) {
 	OpenTest()
@@ -203,3 +244,720 @@ func TestUUIDAssignment(t *testing.T) {
 		t.Errorf("Error getting back correct UUID %s from %s\n", myuuid, uuid)
 	}
 }
+
+func TestRepoQuota(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	uuid, _ := NewTestRepo()
+	if err := CheckRepoQuota(uuid); err != nil {
+		t.Fatalf("unexpected error for repo without quota: %v\n", err)
+	}
+	if err := SetRepoQuota(uuid, 100); err != nil {
+		t.Fatalf("unable to set repo quota: %v\n", err)
+	}
+	if err := CheckRepoQuota(uuid); err != nil {
+		t.Fatalf("unexpected error for empty repo with quota: %v\n", err)
+	}
+	AddRepoBytes(uuid, 150)
+	err := CheckRepoQuota(uuid)
+	qerr, ok := err.(QuotaExceededError)
+	if !ok {
+		t.Fatalf("expected quota exceeded error, got %v\n", err)
+	}
+	if qerr.Repo != uuid || qerr.Quota != 100 || qerr.Used != 150 {
+		t.Errorf("bad quota exceeded error: %v\n", qerr)
+	}
+	quota, used, err := GetRepoQuota(uuid)
+	if err != nil {
+		t.Fatalf("unable to get repo quota: %v\n", err)
+	}
+	if quota != 100 || used != 150 {
+		t.Errorf("expected quota 100 and 150 bytes used, got %d and %d\n", quota, used)
+	}
+	if err := SetRepoQuota(uuid, 0); err != nil {
+		t.Fatalf("unable to remove repo quota: %v\n", err)
+	}
+	if err := CheckRepoQuota(uuid); err != nil {
+		t.Errorf("unexpected error after removing quota: %v\n", err)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	if err := SetNamespaces(map[string]NamespaceConfig{"a": {IDPrefix: 2}, "b": {IDPrefix: 2}}); err == nil {
+		t.Errorf("expected error for namespaces with the same instance id prefix\n")
+	}
+	if err := SetNamespaces(map[string]NamespaceConfig{"bad name": {}}); err == nil {
+		t.Errorf("expected error for bad namespace name\n")
+	}
+	if err := SetNamespaces(map[string]NamespaceConfig{"lab1": {Quota: 100, IDPrefix: 2}}); err != nil {
+		t.Fatalf("unable to set namespaces: %v\n", err)
+	}
+	defer SetNamespaces(nil)
+
+	uuid, err := NewNamespaceRepo("lab1", "lab repo", "", nil, "")
+	if err != nil {
+		t.Fatalf("unable to create namespace repo: %v\n", err)
+	}
+	other, _ := NewTestRepo()
+	if namespace, err := RepoNamespace(uuid); err != nil || namespace != "lab1" {
+		t.Errorf("expected repo in namespace lab1, got %q: %v\n", namespace, err)
+	}
+	if uuids, err := NamespaceRepos("lab1"); err != nil || len(uuids) != 1 || uuids[0] != uuid {
+		t.Errorf("expected only repo %s in namespace lab1, got %v: %v\n", uuid, uuids, err)
+	}
+	if err := SetRepoProperties(uuid, map[string]interface{}{namespaceProperty: "lab2"}); err == nil {
+		t.Errorf("expected error changing the namespace of a repo\n")
+	}
+
+	// Instance ids of the namespace's repos use its prefix.
+	manager.idMutex.Lock()
+	id, err := manager.allocInstanceID("lab1")
+	manager.idMutex.Unlock()
+	if err != nil {
+		t.Fatalf("unable to allocate namespace instance id: %v\n", err)
+	}
+	if id>>instanceSuffixBits != 2 {
+		t.Errorf("expected instance id with prefix 2 for namespace, got %d\n", id)
+	}
+
+	if err := CheckRepoQuota(uuid); err != nil {
+		t.Fatalf("unexpected error for empty namespace repo: %v\n", err)
+	}
+	AddRepoBytes(uuid, 150)
+	qerr, ok := CheckRepoQuota(uuid).(QuotaExceededError)
+	if !ok || qerr.Namespace != "lab1" || qerr.Quota != 100 || qerr.Used != 150 {
+		t.Errorf("expected namespace quota exceeded error, got %v\n", qerr)
+	}
+	if err := CheckRepoQuota(other); err != nil {
+		t.Errorf("unexpected error for repo outside namespace: %v\n", err)
+	}
+	if _, err := NewNamespaceRepo("lab1", "another", "", nil, ""); err == nil {
+		t.Errorf("expected error creating repo in namespace over its quota\n")
+	}
+	if _, err := NewNamespaceRepo("lab2", "undefined", "", nil, ""); err == nil {
+		t.Errorf("expected error creating repo in undefined namespace\n")
+	}
+}
+
+func TestUploads(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	const body = "0123456789abcdefghij"
+	s, err := CreateUpload("/api/node/abc/grayscale/raw/0_1_2/4_5_1/0_0_0", int64(len(body)), "", "")
+	if err != nil {
+		t.Fatalf("unable to create upload: %v\n", err)
+	}
+
+	// Send ranges out of order, with overlaps and a resent range of different length.
+	for _, r := range []ByteRange{{12, 20}, {0, 5}, {3, 8}, {0, 3}} {
+		if s, err = PutUploadRange(s.ID, r.Start, []byte(body[r.Start:r.End])); err != nil {
+			t.Fatalf("unable to put upload range %v: %v\n", r, err)
+		}
+	}
+	if !reflect.DeepEqual(s.Received, []ByteRange{{0, 8}, {12, 20}}) || s.Complete() {
+		t.Fatalf("bad received ranges: %v\n", s.Received)
+	}
+	if _, err := UploadReader(s.ID); err == nil {
+		t.Fatalf("expected error reading incomplete upload\n")
+	}
+	if _, err := PutUploadRange(s.ID, 18, []byte("xyz")); err == nil {
+		t.Fatalf("expected error putting range past end of upload\n")
+	}
+	if _, err := PutUploadRange(s.ID, 8, []byte(body[8:12])); err != nil {
+		t.Fatalf("unable to put upload range: %v\n", err)
+	}
+
+	s, err = GetUpload(s.ID)
+	if err != nil {
+		t.Fatalf("unable to get upload: %v\n", err)
+	}
+	if !s.Complete() || s.ReceivedBytes() != int64(len(body)) {
+		t.Fatalf("expected complete upload, got %v\n", s.Received)
+	}
+	r, err := UploadReader(s.ID)
+	if err != nil {
+		t.Fatalf("unable to read upload: %v\n", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unable to read upload: %v\n", err)
+	}
+	if string(data) != body {
+		t.Errorf("expected upload body %q, got %q\n", body, string(data))
+	}
+
+	if err := DeleteUpload(s.ID); err != nil {
+		t.Fatalf("unable to delete upload: %v\n", err)
+	}
+	if _, err := GetUpload(s.ID); err != ErrUploadNotFound {
+		t.Errorf("expected deleted upload to be gone, got %v\n", err)
+	}
+	var ctx storage.MetadataContext
+	keys, err := manager.store.KeysInRange(ctx, storage.MinTKey(uploadBytesKey), storage.MaxTKey(uploadBytesKey))
+	if err != nil || len(keys) != 0 {
+		t.Errorf("expected bytes of deleted upload to be removed, got %d keys, err %v\n", len(keys), err)
+	}
+}
+
+func TestDeleteRepoToken(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	root, err := NewRepo("test repo", "test repo description", nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RepoDeleteToken(root, "wrong"); err == nil {
+		t.Errorf("expected error getting deletion token with bad passcode\n")
+	}
+	if err := DeleteRepo(root, ""); err == nil {
+		t.Errorf("expected error deleting repo without token\n")
+	}
+	token, err := RepoDeleteToken(root, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteRepo(root, "bad"+token); err == nil {
+		t.Errorf("expected error deleting repo with bad token\n")
+	}
+	if err := DeleteRepo(root, token); err == nil {
+		t.Errorf("expected error reusing a deletion token after a failed attempt\n")
+	}
+
+	// Expired tokens can't be used.
+	token, err = RepoDeleteToken(root, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Lock()
+	manager.deleteTokens[root] = deleteToken{token, time.Now().Add(-time.Second)}
+	manager.Unlock()
+	if err := DeleteRepo(root, token); err == nil {
+		t.Errorf("expected error deleting repo with expired token\n")
+	}
+
+	token, err = RepoDeleteToken(root, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteRepo(root, token); err != nil {
+		t.Fatalf("unable to delete repo with token: %v\n", err)
+	}
+	if _, err := VersionFromUUID(root); err == nil {
+		t.Errorf("expected root %s of deleted repo to be gone\n", root)
+	}
+}
+
+func TestGetDAG(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	root, err := NewRepo("dag repo", "repo for DAG graph", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit(root, "root", nil); err != nil {
+		t.Fatal(err)
+	}
+	child1, err := NewVersion(root, "first child", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child2, err := NewVersion(root, "second child", "experiment", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit(child1, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit(child2, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := Merge([]dvid.UUID{child1, child2}, "merged", MergeConflictFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTag(root, "release-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	dag, err := GetDAG(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dag.Root != root || dag.Alias != "dag repo" {
+		t.Errorf("bad DAG root %s and alias %q\n", dag.Root, dag.Alias)
+	}
+	if len(dag.Nodes) != 4 {
+		t.Fatalf("expected 4 nodes, got %v\n", dag.Nodes)
+	}
+	if dag.Nodes[0].UUID != root || !dag.Nodes[0].Locked || len(dag.Nodes[0].Tags) != 1 || dag.Nodes[0].Tags[0] != "release-1" {
+		t.Errorf("bad root node: %v\n", dag.Nodes[0])
+	}
+	for _, node := range dag.Nodes {
+		if node.UUID == child2 && node.Branch != "experiment" {
+			t.Errorf("expected branch of node %s to be \"experiment\", got %q\n", child2, node.Branch)
+		}
+		if node.UUID == merged && (node.Locked || node.Note != "merged") {
+			t.Errorf("bad merged node: %v\n", node)
+		}
+	}
+	var merges int
+	for _, edge := range dag.Edges {
+		if edge.Merge {
+			merges++
+			if edge.Parent != child2 || edge.Child != merged {
+				t.Errorf("bad merge edge: %v\n", edge)
+			}
+		}
+	}
+	if len(dag.Edges) != 4 || merges != 1 {
+		t.Errorf("expected 4 edges with 1 from a merge, got %v\n", dag.Edges)
+	}
+}
+
+func TestMergeParents(t *testing.T) {
+	OpenTest()
+
+	root, err := NewRepo("merge repo", "repo for merge nodes", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit(root, "root", nil); err != nil {
+		t.Fatal(err)
+	}
+	child1, err := NewVersion(root, "first child", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child2, err := NewVersion(root, "second child", "experiment", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit(child1, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failed merges shouldn't leave a child on any parent.
+	if _, err := Merge([]dvid.UUID{child1, child2}, "unlocked", MergeConflictFree); err != ErrBranchUnlockedNode {
+		t.Fatalf("expected unlocked parent error, got %v\n", err)
+	}
+	if _, err := Merge([]dvid.UUID{child1, child1}, "duplicate", MergeConflictFree); err == nil {
+		t.Fatalf("expected error merging duplicate parents\n")
+	}
+	v1, _ := VersionFromUUID(child1)
+	if children, err := GetChildrenByVersion(v1); err != nil || len(children) != 0 {
+		t.Fatalf("expected no children of %s after failed merges, got %v: %v\n", child1, children, err)
+	}
+
+	if err := Commit(child2, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := Merge([]dvid.UUID{child2, child1}, "merged", MergeConflictFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, _ := VersionFromUUID(child2)
+
+	// Parents should keep their priority order after a restart.
+	CloseReopenTest()
+	defer CloseTest()
+
+	mergedV, err := VersionFromUUID(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents, err := GetParentsByVersion(mergedV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parents) != 2 || parents[0] != v2 || parents[1] != v1 {
+		t.Errorf("expected merged node parents [%d %d], got %v\n", v2, v1, parents)
+	}
+	for _, v := range []dvid.VersionID{v1, v2} {
+		children, err := GetChildrenByVersion(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(children) != 1 || children[0] != mergedV {
+			t.Errorf("expected merged node %d as only child of version %d, got %v\n", mergedV, v, children)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	events := make(chan Event, 10)
+	id := Subscribe("test", func(e Event) { events <- e }, EventNewRepo, EventLock, EventNewVersion)
+	defer Unsubscribe(id)
+
+	root, err := NewRepo("event repo", "repo for events", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit(root, "root commit", nil); err != nil {
+		t.Fatal(err)
+	}
+	child, err := NewVersion(root, "child", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewVersion(child, "unlocked parent", "", nil); err == nil {
+		t.Fatalf("expected error branching unlocked node %s\n", child)
+	}
+
+	expected := []Event{
+		{Type: EventNewRepo, Repo: root, UUID: root},
+		{Type: EventLock, Repo: root, UUID: root, Note: "root commit"},
+		{Type: EventNewVersion, Repo: root, UUID: child, Parents: []dvid.UUID{root}, Note: "child"},
+	}
+	for _, want := range expected {
+		select {
+		case e := <-events:
+			if e.Type != want.Type || e.Repo != want.Repo || e.UUID != want.UUID || e.Time.IsZero() {
+				t.Fatalf("expected %s event for %s, got %+v\n", want.Type, want.UUID, e)
+			}
+			if want.Note != "" && e.Note != want.Note {
+				t.Errorf("expected note %q in %s event, got %q\n", want.Note, e.Type, e.Note)
+			}
+			if !reflect.DeepEqual(e.Parents, want.Parents) {
+				t.Errorf("expected parents %v in %s event, got %v\n", want.Parents, e.Type, e.Parents)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s event\n", want.Type)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event: %+v\n", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRepoRevisionConflict(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	uuid, err := NewRepo("revised repo", "repo for revision conflicts", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRepoAlias(uuid, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a save of the repo by another server sharing the metadata store.
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ctx storage.MetadataContext
+	tk := storage.NewTKey(repoRevisionKey, r.id.Bytes())
+	if err := manager.store.Put(ctx, tk, encodeRepoRevision(r.revision+1)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = SetRepoAlias(uuid, "second")
+	if _, ok := err.(*RepoConflictError); !ok {
+		t.Fatalf("expected repo conflict error after concurrent save, got %v\n", err)
+	}
+
+	if err := ReloadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRepoAlias(uuid, "third"); err != nil {
+		t.Fatalf("couldn't modify repo after reload: %v\n", err)
+	}
+	alias, err := GetRepoAlias(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias != "third" {
+		t.Errorf("expected alias %q after reload, got %q\n", "third", alias)
+	}
+
+	// Revisions are loaded on restart.
+	CloseReopenTest()
+	if err := SetRepoAlias(uuid, "fourth"); err != nil {
+		t.Fatalf("couldn't modify repo after restart: %v\n", err)
+	}
+}
+
+func TestRepoConcurrentSave(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	uuid, err := NewRepo("raced repo", "repo for concurrent saves", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each goroutine saves its own copy of the repo, as if loaded by separate servers.
+	const savers = 8
+	copies := make([]*repoT, savers)
+	for i := range copies {
+		copies[i] = new(repoT)
+		if err := dvid.Deserialize(r.saved, copies[i]); err != nil {
+			t.Fatal(err)
+		}
+		copies[i].revision = r.revision
+		copies[i].formatVersion = r.formatVersion
+		copies[i].markSaved(r.saved)
+	}
+	errs := make([]error, savers)
+	var wg sync.WaitGroup
+	for i := range copies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			copies[i].alias = fmt.Sprintf("saver %d", i)
+			errs[i] = copies[i].save()
+		}(i)
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		if err == nil {
+			if winner >= 0 {
+				t.Fatalf("savers %d and %d both saved the repo\n", winner, i)
+			}
+			winner = i
+			continue
+		}
+		if _, ok := err.(*RepoConflictError); !ok {
+			t.Fatalf("expected repo conflict error for saver %d, got %v\n", i, err)
+		}
+		if copies[i].alias != "raced repo" {
+			t.Errorf("expected unsaved alias of saver %d to be discarded, got %q\n", i, copies[i].alias)
+		}
+	}
+	if winner < 0 {
+		t.Fatalf("expected one saver to save the repo\n")
+	}
+
+	var ctx storage.MetadataContext
+	value, err := manager.store.Get(ctx, storage.NewTKey(repoRevisionKey, r.id.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision, err := decodeRepoRevision(value); err != nil || revision != r.revision+1 {
+		t.Errorf("expected revision %d after one save, got %d: %v\n", r.revision+1, revision, err)
+	}
+	value, err = manager.store.Get(ctx, storage.NewTKey(repoKey, r.id.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := new(repoT)
+	if err := dvid.Deserialize(value, stored); err != nil {
+		t.Fatal(err)
+	}
+	if expected := fmt.Sprintf("saver %d", winner); stored.alias != expected {
+		t.Errorf("expected stored alias %q, got %q\n", expected, stored.alias)
+	}
+
+	// The manager's copy is now stale, and its change is discarded on conflict.
+	if err := SetRepoAlias(uuid, "stale"); err == nil {
+		t.Fatalf("expected conflict saving stale repo\n")
+	}
+	if alias, err := GetRepoAlias(uuid); err != nil || alias != "raced repo" {
+		t.Errorf("expected stale repo to be restored, got alias %q: %v\n", alias, err)
+	}
+}
+
+// failInitType creates data instances whose handlers can't be initialized.
+type failInitType struct {
+	TestType
+}
+
+func (t *failInitType) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.InstanceName, c dvid.Config) (DataService, error) {
+	basedata, err := NewDataService(t, uuid, id, name, c)
+	if err != nil {
+		return nil, err
+	}
+	return &failInitData{TestData{basedata}}, nil
+}
+
+type failInitData struct {
+	TestData
+}
+
+func (d *failInitData) InitDataHandlers() error {
+	return fmt.Errorf("handlers of %q can't be initialized", d.DataName())
+}
+
+func TestNewDataRollback(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	uuid, _ := NewTestRepo()
+	checkRolledBack := func(name dvid.InstanceName, nextID dvid.InstanceID, numIDs, logLen int) {
+		if _, err := GetDataByUUIDName(uuid, name); err == nil {
+			t.Errorf("expected no instance %q after failed creation\n", name)
+		}
+		if manager.instanceID != nextID {
+			t.Errorf("expected next instance id %d after failed creation of %q, got %d\n", nextID, name, manager.instanceID)
+		}
+		if len(manager.iids) != numIDs {
+			t.Errorf("expected %d instance ids after failed creation of %q, got %d\n", numIDs, name, len(manager.iids))
+		}
+		if log, err := GetRepoLog(uuid); err != nil || len(log) != logLen {
+			t.Errorf("expected %d log entries after failed creation of %q, got %d: %v\n", logLen, name, len(log), err)
+		}
+	}
+	log, err := GetRepoLog(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextID, numIDs := manager.instanceID, len(manager.iids)
+
+	if _, err := NewData(uuid, &failInitType{}, "uninitialized", dvid.NewConfig()); err == nil {
+		t.Fatalf("expected error creating instance that can't be initialized\n")
+	}
+	checkRolledBack("uninitialized", nextID, numIDs, len(log))
+
+	// Simulate a save of the repo by another server so saving the new instance fails.
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ctx storage.MetadataContext
+	tk := storage.NewTKey(repoRevisionKey, r.id.Bytes())
+	if err := manager.store.Put(ctx, tk, encodeRepoRevision(r.revision+1)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewData(uuid, &TestType{}, "unsaved", dvid.NewConfig())
+	if _, ok := err.(*RepoConflictError); !ok {
+		t.Fatalf("expected repo conflict error creating instance, got %v\n", err)
+	}
+	checkRolledBack("unsaved", nextID, numIDs, len(log))
+
+	// Once the other save is undone, the released id is allocated to the next instance.
+	if err := manager.store.Put(ctx, tk, encodeRepoRevision(r.revision)); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewData(uuid, &TestType{}, "saved", dvid.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.InstanceID() != nextID {
+		t.Errorf("expected released instance id %d to be reused, got %d\n", nextID, d.InstanceID())
+	}
+}
+
+func TestMetadataMigration(t *testing.T) {
+	if len(metadataMigrations) != RepoFormatVersion {
+		t.Fatalf("expected %d metadata migrations, got %d\n", RepoFormatVersion, len(metadataMigrations))
+	}
+
+	OpenTest()
+	defer CloseTest()
+
+	uuid, err := NewRepo("old repo", "repo saved in an old format", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.formatVersion != RepoFormatVersion {
+		t.Fatalf("expected new repo at format version %d, got %d\n", RepoFormatVersion, r.formatVersion)
+	}
+
+	// Simulate metadata saved before repos had their own format version.
+	var ctx storage.MetadataContext
+	formatTKey := storage.NewTKey(repoFormatKey, r.id.Bytes())
+	if err := manager.store.Delete(ctx, formatTKey); err != nil {
+		t.Fatal(err)
+	}
+	oldVersion := uint64(1)
+	if err := manager.putData(formatKey, &oldVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	CloseReopenTest()
+	if manager.formatVersion != RepoFormatVersion {
+		t.Errorf("expected metadata migrated to format version %d, got %d\n", RepoFormatVersion, manager.formatVersion)
+	}
+	var stored uint64
+	if found, err := manager.loadData(formatKey, &stored); err != nil || !found || stored != RepoFormatVersion {
+		t.Errorf("expected stored format version %d, got %d (found %t): %v\n", RepoFormatVersion, stored, found, err)
+	}
+	value, err := manager.store.Get(ctx, formatTKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repoVersion, err := decodeRepoRevision(value); err != nil || repoVersion != RepoFormatVersion {
+		t.Errorf("expected repo migrated to format version %d, got %d: %v\n", RepoFormatVersion, repoVersion, err)
+	}
+	if alias, err := GetRepoAlias(uuid); err != nil || alias != "old repo" {
+		t.Errorf("bad alias %q after migration: %v\n", alias, err)
+	}
+
+	// Metadata from newer servers is refused.
+	manager.formatVersion = RepoFormatVersion + 1
+	if err := manager.migrateMetadata(); err == nil {
+		t.Errorf("expected error migrating metadata with newer format version\n")
+	}
+	manager.formatVersion = RepoFormatVersion
+}
+
+func TestMetadataBatch(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	var ctx storage.MetadataContext
+	tk1 := storage.NewTKey(zstdDictKey, []byte("batch1"))
+	tk2 := storage.NewTKey(zstdDictKey, []byte("batch2"))
+	batch := &orderedBatch{store: manager.store}
+	batch.Put(tk1, []byte("first"))
+	batch.Put(tk2, []byte("second"))
+	batch.Delete(tk1)
+	if value, err := manager.store.Get(ctx, tk2); err != nil || value != nil {
+		t.Fatalf("expected no write before commit, got %q: %v\n", value, err)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := manager.store.Get(ctx, tk1); err != nil || value != nil {
+		t.Errorf("expected deleted key after commit, got %q: %v\n", value, err)
+	}
+	if value, err := manager.store.Get(ctx, tk2); err != nil || string(value) != "second" {
+		t.Errorf("expected put key after commit, got %q: %v\n", value, err)
+	}
+	if err := manager.store.Delete(ctx, tk2); err != nil {
+		t.Fatal(err)
+	}
+
+	// A repo whose save was interrupted after the caches were saved is dropped on load.
+	uuid, err := NewRepo("interrupted", "repo with lost save", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.delete(); err != nil {
+		t.Fatal(err)
+	}
+	CloseReopenTest()
+	if _, err := GetRepoRoot(uuid); err == nil {
+		t.Errorf("expected repo %s without saved metadata to be dropped on load\n", uuid)
+	}
+	if _, err := NewRepo("next", "repo after dropped repo", nil, ""); err != nil {
+		t.Errorf("unable to create repo after dropped repo: %v\n", err)
+	}
+}
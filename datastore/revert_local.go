@@ -0,0 +1,79 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports reverting the changes to a data instance in an uncommitted node.
+*/
+
+package datastore
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// RevertNode starts a job that deletes all key-value pairs of the named data instance
+// written in the uncommitted node with the given UUID, returning the job ID.  Since
+// tombstones written in the node are also deleted, the data in the node is afterwards
+// the same as in its parents.  Writes to the data in the node while the job runs may or
+// may not be reverted, and the node shouldn't be committed until the job is done.
+func RevertNode(uuid dvid.UUID, name dvid.InstanceName) (jobID uint64, err error) {
+	if manager == nil {
+		return 0, ErrManagerNotInitialized
+	}
+	return manager.revertNode(uuid, name)
+}
+
+func (m *repoManager) revertNode(uuid dvid.UUID, name dvid.InstanceName) (uint64, error) {
+	v, err := m.versionFromUUID(uuid)
+	if err != nil {
+		return 0, err
+	}
+	m.RLock()
+	r, found := m.repos[uuid]
+	m.RUnlock()
+	if !found {
+		return 0, ErrInvalidUUID
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	node, found := r.dag.nodes[v]
+	if !found {
+		return 0, ErrInvalidVersion
+	}
+	data, found := r.data[name]
+	if !found {
+		return 0, ErrInvalidDataName
+	}
+	if !data.Versioned() {
+		return 0, fmt.Errorf("data %q is unversioned, so its changes in node %s can't be reverted", name, uuid)
+	}
+
+	node.Lock()
+	if node.locked {
+		node.Unlock()
+		return 0, fmt.Errorf("node %s is committed, so its changes can't be reverted", uuid)
+	}
+	node.addToLog([]string{fmt.Sprintf("Reverted changes to data %q", name)})
+	node.Unlock()
+	r.updated = node.updated
+	if err := r.save(); err != nil {
+		return 0, err
+	}
+
+	job := newJob("revert", fmt.Sprintf("data %q in node %s", name, uuid))
+	go func() {
+		setJobStep(job, "deleting key-value pairs written in node")
+		err := deleteVersionsData(data, []dvid.VersionID{v})
+		finishJob(job, err)
+		if err != nil {
+			dvid.Errorf("Unable to revert changes to data %q in node %s: %v\n", name, uuid, err)
+			return
+		}
+		RecordMutation(data.DataUUID())
+		dvid.Infof("Reverted changes to data %q in node %s\n", name, uuid)
+		publishDataEvent(EventDataModified, uuid, data, "revert")
+	}()
+	return job, nil
+}
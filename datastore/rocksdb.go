@@ -0,0 +1,6 @@
+// +build rocksdb
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/rocksdb"
+import _ "github.com/janelia-flyem/dvid/storage/filelog"
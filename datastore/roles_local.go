@@ -0,0 +1,232 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports role-based authorization per repo.  Users, e.g., from JWTs, and API
+	tokens can be assigned read, write, or admin roles in a repo.  Repos without role
+	assignments aren't restricted, while access to a restricted repo requires a role.
+*/
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Role is the access granted to a user in a repo.  Each role includes the access of the
+// roles before it.
+type Role uint8
+
+const (
+	RoleNone  Role = iota // no access to a restricted repo
+	RoleRead              // read data and metadata
+	RoleWrite             // also modify data and create versions
+	RoleAdmin             // also change repo settings and role assignments
+)
+
+// AnyUser is the principal whose role applies to all users, including unauthenticated ones.
+const AnyUser = "*"
+
+// APITokenPrincipal returns the principal of the API token with the given ID.
+func APITokenPrincipal(id string) string {
+	return "token:" + id
+}
+
+func (r Role) String() string {
+	switch r {
+	case RoleNone:
+		return "none"
+	case RoleRead:
+		return "read"
+	case RoleWrite:
+		return "write"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return fmt.Sprintf("role %d", r)
+	}
+}
+
+// ParseRole returns the role with the given name, e.g., "write".
+func ParseRole(s string) (Role, error) {
+	for r := RoleNone; r <= RoleAdmin; r++ {
+		if r.String() == s {
+			return r, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role %q, must be one of none, read, write, or admin", s)
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	var err error
+	*r, err = ParseRole(string(text))
+	return err
+}
+
+// RepoAccessError is returned when a user doesn't have the role needed in a restricted repo.
+type RepoAccessError struct {
+	UUID      dvid.UUID // root of the repo
+	Principal string
+	Needed    Role
+}
+
+func (e *RepoAccessError) Error() string {
+	if e.Principal == "" {
+		return fmt.Sprintf("repo %s requires authentication for %s access", e.UUID, e.Needed)
+	}
+	return fmt.Sprintf("%q doesn't have %s access to repo %s", e.Principal, e.Needed, e.UUID)
+}
+
+// SetRepoRole assigns a role to a principal, e.g., a user, an API token principal, or
+// AnyUser, in the repo with the given UUID.  The RoleNone role removes the assignment,
+// and a repo is no longer restricted when all its assignments are removed.
+func SetRepoRole(uuid dvid.UUID, principal string, role Role) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	return manager.setRepoRole(uuid, principal, role)
+}
+
+// GetRepoRoles returns the role assignments of the repo with the given UUID, which are
+// empty if the repo isn't restricted.
+func GetRepoRoles(uuid dvid.UUID) (map[string]Role, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	return manager.getRepoRoles(uuid)
+}
+
+// CheckRepoRole returns a *RepoAccessError if the repo with the given UUID is restricted
+// and none of the principals, or AnyUser, has the needed role.
+func CheckRepoRole(uuid dvid.UUID, needed Role, principals ...string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	r, err := manager.repoFromUUIDLocked(uuid)
+	if err != nil {
+		return err
+	}
+	manager.roleMutex.RLock()
+	defer manager.roleMutex.RUnlock()
+	roles := manager.roles[r.id]
+	if len(roles) == 0 || roles[AnyUser] >= needed {
+		return nil
+	}
+	for _, p := range principals {
+		if p != "" && roles[p] >= needed {
+			return nil
+		}
+	}
+	var principal string
+	if len(principals) != 0 {
+		principal = principals[0]
+	}
+	return &RepoAccessError{UUID: r.uuid, Principal: principal, Needed: needed}
+}
+
+// repoFromUUIDLocked returns the repo with the given UUID under the manager's read lock.
+func (m *repoManager) repoFromUUIDLocked(uuid dvid.UUID) (*repoT, error) {
+	m.RLock()
+	r, found := m.repos[uuid]
+	m.RUnlock()
+	if !found {
+		return nil, ErrInvalidUUID
+	}
+	return r, nil
+}
+
+func (m *repoManager) setRepoRole(uuid dvid.UUID, principal string, role Role) error {
+	if principal == "" {
+		return fmt.Errorf("role must be assigned to a user, token, or %q", AnyUser)
+	}
+	if role > RoleAdmin {
+		return fmt.Errorf("bad role %d", role)
+	}
+	r, err := m.repoFromUUIDLocked(uuid)
+	if err != nil {
+		return err
+	}
+
+	m.roleMutex.Lock()
+	defer m.roleMutex.Unlock()
+	roles := make(map[string]Role, len(m.roles[r.id])+1)
+	for p, role := range m.roles[r.id] {
+		roles[p] = role
+	}
+	if role == RoleNone {
+		delete(roles, principal)
+	} else {
+		roles[principal] = role
+	}
+
+	var ctx storage.MetadataContext
+	tk := storage.NewTKey(repoRolesKey, r.id.Bytes())
+	if len(roles) == 0 {
+		if err := m.store.Delete(ctx, tk); err != nil {
+			return err
+		}
+	} else {
+		value, err := json.Marshal(roles)
+		if err != nil {
+			return err
+		}
+		if err := m.store.Put(ctx, tk, value); err != nil {
+			return err
+		}
+	}
+	if m.roles == nil {
+		m.roles = make(map[dvid.RepoID]map[string]Role)
+	}
+	if len(roles) == 0 {
+		delete(m.roles, r.id)
+	} else {
+		m.roles[r.id] = roles
+	}
+	return nil
+}
+
+func (m *repoManager) getRepoRoles(uuid dvid.UUID) (map[string]Role, error) {
+	r, err := m.repoFromUUIDLocked(uuid)
+	if err != nil {
+		return nil, err
+	}
+	m.roleMutex.RLock()
+	defer m.roleMutex.RUnlock()
+	roles := make(map[string]Role, len(m.roles[r.id]))
+	for p, role := range m.roles[r.id] {
+		roles[p] = role
+	}
+	return roles, nil
+}
+
+// loadRepoRoles loads the role assignments of all repos.
+func (m *repoManager) loadRepoRoles() error {
+	var ctx storage.MetadataContext
+	kvs, err := m.store.GetRange(ctx, storage.MinTKey(repoRolesKey), storage.MaxTKey(repoRolesKey))
+	if err != nil {
+		return err
+	}
+	allRoles := make(map[dvid.RepoID]map[string]Role, len(kvs))
+	for _, kv := range kvs {
+		ibytes, err := kv.K.ClassBytes(repoRolesKey)
+		if err != nil {
+			return err
+		}
+		var roles map[string]Role
+		if err := json.Unmarshal(kv.V, &roles); err != nil {
+			return fmt.Errorf("bad role assignments in metadata: %v", err)
+		}
+		allRoles[dvid.RepoIDFromBytes(ibytes)] = roles
+	}
+	m.roleMutex.Lock()
+	m.roles = allRoles
+	m.roleMutex.Unlock()
+	return nil
+}
@@ -0,0 +1,5 @@
+// +build s3
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/s3"
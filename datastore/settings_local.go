@@ -0,0 +1,185 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports repo settings, which are repo properties persisted with the repo
+	and shown in its JSON.  Known settings, e.g., auto-branching and the storage quota,
+	have their values checked and converted when set, while other properties can hold any
+	string, number, or boolean.  The "namespace" property is only set on creation.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+const (
+	// compressionProperty is the repo property giving the default compression of new data
+	// instances in the repo, overriding the server default.
+	compressionProperty = "compression"
+
+	// readOnlyProperty is the repo property refusing writes to all data in the repo.
+	readOnlyProperty = "read-only"
+)
+
+// repoSettings converts values of known repo properties, e.g., decoded from JSON, to the
+// types used by the datastore.
+var repoSettings = map[string]func(interface{}) (interface{}, error){
+	autoBranchProperty:  boolSetting,
+	quotaProperty:       uint64Setting,
+	compressionProperty: compressionSetting,
+	readOnlyProperty:    boolSetting,
+}
+
+// ReadOnlyRepoError is returned when data is written in a repo with the read-only setting.
+type ReadOnlyRepoError struct {
+	UUID dvid.UUID // root of the repo
+	Data dvid.InstanceName
+}
+
+func (e *ReadOnlyRepoError) Error() string {
+	return fmt.Sprintf("cannot write data %q in read-only repo %s", e.Data, e.UUID)
+}
+
+// SetRepoProperty sets a property of the repo with the given UUID, or removes it if the
+// value is nil.  Values of known settings are checked and converted, e.g., "read-only"
+// must be a boolean and "storage-quota" a non-negative integer, and other properties must
+// be strings, numbers, or booleans.
+func SetRepoProperty(uuid dvid.UUID, name string, value interface{}) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	value, err := checkRepoProperty(name, value)
+	if err != nil {
+		return err
+	}
+	return manager.setRepoProperty(uuid, name, value)
+}
+
+// SetRepoProperties sets or removes several properties like SetRepoProperty, saving the
+// repo once.  No properties are set if any value is bad.
+func SetRepoProperties(uuid dvid.UUID, props map[string]interface{}) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	checked := make(map[string]interface{}, len(props))
+	for name, value := range props {
+		var err error
+		if checked[name], err = checkRepoProperty(name, value); err != nil {
+			return err
+		}
+	}
+	return manager.setRepoProperties(uuid, checked)
+}
+
+// GetRepoProperty returns a property of the repo with the given UUID or nil if it isn't set.
+func GetRepoProperty(uuid dvid.UUID, name string) (interface{}, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	return manager.getRepoProperty(uuid, name)
+}
+
+// GetRepoProperties returns a copy of all properties of the repo with the given UUID.
+func GetRepoProperties(uuid dvid.UUID) (map[string]interface{}, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	return manager.getRepoProperties(uuid)
+}
+
+// repoReadOnly returns the root of the repo with the given version and whether the repo
+// has the read-only setting.
+func (m *repoManager) repoReadOnly(v dvid.VersionID) (dvid.UUID, bool, error) {
+	r, err := m.repoFromVersion(v)
+	if err != nil {
+		return dvid.NilUUID, false, err
+	}
+	r.RLock()
+	defer r.RUnlock()
+	readOnly, _ := r.properties[readOnlyProperty].(bool)
+	return r.uuid, readOnly, nil
+}
+
+// setDefaultCompression sets the compression in the config of a new data instance to
+// the repo's default compression if the config doesn't give one.  The caller must hold
+// the repo lock.
+func (r *repoT) setDefaultCompression(c *dvid.Config) {
+	compression, found := r.properties[compressionProperty].(string)
+	if !found {
+		return
+	}
+	if _, given := c.Get("Compression"); !given {
+		c.Set("Compression", compression)
+	}
+}
+
+// checkRepoProperty returns the value to store for a repo property.
+func checkRepoProperty(name string, value interface{}) (interface{}, error) {
+	if name == "" {
+		return nil, fmt.Errorf("repo property must have a name")
+	}
+	if name == namespaceProperty {
+		return nil, fmt.Errorf("a repo's namespace is set when the repo is created and can't be changed")
+	}
+	if value == nil {
+		return nil, nil
+	}
+	var err error
+	if convert, known := repoSettings[name]; known {
+		value, err = convert(value)
+	} else {
+		value, err = basicSetting(value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad value for repo property %q: %v", name, err)
+	}
+	return value, nil
+}
+
+func boolSetting(value interface{}) (interface{}, error) {
+	on, ok := value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a boolean", value)
+	}
+	return on, nil
+}
+
+func uint64Setting(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case uint64:
+		return v, nil
+	case int:
+		if v >= 0 {
+			return uint64(v), nil
+		}
+	case float64: // numbers decoded from JSON
+		if v >= 0 && v < math.MaxUint64 && v == math.Trunc(v) {
+			return uint64(v), nil
+		}
+	}
+	return nil, fmt.Errorf("%v is not a non-negative integer", value)
+}
+
+func compressionSetting(value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a string", value)
+	}
+	if _, err := dvid.ParseCompression(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// basicSetting allows values of unknown properties that can be persisted with the repo.
+func basicSetting(value interface{}) (interface{}, error) {
+	switch value.(type) {
+	case string, bool, float64, int, int64, uint64:
+		return value, nil
+	}
+	return nil, fmt.Errorf("%v is not a string, number, or boolean", value)
+}
@@ -0,0 +1,6 @@
+// +build sqlite
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/sqlite"
+import _ "github.com/janelia-flyem/dvid/storage/filelog"
@@ -0,0 +1,6 @@
+// +build tikv
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/tikv"
+import _ "github.com/janelia-flyem/dvid/storage/filelog"
@@ -0,0 +1,349 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports resumable uploads, which let clients send large POST bodies as
+	byte ranges that can be retried or sent in parallel.  Session state and the received
+	ranges are kept in the metadata store until the upload is finalized or removed.
+*/
+
+package datastore
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// UploadExpiration is how long an upload session can go without receiving data before
+// it is removed.
+var UploadExpiration = 7 * 24 * time.Hour
+
+// ErrUploadNotFound is returned for unknown, expired, or removed upload sessions.
+var ErrUploadNotFound = errors.New("upload session not found")
+
+// ByteRange is a range of bytes from Start up to but not including End.
+type ByteRange struct {
+	Start, End int64
+}
+
+// UploadSession describes a resumable upload of a request body.
+type UploadSession struct {
+	ID          string
+	Path        string // endpoint that receives the assembled body as a POST
+	Size        int64  // total bytes of the body
+	ContentType string `json:",omitempty"`
+	User        string `json:",omitempty"` // user that started the upload, if authenticated
+	Received    []ByteRange
+	Created     time.Time
+	Updated     time.Time
+}
+
+// ReceivedBytes returns the number of distinct bytes received.
+func (s UploadSession) ReceivedBytes() int64 {
+	var n int64
+	for _, r := range s.Received {
+		n += r.End - r.Start
+	}
+	return n
+}
+
+// Complete returns true if all bytes of the upload have been received.
+func (s UploadSession) Complete() bool {
+	return s.Size == 0 || (len(s.Received) == 1 && s.Received[0].Start == 0 && s.Received[0].End == s.Size)
+}
+
+// addRange merges a range into sorted, non-overlapping ranges.
+func addRange(ranges []ByteRange, r ByteRange) []ByteRange {
+	merged := make([]ByteRange, 0, len(ranges)+1)
+	for _, cur := range ranges {
+		switch {
+		case cur.End < r.Start:
+			merged = append(merged, cur)
+		case r.End < cur.Start:
+			merged = append(merged, r)
+			r = cur
+		default:
+			if cur.Start < r.Start {
+				r.Start = cur.Start
+			}
+			if cur.End > r.End {
+				r.End = cur.End
+			}
+		}
+	}
+	return append(merged, r)
+}
+
+const uploadIDBytes = 8
+
+// CreateUpload starts an upload session for a body of the given size that will be POSTed
+// to the given endpoint path.  Expired sessions are removed.
+func CreateUpload(path string, size int64, contentType, user string) (UploadSession, error) {
+	if manager == nil {
+		return UploadSession{}, ErrManagerNotInitialized
+	}
+	return manager.createUpload(path, size, contentType, user)
+}
+
+// GetUpload returns the upload session with the given ID.
+func GetUpload(id string) (UploadSession, error) {
+	if manager == nil {
+		return UploadSession{}, ErrManagerNotInitialized
+	}
+	return manager.getUpload(id)
+}
+
+// PutUploadRange stores bytes of an upload starting at the given offset.  Ranges may be
+// sent in any order, concurrently, and more than once.
+func PutUploadRange(id string, start int64, data []byte) (UploadSession, error) {
+	if manager == nil {
+		return UploadSession{}, ErrManagerNotInitialized
+	}
+	return manager.putUploadRange(id, start, data)
+}
+
+// UploadReader returns a reader of a complete upload's body.
+func UploadReader(id string) (io.Reader, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	return manager.uploadReader(id)
+}
+
+// DeleteUpload removes an upload session and its received bytes.
+func DeleteUpload(id string) error {
+	if manager == nil {
+		return ErrManagerNotInitialized
+	}
+	manager.uploadMutex.Lock()
+	defer manager.uploadMutex.Unlock()
+	if _, err := manager.getUpload(id); err != nil {
+		return err
+	}
+	return manager.deleteUpload(id)
+}
+
+// uploadRangeKey keys received bytes by session, start, and end, so resent ranges of
+// different lengths don't overwrite each other.
+func uploadRangeKey(id string, start, end int64) storage.TKey {
+	b := make([]byte, len(id)+16)
+	copy(b, id)
+	binary.BigEndian.PutUint64(b[len(id):], uint64(start))
+	binary.BigEndian.PutUint64(b[len(id)+8:], uint64(end))
+	return storage.NewTKey(uploadBytesKey, b)
+}
+
+func decodeUploadRangeKey(id string, tk storage.TKey) (ByteRange, error) {
+	b, err := tk.ClassBytes(uploadBytesKey)
+	if err != nil {
+		return ByteRange{}, err
+	}
+	if len(b) != len(id)+16 {
+		return ByteRange{}, fmt.Errorf("bad upload range key for session %s", id)
+	}
+	return ByteRange{
+		Start: int64(binary.BigEndian.Uint64(b[len(id):])),
+		End:   int64(binary.BigEndian.Uint64(b[len(id)+8:])),
+	}, nil
+}
+
+func (m *repoManager) createUpload(path string, size int64, contentType, user string) (UploadSession, error) {
+	if size < 0 {
+		return UploadSession{}, fmt.Errorf("upload size can't be negative")
+	}
+	random := make([]byte, uploadIDBytes)
+	if _, err := rand.Read(random); err != nil {
+		return UploadSession{}, fmt.Errorf("unable to generate upload ID: %v", err)
+	}
+	now := time.Now()
+	s := UploadSession{
+		ID:          hex.EncodeToString(random),
+		Path:        path,
+		Size:        size,
+		ContentType: contentType,
+		User:        user,
+		Received:    []ByteRange{},
+		Created:     now,
+		Updated:     now,
+	}
+
+	m.uploadMutex.Lock()
+	defer m.uploadMutex.Unlock()
+	if err := m.removeExpiredUploads(now); err != nil {
+		return UploadSession{}, err
+	}
+	if err := m.putUpload(s); err != nil {
+		return UploadSession{}, err
+	}
+	return s, nil
+}
+
+func (m *repoManager) putUpload(s UploadSession) error {
+	value, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	var ctx storage.MetadataContext
+	return m.store.Put(ctx, storage.NewTKey(uploadKey, []byte(s.ID)), value)
+}
+
+func (m *repoManager) getUpload(id string) (UploadSession, error) {
+	var ctx storage.MetadataContext
+	value, err := m.store.Get(ctx, storage.NewTKey(uploadKey, []byte(id)))
+	if err != nil {
+		return UploadSession{}, err
+	}
+	if value == nil {
+		return UploadSession{}, ErrUploadNotFound
+	}
+	var s UploadSession
+	if err := json.Unmarshal(value, &s); err != nil {
+		return UploadSession{}, fmt.Errorf("bad upload session %s in metadata: %v", id, err)
+	}
+	return s, nil
+}
+
+func (m *repoManager) putUploadRange(id string, start int64, data []byte) (UploadSession, error) {
+	s, err := m.getUpload(id)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	end := start + int64(len(data))
+	if start < 0 || end > s.Size {
+		return UploadSession{}, fmt.Errorf("bytes %d-%d are outside upload %s of %d bytes", start, end-1, id, s.Size)
+	}
+	if len(data) == 0 {
+		return s, nil
+	}
+
+	// Store the bytes before recording them so a failed write is simply resent.
+	var ctx storage.MetadataContext
+	if err := m.store.Put(ctx, uploadRangeKey(id, start, end), data); err != nil {
+		return UploadSession{}, err
+	}
+
+	m.uploadMutex.Lock()
+	defer m.uploadMutex.Unlock()
+	if s, err = m.getUpload(id); err != nil {
+		if err == ErrUploadNotFound {
+			m.store.Delete(ctx, uploadRangeKey(id, start, end)) // removed while writing
+		}
+		return UploadSession{}, err
+	}
+	s.Received = addRange(s.Received, ByteRange{start, end})
+	s.Updated = time.Now()
+	if err := m.putUpload(s); err != nil {
+		return UploadSession{}, err
+	}
+	return s, nil
+}
+
+// deleteUpload removes a session and its bytes.  The caller must hold the upload mutex.
+func (m *repoManager) deleteUpload(id string) error {
+	var ctx storage.MetadataContext
+	if err := m.store.DeleteRange(ctx, uploadRangeKey(id, 0, 0), uploadRangeKey(id, -1, -1)); err != nil {
+		return err
+	}
+	return m.store.Delete(ctx, storage.NewTKey(uploadKey, []byte(id)))
+}
+
+// removeExpiredUploads removes sessions that haven't received data within the upload
+// expiration.  The caller must hold the upload mutex.
+func (m *repoManager) removeExpiredUploads(now time.Time) error {
+	var ctx storage.MetadataContext
+	kvs, err := m.store.GetRange(ctx, storage.MinTKey(uploadKey), storage.MaxTKey(uploadKey))
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		var s UploadSession
+		if err := json.Unmarshal(kv.V, &s); err != nil {
+			return fmt.Errorf("bad upload session in metadata: %v", err)
+		}
+		if now.Sub(s.Updated) > UploadExpiration {
+			if err := m.deleteUpload(s.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *repoManager) uploadReader(id string) (io.Reader, error) {
+	s, err := m.getUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Complete() {
+		return nil, fmt.Errorf("upload %s is incomplete: received %d of %d bytes", id, s.ReceivedBytes(), s.Size)
+	}
+	var ctx storage.MetadataContext
+	keys, err := m.store.KeysInRange(ctx, uploadRangeKey(id, 0, 0), uploadRangeKey(id, -1, -1))
+	if err != nil {
+		return nil, err
+	}
+	ranges := make([]ByteRange, len(keys))
+	for i, tk := range keys {
+		if ranges[i], err = decodeUploadRangeKey(id, tk); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i].Start != ranges[j].Start {
+			return ranges[i].Start < ranges[j].Start
+		}
+		return ranges[i].End < ranges[j].End
+	})
+	return &uploadReader{m: m, id: id, size: s.Size, ranges: ranges}, nil
+}
+
+// uploadReader reads the stored ranges of an upload in order, one range at a time,
+// skipping bytes already read from overlapping ranges.
+type uploadReader struct {
+	m      *repoManager
+	id     string
+	size   int64
+	ranges []ByteRange
+	pos    int64
+	buf    []byte
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if len(r.ranges) == 0 {
+			if r.pos < r.size {
+				return 0, fmt.Errorf("upload %s is missing bytes from %d", r.id, r.pos)
+			}
+			return 0, io.EOF
+		}
+		br := r.ranges[0]
+		r.ranges = r.ranges[1:]
+		if br.End <= r.pos {
+			continue
+		}
+		if br.Start > r.pos {
+			return 0, fmt.Errorf("upload %s is missing bytes %d-%d", r.id, r.pos, br.Start-1)
+		}
+		var ctx storage.MetadataContext
+		data, err := r.m.store.Get(ctx, uploadRangeKey(r.id, br.Start, br.End))
+		if err != nil {
+			return 0, err
+		}
+		if int64(len(data)) != br.End-br.Start {
+			return 0, fmt.Errorf("upload %s has %d bytes stored for range %d-%d", r.id, len(data), br.Start, br.End-1)
+		}
+		r.buf = data[r.pos-br.Start:]
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.pos += int64(n)
+	return n, nil
+}
@@ -0,0 +1,312 @@
+// +build !clustered,!gcloud
+
+package datastore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// InstanceUsage is the approximate disk usage of a data instance.
+type InstanceUsage struct {
+	Name       dvid.InstanceName
+	DataType   dvid.TypeString
+	DataUUID   dvid.UUID
+	InstanceID dvid.InstanceID
+	Store      storage.Alias `json:",omitempty"`
+	Bytes      uint64
+
+	// Versions gives the bytes stored for each version UUID if requested.
+	Versions map[dvid.UUID]uint64 `json:",omitempty"`
+
+	// Error describes why usage couldn't be determined, e.g., the store can't report sizes.
+	Error string `json:",omitempty"`
+}
+
+// RepoUsage is the approximate disk usage of a repo's data instances.
+type RepoUsage struct {
+	RootUUID  dvid.UUID
+	Alias     string
+	Bytes     uint64
+	Instances []InstanceUsage // sorted by descending bytes.
+}
+
+// GetRepoUsage returns the approximate disk usage of the repo with the given UUID.  If
+// versions is true, the bytes stored for each version are computed by scanning each data
+// instance, which could take a long time.
+func GetRepoUsage(uuid dvid.UUID, versions bool) (*RepoUsage, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	return repoUsage(r, versions)
+}
+
+// GetUsage returns the approximate disk usage of all repos, sorted by descending bytes.
+// If versions is true, the bytes stored for each version are computed by scanning each
+// data instance, which could take a long time.
+func GetUsage(versions bool) ([]*RepoUsage, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	manager.RLock()
+	var repos []*repoT
+	for _, uuid := range manager.repoToUUID {
+		if r, found := manager.repos[uuid]; found {
+			repos = append(repos, r)
+		}
+	}
+	manager.RUnlock()
+
+	usage := make([]*RepoUsage, 0, len(repos))
+	for _, r := range repos {
+		u, err := repoUsage(r, versions)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Bytes > usage[j].Bytes })
+	return usage, nil
+}
+
+func repoUsage(r *repoT, versions bool) (*RepoUsage, error) {
+	r.RLock()
+	usage := &RepoUsage{RootUUID: r.uuid, Alias: r.alias}
+	instances := make([]DataService, 0, len(r.data))
+	for _, d := range r.data {
+		instances = append(instances, d)
+	}
+	r.RUnlock()
+
+	sizes, errs := instanceSizes(instances)
+	aliases := storeAliases()
+	for _, d := range instances {
+		iu := InstanceUsage{
+			Name:       d.DataName(),
+			DataType:   d.TypeName(),
+			DataUUID:   d.DataUUID(),
+			InstanceID: d.InstanceID(),
+			Bytes:      sizes[d.InstanceID()],
+		}
+		store, err := d.KVStore()
+		if err != nil {
+			iu.Error = err.Error()
+			usage.Instances = append(usage.Instances, iu)
+			continue
+		}
+		iu.Store = aliases[store]
+		if err, found := errs[d.InstanceID()]; found {
+			iu.Error = err.Error()
+		}
+		if versions {
+			vsizes, err := storage.GetVersionSizes(store, d.InstanceID())
+			if err != nil {
+				iu.Error = err.Error()
+			} else {
+				iu.Versions = make(map[dvid.UUID]uint64, len(vsizes))
+				for v, size := range vsizes {
+					uuid, err := UUIDFromVersion(v)
+					if err != nil {
+						uuid = dvid.UUID(fmt.Sprintf("unknown-%d", v))
+					}
+					iu.Versions[uuid] = size
+				}
+			}
+		}
+		usage.Bytes += iu.Bytes
+		usage.Instances = append(usage.Instances, iu)
+	}
+	sort.Slice(usage.Instances, func(i, j int) bool { return usage.Instances[i].Bytes > usage.Instances[j].Bytes })
+	return usage, nil
+}
+
+// instanceSizes returns the approximate bytes stored for each data instance, grouping
+// instances by store for efficiency.  Instances whose stores can't report sizes are
+// returned with an error.
+func instanceSizes(instances []DataService) (map[dvid.InstanceID]uint64, map[dvid.InstanceID]error) {
+	stores := make(map[dvid.Store][]dvid.InstanceID)
+	errs := make(map[dvid.InstanceID]error)
+	for _, d := range instances {
+		store, err := d.KVStore()
+		if err != nil {
+			errs[d.InstanceID()] = err
+			continue
+		}
+		stores[store] = append(stores[store], d.InstanceID())
+	}
+	sizes := make(map[dvid.InstanceID]uint64, len(instances))
+	for store, ids := range stores {
+		s, err := storage.GetDataSizes(store, ids)
+		if err != nil {
+			for _, id := range ids {
+				errs[id] = err
+			}
+			continue
+		}
+		for id, size := range s {
+			sizes[id] = size
+		}
+	}
+	return sizes, errs
+}
+
+// storeAliases returns the alias for each configured store.
+func storeAliases() map[dvid.Store]storage.Alias {
+	stores, err := storage.AllStores()
+	if err != nil {
+		return nil
+	}
+	aliases := make(map[dvid.Store]storage.Alias, len(stores))
+	for alias, store := range stores {
+		aliases[store] = alias
+	}
+	return aliases
+}
+
+// VersionUsage is the bytes stored in one version across a repo's data instances.
+type VersionUsage struct {
+	UUID      dvid.UUID
+	Branch    string `json:",omitempty"`
+	Note      string `json:",omitempty"`
+	Locked    bool
+	Bytes     uint64
+	Instances map[dvid.InstanceName]uint64 // bytes stored in the version by each instance.
+}
+
+// VersionReport is the bytes stored in each version of a repo as of its last measurement.
+// Bytes are counted for the keys and values written in a version, including tombstones,
+// and not for values inherited from ancestors.
+type VersionReport struct {
+	RootUUID dvid.UUID
+	Measured time.Time
+	Bytes    uint64
+	Versions []VersionUsage // sorted by descending bytes.
+
+	// Errors gives the instances whose key-value pairs couldn't be scanned.
+	Errors map[dvid.InstanceName]string `json:",omitempty"`
+}
+
+var versionReports struct {
+	sync.Mutex
+	byRoot map[dvid.UUID]*VersionReport
+}
+
+// MeasureVersionUsage starts a job that scans all data instances of the repo with the
+// given UUID to measure the bytes stored in each version, returning the job ID.  The
+// resulting report is kept until the next measurement and can be retrieved with
+// GetVersionUsage.
+func MeasureVersionUsage(uuid dvid.UUID) (jobID uint64, err error) {
+	if manager == nil {
+		return 0, ErrManagerNotInitialized
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return 0, err
+	}
+	r.RLock()
+	root := r.uuid
+	instances := make([]DataService, 0, len(r.data))
+	for _, d := range r.data {
+		instances = append(instances, d)
+	}
+	r.RUnlock()
+	sort.Slice(instances, func(i, j int) bool { return instances[i].DataName() < instances[j].DataName() })
+
+	job := newJob("measure version usage", fmt.Sprintf("repo %s", root))
+	go func() {
+		report, err := measureVersionUsage(job, root, instances)
+		if err == nil {
+			versionReports.Lock()
+			if versionReports.byRoot == nil {
+				versionReports.byRoot = make(map[dvid.UUID]*VersionReport)
+			}
+			versionReports.byRoot[root] = report
+			versionReports.Unlock()
+		}
+		finishJob(job, err)
+	}()
+	return job, nil
+}
+
+// GetVersionUsage returns the last measured bytes stored in each version of the repo
+// with the given UUID.
+func GetVersionUsage(uuid dvid.UUID) (*VersionReport, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	root, err := manager.getRepoRoot(uuid)
+	if err != nil {
+		return nil, err
+	}
+	versionReports.Lock()
+	defer versionReports.Unlock()
+	report, found := versionReports.byRoot[root]
+	if !found {
+		return nil, fmt.Errorf("version usage of repo %s hasn't been measured", root)
+	}
+	return report, nil
+}
+
+func measureVersionUsage(job uint64, root dvid.UUID, instances []DataService) (*VersionReport, error) {
+	report := &VersionReport{RootUUID: root, Measured: time.Now()}
+	versions := make(map[dvid.VersionID]*VersionUsage)
+	for _, d := range instances {
+		if jobCancelled(job) {
+			return nil, ErrJobCancelled
+		}
+		setJobStep(job, fmt.Sprintf("scanning data %q (instance %d)", d.DataName(), d.InstanceID()))
+		store, err := d.KVStore()
+		if err == nil {
+			var vsizes map[dvid.VersionID]uint64
+			if vsizes, err = storage.GetVersionSizes(store, d.InstanceID()); err == nil {
+				for v, size := range vsizes {
+					vu, found := versions[v]
+					if !found {
+						vu = &VersionUsage{Instances: make(map[dvid.InstanceName]uint64)}
+						versions[v] = vu
+					}
+					vu.Bytes += size
+					vu.Instances[d.DataName()] = size
+					report.Bytes += size
+				}
+			}
+		}
+		if err != nil {
+			if report.Errors == nil {
+				report.Errors = make(map[dvid.InstanceName]string)
+			}
+			report.Errors[d.DataName()] = err.Error()
+		}
+	}
+
+	dag, err := GetDAG(root)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[dvid.UUID]DAGNode, len(dag.Nodes))
+	for _, node := range dag.Nodes {
+		nodes[node.UUID] = node
+	}
+	for v, vu := range versions {
+		uuid, err := UUIDFromVersion(v)
+		if err != nil {
+			uuid = dvid.UUID(fmt.Sprintf("unknown-%d", v))
+		}
+		vu.UUID = uuid
+		if node, found := nodes[uuid]; found {
+			vu.Branch, vu.Note, vu.Locked = node.Branch, node.Note, node.Locked
+		}
+		report.Versions = append(report.Versions, *vu)
+	}
+	sort.Slice(report.Versions, func(i, j int) bool { return report.Versions[i].Bytes > report.Versions[j].Bytes })
+	return report, nil
+}
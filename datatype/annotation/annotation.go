@@ -1879,8 +1879,10 @@ func (d *Data) resync(ctx *datastore.VersionedCtx) {
 	timedLog.Infof("Completed asynchronous annotation %q reload of %d block and %d tag elements.", d.DataName(), totBlockE, totTagE)
 }
 
+// ReloadData asynchronously recomputes the label and tag denormalizations.  The reload
+// gets its own context since it continues after the request is finished.
 func (d *Data) ReloadData(ctx *datastore.VersionedCtx) {
-	go d.resync(ctx)
+	go d.resync(datastore.NewVersionedCtx(d, ctx.VersionID()))
 	dvid.Infof("Started reload of annotations %q...\n", d.DataName())
 }
 
@@ -175,7 +175,7 @@ GET  <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>][?qu
   	scale         Default is 0.  For scale N, returns an image down-sampled by a factor of 2^N.
     throttle      Only works for 3d data requests.  If "true", makes sure only N compute-intense operation 
     				(all API calls that can be throttled) are handled.  If the server can't initiate the API 
-    				call right away, a 503 (Service Unavailable) status code is returned.
+    				call right away, it waits its turn by request priority.
 `
 
 func init() {
@@ -1342,7 +1342,7 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 	case "raw":
 		queryStrings := r.URL.Query()
 		if throttle := queryStrings.Get("throttle"); throttle == "on" || throttle == "true" {
-			if server.ThrottledHTTP(w) {
+			if server.ThrottledHTTP(w, r) {
 				return
 			}
 			defer server.ThrottledOpDone()
@@ -289,7 +289,7 @@ func (d *Data) computeValue(pt dvid.Vector3d, ctx storage.Context, keyF KeyFunc,
 		if serializedData == nil || len(serializedData) == 0 {
 			deserializedData = emptyBlock
 		} else {
-			deserializedData, _, err = dvid.DeserializeData(serializedData, true)
+			deserializedData, _, err = d.DeserializeValue(ctx.VersionID(), storage.TKey(key), serializedData, true)
 			if err != nil {
 				return nil, fmt.Errorf("Unable to deserialize block: %v", err)
 			}
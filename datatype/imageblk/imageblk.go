@@ -211,7 +211,7 @@ GET  <api URL>/node/<UUID>/<data name>/isotropic/<dims>/<size>/<offset>[/<format
 
     throttle      Only works for 3d data requests.  If "true", makes sure only N compute-intense operation 
                     (all API calls that can be throttled) are handled.  If the server can't initiate the API 
-                    call right away, a 503 (Service Unavailable) status code is returned.
+                    call right away, it waits its turn by request priority.
 
 GET  <api URL>/node/<UUID>/<data name>/specificblocks[?queryopts]
 
@@ -320,8 +320,8 @@ GET  <api URL>/node/<UUID>/<data name>/subvolblocks/<size>/<offset>[?queryopts]
 
     compression   Allows retrieval of block data in "jpeg" (default) or "uncompressed".
     throttle      If "true", makes sure only N compute-intense operation (all API calls that can be throttled) 
-                    are handled.  If the server can't initiate the API call right away, a 503 (Service Unavailable) 
-                    status code is returned.
+                    are handled.  If the server can't initiate the API call right away, it waits its turn by 
+                    request priority.
 
 
 
@@ -370,7 +370,7 @@ GET  <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>][?qu
                   Default is to zero out voxels outside ROI.
     throttle      Only works for 3d data requests.  If "true", makes sure only N compute-intense operation 
                     (all API calls that can be throttled) are handled.  If the server can't initiate the API 
-                    call right away, a 503 (Service Unavailable) status code is returned.
+                    call right away, it waits its turn by request priority.
 
 POST <api URL>/node/<UUID>/<data name>/raw/0_1_2/<size>/<offset>[?queryopts]
 
@@ -383,8 +383,8 @@ POST <api URL>/node/<UUID>/<data name>/raw/0_1_2/<size>/<offset>[?queryopts]
 
     Throttling can be enabled by passing a "throttle=true" query string.  Throttling makes sure
     only one compute-intense operation (all API calls that can be throttled) is handled.
-    If the server can't initiate the API call right away, a 503 (Service Unavailable) status
-    code is returned.
+    If the server can't initiate the API call right away, it waits its turn by request
+    priority.
 
     Arguments:
 
@@ -402,7 +402,7 @@ POST <api URL>/node/<UUID>/<data name>/raw/0_1_2/<size>/<offset>[?queryopts]
                     POST operations will be slower due to a required GET to retrieve past data.
     throttle      If "true", makes sure only N compute-intense operation 
                     (all API calls that can be throttled) are handled.  If the server can't initiate the API 
-                    call right away, a 503 (Service Unavailable) status code is returned.
+                    call right away, it waits its turn by request priority.
 
 GET  <api URL>/node/<UUID>/<data name>/arb/<top left>/<top right>/<bottom left>/<res>[/<format>][?queryopts]
 
@@ -431,7 +431,7 @@ GET  <api URL>/node/<UUID>/<data name>/arb/<top left>/<top right>/<bottom left>/
 
     throttle      If "true", makes sure only N compute-intense operation 
                     (all API calls that can be throttled) are handled.  If the server can't initiate the API 
-                    call right away, a 503 (Service Unavailable) status code is returned.
+                    call right away, it waits its turn by request priority.
 
  GET <api URL>/node/<UUID>/<data name>/blocks/<block coord>/<spanX>
 POST <api URL>/node/<UUID>/<data name>/blocks/<block coord>/<spanX>
@@ -930,8 +930,8 @@ func (d *Data) serializeExtents(extents ExtentsJSON) ([]byte, error) {
 
 }
 
-// serializeExtents takes extents structure and serializes and compresses it
-func (d *Data) deserializeExtents(serdata []byte) (ExtentsJSON, error) {
+// deserializeExtents deserializes the extents stored for a version.
+func (d *Data) deserializeExtents(v dvid.VersionID, serdata []byte) (ExtentsJSON, error) {
 
 	// return blank extents if nothing set
 	var extents ExtentsJSON
@@ -940,7 +940,7 @@ func (d *Data) deserializeExtents(serdata []byte) (ExtentsJSON, error) {
 	}
 
 	// deserialize
-	data, _, err := dvid.DeserializeData(serdata, true)
+	data, _, err := d.DeserializeValue(v, MetaTKey(), serdata, true)
 	if err != nil {
 		return extents, err
 	}
@@ -1237,7 +1237,7 @@ func (d *Data) GetExtents(ctx *datastore.VersionedCtx) (dvid.Extents, error) {
 	if err != nil {
 		return dvidextents, err
 	}
-	extents, err := d.deserializeExtents(ser_extents)
+	extents, err := d.deserializeExtents(ctx.VersionID(), ser_extents)
 	if err != nil {
 		return dvidextents, err
 	}
@@ -1256,13 +1256,13 @@ func (d *Data) PostExtents(ctx *datastore.VersionedCtx, start dvid.Point, end dv
 	if err != nil {
 		return err
 	}
-	patchdb, haspatch := store.(storage.TransactionDB)
-
-	if haspatch {
+	// stores shared with other servers are patched since the extents can change elsewhere
+	if storage.IsShared(store) {
+		patchdb := store.(storage.TransactionDB)
 		// use patch function (do not post if no change)
 		patchfunc := func(data []byte) ([]byte, error) {
 			// will return empty extents if data is empty
-			extentsjson, err := d.deserializeExtents(data)
+			extentsjson, err := d.deserializeExtents(ctx.VersionID(), data)
 			if err != nil {
 				return nil, err
 			}
@@ -1303,7 +1303,7 @@ func (d *Data) PostExtents(ctx *datastore.VersionedCtx, start dvid.Point, end dv
 			return err
 		}
 
-		extentsjson, err := d.deserializeExtents(data)
+		extentsjson, err := d.deserializeExtents(ctx.VersionID(), data)
 		if err != nil {
 			return err
 		}
@@ -1414,7 +1414,7 @@ func (d *Data) ModifyConfig(config dvid.Config) error {
 	if err := p.setByConfig(config); err != nil {
 		return err
 	}
-	return nil
+	return d.Data.ModifyMetadata(config)
 }
 
 // ForegroundROI creates a new ROI by determining all non-background blocks.
@@ -1496,7 +1496,7 @@ func (d *Data) foregroundROI(v dvid.VersionID, dest *roi.Data, background dvid.P
 		if chunk == nil || chunk.V == nil {
 			return nil
 		}
-		data, _, err := dvid.DeserializeData(chunk.V, true)
+		data, _, err := d.DeserializeValue(v, chunk.K, chunk.V, true)
 		if err != nil {
 			return fmt.Errorf("Error decoding block: %v\n", err)
 		}
@@ -1658,7 +1658,10 @@ func debugData(img image.Image, message string) {
 	}
 }
 
-func (d *Data) SendBlockSimple(w http.ResponseWriter, x, y, z int32, v []byte, compression string) error {
+// SendBlockSimple writes the block at the given block coordinate, stored under the given
+// key and read for the given version, with its coordinate and size.  A corrupt value
+// returns a *datastore.CorruptValueError before anything is written.
+func (d *Data) SendBlockSimple(w http.ResponseWriter, version dvid.VersionID, tk storage.TKey, x, y, z int32, v []byte, compression string) error {
 	// Check internal format and see if it's valid with compression choice.
 	format, checksum := dvid.DecodeSerializationFormat(dvid.SerializationFormat(v[0]))
 
@@ -1666,6 +1669,17 @@ func (d *Data) SendBlockSimple(w http.ResponseWriter, x, y, z int32, v []byte, c
 		return fmt.Errorf("Expected internal block data to be JPEG, was %s instead.", format)
 	}
 
+	// Check the stored value or deserialize it before anything is written.
+	var data []byte
+	if compression == "uncompressed" {
+		var err error
+		if data, _, err = d.DeserializeValue(version, tk, v, true); err != nil {
+			return err
+		}
+	} else if err := d.VerifyValue(version, tk, v); err != nil {
+		return err
+	}
+
 	// Send block coordinate and size of data.
 	if err := binary.Write(w, binary.LittleEndian, x); err != nil {
 		return err
@@ -1684,14 +1698,7 @@ func (d *Data) SendBlockSimple(w http.ResponseWriter, x, y, z int32, v []byte, c
 	}
 
 	// Do any adjustment of sent data based on compression request
-	var data []byte
-	if compression == "uncompressed" {
-		var err error
-		data, _, err = dvid.DeserializeData(v, true)
-		if err != nil {
-			return err
-		}
-	} else {
+	if compression != "uncompressed" {
 		data = v[start:]
 	}
 	n := len(data)
@@ -1772,7 +1779,7 @@ func (d *Data) SendBlocksSpecific(ctx *datastore.VersionedCtx, w http.ResponseWr
 					// lock shared resource
 					mutex.Lock()
 					defer mutex.Unlock()
-					d.SendBlockSimple(w, xloc, yloc, zloc, value, compression)
+					err = d.SendBlockSimple(w, ctx.VersionID(), keyBeg, xloc, yloc, zloc, value, compression)
 				}
 			}
 		}(int32(xloc), int32(yloc), int32(zloc), isprefetch, finishedRequests, store)
@@ -1821,7 +1828,7 @@ func (d *Data) SendBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter, su
 			return err
 		}
 		if len(value) > 0 {
-			return d.SendBlockSimple(w, blockoffset.Value(0), blockoffset.Value(1), blockoffset.Value(2), value, compression)
+			return d.SendBlockSimple(w, ctx.VersionID(), keyBeg, blockoffset.Value(0), blockoffset.Value(1), blockoffset.Value(2), value, compression)
 		}
 		return nil
 	}
@@ -1867,7 +1874,7 @@ func (d *Data) SendBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter, su
 					if z != sz || y != sy || x < sx || x >= sx+int32(blocksize.Value(0)) {
 						return nil
 					}
-					if err := d.SendBlockSimple(w, x, y, z, kv.V, compression); err != nil {
+					if err := d.SendBlockSimple(w, ctx.VersionID(), kv.K, x, y, z, kv.V, compression); err != nil {
 						return err
 					}
 					return nil
@@ -1901,7 +1908,7 @@ func (d *Data) SendBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter, su
 					}
 					x, y, z := indexZYX.Unpack()
 
-					if err := d.SendBlockSimple(w, x, y, z, kv.V, compression); err != nil {
+					if err := d.SendBlockSimple(w, ctx.VersionID(), kv.K, x, y, z, kv.V, compression); err != nil {
 						return err
 					}
 					return nil
@@ -2075,7 +2082,7 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		sizeStr, offsetStr := parts[4], parts[5]
 
 		if throttle := queryStrings.Get("throttle"); throttle == "on" || throttle == "true" {
-			if server.ThrottledHTTP(w) {
+			if server.ThrottledHTTP(w, r) {
 				return
 			}
 			defer server.ThrottledOpDone()
@@ -2155,7 +2162,7 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			return
 		}
 		if throttle := queryStrings.Get("throttle"); throttle == "on" || throttle == "true" {
-			if server.ThrottledHTTP(w) {
+			if server.ThrottledHTTP(w, r) {
 				return
 			}
 			defer server.ThrottledOpDone()
@@ -2237,7 +2244,7 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			timedLog.Infof("HTTP %s: %s (%s)", r.Method, plane, r.URL)
 		case 3:
 			if throttle := queryStrings.Get("throttle"); throttle == "on" || throttle == "true" {
-				if server.ThrottledHTTP(w) {
+				if server.ThrottledHTTP(w, r) {
 					return
 				}
 				defer server.ThrottledOpDone()
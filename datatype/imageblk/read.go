@@ -314,6 +314,7 @@ func (d *Data) GetVolume(v dvid.VersionID, vox *Voxels, roiname dvid.InstanceNam
 }
 
 type getOperation struct {
+	version     dvid.VersionID
 	voxels      *Voxels
 	blocksInROI map[string]bool
 	attenuation uint8
@@ -375,9 +376,9 @@ func (d *Data) GetVoxels(v dvid.VersionID, vox *Voxels, roiname dvid.InstanceNam
 					blocksInROI[indexString] = true
 				}
 			}
-			chunkOp = &storage.ChunkOp{&getOperation{vox, blocksInROI, r.attenuation}, wg}
+			chunkOp = &storage.ChunkOp{&getOperation{v, vox, blocksInROI, r.attenuation}, wg}
 		} else {
-			chunkOp = &storage.ChunkOp{&getOperation{vox, nil, 0}, wg}
+			chunkOp = &storage.ChunkOp{&getOperation{v, vox, nil, 0}, wg}
 		}
 
 		if !hasbuffer {
@@ -486,7 +487,7 @@ func (d *Data) GetBlocks(v dvid.VersionID, start dvid.ChunkPoint3d, span int32)
 
 		// Spawn goroutine to transfer data
 		wg.Add(1)
-		go xferBlock(buf[i:j], c, &wg)
+		go d.xferBlock(v, buf[i:j], c, &wg)
 		return nil
 	})
 	if err != nil {
@@ -496,12 +497,12 @@ func (d *Data) GetBlocks(v dvid.VersionID, start dvid.ChunkPoint3d, span int32)
 	return buf, nil
 }
 
-func xferBlock(buf []byte, chunk *storage.Chunk, wg *sync.WaitGroup) {
+func (d *Data) xferBlock(v dvid.VersionID, buf []byte, chunk *storage.Chunk, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	kv := chunk.TKeyValue
 	uncompress := true
-	block, _, err := dvid.DeserializeData(kv.V, uncompress)
+	block, _, err := d.DeserializeValue(v, kv.K, kv.V, uncompress)
 	if err != nil {
 		dvid.Errorf("Unable to deserialize block (%v): %v", kv.K, err)
 		return
@@ -545,7 +546,7 @@ func (d *Data) LoadOldBlocks(v dvid.VersionID, vox *Voxels, blocks storage.TKeyV
 			if err != nil {
 				return err
 			}
-			block, _, err := dvid.DeserializeData(kv.V, true)
+			block, _, err := d.DeserializeValue(v, kv.K, kv.V, true)
 			if err != nil {
 				return fmt.Errorf("Unable to deserialize block, %s: %v", ctx, err)
 			}
@@ -585,7 +586,7 @@ func (d *Data) GetBlock(v dvid.VersionID, k storage.TKey) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	data, _, err := dvid.DeserializeData(serialization, true)
+	data, _, err := d.DeserializeValue(v, k, serialization, true)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to deserialize block, %s: %v", ctx, err)
 	}
@@ -652,7 +653,7 @@ func (d *Data) readChunk(chunk *storage.Chunk) {
 	if zeroOut || chunk.V == nil {
 		blockData = d.BackgroundBlock()
 	} else {
-		blockData, _, err = dvid.DeserializeData(chunk.V, true)
+		blockData, _, err = d.DeserializeValue(op.version, chunk.K, chunk.V, true)
 		if err != nil {
 			dvid.Errorf("Unable to deserialize block in '%s': %v\n", d.DataName(), err)
 			return
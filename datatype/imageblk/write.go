@@ -144,17 +144,9 @@ func (d *Data) PutVoxels(v dvid.VersionID, mutID uint64, vox *Voxels, roiname dv
 		return fmt.Errorf("Data type imageblk had error initializing store: %v\n", err)
 	}
 
-	// extract patchdb interface
-	_, haspatch := store.(storage.TransactionDB)
-
 	// extract buffer interface
 	_, hasbuffer := store.(storage.KeyValueRequester)
 
-	// Make sure vox is block-aligned if no patch interface
-	if !haspatch && !dvid.BlockAligned(vox, d.BlockSize()) {
-		return fmt.Errorf("cannot store voxels in non-block aligned geometry %s -> %s", vox.StartPoint(), vox.EndPoint())
-	}
-
 	// Only do one request at a time, although each request can start many goroutines.
 	if !hasbuffer {
 		server.LargeMutationMutex.Lock()
@@ -249,10 +241,6 @@ func (d *Data) PutVoxels(v dvid.VersionID, mutID uint64, vox *Voxels, roiname dv
 				continue
 			}
 
-			if !haspatch && patchgeo != nil {
-				return fmt.Errorf("Non-block aligned request for DB that requires block alignment")
-			}
-
 			kv := &storage.TKeyValue{K: NewTKey(&curIndex)}
 			putOp := &putOperation{vox, curIndex, v, mutate, mutID}
 			op := &storage.ChunkOp{putOp, nil}
@@ -411,7 +399,7 @@ func (d *Data) putChunk(chunk *storage.Chunk, hasbuffer bool, patchgeo *patchGeo
 	if chunk.V == nil {
 		blockData = d.BackgroundBlock()
 	} else {
-		blockData, _, err = dvid.DeserializeData(chunk.V, true)
+		blockData, _, err = d.DeserializeValue(op.version, chunk.K, chunk.V, true)
 		if err != nil {
 			dvid.Errorf("Unable to deserialize block in %q: %v\n", d.DataName(), err)
 			return
@@ -490,7 +478,7 @@ func (d *Data) putChunk(chunk *storage.Chunk, hasbuffer bool, patchgeo *patchGeo
 				// use blank default block
 				origdata = blockData
 			} else {
-				origdata, _, err = dvid.DeserializeData(origdata, true)
+				origdata, _, err = d.DeserializeValue(op.version, chunk.K, origdata, true)
 				if err != nil {
 					return nil, err
 				}
@@ -512,8 +500,9 @@ func (d *Data) putChunk(chunk *storage.Chunk, hasbuffer bool, patchgeo *patchGeo
 			return retdata, err
 		}
 
-		patchdb, _ := store.(storage.TransactionDB)
-		if err = patchdb.Patch(ctx, chunk.K, patchfunc); err != nil {
+		// Blocks only partially covered by the voxels are patched, which is emulated
+		// for stores without native transactions.
+		if err = storage.Patch(store, ctx, chunk.K, patchfunc); err != nil {
 			dvid.Errorf("Unable to PUT voxel data for key %v: %v\n", chunk.K, err)
 			return
 		}
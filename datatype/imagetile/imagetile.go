@@ -1129,9 +1129,9 @@ func (d *Data) ServeTile(ctx storage.Context, w http.ResponseWriter, r *http.Req
 
 	switch d.Encoding {
 	case LZ4:
-		var img dvid.Image
-		if err := img.Deserialize(data); err != nil {
-			return err
+		img, derr := d.deserializeTile(ctx, tileReq, data)
+		if derr != nil {
+			return derr
 		}
 		data, err = img.GetPNG()
 		w.Header().Set("Content-type", "image/png")
@@ -1186,8 +1186,7 @@ func (d *Data) getTileImage(ctx storage.Context, req TileReq) (image.Image, erro
 	var goImg image.Image
 	switch d.Encoding {
 	case LZ4:
-		var img dvid.Image
-		err = img.Deserialize(data)
+		img, err := d.deserializeTile(ctx, req, data)
 		if err != nil {
 			return nil, err
 		}
@@ -1225,6 +1224,19 @@ func (d *Data) getTileData(ctx storage.Context, req TileReq) ([]byte, error) {
 	return data, nil
 }
 
+// deserializeTile returns the image of a tile stored with LZ4 encoding.
+func (d *Data) deserializeTile(ctx storage.Context, req TileReq, data []byte) (*dvid.Image, error) {
+	raw, _, err := d.DeserializeValue(ctx.VersionID(), NewTKeyByTileReq(req), data, true)
+	if err != nil {
+		return nil, err
+	}
+	img := new(dvid.Image)
+	if err := img.UnmarshalBinary(raw); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
 // getBlankTileData returns zero 2d tile image.
 func (d *Data) getBlankTileImage(req TileReq) (image.Image, error) {
 	levelSpec, found := d.Levels[req.scale]
@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
@@ -98,6 +99,16 @@ GET  <api URL>/node/<UUID>/<data name>/keyrange/<key1>/<key2>
 
 	[key1, key2, ...]
 
+    Query-string Options for keys and keyrange:
+
+    limit         Maximum number of keys to return.  If more remain, the response has an
+                  X-Next-Cursor header with the last key returned and a Link header with
+                  the URL of the next page.  Only a page of keys is read from the store, so
+                  instances with huge numbers of keys should be listed a page at a time.
+    cursor        Return keys after the given key, e.g., the X-Next-Cursor of a previous page.
+    offset        Number of keys to skip.
+    prefix        Only return keys starting with the given prefix (keys endpoint only).
+
     Arguments:
 
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
@@ -106,7 +117,7 @@ GET  <api URL>/node/<UUID>/<data name>/keyrange/<key1>/<key2>
     key2          Last alphanumeric key in range.
 
 GET  <api URL>/node/<UUID>/<data name>/key/<key>
-POST <api URL>/node/<UUID>/<data name>/key/<key>
+POST <api URL>/node/<UUID>/<data name>/key/<key>[?ttl=<duration>]
 DEL  <api URL>/node/<UUID>/<data name>/key/<key> 
 
     Performs operations on a key-value pair depending on the HTTP verb.  
@@ -126,6 +137,12 @@ DEL  <api URL>/node/<UUID>/<data name>/key/<key>
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
     data name     Name of keyvalue data instance.
     key           An alphanumeric key.
+
+    Query-string Options (POST only):
+
+    ttl           Time-to-live after which the key expires, e.g., "90s" or "24h".  Expired keys
+                  are garbage collected by the storage engine, which must support TTLs
+                  (currently badger).  Useful for caches and temporary results.
 `
 
 func init() {
@@ -170,6 +187,32 @@ func (dtype *Type) Help() string {
 	return fmt.Sprintf(HelpMessage)
 }
 
+// Routes describes the HTTP endpoints of keyvalue data for the server's OpenAPI
+// specification.
+func (dtype *Type) Routes() []datastore.Route {
+	page := []datastore.RouteParam{
+		{Name: "limit", Description: "Maximum number of keys to return."},
+		{Name: "cursor", Description: "Return keys after the given key, e.g., the X-Next-Cursor of a previous page."},
+		{Name: "offset", Description: "Number of keys to skip."},
+	}
+	keysQuery := append([]datastore.RouteParam{{Name: "prefix", Description: "Only return keys starting with the given prefix."}}, page...)
+	return []datastore.Route{
+		{Method: "GET", Path: "/help", Summary: "Returns the keyvalue help.", Returns: "text/plain"},
+		{Method: "GET", Path: "/info", Summary: "Returns JSON of the data instance's properties.", Returns: "application/json"},
+		{Method: "GET", Path: "/keys", Summary: "Returns a JSON list of keys.", Query: keysQuery, Returns: "application/json"},
+		{Method: "GET", Path: "/keyrange/:key1/:key2", Summary: "Returns a JSON list of keys between key1 and key2 inclusive.", Query: page, Returns: "application/json"},
+		{Method: "GET", Path: "/key/:key", Summary: "Returns the value of a key.", Returns: "application/octet-stream"},
+		{
+			Method:  "POST",
+			Path:    "/key/:key",
+			Summary: "Stores the body as the value of a key.",
+			Query:   []datastore.RouteParam{{Name: "ttl", Description: "Time-to-live after which the key expires, e.g., \"24h\"."}},
+			Body:    "application/octet-stream",
+		},
+		{Method: "DELETE", Path: "/key/:key", Summary: "Deletes a key."},
+	}
+}
+
 // Data embeds the datastore's Data and extends it with keyvalue properties (none for now).
 type Data struct {
 	*datastore.Data
@@ -264,6 +307,62 @@ func (d *Data) GetKeys(ctx storage.Context) ([]string, error) {
 	return keyList, nil
 }
 
+// getKeysPage returns a page of the keys in the range (first, last), streaming keys so
+// only the page is held in memory, and sets the response's next page headers if more
+// keys remain.
+func (d *Data) getKeysPage(ctx storage.Context, w http.ResponseWriter, r *http.Request, first, last storage.TKey, page server.Page) ([]string, error) {
+	db, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	if page.Cursor != "" {
+		// Stored keys are the type-specific key followed by version bytes, so the range
+		// after the cursor starts at the successor of its type-specific key.  Incrementing
+		// the 0 terminator sorts after all versions of the cursor key and before any key
+		// with the cursor as prefix, e.g., "a1" < "a1\x01" < "a1b".
+		after, err := NewTKey(page.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after[len(after)-1]++
+		if bytes.Compare(after, first) > 0 {
+			first = after
+		}
+	}
+	stream, err := storage.GetRangeStream(db, ctx, first, last)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	keyList := []string{}
+	skip := page.Offset
+	for tkv := range stream.C {
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if page.Limit > 0 && len(keyList) == page.Limit {
+			server.SetNextPage(w, r, keyList[len(keyList)-1])
+			return keyList, nil
+		}
+		keyStr, err := DecodeTKey(tkv.K)
+		if err != nil {
+			return nil, err
+		}
+		keyList = append(keyList, keyStr)
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	return keyList, nil
+}
+
+// DescribeTKey returns the string key for a type-specific key, e.g., when listing the keys
+// changed between versions.
+func (d *Data) DescribeTKey(tk storage.TKey) (string, error) {
+	return DecodeTKey(tk)
+}
+
 // GetData gets a value using a key
 func (d *Data) GetData(ctx storage.Context, keyStr string) ([]byte, bool, error) {
 	db, err := d.GetOrderedKeyValueDB()
@@ -282,8 +381,11 @@ func (d *Data) GetData(ctx storage.Context, keyStr string) ([]byte, bool, error)
 		return nil, false, nil
 	}
 	uncompress := true
-	value, _, err := dvid.DeserializeData(data, uncompress)
+	value, _, err := d.DeserializeValue(ctx.VersionID(), tk, data, uncompress)
 	if err != nil {
+		if _, corrupt := err.(*datastore.CorruptValueError); corrupt {
+			return nil, false, err
+		}
 		return nil, false, fmt.Errorf("Unable to deserialize data for key '%s': %v\n", keyStr, err)
 	}
 	return value, true, nil
@@ -291,10 +393,23 @@ func (d *Data) GetData(ctx storage.Context, keyStr string) ([]byte, bool, error)
 
 // PutData puts a key-value at a given uuid
 func (d *Data) PutData(ctx storage.Context, keyStr string, value []byte) error {
+	return d.PutDataTTL(ctx, keyStr, value, 0)
+}
+
+// PutDataTTL puts a key-value pair that expires after the given time-to-live, which
+// requires a store that supports TTLs.  A zero TTL means the key never expires.
+func (d *Data) PutDataTTL(ctx storage.Context, keyStr string, value []byte, ttl time.Duration) error {
 	db, err := d.GetOrderedKeyValueDB()
 	if err != nil {
 		return err
 	}
+	var ttlDB storage.TTLPutter
+	if ttl != 0 {
+		var ok bool
+		if ttlDB, ok = db.(storage.TTLPutter); !ok {
+			return fmt.Errorf("store for keyvalue %q does not support expiring keys", d.DataName())
+		}
+	}
 	serialization, err := dvid.SerializeData(value, d.Compression(), d.Checksum())
 	if err != nil {
 		return fmt.Errorf("Unable to serialize data: %v\n", err)
@@ -303,6 +418,9 @@ func (d *Data) PutData(ctx storage.Context, keyStr string, value []byte) error {
 	if err != nil {
 		return err
 	}
+	if ttlDB != nil {
+		return ttlDB.PutTTL(ctx, tk, serialization, ttl)
+	}
 	return db.Put(ctx, tk, serialization)
 }
 
@@ -415,7 +533,26 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		return
 
 	case "keys":
-		keyList, err := d.GetKeys(ctx)
+		page, err := server.ParsePage(r)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		var keyList []string
+		if prefix := r.URL.Query().Get("prefix"); prefix != "" || page.Paged() {
+			first, last := storage.MinTKey(keyStandard), storage.MaxTKey(keyStandard)
+			if prefix != "" {
+				// The smallest key with the prefix is the prefix itself.
+				if first, err = NewTKey(prefix); err != nil {
+					server.BadRequest(w, r, err)
+					return
+				}
+				last = storage.NewTKey(keyStandard, append([]byte(prefix), 0xFF))
+			}
+			keyList, err = d.getKeysPage(ctx, w, r, first, last, page)
+		} else {
+			keyList, err = d.GetKeys(ctx)
+		}
 		if err != nil {
 			server.BadRequest(w, r, err)
 			return
@@ -438,7 +575,19 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		// Return JSON list of keys
 		keyBeg := parts[4]
 		keyEnd := parts[5]
-		keyList, err := d.GetKeysInRange(ctx, keyBeg, keyEnd)
+		page, err := server.ParsePage(r)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		var keyList []string
+		if page.Paged() {
+			first, _ := NewTKey(keyBeg)
+			last, _ := NewTKey(keyEnd)
+			keyList, err = d.getKeysPage(ctx, w, r, first, last, page)
+		} else {
+			keyList, err = d.GetKeysInRange(ctx, keyBeg, keyEnd)
+		}
 		if err != nil {
 			server.BadRequest(w, r, err)
 			return
@@ -494,7 +643,14 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 				server.BadRequest(w, r, err)
 				return
 			}
-			err = d.PutData(ctx, keyStr, data)
+			var ttl time.Duration
+			if ttlStr := r.URL.Query().Get("ttl"); ttlStr != "" {
+				if ttl, err = time.ParseDuration(ttlStr); err != nil || ttl <= 0 {
+					server.BadRequest(w, r, "bad ttl %q: must be a positive duration like \"90s\" or \"24h\"", ttlStr)
+					return
+				}
+			}
+			err = d.PutDataTTL(ctx, keyStr, data, ttl)
 			if err != nil {
 				server.BadRequest(w, r, err)
 				return
@@ -5,13 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
 )
 
 var (
@@ -355,6 +358,67 @@ func TestKeyvalueUnversioned(t *testing.T) {
 	}
 }
 
+func TestKeyvaluePaging(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "pagingtest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	data, ok := dataservice.(*Data)
+	if !ok {
+		t.Fatalf("Returned new data instance is not keyvalue.Data\n")
+	}
+	keys := []string{"a1", "a2", "a3", "b1", "b2"}
+	for _, key := range keys {
+		keyreq := fmt.Sprintf("%snode/%s/%s/key/%s", server.WebAPIPath, uuid, data.DataName(), key)
+		server.TestHTTP(t, "POST", keyreq, strings.NewReader("value of "+key))
+	}
+
+	var retrieved []string
+	keysreq := fmt.Sprintf("%snode/%s/%s/keys?limit=2", server.WebAPIPath, uuid, data.DataName())
+	for keysreq != "" {
+		resp := server.TestHTTPResponse(t, "GET", keysreq, nil)
+		var page []string
+		if err := json.Unmarshal(resp.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Bad keys page unmarshal: %v\n", err)
+		}
+		if len(page) > 2 {
+			t.Fatalf("Expected at most 2 keys per page, got %v\n", page)
+		}
+		retrieved = append(retrieved, page...)
+		keysreq = ""
+		if cursor := resp.Header().Get(server.NextCursorHeader); cursor != "" {
+			keysreq = fmt.Sprintf("%snode/%s/%s/keys?limit=2&cursor=%s", server.WebAPIPath, uuid, data.DataName(), cursor)
+		}
+	}
+	if strings.Join(retrieved, ",") != strings.Join(keys, ",") {
+		t.Errorf("Expected paged keys %v, got %v\n", keys, retrieved)
+	}
+
+	prefixreq := fmt.Sprintf("%snode/%s/%s/keys?prefix=a&offset=1", server.WebAPIPath, uuid, data.DataName())
+	var prefixed []string
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", prefixreq, nil), &prefixed); err != nil {
+		t.Fatalf("Bad prefixed keys unmarshal: %v\n", err)
+	}
+	if strings.Join(prefixed, ",") != "a2,a3" {
+		t.Errorf("Expected keys [a2 a3] with prefix a and offset 1, got %v\n", prefixed)
+	}
+
+	rangereq := fmt.Sprintf("%snode/%s/%s/keyrange/a2/b1?limit=1&cursor=a2", server.WebAPIPath, uuid, data.DataName())
+	resp := server.TestHTTPResponse(t, "GET", rangereq, nil)
+	var ranged []string
+	if err := json.Unmarshal(resp.Body.Bytes(), &ranged); err != nil {
+		t.Fatalf("Bad key range page unmarshal: %v\n", err)
+	}
+	if len(ranged) != 1 || ranged[0] != "a3" || resp.Header().Get(server.NextCursorHeader) != "a3" {
+		t.Errorf("Expected key range page [a3] with next cursor a3, got %v with cursor %q\n", ranged, resp.Header().Get(server.NextCursorHeader))
+	}
+}
+
 func TestKeyvalueVersioning(t *testing.T) {
 	datastore.OpenTest()
 	defer datastore.CloseTest()
@@ -638,9 +702,15 @@ func TestKeyvalueVersioning(t *testing.T) {
 	if err = datastore.RenameData(uuid8, "leafdata", "versiontest", "foobar"); err == nil {
 		t.Fatalf("Should have been prevented from renaming data 'leafdata' to existing data 'versiontest'!\n")
 	}
+	if err = datastore.RenameData(uuid8, "leafdata", "", "foobar"); err == nil {
+		t.Fatalf("Should have been prevented from renaming data 'leafdata' to an empty name!\n")
+	}
 	if err = datastore.RenameData(uuid8, "leafdata", "renamedData", "foobar"); err != nil {
 		t.Fatalf("Error renaming leafdata: %v\n", err)
 	}
+	if _, err = datastore.GetDataByUUIDName(uuid8, "leafdata"); err == nil {
+		t.Errorf("Data 'leafdata' should not be found after rename\n")
+	}
 
 	// Check the values
 	key1req = fmt.Sprintf("%snode/%s/renamedData/key/%s", server.WebAPIPath, uuid8, key1)
@@ -708,3 +778,1019 @@ func TestDiamondGetOnMerge(t *testing.T) {
 		t.Errorf("Error on merged child, key %q: expected %q, got %q\n", key1, value1, string(returnValue))
 	}
 }
+
+func TestMergeStrategies(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "mergetest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	data, ok := dataservice.(*Data)
+	if !ok {
+		t.Fatalf("Returned new data instance is not keyvalue.Data\n")
+	}
+	keyreq := func(uuid dvid.UUID, key string) string {
+		return fmt.Sprintf("%snode/%s/%s/key/%s", server.WebAPIPath, uuid, data.DataName(), key)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid, "key1"), strings.NewReader("root1"))
+	server.TestHTTP(t, "POST", keyreq(uuid, "key2"), strings.NewReader("root2"))
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+
+	// One parent changes key1 and deletes key2 while the other changes key1 and adds key3.
+	uuid2, err := datastore.NewVersion(uuid, "first child", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create 1st child off root %s: %v\n", uuid, err)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid2, "key1"), strings.NewReader("ours"))
+	server.TestHTTP(t, "DELETE", keyreq(uuid2, "key2"), nil)
+	if err = datastore.Commit(uuid2, "first child", nil); err != nil {
+		t.Fatalf("Unable to commit node %s: %v\n", uuid2, err)
+	}
+	uuid3, err := datastore.NewVersion(uuid, "second child", "newbranch", nil)
+	if err != nil {
+		t.Fatalf("Unable to create 2nd child off root %s: %v\n", uuid, err)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid3, "key1"), strings.NewReader("theirs"))
+	server.TestHTTP(t, "POST", keyreq(uuid3, "key3"), strings.NewReader("added"))
+	if err = datastore.Commit(uuid3, "second child", nil); err != nil {
+		t.Fatalf("Unable to commit node %s: %v\n", uuid3, err)
+	}
+
+	parents := []dvid.UUID{uuid2, uuid3}
+	if _, err = datastore.MergeWithStrategy(parents, "conflicted", datastore.MergeConflictError, nil); err == nil {
+		t.Fatalf("Expected error merging parents with conflicting key1\n")
+	}
+	ours, err := datastore.MergeWithStrategy(parents, "ours", datastore.MergeOurs, nil)
+	if err != nil {
+		t.Fatalf("Error doing merge: %v\n", err)
+	}
+	if value := server.TestHTTP(t, "GET", keyreq(ours, "key1"), nil); string(value) != "ours" {
+		t.Errorf("Expected key1 to be %q in merged child, got %q\n", "ours", string(value))
+	}
+	server.TestBadHTTP(t, "GET", keyreq(ours, "key2"), nil)
+	if value := server.TestHTTP(t, "GET", keyreq(ours, "key3"), nil); string(value) != "added" {
+		t.Errorf("Expected key3 to be %q in merged child, got %q\n", "added", string(value))
+	}
+
+	// A per-type merge type overrides the default.
+	mergereq := fmt.Sprintf("%srepo/%s/merge", server.WebAPIPath, uuid)
+	payload := fmt.Sprintf(`{"mergeType": "conflict-error", "typeMergeTypes": {"keyvalue": "theirs"}, "parents": ["%s", "%s"]}`, uuid2, uuid3)
+	resp := struct {
+		Child dvid.UUID `json:"child"`
+	}{}
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", mergereq, strings.NewReader(payload)), &resp); err != nil {
+		t.Fatalf("Unable to parse merge response: %v\n", err)
+	}
+	if value := server.TestHTTP(t, "GET", keyreq(resp.Child, "key1"), nil); string(value) != "theirs" {
+		t.Errorf("Expected key1 to be %q in merged child, got %q\n", "theirs", string(value))
+	}
+	server.TestBadHTTP(t, "GET", keyreq(resp.Child, "key2"), nil)
+}
+
+func TestVersionDiff(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "difftest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := func(uuid dvid.UUID, key string) string {
+		return fmt.Sprintf("%snode/%s/%s/key/%s", server.WebAPIPath, uuid, dataservice.DataName(), key)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid, "modified"), strings.NewReader("old"))
+	server.TestHTTP(t, "POST", keyreq(uuid, "deleted"), strings.NewReader("gone"))
+	server.TestHTTP(t, "POST", keyreq(uuid, "same"), strings.NewReader("same"))
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+	uuid2, err := datastore.NewVersion(uuid, "child", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child off root %s: %v\n", uuid, err)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid2, "modified"), strings.NewReader("newer"))
+	server.TestHTTP(t, "DELETE", keyreq(uuid2, "deleted"), nil)
+	server.TestHTTP(t, "POST", keyreq(uuid2, "same"), strings.NewReader("same"))
+	server.TestHTTP(t, "POST", keyreq(uuid2, "added"), strings.NewReader("new"))
+
+	diffreq := fmt.Sprintf("%srepo/%s/diff?data=%s&from=%s&to=%s&keys=true", server.WebAPIPath, uuid, dataservice.DataName(), uuid, uuid2)
+	var diff datastore.VersionDiff
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", diffreq, nil), &diff); err != nil {
+		t.Fatalf("Unable to parse diff response: %v\n", err)
+	}
+	if diff.Added != 1 || diff.Modified != 1 || diff.Deleted != 1 {
+		t.Errorf("Expected 1 added, modified, and deleted key, got %+v\n", diff)
+	}
+	// Two values were added and two deleted with the same serialization overhead.
+	if diff.BytesAdded-diff.BytesDeleted != 1 {
+		t.Errorf("Expected one more byte added than deleted, got %+v\n", diff)
+	}
+	expected := map[string]string{"added": "added", "modified": "modified", "deleted": "deleted"}
+	if len(diff.Changes) != len(expected) {
+		t.Fatalf("Expected %d changed keys, got %v\n", len(expected), diff.Changes)
+	}
+	for _, change := range diff.Changes {
+		if expected[change.Key] != change.Change {
+			t.Errorf("Unexpected change for key %q: %s\n", change.Key, change.Change)
+		}
+	}
+}
+
+func TestDeleteVersion(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "deleteversiontest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := func(uuid dvid.UUID, key string) string {
+		return fmt.Sprintf("%snode/%s/%s/key/%s", server.WebAPIPath, uuid, dataservice.DataName(), key)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid, "a"), strings.NewReader("root"))
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+	uuid2, err := datastore.NewVersion(uuid, "experiment", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child off root %s: %v\n", uuid, err)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid2, "a"), strings.NewReader("experiment"))
+	server.TestHTTP(t, "POST", keyreq(uuid2, "b"), strings.NewReader("experiment"))
+	if err = datastore.Commit(uuid2, "experiment", nil); err != nil {
+		t.Fatalf("Unable to commit node %s: %v\n", uuid2, err)
+	}
+	uuid3, err := datastore.NewVersion(uuid2, "more experiment", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child off %s: %v\n", uuid2, err)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid3, "c"), strings.NewReader("experiment"))
+	if err = datastore.SetTag(uuid3, "experiment"); err != nil {
+		t.Fatalf("Unable to tag node %s: %v\n", uuid3, err)
+	}
+	v2, _ := datastore.VersionFromUUID(uuid2)
+	v3, _ := datastore.VersionFromUUID(uuid3)
+
+	if err = datastore.DeleteVersion(uuid, true, "foobar"); err == nil {
+		t.Errorf("Expected error deleting root node\n")
+	}
+	if err = datastore.DeleteVersion(uuid2, false, "foobar"); err == nil {
+		t.Errorf("Expected error deleting node with children without its descendants\n")
+	}
+	if err = datastore.DeleteVersion(uuid2, true, "foobar"); err != nil {
+		t.Fatalf("Unable to delete branch at %s: %v\n", uuid2, err)
+	}
+	for _, u := range []dvid.UUID{uuid2, uuid3} {
+		if _, err := datastore.VersionFromUUID(u); err == nil {
+			t.Errorf("Expected deleted node %s to be gone\n", u)
+		}
+	}
+	if _, _, err := datastore.MatchingUUID("experiment"); err == nil {
+		t.Errorf("Expected tag of deleted node to be removed\n")
+	}
+	if value := server.TestHTTP(t, "GET", keyreq(uuid, "a"), nil); string(value) != "root" {
+		t.Errorf("Expected root value to be kept, got %q\n", string(value))
+	}
+
+	// Key-value pairs of the deleted versions are removed asynchronously.
+	store, err := dataservice.KVStore()
+	if err != nil {
+		t.Fatalf("Unable to get store: %v\n", err)
+	}
+	for i := 0; ; i++ {
+		sizes, err := storage.GetVersionSizes(store, dataservice.InstanceID())
+		if err != nil {
+			t.Fatalf("Unable to get version sizes: %v\n", err)
+		}
+		if sizes[v2] == 0 && sizes[v3] == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Key-value pairs of deleted versions not removed: %v\n", sizes)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFlattenRepo(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "flattentest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := func(uuid dvid.UUID, key string) string {
+		return fmt.Sprintf("%snode/%s/%s/key/%s", server.WebAPIPath, uuid, dataservice.DataName(), key)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid, "a"), strings.NewReader("old"))
+	server.TestHTTP(t, "POST", keyreq(uuid, "b"), strings.NewReader("deleted"))
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+	uuid2, err := datastore.NewVersion(uuid, "child", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child off root %s: %v\n", uuid, err)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid2, "a"), strings.NewReader("new"))
+	server.TestHTTP(t, "DELETE", keyreq(uuid2, "b"), nil)
+	server.TestHTTP(t, "POST", keyreq(uuid2, "c"), strings.NewReader("added"))
+
+	if _, err = datastore.FlattenRepo(uuid2, "flat", "flattened child", ""); err == nil {
+		t.Fatalf("Expected error flattening uncommitted node\n")
+	}
+	if err = datastore.Commit(uuid2, "child", nil); err != nil {
+		t.Fatalf("Unable to commit node %s: %v\n", uuid2, err)
+	}
+	root, err := datastore.FlattenRepo(uuid2, "flat", "flattened child", "")
+	if err != nil {
+		t.Fatalf("Unable to flatten node %s: %v\n", uuid2, err)
+	}
+	rootV, err := datastore.VersionFromUUID(root)
+	if err != nil {
+		t.Fatalf("Unable to get version of flattened root %s: %v\n", root, err)
+	}
+	if parents, err := datastore.GetParentsByVersion(rootV); err != nil || len(parents) != 0 {
+		t.Errorf("Expected flattened root %s to have no parents, got %v (%v)\n", root, parents, err)
+	}
+
+	keysreq := fmt.Sprintf("%snode/%s/%s/keys", server.WebAPIPath, root, dataservice.DataName())
+	var keys []string
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", keysreq, nil), &keys); err != nil {
+		t.Fatalf("Bad keys response: %v\n", err)
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+		t.Errorf("Expected keys [a c] in flattened repo, got %v\n", keys)
+	}
+	if value := server.TestHTTP(t, "GET", keyreq(root, "a"), nil); string(value) != "new" {
+		t.Errorf("Expected flattened value %q, got %q\n", "new", string(value))
+	}
+
+	// The flattened instance has no tombstones or overwritten values.
+	flat, err := datastore.GetDataByUUIDName(root, dataservice.DataName())
+	if err != nil {
+		t.Fatalf("Unable to get flattened instance: %v\n", err)
+	}
+	store, err := flat.KVStore()
+	if err != nil {
+		t.Fatalf("Unable to get store: %v\n", err)
+	}
+	sizes, err := storage.GetVersionSizes(store, flat.InstanceID())
+	if err != nil {
+		t.Fatalf("Unable to get version sizes: %v\n", err)
+	}
+	if len(sizes) != 1 || sizes[rootV] == 0 {
+		t.Errorf("Expected key-value pairs only in flattened root, got %v\n", sizes)
+	}
+}
+
+func TestExportImportRepo(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "exporttest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := func(uuid dvid.UUID, key string) string {
+		return fmt.Sprintf("%snode/%s/%s/key/%s", server.WebAPIPath, uuid, dataservice.DataName(), key)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid, "a"), strings.NewReader("root"))
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+	uuid2, err := datastore.NewVersion(uuid, "child", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child off root %s: %v\n", uuid, err)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid2, "a"), strings.NewReader("child"))
+
+	exportreq := fmt.Sprintf("%srepo/%s/export", server.WebAPIPath, uuid)
+	archive := server.TestHTTP(t, "GET", exportreq, nil)
+
+	// The repo can't be imported while its nodes exist.
+	importreq := fmt.Sprintf("%srepos/import", server.WebAPIPath)
+	server.TestBadHTTP(t, "POST", importreq, bytes.NewReader(archive))
+
+	token, err := datastore.RepoDeleteToken(uuid, "foobar")
+	if err != nil {
+		t.Fatalf("Unable to get deletion token: %v\n", err)
+	}
+	if err := datastore.DeleteRepo(uuid, token); err != nil {
+		t.Fatalf("Unable to delete repo %s: %v\n", uuid, err)
+	}
+
+	var imported map[string]dvid.UUID
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", importreq, bytes.NewReader(archive)), &imported); err != nil {
+		t.Fatalf("Bad import response: %v\n", err)
+	}
+	if imported["root"] != uuid {
+		t.Errorf("Expected imported root %s, got %s\n", uuid, imported["root"])
+	}
+	if value := server.TestHTTP(t, "GET", keyreq(uuid, "a"), nil); string(value) != "root" {
+		t.Errorf("Expected imported root value %q, got %q\n", "root", string(value))
+	}
+	if value := server.TestHTTP(t, "GET", keyreq(uuid2, "a"), nil); string(value) != "child" {
+		t.Errorf("Expected imported child value %q, got %q\n", "child", string(value))
+	}
+}
+
+func TestDeleteDataJob(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "deletejobtest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := fmt.Sprintf("%snode/%s/%s/key/a", server.WebAPIPath, uuid, dataservice.DataName())
+	server.TestHTTP(t, "POST", keyreq, strings.NewReader("deleted"))
+
+	job, err := datastore.DeleteData(uuid, dataservice.DataName(), "foobar")
+	if err != nil {
+		t.Fatalf("Unable to delete data: %v\n", err)
+	}
+	if _, err := datastore.GetDataByUUIDName(uuid, dataservice.DataName()); err == nil {
+		t.Errorf("Expected deleted data to be removed from metadata immediately\n")
+	}
+
+	jobreq := fmt.Sprintf("%sserver/jobs/%d", server.WebAPIPath, job)
+	for i := 0; ; i++ {
+		var progress datastore.Job
+		if err := json.Unmarshal(server.TestHTTP(t, "GET", jobreq, nil), &progress); err != nil {
+			t.Fatalf("Bad job response: %v\n", err)
+		}
+		if progress.ID != job || progress.Kind != "delete data" {
+			t.Fatalf("Unexpected job: %+v\n", progress)
+		}
+		if progress.State == datastore.JobFailed {
+			t.Fatalf("Deletion job failed: %s\n", progress.Error)
+		}
+		if progress.State == datastore.JobDone {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Deletion job didn't finish: %+v\n", progress)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	store, err := dataservice.KVStore()
+	if err != nil {
+		t.Fatalf("Unable to get store: %v\n", err)
+	}
+	sizes, err := storage.GetVersionSizes(store, dataservice.InstanceID())
+	if err != nil {
+		t.Fatalf("Unable to get version sizes: %v\n", err)
+	}
+	if len(sizes) != 0 {
+		t.Errorf("Expected no key-value pairs after deletion job, got %v\n", sizes)
+	}
+}
+
+func TestTrashRestoreData(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "trashtest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := fmt.Sprintf("%snode/%s/%s/key/a", server.WebAPIPath, uuid, dataservice.DataName())
+	server.TestHTTP(t, "POST", keyreq, strings.NewReader("kept"))
+
+	if err := datastore.TrashData(uuid, dataservice.DataName(), "foobar"); err != nil {
+		t.Fatalf("Unable to trash data: %v\n", err)
+	}
+	if _, err := datastore.GetDataByUUIDName(uuid, dataservice.DataName()); err == nil {
+		t.Errorf("Expected trashed data to be removed from repo\n")
+	}
+	server.TestBadHTTP(t, "GET", keyreq, nil)
+
+	var trash []datastore.TrashedData
+	trashreq := fmt.Sprintf("%srepo/%s/trash", server.WebAPIPath, uuid)
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", trashreq, nil), &trash); err != nil {
+		t.Fatalf("Bad trash response: %v\n", err)
+	}
+	if len(trash) != 1 || trash[0].Name != dataservice.DataName() || trash[0].DataUUID != dataservice.DataUUID() {
+		t.Fatalf("Unexpected trash: %v\n", trash)
+	}
+
+	// A new instance with the same name blocks the restore.
+	if _, err := datastore.NewData(uuid, kvtype, "trashtest", config); err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	if err := datastore.RestoreData(uuid, dataservice.DataName()); err == nil {
+		t.Errorf("Expected restore to fail when name is in use\n")
+	}
+	if err := datastore.DeleteDataByName(uuid, dataservice.DataName(), "foobar"); err != nil {
+		t.Fatalf("Unable to delete data: %v\n", err)
+	}
+
+	if err := datastore.RestoreData(uuid, dataservice.DataName()); err != nil {
+		t.Fatalf("Unable to restore data: %v\n", err)
+	}
+	if value := server.TestHTTP(t, "GET", keyreq, nil); string(value) != "kept" {
+		t.Errorf("Expected restored value %q, got %q\n", "kept", string(value))
+	}
+	trash, err = datastore.GetTrash(uuid)
+	if err != nil {
+		t.Fatalf("Unable to get trash: %v\n", err)
+	}
+	if len(trash) != 0 {
+		t.Errorf("Expected empty trash after restore, got %v\n", trash)
+	}
+}
+
+func TestInstanceMetadata(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	config.Set("Description", "synapse predictions")
+	config.Set("Tags", "synapses, predicted")
+	if _, err := datastore.NewData(uuid, kvtype, "metatest", config); err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+
+	modreq := fmt.Sprintf("%srepo/%s/instance/metatest", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", modreq, strings.NewReader(`{"Owner": "flyem", "Tags": ["synapses", "v2"]}`))
+	server.TestBadHTTP(t, "POST", fmt.Sprintf("%srepo/%s/instance/nosuchdata", server.WebAPIPath, uuid), strings.NewReader(`{"Owner": "flyem"}`))
+
+	var repo struct {
+		DataInstances map[dvid.InstanceName]struct {
+			Base struct {
+				Description string
+				Owner       string
+				Tags        []string
+				Created     time.Time
+				Modified    time.Time
+			}
+		}
+	}
+	inforeq := fmt.Sprintf("%srepo/%s/info", server.WebAPIPath, uuid)
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", inforeq, nil), &repo); err != nil {
+		t.Fatalf("Bad repo info: %v\n", err)
+	}
+	meta := repo.DataInstances["metatest"].Base
+	if meta.Description != "synapse predictions" || meta.Owner != "flyem" {
+		t.Errorf("Unexpected description %q and owner %q\n", meta.Description, meta.Owner)
+	}
+	if len(meta.Tags) != 2 || meta.Tags[0] != "synapses" || meta.Tags[1] != "v2" {
+		t.Errorf("Unexpected tags: %v\n", meta.Tags)
+	}
+	if meta.Created.IsZero() || meta.Modified.Before(meta.Created) {
+		t.Errorf("Bad creation %s and modification %s times\n", meta.Created, meta.Modified)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "gctest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := fmt.Sprintf("%snode/%s/%s/key/a", server.WebAPIPath, uuid, dataservice.DataName())
+	server.TestHTTP(t, "POST", keyreq, strings.NewReader("root"))
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+	uuid2, err := datastore.NewVersion(uuid, "pruned", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child off root %s: %v\n", uuid, err)
+	}
+	v, _ := datastore.VersionFromUUID(uuid)
+	v2, _ := datastore.VersionFromUUID(uuid2)
+	if err = datastore.DeleteVersion(uuid2, false, "foobar"); err != nil {
+		t.Fatalf("Unable to delete node %s: %v\n", uuid2, err)
+	}
+
+	// Leave a key-value pair in the deleted version as if its deletion was interrupted.
+	store, err := dataservice.KVStore()
+	if err != nil {
+		t.Fatalf("Unable to get store: %v\n", err)
+	}
+	db, ok := store.(storage.OrderedKeyValueDB)
+	if !ok {
+		t.Fatalf("Store %s is not an ordered key-value db\n", store)
+	}
+	tk, err := NewTKey("orphan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // let the version deletion finish.
+	if err := db.RawPut(storage.NewDataContext(dataservice, v2).ConstructKey(tk), []byte("orphan")); err != nil {
+		t.Fatalf("Unable to put orphaned key: %v\n", err)
+	}
+
+	var result struct {
+		Job uint64
+	}
+	gcreq := fmt.Sprintf("%sstorage/gc", server.WebAPIPath)
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", gcreq, nil), &result); err != nil {
+		t.Fatalf("Bad gc response: %v\n", err)
+	}
+	for i := 0; ; i++ {
+		job, err := datastore.GetJob(result.Job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State == datastore.JobFailed {
+			t.Fatalf("Garbage collection failed: %s\n", job.Error)
+		}
+		if job.State == datastore.JobDone {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Garbage collection didn't finish: %+v\n", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sizes, err := storage.GetVersionSizes(store, dataservice.InstanceID())
+	if err != nil {
+		t.Fatalf("Unable to get version sizes: %v\n", err)
+	}
+	if sizes[v2] != 0 {
+		t.Errorf("Expected unreachable version to be collected, got sizes %v\n", sizes)
+	}
+	if sizes[v] == 0 {
+		t.Errorf("Expected reachable root version to be kept, got sizes %v\n", sizes)
+	}
+	if value := server.TestHTTP(t, "GET", keyreq, nil); string(value) != "root" {
+		t.Errorf("Expected root value to be kept, got %q\n", string(value))
+	}
+}
+
+func TestLockedNodeWrites(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "lockedtest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+	uuid2, err := datastore.NewVersion(uuid, "child", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child off root %s: %v\n", uuid, err)
+	}
+	v, _ := datastore.VersionFromUUID(uuid)
+	v2, _ := datastore.VersionFromUUID(uuid2)
+
+	store, err := dataservice.KVStore()
+	if err != nil {
+		t.Fatalf("Unable to get store: %v\n", err)
+	}
+	db, ok := store.(storage.OrderedKeyValueDB)
+	if !ok {
+		t.Fatalf("Store %s is not an ordered key-value db\n", store)
+	}
+	tk, err := NewTKey("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := datastore.NewVersionedCtx(dataservice, v)
+	err = db.Put(ctx, tk, []byte("locked"))
+	if lockedErr, ok := err.(*datastore.LockedNodeError); !ok || lockedErr.UUID != uuid {
+		t.Fatalf("Expected locked node error on put to committed node, got %v\n", err)
+	}
+	if _, ok := db.Delete(ctx, tk).(*datastore.LockedNodeError); !ok {
+		t.Fatalf("Expected locked node error on delete in committed node\n")
+	}
+	if value, err := db.Get(ctx, tk); err != nil || value != nil {
+		t.Fatalf("Expected no value in committed node, got %q: %v\n", value, err)
+	}
+	if err := db.Put(datastore.NewVersionedCtx(dataservice, v2), tk, []byte("child")); err != nil {
+		t.Fatalf("Unable to put to uncommitted child: %v\n", err)
+	}
+
+	datastore.SetLockedWrites(true)
+	defer datastore.SetLockedWrites(false)
+	if err := db.Put(ctx, tk, []byte("override")); err != nil {
+		t.Fatalf("Unable to put to committed node with override: %v\n", err)
+	}
+}
+
+func TestAutoBranch(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "autobranch", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := fmt.Sprintf("%snode/%s/%s/key/a", server.WebAPIPath, uuid, dataservice.DataName())
+	server.TestHTTP(t, "POST", keyreq, strings.NewReader("root"))
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+	server.TestBadHTTP(t, "POST", keyreq, strings.NewReader("refused"))
+
+	settingreq := fmt.Sprintf("%srepo/%s/autobranch", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", settingreq, strings.NewReader(`{"AutoBranch": true}`))
+	if resp := server.TestHTTP(t, "GET", settingreq, nil); string(resp) != `{"AutoBranch": true}` {
+		t.Fatalf("Bad auto-branch setting returned: %s\n", string(resp))
+	}
+
+	// Two writes to the locked node should each get a new child.
+	var children []dvid.UUID
+	for i, value := range []string{"first", "second"} {
+		resp := server.TestHTTPResponse(t, "POST", keyreq, strings.NewReader(value))
+		if resp.Code != 200 {
+			t.Fatalf("Auto-branched write %d failed: %s\n", i, resp.Body.String())
+		}
+		child := dvid.UUID(resp.Header().Get(server.AutoBranchHeader))
+		if child == "" || child == uuid {
+			t.Fatalf("Expected new child UUID in auto-branch header, got %q\n", child)
+		}
+		childreq := fmt.Sprintf("%snode/%s/%s/key/a", server.WebAPIPath, child, dataservice.DataName())
+		if got := server.TestHTTP(t, "GET", childreq, nil); string(got) != value {
+			t.Errorf("Expected %q in auto-branched child %s, got %q\n", value, child, string(got))
+		}
+		children = append(children, child)
+	}
+	if children[0] == children[1] {
+		t.Errorf("Expected different children for writes to locked node, got %s twice\n", children[0])
+	}
+	if got := server.TestHTTP(t, "GET", keyreq, nil); string(got) != "root" {
+		t.Errorf("Expected locked node value to be unchanged, got %q\n", string(got))
+	}
+}
+
+func TestVersionUsage(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "versionusage", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := fmt.Sprintf("%snode/%s/%s/key/a", server.WebAPIPath, uuid, dataservice.DataName())
+	server.TestHTTP(t, "POST", keyreq, strings.NewReader("root"))
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+	uuid2, err := datastore.NewVersion(uuid, "bloated", "proofreading", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child off root %s: %v\n", uuid, err)
+	}
+	for i := 0; i < 10; i++ {
+		keyreq2 := fmt.Sprintf("%snode/%s/%s/key/b%d", server.WebAPIPath, uuid2, dataservice.DataName(), i)
+		server.TestHTTP(t, "POST", keyreq2, strings.NewReader(strings.Repeat("x", 1000)))
+	}
+
+	usagereq := fmt.Sprintf("%srepo/%s/version-usage", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "GET", usagereq, nil)
+	var result struct {
+		Job uint64
+	}
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", usagereq, nil), &result); err != nil {
+		t.Fatalf("Bad version usage response: %v\n", err)
+	}
+	for i := 0; ; i++ {
+		job, err := datastore.GetJob(result.Job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State == datastore.JobFailed {
+			t.Fatalf("Version usage measurement failed: %s\n", job.Error)
+		}
+		if job.State == datastore.JobDone {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Version usage measurement didn't finish: %+v\n", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var report datastore.VersionReport
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", usagereq, nil), &report); err != nil {
+		t.Fatalf("Bad version usage report: %v\n", err)
+	}
+	if len(report.Versions) != 2 {
+		t.Fatalf("Expected 2 versions in report, got %v\n", report.Versions)
+	}
+	bloated, root := report.Versions[0], report.Versions[1]
+	if bloated.UUID != uuid2 || bloated.Branch != "proofreading" || bloated.Locked {
+		t.Errorf("Expected uncommitted child first in report, got %v\n", bloated)
+	}
+	// Values are compressed, so only compare the versions' relative sizes.
+	if root.UUID != uuid || !root.Locked || root.Bytes >= bloated.Bytes {
+		t.Errorf("Bad version usage: %v\n", report.Versions)
+	}
+	if bloated.Instances[dataservice.DataName()] != bloated.Bytes || report.Bytes != bloated.Bytes+root.Bytes {
+		t.Errorf("Bad instance breakdown of version usage: %v\n", report)
+	}
+}
+
+func TestAccessTimes(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "accessed", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	before := time.Now()
+	keyreq := fmt.Sprintf("%snode/%s/%s/key/a", server.WebAPIPath, uuid, dataservice.DataName())
+	server.TestHTTP(t, "POST", keyreq, strings.NewReader("written"))
+	server.TestHTTP(t, "GET", keyreq, nil)
+
+	// Access times are saved with the repo, e.g., on commit.
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+	datastore.CloseReopenTest()
+
+	var info struct {
+		DataAccess map[dvid.InstanceName]struct {
+			LastRead  time.Time
+			LastWrite time.Time
+		}
+		DAG struct {
+			Nodes map[dvid.UUID]struct {
+				LastRead  *time.Time
+				LastWrite *time.Time
+			}
+		}
+	}
+	inforeq := fmt.Sprintf("%srepo/%s/info", server.WebAPIPath, uuid)
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", inforeq, nil), &info); err != nil {
+		t.Fatalf("Bad repo info: %v\n", err)
+	}
+	access, found := info.DataAccess[dataservice.DataName()]
+	if !found || access.LastRead.Before(before) || access.LastWrite.Before(before) {
+		t.Errorf("Bad access times for data %q: %v\n", dataservice.DataName(), info.DataAccess)
+	}
+	node := info.DAG.Nodes[uuid]
+	if node.LastRead == nil || node.LastWrite == nil || node.LastRead.Before(*node.LastWrite) {
+		t.Errorf("Bad access times for node %s: %v, %v\n", uuid, node.LastRead, node.LastWrite)
+	}
+}
+
+func TestRevertNode(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "reverttest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := func(uuid dvid.UUID, key string) string {
+		return fmt.Sprintf("%snode/%s/%s/key/%s", server.WebAPIPath, uuid, dataservice.DataName(), key)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid, "a"), strings.NewReader("root"))
+	server.TestHTTP(t, "POST", keyreq(uuid, "b"), strings.NewReader("root"))
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+	revertreq := fmt.Sprintf("%snode/%s/revert", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "POST", revertreq, strings.NewReader(`{"data": "reverttest"}`))
+
+	uuid2, err := datastore.NewVersion(uuid, "mistakes", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child off root %s: %v\n", uuid, err)
+	}
+	server.TestHTTP(t, "POST", keyreq(uuid2, "a"), strings.NewReader("mistake"))
+	server.TestHTTP(t, "DELETE", keyreq(uuid2, "b"), nil)
+	server.TestHTTP(t, "POST", keyreq(uuid2, "c"), strings.NewReader("mistake"))
+	server.TestBadHTTP(t, "GET", keyreq(uuid2, "b"), nil)
+
+	revertreq = fmt.Sprintf("%snode/%s/revert", server.WebAPIPath, uuid2)
+	server.TestBadHTTP(t, "POST", revertreq, strings.NewReader(`{"data": "nonexistent"}`))
+	var resp struct {
+		Job uint64
+	}
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", revertreq, strings.NewReader(`{"data": "reverttest"}`)), &resp); err != nil {
+		t.Fatalf("Bad revert response: %v\n", err)
+	}
+	for i := 0; ; i++ {
+		job, err := datastore.GetJob(resp.Job)
+		if err != nil {
+			t.Fatalf("Unable to get revert job %d: %v\n", resp.Job, err)
+		}
+		if job.State == datastore.JobFailed {
+			t.Fatalf("Revert job failed: %s\n", job.Error)
+		}
+		if job.State == datastore.JobDone {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Revert job didn't finish: %+v\n", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if value := server.TestHTTP(t, "GET", keyreq(uuid2, key), nil); string(value) != "root" {
+			t.Errorf("Expected reverted key %q to have root value, got %q\n", key, string(value))
+		}
+	}
+	server.TestBadHTTP(t, "GET", keyreq(uuid2, "c"), nil)
+
+	log, err := datastore.GetNodeLog(uuid2)
+	if err != nil {
+		t.Fatalf("Unable to get log of node %s: %v\n", uuid2, err)
+	}
+	if len(log) == 0 || !strings.HasSuffix(log[len(log)-1], `Reverted changes to data "reverttest"`) {
+		t.Errorf("Expected revert in node log, got %v\n", log)
+	}
+}
+
+func TestUnlockNode(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "unlocktest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := fmt.Sprintf("%snode/%s/%s/key/a", server.WebAPIPath, uuid, dataservice.DataName())
+	server.TestHTTP(t, "POST", keyreq, strings.NewReader("premature"))
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+	server.TestBadHTTP(t, "POST", keyreq, strings.NewReader("fixed"))
+
+	unlockreq := fmt.Sprintf("%snode/%s/unlock", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "POST", unlockreq, strings.NewReader(`{"user": "jdoe"}`))
+	server.TestHTTP(t, "POST", unlockreq, strings.NewReader(`{"reason": "committed too soon", "user": "jdoe", "passcode": "foobar"}`))
+	server.TestBadHTTP(t, "POST", unlockreq, strings.NewReader(`{"reason": "already open", "passcode": "foobar"}`))
+
+	if locked, err := datastore.LockedUUID(uuid); err != nil || locked {
+		t.Fatalf("Expected node %s to be unlocked, got %t: %v\n", uuid, locked, err)
+	}
+	if info, err := datastore.GetCommitInfo(uuid); err != nil || info != nil {
+		t.Errorf("Expected no commit info for unlocked node, got %v: %v\n", info, err)
+	}
+	server.TestHTTP(t, "POST", keyreq, strings.NewReader("fixed"))
+	if value := server.TestHTTP(t, "GET", keyreq, nil); string(value) != "fixed" {
+		t.Errorf("Expected fixed value in unlocked node, got %q\n", string(value))
+	}
+	log, err := datastore.GetNodeLog(uuid)
+	if err != nil {
+		t.Fatalf("Unable to get log of node %s: %v\n", uuid, err)
+	}
+	if len(log) == 0 || !strings.HasSuffix(log[len(log)-1], "unlocked by jdoe: committed too soon") {
+		t.Errorf("Expected unlock in node log, got %v\n", log)
+	}
+
+	// Nodes with children can't be unlocked.
+	if err = datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root node %s: %v\n", uuid, err)
+	}
+	if _, err = datastore.NewVersion(uuid, "child", "", nil); err != nil {
+		t.Fatalf("Unable to create child off root %s: %v\n", uuid, err)
+	}
+	if err = datastore.Unlock(uuid, "too late", "", "foobar"); err == nil {
+		t.Errorf("Expected error unlocking node with children\n")
+	}
+}
+
+func TestRepoProperties(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	dataservice, err := datastore.NewData(uuid, kvtype, "propertytest", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	keyreq := fmt.Sprintf("%snode/%s/%s/key/a", server.WebAPIPath, uuid, dataservice.DataName())
+	propreq := fmt.Sprintf("%srepo/%s/properties", server.WebAPIPath, uuid)
+
+	server.TestBadHTTP(t, "POST", propreq, strings.NewReader(`{"read-only": "yes"}`))
+	server.TestBadHTTP(t, "POST", propreq, strings.NewReader(`{"storage-quota": -1, "project": "ignored"}`))
+	server.TestHTTP(t, "POST", propreq, strings.NewReader(`{"read-only": true, "project": "hemibrain", "storage-quota": 1000000}`))
+
+	var props map[string]interface{}
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", propreq, nil), &props); err != nil {
+		t.Fatalf("Bad properties response: %v\n", err)
+	}
+	if props["read-only"] != true || props["project"] != "hemibrain" || props["storage-quota"] != float64(1000000) {
+		t.Errorf("Bad repo properties: %v\n", props)
+	}
+	if quota, _, err := datastore.GetRepoQuota(uuid); err != nil || quota != 1000000 {
+		t.Errorf("Expected quota set through properties, got %d: %v\n", quota, err)
+	}
+	var info struct {
+		Properties map[string]interface{}
+	}
+	inforeq := fmt.Sprintf("%srepo/%s/info", server.WebAPIPath, uuid)
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", inforeq, nil), &info); err != nil {
+		t.Fatalf("Bad repo info: %v\n", err)
+	}
+	if info.Properties["project"] != "hemibrain" {
+		t.Errorf("Expected properties in repo info, got %v\n", info.Properties)
+	}
+
+	server.TestBadHTTP(t, "POST", keyreq, strings.NewReader("refused"))
+	server.TestHTTP(t, "POST", propreq, strings.NewReader(`{"read-only": null}`))
+	server.TestHTTP(t, "POST", keyreq, strings.NewReader("written"))
+	if value, err := datastore.GetRepoProperty(uuid, "read-only"); err != nil || value != nil {
+		t.Errorf("Expected read-only setting removed, got %v: %v\n", value, err)
+	}
+}
+
+func TestAtomicBatch(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	if _, err := datastore.NewData(uuid, kvtype, "atomickv", dvid.NewConfig()); err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	server.SetAuditing(true)
+	defer server.SetAuditing(false)
+
+	keyPath := fmt.Sprintf("/api/node/%s/atomickv/key/", uuid)
+	batch := fmt.Sprintf(`[
+		{"method": "POST", "path": %q, "body": "1"},
+		{"method": "POST", "path": %q, "body": "2"},
+		{"method": "POST", "path": "/api/node/%s/nosuchdata/key/c", "body": "3"}
+	]`, keyPath+"a", keyPath+"b", uuid)
+	resp := server.TestHTTPResponse(t, "POST", server.WebAPIPath+"batch?atomic=true", strings.NewReader(batch))
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for failed atomic batch, got %d: %s\n", resp.Code, resp.Body)
+	}
+	server.TestBadHTTP(t, "GET", server.WebAPIPath+keyPath[len("/api/"):]+"a", nil)
+
+	records, err := datastore.QueryAudit(datastore.AuditQuery{Instance: "atomickv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected audit records of 2 rolled back writes, got %v\n", records)
+	}
+	for _, rec := range records {
+		if rec.Status != http.StatusBadRequest || !strings.HasPrefix(rec.Error, "rolled back") {
+			t.Errorf("Expected rolled back audit record, got %v\n", rec)
+		}
+	}
+
+	batch = fmt.Sprintf(`[
+		{"method": "POST", "path": %q, "body": "1"},
+		{"method": "POST", "path": %q, "body": "2"}
+	]`, keyPath+"a", keyPath+"b")
+	server.TestHTTP(t, "POST", server.WebAPIPath+"batch?atomic=true", strings.NewReader(batch))
+	if value := server.TestHTTP(t, "GET", server.WebAPIPath+keyPath[len("/api/"):]+"b", nil); string(value) != "2" {
+		t.Errorf("Expected committed value %q, got %q\n", "2", value)
+	}
+}
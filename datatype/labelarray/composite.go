@@ -142,7 +142,7 @@ func (d *Data) createCompositeChunk(chunk *storage.Chunk) {
 	}
 	curZMutex.Unlock()
 
-	deserialization, _, err := dvid.DeserializeData(chunk.V, true)
+	deserialization, _, err := d.DeserializeValue(op.versionID, chunk.K, chunk.V, true)
 	if err != nil {
 		dvid.Errorf("Unable to deserialize block in %q: %v\n", d.DataName(), err)
 		return
@@ -171,7 +171,7 @@ func (d *Data) createCompositeChunk(chunk *storage.Chunk) {
 		dvid.Errorf("Error getting grayscale block for index %s\n", zyx)
 		return
 	}
-	grayscaleData, _, err := dvid.DeserializeData(blockData, true)
+	grayscaleData, _, err := op.grayscale.DeserializeValue(op.versionID, chunk.K, blockData, true)
 	if err != nil {
 		dvid.Errorf("Unable to deserialize block in '%s': %v\n", op.grayscale.DataName(), err)
 		return
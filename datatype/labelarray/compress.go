@@ -0,0 +1,76 @@
+/*
+	This file supports training of zstd dictionaries from stored label blocks.
+*/
+
+package labelarray
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// DefaultDictSamples is the default number of label blocks sampled for dictionary training.
+const DefaultDictSamples = 1000
+
+var errEnoughSamples = errors.New("enough samples")
+
+// trainCompressionDict samples stored label blocks, trains a zstd dictionary, and switches
+// the data instance to zstd compression with that dictionary.  Previously stored blocks
+// are not recompressed.
+func (d *Data) trainCompressionDict(uuid dvid.UUID, ctx *datastore.VersionedCtx, numSamples, maxSize int, level dvid.CompressionLevel) (uint32, error) {
+	if numSamples <= 0 {
+		numSamples = DefaultDictSamples
+	}
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return 0, err
+	}
+
+	timedLog := dvid.NewTimeLog()
+	var samples [][]byte
+	begTKey := storage.MinTKey(keyLabelBlock)
+	endTKey := storage.MaxTKey(keyLabelBlock)
+	err = store.ProcessRange(ctx, begTKey, endTKey, &storage.ChunkOp{}, func(c *storage.Chunk) error {
+		if c == nil || c.TKeyValue == nil || c.V == nil {
+			return nil
+		}
+		block, _, err := d.DeserializeValue(ctx.VersionID(), c.K, c.V, true)
+		if err != nil {
+			return fmt.Errorf("unable to deserialize block in %q: %v", d.DataName(), err)
+		}
+		samples = append(samples, block)
+		if len(samples) >= numSamples {
+			return errEnoughSamples
+		}
+		return nil
+	})
+	if err != nil && err != errEnoughSamples {
+		return 0, err
+	}
+	if len(samples) == 0 {
+		return 0, fmt.Errorf("no blocks stored in %q to train a compression dictionary", d.DataName())
+	}
+
+	dict, err := dvid.TrainZstdDict(samples, maxSize)
+	if err != nil {
+		return 0, err
+	}
+	id, err := datastore.SaveZstdDict(dict)
+	if err != nil {
+		return 0, err
+	}
+	compression, err := dvid.NewZstdCompression(level, id)
+	if err != nil {
+		return 0, err
+	}
+	d.SetCompression(compression)
+	if err := datastore.SaveDataByUUID(uuid, d); err != nil {
+		return 0, err
+	}
+	timedLog.Infof("Trained %d byte zstd dictionary %d for %q from %d blocks", len(dict), id, d.DataName(), len(samples))
+	return id, nil
+}
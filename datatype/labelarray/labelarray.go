@@ -6,6 +6,7 @@ package labelarray
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/binary"
 	"encoding/gob"
@@ -187,6 +188,22 @@ POST <api URL>/node/<UUID>/<data name>/sync?<options>
 			   Default operation is false.
 
 
+POST <api URL>/node/<UUID>/<data name>/compression-dict?<options>
+
+    Trains a zstd dictionary from stored label blocks and switches the data instance to
+    zstd compression using that dictionary.  Label blocks compress much better with a
+    shared dictionary.  Only newly written blocks use the dictionary; existing blocks
+    remain readable in their original format.  Returns JSON with the dictionary ID:
+
+    { "Dict": 1393859212 }
+
+    Query-string Options:
+
+    samples       Number of blocks to sample.  Default is 1000.
+    size          Maximum size of the dictionary in bytes.  Default is 112640.
+    level         Zstd compression level from 1 to 22.  Default is zstd default.
+
+
 GET  <api URL>/node/<UUID>/<data name>/metadata
 
 	Retrieves a JSON schema (application/vnd.dvid-nd-data+json) that describes the layout
@@ -292,7 +309,7 @@ GET  <api URL>/node/<UUID>/<data name>/isotropic/<dims>/<size>/<offset>[/<format
                     the image-based codec.
     throttle      Only works for 3d data requests.  If "true", makes sure only N compute-intense operation 
     				(all API calls that can be throttled) are handled.  If the server can't initiate the API 
-    				call right away, a 503 (Service Unavailable) status code is returned.
+    				call right away, it waits its turn by request priority.
 
 
 GET  <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>][?queryopts]
@@ -340,7 +357,7 @@ GET  <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>][?qu
                     the image-based codec.
     throttle      Only works for 3d data requests.  If "true", makes sure only N compute-intense operation 
     				(all API calls that can be throttled) are handled.  If the server can't initiate the API 
-    				call right away, a 503 (Service Unavailable) status code is returned.
+    				call right away, it waits its turn by request priority.
 
 
 POST <api URL>/node/<UUID>/<data name>/raw/0_1_2/<size>/<offset>[?queryopts]
@@ -369,8 +386,8 @@ POST <api URL>/node/<UUID>/<data name>/raw/0_1_2/<size>/<offset>[?queryopts]
     compression   Allows retrieval or submission of 3d data in "lz4" and "gzip"
                     compressed format.
     throttle      If "true", makes sure only N compute-intense operation (all API calls that can be throttled) 
-                    are handled.  If the server can't initiate the API call right away, a 503 (Service Unavailable) 
-                    status code is returned.
+                    are handled.  If the server can't initiate the API call right away, it waits its turn by 
+                    request priority.
 
 GET  <api URL>/node/<UUID>/<data name>/pseudocolor/<dims>/<size>/<offset>[?queryopts]
 
@@ -398,8 +415,8 @@ GET  <api URL>/node/<UUID>/<data name>/pseudocolor/<dims>/<size>/<offset>[?query
     compression   Allows retrieval or submission of 3d data in "lz4" and "gzip"
                     compressed format.
     throttle      If "true", makes sure only N compute-intense operation (all API calls that can be throttled) 
-                    are handled.  If the server can't initiate the API call right away, a 503 (Service Unavailable) 
-                    status code is returned.
+                    are handled.  If the server can't initiate the API call right away, it waits its turn by 
+                    request priority.
 
 GET <api URL>/node/<UUID>/<data name>/label/<coord>
 
@@ -487,8 +504,8 @@ GET <api URL>/node/<UUID>/<data name>/blocks/<size>/<offset>[?queryopts]
     compression   Allows retrieval of block data in "lz4" (default), "gzip", blocks" (native DVID
 	              label blocks) or "uncompressed" (uint64 labels).
     throttle      If "true", makes sure only N compute-intense operation (all API calls that can be 
-	              throttled) are handled.  If the server can't initiate the API call right away, a 503 
-                  (Service Unavailable) status code is returned.
+	              throttled) are handled.  If the server can't initiate the API call right away, it waits 
+                  its turn by request priority.
 
 
 POST <api URL>/node/<UUID>/<data name>/blocks[?queryopts]
@@ -568,8 +585,8 @@ POST <api URL>/node/<UUID>/<data name>/blocks[?queryopts]
     compression   Specifies compression format of block data: default and only option currently is
                     "blocks" (native DVID label blocks).
     throttle      If "true", makes sure only N compute-intense operation (all API calls that can be 
-	                throttled) are handled.  If the server can't initiate the API call right away, a 503 
-                    (Service Unavailable) status code is returned.
+	                throttled) are handled.  If the server can't initiate the API call right away, it waits 
+                    its turn by request priority.
 
 
 GET <api URL>/node/<UUID>/<data name>/maxlabel
@@ -1361,7 +1378,7 @@ func (d *Data) LoadMutable(root dvid.VersionID, storedVersion, expectedVersion u
 		return false, err
 	}
 	keysOnly := false
-	if err = store.RawRangeQuery(minKey, maxKey, keysOnly, ch, nil); err != nil {
+	if err = store.RawRangeQuery(context.Background(), minKey, maxKey, keysOnly, ch); err != nil {
 		return false, err
 	}
 	wg.Wait()
@@ -1689,7 +1706,7 @@ func (d *Data) sendBlocksSpecific(ctx *datastore.VersionedCtx, w http.ResponseWr
 				// lock shared resource
 				mutex.Lock()
 				defer mutex.Unlock()
-				d.SendBlockSimple(w, xloc, yloc, zloc, value, "")
+				err = d.SendBlockSimple(w, ctx.VersionID(), keyBeg, xloc, yloc, zloc, value, "")
 			}
 		}(int32(xloc), int32(yloc), int32(zloc), finishedRequests, store)
 	}
@@ -1719,9 +1736,9 @@ func (d *Data) getLabelBlock(ctx *datastore.VersionedCtx, scale uint8, bcoord dv
 	if val == nil {
 		return nil, nil
 	}
-	data, _, err := dvid.DeserializeData(val, true)
+	data, _, err := d.DeserializeValue(ctx.VersionID(), tk, val, true)
 	if err != nil {
-		return nil, fmt.Errorf("unable to deserialize label block in %q: %v\n", d.DataName(), err)
+		return nil, err
 	}
 	var block labels.Block
 	if err := block.UnmarshalBinary(data); err != nil {
@@ -1769,7 +1786,7 @@ func (d *Data) sendBlock(w http.ResponseWriter, x, y, z int32, v []byte, compres
 		if len(out) != int(outsize) {
 			return fmt.Errorf("block (%d,%d,%d) was corrupted lz4: supposed size %d but had %d bytes", x, y, z, outsize, len(out))
 		}
-	case dvid.Uncompressed, dvid.Gzip:
+	case dvid.Uncompressed, dvid.Gzip, dvid.Zstd:
 		outsize = uint32(len(v[start:]))
 		out = v[start:]
 	default:
@@ -1782,6 +1799,10 @@ func (d *Data) sendBlock(w http.ResponseWriter, x, y, z int32, v []byte, compres
 		formatOut = dvid.LZ4
 	case "blocks":
 		formatOut = formatIn
+		if formatIn == dvid.Zstd {
+			// clients don't have the server's zstd dictionaries, so send lz4 instead.
+			formatOut = dvid.LZ4
+		}
 	case "gzip":
 		formatOut = dvid.Gzip
 	case "uncompressed":
@@ -1813,6 +1834,10 @@ func (d *Data) sendBlock(w http.ResponseWriter, x, y, z int32, v []byte, compres
 				return err
 			}
 			zr.Close()
+		case dvid.Zstd:
+			if uncompressed, err = dvid.DecompressZstd(out); err != nil {
+				return err
+			}
 		}
 
 		var block labels.Block
@@ -1935,6 +1960,9 @@ func (d *Data) SendBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter, sc
 				if z != sz || y != sy || x < sx || x >= sx+int32(blocksdims.Value(0)) {
 					return nil
 				}
+				if err := d.VerifyValue(ctx.VersionID(), kv.K, kv.V); err != nil {
+					return err
+				}
 				if err := d.sendBlock(w, x, y, z, kv.V, compression); err != nil {
 					return err
 				}
@@ -2604,6 +2632,7 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			return
 		}
 		if action == "get" {
+			server.NoCompression(r) // blocks are sent as stored
 			if err := d.sendBlocksSpecific(ctx, w, blocklist, scale); err != nil {
 				server.BadRequest(w, r, err)
 				return
@@ -2615,6 +2644,43 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			return
 		}
 
+	case "compression-dict":
+		if action != "post" {
+			server.BadRequest(w, r, "Only POST allowed to compression-dict endpoint")
+			return
+		}
+		queryStrings := r.URL.Query()
+		var samples, size int
+		var err error
+		level := dvid.CompressionLevel(dvid.DefaultCompression)
+		if s := queryStrings.Get("samples"); s != "" {
+			if samples, err = strconv.Atoi(s); err != nil {
+				server.BadRequest(w, r, "bad samples %q: %v", s, err)
+				return
+			}
+		}
+		if s := queryStrings.Get("size"); s != "" {
+			if size, err = strconv.Atoi(s); err != nil {
+				server.BadRequest(w, r, "bad size %q: %v", s, err)
+				return
+			}
+		}
+		if s := queryStrings.Get("level"); s != "" {
+			l, err := strconv.Atoi(s)
+			if err != nil {
+				server.BadRequest(w, r, "bad level %q: %v", s, err)
+				return
+			}
+			level = dvid.CompressionLevel(l)
+		}
+		id, err := d.trainCompressionDict(uuid, ctx, samples, size, level)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Dict": %d}`, id)
+
 	case "sync":
 		if action != "post" {
 			server.BadRequest(w, r, "Only POST allowed to sync endpoint")
@@ -2753,7 +2819,7 @@ func (d *Data) handleBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter,
 
 	queryStrings := r.URL.Query()
 	if throttle := queryStrings.Get("throttle"); throttle == "on" || throttle == "true" {
-		if server.ThrottledHTTP(w) {
+		if server.ThrottledHTTP(w, r) {
 			return
 		}
 		defer server.ThrottledOpDone()
@@ -2788,6 +2854,9 @@ func (d *Data) handleBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter,
 			return
 		}
 
+		if compression != "uncompressed" {
+			server.NoCompression(r) // blocks are gzipped by default
+		}
 		if err := d.SendBlocks(ctx, w, scale, subvol, compression); err != nil {
 			server.BadRequest(w, r, err)
 		}
@@ -2935,7 +3004,7 @@ func (d *Data) handleDataRequest(ctx *datastore.VersionedCtx, w http.ResponseWri
 		}
 	case 3:
 		if throttle := queryStrings.Get("throttle"); throttle == "on" || throttle == "true" {
-			if server.ThrottledHTTP(w) {
+			if server.ThrottledHTTP(w, r) {
 				return
 			}
 			defer server.ThrottledOpDone()
@@ -2957,6 +3026,9 @@ func (d *Data) handleDataRequest(ctx *datastore.VersionedCtx, w http.ResponseWri
 				server.BadRequest(w, r, err)
 				return
 			}
+			if compression != "" {
+				server.NoCompression(r)
+			}
 			if err := sendBinaryData(compression, data, subvol, w); err != nil {
 				server.BadRequest(w, r, err)
 				return
@@ -3398,7 +3470,8 @@ func (d *Data) GetLabelBlock(v dvid.VersionID, scale uint8, bcoord dvid.ChunkPoi
 	// Retrieve the block of labels
 	ctx := datastore.NewVersionedCtx(d, v)
 	index := dvid.IndexZYX(bcoord)
-	serialization, err := store.Get(ctx, NewBlockTKey(scale, &index))
+	tk := NewBlockTKey(scale, &index)
+	serialization, err := store.Get(ctx, tk)
 	if err != nil {
 		return nil, fmt.Errorf("Error getting '%s' block for index %s\n", d.DataName(), bcoord)
 	}
@@ -3409,7 +3482,7 @@ func (d *Data) GetLabelBlock(v dvid.VersionID, scale uint8, bcoord dvid.ChunkPoi
 		}
 		return labels.MakeSolidBlock(0, blockSize), nil
 	}
-	deserialization, _, err := dvid.DeserializeData(serialization, true)
+	deserialization, _, err := d.DeserializeValue(v, tk, serialization, true)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to deserialize block %s in '%s': %v\n", bcoord, d.DataName(), err)
 	}
@@ -3430,14 +3503,15 @@ func (d *Data) GetLabelBytes(v dvid.VersionID, bcoord dvid.ChunkPoint3d) ([]byte
 	// Retrieve the block of labels
 	ctx := datastore.NewVersionedCtx(d, v)
 	index := dvid.IndexZYX(bcoord)
-	serialization, err := store.Get(ctx, NewBlockTKey(0, &index))
+	tk := NewBlockTKey(0, &index)
+	serialization, err := store.Get(ctx, tk)
 	if err != nil {
 		return nil, fmt.Errorf("Error getting '%s' block for index %s\n", d.DataName(), bcoord)
 	}
 	if serialization == nil {
 		return []byte{}, nil
 	}
-	deserialization, _, err := dvid.DeserializeData(serialization, true)
+	deserialization, _, err := d.DeserializeValue(v, tk, serialization, true)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to deserialize block %s in '%s': %v\n", bcoord, d.DataName(), err)
 	}
@@ -12,6 +12,7 @@ import (
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/datatype/common/labels"
 	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
 
 	lz4 "github.com/janelia-flyem/go/golz4"
 )
@@ -338,8 +339,10 @@ func (d *Data) indexLabels(ch <-chan labelChange) {
 }
 
 type labelBlock struct {
-	index dvid.IZYXString
-	data  []byte
+	version dvid.VersionID
+	scale   uint8
+	index   dvid.IZYXString
+	data    []byte
 }
 
 type rleResult struct {
@@ -355,7 +358,7 @@ func (d *Data) processBlocksToRLEs(lbls labels.Set, bounds dvid.Bounds, in chan
 			return
 		}
 		var result rleResult
-		data, _, err := dvid.DeserializeData(lb.data, true)
+		data, _, err := d.DeserializeValue(lb.version, NewBlockTKeyByCoord(lb.scale, lb.index), lb.data, true)
 		if err != nil {
 			dvid.Errorf("could not deserialize %d bytes in block %s: %v\n", len(lb.data), lb.index, err)
 			out <- result
@@ -553,13 +556,18 @@ func (d *Data) FoundSparseVol(ctx *datastore.VersionedCtx, label uint64, bounds
 		return false, err
 	}
 	for label := range constituents {
-		val, err := store.Get(ctx, NewLabelIndexTKey(label))
+		tk := NewLabelIndexTKey(label)
+		compressed, err := store.Get(ctx, tk)
 		if err != nil {
 			return false, err
 		}
-		if len(val) == 0 {
+		if len(compressed) == 0 {
 			continue
 		}
+		val, _, err := d.DeserializeValue(ctx.VersionID(), tk, compressed, true)
+		if err != nil {
+			return false, err
+		}
 		// Check bounds if one was supplied.
 		var meta Meta
 		if err := meta.UnmarshalBinary(val); err != nil {
@@ -618,15 +626,19 @@ func (d *Data) getLabelMeta(ctx *datastore.VersionedCtx, lbls labels.Set, scale
 	// dvid.Infof("getLabelMeta for labels %s...\n", lbls)
 	var voxels uint64
 	var blocks dvid.IZYXSlice
+	tks := make([]storage.TKey, 0, len(lbls))
 	for label := range lbls {
-		compressed, err := store.Get(ctx, NewLabelIndexTKey(label))
-		if err != nil {
-			return nil, err
-		}
+		tks = append(tks, NewLabelIndexTKey(label))
+	}
+	values, err := storage.GetBatch(store, ctx, tks)
+	if err != nil {
+		return nil, err
+	}
+	for i, compressed := range values {
 		if len(compressed) == 0 {
 			continue
 		}
-		val, _, err := dvid.DeserializeData(compressed, true)
+		val, _, err := d.DeserializeValue(ctx.VersionID(), tks[i], compressed, true)
 		if err != nil {
 			return nil, err
 		}
@@ -675,10 +687,9 @@ func (d *Data) PutLabelMeta(ctx *datastore.VersionedCtx, label uint64, meta *Met
 	if err != nil {
 		return fmt.Errorf("Error trying to serialize meta for label %d, data %q: %v", label, d.DataName(), err)
 	}
-	compressFormat, _ := dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
-	compressed, err := dvid.SerializeData(serialization, compressFormat, dvid.NoChecksum)
+	compressed, err := dvid.SerializeData(serialization, d.Compression(), d.Checksum())
 	if err != nil {
-		return fmt.Errorf("Error trying to LZ4 compress label %d indexing in data %q\n", label, d.DataName())
+		return fmt.Errorf("Error trying to compress label %d indexing in data %q: %v\n", label, d.DataName(), err)
 	}
 	if err := store.Put(ctx, tk, compressed); err != nil {
 		return fmt.Errorf("Unable to store indices for label %d, data %s: %v\n", label, d.DataName(), err)
@@ -686,6 +697,36 @@ func (d *Data) PutLabelMeta(ctx *datastore.VersionedCtx, label uint64, meta *Met
 	return nil
 }
 
+// blockBatchSize is the number of label blocks requested from the store at a time when
+// streaming sparse volumes.
+const blockBatchSize = 64
+
+// getBlocks calls f with the stored data for each block index in order, fetching blocks
+// from the store in batches.  The data is nil for blocks that aren't stored.
+func getBlocks(ctx *datastore.VersionedCtx, store storage.KeyValueGetter, scale uint8, indices dvid.IZYXSlice, f func(dvid.IZYXString, []byte) error) error {
+	tks := make([]storage.TKey, 0, blockBatchSize)
+	for begin := 0; begin < len(indices); begin += blockBatchSize {
+		end := begin + blockBatchSize
+		if end > len(indices) {
+			end = len(indices)
+		}
+		tks = tks[:0]
+		for _, izyx := range indices[begin:end] {
+			tks = append(tks, NewBlockTKeyByCoord(scale, izyx))
+		}
+		values, err := storage.GetBatch(store, ctx, tks)
+		if err != nil {
+			return err
+		}
+		for i, data := range values {
+			if err := f(indices[begin+i], data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // WriteBinaryBlocks does a streaming write of an encoded sparse volume given a label.
 // It returns a bool whether the label was found in the given bounds and any error.
 func (d *Data) WriteBinaryBlocks(ctx *datastore.VersionedCtx, label uint64, scale uint8, bounds dvid.Bounds, compression string, w io.Writer) (bool, error) {
@@ -708,19 +749,14 @@ func (d *Data) WriteBinaryBlocks(ctx *datastore.VersionedCtx, label uint64, scal
 	}
 	op := labels.NewOutputOp(w)
 	go labels.WriteBinaryBlocks(label, lbls, op, bounds)
-	for _, izyx := range indices {
-		tk := NewBlockTKeyByCoord(scale, izyx)
-		data, err := store.Get(ctx, tk)
+	err = getBlocks(ctx, store, scale, indices, func(izyx dvid.IZYXString, data []byte) error {
+		blockData, _, err := d.DeserializeValue(ctx.VersionID(), NewBlockTKeyByCoord(scale, izyx), data, true)
 		if err != nil {
-			return false, err
-		}
-		blockData, _, err := dvid.DeserializeData(data, true)
-		if err != nil {
-			return false, err
+			return err
 		}
 		var block labels.Block
 		if err := block.UnmarshalBinary(blockData); err != nil {
-			return false, err
+			return err
 		}
 		pb := labels.PositionedBlock{
 			Block:  block,
@@ -728,6 +764,10 @@ func (d *Data) WriteBinaryBlocks(ctx *datastore.VersionedCtx, label uint64, scal
 		}
 		dvid.Infof("Read from database: block %s\n", izyx)
 		op.Process(&pb)
+		return nil
+	})
+	if err != nil {
+		return false, err
 	}
 	if err = op.Finish(); err != nil {
 		return false, err
@@ -759,25 +799,24 @@ func (d *Data) WriteStreamingRLE(ctx *datastore.VersionedCtx, label uint64, scal
 	}
 	op := labels.NewOutputOp(w)
 	go labels.WriteRLEs(lbls, op, bounds)
-	for _, izyx := range indices {
-		tk := NewBlockTKeyByCoord(scale, izyx)
-		data, err := store.Get(ctx, tk)
+	err = getBlocks(ctx, store, scale, indices, func(izyx dvid.IZYXString, data []byte) error {
+		blockData, _, err := d.DeserializeValue(ctx.VersionID(), NewBlockTKeyByCoord(scale, izyx), data, true)
 		if err != nil {
-			return false, err
-		}
-		blockData, _, err := dvid.DeserializeData(data, true)
-		if err != nil {
-			return false, err
+			return err
 		}
 		var block labels.Block
 		if err := block.UnmarshalBinary(blockData); err != nil {
-			return false, err
+			return err
 		}
 		pb := labels.PositionedBlock{
 			Block:  block,
 			BCoord: izyx,
 		}
 		op.Process(&pb)
+		return nil
+	})
+	if err != nil {
+		return false, err
 	}
 	if err = op.Finish(); err != nil {
 		return false, err
@@ -873,12 +912,7 @@ func (d *Data) getLegacyRLEs(ctx *datastore.VersionedCtx, meta *Meta, lbls label
 	op := labels.NewOutputOp(buf)
 	go labels.WriteRLEs(lbls, op, bounds)
 	var numEmpty int
-	for _, izyx := range indices {
-		tk := NewBlockTKeyByCoord(scale, izyx)
-		data, err := store.Get(ctx, tk)
-		if err != nil {
-			return nil, err
-		}
+	err = getBlocks(ctx, store, scale, indices, func(izyx dvid.IZYXString, data []byte) error {
 		if len(data) == 0 {
 			numEmpty++
 			if numEmpty < 10 {
@@ -886,21 +920,25 @@ func (d *Data) getLegacyRLEs(ctx *datastore.VersionedCtx, meta *Meta, lbls label
 			} else if numEmpty == 10 {
 				dvid.Errorf("Over %d blocks included in indices with no data.  Halting error stream.\n", numEmpty)
 			}
-			continue
+			return nil
 		}
-		blockData, _, err := dvid.DeserializeData(data, true)
+		blockData, _, err := d.DeserializeValue(ctx.VersionID(), NewBlockTKeyByCoord(scale, izyx), data, true)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		var block labels.Block
 		if err := block.UnmarshalBinary(blockData); err != nil {
-			return nil, err
+			return err
 		}
 		pb := labels.PositionedBlock{
 			Block:  block,
 			BCoord: izyx,
 		}
 		op.Process(&pb)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	if numEmpty < len(indices) {
 		if err = op.Finish(); err != nil {
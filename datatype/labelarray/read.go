@@ -70,6 +70,7 @@ func (d *Data) GetVolume(v dvid.VersionID, vox *Labels, scale uint8, roiname dvi
 }
 
 type getOperation struct {
+	version     dvid.VersionID
 	voxels      *Labels
 	blocksInROI map[string]bool
 	mapping     *labels.Mapping
@@ -129,9 +130,9 @@ func (d *Data) GetLabels(v dvid.VersionID, scale uint8, vox *Labels, r *imageblk
 					blocksInROI[indexString] = true
 				}
 			}
-			chunkOp = &storage.ChunkOp{&getOperation{vox, blocksInROI, mapping}, wg}
+			chunkOp = &storage.ChunkOp{&getOperation{v, vox, blocksInROI, mapping}, wg}
 		} else {
-			chunkOp = &storage.ChunkOp{&getOperation{vox, nil, mapping}, wg}
+			chunkOp = &storage.ChunkOp{&getOperation{v, vox, nil, mapping}, wg}
 		}
 
 		if !hasbuffer {
@@ -240,7 +241,7 @@ func (d *Data) readChunk(chunk *storage.Chunk) {
 		block = *labels.MakeSolidBlock(0, blockSize)
 	} else {
 		var data []byte
-		data, _, err = dvid.DeserializeData(chunk.V, true)
+		data, _, err = d.DeserializeValue(op.version, chunk.K, chunk.V, true)
 		if err != nil {
 			dvid.Errorf("Unable to deserialize block in %q: %v\n", d.DataName(), err)
 			return
@@ -210,7 +210,7 @@ GET  <api URL>/node/<UUID>/<data name>/isotropic/<dims>/<size>/<offset>[/<format
                     the image-based codec.
     throttle      Only works for 3d data requests.  If "true", makes sure only N compute-intense operation 
     				(all API calls that can be throttled) are handled.  If the server can't initiate the API 
-    				call right away, a 503 (Service Unavailable) status code is returned.
+    				call right away, it waits its turn by request priority.
 
 
 GET  <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>][?queryopts]
@@ -256,7 +256,7 @@ GET  <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>][?qu
                     the image-based codec.
     throttle      Only works for 3d data requests.  If "true", makes sure only N compute-intense operation 
     				(all API calls that can be throttled) are handled.  If the server can't initiate the API 
-    				call right away, a 503 (Service Unavailable) status code is returned.
+    				call right away, it waits its turn by request priority.
 
 
 POST <api URL>/node/<UUID>/<data name>/raw/0_1_2/<size>/<offset>[?queryopts]
@@ -285,8 +285,8 @@ POST <api URL>/node/<UUID>/<data name>/raw/0_1_2/<size>/<offset>[?queryopts]
     compression   Allows retrieval or submission of 3d data in "lz4" and "gzip"
                     compressed format.
     throttle      If "true", makes sure only N compute-intense operation (all API calls that can be throttled) 
-                    are handled.  If the server can't initiate the API call right away, a 503 (Service Unavailable) 
-                    status code is returned.
+                    are handled.  If the server can't initiate the API call right away, it waits its turn by 
+                    request priority.
 
 GET  <api URL>/node/<UUID>/<data name>/pseudocolor/<dims>/<size>/<offset>[?queryopts]
 
@@ -314,8 +314,8 @@ GET  <api URL>/node/<UUID>/<data name>/pseudocolor/<dims>/<size>/<offset>[?query
     compression   Allows retrieval or submission of 3d data in "lz4" and "gzip"
                     compressed format.
     throttle      If "true", makes sure only N compute-intense operation (all API calls that can be throttled) 
-                    are handled.  If the server can't initiate the API call right away, a 503 (Service Unavailable) 
-                    status code is returned.
+                    are handled.  If the server can't initiate the API call right away, it waits its turn by 
+                    request priority.
 
 GET <api URL>/node/<UUID>/<data name>/label/<coord>
 
@@ -398,8 +398,8 @@ GET  <api URL>/node/<UUID>/<data name>/blocks/<size>/<offset>[?queryopts]
 
     compression   Allows retrieval of block data in "lz4" (default) or "uncompressed".
     throttle      If "true", makes sure only N compute-intense operation (all API calls that can be 
-                  throttled) are handled.  If the server can't initiate the API call right away, a 503 
-                  (Service Unavailable) status code is returned.
+                  throttled) are handled.  If the server can't initiate the API call right away, it waits 
+                  its turn by request priority.
 `
 
 var (
@@ -718,13 +718,27 @@ func (d *Data) convertTo64bit(geom dvid.Geometry, data []uint8, bytesPerVoxel, s
 	return data64, nil
 }
 
-func sendBlockLZ4(w http.ResponseWriter, x, y, z int32, v []byte, compression string) error {
+// sendBlockLZ4 writes the block at the given block coordinate, stored under the given key
+// and read for the given version, with its coordinate and size.  A corrupt value returns
+// a *datastore.CorruptValueError before anything is written.
+func (d *Data) sendBlockLZ4(w http.ResponseWriter, version dvid.VersionID, tk storage.TKey, x, y, z int32, v []byte, compression string) error {
 	// Check internal format and see if it's valid with compression choice.
 	format, checksum := dvid.DecodeSerializationFormat(dvid.SerializationFormat(v[0]))
 	if (compression == "lz4" || compression == "") && format != dvid.LZ4 {
 		return fmt.Errorf("Expected internal block data to be LZ4, was %s instead.", format)
 	}
 
+	// Check the stored value or deserialize it before anything is written.
+	var data []byte
+	if compression == "uncompressed" {
+		var err error
+		if data, _, err = d.DeserializeValue(version, tk, v, true); err != nil {
+			return err
+		}
+	} else if err := d.VerifyValue(version, tk, v); err != nil {
+		return err
+	}
+
 	// Send block coordinate and size of data.
 	if err := binary.Write(w, binary.LittleEndian, x); err != nil {
 		return err
@@ -742,14 +756,7 @@ func sendBlockLZ4(w http.ResponseWriter, x, y, z int32, v []byte, compression st
 	}
 
 	// Do any adjustment of sent data based on compression request
-	var data []byte
-	if compression == "uncompressed" {
-		var err error
-		data, _, err = dvid.DeserializeData(v, true)
-		if err != nil {
-			return err
-		}
-	} else {
+	if compression != "uncompressed" {
 		data = v[start:]
 	}
 	n := len(data)
@@ -797,7 +804,7 @@ func (d *Data) SendBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter, su
 			return err
 		}
 		if len(value) > 0 {
-			return sendBlockLZ4(w, blockoffset.Value(0), blockoffset.Value(1), blockoffset.Value(2), value, compression)
+			return d.sendBlockLZ4(w, ctx.VersionID(), keyBeg, blockoffset.Value(0), blockoffset.Value(1), blockoffset.Value(2), value, compression)
 		}
 		return nil
 	}
@@ -843,7 +850,7 @@ func (d *Data) SendBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter, su
 				if z != sz || y != sy || x < sx || x >= sx+int32(blocksize.Value(0)) {
 					return nil
 				}
-				if err := sendBlockLZ4(w, x, y, z, kv.V, compression); err != nil {
+				if err := d.sendBlockLZ4(w, ctx.VersionID(), kv.K, x, y, z, kv.V, compression); err != nil {
 					return err
 				}
 				return nil
@@ -1403,7 +1410,7 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		sizeStr, offsetStr := parts[4], parts[5]
 
 		if throttle := queryStrings.Get("throttle"); throttle == "on" || throttle == "true" {
-			if server.ThrottledHTTP(w) {
+			if server.ThrottledHTTP(w, r) {
 				return
 			}
 			defer server.ThrottledOpDone()
@@ -1427,6 +1434,9 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		}
 
 		if action == "get" {
+			if compression != "uncompressed" {
+				server.NoCompression(r) // blocks are lz4 by default
+			}
 			if err := d.SendBlocks(ctx, w, subvol, compression); err != nil {
 				server.BadRequest(w, r, err)
 				return
@@ -1551,7 +1561,7 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			timedLog.Infof("HTTP %s: %s (%s)", r.Method, plane, r.URL)
 		case 3:
 			if throttle := queryStrings.Get("throttle"); throttle == "on" || throttle == "true" {
-				if server.ThrottledHTTP(w) {
+				if server.ThrottledHTTP(w, r) {
 					return
 				}
 				defer server.ThrottledOpDone()
@@ -1573,6 +1583,9 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 					server.BadRequest(w, r, err)
 					return
 				}
+				if compression != "" {
+					server.NoCompression(r)
+				}
 				if err := sendBinaryData(compression, data, subvol, w); err != nil {
 					server.BadRequest(w, r, err)
 					return
@@ -1631,14 +1644,15 @@ func (d *Data) GetLabelBytes(v dvid.VersionID, bcoord dvid.ChunkPoint3d) ([]byte
 	// Retrieve the block of labels
 	ctx := datastore.NewVersionedCtx(d, v)
 	index := dvid.IndexZYX(bcoord)
-	serialization, err := store.Get(ctx, NewTKey(&index))
+	tk := NewTKey(&index)
+	serialization, err := store.Get(ctx, tk)
 	if err != nil {
 		return nil, fmt.Errorf("Error getting '%s' block for index %s\n", d.DataName(), bcoord)
 	}
 	if serialization == nil {
 		return []byte{}, nil
 	}
-	labelData, _, err := dvid.DeserializeData(serialization, true)
+	labelData, _, err := d.DeserializeValue(v, tk, serialization, true)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to deserialize block %s in '%s': %v\n", bcoord, d.DataName(), err)
 	}
@@ -70,6 +70,7 @@ func (d *Data) GetVolume(v dvid.VersionID, vox *Labels, roiname dvid.InstanceNam
 }
 
 type getOperation struct {
+	version     dvid.VersionID
 	voxels      *Labels
 	blocksInROI map[string]bool
 	mapping     *labels.Mapping
@@ -126,9 +127,9 @@ func (d *Data) GetLabels(v dvid.VersionID, vox *Labels, r *imageblk.ROI) error {
 					blocksInROI[indexString] = true
 				}
 			}
-			chunkOp = &storage.ChunkOp{&getOperation{vox, blocksInROI, mapping}, wg}
+			chunkOp = &storage.ChunkOp{&getOperation{v, vox, blocksInROI, mapping}, wg}
 		} else {
-			chunkOp = &storage.ChunkOp{&getOperation{vox, nil, mapping}, wg}
+			chunkOp = &storage.ChunkOp{&getOperation{v, vox, nil, mapping}, wg}
 		}
 
 		if !hasbuffer {
@@ -218,7 +219,7 @@ func (d *Data) GetBlocks(v dvid.VersionID, start dvid.ChunkPoint3d, span int) ([
 			return nil, err
 		}
 		blockPos := dvid.ChunkPoint3d(*idx)
-		block, _, err := dvid.DeserializeData(kv.V, uncompress)
+		block, _, err := d.DeserializeValue(v, kv.K, kv.V, uncompress)
 		if err != nil {
 			return nil, fmt.Errorf("Unable to deserialize block, %s (%v): %v", ctx, kv.K, err)
 		}
@@ -295,7 +296,7 @@ func (d *Data) readChunk(chunk *storage.Chunk) {
 	if zeroOut || chunk.V == nil {
 		blockData = d.BackgroundBlock()
 	} else {
-		blockData, _, err = dvid.DeserializeData(chunk.V, true)
+		blockData, _, err = d.DeserializeValue(op.version, chunk.K, chunk.V, true)
 		if err != nil {
 			dvid.Errorf("Unable to deserialize block in '%s': %v\n", d.DataName(), err)
 			return
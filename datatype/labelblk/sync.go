@@ -274,7 +274,7 @@ func (d *Data) downsizeCommit(v dvid.VersionID, mutID uint64) {
 				continue
 			}
 			uncompress := true
-			deserialized, _, err := dvid.DeserializeData(serialization, uncompress)
+			deserialized, _, err := d.DeserializeValue(v, tk, serialization, uncompress)
 			if err != nil {
 				dvid.Criticalf("Unable to deserialize data for %q, block %s: %v", d.DataName(), downresBlock, err)
 				continue
@@ -574,7 +574,7 @@ func (d *Data) mergeBlock(ctx *datastore.VersionedCtx, op mergeOp) {
 		return
 	}
 
-	blockData, _, err := dvid.DeserializeData(data, true)
+	blockData, _, err := d.DeserializeValue(ctx.VersionID(), tk, data, true)
 	if err != nil {
 		dvid.Criticalf("unable to deserialize label block in '%s': %v\n", d.DataName(), err)
 		return
@@ -628,7 +628,7 @@ func (d *Data) splitBlock(ctx *datastore.VersionedCtx, op splitOp) {
 		dvid.Errorf("nil label block where split was done, coord %s\n", op.bcoord)
 		return
 	}
-	blockData, _, err := dvid.DeserializeData(data, true)
+	blockData, _, err := d.DeserializeValue(ctx.VersionID(), tk, data, true)
 	if err != nil {
 		dvid.Criticalf("unable to deserialize label block in %q key %v: %v\n", d.DataName(), op.bcoord, err)
 		return
@@ -752,8 +752,10 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 	}
 }
 
+// ReloadData asynchronously recalculates the labelsz from the synced annotations.  The
+// recalculation gets its own context since it continues after the request is finished.
 func (d *Data) ReloadData(ctx *datastore.VersionedCtx) {
-	go d.resync(ctx)
+	go d.resync(datastore.NewVersionedCtx(d, ctx.VersionID()))
 	dvid.Infof("Started recalculation of labelsz %q...\n", d.DataName())
 }
 
@@ -7,6 +7,7 @@ package labelvol
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
@@ -688,7 +689,7 @@ func (d *Data) LoadMutable(root dvid.VersionID, storedVersion, expectedVersion u
 		return false, err
 	}
 	keysOnly := false
-	if err = store.RawRangeQuery(minKey, maxKey, keysOnly, ch, nil); err != nil {
+	if err = store.RawRangeQuery(context.Background(), minKey, maxKey, keysOnly, ch); err != nil {
 		return false, err
 	}
 	wg.Wait()
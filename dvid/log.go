@@ -1,7 +1,12 @@
 package dvid
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,6 +21,25 @@ const (
 	SilentMode
 )
 
+var modeNames = []string{"debug", "info", "warning", "error", "critical", "silent"}
+
+func (m ModeFlag) String() string {
+	if int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return fmt.Sprintf("mode %d", m)
+}
+
+// ParseLogMode returns the mode for a level name, e.g., "info" or "warning".
+func ParseLogMode(level string) (ModeFlag, error) {
+	for i, name := range modeNames {
+		if strings.ToLower(level) == name {
+			return ModeFlag(i), nil
+		}
+	}
+	return InfoMode, fmt.Errorf("unknown log level %q", level)
+}
+
 var (
 	// mode is a global variable set to the run modes of this DVID process.
 	mode ModeFlag = InfoMode
@@ -28,8 +52,87 @@ var (
 type logFunc func(s string)
 
 type logMessage struct {
-	f   logFunc
-	msg string
+	f      logFunc
+	level  ModeFlag
+	msg    string
+	fields Fields
+}
+
+// Fields are named values attached to a log message, e.g., the request ID, repo UUID,
+// data instance, or duration of an operation.
+type Fields map[string]interface{}
+
+// String returns the fields as " key=value" pairs sorted by key.
+func (f Fields) String() string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		v := fmt.Sprintf("%v", f[k])
+		if strings.ContainsAny(v, " \t\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	return b.String()
+}
+
+var (
+	// jsonLog is where log messages are written as JSON lines, or nil if messages are
+	// written as text by the logger.
+	jsonLog   io.Writer
+	jsonLogMu sync.Mutex
+)
+
+// write sends a message to the logger, appending any fields to the text, or writes it as
+// a JSON line if so configured.
+func (m logMessage) write() {
+	if jsonLog != nil {
+		writeJSONLog(m.level, m.msg, m.fields)
+		return
+	}
+	if len(m.fields) == 0 {
+		m.f(m.msg)
+		return
+	}
+	m.f(strings.TrimRight(m.msg, "\n") + m.fields.String() + "\n")
+}
+
+// writeJSONLog writes a JSON object with the time, level, message, and fields.  Durations
+// are written in seconds and errors as their text.
+func writeJSONLog(level ModeFlag, msg string, fields Fields) {
+	entry := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		switch x := v.(type) {
+		case time.Duration:
+			v = x.Seconds()
+		case error:
+			v = x.Error()
+		case fmt.Stringer:
+			v = x.String()
+		}
+		entry[k] = v
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = strings.TrimRight(msg, "\n")
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"time": entry["time"].(string), "level": level.String(), "msg": fmt.Sprintf("%s (bad log fields: %v)", entry["msg"], err)})
+	}
+	jsonLogMu.Lock()
+	jsonLog.Write(append(line, '\n'))
+	jsonLogMu.Unlock()
+}
+
+// queueLog formats and queues a message if the mode allows its level.
+func queueLog(level ModeFlag, f logFunc, fields Fields, format string, args ...interface{}) {
+	if mode <= level {
+		logCh <- logMessage{f: f, level: level, msg: fmt.Sprintf(format, args...), fields: fields}
+	}
 }
 
 const maxPendingLogMessages = 10000
@@ -38,7 +141,7 @@ func init() {
 	logCh = make(chan logMessage, maxPendingLogMessages)
 	go func() {
 		for msg := range logCh {
-			msg.f(msg.msg)
+			msg.write()
 		}
 	}()
 }
@@ -114,38 +217,75 @@ func SetLogMode(newMode ModeFlag) {
 }
 
 func Debugf(format string, args ...interface{}) {
-	if mode <= DebugMode {
-		logCh <- logMessage{f: logger.Debug, msg: fmt.Sprintf(format, args...)}
-	}
+	queueLog(DebugMode, logger.Debug, nil, format, args...)
 }
 
 func Infof(format string, args ...interface{}) {
-	if mode <= InfoMode {
-		logCh <- logMessage{f: logger.Info, msg: fmt.Sprintf(format, args...)}
-	}
+	queueLog(InfoMode, logger.Info, nil, format, args...)
 }
 
 func Warningf(format string, args ...interface{}) {
-	if mode <= WarningMode {
-		logCh <- logMessage{f: logger.Warning, msg: fmt.Sprintf(format, args...)}
-	}
+	queueLog(WarningMode, logger.Warning, nil, format, args...)
 }
 
 func Errorf(format string, args ...interface{}) {
-	if mode <= ErrorMode {
-		logCh <- logMessage{f: logger.Error, msg: fmt.Sprintf(format, args...)}
-	}
+	queueLog(ErrorMode, logger.Error, nil, format, args...)
 }
 
 func Criticalf(format string, args ...interface{}) {
-	if mode <= CriticalMode {
-		logCh <- logMessage{f: logger.Critical, msg: fmt.Sprintf(format, args...)}
+	queueLog(CriticalMode, logger.Critical, nil, format, args...)
+}
+
+// FieldLogger writes log messages with a set of fields, e.g.,
+// dvid.WithFields(dvid.Fields{"request_id": id, "repo": uuid}).Infof("deleted %d blocks\n", n)
+type FieldLogger struct {
+	fields Fields
+}
+
+// WithFields returns a logger that attaches the given fields to its messages.
+func WithFields(fields Fields) FieldLogger {
+	return FieldLogger{fields}
+}
+
+// WithFields returns a logger with additional fields, overriding any of the same name.
+func (l FieldLogger) WithFields(fields Fields) FieldLogger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
 	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return FieldLogger{merged}
+}
+
+func (l FieldLogger) Debugf(format string, args ...interface{}) {
+	queueLog(DebugMode, logger.Debug, l.fields, format, args...)
+}
+
+func (l FieldLogger) Infof(format string, args ...interface{}) {
+	queueLog(InfoMode, logger.Info, l.fields, format, args...)
+}
+
+func (l FieldLogger) Warningf(format string, args ...interface{}) {
+	queueLog(WarningMode, logger.Warning, l.fields, format, args...)
+}
+
+func (l FieldLogger) Errorf(format string, args ...interface{}) {
+	queueLog(ErrorMode, logger.Error, l.fields, format, args...)
+}
+
+func (l FieldLogger) Criticalf(format string, args ...interface{}) {
+	queueLog(CriticalMode, logger.Critical, l.fields, format, args...)
 }
 
 // LogImmediately writes a message to the log file immediately, bypassing any queue of
 // log messages.
 func LogImmediately(s string) {
+	if jsonLog != nil {
+		writeJSONLog(CriticalMode, s, nil)
+		return
+	}
 	logger.Criticalf("%s", s)
 }
 
@@ -164,33 +304,23 @@ func NewTimeLog() TimeLog {
 }
 
 func (t TimeLog) Debugf(format string, args ...interface{}) {
-	if mode <= DebugMode {
-		logCh <- logMessage{f: t.logger.Debug, msg: fmt.Sprintf(format+": %s\n", append(args, time.Since(t.start))...)}
-	}
+	queueLog(DebugMode, t.logger.Debug, nil, format+": %s\n", append(args, time.Since(t.start))...)
 }
 
 func (t TimeLog) Infof(format string, args ...interface{}) {
-	if mode <= InfoMode {
-		logCh <- logMessage{f: t.logger.Info, msg: fmt.Sprintf(format+": %s\n", append(args, time.Since(t.start))...)}
-	}
+	queueLog(InfoMode, t.logger.Info, nil, format+": %s\n", append(args, time.Since(t.start))...)
 }
 
 func (t TimeLog) Warningf(format string, args ...interface{}) {
-	if mode <= WarningMode {
-		logCh <- logMessage{f: t.logger.Warning, msg: fmt.Sprintf(format+": %s\n", append(args, time.Since(t.start))...)}
-	}
+	queueLog(WarningMode, t.logger.Warning, nil, format+": %s\n", append(args, time.Since(t.start))...)
 }
 
 func (t TimeLog) Errorf(format string, args ...interface{}) {
-	if mode <= ErrorMode {
-		logCh <- logMessage{f: t.logger.Error, msg: fmt.Sprintf(format+": %s\n", append(args, time.Since(t.start))...)}
-	}
+	queueLog(ErrorMode, t.logger.Error, nil, format+": %s\n", append(args, time.Since(t.start))...)
 }
 
 func (t TimeLog) Criticalf(format string, args ...interface{}) {
-	if mode <= CriticalMode {
-		logCh <- logMessage{f: t.logger.Critical, msg: fmt.Sprintf(format+": %s\n", append(args, time.Since(t.start))...)}
-	}
+	queueLog(CriticalMode, t.logger.Critical, nil, format+": %s\n", append(args, time.Since(t.start))...)
 }
 
 func (t TimeLog) Shutdown() {
@@ -4,37 +4,69 @@ package dvid
 
 import (
 	"fmt"
+	"io"
 	"log"
-
-	"gopkg.in/natefinch/lumberjack.v2"
+	"os"
+	"strings"
 )
 
 type stdLogger struct {
-	*lumberjack.Logger
+	*logFile
 }
 
 var logger stdLogger
 
 type LogConfig struct {
 	Logfile string
-	MaxSize int `toml:"max_log_size"`
-	MaxAge  int `toml:"max_log_age"`
+	MaxSize int    `toml:"max_log_size"`
+	MaxAge  int    `toml:"max_log_age"`
+	Format  string // "console" (default) or "json" for one JSON object per line
+	Level   string // minimum level logged, e.g., "debug" or "warning"; "info" by default
 }
 
-// SetLogger creates a logger that saves to a rotating log file.
+// SetLogger creates a logger that saves to a log file, rotated when it exceeds MaxSize
+// megabytes with rotated files removed after MaxAge days, and sets the level and format
+// of log messages.  The -verbose flag overrides the configured level.
 func (c *LogConfig) SetLogger() {
-	if c == nil || c.Logfile == "" {
+	if c == nil {
 		Infof("Sending log messages to stdout since no log file specified.")
 		return
 	}
-	fmt.Printf("Sending log messages to: %s\n", c.Logfile)
-	l := &lumberjack.Logger{
-		Filename: c.Logfile,
-		MaxSize:  c.MaxSize, // megabytes
-		MaxAge:   c.MaxAge,  //days
+	if c.Level != "" && mode != DebugMode {
+		level, err := ParseLogMode(c.Level)
+		if err != nil {
+			fmt.Printf("Ignoring bad [logging] level: %v\n", err)
+		} else {
+			SetLogMode(level)
+		}
+	}
+	var out io.Writer = os.Stderr // the standard log's output
+	if c.Logfile == "" {
+		Infof("Sending log messages to stdout since no log file specified.")
+	} else if l, err := openLogFile(c.Logfile, c.MaxSize, c.MaxAge); err != nil {
+		fmt.Printf("Sending log messages to stdout: %v\n", err)
+	} else {
+		fmt.Printf("Sending log messages to: %s\n", c.Logfile)
+		log.SetOutput(l)
+		logger = stdLogger{l}
+		out = l
+	}
+	switch strings.ToLower(c.Format) {
+	case "", "console":
+	case "json":
+		jsonLog = out
+	default:
+		fmt.Printf("Ignoring unknown [logging] format %q; use \"console\" or \"json\".\n", c.Format)
+	}
+}
+
+// ReopenLog reopens the log file, if any, so a log file renamed by an external tool like
+// logrotate is replaced by a new file at the configured path.
+func ReopenLog() error {
+	if logger.logFile == nil {
+		return nil
 	}
-	log.SetOutput(l)
-	logger = stdLogger{l}
+	return logger.Reopen()
 }
 
 // --- Logger implementation ----
@@ -68,7 +100,7 @@ func (slog stdLogger) Criticalf(format string, args ...interface{}) {
 
 // Debug writes directly to logger at DEBUG level.
 func (slog stdLogger) Debug(s string) {
-	if logger.Logger != nil {
+	if logger.logFile != nil {
 		logger.Write([]byte("   DEBUG " + s))
 	} else {
 		log.Printf("   DEBUG " + s)
@@ -77,7 +109,7 @@ func (slog stdLogger) Debug(s string) {
 
 // Info writes directly to logger at INFO level
 func (slog stdLogger) Info(s string) {
-	if logger.Logger != nil {
+	if logger.logFile != nil {
 		logger.Write([]byte("   INFO " + s))
 	} else {
 		log.Printf("   INFO " + s)
@@ -86,7 +118,7 @@ func (slog stdLogger) Info(s string) {
 
 // Warning writes directly to logger at INFO level
 func (slog stdLogger) Warning(s string) {
-	if logger.Logger != nil {
+	if logger.logFile != nil {
 		logger.Write([]byte("   WARNING " + s))
 	} else {
 		log.Printf("   WARNING " + s)
@@ -95,7 +127,7 @@ func (slog stdLogger) Warning(s string) {
 
 // Error writes directly to logger at ERROR level
 func (slog stdLogger) Error(s string) {
-	if logger.Logger != nil {
+	if logger.logFile != nil {
 		logger.Write([]byte("   ERROR " + s))
 	} else {
 		log.Printf("   ERROR " + s)
@@ -104,7 +136,7 @@ func (slog stdLogger) Error(s string) {
 
 // Critical writes directly to logger at CRITICAL level
 func (slog stdLogger) Critical(s string) {
-	if logger.Logger != nil {
+	if logger.logFile != nil {
 		logger.Write([]byte("   CRITICAL " + s))
 	} else {
 		log.Printf("   CRITICAL " + s)
@@ -113,7 +145,7 @@ func (slog stdLogger) Critical(s string) {
 
 func (slog stdLogger) Shutdown() {
 	log.Printf("Closing log file...\n")
-	if slog.Logger != nil {
+	if slog.logFile != nil {
 		slog.Close()
 	}
 }
@@ -0,0 +1,50 @@
+package dvid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLogFields(t *testing.T) {
+	fields := Fields{"repo": "a1b2", "duration": 1500 * time.Millisecond, "path": "/api/node x"}
+	if s := fields.String(); s != ` duration=1.5s path="/api/node x" repo=a1b2` {
+		t.Errorf("bad console fields: %s\n", s)
+	}
+
+	var buf bytes.Buffer
+	jsonLog = &buf
+	defer func() { jsonLog = nil }()
+	fields["err"] = fmt.Errorf("bad block")
+	writeJSONLog(WarningMode, "request failed\n", fields)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("bad JSON log line %q: %v\n", buf.String(), err)
+	}
+	expected := map[string]interface{}{
+		"level":    "warning",
+		"msg":      "request failed",
+		"repo":     "a1b2",
+		"duration": 1.5,
+		"path":     "/api/node x",
+		"err":      "bad block",
+	}
+	for k, v := range expected {
+		if entry[k] != v {
+			t.Errorf("expected %q = %v in JSON log, got %v\n", k, v, entry[k])
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, entry["time"].(string)); err != nil {
+		t.Errorf("bad time in JSON log: %v\n", err)
+	}
+
+	if mode, err := ParseLogMode("Warning"); err != nil || mode != WarningMode {
+		t.Errorf("expected warning mode, got %s, %v\n", mode, err)
+	}
+	if _, err := ParseLogMode("loud"); err == nil {
+		t.Errorf("expected error parsing unknown log level\n")
+	}
+}
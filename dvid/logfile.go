@@ -0,0 +1,158 @@
+/*
+	This file implements a log file that rotates itself by size, removes old rotated files
+	by age, and can be reopened so external tools like logrotate can move it aside.
+*/
+
+package dvid
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxLogSize is the size in megabytes at which a log file is rotated if no
+	// maximum size is configured.
+	DefaultMaxLogSize = 100
+
+	// rotatedTimeFormat is added to the name of rotated log files, e.g., dvid.log becomes
+	// dvid-2017-03-21T14-05-33.127.log.
+	rotatedTimeFormat = "2006-01-02T15-04-05.000"
+)
+
+// logFile is an io.Writer that appends to a file, renaming it with the time and starting
+// a new file when it exceeds a maximum size.
+type logFile struct {
+	sync.Mutex
+	path    string
+	maxSize int64         // bytes
+	maxAge  time.Duration // rotated files older than this are removed, or kept if 0.
+
+	file *os.File
+	size int64
+}
+
+// openLogFile opens a log file for appending, rotating it when it exceeds the given
+// megabytes and removing rotated files older than the given days.  A non-positive size
+// uses DefaultMaxLogSize and a non-positive age keeps all rotated files.
+func openLogFile(path string, maxSizeMB, maxAgeDays int) (*logFile, error) {
+	if maxSizeMB <= 0 {
+		maxSizeMB = DefaultMaxLogSize
+	}
+	l := &logFile{
+		path:    path,
+		maxSize: int64(maxSizeMB) * 1024 * 1024,
+	}
+	if maxAgeDays > 0 {
+		l.maxAge = time.Duration(maxAgeDays) * 24 * time.Hour
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("can't make directory for log file %q: %v", l.path, err)
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("can't open log file %q: %v", l.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("can't stat log file %q: %v", l.path, err)
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+// Write appends to the log file, first rotating it if the write would exceed the maximum
+// size.  If the file can't be reopened after a rotation or reopen request, the write is
+// sent to stderr.
+func (l *logFile) Write(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	if l.file != nil && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to rotate log file: %v\n", err)
+		}
+	}
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return os.Stderr.Write(p)
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file with the time and opens a new one.  The caller must
+// hold the lock.
+func (l *logFile) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	ext := filepath.Ext(l.path)
+	rotated := strings.TrimSuffix(l.path, ext) + "-" + time.Now().Format(rotatedTimeFormat) + ext
+	if err := os.Rename(l.path, rotated); err != nil {
+		return err
+	}
+	if l.maxAge > 0 {
+		go l.removeOld()
+	}
+	return l.open()
+}
+
+// removeOld deletes rotated files modified longer ago than the maximum age.
+func (l *logFile) removeOld() {
+	ext := filepath.Ext(l.path)
+	matches, err := filepath.Glob(strings.TrimSuffix(l.path, ext) + "-*" + ext)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-l.maxAge)
+	for _, name := range matches {
+		info, err := os.Stat(name)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to remove old log file %q: %v\n", name, err)
+		}
+	}
+}
+
+// Reopen closes the log file and opens the file at its path, e.g., after logrotate has
+// renamed it.
+func (l *logFile) Reopen() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	return l.open()
+}
+
+// Close closes the log file.  Later writes reopen it.
+func (l *logFile) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
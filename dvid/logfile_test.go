@@ -0,0 +1,69 @@
+package dvid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dvid-logfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dvid.log")
+
+	l, err := openLogFile(path, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	line := strings.Repeat("x", 1023) + "\n"
+	for i := 0; i < 1025; i++ {
+		if _, err := l.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotated, _ := filepath.Glob(filepath.Join(dir, "dvid-*.log"))
+	if len(rotated) != 1 {
+		t.Fatalf("expected 1 rotated log file, got %v\n", rotated)
+	}
+	if info, err := os.Stat(rotated[0]); err != nil || info.Size() != 1024*1024 {
+		t.Fatalf("expected rotated log file of 1 MB, got %v, %v\n", info, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 1024 {
+		t.Fatalf("expected new log file with 1 line, got %v, %v\n", info, err)
+	}
+
+	// Simulate logrotate moving the file before a reopen.
+	moved := filepath.Join(dir, "dvid.log.1")
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Write([]byte("after reopen\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "after reopen\n" {
+		t.Fatalf("expected reopened log file with new line, got %q, %v\n", data, err)
+	}
+	if info, err := os.Stat(moved); err != nil || info.Size() != 1024 {
+		t.Fatalf("expected moved log file unchanged, got %v, %v\n", info, err)
+	}
+
+	// Rotated files older than the maximum age are removed.
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(rotated[0], old, old); err != nil {
+		t.Fatal(err)
+	}
+	l.removeOld()
+	if _, err := os.Stat(rotated[0]); !os.IsNotExist(err) {
+		t.Errorf("expected old rotated log file to be removed: %v\n", err)
+	}
+}
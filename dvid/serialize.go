@@ -16,6 +16,9 @@ import (
 	"image/jpeg"
 	"io"
 	_ "log"
+	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"github.com/golang/snappy"
 	lz4 "github.com/janelia-flyem/go/golz4"
@@ -26,6 +29,7 @@ import (
 type Compression struct {
 	format CompressionFormat
 	level  CompressionLevel
+	dict   uint32 // zstd dictionary ID or 0 if none.
 }
 
 func (c Compression) Format() CompressionFormat {
@@ -36,8 +40,16 @@ func (c Compression) Level() CompressionLevel {
 	return c.level
 }
 
+// Dict returns the ID of the zstd dictionary used or 0 if there is none.
+func (c Compression) Dict() uint32 {
+	return c.dict
+}
+
 // MarshalJSON implements the json.Marshaler interface.
 func (c Compression) MarshalJSON() ([]byte, error) {
+	if c.dict != 0 {
+		return []byte(fmt.Sprintf(`{"Format":%d,"Level":%d,"Dict":%d}`, c.format, c.level, c.dict)), nil
+	}
 	return []byte(fmt.Sprintf(`{"Format":%d,"Level":%d}`, c.format, c.level)), nil
 }
 
@@ -46,31 +58,46 @@ func (c *Compression) UnmarshalJSON(b []byte) error {
 	var m struct {
 		Format CompressionFormat
 		Level  CompressionLevel
+		Dict   uint32
 	}
 	if err := json.Unmarshal(b, &m); err != nil {
 		return err
 	}
 	c.format = m.Format
 	c.level = m.Level
+	c.dict = m.Dict
 	return nil
 }
 
-// MarshalBinary fulfills the encoding.BinaryMarshaler interface.
+// MarshalBinary fulfills the encoding.BinaryMarshaler interface.  The dictionary ID
+// is only appended if used so older serializations remain valid.
 func (c Compression) MarshalBinary() ([]byte, error) {
+	if c.dict != 0 {
+		b := []byte{byte(c.format), byte(c.level), 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(b[2:], c.dict)
+		return b, nil
+	}
 	return []byte{byte(c.format), byte(c.level)}, nil
 }
 
 // UnmarshalBinary fulfills the encoding.BinaryUnmarshaler interface.
 func (c *Compression) UnmarshalBinary(data []byte) error {
-	if len(data) != 2 {
+	if len(data) != 2 && len(data) != 6 {
 		return fmt.Errorf("Cannot unmarshal %d bytes into Compression", len(data))
 	}
 	c.format = CompressionFormat(data[0])
 	c.level = CompressionLevel(data[1])
+	c.dict = 0
+	if len(data) == 6 {
+		c.dict = binary.LittleEndian.Uint32(data[2:])
+	}
 	return nil
 }
 
 func (c Compression) String() string {
+	if c.dict != 0 {
+		return fmt.Sprintf("%s, level %d, dictionary %d", c.format, c.level, c.dict)
+	}
 	return fmt.Sprintf("%s, level %d", c.format, c.level)
 }
 
@@ -82,23 +109,74 @@ func NewCompression(format CompressionFormat, level CompressionLevel) (Compressi
 	}
 	switch format {
 	case Uncompressed:
-		return Compression{format, DefaultCompression}, nil
+		return Compression{format: format, level: DefaultCompression}, nil
 	case Snappy:
-		return Compression{format, DefaultCompression}, nil
+		return Compression{format: format, level: DefaultCompression}, nil
 	case LZ4:
-		return Compression{format, DefaultCompression}, nil
+		return Compression{format: format, level: DefaultCompression}, nil
 	case JPEG:
-		return Compression{format, level}, nil
+		return Compression{format: format, level: level}, nil
 	case Gzip:
 		if level != DefaultCompression && (level < 1 || level > 9) {
 			return Compression{}, fmt.Errorf("Gzip compression level must be between 1 and 9")
 		}
-		return Compression{format, level}, nil
+		return Compression{format: format, level: level}, nil
+	case Zstd:
+		if level != DefaultCompression && (level < 1 || level > 22) {
+			return Compression{}, fmt.Errorf("Zstd compression level must be between 1 and 22")
+		}
+		return Compression{format: format, level: level}, nil
 	default:
 		return Compression{}, fmt.Errorf("Unrecognized compression format requested: %d", format)
 	}
 }
 
+// NewZstdCompression returns a Zstd compression using a registered dictionary.
+func NewZstdCompression(level CompressionLevel, dict uint32) (Compression, error) {
+	c, err := NewCompression(Zstd, level)
+	if err != nil {
+		return c, err
+	}
+	if dict != 0 {
+		if _, found := ZstdDicts()[dict]; !found {
+			return Compression{}, fmt.Errorf("zstd dictionary %d has not been registered", dict)
+		}
+	}
+	c.dict = dict
+	return c, nil
+}
+
+// ParseCompression returns the compression specified by a string of the form
+// "<format>" or "<format>:<level>", e.g., "none", "lz4", "gzip:7", or "zstd:3".
+// For jpeg, the level is the quality.
+func ParseCompression(s string) (Compression, error) {
+	format := strings.ToLower(strings.TrimSpace(s))
+	level := CompressionLevel(DefaultCompression)
+	if parts := strings.Split(format, ":"); len(parts) == 2 {
+		l, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return Compression{}, fmt.Errorf("Unable to parse %s compression level (%q).  Should be '%s:<level>'.", parts[0], parts[1], parts[0])
+		}
+		format, level = parts[0], CompressionLevel(l)
+	}
+	switch format {
+	case "none", "uncompressed":
+		return NewCompression(Uncompressed, level)
+	case "snappy":
+		return NewCompression(Snappy, level)
+	case "lz4":
+		return NewCompression(LZ4, level)
+	case "gzip":
+		return NewCompression(Gzip, level)
+	case "zstd":
+		return NewCompression(Zstd, level)
+	case "jpeg":
+		return NewCompression(JPEG, level)
+	default:
+		return Compression{}, fmt.Errorf("Illegal compression specified: %s", s)
+	}
+}
+
 // CompressionLevel goes from 1 (fastest) to 9 (highest compression)
 // as in deflate.  Default compression is -1 so need signed int8.
 type CompressionLevel int8
@@ -121,6 +199,7 @@ const (
 	Uncompressed CompressionFormat = 0
 	Snappy                         = 1
 	Gzip                           = 2 // Gzip stores length and checksum automatically.
+	Zstd                           = 3
 	LZ4                            = 4
 	JPEG                           = 5
 )
@@ -137,6 +216,8 @@ func (format CompressionFormat) String() string {
 		return "jpeg compression"
 	case Gzip:
 		return "gzip compression"
+	case Zstd:
+		return "zstd compression"
 	default:
 		return "Unknown compression"
 	}
@@ -167,6 +248,18 @@ func (checksum Checksum) String() string {
 	}
 }
 
+// ParseChecksum returns the checksum specified by a string, either "none" or "crc32".
+func ParseChecksum(s string) (Checksum, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "none":
+		return NoChecksum, nil
+	case "crc32":
+		return CRC32, nil
+	default:
+		return NoChecksum, fmt.Errorf("Illegal checksum specified: %s", s)
+	}
+}
+
 // SerializationFormat combines both compression and checksum methods.
 // First 3 bits specifies compression, next 2 bits is the checkum, and
 // the final 3 bits is reserved for future use.
@@ -238,6 +331,10 @@ func SerializeData(data []byte, compress Compression, checksum Checksum) ([]byte
 			return nil, err
 		}
 		byteData = b.Bytes()
+	case Zstd:
+		if byteData, err = compressZstd(data, compress.level, compress.dict); err != nil {
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("Illegal compression (%s) during serialization", compress)
 	}
@@ -295,6 +392,57 @@ func Serialize(object interface{}, compress Compression, checksum Checksum) ([]b
 	return SerializeData(buffer.Bytes(), compress, checksum)
 }
 
+// ChecksumError is returned when the checksum stored with serialized data does not
+// match the data, i.e., the stored value is corrupt.
+type ChecksumError struct {
+	Stored   uint32
+	Computed uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("Bad checksum.  Stored %x got %x", e.Stored, e.Computed)
+}
+
+// VerifyChecksums requests checksum verification of stored values that are sent to
+// clients without deserialization.  Values are always verified on deserialization.
+var VerifyChecksums bool
+
+var numCorrupt uint64
+
+// CorruptValues returns the number of values that have failed checksum verification
+// since the server started.
+func CorruptValues() uint64 {
+	return atomic.LoadUint64(&numCorrupt)
+}
+
+func checkCRC32(data []byte, stored uint32) error {
+	if computed := crc32.ChecksumIEEE(data); computed != stored {
+		atomic.AddUint64(&numCorrupt, 1)
+		return &ChecksumError{Stored: stored, Computed: computed}
+	}
+	return nil
+}
+
+// VerifyChecksum checks any checksum stored with serialized data without uncompressing
+// the data.  A *ChecksumError is returned if the data is corrupt.
+func VerifyChecksum(s []byte) error {
+	if len(s) == 0 {
+		return nil
+	}
+	_, checksum := DecodeSerializationFormat(SerializationFormat(s[0]))
+	switch checksum {
+	case NoChecksum:
+		return nil
+	case CRC32:
+		if len(s) < 5 {
+			return fmt.Errorf("Serialized data with checksum only has %d bytes", len(s))
+		}
+		return checkCRC32(s[5:], binary.LittleEndian.Uint32(s[1:5]))
+	default:
+		return fmt.Errorf("Illegal checksum in serialized data")
+	}
+}
+
 // DeserializeData deserializes a slice of bytes using stored compression, checksum.
 // If uncompress parameter is false, the data is not uncompressed.
 func DeserializeData(s []byte, uncompress bool) ([]byte, CompressionFormat, error) {
@@ -328,9 +476,8 @@ func DeserializeData(s []byte, uncompress bool) ([]byte, CompressionFormat, erro
 	// Perform any requested checksum
 	switch checksum {
 	case CRC32:
-		crcChecksum := crc32.ChecksumIEEE(cdata)
-		if crcChecksum != storedCrc32 {
-			return nil, 0, fmt.Errorf("Bad checksum.  Stored %x got %x", storedCrc32, crcChecksum)
+		if err := checkCRC32(cdata, storedCrc32); err != nil {
+			return nil, 0, err
 		}
 	}
 
@@ -379,6 +526,12 @@ func DeserializeData(s []byte, uncompress bool) ([]byte, CompressionFormat, erro
 			return nil, 0, err
 		}
 		return buffer.Bytes(), compression, nil
+	case Zstd:
+		data, err := DecompressZstd(cdata)
+		if err != nil {
+			return nil, 0, err
+		}
+		return data, compression, nil
 	default:
 		return nil, 0, fmt.Errorf("Illegal compression format (%d) in deserialization", compression)
 	}
@@ -31,7 +31,7 @@ func (suite *DataSuite) TestSerialization(c *C) {
 		},
 	}
 
-	for _, format := range []CompressionFormat{Uncompressed, Snappy, LZ4, Gzip} {
+	for _, format := range []CompressionFormat{Uncompressed, Snappy, LZ4, Gzip, Zstd} {
 		for _, checksum := range []Checksum{NoChecksum, CRC32} {
 			compression, err := NewCompression(format, DefaultCompression)
 			c.Assert(err, IsNil)
@@ -75,6 +75,53 @@ func (suite *DataSuite) TestSerialization(c *C) {
 	}
 }
 
+func (suite *DataSuite) TestVerifyChecksum(c *C) {
+	compression, _ := NewCompression(LZ4, DefaultCompression)
+	s, err := SerializeData([]byte("some data to be checked"), compression, CRC32)
+	c.Assert(err, IsNil)
+	c.Assert(VerifyChecksum(s), IsNil)
+
+	numCorrupt := CorruptValues()
+	s[len(s)-1] ^= 0x01
+	err = VerifyChecksum(s)
+	_, ok := err.(*ChecksumError)
+	c.Assert(ok, Equals, true, Commentf("expected ChecksumError, got %v", err))
+	_, _, err = DeserializeData(s, true)
+	_, ok = err.(*ChecksumError)
+	c.Assert(ok, Equals, true, Commentf("expected ChecksumError, got %v", err))
+	c.Assert(CorruptValues(), Equals, numCorrupt+2)
+}
+
+func (suite *DataSuite) TestParseCompression(c *C) {
+	tests := []struct {
+		s      string
+		format CompressionFormat
+		level  CompressionLevel
+	}{
+		{"none", Uncompressed, DefaultCompression},
+		{"LZ4", LZ4, DefaultCompression},
+		{"gzip:7", Gzip, 7},
+		{"zstd", Zstd, DefaultCompression},
+		{"zstd:3", Zstd, 3},
+	}
+	for _, test := range tests {
+		compression, err := ParseCompression(test.s)
+		c.Assert(err, IsNil)
+		c.Assert(compression.Format(), Equals, test.format)
+		c.Assert(compression.Level(), Equals, test.level)
+	}
+	for _, bad := range []string{"zip", "gzip:11", "zstd:x", "zstd:23"} {
+		_, err := ParseCompression(bad)
+		c.Assert(err, NotNil, Commentf("no error for compression %q", bad))
+	}
+
+	checksum, err := ParseChecksum("crc32")
+	c.Assert(err, IsNil)
+	c.Assert(checksum, Equals, Checksum(CRC32))
+	_, err = ParseChecksum("md5")
+	c.Assert(err, NotNil)
+}
+
 func (suite *DataSuite) testUncompressed(b *testing.B, checksum Checksum) {
 	stringObj := "Hi there!"
 	var returnObj string
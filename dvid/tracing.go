@@ -0,0 +1,450 @@
+/*
+	This file implements distributed tracing compatible with OpenTelemetry.  Spans are
+	propagated through context.Context values and W3C "traceparent" headers, and finished
+	spans are exported in batches to an OTLP/HTTP endpoint using the JSON encoding, e.g.,
+	to an OpenTelemetry collector at "http://localhost:4318/v1/traces".
+*/
+
+package dvid
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceConfig sets the export of trace spans.
+type TraceConfig struct {
+	Endpoint    string            // OTLP/HTTP traces URL.  Tracing is off if empty.
+	ServiceName string            `toml:"service_name"` // "dvid" by default
+	SampleRate  float64           `toml:"sample_rate"`  // fraction of new traces recorded, 1 by default
+	Headers     map[string]string // extra HTTP headers, e.g., for collector authentication
+}
+
+// SpanKind is the OpenTelemetry kind of a span.
+type SpanKind int
+
+const (
+	SpanInternal SpanKind = 1
+	SpanServer   SpanKind = 2
+	SpanClient   SpanKind = 3
+)
+
+const (
+	traceBatchSize     = 512
+	traceQueueSize     = 8192
+	traceFlushInterval = 5 * time.Second
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// TraceSpan is a timed operation within a trace.  A nil TraceSpan, returned when tracing
+// is off or the trace isn't sampled, can be used but records nothing.
+type TraceSpan struct {
+	name   string
+	kind   SpanKind
+	sc     SpanContext
+	parent [8]byte
+	start  time.Time
+	end    time.Time
+	attrs  map[string]interface{}
+	err    error
+
+	mu    sync.Mutex
+	ended bool
+}
+
+type spanContextKey struct{}
+
+type tracer struct {
+	config TraceConfig
+	client *http.Client
+	queue  chan *TraceSpan
+	done   chan struct{}
+
+	mu  sync.Mutex
+	rnd *mathrand.Rand
+}
+
+var (
+	tracingMu     sync.RWMutex
+	activeTracer  *tracer
+	droppedSpans  uint64
+	droppedSpanMu sync.Mutex
+)
+
+// StartTracing starts exporting spans to the configured endpoint.  It does nothing if no
+// endpoint is given.
+func StartTracing(config TraceConfig) error {
+	if config.Endpoint == "" {
+		return nil
+	}
+	if !strings.HasPrefix(config.Endpoint, "http://") && !strings.HasPrefix(config.Endpoint, "https://") {
+		return fmt.Errorf("tracing endpoint %q must be a http or https URL", config.Endpoint)
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return fmt.Errorf("tracing sample_rate %g must be between 0 and 1", config.SampleRate)
+	}
+	if config.SampleRate == 0 {
+		config.SampleRate = 1
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = "dvid"
+	}
+	t := &tracer{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan *TraceSpan, traceQueueSize),
+		done:   make(chan struct{}),
+		rnd:    mathrand.New(mathrand.NewSource(time.Now().UnixNano())),
+	}
+	StopTracing()
+	tracingMu.Lock()
+	activeTracer = t
+	tracingMu.Unlock()
+	go t.export()
+	Infof("Exporting trace spans to %s with sample rate %g\n", config.Endpoint, config.SampleRate)
+	return nil
+}
+
+// StopTracing stops recording spans and exports any that are queued.
+func StopTracing() {
+	tracingMu.Lock()
+	t := activeTracer
+	activeTracer = nil
+	tracingMu.Unlock()
+	if t != nil {
+		close(t.queue)
+		<-t.done
+	}
+}
+
+// TracingEnabled returns true if spans are being exported.
+func TracingEnabled() bool {
+	tracingMu.RLock()
+	defer tracingMu.RUnlock()
+	return activeTracer != nil
+}
+
+func getTracer() *tracer {
+	tracingMu.RLock()
+	defer tracingMu.RUnlock()
+	return activeTracer
+}
+
+// SpanContextFromContext returns the span context in a context.Context, if any.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// ContextWithTraceParent returns a context with the remote parent span given by a W3C
+// "traceparent" header value, e.g., "00-<trace id>-<span id>-01".  The context is returned
+// unchanged if the value is malformed.
+func ContextWithTraceParent(ctx context.Context, traceparent string) context.Context {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || sc.TraceID == [16]byte{} || sc.SpanID == [8]byte{} {
+		return ctx
+	}
+	sc.Sampled = flags&1 == 1
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// TraceParent returns the W3C "traceparent" header value for the span in a context, or ""
+// if there is none.
+func TraceParent(ctx context.Context) string {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// StartSpan starts a span that is a child of any span in the context, returning a context
+// holding the new span.  If tracing is off, the context is returned with a nil span.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *TraceSpan) {
+	t := getTracer()
+	if t == nil {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	parent, hasParent := SpanContextFromContext(ctx)
+	var sc SpanContext
+	if hasParent {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		rand.Read(sc.TraceID[:])
+		sc.Sampled = t.sample()
+	}
+	rand.Read(sc.SpanID[:])
+	ctx = context.WithValue(ctx, spanContextKey{}, sc)
+	if !sc.Sampled {
+		return ctx, nil
+	}
+	s := &TraceSpan{
+		name:  name,
+		kind:  kind,
+		sc:    sc,
+		start: time.Now(),
+	}
+	if hasParent {
+		s.parent = parent.SpanID
+	}
+	return ctx, s
+}
+
+// RecordSpan records a finished span that started at the given time and is a child of
+// any sampled span in the context, e.g., for operations timed without a context.
+func RecordSpan(ctx context.Context, name string, start time.Time, err error, attrs map[string]interface{}) {
+	parent, ok := SpanContextFromContext(ctx)
+	if !ok || !parent.Sampled {
+		return
+	}
+	_, s := StartSpan(ctx, name, SpanInternal)
+	if s == nil {
+		return
+	}
+	s.start = start
+	s.attrs = attrs
+	s.End(err)
+}
+
+func (t *tracer) sample() bool {
+	if t.config.SampleRate >= 1 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rnd.Float64() < t.config.SampleRate
+}
+
+// SetAttribute sets an attribute of the span, which can be a string, bool, integer, or
+// float.
+func (s *TraceSpan) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	s.attrs[key] = value
+}
+
+// End finishes the span, marking it as failed if the error is non-nil, and queues it for
+// export.  Only the first call has an effect.
+func (s *TraceSpan) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.err = err
+	s.mu.Unlock()
+
+	tracingMu.RLock()
+	defer tracingMu.RUnlock()
+	if activeTracer == nil {
+		return
+	}
+	select {
+	case activeTracer.queue <- s:
+	default:
+		droppedSpanMu.Lock()
+		droppedSpans++
+		droppedSpanMu.Unlock()
+	}
+}
+
+// DroppedSpans returns the number of finished spans dropped because the export queue
+// was full.
+func DroppedSpans() uint64 {
+	droppedSpanMu.Lock()
+	defer droppedSpanMu.Unlock()
+	return droppedSpans
+}
+
+func (t *tracer) export() {
+	defer close(t.done)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	batch := make([]*TraceSpan, 0, traceBatchSize)
+	for {
+		select {
+		case s, ok := <-t.queue:
+			if !ok {
+				t.send(batch)
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) < traceBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		t.send(batch)
+		batch = batch[:0]
+	}
+}
+
+// OTLP JSON encoding of spans.
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         SpanKind        `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+func otlpAttributeOf(key string, value interface{}) otlpAttribute {
+	var v otlpValue
+	switch x := value.(type) {
+	case string:
+		v.StringValue = &x
+	case bool:
+		v.BoolValue = &x
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		s := fmt.Sprintf("%d", x)
+		v.IntValue = &s
+	case float32:
+		f := float64(x)
+		v.DoubleValue = &f
+	case float64:
+		v.DoubleValue = &x
+	default:
+		s := fmt.Sprintf("%v", x)
+		v.StringValue = &s
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
+
+func (s *TraceSpan) otlp() otlpSpan {
+	out := otlpSpan{
+		TraceID: hex.EncodeToString(s.sc.TraceID[:]),
+		SpanID:  hex.EncodeToString(s.sc.SpanID[:]),
+		Name:    s.name,
+		Kind:    s.kind,
+		Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+		End:     strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parent != [8]byte{} {
+		out.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	for key, value := range s.attrs {
+		out.Attributes = append(out.Attributes, otlpAttributeOf(key, value))
+	}
+	if s.err != nil {
+		out.Status = otlpStatus{Code: 2, Message: s.err.Error()}
+	}
+	return out
+}
+
+func (t *tracer) send(batch []*TraceSpan) {
+	if len(batch) == 0 {
+		return
+	}
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		spans[i] = s.otlp()
+	}
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{otlpAttributeOf("service.name", t.config.ServiceName)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "dvid"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		Errorf("Unable to encode %d trace spans: %v\n", len(batch), err)
+		return
+	}
+	req, err := http.NewRequest("POST", t.config.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		Errorf("Unable to export trace spans: %v\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		Errorf("Unable to export %d trace spans to %s: %v\n", len(batch), t.config.Endpoint, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		Errorf("Trace collector %s returned status %d for %d spans\n", t.config.Endpoint, resp.StatusCode, len(batch))
+	}
+}
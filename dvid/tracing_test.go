@@ -0,0 +1,80 @@
+package dvid
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("bad OTLP payload: %v\n", err)
+		}
+		received <- payload
+	}))
+	defer collector.Close()
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := ContextWithTraceParent(context.Background(), parent)
+	if tp := TraceParent(ctx); tp != parent {
+		t.Fatalf("expected traceparent %q, got %q\n", parent, tp)
+	}
+	if _, found := SpanContextFromContext(ContextWithTraceParent(context.Background(), "00-bad-00f067aa0ba902b7-01")); found {
+		t.Fatalf("expected malformed traceparent to be ignored\n")
+	}
+
+	// Spans aren't recorded until tracing is started.
+	if _, span := StartSpan(ctx, "before", SpanServer); span != nil {
+		t.Fatalf("expected no span without tracing\n")
+	}
+
+	if err := StartTracing(TraceConfig{Endpoint: collector.URL, ServiceName: "dvid-test"}); err != nil {
+		t.Fatalf("couldn't start tracing: %v\n", err)
+	}
+	spanCtx, span := StartSpan(ctx, "GET /api/node/:uuid/:dataname/raw", SpanServer)
+	span.SetAttribute("http.status_code", 200)
+	if tp := TraceParent(spanCtx); tp[:36] != parent[:36] || tp == parent {
+		t.Errorf("expected child span in trace of %q, got %q\n", parent, tp)
+	}
+	RecordSpan(spanCtx, "storage range", span.start, nil, map[string]interface{}{"dvid.bytes_out": 42})
+	span.End(nil)
+	StopTracing()
+
+	payload := <-received
+	data, _ := json.Marshal(payload)
+	var export struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Kind         int    `json:"kind"`
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		t.Fatalf("bad OTLP payload: %v\n", err)
+	}
+	spans := export.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans exported, got %d: %s\n", len(spans), string(data))
+	}
+	storageSpan, serverSpan := spans[0], spans[1]
+	if serverSpan.Name != "GET /api/node/:uuid/:dataname/raw" || serverSpan.Kind != int(SpanServer) {
+		t.Errorf("bad server span: %v\n", serverSpan)
+	}
+	if serverSpan.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || serverSpan.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("server span not in remote parent's trace: %v\n", serverSpan)
+	}
+	if storageSpan.Name != "storage range" || storageSpan.ParentSpanID != serverSpan.SpanID || storageSpan.TraceID != serverSpan.TraceID {
+		t.Errorf("bad storage span: %v\n", storageSpan)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+	This file supports Zstandard compression, including shared dictionaries that
+	greatly improve compression of small, similar values like label blocks.
+*/
+
+package dvid
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultZstdDictSize is the default maximum size of a trained dictionary, which
+// matches the zstd command line default.
+const DefaultZstdDictSize = 112640
+
+// zstdDictMagic is the first 4 bytes of a zstd dictionary.
+const zstdDictMagic = 0xEC30A437
+
+type zstdEncoderKey struct {
+	level CompressionLevel
+	dict  uint32
+}
+
+var (
+	zstdMu       sync.RWMutex
+	zstdDicts    = make(map[uint32][]byte)
+	zstdEncoders = make(map[zstdEncoderKey]*zstd.Encoder)
+	zstdDecoder  *zstd.Decoder
+)
+
+// ZstdDictID returns the dictionary ID stored in a zstd dictionary.
+func ZstdDictID(dict []byte) (uint32, error) {
+	if len(dict) < 8 || binary.LittleEndian.Uint32(dict[0:4]) != zstdDictMagic {
+		return 0, fmt.Errorf("data is not a zstd dictionary")
+	}
+	id := binary.LittleEndian.Uint32(dict[4:8])
+	if id == 0 {
+		return 0, fmt.Errorf("zstd dictionary has reserved ID 0")
+	}
+	return id, nil
+}
+
+// RegisterZstdDict makes a dictionary available for compression and decompression and
+// returns its ID.  Dictionaries must be registered before deserializing any data that
+// was compressed with them.
+func RegisterZstdDict(dict []byte) (uint32, error) {
+	id, err := ZstdDictID(dict)
+	if err != nil {
+		return 0, err
+	}
+	zstdMu.Lock()
+	defer zstdMu.Unlock()
+	if _, found := zstdDicts[id]; found {
+		return id, nil
+	}
+	zstdDicts[id] = dict
+
+	// Force recreation of the decoder with the new dictionary.  The old decoder isn't
+	// closed since it may still be in use.
+	zstdDecoder = nil
+	return id, nil
+}
+
+// ZstdDicts returns all registered zstd dictionaries keyed by ID.
+func ZstdDicts() map[uint32][]byte {
+	zstdMu.RLock()
+	defer zstdMu.RUnlock()
+	dicts := make(map[uint32][]byte, len(zstdDicts))
+	for id, dict := range zstdDicts {
+		dicts[id] = dict
+	}
+	return dicts
+}
+
+// TrainZstdDict builds a zstd dictionary of at most maxSize bytes from sample values.
+// The samples should be uncompressed and representative of the data to be compressed.
+func TrainZstdDict(samples [][]byte, maxSize int) ([]byte, error) {
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no samples given for zstd dictionary training")
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultZstdDictSize
+	}
+
+	// The dictionary content is taken from the samples, and the ID is derived from them
+	// while avoiding the range reserved for registered dictionaries.
+	var history []byte
+	crc := crc32.NewIEEE()
+	for _, sample := range samples {
+		crc.Write(sample)
+		if len(history) < maxSize {
+			n := maxSize - len(history)
+			if n > len(sample) {
+				n = len(sample)
+			}
+			history = append(history, sample[:n]...)
+		}
+	}
+	id := 32768 + crc.Sum32()%(1<<31-32768)
+	return zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: samples,
+		History:  history,
+		Offsets:  [3]int{1, 4, 8},
+	})
+}
+
+func zstdEncoder(level CompressionLevel, dict uint32) (*zstd.Encoder, error) {
+	key := zstdEncoderKey{level, dict}
+	zstdMu.RLock()
+	enc, found := zstdEncoders[key]
+	zstdMu.RUnlock()
+	if found {
+		return enc, nil
+	}
+
+	zstdMu.Lock()
+	defer zstdMu.Unlock()
+	if enc, found = zstdEncoders[key]; found {
+		return enc, nil
+	}
+	opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if level != DefaultCompression {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(int(level))))
+	}
+	if dict != 0 {
+		d, found := zstdDicts[dict]
+		if !found {
+			return nil, fmt.Errorf("zstd dictionary %d has not been registered", dict)
+		}
+		opts = append(opts, zstd.WithEncoderDict(d))
+	}
+	enc, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoders[key] = enc
+	return enc, nil
+}
+
+func compressZstd(data []byte, level CompressionLevel, dict uint32) ([]byte, error) {
+	enc, err := zstdEncoder(level, dict)
+	if err != nil {
+		return nil, err
+	}
+	return enc.EncodeAll(data, nil), nil
+}
+
+// DecompressZstd decompresses zstd data using any registered dictionary.
+func DecompressZstd(data []byte) ([]byte, error) {
+	zstdMu.RLock()
+	dec := zstdDecoder
+	zstdMu.RUnlock()
+	if dec == nil {
+		zstdMu.Lock()
+		if zstdDecoder == nil {
+			var dicts [][]byte
+			for _, dict := range zstdDicts {
+				dicts = append(dicts, dict)
+			}
+			var err error
+			zstdDecoder, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderDicts(dicts...))
+			if err != nil {
+				zstdMu.Unlock()
+				return nil, err
+			}
+		}
+		dec = zstdDecoder
+		zstdMu.Unlock()
+	}
+	return dec.DecodeAll(data, nil)
+}
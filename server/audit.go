@@ -0,0 +1,262 @@
+/*
+	This file implements the audit log of mutating HTTP requests and commands, which is
+	enabled by the [audit] configuration.  Each record gives the time, user, route, repo
+	and data instance, a digest of the payload, and the result of the request, and the
+	log can be queried by admins via /api/server/audit.
+*/
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+
+	"github.com/zenazn/goji/web"
+	"github.com/zenazn/goji/web/mutil"
+)
+
+type auditConfig struct {
+	Enabled bool // record mutating requests in the audit log.
+}
+
+// auditing is true if mutating requests are recorded in the audit log.
+var auditing bool
+
+// SetAuditing sets whether mutating HTTP requests and commands are recorded in the audit
+// log.
+func SetAuditing(on bool) {
+	auditing = on
+}
+
+// mutatingMethod returns true if requests with the HTTP method can change data.
+func mutatingMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	return true
+}
+
+type auditBatchKey struct{}
+
+// auditBatch holds the audit records of the sub-requests of an atomic batch request until
+// the batch is committed or rolled back.
+type auditBatch struct {
+	sync.Mutex
+	records []datastore.AuditRecord
+}
+
+func (ab *auditBatch) add(rec datastore.AuditRecord) {
+	ab.Lock()
+	ab.records = append(ab.records, rec)
+	ab.Unlock()
+}
+
+// record records the held audit records.  If the batch was rolled back, records of
+// sub-requests that succeeded are given the status of the batch and the rollback reason.
+func (ab *auditBatch) record(status int, rollback string) {
+	ab.Lock()
+	defer ab.Unlock()
+	for _, rec := range ab.records {
+		if rollback != "" && rec.Status < 300 {
+			rec.Status = status
+			rec.Error = "rolled back: " + rollback
+		}
+		recordAudit(rec)
+	}
+	ab.records = nil
+}
+
+func recordAudit(rec datastore.AuditRecord) {
+	if err := datastore.RecordAudit(rec); err != nil {
+		dvid.Errorf("Unable to record audit of %s %s: %v\n", rec.Method, rec.Route, err)
+	}
+}
+
+// digestReader hashes and counts the bytes read from a request body.
+type digestReader struct {
+	io.ReadCloser
+	h hash.Hash
+	n int64
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	d.h.Write(p[:n])
+	d.n += int64(n)
+	return n, err
+}
+
+func (d *digestReader) digest() string {
+	if d.n == 0 {
+		return ""
+	}
+	return "sha256:" + hex.EncodeToString(d.h.Sum(nil))
+}
+
+// auditHandler is middleware that records mutating requests in the audit log when
+// auditing is enabled.  The payload digest covers the request body bytes read while
+// handling the request, which is the whole body unless the request was refused early.
+// Records of sub-requests of an atomic batch are held until the batch is resolved.
+func auditHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !auditing || !mutatingMethod(r.Method) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if c.Env == nil {
+			c.Env = make(map[interface{}]interface{})
+		}
+		body := &digestReader{h: sha256.New()}
+		if r.Body != nil {
+			body.ReadCloser = r.Body
+			r.Body = body
+		}
+		start := time.Now()
+		ww := mutil.WrapWriter(w)
+		h.ServeHTTP(ww, r)
+
+		rec := datastore.AuditRecord{
+			Time:   start,
+			User:   RequestUser(*c),
+			Method: r.Method,
+			Route:  r.URL.Path,
+			Digest: body.digest(),
+			Bytes:  body.n,
+			Status: ww.Status(),
+		}
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+		if uuid, ok := c.Env["uuid"].(dvid.UUID); ok {
+			rec.UUID = uuid
+			rec.Repo, _ = datastore.GetRepoRoot(uuid)
+		}
+		if data, ok := c.Env["data"].(dvid.Data); ok {
+			rec.Instance = data.DataName()
+		}
+		if ab, ok := r.Context().Value(auditBatchKey{}).(*auditBatch); ok {
+			ab.add(rec)
+			return
+		}
+		recordAudit(rec)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// auditCommand records a mutating command and its result in the audit log when auditing
+// is enabled.  The route is the command and its subcommand, e.g., "repos new", since
+// later arguments can include passcodes, while the digest covers all arguments and input.
+func auditCommand(cmd *datastore.Request, start time.Time, cmdErr error) {
+	if !auditing {
+		return
+	}
+	if rpcScope(cmd) == "" {
+		return
+	}
+	route := cmd.Name()
+	switch cmd.Name() {
+	case "repo", "node":
+		route += " " + cmd.Argument(2)
+	default:
+		if sub := cmd.Argument(1); sub != "" {
+			route += " " + sub
+		}
+	}
+	h := sha256.New()
+	io.WriteString(h, strings.Join(cmd.Command, "\x00"))
+	h.Write(cmd.Input)
+	rec := datastore.AuditRecord{
+		Time:   start,
+		Method: "RPC",
+		Route:  route,
+		Digest: "sha256:" + hex.EncodeToString(h.Sum(nil)),
+		Bytes:  int64(len(cmd.Input)),
+		Status: http.StatusOK,
+	}
+	if cmd.Token != "" {
+		rec.User, _, _ = authenticate(cmd.Token)
+	}
+	if uuidStr, _ := rpcRepoRole(cmd); uuidStr != "" {
+		if uuid, _, err := datastore.MatchingUUID(uuidStr); err == nil {
+			rec.UUID = uuid
+			rec.Repo, _ = datastore.GetRepoRoot(uuid)
+		}
+	}
+	if cmdErr != nil {
+		rec.Status = http.StatusBadRequest
+		rec.Error = cmdErr.Error()
+	}
+	if err := datastore.RecordAudit(rec); err != nil {
+		dvid.Errorf("Unable to record audit of command %q: %v\n", route, err)
+	}
+}
+
+// parseAuditQuery returns the audit log query given by a request's query string.
+func parseAuditQuery(r *http.Request) (datastore.AuditQuery, error) {
+	query := r.URL.Query()
+	q := datastore.AuditQuery{
+		User:     query.Get("user"),
+		Repo:     dvid.UUID(query.Get("repo")),
+		Instance: dvid.InstanceName(query.Get("instance")),
+	}
+	var err error
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		if s := query.Get(param.name); s != "" {
+			if *param.value, err = time.Parse(time.RFC3339, s); err != nil {
+				return q, fmt.Errorf("bad %s %q: must be an RFC 3339 time", param.name, s)
+			}
+		}
+	}
+	if s := query.Get("cursor"); s != "" {
+		if q.After, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return q, fmt.Errorf("bad cursor %q: must be the ID of an audit record", s)
+		}
+	}
+	if s := query.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("bad limit %q: must be a non-negative integer", s)
+		}
+	}
+	if q.Repo != "" {
+		if root, err := datastore.GetRepoRoot(q.Repo); err == nil {
+			q.Repo = root
+		}
+	}
+	return q, nil
+}
+
+func serverAuditHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	records, err := datastore.QueryAudit(q)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	if q.Limit > 0 && len(records) == q.Limit {
+		SetNextPage(w, r, strconv.FormatUint(records[len(records)-1].ID, 10))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		BadRequest(w, r, err)
+	}
+}
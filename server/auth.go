@@ -0,0 +1,580 @@
+/*
+	This file implements API token authentication.  When tokens are required, mutating
+	HTTP requests and RPC commands must give a server-managed API token or a JWT from the
+	configured OIDC issuer with the needed scope, e.g., via an "Authorization: Bearer
+	<token>" header.
+
+	Scopes can be limited to the repos of a namespace, e.g., "write:lab1".  Such scopes
+	are checked against the namespace named by /api/ns/{ns} requests or, for requests on
+	a repo or node, the namespace of the repo.  Reading repos of a private namespace
+	requires a scope for the namespace.
+
+	Tokens are managed only with an admin token, whether or not tokens are required,
+	since admin tokens have all roles in every repo.  Until an admin token exists, the
+	first tokens can be created from localhost or by giving the configured bootstrap
+	secret as the bearer token.
+*/
+
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+
+	"github.com/zenazn/goji/web"
+)
+
+type authConfig struct {
+	RequireTokens bool `toml:"require_tokens"` // require API tokens on mutating requests
+
+	// BootstrapSecret can be given as the bearer token to create tokens from clients other
+	// than localhost until an admin token exists.
+	BootstrapSecret string `toml:"bootstrap_secret"`
+
+	OIDC oidcConfig `toml:"oidc"` // accept JWTs from an OIDC issuer as tokens
+}
+
+// requireTokens is true if mutating requests must give an API token.
+var requireTokens bool
+
+// SetRequireTokens sets whether mutating HTTP requests and RPC commands require an API
+// token with the needed scope.
+func SetRequireTokens(on bool) {
+	requireTokens = on
+}
+
+// bootstrapSecret, if set, allows creation of the first admin token by remote clients.
+var bootstrapSecret string
+
+// SetBootstrapSecret sets the secret that can be given instead of an admin token to create
+// tokens until an admin token exists.  An empty secret allows this only from localhost.
+func SetBootstrapSecret(secret string) {
+	bootstrapSecret = secret
+}
+
+// bootstrapUser is the user of requests that create tokens before an admin token exists.
+const bootstrapUser = "bootstrap"
+
+// tokenManagerError is returned when a request to manage tokens isn't made with an admin
+// token.  The user is "" if no valid token was given.
+type tokenManagerError struct {
+	user string
+}
+
+func (e *tokenManagerError) Error() string {
+	if e.user == "" {
+		return fmt.Sprintf("managing API tokens requires a token with %q scope", datastore.ScopeAdmin)
+	}
+	return fmt.Sprintf("token for %q doesn't have %q scope needed to manage API tokens", e.user, datastore.ScopeAdmin)
+}
+
+// isLocalAddr returns true if the network address, with or without a port, is a
+// loopback address.
+func isLocalAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authorizeTokenManagement returns the user and scopes of an admin token given to manage
+// tokens, or a *tokenManagerError if the token isn't an admin token.  Until an admin
+// token exists, tokens can also be created from localhost or with the bootstrap secret.
+func authorizeTokenManagement(token string, creating, local bool) (user string, scopes []string, err error) {
+	isSecret := bootstrapSecret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(bootstrapSecret)) == 1
+	if token != "" && !isSecret {
+		if user, scopes, err = authenticate(token); err != nil {
+			return "", nil, &tokenManagerError{}
+		}
+		if !hasScope(scopes, datastore.ScopeAdmin) {
+			return "", nil, &tokenManagerError{user: user}
+		}
+		return user, scopes, nil
+	}
+	if creating && (local || isSecret) {
+		haveAdmin, err := datastore.HasAdminToken()
+		if err != nil {
+			return "", nil, err
+		}
+		if !haveAdmin {
+			return bootstrapUser, nil, nil
+		}
+	}
+	return "", nil, &tokenManagerError{}
+}
+
+// requiredScope returns the API token scope needed for a HTTP request, or "" if the
+// request can be made without a token.
+func requiredScope(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/api/server/tokens") || strings.HasPrefix(r.URL.Path, "/api/server/audit") {
+		return datastore.ScopeAdmin
+	}
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return ""
+	}
+	if r.URL.Path == "/api/batch" {
+		return "" // each sub-request is authorized.
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/server/") || strings.HasPrefix(r.URL.Path, "/api/storage/") {
+		return datastore.ScopeAdmin
+	}
+	return datastore.ScopeWrite
+}
+
+// bearerToken returns the token in a request's "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// authenticate returns the user and scopes of an API token or, if OIDC is configured, a
+// JWT.  The user of an API token is its principal, e.g., "token:8f3a1c0e2b4d6f70".
+func authenticate(token string) (user string, scopes []string, err error) {
+	if isJWT(token) && oidcVerifier != nil {
+		return oidcVerifier.authenticate(token)
+	}
+	t, err := datastore.CheckAPIToken(token, "")
+	if err != nil {
+		return "", nil, err
+	}
+	return datastore.APITokenPrincipal(t.ID), t.Scopes, nil
+}
+
+// hasScope returns true if the scopes include the given scope or the admin scope.
+func hasScope(scopes []string, scope string) bool {
+	return datastore.APIToken{Scopes: scopes}.HasScope(scope)
+}
+
+// hasNamespaceScope returns true if the scopes include the given scope, or a greater one,
+// for all repos or those of the namespace.
+func hasNamespaceScope(scopes []string, scope, namespace string) bool {
+	return datastore.APIToken{Scopes: scopes}.HasNamespaceScope(scope, namespace)
+}
+
+// requestNamespace returns the namespace of a /api/ns/{ns} request and whether the
+// request is on a repo or node, whose namespace is checked by authorizeRepo.
+func requestNamespace(r *http.Request) (namespace string, repoRequest bool) {
+	if strings.HasPrefix(r.URL.Path, "/api/ns/") {
+		namespace = strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/ns/"), "/", 2)[0]
+		return namespace, false
+	}
+	return "", strings.HasPrefix(r.URL.Path, "/api/repo/") || strings.HasPrefix(r.URL.Path, "/api/node/")
+}
+
+// RequestUser returns the user authenticated by a request's bearer token, e.g., the
+// email in a JWT or the principal of an API token, or "" if the request gave no valid
+// token.
+func RequestUser(c web.C) string {
+	user, _ := c.Env["user"].(string)
+	return user
+}
+
+// authHandler is middleware that refuses requests without a token of the needed scope
+// when tokens are required.  The user of any valid token is set in the "user" env and
+// its scopes in the "scopes" env.  If tokens aren't required, invalid tokens are ignored.
+// Requests on repos and nodes with a scope limited to namespaces have the needed scope
+// set in the "namespaceScope" env for authorizeRepo to check against the repo's namespace.
+// Token management requests always require an admin token, as authorizeTokenManagement.
+func authHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if c.Env == nil {
+			c.Env = make(map[interface{}]interface{})
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/server/tokens") {
+			user, scopes, err := authorizeTokenManagement(bearerToken(r), r.Method == "POST", isLocalAddr(r.RemoteAddr))
+			if err != nil {
+				if tmErr, ok := err.(*tokenManagerError); ok && tmErr.user != "" {
+					http.Error(w, err.Error(), http.StatusForbidden)
+				} else if ok {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+					http.Error(w, err.Error(), http.StatusUnauthorized)
+				} else {
+					BadRequest(w, r, err)
+				}
+				return
+			}
+			c.Env["user"] = user
+			c.Env["scopes"] = scopes
+			h.ServeHTTP(w, r)
+			return
+		}
+		scope := requiredScope(r)
+		enforce := requireTokens && scope != ""
+		token := bearerToken(r)
+		if token == "" {
+			if enforce {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, fmt.Sprintf("API token with %q scope required", scope), http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		user, scopes, err := authenticate(token)
+		if err != nil {
+			if enforce {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		if enforce && !hasScope(scopes, scope) {
+			namespace, repoRequest := requestNamespace(r)
+			switch {
+			case namespace != "" && hasNamespaceScope(scopes, scope, namespace):
+			case repoRequest && (datastore.APIToken{Scopes: scopes}).HasAnyNamespaceScope(scope):
+				c.Env["namespaceScope"] = scope
+			default:
+				http.Error(w, fmt.Sprintf("token for %q doesn't have %q scope", user, scope), http.StatusForbidden)
+				return
+			}
+		}
+		c.Env["user"] = user
+		c.Env["scopes"] = scopes
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// authorizeRepo returns true if the request's user has the needed role in the repo with
+// the given UUID and any scope needed for the repo's namespace, and otherwise writes an
+// error.  Users with the admin scope have all roles.
+func authorizeRepo(c *web.C, w http.ResponseWriter, r *http.Request, uuid dvid.UUID, needed datastore.Role) bool {
+	scopes, _ := c.Env["scopes"].([]string)
+	if hasScope(scopes, datastore.ScopeAdmin) {
+		return true
+	}
+	user := RequestUser(*c)
+	scope, _ := c.Env["namespaceScope"].(string)
+	if err := checkNamespaceScope(uuid, scopes, scope); err != nil {
+		if _, ok := err.(*namespaceScopeError); !ok {
+			BadRequest(w, r, err)
+		} else if user == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		}
+		return false
+	}
+	err := datastore.CheckRepoRole(uuid, needed, user)
+	if err == nil {
+		return true
+	}
+	if _, ok := err.(*datastore.RepoAccessError); ok {
+		if user == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		}
+		return false
+	}
+	BadRequest(w, r, err)
+	return false
+}
+
+// namespaceScopeError is returned when a token lacks the scope needed for the namespace
+// of a repo.
+type namespaceScopeError struct {
+	uuid      dvid.UUID
+	namespace string
+	scope     string
+}
+
+func (e *namespaceScopeError) Error() string {
+	return fmt.Sprintf("repo %s is in namespace %q, which requires a token with %q scope", e.uuid, e.namespace,
+		datastore.NamespaceScope(e.scope, e.namespace))
+}
+
+// checkNamespaceScope returns a *namespaceScopeError if the scopes don't include the
+// given scope, if any, for the namespace of the repo with the given UUID, or the read
+// scope if the namespace is private.
+func checkNamespaceScope(uuid dvid.UUID, scopes []string, scope string) error {
+	namespace, err := datastore.RepoNamespace(uuid)
+	if err != nil {
+		return err
+	}
+	if scope == "" && namespace != "" {
+		if config, err := datastore.GetNamespace(namespace); err == nil && config.Private {
+			scope = datastore.ScopeRead
+		}
+	}
+	if scope == "" || hasNamespaceScope(scopes, scope, namespace) {
+		return nil
+	}
+	return &namespaceScopeError{uuid: uuid, namespace: namespace, scope: scope}
+}
+
+// repoActionRole returns the role needed for a /api/repo/{uuid}/{action} request.
+func repoActionRole(action, method string) datastore.Role {
+	switch action {
+	case "roles":
+		return datastore.RoleAdmin
+	case "properties", "quota", "autobranch":
+		if method != "GET" && method != "HEAD" {
+			return datastore.RoleAdmin
+		}
+	}
+	if method == "GET" || method == "HEAD" {
+		return datastore.RoleRead
+	}
+	return datastore.RoleWrite
+}
+
+func getTokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := datastore.APITokens()
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
+func postTokensHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		BadRequest(w, r, "malformed JSON request in body: %v", err)
+		return
+	}
+	token, info, err := datastore.CreateAPIToken(req.Name, req.Scopes)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	dvid.Infof("Created API token %s (%s) with scopes %v\n", info.ID, info.Name, info.Scopes)
+	resp := struct {
+		datastore.APIToken
+		Token string
+	}{info, token}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
+func deleteTokenHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	id := c.URLParams["id"]
+	if err := datastore.RevokeAPIToken(id); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	dvid.Infof("Revoked API token %s\n", id)
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"revoked": %q}`, id)
+}
+
+// rpcScope returns the API token scope needed for a RPC command, or "" if the command
+// can be run without a token.
+func rpcScope(cmd *datastore.Request) string {
+	switch cmd.Name() {
+	case "help", "types":
+		return ""
+	case "repo", "node":
+		return datastore.ScopeWrite
+	case "repos":
+		if cmd.Argument(1) == "new" {
+			return datastore.ScopeWrite
+		}
+	}
+	return datastore.ScopeAdmin
+}
+
+// rpcRepoRole returns the repo UUID string and role needed by a RPC command, or "" if the
+// command doesn't target a repo.
+func rpcRepoRole(cmd *datastore.Request) (string, datastore.Role) {
+	switch cmd.Name() {
+	case "node":
+		return cmd.Argument(1), datastore.RoleWrite
+	case "repo":
+		switch cmd.Argument(2) {
+		case "delete", "rename", "restore":
+			return cmd.Argument(1), datastore.RoleAdmin
+		}
+		return cmd.Argument(1), datastore.RoleWrite
+	case "repos":
+		switch cmd.Argument(1) {
+		case "delete", "deleteversion":
+			return cmd.Argument(2), datastore.RoleAdmin
+		}
+	}
+	return "", datastore.RoleNone
+}
+
+// rpcNamespace returns the namespace of the repo targeted by a RPC command or the
+// namespace of a new repo, and whether the command targets a namespace.
+func rpcNamespace(cmd *datastore.Request) (string, bool) {
+	if cmd.Name() == "repos" && cmd.Argument(1) == "new" {
+		namespace, _, err := cmd.Settings().GetString("namespace")
+		return namespace, err == nil && namespace != ""
+	}
+	uuidStr, _ := rpcRepoRole(cmd)
+	if uuidStr == "" {
+		return "", false
+	}
+	uuid, _, err := datastore.MatchingUUID(uuidStr)
+	if err != nil {
+		return "", false
+	}
+	namespace, err := datastore.RepoNamespace(uuid)
+	return namespace, err == nil
+}
+
+// checkRPCToken returns an error if the command doesn't give a token with the needed
+// scope, either for all repos or the namespace of the command's repo, when tokens are
+// required or a token is given, or if the command's user doesn't have the needed role in
+// a restricted repo.  The "tokens" command always needs an admin token, except that the
+// first tokens can be created as allowed by authorizeTokenManagement for a client at the
+// given address.
+func checkRPCToken(cmd *datastore.Request, clientAddr string) error {
+	if cmd.Name() == "tokens" {
+		_, _, err := authorizeTokenManagement(cmd.Token, cmd.Argument(1) == "create", isLocalAddr(clientAddr))
+		return err
+	}
+	var user string
+	var scopes []string
+	var authErr error
+	if cmd.Token != "" {
+		user, scopes, authErr = authenticate(cmd.Token)
+	}
+	if requireTokens || cmd.Token != "" {
+		if scope := rpcScope(cmd); scope != "" {
+			if cmd.Token == "" {
+				return fmt.Errorf("command %q requires an API token with %q scope", cmd.Name(), scope)
+			}
+			if authErr != nil {
+				return authErr
+			}
+			if !hasScope(scopes, scope) {
+				namespace, found := rpcNamespace(cmd)
+				if !found || !hasNamespaceScope(scopes, scope, namespace) {
+					return fmt.Errorf("token for %q doesn't have %q scope needed by command %q", user, scope, cmd.Name())
+				}
+			}
+		}
+	}
+	if hasScope(scopes, datastore.ScopeAdmin) {
+		return nil
+	}
+	uuidStr, role := rpcRepoRole(cmd)
+	if uuidStr == "" {
+		return nil
+	}
+	uuid, _, err := datastore.MatchingUUID(uuidStr)
+	if err != nil {
+		return nil // the command reports bad UUIDs
+	}
+	return datastore.CheckRepoRole(uuid, role, user)
+}
+
+// filterRepos removes the repos the request's user can't read, including those in private
+// namespaces without a scope for them, from JSON of repos keyed by root UUID.
+func filterRepos(c web.C, jsonBytes []byte) ([]byte, error) {
+	if scopes, _ := c.Env["scopes"].([]string); hasScope(scopes, datastore.ScopeAdmin) {
+		return jsonBytes, nil
+	}
+	var repos map[dvid.UUID]json.RawMessage
+	if err := json.Unmarshal(jsonBytes, &repos); err != nil {
+		return nil, err
+	}
+	user := RequestUser(c)
+	scopes, _ := c.Env["scopes"].([]string)
+	for uuid := range repos {
+		if err := checkNamespaceScope(uuid, scopes, ""); err != nil {
+			delete(repos, uuid)
+		} else if err := datastore.CheckRepoRole(uuid, datastore.RoleRead, user); err != nil {
+			delete(repos, uuid)
+		}
+	}
+	return json.Marshal(repos)
+}
+
+func getRepoRolesHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	roles, err := datastore.GetRepoRoles(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(roles); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
+func postRepoRolesHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	var roles map[string]datastore.Role
+	if err := json.NewDecoder(r.Body).Decode(&roles); err != nil {
+		BadRequest(w, r, "malformed JSON request in body: %v", err)
+		return
+	}
+	for principal, role := range roles {
+		if err := datastore.SetRepoRole(uuid, principal, role); err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+		dvid.Infof("Assigned role %s in repo %s to %q\n", role, uuid, principal)
+	}
+	getRepoRolesHandler(c, w, r)
+}
+
+// tokensCommand handles the "tokens" RPC command.
+func tokensCommand(cmd *datastore.Request) (string, error) {
+	switch cmd.Argument(1) {
+	case "create":
+		name := cmd.Argument(2)
+		var scopes []string
+		for pos := 3; cmd.Argument(pos) != ""; pos++ {
+			scopes = append(scopes, cmd.Argument(pos))
+		}
+		token, info, err := datastore.CreateAPIToken(name, scopes)
+		if err != nil {
+			return "", err
+		}
+		dvid.Infof("Created API token %s (%s) with scopes %v\n", info.ID, info.Name, info.Scopes)
+		return fmt.Sprintf("Created API token %s (%s) with scopes %v.  The token, which can't be shown again, is:\n%s\n",
+			info.ID, info.Name, info.Scopes, token), nil
+	case "revoke":
+		id := cmd.Argument(2)
+		if err := datastore.RevokeAPIToken(id); err != nil {
+			return "", err
+		}
+		dvid.Infof("Revoked API token %s\n", id)
+		return fmt.Sprintf("Revoked API token %s.\n", id), nil
+	case "list":
+		tokens, err := datastore.APITokens()
+		if err != nil {
+			return "", err
+		}
+		text := fmt.Sprintf("%-16s  %-20s  %-25s  %s\n", "ID", "Name", "Created", "Scopes")
+		for _, t := range tokens {
+			text += fmt.Sprintf("%-16s  %-20s  %-25s  %s\n", t.ID, t.Name, t.Created.Format(time.RFC3339), strings.Join(t.Scopes, ","))
+		}
+		return text, nil
+	default:
+		return "", fmt.Errorf("Unknown tokens command: %q", cmd.Argument(1))
+	}
+}
@@ -0,0 +1,304 @@
+/*
+	This file implements batch requests, which run a list of sub-requests in one round trip,
+	e.g., hundreds of small keyvalue writes.  Each sub-request goes through the full
+	middleware, so it's authorized, rate limited, and audited as if sent directly, but all
+	sub-requests share the batch request's timeout.  An atomic batch queues the storage
+	writes of its sub-requests and commits them once all have succeeded.  It's rejected if
+	a mutating sub-request isn't to a data instance or makes a write that can't be queued,
+	and its sub-requests are audited as rolled back unless it's committed.
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/zenazn/goji/web"
+)
+
+// MaxBatchRequests is the maximum number of sub-requests in one batch request.
+var MaxBatchRequests = 10000
+
+// batchExcluded are paths that can't be sub-requests, either because they stream
+// responses or would nest batches.
+var batchExcluded = []string{"/api/batch", "/api/server/events"}
+
+// batchRequest is a sub-request in the JSON body of a batch request.  A binary body is
+// given base64 encoded as Body64.
+type batchRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Body64  []byte            `json:"body64,omitempty"`
+}
+
+// batchResponse is the response to a sub-request in the JSON response of a batch request.
+// A body that isn't UTF-8 is returned base64 encoded as Body64.
+type batchResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Body64  []byte            `json:"body64,omitempty"`
+}
+
+// newSubrequest returns a sub-request of a batch request.  The batch request's
+// Authorization is used if the sub-request has none.
+func newSubrequest(r *http.Request, method, target string, header http.Header, body []byte) (*http.Request, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("bad sub-request path %q: %v", target, err)
+	}
+	if !strings.HasPrefix(u.Path, "/api/") {
+		return nil, fmt.Errorf("sub-request path %q isn't an API endpoint", target)
+	}
+	for _, prefix := range batchExcluded {
+		if strings.HasPrefix(u.Path, prefix) {
+			return nil, fmt.Errorf("sub-request path %q isn't allowed in a batch", target)
+		}
+	}
+	if method == "" {
+		method = "GET"
+	}
+	req, err := http.NewRequest(strings.ToUpper(method), u.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Del("Content-Length")
+	if req.Header.Get("Authorization") == "" && r.Header.Get("Authorization") != "" {
+		req.Header.Set("Authorization", r.Header.Get("Authorization"))
+	}
+	req.Host = r.Host
+	req.RemoteAddr = r.RemoteAddr
+	return req, nil
+}
+
+// readJSONBatch returns the sub-requests of a JSON batch request body.
+func readJSONBatch(r *http.Request) ([]*http.Request, error) {
+	var batch []batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		return nil, fmt.Errorf("bad JSON list of sub-requests: %v", err)
+	}
+	if len(batch) > MaxBatchRequests {
+		return nil, fmt.Errorf("batch of %d sub-requests exceeds maximum of %d", len(batch), MaxBatchRequests)
+	}
+	reqs := make([]*http.Request, len(batch))
+	for i, sub := range batch {
+		if sub.Body != "" && sub.Body64 != nil {
+			return nil, fmt.Errorf("sub-request %d has both body and body64", i)
+		}
+		body := sub.Body64
+		if body == nil {
+			body = []byte(sub.Body)
+		}
+		header := make(http.Header, len(sub.Headers))
+		for key, value := range sub.Headers {
+			header.Set(key, value)
+		}
+		req, err := newSubrequest(r, sub.Method, sub.Path, header, body)
+		if err != nil {
+			return nil, fmt.Errorf("sub-request %d: %v", i, err)
+		}
+		reqs[i] = req
+	}
+	return reqs, nil
+}
+
+// readMultipartBatch returns the sub-requests of a multipart/mixed batch request body,
+// where each part is an HTTP request, and the Content-ID of each part.
+func readMultipartBatch(r *http.Request, boundary string) ([]*http.Request, []string, error) {
+	mr := multipart.NewReader(r.Body, boundary)
+	var reqs []*http.Request
+	var ids []string
+	for i := 0; ; i++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return reqs, ids, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad multipart body: %v", err)
+		}
+		if i == MaxBatchRequests {
+			return nil, nil, fmt.Errorf("batch exceeds maximum of %d sub-requests", MaxBatchRequests)
+		}
+		in, err := http.ReadRequest(bufio.NewReader(part))
+		if err != nil {
+			return nil, nil, fmt.Errorf("part %d isn't an HTTP request: %v", i, err)
+		}
+		body, err := ioutil.ReadAll(in.Body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading body of part %d: %v", i, err)
+		}
+		req, err := newSubrequest(r, in.Method, in.URL.RequestURI(), in.Header, body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("part %d: %v", i, err)
+		}
+		reqs = append(reqs, req)
+		ids = append(ids, part.Header.Get("Content-ID"))
+	}
+}
+
+// instanceRequest returns true if the path is an endpoint of a data instance, whose
+// storage writes can be queued by an atomic batch, rather than of a node, repo, or the
+// server, whose metadata is written directly.
+func instanceRequest(path string) bool {
+	parts := strings.Split(path, "/")
+	if len(parts) < 6 || parts[1] != "api" || parts[2] != "node" {
+		return false
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	for _, route := range registry.routes {
+		rparts := strings.Split(route.Path, "/")
+		if len(rparts) > 4 && rparts[2] == "node" && rparts[4] == parts[4] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSONBatch(w http.ResponseWriter, status int, results []*httptest.ResponseRecorder) {
+	out := make([]batchResponse, len(results))
+	for i, rec := range results {
+		resp := rec.Result()
+		out[i].Status = resp.StatusCode
+		if len(resp.Header) != 0 {
+			out[i].Headers = make(map[string]string, len(resp.Header))
+			for key, values := range resp.Header {
+				out[i].Headers[key] = strings.Join(values, ", ")
+			}
+		}
+		if body := rec.Body.Bytes(); utf8.Valid(body) {
+			out[i].Body = string(body)
+		} else {
+			out[i].Body64 = body
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		dvid.Errorf("Unable to write batch response: %v\n", err)
+	}
+}
+
+func writeMultipartBatch(w http.ResponseWriter, status int, results []*httptest.ResponseRecorder, ids []string) {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+	w.WriteHeader(status)
+	for i, rec := range results {
+		header := textproto.MIMEHeader{"Content-Type": []string{"application/http"}}
+		if ids[i] != "" {
+			header.Set("Content-ID", ids[i])
+		}
+		part, err := mw.CreatePart(header)
+		if err == nil {
+			err = rec.Result().Write(part)
+		}
+		if err != nil {
+			dvid.Errorf("Unable to write batch response: %v\n", err)
+			return
+		}
+	}
+	mw.Close()
+}
+
+// batchHandler runs the sub-requests of a batch request in order and returns their
+// responses in the format of the request.  If the batch is atomic, it stops at the first
+// failed sub-request and the writes of its sub-requests are made only if all succeed.
+func batchHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	if httpUnavailable(w) {
+		return
+	}
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	multipartBatch := mediaType == "multipart/mixed"
+	var reqs []*http.Request
+	var ids []string
+	var err error
+	if multipartBatch {
+		reqs, ids, err = readMultipartBatch(r, params["boundary"])
+	} else {
+		reqs, err = readJSONBatch(r)
+	}
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	var rb *storage.RequestBatch
+	var ab *auditBatch
+	if atomic := r.URL.Query().Get("atomic"); atomic == "true" || atomic == "1" {
+		for i, req := range reqs {
+			if mutatingMethod(req.Method) && !instanceRequest(req.URL.Path) {
+				msg := fmt.Sprintf("atomic batch not supported for sub-request %d, %s %s, which isn't to a data instance", i+1, req.Method, req.URL.Path)
+				http.Error(w, msg, http.StatusNotImplemented)
+				return
+			}
+		}
+		rb = storage.NewRequestBatch()
+		ab = new(auditBatch)
+		ctx = context.WithValue(storage.WithRequestBatch(ctx, rb), auditBatchKey{}, ab)
+	}
+	status := http.StatusOK
+	var rollback string
+	results := make([]*httptest.ResponseRecorder, 0, len(reqs))
+	for i, req := range reqs {
+		rec := httptest.NewRecorder()
+		webMux.ServeHTTP(rec, req.WithContext(ctx))
+		results = append(results, rec)
+		if rb != nil && rec.Code >= 300 {
+			rb.Discard()
+			rollback = fmt.Sprintf("atomic batch stopped at sub-request %d of %d, %s %s, with status %d", i+1, len(reqs), req.Method, req.URL.Path, rec.Code)
+			requestLog(c).Warningf("%s\n", rollback)
+			status = http.StatusBadRequest
+			break
+		}
+	}
+	if rb != nil {
+		if err := rb.Unqueued(); err != nil {
+			rb.Discard()
+			msg := fmt.Sprintf("atomic batch not supported for all sub-requests: %v", err)
+			requestLog(c).Warningf("%s\n", msg)
+			ab.record(http.StatusNotImplemented, msg)
+			http.Error(w, msg, http.StatusNotImplemented)
+			return
+		}
+		if rollback == "" {
+			writes := rb.Writes()
+			if err := rb.Commit(); err != nil {
+				msg := fmt.Sprintf("unable to commit writes of atomic batch: %v", err)
+				requestLog(c).Errorf("%s\n", msg)
+				ab.record(http.StatusInternalServerError, msg)
+				http.Error(w, msg, http.StatusInternalServerError)
+				return
+			}
+			requestLog(c).Infof("Committed %d writes of atomic batch of %d sub-requests\n", writes, len(reqs))
+		}
+		ab.record(status, rollback)
+	}
+	if multipartBatch {
+		writeMultipartBatch(w, status, results, ids)
+	} else {
+		writeJSONBatch(w, status, results)
+	}
+}
@@ -0,0 +1,224 @@
+/*
+	This file implements negotiated compression of responses.  JSON, text, and
+	uncompressed binary responses are compressed with zstd or gzip when the client accepts
+	them, while handlers that send already-compressed data, e.g., lz4 or gzipped label
+	blocks, opt out with NoCompression.
+*/
+
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/zenazn/goji/web"
+)
+
+// MinCompressSize is the minimum Content-Length of a response that will be compressed.
+// Responses without a Content-Length are compressed regardless of size.
+var MinCompressSize int64 = 1024
+
+// compressibleTypes are the media types compressed.  Other types, e.g., images and
+// archives, are usually compressed already.
+var compressibleTypes = map[string]bool{
+	"application/json":         true,
+	"application/octet-stream": true,
+	"application/javascript":   true,
+	"application/xml":          true,
+	"application/x-ndjson":     true,
+	"image/svg+xml":            true,
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} {
+		zw, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return zw
+	}}
+	zstdWriters = sync.Pool{New: func() interface{} {
+		zw, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		return zw
+	}}
+)
+
+type noCompressionKey struct{}
+
+// NoCompression keeps the response to a request from being compressed, e.g., because
+// the handler sends already-compressed data.  It must be called before the response is
+// written.
+func NoCompression(r *http.Request) {
+	if off, ok := r.Context().Value(noCompressionKey{}).(*int32); ok {
+		atomic.StoreInt32(off, 1)
+	}
+}
+
+// acceptedEncoding returns "zstd" or "gzip" if accepted by an Accept-Encoding header,
+// preferring zstd when both are equally acceptable, or "" if neither is accepted.
+func acceptedEncoding(header string) string {
+	var best string
+	var bestQ float64
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if (coding != "zstd" && coding != "gzip") || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && coding == "zstd") {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressWriter compresses a response if it's compressible once its headers and first
+// bytes are known.
+type compressWriter struct {
+	w        http.ResponseWriter
+	r        *http.Request
+	encoding string
+	off      *int32
+
+	status  int  // status given to WriteHeader but not yet written
+	decided bool // true once headers are written
+	enc     io.WriteCloser
+}
+
+func (cw *compressWriter) Header() http.Header {
+	return cw.w.Header()
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	cw.status = status
+	// Delay the decision until the first write unless there's no body to sniff.
+	if cw.w.Header().Get("Content-Type") != "" || status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.decide(nil)
+	}
+}
+
+// decide writes the headers, compressing the response if it's worthwhile.
+func (cw *compressWriter) decide(p []byte) {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	header := cw.w.Header()
+	if header.Get("Content-Type") == "" && len(p) > 0 {
+		header.Set("Content-Type", http.DetectContentType(p))
+	}
+	if cw.compressible() {
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		header.Set("Content-Encoding", cw.encoding)
+		if etag := header.Get("ETag"); strings.HasSuffix(etag, `"`) {
+			// Compressed and uncompressed responses need different strong ETags.
+			header.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+cw.encoding+`"`)
+		}
+		switch cw.encoding {
+		case "zstd":
+			zw := zstdWriters.Get().(*zstd.Encoder)
+			zw.Reset(cw.w)
+			cw.enc = zw
+		default:
+			zw := gzipWriters.Get().(*gzip.Writer)
+			zw.Reset(cw.w)
+			cw.enc = zw
+		}
+	}
+	cw.w.WriteHeader(cw.status)
+}
+
+func (cw *compressWriter) compressible() bool {
+	header := cw.w.Header()
+	if atomic.LoadInt32(cw.off) != 0 || cw.r.Method == "HEAD" || header.Get("Content-Encoding") != "" {
+		return false
+	}
+	switch cw.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	if size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && size < MinCompressSize {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return compressibleTypes[mediaType] || (strings.HasPrefix(mediaType, "text/") && mediaType != "text/event-stream")
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.decide(p)
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.w.Write(p)
+}
+
+// Flush sends any compressed bytes buffered so far.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(nil)
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close finishes any compressed stream and returns its encoder to the pool.
+func (cw *compressWriter) close() {
+	if !cw.decided && cw.status != 0 {
+		cw.decide(nil)
+	}
+	switch zw := cw.enc.(type) {
+	case *zstd.Encoder:
+		zw.Close()
+		zw.Reset(nil)
+		zstdWriters.Put(zw)
+	case *gzip.Writer:
+		zw.Close()
+		zw.Reset(nil)
+		gzipWriters.Put(zw)
+	}
+}
+
+// compressHandler is middleware that compresses responses with the encoding negotiated
+// by the request's Accept-Encoding header.
+func compressHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Header.Get("Range") != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		off := new(int32)
+		cw := &compressWriter{w: w, r: r, encoding: encoding, off: off}
+		defer cw.close()
+		h.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), noCompressionKey{}, off)))
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,53 @@
+/*
+	This file implements conditional GETs of data instances.  Responses are given ETags
+	derived from the version and the instance's mutations, and requests whose
+	If-None-Match header has a current ETag get a 304 (Not Modified) without any reads.
+*/
+
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// etagMatches returns true if an If-None-Match header lists the ETag, using the weak
+// comparison and ignoring any suffix added for a compressed encoding.
+func etagMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		for _, encoding := range []string{"-gzip\"", "-zstd\""} {
+			if strings.HasSuffix(candidate, encoding) {
+				candidate = strings.TrimSuffix(candidate, encoding) + "\""
+			}
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag of a GET or HEAD of a data instance and returns true, after
+// writing a 304 response, if the client already has the current response.
+func notModified(w http.ResponseWriter, r *http.Request, uuid dvid.UUID, data datastore.DataService) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	etag, err := datastore.DataETag(uuid, data)
+	if err != nil || etag == "" {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
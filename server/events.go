@@ -0,0 +1,205 @@
+/*
+	This file implements external notifiers of datastore events, which POST events to
+	webhooks or append them to the default log store, e.g., a Kafka topic, as well as a
+	server-sent events stream that clients can follow over plain HTTP.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/zenazn/goji/web"
+)
+
+// EventLogEntryType is the entry type of datastore events appended to a log store.
+const EventLogEntryType uint16 = 0x4556
+
+// WebhookTimeout is the maximum time to wait for a webhook to accept an event.
+var WebhookTimeout = 10 * time.Second
+
+// EventStreamKeepAlive is the interval between comments sent on idle event streams so
+// proxies don't close them.
+var EventStreamKeepAlive = 15 * time.Second
+
+type eventsConfig struct {
+	Webhooks []string // URLs that are POSTed the JSON of each event.
+	Log      bool     // append events to the default log store.
+	Types    []string // event types to send, e.g., "NewVersion", or all types if empty.
+}
+
+// startEventNotifiers subscribes the configured webhooks and log store to datastore events.
+func startEventNotifiers(c eventsConfig) error {
+	types := make([]datastore.EventType, len(c.Types))
+	for i, t := range c.Types {
+		types[i] = datastore.EventType(t)
+	}
+	if len(c.Webhooks) != 0 {
+		client := &http.Client{Timeout: WebhookTimeout}
+		for _, url := range c.Webhooks {
+			url := url
+			datastore.Subscribe("webhook "+url, func(e datastore.Event) {
+				if err := postEvent(client, url, e); err != nil {
+					dvid.Errorf("Unable to send %s event to webhook %s: %v\n", e.Type, url, err)
+				}
+			}, types...)
+			dvid.Infof("Sending datastore events to webhook %s\n", url)
+		}
+	}
+	if c.Log {
+		log, err := storage.DefaultLogStore()
+		if err != nil {
+			return fmt.Errorf("can't log datastore events: %v", err)
+		}
+		datastore.Subscribe("log "+log.String(), func(e datastore.Event) {
+			data, err := json.Marshal(e)
+			if err == nil {
+				err = log.Append(EventLogEntryType, e.DataUUID, e.UUID, data)
+			}
+			if err != nil {
+				dvid.Errorf("Unable to log %s event to %s: %v\n", e.Type, log, err)
+			}
+		}, types...)
+		dvid.Infof("Logging datastore events to %s\n", log)
+	}
+	return nil
+}
+
+func postEvent(client *http.Client, url string, e datastore.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// eventFilter selects the events sent to a subscriber.
+type eventFilter struct {
+	types []datastore.EventType // all types if empty.
+	repo  dvid.UUID             // root of the repo, or all repos if empty.
+	data  dvid.InstanceName     // all data if empty.
+}
+
+func (f eventFilter) match(e datastore.Event) bool {
+	if f.repo != "" && e.Repo != f.repo {
+		return false
+	}
+	return f.data == "" || e.Data == f.data
+}
+
+// parseEventFilter returns the filter given by the "types", "repo", and "data" query
+// strings of an events request.  Types are comma-separated like the [events] types.
+func parseEventFilter(r *http.Request) (eventFilter, error) {
+	var f eventFilter
+	q := r.URL.Query()
+	if s := q.Get("types"); s != "" {
+		for _, t := range strings.Split(s, ",") {
+			f.types = append(f.types, datastore.EventType(strings.TrimSpace(t)))
+		}
+	}
+	if s := q.Get("repo"); s != "" {
+		uuid, _, err := datastore.MatchingUUID(s)
+		if err != nil {
+			return f, err
+		}
+		if f.repo, err = datastore.GetRepoRoot(uuid); err != nil {
+			return f, err
+		}
+	}
+	f.data = dvid.InstanceName(q.Get("data"))
+	return f, nil
+}
+
+// serverEventsHandler streams datastore events as server-sent events until the client
+// disconnects.  Streams end before the server's write timeout so clients reconnect.
+func serverEventsHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		BadRequest(w, r, "connection doesn't support streaming events")
+		return
+	}
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	if filter.repo != "" && !authorizeRepo(&c, w, r, filter.repo, datastore.RoleRead) {
+		return
+	}
+	scopes, _ := c.Env["scopes"].([]string)
+	admin := hasScope(scopes, datastore.ScopeAdmin)
+	user := RequestUser(c)
+
+	// Events are dropped rather than blocking the subscription if the client falls behind.
+	events := make(chan datastore.Event, datastore.EventBufferSize)
+	id := datastore.Subscribe("events stream "+r.RemoteAddr, func(e datastore.Event) {
+		if !filter.match(e) {
+			return
+		}
+		if !admin && datastore.CheckRepoRole(e.Repo, datastore.RoleRead, user) != nil {
+			return
+		}
+		select {
+		case events <- e:
+		default:
+		}
+	}, filter.types...)
+	defer datastore.Unsubscribe(id)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // don't let nginx buffer the stream
+	fmt.Fprintf(w, "retry: 1000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(EventStreamKeepAlive)
+	defer keepAlive.Stop()
+	// End the stream cleanly before the request's deadline closes the connection.
+	var end <-chan time.Time
+	if deadline, ok := r.Context().Deadline(); ok && time.Until(deadline) > 2*EventStreamKeepAlive {
+		timer := time.NewTimer(time.Until(deadline) - EventStreamKeepAlive)
+		defer timer.Stop()
+		end = timer.C
+	}
+	var seq uint64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-end:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprintf(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case e := <-events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				dvid.Errorf("Unable to encode %s event: %v\n", e.Type, err)
+				continue
+			}
+			seq++
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", seq, e.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
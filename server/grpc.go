@@ -0,0 +1,273 @@
+/*
+	This file implements the gRPC command service used by the dvid terminal client.  A
+	command's reply is streamed back followed by the progress of any background jobs it
+	started, e.g., a backup, until the jobs finish.  If the client goes away before then,
+	e.g., the user interrupts the command, the jobs are cancelled.
+
+	Messages are JSON encoded, so the service needs no generated code:
+
+		service dvid.Commands {
+			rpc Run(CommandRequest) returns (stream CommandReply);
+		}
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultGRPCAddress is the default gRPC address for command-line use of a remote DVID server.
+	DefaultGRPCAddress = "localhost:8003"
+
+	grpcCodecName   = "json"
+	grpcRunCommand  = "/dvid.Commands/Run"
+	maxReplyMessage = 1 << 20 // maximum bytes of output sent in one reply message.
+)
+
+// JobProgressInterval is the interval at which the progress of jobs started by a command
+// is checked and streamed to the client.
+var JobProgressInterval = time.Second
+
+// CommandRequest is a command sent to the gRPC command service.
+type CommandRequest struct {
+	Command []string
+	Input   []byte
+	Token   string
+
+	// Detach returns after the command's reply without streaming the progress of jobs it
+	// started, which keep running.
+	Detach bool
+}
+
+// CommandReply is one of the messages streamed back for a command: first the command's
+// text and output, then the state of its jobs whenever they change.
+type CommandReply struct {
+	Text   string         `json:",omitempty"`
+	Output []byte         `json:",omitempty"`
+	Job    *datastore.Job `json:",omitempty"`
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return grpcCodecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// commandService is the interface the gRPC service description requires of its server.
+type commandService interface {
+	run(req *CommandRequest, stream grpc.ServerStream) error
+}
+
+var commandServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dvid.Commands",
+	HandlerType: (*commandService)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Run",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := new(CommandRequest)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(commandService).run(req, stream)
+			},
+		},
+	},
+	Metadata: "dvid/commands",
+}
+
+type commandServer struct{}
+
+func (commandServer) run(req *CommandRequest, stream grpc.ServerStream) error {
+	cmd := &datastore.Request{Command: dvid.Command(req.Command), Input: req.Input, Token: req.Token}
+	var clientAddr string
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		clientAddr = p.Addr.String()
+	}
+	reply, err := handleCommand(clientAddr, cmd)
+	if err != nil {
+		return status.Error(codes.Unknown, err.Error())
+	}
+	if err := stream.SendMsg(&CommandReply{Text: reply.Text}); err != nil {
+		return err
+	}
+	for output := reply.Output; len(output) > 0; {
+		n := len(output)
+		if n > maxReplyMessage {
+			n = maxReplyMessage
+		}
+		if err := stream.SendMsg(&CommandReply{Output: output[:n]}); err != nil {
+			return err
+		}
+		output = output[n:]
+	}
+	if req.Detach {
+		return nil
+	}
+	return streamJobs(stream, reply.Jobs)
+}
+
+// streamJobs sends the state of jobs whenever it changes until all jobs have finished.
+// If the client goes away first, the running jobs are cancelled.
+func streamJobs(stream grpc.ServerStream, ids []uint64) error {
+	sent := make(map[uint64]datastore.Job, len(ids))
+	ticker := time.NewTicker(JobProgressInterval)
+	defer ticker.Stop()
+	for {
+		var running []uint64
+		for _, id := range ids {
+			job, err := datastore.GetJob(id)
+			if err != nil {
+				continue
+			}
+			if job.State == datastore.JobRunning {
+				running = append(running, id)
+			}
+			if last, found := sent[id]; found && last.State == job.State && last.Step == job.Step {
+				continue
+			}
+			sent[id] = job
+			if err := stream.SendMsg(&CommandReply{Job: &job}); err != nil {
+				return err
+			}
+		}
+		if len(running) == 0 {
+			return nil
+		}
+		select {
+		case <-stream.Context().Done():
+			for _, id := range running {
+				if err := datastore.CancelJob(id); err == nil {
+					dvid.Infof("Cancelled job %d since its command's client went away\n", id)
+				}
+			}
+			return status.Error(codes.Canceled, "command cancelled by client")
+		case <-ticker.C:
+		}
+	}
+}
+
+var grpcServer struct {
+	sync.Mutex
+	s *grpc.Server
+}
+
+// startGRPC serves the command service on the given address until stopGRPC is called.
+func startGRPC(address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer()
+	s.RegisterService(&commandServiceDesc, commandServer{})
+	grpcServer.Lock()
+	grpcServer.s = s
+	grpcServer.Unlock()
+	return s.Serve(ln)
+}
+
+// stopGRPC stops the command service, if running, after in-flight commands return.
+func stopGRPC() {
+	grpcServer.Lock()
+	defer grpcServer.Unlock()
+	if grpcServer.s != nil {
+		grpcServer.s.GracefulStop()
+		grpcServer.s = nil
+	}
+}
+
+// SendCommand sends a request to the gRPC command service of a remote DVID, writing the
+// reply and the progress of any jobs started by the command to w.  Cancelling the context
+// cancels those jobs unless detach is true, in which case SendCommand returns without
+// waiting for them.  An error is returned if a job fails or is cancelled.
+func SendCommand(ctx context.Context, addr string, req datastore.Request, detach bool, w io.Writer) error {
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("unable to connect to DVID at %s: %v", addr, err)
+	}
+	defer conn.Close()
+
+	stream, err := conn.NewStream(ctx, &commandServiceDesc.Streams[0], grpcRunCommand, grpc.CallContentSubtype(grpcCodecName))
+	if err != nil {
+		return fmt.Errorf("gRPC error for %q: %v", req.Command, err)
+	}
+	in := &CommandRequest{Command: req.Command, Input: req.Input, Token: req.Token, Detach: detach}
+	if err := stream.SendMsg(in); err != nil {
+		return fmt.Errorf("gRPC error for %q: %v", req.Command, err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return fmt.Errorf("gRPC error for %q: %v", req.Command, err)
+	}
+	var failed []string
+	for {
+		var reply CommandReply
+		err := stream.RecvMsg(&reply)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if s, ok := status.FromError(err); ok {
+				return fmt.Errorf("%s", s.Message())
+			}
+			return err
+		}
+		if reply.Text != "" {
+			fmt.Fprint(w, reply.Text)
+		}
+		if len(reply.Output) != 0 {
+			if _, err := w.Write(reply.Output); err != nil {
+				return err
+			}
+		}
+		if job := reply.Job; job != nil {
+			switch job.State {
+			case datastore.JobRunning:
+				if job.Step != "" {
+					fmt.Fprintf(w, "Job %d (%s of %s): %s\n", job.ID, job.Kind, job.Target, job.Step)
+				}
+			case datastore.JobFailed:
+				fmt.Fprintf(w, "Job %d (%s of %s) failed after %s: %s\n", job.ID, job.Kind, job.Target, job.Finished.Sub(job.Started), job.Error)
+				failed = append(failed, fmt.Sprintf("job %d %s", job.ID, job.State))
+			case datastore.JobCancelled:
+				fmt.Fprintf(w, "Job %d (%s of %s) cancelled after %s\n", job.ID, job.Kind, job.Target, job.Finished.Sub(job.Started))
+				failed = append(failed, fmt.Sprintf("job %d %s", job.ID, job.State))
+			default:
+				fmt.Fprintf(w, "Job %d (%s of %s) done in %s\n", job.ID, job.Kind, job.Target, job.Finished.Sub(job.Started))
+			}
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("command %q did not complete: %v", req.Command, failed)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+	This file implements health endpoints for orchestrators and load balancers.  /livez
+	reports whether the server is running, /healthz whether the metadata is loaded and all
+	stores respond, and /readyz additionally whether chunk handlers are free to take on
+	more requests.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// HealthCheckTimeout is the maximum time to wait for a store to respond to a health check.
+var HealthCheckTimeout = 2 * time.Second
+
+type healthCheck struct {
+	OK     bool
+	Error  string                                `json:",omitempty"`
+	Stores map[storage.Alias]storage.StoreHealth `json:",omitempty"`
+	InUse  int                                   `json:",omitempty"` // chunk handlers in use
+	Max    int                                   `json:",omitempty"` // maximum chunk handlers
+}
+
+type healthStatus struct {
+	Status string // "ok" or "unavailable"
+	Checks map[string]healthCheck
+}
+
+// checkHealth returns the status of the metadata, the stores, and, for readiness, the
+// chunk handlers.
+func checkHealth(readiness bool) healthStatus {
+	checks := make(map[string]healthCheck)
+	if datastore.MetadataLoaded() {
+		checks["metadata"] = healthCheck{OK: true}
+	} else {
+		checks["metadata"] = healthCheck{Error: "metadata not loaded"}
+	}
+
+	stores, err := storage.CheckStores(HealthCheckTimeout)
+	storesCheck := healthCheck{OK: err == nil, Stores: stores}
+	if err != nil {
+		storesCheck.Error = err.Error()
+	}
+	for _, store := range stores {
+		if store.Error != "" {
+			storesCheck.OK = false
+			storesCheck.Error = "store not responding"
+		}
+	}
+	checks["stores"] = storesCheck
+
+	if readiness {
+		inUse := MaxChunkHandlers - len(HandlerToken)
+		handlers := healthCheck{OK: inUse < MaxChunkHandlers, InUse: inUse, Max: MaxChunkHandlers}
+		if !handlers.OK {
+			handlers.Error = "all chunk handlers busy"
+		}
+		checks["handlers"] = handlers
+
+		server := healthCheck{OK: httpAvail}
+		if !httpAvail {
+			server.Error = "server is unavailable or shutting down"
+		}
+		checks["server"] = server
+	}
+
+	status := healthStatus{Status: "ok", Checks: checks}
+	for _, check := range checks {
+		if !check.OK {
+			status.Status = "unavailable"
+		}
+	}
+	return status
+}
+
+func writeHealth(w http.ResponseWriter, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		dvid.Errorf("Unable to write health status: %v\n", err)
+	}
+}
+
+func livenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, healthStatus{Status: "ok", Checks: map[string]healthCheck{}})
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, checkHealth(false))
+}
+
+func readinessHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, checkHealth(true))
+}
@@ -0,0 +1,265 @@
+/*
+	This file implements per-request timeouts and a maximum request body size so stuck or
+	slow clients can't hold handlers, and the throttle slots they use, indefinitely.  Each
+	request is given the timeout of its class: reads, writes, or long-running requests
+	such as repo exports and imports.  Bodies not received in time get status 408 (Request
+	Timeout) and bodies over the maximum size get 413 (Request Entity Too Large).
+*/
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zenazn/goji/web"
+)
+
+type limitsConfig struct {
+	ReadTimeout   int      `toml:"read_timeout"`   // seconds for GET and HEAD requests.
+	WriteTimeout  int      `toml:"write_timeout"`  // seconds for other requests.
+	LongTimeout   int      `toml:"long_timeout"`   // seconds for long-running requests.
+	LongEndpoints []string `toml:"long_endpoints"` // data instance endpoints that are long-running.
+	MaxBodyBytes  int64    `toml:"max_body_bytes"` // no limit if 0.
+}
+
+// requestClass is the class of a request that sets its timeout.
+type requestClass int
+
+const (
+	readRequest requestClass = iota
+	writeRequest
+	longRequest
+)
+
+// requestLimits holds the timeout of each class of request and the maximum body size.
+type requestLimits struct {
+	timeouts      [3]time.Duration
+	longEndpoints map[string]struct{}
+	maxBody       int64 // no limit if 0.
+}
+
+// limits are the current request limits, which default to the ReadTimeout and
+// WriteTimeout with no maximum body size.
+var limits = &requestLimits{timeouts: [3]time.Duration{ReadTimeout, WriteTimeout, LongTimeout}}
+
+// setRequestLimits sets the request timeouts, where zero gives the default, and the
+// maximum body size.
+func setRequestLimits(config limitsConfig) error {
+	l, err := newRequestLimits(config)
+	if err != nil {
+		return err
+	}
+	limits = l
+	return nil
+}
+
+// newRequestLimits returns the request limits of a configuration.
+func newRequestLimits(config limitsConfig) (*requestLimits, error) {
+	if config.ReadTimeout < 0 || config.WriteTimeout < 0 || config.LongTimeout < 0 || config.MaxBodyBytes < 0 {
+		return nil, fmt.Errorf("request timeouts and maximum body size can't be negative")
+	}
+	l := &requestLimits{
+		timeouts:      [3]time.Duration{ReadTimeout, WriteTimeout, LongTimeout},
+		longEndpoints: make(map[string]struct{}, len(config.LongEndpoints)),
+		maxBody:       config.MaxBodyBytes,
+	}
+	for i, secs := range []int{config.ReadTimeout, config.WriteTimeout, config.LongTimeout} {
+		if secs != 0 {
+			l.timeouts[i] = time.Duration(secs) * time.Second
+		}
+	}
+	if l.timeouts[longRequest] < l.timeouts[readRequest] || l.timeouts[longRequest] < l.timeouts[writeRequest] {
+		return nil, fmt.Errorf("long-running request timeout %s is shorter than the read or write timeout", l.timeouts[longRequest])
+	}
+	for _, endpoint := range config.LongEndpoints {
+		l.longEndpoints[endpoint] = struct{}{}
+	}
+	return l, nil
+}
+
+// maxTimeout returns the longest request timeout, which bounds every request on a
+// connection.
+func (l *requestLimits) maxTimeout() time.Duration {
+	return l.timeouts[longRequest]
+}
+
+// class returns the class of a request.  Repo imports and exports, event streams,
+// finalized uploads, and requests of the configured data instance endpoints are
+// long-running.
+func (l *requestLimits) class(r *http.Request) requestClass {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	switch {
+	case len(parts) >= 2 && parts[0] == "repos" && parts[1] == "import",
+		len(parts) >= 2 && parts[0] == "server" && parts[1] == "events",
+		len(parts) >= 3 && parts[0] == "repo" && parts[2] == "export",
+		len(parts) >= 3 && parts[0] == "uploads" && parts[2] == "finalize":
+		return longRequest
+	case len(parts) >= 4 && parts[0] == "node":
+		if _, found := l.longEndpoints[parts[3]]; found {
+			return longRequest
+		}
+	}
+	if mutatingMethod(r.Method) {
+		return writeRequest
+	}
+	return readRequest
+}
+
+type connContextKey struct{}
+
+// limitedContextKey marks the context of a request whose limits are set, so requests
+// made within it, e.g., the POST of a finalized upload, keep the outer request's limits.
+type limitedContextKey struct{}
+
+// withConn adds a connection to the context of its requests, so their deadlines can be
+// set per request.  It's used as the http.Server ConnContext.
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+var (
+	errBodyTimeout  = errors.New("request body not received in time")
+	errBodyTooLarge = errors.New("request body too large")
+)
+
+// limitedBody is a request body that fails if it's larger than the maximum size and
+// records whether reading it timed out.
+type limitedBody struct {
+	io.ReadCloser
+	maxBody int64
+	n       int64
+	err     error // errBodyTimeout or errBodyTooLarge once either happens.
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if b.maxBody > 0 && b.n > b.maxBody {
+		b.err = errBodyTooLarge
+		return 0, b.err
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		b.err = errBodyTimeout
+		return n, b.err
+	}
+	return n, err
+}
+
+// limitWriter replaces the response to a request whose body timed out or was too large
+// with a 408 or 413 error.
+type limitWriter struct {
+	http.ResponseWriter
+	body        *limitedBody
+	timeout     time.Duration
+	maxBody     int64
+	wroteHeader bool
+	replaced    bool
+}
+
+func (w *limitWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	switch w.body.err {
+	case errBodyTimeout:
+		w.replaced = true
+		http.Error(w.ResponseWriter, fmt.Sprintf("request body not received within %s", w.timeout), http.StatusRequestTimeout)
+	case errBodyTooLarge:
+		w.replaced = true
+		http.Error(w.ResponseWriter, fmt.Sprintf("request body is larger than the maximum of %d bytes", w.maxBody), http.StatusRequestEntityTooLarge)
+	default:
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *limitWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.replaced {
+		f.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection, e.g., for websockets, when the wrapped
+// writer supports it.
+func (w *limitWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer doesn't support hijacking the connection")
+	}
+	return h.Hijack()
+}
+
+// CloseNotify returns the wrapped writer's close notifications, or a channel that never
+// receives if the wrapped writer doesn't support them.
+func (w *limitWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// limitHandler is middleware that gives each request the timeout of its class, both as
+// the deadline of its context and, except for HTTP/2, of its connection, and limits the
+// size of bodies of requests other than long-running ones.
+func limitHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(limitedContextKey{}) != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		l := limits
+		class := l.class(r)
+		timeout := l.timeouts[class]
+		maxBody := l.maxBody
+		if class == longRequest {
+			maxBody = 0
+		}
+		if maxBody > 0 && r.ContentLength > maxBody {
+			http.Error(w, fmt.Sprintf("request body of %d bytes is larger than the maximum of %d bytes", r.ContentLength, maxBody), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		deadline := time.Now().Add(timeout)
+		if conn, ok := r.Context().Value(connContextKey{}).(net.Conn); ok && r.ProtoMajor < 2 {
+			conn.SetReadDeadline(deadline)
+			conn.SetWriteDeadline(deadline)
+		}
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		r = r.WithContext(context.WithValue(ctx, limitedContextKey{}, class))
+
+		if r.Body == nil || r.Body == http.NoBody || !mutatingMethod(r.Method) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		body := &limitedBody{ReadCloser: r.Body, maxBody: maxBody}
+		r.Body = body
+		lw := &limitWriter{ResponseWriter: w, body: body, timeout: timeout, maxBody: maxBody}
+		h.ServeHTTP(lw, r)
+		if body.err != nil && !lw.wroteHeader {
+			lw.WriteHeader(http.StatusOK)
+		}
+	}
+	return http.HandlerFunc(fn)
+}
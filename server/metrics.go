@@ -0,0 +1,342 @@
+/*
+	This file exposes server metrics in the Prometheus text exposition format at /metrics,
+	including HTTP request counts and latencies per route, chunk handler saturation, storage
+	operation metrics, and bytes served by each data instance.
+*/
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/zenazn/goji/web"
+	"github.com/zenazn/goji/web/mutil"
+)
+
+// HTTPLatencyBuckets are the upper bounds in seconds of the HTTP request latency histograms.
+var HTTPLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// maxRouteLabels limits the number of distinct routes tracked so badly formed URLs can't
+// create unbounded metrics.  Later routes are tallied under "other".
+const maxRouteLabels = 1000
+
+type routeKey struct {
+	method string
+	route  string
+}
+
+type routeMetrics struct {
+	codes   map[int]uint64
+	seconds float64
+	buckets []uint64 // requests within each of the HTTPLatencyBuckets, plus slower ones
+	count   uint64
+}
+
+type instanceKey struct {
+	name dvid.InstanceName
+	uuid dvid.UUID // data UUID
+}
+
+var httpMetrics struct {
+	sync.Mutex
+	routes    map[routeKey]*routeMetrics
+	instances map[instanceKey]uint64 // bytes served per data instance
+}
+
+// routeLabel returns the route pattern of a URL path, replacing UUIDs, data names, and
+// other variable parts, e.g., "/api/node/:uuid/:dataname/raw".
+func routeLabel(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "api" {
+		return "other"
+	}
+	switch parts[1] {
+	case "node":
+		if len(parts) > 2 {
+			parts[2] = ":uuid"
+		}
+		if len(parts) > 4 {
+			parts[3] = ":dataname"
+			parts = parts[:5]
+		}
+	case "repo":
+		if len(parts) > 2 {
+			parts[2] = ":uuid"
+		}
+		if len(parts) > 4 {
+			parts[4] = ":dataname"
+			parts = parts[:5]
+		}
+	case "server":
+		if len(parts) > 3 {
+			parts[3] = ":id"
+			parts = parts[:4]
+		}
+	case "help":
+		if len(parts) > 3 {
+			parts = parts[:3]
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+func recordRequest(method, route string, code int, elapsed time.Duration, data dvid.Data, bytes int) {
+	seconds := elapsed.Seconds()
+	bucket := len(HTTPLatencyBuckets)
+	for i, bound := range HTTPLatencyBuckets {
+		if seconds <= bound {
+			bucket = i
+			break
+		}
+	}
+	httpMetrics.Lock()
+	defer httpMetrics.Unlock()
+	if httpMetrics.routes == nil {
+		httpMetrics.routes = make(map[routeKey]*routeMetrics)
+		httpMetrics.instances = make(map[instanceKey]uint64)
+	}
+	key := routeKey{method, route}
+	m, found := httpMetrics.routes[key]
+	if !found {
+		if len(httpMetrics.routes) >= maxRouteLabels {
+			key.route = "other"
+			m, found = httpMetrics.routes[key]
+		}
+		if !found {
+			m = &routeMetrics{
+				codes:   make(map[int]uint64),
+				buckets: make([]uint64, len(HTTPLatencyBuckets)+1),
+			}
+			httpMetrics.routes[key] = m
+		}
+	}
+	m.codes[code]++
+	m.count++
+	m.seconds += seconds
+	m.buckets[bucket]++
+	if data != nil {
+		httpMetrics.instances[instanceKey{data.DataName(), data.DataUUID()}] += uint64(bytes)
+	}
+}
+
+// metricsHandler is middleware that records the status, latency, and bytes served of
+// each request.  Data instance requests are identified by the "data" env set by
+// instanceSelector.
+func metricsHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := mutil.WrapWriter(w)
+		h.ServeHTTP(ww, r)
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+		data, _ := c.Env["data"].(dvid.Data)
+		recordRequest(r.Method, routeLabel(r.URL.Path), code, time.Since(start), data, ww.BytesWritten())
+	}
+	return http.HandlerFunc(fn)
+}
+
+// promWriter writes metrics in the Prometheus text exposition format.
+type promWriter struct {
+	*bufio.Writer
+}
+
+func (pw promWriter) header(name, kind, help string) {
+	fmt.Fprintf(pw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (pw promWriter) sample(name, labels string, value interface{}) {
+	if labels != "" {
+		fmt.Fprintf(pw, "%s{%s} %v\n", name, labels, value)
+	} else {
+		fmt.Fprintf(pw, "%s %v\n", name, value)
+	}
+}
+
+// histogram writes the cumulative buckets, sum, and count of a histogram, where counts
+// holds the observations within each bound plus a final count of larger ones.
+func (pw promWriter) histogram(name, labels string, bounds []float64, counts []uint64, sum float64) {
+	if labels != "" {
+		labels += ","
+	}
+	var cumulative uint64
+	for i, bound := range bounds {
+		cumulative += counts[i]
+		pw.sample(name+"_bucket", fmt.Sprintf(`%sle="%g"`, labels, bound), cumulative)
+	}
+	cumulative += counts[len(bounds)]
+	pw.sample(name+"_bucket", labels+`le="+Inf"`, cumulative)
+	labels = strings.TrimSuffix(labels, ",")
+	pw.sample(name+"_sum", labels, sum)
+	pw.sample(name+"_count", labels, cumulative)
+}
+
+// labelValue escapes a Prometheus label value.
+func labelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func writeHTTPMetrics(pw promWriter) {
+	httpMetrics.Lock()
+	defer httpMetrics.Unlock()
+	keys := make([]routeKey, 0, len(httpMetrics.routes))
+	for key := range httpMetrics.routes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	pw.header("dvid_http_requests_total", "counter", "HTTP requests by method, route, and status code.")
+	for _, key := range keys {
+		m := httpMetrics.routes[key]
+		codes := make([]int, 0, len(m.codes))
+		for code := range m.codes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			pw.sample("dvid_http_requests_total", fmt.Sprintf(`method="%s",route="%s",code="%d"`, key.method, labelValue(key.route), code), m.codes[code])
+		}
+	}
+	pw.header("dvid_http_request_duration_seconds", "histogram", "HTTP request latencies by method and route.")
+	for _, key := range keys {
+		m := httpMetrics.routes[key]
+		labels := fmt.Sprintf(`method="%s",route="%s"`, key.method, labelValue(key.route))
+		pw.histogram("dvid_http_request_duration_seconds", labels, HTTPLatencyBuckets, m.buckets, m.seconds)
+	}
+
+	instances := make([]instanceKey, 0, len(httpMetrics.instances))
+	for key := range httpMetrics.instances {
+		instances = append(instances, key)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].uuid < instances[j].uuid })
+	pw.header("dvid_instance_bytes_served_total", "counter", "Bytes of HTTP responses served by each data instance.")
+	for _, key := range instances {
+		labels := fmt.Sprintf(`instance="%s",data_uuid="%s"`, labelValue(string(key.name)), key.uuid)
+		pw.sample("dvid_instance_bytes_served_total", labels, httpMetrics.instances[key])
+	}
+}
+
+func writeStorageMetrics(pw promWriter) {
+	bounds := make([]float64, len(storage.LatencyBuckets))
+	for i, d := range storage.LatencyBuckets {
+		bounds[i] = d.Seconds()
+	}
+	metrics := storage.GetStoreMetrics()
+	ids := make([]dvid.InstanceID, 0, len(metrics))
+	for id := range metrics {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	type sampleFunc func(m storage.OpMetrics) uint64
+	counters := []struct {
+		name, help string
+		value      sampleFunc
+	}{
+		{"dvid_storage_ops_total", "Storage operations by data instance ID and operation.", func(m storage.OpMetrics) uint64 { return m.Count }},
+		{"dvid_storage_errors_total", "Failed storage operations by data instance ID and operation.", func(m storage.OpMetrics) uint64 { return m.Errors }},
+		{"dvid_storage_bytes_written_total", "Bytes of keys and values written by data instance ID and operation.", func(m storage.OpMetrics) uint64 { return m.BytesIn }},
+		{"dvid_storage_bytes_read_total", "Bytes of keys and values read by data instance ID and operation.", func(m storage.OpMetrics) uint64 { return m.BytesOut }},
+	}
+	for _, counter := range counters {
+		pw.header(counter.name, "counter", counter.help)
+		for _, id := range ids {
+			for _, op := range sortedOps(metrics[id]) {
+				pw.sample(counter.name, fmt.Sprintf(`instance_id="%d",op="%s"`, id, op), counter.value(metrics[id][op]))
+			}
+		}
+	}
+	pw.header("dvid_storage_op_duration_seconds", "histogram", "Storage operation latencies by data instance ID and operation.")
+	for _, id := range ids {
+		for _, op := range sortedOps(metrics[id]) {
+			m := metrics[id][op]
+			pw.histogram("dvid_storage_op_duration_seconds", fmt.Sprintf(`instance_id="%d",op="%s"`, id, op), bounds, m.Latency, m.Seconds)
+		}
+	}
+}
+
+func sortedOps(ops map[string]storage.OpMetrics) []string {
+	names := make([]string, 0, len(ops))
+	for op := range ops {
+		names = append(names, op)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteMetrics writes all server metrics in the Prometheus text exposition format.
+func WriteMetrics(w io.Writer) error {
+	pw := promWriter{bufio.NewWriter(w)}
+
+	writeHTTPMetrics(pw)
+
+	pw.header("dvid_active_handlers", "gauge", "Maximum number of active chunk handlers over the last second.")
+	pw.sample("dvid_active_handlers", "", ActiveHandlers)
+	pw.header("dvid_handler_tokens_in_use", "gauge", "Chunk handler tokens currently in use.")
+	pw.sample("dvid_handler_tokens_in_use", "", MaxChunkHandlers-len(HandlerToken))
+	pw.header("dvid_handler_tokens", "gauge", "Maximum number of concurrent chunk handlers.")
+	pw.sample("dvid_handler_tokens", "", MaxChunkHandlers)
+	pw.header("dvid_throttle_slots", "gauge", "Maximum number of concurrent throttled operations.")
+	pw.sample("dvid_throttle_slots", "", throttle.Slots())
+	pw.header("dvid_interactive_requests", "gauge", "Interactive requests over the last 2 minutes.")
+	pw.sample("dvid_interactive_requests", "", InteractiveOpsPer2Min)
+	pw.header("dvid_corrupt_values_total", "counter", "Stored values that failed checksum verification.")
+	pw.sample("dvid_corrupt_values_total", "", dvid.CorruptValues())
+	pw.header("dvid_rate_limited_requests_total", "counter", "HTTP requests refused because the client exceeded its rate limit.")
+	pw.sample("dvid_rate_limited_requests_total", "", atomic.LoadUint64(&rateLimited))
+
+	jobs := make(map[string]int)
+	for _, job := range datastore.GetJobs() {
+		if job.State == datastore.JobRunning {
+			jobs[job.Kind]++
+		}
+	}
+	pw.header("dvid_running_jobs", "gauge", "Running background jobs by kind.")
+	kinds := make([]string, 0, len(jobs))
+	for kind := range jobs {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		pw.sample("dvid_running_jobs", fmt.Sprintf(`kind="%s"`, labelValue(kind)), jobs[kind])
+	}
+
+	writeStorageMetrics(pw)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	pw.header("dvid_goroutines", "gauge", "Number of goroutines.")
+	pw.sample("dvid_goroutines", "", runtime.NumGoroutine())
+	pw.header("dvid_heap_bytes", "gauge", "Bytes of allocated heap objects.")
+	pw.sample("dvid_heap_bytes", "", mem.HeapAlloc)
+	pw.header("dvid_uptime_seconds", "gauge", "Seconds since the server started.")
+	pw.sample("dvid_uptime_seconds", "", time.Since(startupTime).Seconds())
+
+	return pw.Flush()
+}
+
+func metricsExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := WriteMetrics(w); err != nil {
+		dvid.Errorf("Unable to write metrics: %v\n", err)
+	}
+}
@@ -0,0 +1,382 @@
+/*
+	This file implements validation of JSON Web Tokens (JWTs) issued by an OpenID Connect
+	(OIDC) provider, so institutional single sign-on can front DVID.  Tokens are verified
+	using the provider's published JSON Web Key Set (JWKS), and the user identity and scopes
+	are taken from configured claims.
+*/
+
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+var (
+	// JWTLeeway is the allowed clock skew when checking the times in JWTs.
+	JWTLeeway = time.Minute
+
+	// JWKSRefreshInterval is the minimum time between fetches of the JWKS, which is
+	// refetched when a token is signed by an unknown key.
+	JWKSRefreshInterval = time.Minute
+)
+
+type oidcConfig struct {
+	Issuer        string   // expected "iss" claim, also used to discover the JWKS URL
+	JWKSURL       string   `toml:"jwks_url"` // URL of the issuer's JWKS
+	Audience      string   // expected "aud" claim, e.g., the client ID of DVID
+	UserClaim     string   `toml:"user_claim"`     // claim giving the user, "email" by default
+	ScopesClaim   string   `toml:"scopes_claim"`   // claim giving DVID scopes, "scope" by default
+	DefaultScopes []string `toml:"default_scopes"` // scopes of users without a scopes claim
+}
+
+// jwtVerifier validates JWTs from a configured OIDC issuer.
+type jwtVerifier struct {
+	config oidcConfig
+	client *http.Client
+
+	sync.Mutex
+	keys    map[string]crypto.PublicKey // keyed by key ID
+	fetched time.Time
+}
+
+// oidcVerifier is set if JWTs from an OIDC issuer are accepted as bearer tokens.
+var oidcVerifier *jwtVerifier
+
+// startOIDC sets the JWT verifier for the configured OIDC issuer, discovering the JWKS
+// URL if it isn't given, and loads the issuer's keys.
+func startOIDC(c oidcConfig) error {
+	if c.Issuer == "" {
+		if c.JWKSURL != "" {
+			return fmt.Errorf("OIDC issuer must be set to accept JWTs")
+		}
+		return nil
+	}
+	if c.UserClaim == "" {
+		c.UserClaim = "email"
+	}
+	if c.ScopesClaim == "" {
+		c.ScopesClaim = "scope"
+	}
+	v := &jwtVerifier{
+		config: c,
+		client: &http.Client{Timeout: WebhookTimeout},
+	}
+	if v.config.JWKSURL == "" {
+		url, err := v.discoverJWKS()
+		if err != nil {
+			return fmt.Errorf("unable to discover JWKS URL of OIDC issuer %s: %v", c.Issuer, err)
+		}
+		v.config.JWKSURL = url
+	}
+	v.Lock()
+	err := v.fetchKeys()
+	v.Unlock()
+	if err != nil {
+		return err
+	}
+	oidcVerifier = v
+	dvid.Infof("Accepting JWTs from OIDC issuer %s with keys from %s\n", c.Issuer, v.config.JWKSURL)
+	return nil
+}
+
+// isJWT returns true if a bearer token looks like a JWT rather than an API token.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func (v *jwtVerifier) getJSON(url string, target interface{}) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func (v *jwtVerifier) discoverJWKS() (string, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	url := strings.TrimSuffix(v.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := v.getJSON(url, &discovery); err != nil {
+		return "", err
+	}
+	if discovery.JWKSURI == "" {
+		return "", fmt.Errorf("no jwks_uri in %s", url)
+	}
+	return discovery.JWKSURI, nil
+}
+
+// jsonWebKey is a public key in a JWKS.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("EC key isn't on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// fetchKeys loads the signing keys in the JWKS.  The caller must hold the lock.
+func (v *jwtVerifier) fetchKeys() error {
+	v.fetched = time.Now()
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := v.getJSON(v.config.JWKSURL, &jwks); err != nil {
+		return fmt.Errorf("unable to fetch JWKS: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			dvid.Errorf("Skipping JWKS key %q from %s: %v\n", k.Kid, v.config.JWKSURL, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	v.keys = keys
+	return nil
+}
+
+// key returns the public key with the given ID, refetching the JWKS if the key is unknown
+// and the keys weren't recently fetched, e.g., after the issuer rotates its keys.
+func (v *jwtVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.Lock()
+	defer v.Unlock()
+	if key, found := v.keys[kid]; found {
+		return key, nil
+	}
+	if time.Since(v.fetched) >= JWKSRefreshInterval {
+		if err := v.fetchKeys(); err != nil {
+			return nil, err
+		}
+		if key, found := v.keys[kid]; found {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("JWT signed by unknown key %q", kid)
+}
+
+// verify checks the signature, issuer, audience, and times of a JWT and returns its claims.
+func (v *jwtVerifier) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("bad JWT header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("bad JWT signature encoding: %v", err)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("bad JWT claims: %v", err)
+	}
+	if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+		return nil, fmt.Errorf("JWT issued by %q rather than %q", iss, v.config.Issuer)
+	}
+	if v.config.Audience != "" && !hasAudience(claims["aud"], v.config.Audience) {
+		return nil, fmt.Errorf("JWT not issued for audience %q", v.config.Audience)
+	}
+	now := time.Now()
+	exp, found := claims["exp"].(float64)
+	if !found {
+		return nil, fmt.Errorf("JWT has no expiration")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(JWTLeeway)) {
+		return nil, fmt.Errorf("JWT expired")
+	}
+	if nbf, found := claims["nbf"].(float64); found && now.Add(JWTLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("JWT not yet valid")
+	}
+	return claims, nil
+}
+
+// authenticate returns the user and DVID scopes given by a valid JWT.
+func (v *jwtVerifier) authenticate(token string) (user string, scopes []string, err error) {
+	claims, err := v.verify(token)
+	if err != nil {
+		return "", nil, err
+	}
+	user, _ = claims[v.config.UserClaim].(string)
+	if user == "" {
+		user, _ = claims["sub"].(string)
+	}
+	if user == "" {
+		return "", nil, fmt.Errorf("JWT has no %q or \"sub\" claim", v.config.UserClaim)
+	}
+	switch s := claims[v.config.ScopesClaim].(type) {
+	case string: // space-separated like the OAuth 2.0 "scope" claim
+		scopes = strings.Fields(s)
+	case []interface{}:
+		for _, scope := range s {
+			if str, ok := scope.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+	default:
+		scopes = v.config.DefaultScopes
+	}
+	return user, scopes, nil
+}
+
+func decodeJWTPart(part string, target interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, target)
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []interface{}:
+		for _, s := range a {
+			if s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyJWTSignature checks the signature of the signed JWT header and payload.  Only
+// asymmetric algorithms are allowed since the keys come from the issuer's JWKS.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	if hash == 0 {
+		return fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("JWT algorithm %s doesn't match key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, hash, digest, sig); err != nil {
+			return fmt.Errorf("bad JWT signature")
+		}
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("JWT algorithm %s doesn't match key", alg)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("bad JWT signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("bad JWT signature")
+		}
+	default:
+		return fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+	return nil
+}
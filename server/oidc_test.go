@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCTokens(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := fmt.Sprintf(`{"keys": [{"kty": "RSA", "kid": "k1", "use": "sig", "n": %q, "e": %q}]}`,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()))
+	var issuer string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": "%s/keys"}`, issuer, issuer)
+		case "/keys":
+			fmt.Fprint(w, jwks)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	issuer = ts.URL
+
+	if err := startOIDC(oidcConfig{Issuer: issuer, Audience: "dvid", DefaultScopes: []string{"write"}}); err != nil {
+		t.Fatalf("unable to start OIDC: %v\n", err)
+	}
+	defer func() { oidcVerifier = nil }()
+
+	claims := map[string]interface{}{
+		"iss":   issuer,
+		"aud":   []string{"other", "dvid"},
+		"email": "jane@example.org",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	user, scopes, err := authenticate(signTestJWT(t, key, "k1", claims))
+	if err != nil {
+		t.Fatalf("expected valid JWT: %v\n", err)
+	}
+	if user != "jane@example.org" || !hasScope(scopes, "write") || hasScope(scopes, "admin") {
+		t.Errorf("bad user %q or scopes %v from JWT\n", user, scopes)
+	}
+
+	claims["scope"] = "openid admin"
+	if _, scopes, err = authenticate(signTestJWT(t, key, "k1", claims)); err != nil || !hasScope(scopes, "admin") {
+		t.Errorf("expected admin scope from scope claim, got %v: %v\n", scopes, err)
+	}
+
+	claims["aud"] = "other"
+	if _, _, err := authenticate(signTestJWT(t, key, "k1", claims)); err == nil {
+		t.Errorf("expected JWT for other audience to be rejected\n")
+	}
+	claims["aud"] = "dvid"
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	if _, _, err := authenticate(signTestJWT(t, key, "k1", claims)); err == nil {
+		t.Errorf("expected expired JWT to be rejected\n")
+	}
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := authenticate(signTestJWT(t, other, "k1", claims)); err == nil {
+		t.Errorf("expected JWT with bad signature to be rejected\n")
+	}
+}
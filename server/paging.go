@@ -0,0 +1,135 @@
+/*
+	This file supports pagination and field selection of listing endpoints so clients can
+	walk huge listings, e.g., the keys of a keyvalue instance, a page at a time.  A page
+	is requested with "limit", "offset", and "cursor" query parameters, and if more items
+	remain, the response has an X-Next-Cursor header and a Link header to the next page.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NextCursorHeader is the response header giving the cursor of a listing's next page.
+const NextCursorHeader = "X-Next-Cursor"
+
+// Page gives the items of a listing to return: after skipping any items up to and
+// including the Cursor item, skip Offset items and return at most Limit items.
+type Page struct {
+	Limit  int    // 0 if all items should be returned
+	Offset int    // items to skip after the cursor
+	Cursor string // the last item of the previous page, or "" for the first page
+}
+
+// Paged returns true if the listing may be cut short.
+func (p Page) Paged() bool {
+	return p.Limit > 0 || p.Offset > 0 || p.Cursor != ""
+}
+
+// ParsePage returns the pagination parameters of a listing request.
+func ParsePage(r *http.Request) (Page, error) {
+	var p Page
+	query := r.URL.Query()
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"limit", &p.Limit}, {"offset", &p.Offset}} {
+		s := query.Get(param.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return Page{}, fmt.Errorf("bad %s %q: must be a non-negative integer", param.name, s)
+		}
+		*param.value = n
+	}
+	p.Cursor = query.Get("cursor")
+	return p, nil
+}
+
+// Bounds returns the start and end indices of the page within items sorted in ascending
+// order.
+func (p Page) Bounds(sorted []string) (start, end int) {
+	if p.Cursor != "" {
+		start = sort.SearchStrings(sorted, p.Cursor)
+		if start < len(sorted) && sorted[start] == p.Cursor {
+			start++
+		}
+	}
+	start += p.Offset
+	n := len(sorted)
+	if start > n {
+		start = n
+	}
+	end = n
+	if p.Limit > 0 && start+p.Limit < n {
+		end = start + p.Limit
+	}
+	return start, end
+}
+
+// SetNextPage sets the X-Next-Cursor header and a Link header to the next page of a
+// listing whose last returned item is given as the cursor.
+func SetNextPage(w http.ResponseWriter, r *http.Request, cursor string) {
+	next := *r.URL
+	query := next.Query()
+	query.Set("cursor", cursor)
+	query.Del("offset")
+	next.RawQuery = query.Encode()
+	w.Header().Set(NextCursorHeader, cursor)
+	w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+}
+
+// parseFields returns the comma-separated fields of a request's "fields" parameter.
+// Nested fields are given as dotted paths, e.g., "Base.TypeName".
+func parseFields(r *http.Request) []string {
+	s := r.URL.Query().Get("fields")
+	if s == "" {
+		return nil
+	}
+	var fields []string
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// selectFields returns a JSON object with only the given fields of an object.  Missing
+// fields are omitted.
+func selectFields(item json.RawMessage, fields []string) (json.RawMessage, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(item, &object); err != nil {
+		return nil, err
+	}
+	whole := make(map[string]bool)
+	nested := make(map[string][]string)
+	for _, field := range fields {
+		if pos := strings.Index(field, "."); pos >= 0 {
+			nested[field[:pos]] = append(nested[field[:pos]], field[pos+1:])
+		} else {
+			whole[field] = true
+		}
+	}
+	selected := make(map[string]json.RawMessage)
+	for name, value := range object {
+		if whole[name] {
+			selected[name] = value
+		} else if subfields, found := nested[name]; found {
+			subset, err := selectFields(value, subfields)
+			if err != nil {
+				return nil, fmt.Errorf("field %q isn't an object", name)
+			}
+			selected[name] = subset
+		}
+	}
+	return json.Marshal(selected)
+}
@@ -0,0 +1,202 @@
+/*
+	This file implements HTTP Range requests for GETs of data instances and repos so
+	clients can resume interrupted downloads of large volumes and exports or fetch parts
+	of them in parallel.  Handlers write full responses and the requested byte range is
+	cut from them here.
+*/
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zenazn/goji/web"
+)
+
+// MaxRangeBuffer is the maximum bytes of a response without a Content-Length that are
+// buffered to serve a Range request.  Larger responses are sent in full with status 200.
+var MaxRangeBuffer int64 = 512 * 1024 * 1024
+
+// parseByteRange parses a Range header of a single range, e.g., "bytes=0-499",
+// "bytes=500-", or "bytes=-500", for content of the given size.  It returns ok false if
+// the header isn't a single byte range, and satisfiable false if the range is outside
+// the content.  The returned end is inclusive.
+func parseByteRange(header string, size int64) (start, end int64, ok, satisfiable bool) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, 0, false, false
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	pos := strings.Index(spec, "-")
+	if pos < 0 {
+		return 0, 0, false, false
+	}
+	first, last := strings.TrimSpace(spec[:pos]), strings.TrimSpace(spec[pos+1:])
+	var err error
+	if first == "" {
+		// Suffix range of the last bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, false
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, true, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, true
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 {
+		return 0, 0, false, false
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true, start < size
+}
+
+type rangeMode int
+
+const (
+	rangeUndecided   rangeMode = iota
+	rangePassthrough           // full response as written
+	rangeStream                // known length, so only bytes in range are passed
+	rangeBuffer                // unknown length, so response is buffered and then cut
+	rangeDiscard               // range not satisfiable, so body is dropped
+)
+
+// rangeWriter cuts a requested byte range from a handler's 200 response.
+type rangeWriter struct {
+	w    http.ResponseWriter
+	r    *http.Request
+	mode rangeMode
+
+	start, end, pos int64 // range and bytes written when streaming
+	buf             bytes.Buffer
+}
+
+func (rw *rangeWriter) Header() http.Header {
+	return rw.w.Header()
+}
+
+func (rw *rangeWriter) WriteHeader(status int) {
+	if rw.mode != rangeUndecided {
+		return
+	}
+	header := rw.w.Header()
+	ifRange := rw.r.Header.Get("If-Range")
+	if status != http.StatusOK || (ifRange != "" && header.Get("ETag") != "" && ifRange != header.Get("ETag")) {
+		rw.mode = rangePassthrough
+		rw.w.WriteHeader(status)
+		return
+	}
+	size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		rw.mode = rangeBuffer
+		return
+	}
+	start, end, ok, satisfiable := parseByteRange(rw.r.Header.Get("Range"), size)
+	switch {
+	case !ok:
+		rw.mode = rangePassthrough
+		rw.w.WriteHeader(status)
+	case !satisfiable:
+		rw.mode = rangeDiscard
+		header.Del("Content-Length")
+		header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(rw.w, "requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+	default:
+		rw.mode = rangeStream
+		rw.start, rw.end = start, end
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		header.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+		rw.w.WriteHeader(http.StatusPartialContent)
+	}
+}
+
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	if rw.mode == rangeUndecided {
+		rw.WriteHeader(http.StatusOK)
+	}
+	switch rw.mode {
+	case rangeStream:
+		n := int64(len(p))
+		from, to := rw.start-rw.pos, rw.end+1-rw.pos
+		rw.pos += n
+		if from < 0 {
+			from = 0
+		}
+		if to > n {
+			to = n
+		}
+		if from < to {
+			if _, err := rw.w.Write(p[from:to]); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	case rangeBuffer:
+		if int64(rw.buf.Len()+len(p)) <= MaxRangeBuffer {
+			return rw.buf.Write(p)
+		}
+		// Too large to buffer, so give up on the range and send everything.
+		rw.mode = rangePassthrough
+		rw.w.WriteHeader(http.StatusOK)
+		if _, err := rw.w.Write(rw.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		rw.buf = bytes.Buffer{}
+		return rw.w.Write(p)
+	case rangeDiscard:
+		return len(p), nil
+	default:
+		return rw.w.Write(p)
+	}
+}
+
+// Flush sends any response written so far unless it is being buffered.
+func (rw *rangeWriter) Flush() {
+	if rw.mode == rangePassthrough || rw.mode == rangeStream {
+		if f, ok := rw.w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// finish serves the requested range of a buffered response.
+func (rw *rangeWriter) finish() {
+	if rw.mode == rangeBuffer {
+		http.ServeContent(rw.w, rw.r, "", time.Time{}, bytes.NewReader(rw.buf.Bytes()))
+	}
+}
+
+// rangeHandler is middleware that serves the byte ranges requested by GETs with a Range
+// header, advertising support with an "Accept-Ranges: bytes" header.
+func rangeHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Header.Get("Range") == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rw := &rangeWriter{w: w, r: r}
+		h.ServeHTTP(rw, r)
+		rw.finish()
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,189 @@
+/*
+	This file implements per-client rate limiting of HTTP requests.  Each client, the user
+	of a token if given or else the IP address, has token buckets for requests and bytes
+	transferred so one runaway script can't starve interactive users.
+*/
+
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zenazn/goji/web"
+	"github.com/zenazn/goji/web/mutil"
+)
+
+// rateLimitIdle is how long a client must be idle before its limits are forgotten.
+const rateLimitIdle = 10 * time.Minute
+
+type rateLimitConfig struct {
+	RequestsPerSec float64  `toml:"requests_per_sec"` // no request limit if 0.
+	RequestBurst   float64  `toml:"request_burst"`    // default: one second of requests.
+	BytesPerSec    float64  `toml:"bytes_per_sec"`    // no byte limit if 0.
+	ByteBurst      float64  `toml:"byte_burst"`       // default: one second of bytes.
+	Exempt         []string // users or IP addresses that aren't limited.
+}
+
+// bucket is a token bucket that fills at a rate up to a burst size.
+type bucket struct {
+	rate, burst, tokens float64
+	last                time.Time
+}
+
+func newBucket(rate, burst float64, now time.Time) bucket {
+	if burst <= 0 {
+		burst = math.Max(rate, 1)
+	}
+	return bucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait returns the time until the bucket has the given tokens.
+func (b *bucket) wait(need float64) time.Duration {
+	if b.rate <= 0 || b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+type clientLimits struct {
+	requests, bytes bucket
+	lastUsed        time.Time
+}
+
+type rateLimiter struct {
+	sync.Mutex
+	config    rateLimitConfig
+	exempt    map[string]struct{}
+	clients   map[string]*clientLimits
+	lastSweep time.Time
+}
+
+var (
+	limiter     *rateLimiter // nil if requests aren't limited.
+	rateLimited uint64       // requests refused
+)
+
+// setRateLimits limits the requests and bytes of each client.
+func setRateLimits(config rateLimitConfig) error {
+	if config.RequestsPerSec < 0 || config.BytesPerSec < 0 || config.RequestBurst < 0 || config.ByteBurst < 0 {
+		return fmt.Errorf("rate limits can't be negative")
+	}
+	if config.RequestsPerSec == 0 && config.BytesPerSec == 0 {
+		limiter = nil
+		return nil
+	}
+	l := &rateLimiter{
+		config:  config,
+		exempt:  make(map[string]struct{}, len(config.Exempt)),
+		clients: make(map[string]*clientLimits),
+	}
+	for _, client := range config.Exempt {
+		l.exempt[client] = struct{}{}
+	}
+	limiter = l
+	return nil
+}
+
+// allow takes a request from a client's bucket, returning the time until the client can
+// make another request if it can't make one now.
+func (l *rateLimiter) allow(client string) time.Duration {
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	if now.Sub(l.lastSweep) > time.Minute {
+		for key, limits := range l.clients {
+			if now.Sub(limits.lastUsed) > rateLimitIdle {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+	limits, found := l.clients[client]
+	if !found {
+		limits = &clientLimits{
+			requests: newBucket(l.config.RequestsPerSec, l.config.RequestBurst, now),
+			bytes:    newBucket(l.config.BytesPerSec, l.config.ByteBurst, now),
+		}
+		l.clients[client] = limits
+	}
+	limits.lastUsed = now
+	limits.requests.refill(now)
+	limits.bytes.refill(now)
+	// Bytes are charged after requests complete, so any debt must be paid before the next.
+	wait := limits.requests.wait(1)
+	if byteWait := limits.bytes.wait(0); byteWait > wait {
+		wait = byteWait
+	}
+	if wait == 0 && l.config.RequestsPerSec > 0 {
+		limits.requests.tokens--
+	}
+	return wait
+}
+
+// charge takes the bytes transferred by a request from a client's bucket.
+func (l *rateLimiter) charge(client string, bytes uint64) {
+	if l.config.BytesPerSec == 0 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	if limits, found := l.clients[client]; found {
+		limits.bytes.tokens -= float64(bytes)
+	}
+}
+
+// rateLimitClient returns the user of a request's token, or else the client's IP address.
+func rateLimitClient(c web.C, r *http.Request) string {
+	if user := RequestUser(c); user != "" {
+		return user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitHandler is middleware that refuses requests of clients over their limits with
+// status 429 (Too Many Requests) and a Retry-After header.  It must follow authHandler so
+// clients with tokens are identified.
+func rateLimitHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		l := limiter
+		if l == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		client := rateLimitClient(*c, r)
+		if _, exempt := l.exempt[client]; exempt {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if wait := l.allow(client); wait > 0 {
+			atomic.AddUint64(&rateLimited, 1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, fmt.Sprintf("Rate limit exceeded for client %s; retry in %s", client, wait.Round(time.Millisecond)), http.StatusTooManyRequests)
+			return
+		}
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		ww := mutil.WrapWriter(w)
+		h.ServeHTTP(ww, r)
+		l.charge(client, body.n+uint64(ww.BytesWritten()))
+	}
+	return http.HandlerFunc(fn)
+}
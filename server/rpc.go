@@ -1,5 +1,6 @@
 /*
-	This file handles RPC connections, usually from DVID clients.
+	This file handles commands from DVID clients, which are received over gRPC (see
+	grpc.go) or, from older clients, the legacy gorpc connection.
 	TODO: Remove all command-line commands aside from the most basic ones, and
 	   force use of the HTTP API.  Curl can be used from command line.
 */
@@ -7,8 +8,11 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
@@ -33,6 +37,11 @@ Commands executed on the server (rpc address = %s):
 	
 			The optional passcode will have to be provided to delete the repo
 			or any contained data instance.
+
+		namespace=<namespace>
+
+			The optional namespace, defined in the [namespace] section of the TOML
+			file, that holds the repo.  It can't be changed later.
 	
 	repo <UUID> branch name [optional UUID]
 
@@ -50,15 +59,114 @@ Commands executed on the server (rpc address = %s):
 
 	node <UUID> <data name> <type-specific commands>
 
+	cancel <job ID>
+
+		Cancels a running background job, e.g., a backup or garbage collection.  The job
+		stops at its next step.  Interrupting a command with Ctrl-C also cancels the jobs
+		it started.
+
+	tokens create <name> <scope> [<scope>...]
+	tokens revoke <token ID>
+	tokens list
+
+		Manages API tokens, which are required on mutating requests if "require_tokens"
+		is set in the [auth] section of the TOML file.  Scopes are "write", allowing
+		modification of repos and data, or "admin", allowing all commands.  The token is
+		given to commands with the -token flag or DVID_TOKEN environment variable.  When
+		no admin token exists, an admin token can be created without a token.
+
+		Scopes can be limited to the repos of a namespace, e.g., "write:lab1" or
+		"admin:lab1", and "read:lab1" allows reading repos of a private namespace.
+
+		Commands on repos with role assignments (see /api/repo/{uuid}/roles) require the
+		token's principal, "token:<token ID>", to have the "write" role, or "admin" for
+		deletions, renames, and restores, unless the token has the "admin" scope.
+
+	backup <archive directory> <settings...>
+
+		Streams all repo metadata and data instance key-value pairs into an archive in the
+		given server directory.  The backup runs while the server is live.  If interrupted
+		or cancelled, rerunning the command with the same directory resumes the backup.  The archive
+		manifest records the "HighWater" mutation sequence number if mutations are tracked.
+
+		Configuration Settings (case-insensitive keys)
+
+		since      If given, only key-value pairs changed by mutations after this sequence
+		           number, usually the "HighWater" of the last backup, are streamed.
+		           Requires "track_mutations" in the [backup] section of the TOML file.
+
+	migrate <source store> <destination store> <settings...>
+
+		Copies all key-value pairs from a store to another store, which can use a different
+		storage engine, where stores are specified by their nicknames in the TOML file.
+		Progress is logged periodically.  Writes to the source store during migration may
+		or may not be copied and will be reported as mismatches during verification.
+
+		Configuration Settings (case-insensitive keys)
+
+		verify     If "false", skips the pass that compares the stores after copying.
+
 DANGEROUS COMMANDS (only available via command line)
 
-	repos delete <UUID> <repo passcode if any>
+	restore <archive directory> [<archive directory> ...] <settings...>
+
+		Loads an archive created by "backup" into this server, which must have no repos.
+		An incremental archive created with "since" must instead be restored after the
+		archives it follows.  If interrupted, rerunning the command resumes the restore.
+		Restart the server after the restore completes.
 
-		Deletes an entire repo with the given root UUID.
+		If several archives are given, they must be a full backup followed by incremental
+		backups, each made since the "HighWater" of the one before.  They are restored in
+		order up to any point given by the settings below, which allows recovery from
+		operator errors by restoring into a fresh server.  Since recovery points are the
+		ends of backups, archives are only restored if all their writes precede the point.
 
-	repo <UUID> delete <data name> <repo passcode if any>
+		Configuration Settings (case-insensitive keys)
 
-		Delete the given data instance.
+		upto       Restore no archive that captures mutations after this sequence number.
+		before     Restore no archive that finished after this RFC 3339 time, e.g.,
+		           "2017-04-10T15:00:00-04:00".
+
+	repos delete <UUID> <repo passcode if any> <settings...>
+
+		Deletes an entire repo with the given root UUID.  Without a token setting, no
+		deletion occurs and a confirmation token is returned.  The command must then be
+		rerun with that token within 10 minutes to delete the repo.
+
+		token=<token>
+
+			The confirmation token returned by the first "repos delete" command.
+
+	repos deleteversion <UUID> <repo passcode if any> <settings...>
+
+		Deletes a node that isn't the root from its repo's DAG along with the key-value
+		pairs written in that version, e.g., to clean up a failed experimental branch.
+		Key-value pairs are deleted and the data instances' stores are compacted
+		asynchronously.  Tags of deleted nodes are removed.
+
+		Configuration Settings (case-insensitive keys)
+
+		descendants  If "true", the node's entire branch is deleted.  Otherwise, the
+		             node must have no children.  A branch can't be deleted if any of
+		             its nodes was merged with a node outside the branch.
+
+	repo <UUID> delete <data name> <repo passcode if any> <settings...>
+
+		Delete the given data instance.  The instance is removed from the repo immediately,
+		and its key-value pairs are deleted and compacted by a background job whose progress
+		is available via the /api/server/jobs HTTP endpoint.
+
+		Configuration Settings (case-insensitive keys)
+
+		trash        If "true", the instance is moved to the repo's trash, keeping its
+		             key-value pairs, and can be restored with the "restore" command until
+		             the server's "trash_days" setting (default 7 days) has passed.
+
+	repo <UUID> restore <data name>
+
+		Restores the most recently trashed data instance with the given name.  The name
+		must not be used by another data instance in the repo.  The repo's trash is
+		available via the /api/repo/{uuid}/trash HTTP endpoint.
 
 
 EXPERIMENTAL COMMANDS
@@ -90,13 +198,29 @@ EXPERIMENTAL COMMANDS
             for the types of filters they will use for pushes.  Examples
             include "roi:name,uuid" and "tile:xy,xz".
 		
-		transmit=[all | flatten]
+		transmit=[all | flatten | lazy]
 
 			The default transmit "all" copies all versions of the source.
 			
 			A transmit "flatten" will copy just the version specified and
 			flatten the key/values so there is no history.
 
+			A transmit "lazy" clones all versions of the source without copying
+			key/values, which are copied only as either instance changes.  Both
+			instances must use the same store, which must be listed in the [clone]
+			section of the TOML config file, and filters can't be used.
+
+	repo <UUID> flatten <alias> <description> <settings...>
+
+		Creates a new repo whose root node holds the state of all data instances at the
+		given committed node but none of its history.  Data instances keep their names,
+		properties, and syncs.  The new root UUID is logged when the copy completes,
+		after which the original repo can be deleted to reclaim its history.
+
+		passcode=<passcode>
+
+			The optional passcode of the new repo.
+
 	repo <UUID> push <remote DVID address> <settings...>
 
         A DVID-to-DVID repo copy with optional datatype-specific delimiter,
@@ -150,7 +274,9 @@ func init() {
 	gorpc.RegisterType(&datastore.Request{})
 }
 
-// SendRPC sends a request to a remote DVID.
+// SendRPC sends a request to a remote DVID over the legacy gorpc connection.
+//
+// Deprecated: Use SendCommand, which streams the progress of jobs started by commands.
 func SendRPC(addr string, req datastore.Request) error {
 	c := gorpc.NewTCPClient(addr)
 	c.Start()
@@ -169,12 +295,18 @@ func SendRPC(addr string, req datastore.Request) error {
 	return reply.Write(os.Stdout)
 }
 
-// switchboard for remote command execution
-func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error) {
+// switchboard for remote command execution from a client at the given address
+func handleCommand(clientAddr string, cmd *datastore.Request) (reply *datastore.Response, err error) {
 	if cmd.Name() == "" {
 		err = fmt.Errorf("Server error: got empty command!")
 		return
 	}
+	defer func(start time.Time) {
+		auditCommand(cmd, start, err)
+	}(time.Now())
+	if err = checkRPCToken(cmd, clientAddr); err != nil {
+		return
+	}
 	reply = new(datastore.Response)
 
 	switch cmd.Name() {
@@ -215,6 +347,130 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 			reply.Text = typeservice.Help()
 		}
 
+	case "tokens":
+		reply.Text, err = tokensCommand(cmd)
+
+	case "cancel":
+		var s string
+		cmd.CommandArgs(1, &s)
+		var id uint64
+		if id, err = strconv.ParseUint(s, 10, 64); err != nil {
+			err = fmt.Errorf("cancel requires a job ID, not %q", s)
+			return
+		}
+		if err = datastore.CancelJob(id); err != nil {
+			return
+		}
+		reply.Text = fmt.Sprintf("Cancelled job %d\n", id)
+
+	case "backup":
+		var target string
+		cmd.CommandArgs(1, &target)
+		if target == "" {
+			err = fmt.Errorf("backup requires an archive directory")
+			return
+		}
+		config := cmd.Settings()
+		var s string
+		if s, _, err = config.GetString("since"); err != nil {
+			return
+		}
+		if s != "" {
+			var since uint64
+			if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+				err = fmt.Errorf("bad 'since' mutation sequence number %q: %v", s, err)
+				return
+			}
+			job := datastore.BackupJob(target, since)
+			reply.Jobs = []uint64{job}
+			reply.Text = fmt.Sprintf("Started backup of changes since mutation %d to %q as job %d...\n", since, target, job)
+			return
+		}
+		job := datastore.BackupJob(target, 0)
+		reply.Jobs = []uint64{job}
+		reply.Text = fmt.Sprintf("Started backup to %q as job %d...\n", target, job)
+
+	case "migrate":
+		var srcName, dstName string
+		cmd.CommandArgs(1, &srcName, &dstName)
+		if srcName == "" || dstName == "" {
+			err = fmt.Errorf("migrate requires source and destination store nicknames")
+			return
+		}
+		var src, dst dvid.Store
+		if src, err = storage.GetStoreByAlias(storage.Alias(srcName)); err != nil {
+			return
+		}
+		if dst, err = storage.GetStoreByAlias(storage.Alias(dstName)); err != nil {
+			return
+		}
+		var verify, found bool
+		if verify, found, err = cmd.Settings().GetBool("verify"); err != nil {
+			return
+		}
+		if !found {
+			verify = true
+		}
+		// The migration can't be cancelled midway, but its job reports when it's done.
+		job := datastore.RunJob("migrate", srcName+" to "+dstName, func(ctx context.Context, step func(string)) error {
+			stats, err := storage.Migrate(src, dst, nil, verify)
+			if err != nil {
+				return err
+			}
+			if stats.Mismatches != 0 {
+				return fmt.Errorf("migration from %q to %q has %d mismatched keys", srcName, dstName, stats.Mismatches)
+			}
+			return nil
+		})
+		reply.Jobs = []uint64{job}
+		reply.Text = fmt.Sprintf("Started migration from store %q to store %q as job %d...\n", srcName, dstName, job)
+
+	case "restore":
+		var sources []string
+		for pos := 1; cmd.Argument(pos) != ""; pos++ {
+			sources = append(sources, cmd.Argument(pos))
+		}
+		if len(sources) == 0 {
+			err = fmt.Errorf("restore requires an archive directory")
+			return
+		}
+		config := cmd.Settings()
+		var point datastore.RestorePoint
+		var s string
+		if s, _, err = config.GetString("upto"); err != nil {
+			return
+		}
+		if s != "" {
+			if point.Mutation, err = strconv.ParseUint(s, 10, 64); err != nil {
+				err = fmt.Errorf("bad 'upto' mutation sequence number %q: %v", s, err)
+				return
+			}
+		}
+		if s, _, err = config.GetString("before"); err != nil {
+			return
+		}
+		if s != "" {
+			if point.Time, err = time.Parse(time.RFC3339, s); err != nil {
+				err = fmt.Errorf("bad 'before' time %q: %v", s, err)
+				return
+			}
+		}
+		// Restores can't be cancelled midway but resume if rerun.
+		var job uint64
+		if len(sources) > 1 || point.Mutation != 0 || !point.Time.IsZero() {
+			job = datastore.RunJob("restore", fmt.Sprintf("%d archives", len(sources)), func(ctx context.Context, step func(string)) error {
+				return datastore.RestoreTo(sources, point)
+			})
+			reply.Text = fmt.Sprintf("Started restore from %d archives as job %d...\n", len(sources), job)
+		} else {
+			source := sources[0]
+			job = datastore.RunJob("restore", source, func(ctx context.Context, step func(string)) error {
+				return datastore.Restore(source)
+			})
+			reply.Text = fmt.Sprintf("Started restore from %q as job %d...\n", source, job)
+		}
+		reply.Jobs = []uint64{job}
+
 	case "repos":
 		var subcommand string
 		cmd.CommandArgs(1, &subcommand)
@@ -240,8 +496,16 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 			if passcode, found, err = config.GetString("passcode"); err != nil {
 				return
 			}
+			var namespace string
+			if namespace, _, err = config.GetString("namespace"); err != nil {
+				return
+			}
 			var root dvid.UUID
-			root, err = datastore.NewRepo(alias, description, assign, passcode)
+			if namespace != "" {
+				root, err = datastore.NewNamespaceRepo(namespace, alias, description, assign, passcode)
+			} else {
+				root, err = datastore.NewRepo(alias, description, assign, passcode)
+			}
 			if err != nil {
 				return
 			}
@@ -267,11 +531,46 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 			if uuid, _, err = datastore.MatchingUUID(uuidStr); err != nil {
 				return
 			}
-			if err = datastore.DeleteRepo(uuid, passcode); err != nil {
+			var token string
+			var found bool
+			if token, found, err = cmd.Settings().GetString("token"); err != nil {
+				return
+			}
+			if !found {
+				if token, err = datastore.RepoDeleteToken(uuid, passcode); err != nil {
+					return
+				}
+				reply.Text = fmt.Sprintf("To confirm deletion of repo %s and all its data, rerun this command with token=%s within %s.\n",
+					uuid, token, datastore.RepoDeleteTokenTimeout)
+				return
+			}
+			if err = datastore.DeleteRepo(uuid, token); err != nil {
 				return
 			}
 			reply.Text = fmt.Sprintf("Started deletion of repo %s.\n", uuid)
 
+		case "deleteversion":
+			if err = datastore.MetadataUniversalLock(); err != nil {
+				return
+			}
+			defer datastore.MetadataUniversalUnlock()
+
+			var uuidStr, passcode string
+			cmd.CommandArgs(2, &uuidStr, &passcode)
+
+			var uuid dvid.UUID
+			if uuid, _, err = datastore.MatchingUUID(uuidStr); err != nil {
+				return
+			}
+			var descendants bool
+			if descendants, _, err = cmd.Settings().GetBool("descendants"); err != nil {
+				return
+			}
+			if err = datastore.DeleteVersion(uuid, descendants, passcode); err != nil {
+				return
+			}
+			reply.Text = fmt.Sprintf("Deleted node %s and started deletion of its key-value pairs.\n", uuid)
+
 		default:
 			err = fmt.Errorf("Unknown repos command: %q", subcommand)
 			return
@@ -428,6 +727,20 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 			}()
 			reply.Text = fmt.Sprintf("Started copy of uuid %s data instance %q to %q...\n", uuid, source, target)
 
+		case "flatten":
+			var alias, description string
+			cmd.CommandArgs(3, &alias, &description)
+			var passcode string
+			if passcode, _, err = cmd.Settings().GetString("passcode"); err != nil {
+				return
+			}
+			go func() {
+				if _, err := datastore.FlattenRepo(uuid, alias, description, passcode); err != nil {
+					dvid.Errorf("flatten error: %v\n", err)
+				}
+			}()
+			reply.Text = fmt.Sprintf("Started flattening of node %s into new repo %q...\n", uuid, alias)
+
 		case "push":
 			var target string
 			cmd.CommandArgs(3, &target)
@@ -466,12 +779,40 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 				return
 			}
 
+			var trash bool
+			if trash, _, err = cmd.Settings().GetBool("trash"); err != nil {
+				return
+			}
+			if trash {
+				if err = datastore.TrashData(uuid, dvid.InstanceName(dataname), passcode); err != nil {
+					err = fmt.Errorf("Error trashing data instance %q: %v", dataname, err)
+					return
+				}
+				reply.Text = fmt.Sprintf("Moved data instance %q of repo with root %s to trash for %s\n", dataname, uuid, datastore.TrashRetention)
+				return
+			}
+
 			// Do the deletion.  Under hood, modifies metadata immediately and launches async k/v deletion.
-			if err = datastore.DeleteDataByName(uuid, dvid.InstanceName(dataname), passcode); err != nil {
+			var job uint64
+			if job, err = datastore.DeleteData(uuid, dvid.InstanceName(dataname), passcode); err != nil {
 				err = fmt.Errorf("Error deleting data instance %q: %v", dataname, err)
 				return
 			}
-			reply.Text = fmt.Sprintf("Started deletion of data instance %q from repo with root %s\n", dataname, uuid)
+			reply.Jobs = []uint64{job}
+			reply.Text = fmt.Sprintf("Started deletion of data instance %q from repo with root %s as job %d\n", dataname, uuid, job)
+
+		case "restore":
+			if err = datastore.MetadataUniversalLock(); err != nil {
+				return
+			}
+			defer datastore.MetadataUniversalUnlock()
+
+			var dataname string
+			cmd.CommandArgs(3, &dataname)
+			if err = datastore.RestoreData(uuid, dvid.InstanceName(dataname)); err != nil {
+				return
+			}
+			reply.Text = fmt.Sprintf("Restored data instance %q from trash of repo with root %s\n", dataname, uuid)
 
 		default:
 			err = fmt.Errorf("Unknown command: %q", cmd)
@@ -499,6 +840,7 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 			return
 		}
 		err = dataservice.DoRPC(*cmd, reply)
+		datastore.RecordMutation(dataservice.DataUUID())
 		return
 
 	default:
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"runtime"
@@ -25,6 +26,9 @@ type Config interface {
 
 	// Set timing in HTTP header
 	AllowTiming() bool
+
+	// TLS settings for the HTTP server, or nil if it should serve plain HTTP.
+	TLSConfig() (*tls.Config, error)
 }
 
 // Returns configuration settings for the server, which is set by each platform-specific server code.
@@ -78,14 +82,11 @@ var (
 	// Timeout in seconds for waiting to open a datastore for exclusive access.
 	TimeoutSecs int
 
-	// maxThrottledOps sets the maximum number of concurrent CPU-heavy ops that can be
-	// performed on this server when requests are submitted using "throttled=true" query
-	// strings.  See imageblk and labelblk 3d GET/POST voxel requests.
-	maxThrottledOps int = 1
-
-	// curThrottleOps is the current number of CPU-heavy ops being performed on the server.
-	curThrottledOps int
-	curThrottleMu   sync.Mutex
+	// throttle schedules the concurrent CPU-heavy ops that can be performed on this server
+	// when requests are submitted using "throttled=true" query strings, one by default.
+	// Waiting requests are ordered by priority and client so bulk jobs don't starve
+	// interactive requests.  See imageblk and labelblk 3d GET/POST voxel requests.
+	throttle = storage.NewScheduler(1)
 
 	// Keep track of the startup time for uptime.
 	startupTime time.Time = time.Now()
@@ -161,9 +162,7 @@ func init() {
 }
 
 func SetMaxThrottleOps(maxOps int) {
-	curThrottleMu.Lock()
-	maxThrottledOps = maxOps
-	curThrottleMu.Unlock()
+	throttle.SetSlots(maxOps)
 }
 
 // GitVersion returns a git-derived string that allows recovery of the exact source code
@@ -200,11 +199,15 @@ func BlockOnInteractiveRequests(caller ...string) {
 func SetReadOnly(on bool) {
 	readonly = on
 	fullwrite = !on
+	datastore.SetLockedWrites(fullwrite)
 }
 
+// SetFullWrite allows writes to all nodes, including locked ones, both through the HTTP
+// and RPC APIs and at the storage layer.
 func SetFullWrite(on bool) {
 	fullwrite = on
 	readonly = !on
+	datastore.SetLockedWrites(fullwrite)
 }
 
 // AboutJSON returns a JSON string describing the properties of this server.
@@ -217,6 +220,7 @@ func AboutJSON() (jsonStr string, err error) {
 		"Storage backend":   storage.EnginesAvailable(),
 		"Server time":       time.Now().String(),
 		"Server uptime":     time.Since(startupTime).String(),
+		"Corrupt values":    fmt.Sprintf("%d", dvid.CorruptValues()),
 	}
 	if readonly {
 		data["Mode"] = "read only"
@@ -277,7 +281,9 @@ func Shutdown() {
 	time.Sleep(5 * time.Second)
 	datastore.Shutdown()
 	dvid.BlockOnActiveCgo()
+	stopGRPC()
 	rpc.Shutdown()
+	dvid.StopTracing()
 	dvid.Shutdown()
 	shutdownCh <- struct{}{}
 }
@@ -10,14 +10,19 @@ package server
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
-	"path/filepath"
+	"io/ioutil"
 	"net/smtp"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
@@ -31,7 +36,8 @@ const (
 	// DefaultWebAddress is the default URL of the DVID web server
 	DefaultWebAddress = "localhost:8000"
 
-	// DefaultRPCAddress is the default RPC address for command-line use of a remote DVID server
+	// DefaultRPCAddress is the default address of the legacy RPC connection used by older
+	// command-line clients and DVID-to-DVID pushes.
 	DefaultRPCAddress = "localhost:8001"
 
 	// ErrorLogFilename is the name of the server error log, stored in the datastore directory.
@@ -62,11 +68,174 @@ func init() {
 
 type tomlConfig struct {
 	Server     serverConfig
+	Instance   instanceConfig
 	Email      emailConfig
 	Logging    dvid.LogConfig
 	Store      map[storage.Alias]storeConfig
 	Backend    map[dvid.DataSpecifier]backendConfig
 	Groupcache storage.GroupcacheConfig
+	LRUCache   storage.LRUCacheConfig  `toml:"lrucache"`
+	WriteBack  storage.WriteBackConfig `toml:"writeback"`
+	Encryption storage.EncryptionConfig
+	Dedup      storage.DedupConfig
+	Clone      storage.CloneConfig
+	Chunk      storage.ChunkConfig
+	Backup     backupConfig
+	Metrics    metricsConfig
+	Compaction storage.CompactionConfig
+	Events     eventsConfig
+	Auth       authConfig
+	Tracing    dvid.TraceConfig
+	RateLimit  rateLimitConfig `toml:"ratelimit"`
+	Throttle   map[dvid.DataSpecifier]instanceThrottleConfig
+	Namespace  map[string]namespaceConfig
+	Audit      auditConfig
+	Limits     limitsConfig
+}
+
+// namespaceConfig defines a namespace grouping repos, e.g., of one lab.
+type namespaceConfig struct {
+	Quota    uint64 // storage quota in bytes for all repos in the namespace; no limit if 0.
+	IDPrefix uint32 `toml:"id_prefix"` // if non-zero, instance IDs of its repos use this prefix.
+	Private  bool   // if true, reading its repos requires a token with a scope for it.
+}
+
+// namespaces returns the datastore settings of the configured namespaces, checking that
+// none uses the instance id prefix of the server.
+func (c *tomlConfig) namespaces(gen string, prefix uint32) (map[string]datastore.NamespaceConfig, error) {
+	configs := make(map[string]datastore.NamespaceConfig, len(c.Namespace))
+	for name, nc := range c.Namespace {
+		if gen == "prefixed" && nc.IDPrefix != 0 && nc.IDPrefix == prefix {
+			return nil, fmt.Errorf("namespace %q uses the server's instance id prefix %d", name, prefix)
+		}
+		configs[name] = datastore.NamespaceConfig{Quota: nc.Quota, IDPrefix: nc.IDPrefix, Private: nc.Private}
+	}
+	return configs, datastore.CheckNamespaces(configs)
+}
+
+// instanceConfig sets how new data instance ids are allocated, overriding the older
+// instance_id_gen and instance_id_start server settings.
+type instanceConfig struct {
+	IDGen    string `toml:"id_gen"`
+	IDStart  uint32 `toml:"id_start"`
+	IDPrefix uint32 `toml:"id_prefix"`
+}
+
+type backupConfig struct {
+	TrackMutations bool `toml:"track_mutations"`
+}
+
+type metricsConfig struct {
+	Storage bool // collect per-instance storage operation metrics.
+}
+
+// ExpandEnv replaces ${VAR} references in the configuration's string values with the
+// value of the environment variable VAR, so one file can serve different deployments.
+// A default can be given as ${VAR:-default} and is used if VAR is unset or empty.
+// References to unset variables without defaults are errors, and "$${" gives a literal
+// "${".
+func (c *tomlConfig) ExpandEnv() error {
+	return expandEnvValue(reflect.ValueOf(c).Elem(), "")
+}
+
+// expandEnvValue expands environment variable references in all strings reachable from
+// a settable value, using the path to report errors.
+func expandEnvValue(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := expandEnv(v.String())
+		if err != nil {
+			return fmt.Errorf("setting %s: %v", path, err)
+		}
+		v.SetString(s)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return expandEnvValue(v.Elem(), path)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		// Values within interfaces aren't settable, so expand a copy.
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err := expandEnvValue(elem, path); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue // unexported
+			}
+			name := t.Field(i).Name
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := expandEnvValue(v.Field(i), name); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := expandEnvValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			if err := expandEnvValue(elem, fmt.Sprintf("%s.%v", path, key)); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	}
+	return nil
+}
+
+// expandEnv returns a string with its ${VAR} and ${VAR:-default} references replaced.
+func expandEnv(s string) (string, error) {
+	var out strings.Builder
+	for {
+		pos := strings.Index(s, "${")
+		if pos < 0 {
+			out.WriteString(s)
+			return out.String(), nil
+		}
+		if pos > 0 && s[pos-1] == '$' {
+			out.WriteString(s[:pos-1] + "${")
+			s = s[pos+2:]
+			continue
+		}
+		out.WriteString(s[:pos])
+		end := strings.Index(s[pos:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference in %q", s)
+		}
+		ref := s[pos+2 : pos+end]
+		s = s[pos+end+1:]
+		name, def, hasDefault := ref, "", false
+		if sep := strings.Index(ref, ":-"); sep >= 0 {
+			name, def, hasDefault = ref[:sep], ref[sep+2:], true
+		}
+		if name == "" {
+			return "", fmt.Errorf("empty variable name in ${%s}", ref)
+		}
+		value, found := os.LookupEnv(name)
+		switch {
+		case found && value != "":
+			out.WriteString(value)
+		case hasDefault:
+			out.WriteString(def)
+		case found:
+			// Set but empty without a default.
+		default:
+			return "", fmt.Errorf("environment variable %q is not set and has no default", name)
+		}
+	}
 }
 
 // Some settings in the TOML can be given as relative paths.
@@ -76,13 +245,13 @@ func (c *tomlConfig) ConvertPathsToAbsolute(configPath string) error {
 	var err error
 
 	configDir := filepath.Dir(configPath)
-	
+
 	// [server].webClient
 	c.Server.WebClient, err = dvid.ConvertToAbsolute(c.Server.WebClient, configDir)
 	if err != nil {
 		return fmt.Errorf("Error converting webClient setting to absolute path")
 	}
-	
+
 	// [logging].logfile
 	c.Logging.Logfile, err = dvid.ConvertToAbsolute(c.Logging.Logfile, configDir)
 	if err != nil {
@@ -105,6 +274,26 @@ func (c *tomlConfig) ConvertPathsToAbsolute(configPath string) error {
 		}
 		sc["path"] = absPath
 	}
+
+	// [server] TLS certificate, key, and client CA files
+	for _, path := range []*string{&c.Server.TLSCert, &c.Server.TLSKey, &c.Server.TLSClientCA} {
+		if *path == "" {
+			continue
+		}
+		absPath, err := dvid.ConvertToAbsolute(*path, configDir)
+		if err != nil {
+			return fmt.Errorf("Error converting TLS file setting to absolute path: %q", *path)
+		}
+		*path = absPath
+	}
+
+	// [encryption].keyfile
+	if c.Encryption.KeyFile != "" {
+		c.Encryption.KeyFile, err = dvid.ConvertToAbsolute(c.Encryption.KeyFile, configDir)
+		if err != nil {
+			return fmt.Errorf("Error converting encryption keyfile setting to absolute path")
+		}
+	}
 	return nil
 }
 
@@ -155,16 +344,118 @@ func (c *tomlConfig) AllowTiming() bool {
 	return c.Server.AllowTiming
 }
 
+// TLSConfig returns the TLS settings for the HTTP server, loading its certificate and any
+// client CA certificates, or nil if no certificate is configured.
+func (c *tomlConfig) TLSConfig() (*tls.Config, error) {
+	s := c.Server
+	if s.TLSCert == "" && s.TLSKey == "" {
+		if s.TLSClientCA != "" || s.TLSClientAuth != "" || s.TLSMinVersion != "" {
+			return nil, fmt.Errorf("TLS settings require tls_cert and tls_key")
+		}
+		return nil, nil
+	}
+	if s.TLSCert == "" || s.TLSKey == "" {
+		return nil, fmt.Errorf("TLS requires both tls_cert and tls_key to be set")
+	}
+	cert, err := tls.LoadX509KeyPair(s.TLSCert, s.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load TLS certificate: %v", err)
+	}
+	minVersion, err := parseTLSVersion(s.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := parseTLSClientAuth(s.TLSClientAuth, s.TLSClientCA != "")
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		ClientAuth:   clientAuth,
+	}
+	if s.TLSClientCA != "" {
+		pem, err := ioutil.ReadFile(s.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read TLS client CA file: %v", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS client CA file %q", s.TLSClientCA)
+		}
+	} else if clientAuth >= tls.VerifyClientCertIfGiven {
+		return nil, fmt.Errorf("tls_client_auth %q requires tls_client_ca", s.TLSClientAuth)
+	}
+	return cfg, nil
+}
+
+// parseTLSVersion returns the TLS version for a setting like "1.2", defaulting to TLS 1.2.
+func parseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown tls_min_version %q, must be one of 1.0, 1.1, 1.2, or 1.3", s)
+	}
+}
+
+// parseTLSClientAuth returns the client certificate policy for a setting.  If no policy
+// is given, client certificates are required and verified if client CAs are given.
+func parseTLSClientAuth(s string, haveCA bool) (tls.ClientAuthType, error) {
+	switch s {
+	case "":
+		if haveCA {
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.NoClientCert, nil
+	case "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAnyClientCert, nil
+	case "verify-if-given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require-and-verify":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unknown tls_client_auth %q", s)
+	}
+}
+
 type serverConfig struct {
 	Host        string
 	HTTPAddress string
 	RPCAddress  string
+	GRPCAddress string
 	WebClient   string
 	AllowTiming bool
 	Note        string
 
 	IIDGen   string `toml:"instance_id_gen"`
 	IIDStart uint32 `toml:"instance_id_start"`
+
+	Compression string // default compression of new data instances, e.g., "lz4" or "zstd:3"
+	Checksum    string // default checksum of new data instances, "none" or "crc32"
+
+	VerifyChecksums bool `toml:"verify_checksums"` // verify checksums of values sent without deserialization
+
+	TrashDays int `toml:"trash_days"` // days trashed data instances can be restored
+
+	LazyLoad bool `toml:"lazy_load"` // load repos on first access rather than at startup
+
+	// HTTPS is served on HTTPAddress if a certificate and key are given.
+	TLSCert       string `toml:"tls_cert"`        // PEM certificate file, which may include intermediates
+	TLSKey        string `toml:"tls_key"`         // PEM private key file
+	TLSMinVersion string `toml:"tls_min_version"` // "1.0", "1.1", "1.2", or "1.3"
+	TLSClientCA   string `toml:"tls_client_ca"`   // PEM file of CAs for verifying client certificates
+	TLSClientAuth string `toml:"tls_client_auth"` // client certificate policy, e.g., "require-and-verify"
 }
 
 type storeConfig map[string]interface{}
@@ -172,6 +463,12 @@ type storeConfig map[string]interface{}
 type backendConfig struct {
 	Store storage.Alias
 	Log   storage.Alias
+
+	// Tiered storage where reads fall through from hot to cold store and
+	// keys untouched for DemoteDays are moved to the cold store.
+	Hot        storage.Alias
+	Cold       storage.Alias
+	DemoteDays int `toml:"demote_days"`
 }
 
 type emailConfig struct {
@@ -194,6 +491,9 @@ func LoadConfig(filename string) (*datastore.InstanceConfig, *dvid.LogConfig, *s
 	if _, err := toml.DecodeFile(filename, &tc); err != nil {
 		return nil, nil, nil, fmt.Errorf("Could not decode TOML config: %v\n", err)
 	}
+	if err := tc.ExpandEnv(); err != nil {
+		return nil, nil, nil, fmt.Errorf("Could not expand environment variables in TOML config: %v\n", err)
+	}
 	var err error
 	err = tc.ConvertPathsToAbsolute(filename)
 	if err != nil {
@@ -203,6 +503,16 @@ func LoadConfig(filename string) (*datastore.InstanceConfig, *dvid.LogConfig, *s
 	// Get all defined stores.
 	backend := new(storage.Backend)
 	backend.Groupcache = tc.Groupcache
+	backend.LRUCache = tc.LRUCache
+	backend.WriteBack = tc.WriteBack
+	backend.Encryption = tc.Encryption
+	backend.Dedup = tc.Dedup
+	backend.Clone = tc.Clone
+	backend.Chunk = tc.Chunk
+	backend.TrackMutations = tc.Backup.TrackMutations
+	backend.StoreMetrics = tc.Metrics.Storage
+	backend.Tracing = tc.Tracing.Endpoint != ""
+	backend.Compaction = tc.Compaction
 	backend.Stores, err = tc.Stores()
 	if err != nil {
 		return nil, nil, nil, err
@@ -218,13 +528,43 @@ func LoadConfig(filename string) (*datastore.InstanceConfig, *dvid.LogConfig, *s
 	// Create the backend mapping.
 	backend.KVStore = make(map[dvid.DataSpecifier]storage.Alias)
 	backend.LogStore = make(map[dvid.DataSpecifier]storage.Alias)
+	backend.Tiers = make(map[dvid.DataSpecifier]storage.TierConfig)
 	for k, v := range tc.Backend {
+		spec := dvid.DataSpecifier(strings.Trim(string(k), "\""))
+		if v.Hot != "" || v.Cold != "" {
+			if spec == "default" || spec == "metadata" {
+				return nil, nil, nil, fmt.Errorf("Backend for %q cannot use tiered hot/cold stores", k)
+			}
+			if v.Hot == "" || v.Cold == "" {
+				return nil, nil, nil, fmt.Errorf("Backend for %q must specify both hot and cold stores", k)
+			}
+			for _, alias := range []storage.Alias{v.Hot, v.Cold} {
+				if _, found := backend.Stores[alias]; !found {
+					return nil, nil, nil, fmt.Errorf("Backend for %q specifies unknown store %q", k, alias)
+				}
+			}
+			if v.DemoteDays < 0 {
+				return nil, nil, nil, fmt.Errorf("Backend for %q has negative demote_days", k)
+			}
+			backend.Tiers[spec] = storage.TierConfig{
+				Hot:         v.Hot,
+				Cold:        v.Cold,
+				DemoteAfter: time.Duration(v.DemoteDays) * 24 * time.Hour,
+			}
+			dvid.Infof("backend.Tiers[%s] = hot %s, cold %s, demote after %d days\n", spec, v.Hot, v.Cold, v.DemoteDays)
+			if v.Store == "" {
+				if v.Log != "" {
+					backend.LogStore[spec] = v.Log
+				}
+				continue
+			}
+		}
+
 		// lookup store config
 		_, found := backend.Stores[v.Store]
 		if !found {
 			return nil, nil, nil, fmt.Errorf("Backend for %q specifies unknown store %q", k, v.Store)
 		}
-		spec := dvid.DataSpecifier(strings.Trim(string(k), "\""))
 		backend.KVStore[spec] = v.Store
 		dvid.Infof("backend.KVStore[%s] = %s\n", spec, v.Store)
 		if v.Log != "" {
@@ -244,9 +584,13 @@ func LoadConfig(filename string) (*datastore.InstanceConfig, *dvid.LogConfig, *s
 		backend.DefaultLog = defaultLog
 	}
 
+	// Metadata goes to the store given by backend.metadata, else any store with the
+	// "metadata" alias, so repo and DAG metadata can be kept apart from bulk data.
 	defaultMetadataName, found := backend.KVStore["metadata"]
 	if found {
 		backend.Metadata = defaultMetadataName
+	} else if _, found := backend.Stores["metadata"]; found {
+		backend.Metadata = "metadata"
 	} else {
 		if backend.DefaultKVDB == "" {
 			return nil, nil, nil, fmt.Errorf("can't set metadata if no default backend specified, must have exactly one store defined in config file")
@@ -254,11 +598,40 @@ func LoadConfig(filename string) (*datastore.InstanceConfig, *dvid.LogConfig, *s
 		backend.Metadata = backend.DefaultKVDB
 	}
 
+	dvid.VerifyChecksums = tc.Server.VerifyChecksums
+
 	// The server config could be local, cluster, gcloud-specific config.  Here it is local.
 	config = &tc
 	ic := datastore.InstanceConfig{
-		Gen:   tc.Server.IIDGen,
-		Start: dvid.InstanceID(tc.Server.IIDStart),
+		Gen:    tc.Server.IIDGen,
+		Start:  dvid.InstanceID(tc.Server.IIDStart),
+		Prefix: tc.Instance.IDPrefix,
+
+		Compression: tc.Server.Compression,
+		Checksum:    tc.Server.Checksum,
+
+		TrashRetention: time.Duration(tc.Server.TrashDays) * 24 * time.Hour,
+
+		LazyLoad: tc.Server.LazyLoad,
+	}
+	if tc.Instance.IDGen != "" {
+		ic.Gen = tc.Instance.IDGen
+	}
+	if tc.Instance.IDStart != 0 {
+		ic.Start = dvid.InstanceID(tc.Instance.IDStart)
+	}
+	if _, err := datastore.NewInstanceIDAllocator(ic.Gen, ic.Prefix); err != nil {
+		return nil, nil, nil, err
+	}
+	namespaces, err := tc.namespaces(ic.Gen, ic.Prefix)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := datastore.SetNamespaces(namespaces); err != nil {
+		return nil, nil, nil, err
+	}
+	if _, err := tc.TLSConfig(); err != nil {
+		return nil, nil, nil, err
 	}
 	return &ic, &(tc.Logging), backend, nil
 }
@@ -337,18 +710,63 @@ func Serve() {
 	if tc.Server.RPCAddress == "" {
 		tc.Server.RPCAddress = DefaultRPCAddress
 	}
+	if tc.Server.GRPCAddress == "" {
+		tc.Server.GRPCAddress = DefaultGRPCAddress
+	}
 
 	dvid.Infof("------------------\n")
 	dvid.Infof("DVID code version: %s\n", gitVersion)
-	dvid.Infof("Serving HTTP on %s (host alias %q)\n", tc.Server.HTTPAddress, tc.Server.Host)
-	dvid.Infof("Serving command-line use via RPC %s\n", tc.Server.RPCAddress)
+	if tc.Server.TLSCert != "" {
+		dvid.Infof("Serving HTTPS on %s (host alias %q)\n", tc.Server.HTTPAddress, tc.Server.Host)
+	} else {
+		dvid.Infof("Serving HTTP on %s (host alias %q)\n", tc.Server.HTTPAddress, tc.Server.Host)
+	}
+	dvid.Infof("Serving command-line use via gRPC %s and legacy RPC %s\n", tc.Server.GRPCAddress, tc.Server.RPCAddress)
 	dvid.Infof("Using web client files from %s\n", tc.Server.WebClient)
 	dvid.Infof("Using %d of %d logical CPUs for DVID.\n", dvid.NumCPU, runtime.NumCPU())
 
+	if err := startOIDC(tc.Auth.OIDC); err != nil {
+		dvid.Criticalf("Unable to accept JWTs from OIDC issuer: %v\n", err)
+	}
+	if err := dvid.StartTracing(tc.Tracing); err != nil {
+		dvid.Errorf("Unable to export trace spans: %v\n", err)
+	}
+	if tc.Auth.RequireTokens {
+		SetRequireTokens(true)
+		dvid.Infof("Requiring API tokens on mutating requests.\n")
+	}
+	SetBootstrapSecret(tc.Auth.BootstrapSecret)
+	if tc.Audit.Enabled {
+		SetAuditing(true)
+		dvid.Infof("Recording mutating requests in the audit log.\n")
+	}
+	if err := setRateLimits(tc.RateLimit); err != nil {
+		dvid.Errorf("Not limiting request rates: %v\n", err)
+	} else if limiter != nil {
+		dvid.Infof("Limiting each client to %g requests/sec and %g bytes/sec (0 is unlimited)\n", tc.RateLimit.RequestsPerSec, tc.RateLimit.BytesPerSec)
+	}
+
+	if err := setRequestLimits(tc.Limits); err != nil {
+		dvid.Errorf("Using default request timeouts without a maximum body size: %v\n", err)
+	}
+
+	if err := setInstanceThrottles(tc.Throttle); err != nil {
+		dvid.Errorf("Not throttling datatypes or data instances: %v\n", err)
+	}
+
+	if err := startEventNotifiers(tc.Events); err != nil {
+		dvid.Errorf("Unable to start datastore event notifiers: %v\n", err)
+	}
+
 	// Launch the web server
 	go serveHTTP()
 
-	// Launch the rpc server
+	// Launch the rpc servers
+	go func() {
+		if err := startGRPC(tc.Server.GRPCAddress); err != nil {
+			dvid.Criticalf("Could not start gRPC server: %v\n", err)
+		}
+	}()
 	go func() {
 		if err := rpc.StartServer(tc.Server.RPCAddress); err != nil {
 			dvid.Criticalf("Could not start RPC server: %v\n", err)
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"crypto/tls"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+
 	"github.com/janelia-flyem/dvid/storage"
 )
 
@@ -35,6 +38,9 @@ func TestParseConfig(t *testing.T) {
 	if backendCfg.DefaultKVDB != "raid6" || backendCfg.DefaultLog != "mutationlog" || backendCfg.KVStore["grayscale:99ef22cd85f143f58a623bd22aad0ef7"] != "kvautobus" {
 		t.Errorf("Bad backend configuration retrieval: %v\n", backendCfg)
 	}
+	if backendCfg.Metadata != "metadata" {
+		t.Errorf("Expected metadata in store %q, got %q\n", "metadata", backendCfg.Metadata)
+	}
 }
 
 func TestTOMLConfigAbsolutePath(t *testing.T) {
@@ -82,3 +88,122 @@ func TestTOMLConfigAbsolutePath(t *testing.T) {
 		t.Errorf("[store.bar].path was already absolute and should have been left unchanged: %s", path)
 	}
 }
+
+func TestTLSConfigSettings(t *testing.T) {
+	var c tomlConfig
+	if cfg, err := c.TLSConfig(); err != nil || cfg != nil {
+		t.Errorf("expected no TLS without certificate, got %v, %v\n", cfg, err)
+	}
+	c.Server.TLSMinVersion = "1.3"
+	if _, err := c.TLSConfig(); err == nil {
+		t.Errorf("expected error for TLS settings without certificate\n")
+	}
+	c.Server.TLSMinVersion = ""
+	c.Server.TLSCert = "server.crt"
+	if _, err := c.TLSConfig(); err == nil {
+		t.Errorf("expected error for TLS certificate without key\n")
+	}
+
+	if v, err := parseTLSVersion(""); err != nil || v != tls.VersionTLS12 {
+		t.Errorf("expected default TLS 1.2, got %x, %v\n", v, err)
+	}
+	if _, err := parseTLSVersion("1.4"); err == nil {
+		t.Errorf("expected error for unknown TLS version\n")
+	}
+	if auth, _ := parseTLSClientAuth("", true); auth != tls.RequireAndVerifyClientCert {
+		t.Errorf("expected client certs verified by default with client CAs, got %v\n", auth)
+	}
+	if auth, _ := parseTLSClientAuth("", false); auth != tls.NoClientCert {
+		t.Errorf("expected no client certs by default without client CAs, got %v\n", auth)
+	}
+	if _, err := parseTLSClientAuth("always", false); err == nil {
+		t.Errorf("expected error for unknown client auth policy\n")
+	}
+}
+
+func TestTOMLConfigExpandEnv(t *testing.T) {
+	os.Setenv("DVID_TEST_DATA_DIR", "/data/dvid")
+	os.Setenv("DVID_TEST_EMPTY", "")
+	defer os.Unsetenv("DVID_TEST_DATA_DIR")
+	defer os.Unsetenv("DVID_TEST_EMPTY")
+
+	var c tomlConfig
+	c.Server.HTTPAddress = "${DVID_TEST_HOST:-localhost}:${DVID_TEST_PORT:-8000}"
+	c.Logging.Logfile = "${DVID_TEST_DATA_DIR}/logs/dvid.log"
+	c.Email.Password = "${DVID_TEST_EMPTY:-default}$${NOT_EXPANDED}"
+	c.Store = map[storage.Alias]storeConfig{
+		"raid6": {"engine": "basholeveldb", "path": "${DVID_TEST_DATA_DIR}/raid6"},
+	}
+	if err := c.ExpandEnv(); err != nil {
+		t.Fatalf("error expanding environment variables: %v\n", err)
+	}
+	if c.Server.HTTPAddress != "localhost:8000" {
+		t.Errorf("expected defaults used for HTTP address, got %q\n", c.Server.HTTPAddress)
+	}
+	if c.Logging.Logfile != "/data/dvid/logs/dvid.log" {
+		t.Errorf("bad expansion of logfile: %q\n", c.Logging.Logfile)
+	}
+	if c.Email.Password != "default${NOT_EXPANDED}" {
+		t.Errorf("bad expansion of empty variable or escape: %q\n", c.Email.Password)
+	}
+	if path := c.Store["raid6"]["path"]; path != "/data/dvid/raid6" {
+		t.Errorf("bad expansion of store path: %v\n", path)
+	}
+
+	c.Server.Host = "${DVID_TEST_UNSET_VARIABLE}"
+	if err := c.ExpandEnv(); err == nil {
+		t.Errorf("expected error for unset variable without default\n")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dvid-validate")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := `
+[server]
+httpAddress = "localhost:0"
+rpcAddress = "localhost:1"
+
+[logging]
+logfile = "logs/dvid.log"
+
+[backend]
+    [backend.default]
+    store = "raid6"
+
+    [backend.grayscale]
+    store = "ssd"
+
+[store]
+    [store.raid6]
+    engine = "nosuchengine"
+    path = "dbs/raid6"
+`
+	filename := dir + "/config.toml"
+	if err := ioutil.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatalf("can't write config: %v\n", err)
+	}
+	errs := ValidateConfig(filename)
+	var found []string
+	for _, err := range errs {
+		for _, expected := range []string{"nosuchengine", `undefined store "ssd"`} {
+			if strings.Contains(err.Error(), expected) {
+				found = append(found, expected)
+			}
+		}
+		if strings.Contains(err.Error(), "path") || strings.Contains(err.Error(), "logfile") {
+			t.Errorf("unexpected error for creatable path: %v\n", err)
+		}
+	}
+	if len(found) != 2 {
+		t.Errorf("expected unknown engine and undefined store errors, got %v\n", errs)
+	}
+
+	if err := checkCreatable(filename + "/db"); err == nil {
+		t.Errorf("expected error for path under a file\n")
+	}
+}
@@ -0,0 +1,280 @@
+/*
+	This file implements the route registry and the OpenAPI specification of the HTTP API
+	served at /api/spec, e.g., for generating clients.  Server endpoints are described as
+	their handlers are registered, and datatypes describe the endpoints of their instances
+	by implementing datastore.RouteDescriber.  Datatypes that don't are covered by a
+	generic instance endpoint referring to their help.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	openAPIVersion = "3.0.3"
+
+	// instancePath is the path of a data instance, which prefixes datatype routes.
+	instancePath = "/api/node/:uuid/:dataname"
+)
+
+// registry holds the routes registered by the server, in order.
+var registry struct {
+	sync.Mutex
+	routes []datastore.Route
+}
+
+// handle registers a handler for a described route with a mux and adds the route to the
+// registry.
+func handle(mux *web.Mux, route datastore.Route, handler interface{}) {
+	switch route.Method {
+	case "GET":
+		mux.Get(route.Path, handler)
+	case "HEAD":
+		mux.Head(route.Path, handler)
+	case "POST":
+		mux.Post(route.Path, handler)
+	case "PUT":
+		mux.Put(route.Path, handler)
+	case "PATCH":
+		mux.Patch(route.Path, handler)
+	case "DELETE":
+		mux.Delete(route.Path, handler)
+	default:
+		dvid.Criticalf("Unable to register route %s with unsupported method %q\n", route.Path, route.Method)
+		return
+	}
+	registry.Lock()
+	registry.routes = append(registry.routes, route)
+	registry.Unlock()
+}
+
+// route registers a handler like handle for an endpoint that returns JSON and, if it's a
+// POST, expects a JSON body.
+func route(mux *web.Mux, method, pattern string, handler interface{}, summary string, query ...datastore.RouteParam) {
+	r := datastore.Route{Method: method, Path: pattern, Summary: summary, Query: query, Returns: "application/json"}
+	if method == "POST" {
+		r.Body = "application/json"
+	}
+	handle(mux, r, handler)
+}
+
+var (
+	// pageParams are the query-string parameters of paged listings.
+	pageParams = []datastore.RouteParam{
+		{Name: "limit", Description: "Maximum number of items to return."},
+		{Name: "cursor", Description: "Return items after the cursor, e.g., the X-Next-Cursor of a previous page."},
+		{Name: "offset", Description: "Number of items to skip."},
+	}
+
+	// listParams are the query-string parameters of paged listings with field selection.
+	listParams = append([]datastore.RouteParam{{Name: "fields", Description: "Comma-separated fields of each item to return."}}, pageParams...)
+
+	versionsParam = datastore.RouteParam{Name: "versions", Description: "If \"true\", includes the usage of each version."}
+)
+
+type openAPISpec struct {
+	OpenAPI string                           `json:"openapi"`
+	Info    openAPIInfo                      `json:"info"`
+	Paths   map[string]map[string]*openAPIOp `json:"paths"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type openAPIOp struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []openAPIParam             `json:"parameters,omitempty"`
+	RequestBody *openAPIBody               `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParam struct {
+	Name        string            `json:"name"`
+	In          string            `json:"in"`
+	Description string            `json:"description,omitempty"`
+	Required    bool              `json:"required,omitempty"`
+	Schema      map[string]string `json:"schema"`
+}
+
+type openAPIBody struct {
+	Content map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]openAPIMedia `json:"content,omitempty"`
+}
+
+type openAPIMedia struct {
+	Schema map[string]string `json:"schema"`
+}
+
+var (
+	routeParam  = regexp.MustCompile(`:(\w+)`)
+	nonWordChar = regexp.MustCompile(`\W+`)
+)
+
+// openAPIPath returns the OpenAPI path of a route pattern, e.g., "/api/node/{uuid}/note"
+// for "/api/node/:uuid/note", and the names of its path parameters.
+func openAPIPath(pattern string) (string, []string) {
+	var names []string
+	for _, match := range routeParam.FindAllStringSubmatch(pattern, -1) {
+		names = append(names, match[1])
+	}
+	path := routeParam.ReplaceAllString(pattern, "{$1}")
+	if strings.HasSuffix(path, "/*") {
+		path = strings.TrimSuffix(path, "*") + "{path}"
+		names = append(names, "path")
+	}
+	return path, names
+}
+
+func openAPIMediaOf(mediaType string) map[string]openAPIMedia {
+	schema := map[string]string{"type": "string", "format": "binary"}
+	if mediaType == "application/json" {
+		schema = map[string]string{}
+	} else if strings.HasPrefix(mediaType, "text/") {
+		schema = map[string]string{"type": "string"}
+	}
+	return map[string]openAPIMedia{mediaType: {Schema: schema}}
+}
+
+// addRoute adds a route to the spec under a tag.  If a route with the same method and path
+// was added, e.g., by another datatype, the tag and summary are added to its operation.
+func (spec *openAPISpec) addRoute(route datastore.Route, tag string) {
+	path, names := openAPIPath(route.Path)
+	method := strings.ToLower(route.Method)
+	ops, found := spec.Paths[path]
+	if !found {
+		ops = make(map[string]*openAPIOp)
+		spec.Paths[path] = ops
+	}
+	if op, found := ops[method]; found {
+		op.Tags = append(op.Tags, tag)
+		if route.Summary != "" {
+			op.Summary += fmt.Sprintf("  %s: %s", tag, route.Summary)
+		}
+		return
+	}
+	op := &openAPIOp{
+		OperationID: strings.Trim(nonWordChar.ReplaceAllString(method+path, "_"), "_"),
+		Summary:     route.Summary,
+		Tags:        []string{tag},
+		Responses: map[string]openAPIResponse{
+			"default": {Description: "Error message", Content: openAPIMediaOf("text/plain")},
+		},
+	}
+	for _, name := range names {
+		op.Parameters = append(op.Parameters, openAPIParam{Name: name, In: "path", Required: true, Schema: map[string]string{"type": "string"}})
+	}
+	for _, param := range route.Query {
+		op.Parameters = append(op.Parameters, openAPIParam{
+			Name:        param.Name,
+			In:          "query",
+			Description: param.Description,
+			Required:    param.Required,
+			Schema:      map[string]string{"type": "string"},
+		})
+	}
+	if route.Body != "" {
+		op.RequestBody = &openAPIBody{Content: openAPIMediaOf(route.Body)}
+	}
+	ok := openAPIResponse{Description: "Success"}
+	if route.Returns != "" {
+		ok.Content = openAPIMediaOf(route.Returns)
+	}
+	op.Responses["200"] = ok
+	ops[method] = op
+}
+
+// routeTag returns the tag of a server route, which is the first part of its path after
+// any "/api", e.g., "server" for "/api/server/info".
+func routeTag(pattern string) string {
+	parts := strings.Split(strings.TrimPrefix(pattern, "/api"), "/")
+	if len(parts) < 2 || parts[1] == "" {
+		return "api"
+	}
+	return parts[1]
+}
+
+// buildSpec returns the OpenAPI specification of the server's endpoints and those of
+// compiled datatypes or, if typename isn't empty, only of the given datatype.
+func buildSpec(typename string) (*openAPISpec, error) {
+	spec := &openAPISpec{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:       "DVID",
+			Description: "HTTP API of the DVID server.  See /api/help for details.",
+			Version:     gitVersion,
+		},
+		Paths: make(map[string]map[string]*openAPIOp),
+	}
+	if typename == "" {
+		registry.Lock()
+		for _, route := range registry.routes {
+			spec.addRoute(route, routeTag(route.Path))
+		}
+		registry.Unlock()
+	}
+
+	var names []string
+	types := datastore.CompiledTypes()
+	for name := range types {
+		if typename == "" || string(name) == typename {
+			names = append(names, string(name))
+		}
+	}
+	if typename != "" && len(names) == 0 {
+		return nil, fmt.Errorf("no datatype %q is compiled into this server", typename)
+	}
+	sort.Strings(names)
+	var undescribed []string
+	for _, name := range names {
+		describer, ok := types[dvid.TypeString(name)].(datastore.RouteDescriber)
+		if !ok {
+			undescribed = append(undescribed, name)
+			continue
+		}
+		for _, route := range describer.Routes() {
+			route.Path = instancePath + route.Path
+			spec.addRoute(route, name)
+		}
+	}
+	if len(undescribed) != 0 {
+		summary := fmt.Sprintf("Endpoints of %s instances, given by /api/help/{typename}.", strings.Join(undescribed, ", "))
+		for _, method := range []string{"GET", "POST", "DELETE"} {
+			spec.addRoute(datastore.Route{Method: method, Path: instancePath + "/:endpoint/*", Summary: summary}, "datatypes")
+		}
+	}
+	return spec, nil
+}
+
+func specHandler(w http.ResponseWriter, r *http.Request) {
+	spec, err := buildSpec(r.URL.Query().Get("type"))
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(spec); err != nil {
+		dvid.Errorf("Unable to write OpenAPI specification: %v\n", err)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+	This file implements throttles of particular datatypes or data instances, limiting
+	their concurrent requests and bandwidth so expensive operations, e.g., multiscale
+	regeneration, can't take over the server.  Throttled requests wait for slots in the
+	order given by their priority and client like other throttled operations.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+type instanceThrottleConfig struct {
+	Concurrency int      // maximum concurrent requests; no limit if 0.
+	BytesPerSec float64  `toml:"bytes_per_sec"` // bytes sent plus received; no limit if 0.
+	ByteBurst   float64  `toml:"byte_burst"`    // default: one second of bytes.
+	Endpoints   []string // endpoints throttled, e.g., "downres"; all if empty.
+}
+
+// instanceThrottle limits the requests of a datatype or data instance.
+type instanceThrottle struct {
+	spec      dvid.DataSpecifier
+	sched     *storage.Scheduler // nil if concurrency isn't limited.
+	endpoints map[string]struct{}
+
+	mu    sync.Mutex
+	bytes *bucket // nil if bandwidth isn't limited.
+}
+
+var instanceThrottles struct {
+	sync.RWMutex
+	types     map[dvid.TypeString]*instanceThrottle
+	instances map[dvid.DataSpecifier]*instanceThrottle
+}
+
+// parseInstanceThrottles returns the throttles for a configuration keyed by datatype
+// name or "<instance name>:<root UUID>" like backend mappings.
+func parseInstanceThrottles(configs map[dvid.DataSpecifier]instanceThrottleConfig) (map[dvid.TypeString]*instanceThrottle, map[dvid.DataSpecifier]*instanceThrottle, error) {
+	types := make(map[dvid.TypeString]*instanceThrottle)
+	instances := make(map[dvid.DataSpecifier]*instanceThrottle)
+	for spec, config := range configs {
+		if config.Concurrency < 0 || config.BytesPerSec < 0 || config.ByteBurst < 0 {
+			return nil, nil, fmt.Errorf("throttle for %s can't have negative limits", spec)
+		}
+		if config.Concurrency == 0 && config.BytesPerSec == 0 {
+			return nil, nil, fmt.Errorf("throttle for %s must set concurrency or bytes_per_sec", spec)
+		}
+		t := &instanceThrottle{spec: spec}
+		if config.Concurrency > 0 {
+			t.sched = storage.NewScheduler(config.Concurrency)
+		}
+		if config.BytesPerSec > 0 {
+			b := newBucket(config.BytesPerSec, config.ByteBurst, time.Now())
+			t.bytes = &b
+		}
+		if len(config.Endpoints) > 0 {
+			t.endpoints = make(map[string]struct{}, len(config.Endpoints))
+			for _, endpoint := range config.Endpoints {
+				t.endpoints[endpoint] = struct{}{}
+			}
+		}
+		name := strings.Trim(string(spec), "\"")
+		parts := strings.Split(name, ":")
+		switch len(parts) {
+		case 1:
+			types[dvid.TypeString(name)] = t
+		case 2:
+			instances[dvid.GetDataSpecifier(dvid.InstanceName(parts[0]), dvid.UUID(parts[1]))] = t
+		default:
+			return nil, nil, fmt.Errorf("bad throttle data specification: %s", spec)
+		}
+	}
+	return types, instances, nil
+}
+
+// setInstanceThrottles replaces the throttles of datatypes and data instances.
+func setInstanceThrottles(configs map[dvid.DataSpecifier]instanceThrottleConfig) error {
+	types, instances, err := parseInstanceThrottles(configs)
+	if err != nil {
+		return err
+	}
+	instanceThrottles.Lock()
+	instanceThrottles.types = types
+	instanceThrottles.instances = instances
+	instanceThrottles.Unlock()
+	for spec, config := range configs {
+		dvid.Infof("Throttling %s to %d concurrent requests and %g bytes/sec (0 is unlimited)\n", spec, config.Concurrency, config.BytesPerSec)
+	}
+	return nil
+}
+
+// instanceThrottleFor returns the throttle for a request to an endpoint of a data
+// instance, preferring one set for the instance over one for its datatype, or nil if
+// the request isn't throttled.
+func instanceThrottleFor(data datastore.DataService, endpoint string) *instanceThrottle {
+	instanceThrottles.RLock()
+	t, found := instanceThrottles.instances[dvid.GetDataSpecifier(data.DataName(), data.RootUUID())]
+	if !found {
+		t = instanceThrottles.types[data.TypeName()]
+	}
+	instanceThrottles.RUnlock()
+	if t == nil {
+		return nil
+	}
+	if t.endpoints != nil {
+		if _, found := t.endpoints[endpoint]; !found {
+			return nil
+		}
+	}
+	return t
+}
+
+// acquire waits until any bandwidth used by earlier requests is paid back and a slot is
+// free, returning an error if the context is done first.
+func (t *instanceThrottle) acquire(ctx context.Context, p storage.Priority, client string) error {
+	if t.bytes != nil {
+		for {
+			t.mu.Lock()
+			t.bytes.refill(time.Now())
+			wait := t.bytes.wait(0)
+			t.mu.Unlock()
+			if wait == 0 {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+	}
+	if t.sched != nil {
+		return t.sched.Acquire(ctx, p, client)
+	}
+	return nil
+}
+
+// release frees the slot of a request acquired earlier, charging the bytes it transferred.
+func (t *instanceThrottle) release(bytes uint64) {
+	if t.bytes != nil {
+		t.mu.Lock()
+		t.bytes.tokens -= float64(bytes)
+		t.mu.Unlock()
+	}
+	if t.sched != nil {
+		t.sched.Release()
+	}
+}
@@ -0,0 +1,50 @@
+/*
+	This file traces HTTP requests when span export is configured.  Each request gets a
+	server span, continuing any trace given in a W3C "traceparent" header, and the span's
+	context is passed to handlers through the request's context.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/janelia-flyem/dvid/dvid"
+
+	"github.com/zenazn/goji/web"
+	"github.com/zenazn/goji/web/mutil"
+)
+
+// tracingHandler is middleware that records a span for each request.
+func tracingHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !dvid.TracingEnabled() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		route := routeLabel(r.URL.Path)
+		ctx := dvid.ContextWithTraceParent(r.Context(), r.Header.Get("traceparent"))
+		ctx, span := dvid.StartSpan(ctx, r.Method+" "+route, dvid.SpanServer)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+		span.SetAttribute("net.peer.name", r.RemoteAddr)
+
+		ww := mutil.WrapWriter(w)
+		h.ServeHTTP(ww, r.WithContext(ctx))
+
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+		span.SetAttribute("http.status_code", code)
+		span.SetAttribute("http.response_content_length", ww.BytesWritten())
+		var err error
+		if code >= 500 {
+			err = fmt.Errorf("%d %s", code, http.StatusText(code))
+		}
+		span.End(err)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,243 @@
+/*
+	This file implements resumable uploads of large POST bodies, e.g., multi-GB volumes.
+	A client starts a session for an endpoint, PATCHes byte ranges in any order and in
+	parallel, resending any that fail, and finalizes the session to POST the assembled
+	body to the endpoint.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+
+	"github.com/zenazn/goji/web"
+	"github.com/zenazn/goji/web/mutil"
+)
+
+// MaxUploadRange is the maximum bytes that can be sent in one PATCH of an upload.
+var MaxUploadRange int64 = 256 * 1024 * 1024
+
+// finalizing holds the IDs of uploads being finalized so each body is POSTed once.
+var finalizing sync.Map
+
+func writeUploadSession(w http.ResponseWriter, status int, s datastore.UploadSession) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		dvid.Errorf("Unable to write upload session %s: %v\n", s.ID, err)
+	}
+}
+
+// uploadSession returns the session of a request's "id" parameter if the requester
+// started it or is an admin, writing an error response otherwise.
+func uploadSession(c web.C, w http.ResponseWriter, r *http.Request) (datastore.UploadSession, bool) {
+	s, err := datastore.GetUpload(c.URLParams["id"])
+	if err == datastore.ErrUploadNotFound {
+		http.Error(w, fmt.Sprintf("upload %q not found", c.URLParams["id"]), http.StatusNotFound)
+		return s, false
+	}
+	if err != nil {
+		BadRequest(w, r, err)
+		return s, false
+	}
+	if scopes, _ := c.Env["scopes"].([]string); s.User != "" && s.User != RequestUser(c) && !hasScope(scopes, datastore.ScopeAdmin) {
+		http.Error(w, fmt.Sprintf("upload %s was started by another user", s.ID), http.StatusForbidden)
+		return s, false
+	}
+	return s, true
+}
+
+// uploadsWritable writes an error response if uploads can't be modified.
+func uploadsWritable(w http.ResponseWriter, r *http.Request) bool {
+	if httpUnavailable(w) {
+		return false
+	}
+	if readonly {
+		BadRequest(w, r, "Server in read-only mode and will only accept GET and HEAD requests")
+		return false
+	}
+	return true
+}
+
+func postUploadHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	if !uploadsWritable(w, r) {
+		return
+	}
+	var req struct {
+		Path        string `json:"path"`
+		Size        int64  `json:"size"`
+		ContentType string `json:"content_type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		BadRequest(w, r, "malformed JSON request in body: %v", err)
+		return
+	}
+
+	// Check the endpoint is a data instance's mutation the requester can make.
+	u, err := url.Parse(req.Path)
+	if err != nil {
+		BadRequest(w, r, "bad upload path %q: %v", req.Path, err)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(u.Path, "/api/node/"), "/")
+	if !strings.HasPrefix(u.Path, "/api/node/") || len(parts) < 3 || u.IsAbs() {
+		BadRequest(w, r, "upload path %q must be a data instance endpoint, e.g., /api/node/<uuid>/<data name>/<keyword>", req.Path)
+		return
+	}
+	uuid, _, err := datastore.MatchingUUID(parts[0])
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	data, err := datastore.GetDataByUUIDName(uuid, dvid.InstanceName(parts[1]))
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	if !data.IsMutationRequest("POST", parts[2]) {
+		BadRequest(w, r, "upload path %q isn't a POST endpoint that modifies data", req.Path)
+		return
+	}
+	if !authorizeRepo(&c, w, r, uuid, datastore.RoleWrite) {
+		return
+	}
+
+	s, err := datastore.CreateUpload(req.Path, req.Size, req.ContentType, RequestUser(c))
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	requestLog(c).Infof("Started upload %s of %d bytes to %s\n", s.ID, s.Size, s.Path)
+	w.Header().Set("Location", "/api/uploads/"+s.ID)
+	writeUploadSession(w, http.StatusCreated, s)
+}
+
+func getUploadHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	if s, ok := uploadSession(c, w, r); ok {
+		writeUploadSession(w, http.StatusOK, s)
+	}
+}
+
+func patchUploadHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	if !uploadsWritable(w, r) {
+		return
+	}
+	s, ok := uploadSession(c, w, r)
+	if !ok {
+		return
+	}
+	var start, end int64
+	var total string
+	contentRange := r.Header.Get("Content-Range")
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &total); err != nil || end < start {
+		BadRequest(w, r, "PATCH of upload needs a Content-Range header like \"bytes 0-1048575/%d\", got %q", s.Size, contentRange)
+		return
+	}
+	if total != "*" && total != fmt.Sprintf("%d", s.Size) {
+		BadRequest(w, r, "Content-Range total %s doesn't match upload size %d", total, s.Size)
+		return
+	}
+	if end-start+1 > MaxUploadRange {
+		http.Error(w, fmt.Sprintf("PATCH of %d bytes exceeds maximum of %d bytes", end-start+1, MaxUploadRange), http.StatusRequestEntityTooLarge)
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, end-start+2))
+	if err != nil {
+		BadRequest(w, r, "error reading upload range: %v", err)
+		return
+	}
+	if int64(len(data)) != end-start+1 {
+		BadRequest(w, r, "Content-Range %q is %d bytes but body has %d bytes", contentRange, end-start+1, len(data))
+		return
+	}
+	if s, err = datastore.PutUploadRange(s.ID, start, data); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	writeUploadSession(w, http.StatusOK, s)
+}
+
+// finalizeUploadHandler POSTs the assembled body of a complete upload to its endpoint
+// through the full middleware, with the finalize request's headers, and returns the
+// endpoint's response.  The session is removed if the POST succeeds.
+func finalizeUploadHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	if !uploadsWritable(w, r) {
+		return
+	}
+	s, ok := uploadSession(c, w, r)
+	if !ok {
+		return
+	}
+	if !s.Complete() {
+		http.Error(w, fmt.Sprintf("upload %s is incomplete: received %d of %d bytes", s.ID, s.ReceivedBytes(), s.Size), http.StatusConflict)
+		return
+	}
+	if _, busy := finalizing.LoadOrStore(s.ID, struct{}{}); busy {
+		http.Error(w, fmt.Sprintf("upload %s is already being finalized", s.ID), http.StatusConflict)
+		return
+	}
+	defer finalizing.Delete(s.ID)
+
+	body, err := datastore.UploadReader(s.ID)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	req, err := http.NewRequest("POST", s.Path, body)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	req = req.WithContext(r.Context())
+	for key, values := range r.Header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	req.Header.Del("Content-Length")
+	if s.ContentType != "" {
+		req.Header.Set("Content-Type", s.ContentType)
+	}
+	req.ContentLength = s.Size
+	req.RemoteAddr = r.RemoteAddr
+
+	ww := mutil.WrapWriter(w)
+	webMux.ServeHTTP(ww, req)
+	if status := ww.Status(); status >= 300 {
+		requestLog(c).Warningf("POST of upload %s to %s failed with status %d\n", s.ID, s.Path, status)
+		return
+	}
+	if err := datastore.DeleteUpload(s.ID); err != nil {
+		requestLog(c).Errorf("Unable to remove finalized upload %s: %v\n", s.ID, err)
+		return
+	}
+	requestLog(c).Infof("Finalized upload %s of %d bytes to %s\n", s.ID, s.Size, s.Path)
+}
+
+func deleteUploadHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	if !uploadsWritable(w, r) {
+		return
+	}
+	s, ok := uploadSession(c, w, r)
+	if !ok {
+		return
+	}
+	if _, busy := finalizing.Load(s.ID); busy {
+		http.Error(w, fmt.Sprintf("upload %s is being finalized", s.ID), http.StatusConflict)
+		return
+	}
+	if err := datastore.DeleteUpload(s.ID); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"deleted": %q}`, s.ID)
+}
@@ -0,0 +1,265 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports validating a server configuration without starting a server, so
+	all problems in a TOML file can be fixed at once before deployment.
+*/
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/janelia-flyem/go/toml"
+)
+
+// ValidateConfig checks a TOML configuration file and returns all problems found, e.g.,
+// stores with unknown engines, backends referencing undefined stores, paths that can't
+// be created, and server addresses already in use.  No stores are opened.
+func ValidateConfig(filename string) []error {
+	if filename == "" {
+		return []error{fmt.Errorf("no server TOML configuration file provided")}
+	}
+	var c tomlConfig
+	if _, err := toml.DecodeFile(filename, &c); err != nil {
+		return []error{fmt.Errorf("could not decode TOML config: %v", err)}
+	}
+	var errs []error
+	if err := c.ExpandEnv(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.ConvertPathsToAbsolute(filename); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, c.validateStores()...)
+	errs = append(errs, c.validateBackends()...)
+
+	if c.Logging.Logfile != "" {
+		if err := checkCreatable(c.Logging.Logfile); err != nil {
+			errs = append(errs, fmt.Errorf("[logging] logfile: %v", err))
+		}
+	}
+	if c.Server.WebClient != "" {
+		if fi, err := os.Stat(c.Server.WebClient); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("[server] webClient %q is not a directory", c.Server.WebClient))
+		}
+	}
+	gen, prefix := c.Server.IIDGen, c.Instance.IDPrefix
+	if c.Instance.IDGen != "" {
+		gen = c.Instance.IDGen
+	}
+	if _, err := datastore.NewInstanceIDAllocator(gen, prefix); err != nil {
+		errs = append(errs, fmt.Errorf("instance ids: %v", err))
+	}
+	if _, err := c.namespaces(gen, prefix); err != nil {
+		errs = append(errs, fmt.Errorf("[namespace] %v", err))
+	}
+	if _, err := c.TLSConfig(); err != nil {
+		errs = append(errs, fmt.Errorf("[server] %v", err))
+	}
+	if _, err := newRequestLimits(c.Limits); err != nil {
+		errs = append(errs, fmt.Errorf("[limits] %v", err))
+	}
+	if _, _, err := parseInstanceThrottles(c.Throttle); err != nil {
+		errs = append(errs, fmt.Errorf("[throttle] %v", err))
+	}
+	errs = append(errs, c.validateAddresses()...)
+	return errs
+}
+
+// validateStores checks that each store has a registered engine and a creatable path.
+func (c *tomlConfig) validateStores() []error {
+	var errs []error
+	if len(c.Store) == 0 {
+		errs = append(errs, fmt.Errorf("no stores defined; at least one [store.<alias>] is required"))
+	}
+	for _, alias := range sortedAliases(c.Store) {
+		sc := c.Store[alias]
+		e, found := sc["engine"]
+		if !found {
+			errs = append(errs, fmt.Errorf("[store.%s] has no engine set", alias))
+		} else if engine, ok := e.(string); !ok {
+			errs = append(errs, fmt.Errorf("[store.%s] engine must be a string, not %v", alias, e))
+		} else if storage.GetEngine(engine) == nil {
+			errs = append(errs, fmt.Errorf("[store.%s] engine %q isn't compiled into this DVID; available engines: %s", alias, engine, storage.EnginesAvailable()))
+		}
+		p, found := sc["path"]
+		if !found {
+			continue
+		}
+		path, ok := p.(string)
+		if !ok {
+			errs = append(errs, fmt.Errorf("[store.%s] path must be a string, not %v", alias, p))
+		} else if !strings.Contains(path, "://") {
+			if err := checkCreatable(path); err != nil {
+				errs = append(errs, fmt.Errorf("[store.%s] path: %v", alias, err))
+			}
+		}
+	}
+	return errs
+}
+
+// validateBackends checks that backend mappings reference defined stores and that the
+// default and metadata stores can be determined.
+func (c *tomlConfig) validateBackends() []error {
+	var errs []error
+	checkAlias := func(spec dvid.DataSpecifier, setting string, alias storage.Alias) {
+		if _, found := c.Store[alias]; !found {
+			errs = append(errs, fmt.Errorf("[backend] %s.%s references undefined store %q; defined stores: %s", spec, setting, alias, strings.Join(aliasNames(c.Store), ", ")))
+		}
+	}
+	specs := make([]string, 0, len(c.Backend))
+	for spec := range c.Backend {
+		specs = append(specs, string(spec))
+	}
+	sort.Strings(specs)
+	hasDefault := false
+	for _, s := range specs {
+		v := c.Backend[dvid.DataSpecifier(s)]
+		spec := dvid.DataSpecifier(strings.Trim(s, "\""))
+		if spec == "default" && v.Store != "" {
+			hasDefault = true
+		}
+		if v.Store != "" {
+			checkAlias(spec, "store", v.Store)
+		}
+		if v.Log != "" {
+			checkAlias(spec, "log", v.Log)
+		}
+		if v.Hot == "" && v.Cold == "" {
+			if v.Store == "" {
+				errs = append(errs, fmt.Errorf("[backend] %s sets no store", spec))
+			}
+			continue
+		}
+		if spec == "default" || spec == "metadata" {
+			errs = append(errs, fmt.Errorf("[backend] %s cannot use tiered hot/cold stores", spec))
+		}
+		if v.Hot == "" || v.Cold == "" {
+			errs = append(errs, fmt.Errorf("[backend] %s must set both hot and cold stores", spec))
+		}
+		if v.Hot != "" {
+			checkAlias(spec, "hot", v.Hot)
+		}
+		if v.Cold != "" {
+			checkAlias(spec, "cold", v.Cold)
+		}
+		if v.DemoteDays < 0 {
+			errs = append(errs, fmt.Errorf("[backend] %s has negative demote_days", spec))
+		}
+	}
+	if !hasDefault && len(c.Store) > 1 {
+		errs = append(errs, fmt.Errorf("[backend] default store must be set since more than one store is defined"))
+	}
+	return errs
+}
+
+// validateAddresses checks that the HTTP, RPC, and gRPC addresses are distinct and free.
+func (c *tomlConfig) validateAddresses() []error {
+	var errs []error
+	httpAddress, rpcAddress, grpcAddress := c.Server.HTTPAddress, c.Server.RPCAddress, c.Server.GRPCAddress
+	if httpAddress == "" {
+		httpAddress = DefaultWebAddress
+	}
+	if rpcAddress == "" {
+		rpcAddress = DefaultRPCAddress
+	}
+	if grpcAddress == "" {
+		grpcAddress = DefaultGRPCAddress
+	}
+	addrs := []struct{ setting, address string }{{"httpAddress", httpAddress}, {"rpcAddress", rpcAddress}, {"grpcAddress", grpcAddress}}
+	for i, a := range addrs {
+		for _, b := range addrs[:i] {
+			if a.address == b.address {
+				errs = append(errs, fmt.Errorf("[server] %s and %s are both %s", b.setting, a.setting, a.address))
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr.address)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("[server] %s %s can't be used: %v", addr.setting, addr.address, err))
+			continue
+		}
+		ln.Close()
+	}
+	return errs
+}
+
+// checkCreatable returns an error if a file or directory can't be written at the path,
+// either because an existing one isn't writable or the nearest existing parent directory
+// isn't writable.
+func checkCreatable(path string) error {
+	fi, err := os.Stat(path)
+	if err == nil {
+		if !fi.IsDir() {
+			f, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				return fmt.Errorf("%q isn't writable: %v", path, err)
+			}
+			return f.Close()
+		}
+		return checkWritableDir(path)
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("can't access %q: %v", path, err)
+	}
+	dir := filepath.Dir(path)
+	for {
+		fi, err := os.Stat(dir)
+		if err == nil {
+			if !fi.IsDir() {
+				return fmt.Errorf("can't create %q since %q isn't a directory", path, dir)
+			}
+			if err := checkWritableDir(dir); err != nil {
+				return fmt.Errorf("can't create %q: %v", path, err)
+			}
+			return nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return fmt.Errorf("can't create %q: no existing parent directory", path)
+		}
+		dir = parent
+	}
+}
+
+// checkWritableDir returns an error if a file can't be created in the directory.
+func checkWritableDir(dir string) error {
+	f, err := ioutil.TempFile(dir, ".dvid-validate-")
+	if err != nil {
+		return fmt.Errorf("directory %q isn't writable: %v", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func sortedAliases(stores map[storage.Alias]storeConfig) []storage.Alias {
+	aliases := make([]storage.Alias, 0, len(stores))
+	for alias := range stores {
+		aliases = append(aliases, alias)
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i] < aliases[j] })
+	return aliases
+}
+
+func aliasNames(stores map[storage.Alias]storeConfig) []string {
+	var names []string
+	for _, alias := range sortedAliases(stores) {
+		names = append(names, string(alias))
+	}
+	return names
+}
@@ -10,7 +10,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
 	"os"
 	"path"
@@ -18,6 +18,7 @@ import (
 	"runtime"
 	"runtime/debug"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,6 +30,7 @@ import (
 	"github.com/janelia-flyem/dvid/storage"
 	"github.com/zenazn/goji/web"
 	"github.com/zenazn/goji/web/middleware"
+	"github.com/zenazn/goji/web/mutil"
 )
 
 const WebHelp = `
@@ -74,6 +76,14 @@ const WebHelp = `
 		The online documentation doesn't show the server host prefixed to the "/api/..." URL,
 		but it is required.
 
+		<p>Each request must finish within the timeout for its class, set in the [limits]
+		section of the configuration TOML: GET and HEAD requests within the read timeout,
+		other requests within the write timeout, and long-running requests, e.g., repo
+		exports and imports, within the long-running timeout.  Requests whose body isn't
+		received in time get status 408 (Request Timeout), and bodies over the configured
+		maximum size, except those of long-running requests, get status 413 (Request Entity
+		Too Large).  Larger bodies can be sent as resumable uploads (see /api/uploads).</p>
+
 		<h4>General commands</h4>
 
 		<pre>
@@ -85,10 +95,54 @@ const WebHelp = `
 
 	Returns help for the given datatype.
 
+ GET  /api/spec[?type=<typename>]
+
+	Returns the OpenAPI 3.0 specification of the HTTP API as JSON, e.g., for generating
+	clients, including the endpoints of data instances for datatypes that describe them.
+	Other datatypes' endpoints are only given by their help.  If a datatype is given,
+	only the endpoints of its instances are returned.
+
  GET  /api/load
 
 	Returns a JSON of server load statistics.
 
+ GET  /metrics
+
+	Returns server metrics in the Prometheus text exposition format, including HTTP request
+	counts and latency histograms per method and route, chunk handler saturation, running
+	background jobs, bytes served by each data instance, and, if enabled in the [metrics]
+	section of the configuration TOML, storage operation counts and latencies per data
+	instance ID.  Scrapes aren't logged.
+
+ GET  /livez
+ GET  /healthz
+ GET  /readyz
+
+	Health endpoints for orchestrators like Kubernetes and load balancers, which return
+	status 200 if healthy and 503 (Service Unavailable) otherwise.  /livez only checks that
+	the server responds.  /healthz also checks that the metadata is loaded and that every
+	store responds to a read within 2 seconds.  /readyz also checks that the server isn't
+	shutting down and that a chunk handler is free.  The JSON response gives each check:
+
+	{
+		"Status": "unavailable",
+		"Checks": {
+			"metadata": { "OK": true },
+			"stores": {
+				"OK": false,
+				"Error": "store not responding",
+				"Stores": {
+					"default": { "Store": "basholeveldb @ /data/db", "Seconds": 0.0001, "Checked": true },
+					"labels": { "Store": "bigtable ...", "Seconds": 2, "Checked": true, "Error": "no response within 2s" }
+				}
+			},
+			"handlers": { "OK": true, "InUse": 3, "Max": 16 },
+			"server": { "OK": true }
+		}
+	}
+
+	Probes aren't logged.
+
  GET  /api/storage
 
  	Returns a JSON object for each backend store where the key is the backend store name.
@@ -102,6 +156,93 @@ const WebHelp = `
 		"Bytes": ...
 	}
 
+ GET  /api/storage/usage[?versions=true]
+
+	Returns a JSON list of the approximate disk usage of each repo, sorted by descending
+	bytes, where the instances of each repo are also sorted by descending bytes:
+
+	[
+		{
+			"RootUUID": ...,
+			"Alias": "my repo",
+			"Bytes": ...,
+			"Instances": [
+				{
+					"Name": "grayscale",
+					"DataType": "uint8blk",
+					"DataUUID": ...,
+					"InstanceID": 3,
+					"Store": "raid6",
+					"Bytes": ...,
+					"Versions": { "<version uuid>": ..., ... },  // only if requested
+					"Error": "..."  // only if usage couldn't be determined
+				},
+				...
+			]
+		},
+		...
+	]
+
+	If "versions" is true, the bytes stored for each version of a data instance are
+	computed by scanning all its key-value pairs, which can take a long time.
+
+ GET  /api/storage/metrics
+
+	Returns a JSON list of storage operation metrics for each data instance, sorted by
+	descending time spent in storage operations so slow instances are listed first.
+	Metrics are only collected if "storage" is set in the [metrics] section of the
+	configuration, and operations on metadata are listed under instance ID 0:
+
+	[
+		{
+			"Name": "grayscale",
+			"DataType": "uint8blk",
+			"DataUUID": ...,
+			"InstanceID": 3,
+			"Seconds": 12.5,  // total time spent in all operations
+			"Ops": {
+				"get": {
+					"Count": 1200,
+					"Errors": 0,
+					"BytesIn": 0,
+					"BytesOut": 35000000,
+					"Seconds": 10.2,
+					"Latency": [0, 150, 1000, 50, 0, 0, 0]
+				},
+				...
+			}
+		},
+		...
+	]
+
+	Operations are "get", "put", "delete", "range", and "batch" (commits of batched puts
+	and deletes).  The latency histogram gives the number of operations taking at most
+	100 microseconds, 1 msec, 10 msec, 100 msec, 1 sec, 10 sec, and longer.  Bytes read
+	by raw range queries, e.g., during pushes and backups, are not counted.
+
+ POST /api/storage/compact?store=<alias>
+ POST /api/storage/compact?uuid=<uuid>&name=<data name>
+
+	Starts compaction of a store, reclaiming the space used by deleted and overwritten
+	key-value pairs, e.g., after deleting data instances or large ranges of data.  If a
+	data instance is given instead of a store, only its keys are compacted.  Compaction
+	runs in the background and only one compaction runs at a time; its completion is
+	logged.  Only stores using LSM engines like basholeveldb, rocksdb, and pebble
+	support compaction.  Scheduled compaction can be set in the [compaction] section of
+	the configuration.
+
+ POST /api/storage/gc
+
+	Starts a garbage collection job that scans the key-value pairs of all data instances and
+	deletes those written in versions that no longer exist in any repo DAG, e.g., left by an
+	interrupted version deletion.  Stores of data instances with deletions are compacted.
+	Returns JSON with the ID of the job, whose progress is available via /api/server/jobs:
+
+	{ "Job": 7 }
+
+	Garbage collection shouldn't be started while repos are pushed to or imported into
+	this server.
+
  GET  /api/server/info
 
 	Returns JSON for server properties.
@@ -125,12 +266,57 @@ const WebHelp = `
  	Returns JSON for groupcache statistics for this server.  See github.com/golang/groupcache package
 	Stats and CacheStats for MainCache and HotCache.
 
+ GET  /api/server/lrucache
+
+ 	Returns JSON for the read-through LRU cache statistics for this server, including
+	hit, miss, and eviction counts as well as the number of entries and bytes cached.
+
+ GET  /api/server/jobs
+ GET  /api/server/jobs/{id}
+
+	Returns JSON for running and recently finished background jobs, e.g., the deletion of a
+	data instance's key-value pairs, or just the job with the given ID.  Each job is a JSON
+	object of the following format, where "State" is "running", "done", or "failed":
+
+	{
+		"ID": 3,
+		"Kind": "delete data",
+		"Target": "data \"grayscale\" (instance 5)",
+		"State": "running",
+		"Step": "compacting",
+		"Started": "2017-04-10T15:00:00-04:00",
+		"Finished": "0001-01-01T00:00:00Z"
+	}
+
+ GET  /api/server/events
+
+	Streams datastore events as server-sent events (Content-Type: text/event-stream) for
+	clients like a browser's EventSource.  Each event gives its type and the JSON of the
+	event, the same JSON POSTed to webhooks set in the [events] configuration:
+
+	id: 1
+	event: NewVersion
+	data: {"Type":"NewVersion","Time":"2017-04-10T15:00:00-04:00","Repo":"a1b2...",...}
+
+	Comments are sent every 15 seconds to keep idle connections open.  Streams end before
+	the server's long-running request timeout, and clients should reconnect.  Events are dropped if the
+	client falls behind.  Only events of repos the client can read are sent.
+
+	Query-string Options:
+
+	types     Comma-separated event types to send, e.g., "NewVersion,Lock", or all types
+	            if not given: NewRepo, NewVersion, Lock, Unlock, DataModified,
+	            InstanceCreated, and InstanceDeleted.
+	repo      Only send events of the repo with the given UUID.
+	data      Only send events of data instances with the given name.
+
 POST  /api/server/settings
 
 	Sets server parameters.  Expects JSON to be posted with optional keys denoting parameters:
 	{
 		"gc": 500,
-		"throttle": 2
+		"throttle": 2,
+		"throttle_reserved": 1
 	}
 
 	 
@@ -140,9 +326,15 @@ POST  /api/server/settings
 	            See: https://golang.org/pkg/runtime/debug/#SetGCPercent
 
 	throttle  Maximum number of CPU-intensive requests that can be executed under throttle mode.
+	            Other throttled requests wait, with interactive requests given more turns
+	            than bulk requests and clients of equal priority taking turns.
 	            See imageblk and labelblk GET 3d voxels and POST voxels.
 	            Default = 1.
 
+	throttle_reserved  Number of throttle slots only used by interactive requests, so they
+	            needn't wait for bulk or background requests to finish.  At least one slot
+	            is left for other requests.  Default = 0.
+
 
 POST  /api/server/reload-metadata
 
@@ -152,6 +344,81 @@ POST  /api/server/reload-metadata
 	when prompted by an external coordinator, allowing the "slave" DVIDs to see changes made by
 	the master DVID.
 
+ GET  /api/server/tokens
+POST  /api/server/tokens
+ DEL  /api/server/tokens/{id}
+
+	Lists, creates, or revokes API tokens.  If "require_tokens" is set in the [auth] section
+	of the configuration TOML, requests other than GET and HEAD must give a token with the
+	needed scope in an "Authorization: Bearer <token>" header.  Tokens with the "write"
+	scope can modify repos and data, while tokens with the "admin" scope can also use the
+	/api/server and /api/storage endpoints, including these token endpoints.
+
+	A POST expects JSON of the token's name and scopes:
+
+	{ "name": "proofreading app", "scopes": ["write"] }
+
+	and returns JSON describing the token, including the "Token" itself, which can't be
+	retrieved again:
+
+	{
+		"ID": "8f3a1c0e2b4d6f70",
+		"Name": "proofreading app",
+		"Scopes": ["write"],
+		"Created": "2017-04-10T15:00:00-04:00",
+		"Token": "8f3a1c0e2b4d6f70.1b2c..."
+	}
+
+	The GET returns JSON of all tokens without their secrets.  These endpoints always
+	require an admin token, whether or not "require_tokens" is set.  Until an admin token
+	exists, tokens can be created by requests from localhost, e.g., the "tokens create"
+	command-line command, or requests giving the "bootstrap_secret" of the [auth] section
+	as the bearer token.
+
+	If an OIDC issuer is set in the [auth.oidc] section of the configuration TOML, JWTs
+	from the issuer can also be given as bearer tokens, with the user and scopes taken from
+	the configured claims.
+
+ GET  /api/server/audit
+
+	Returns a JSON list of audit log records, oldest first, if "enabled" is set in the
+	[audit] section of the configuration TOML.  Every request other than GET, HEAD, and
+	OPTIONS and every command that needs a token scope is recorded, including refused
+	requests.  Requires a token with the "admin" scope if tokens are required.
+
+	[
+		{
+			"ID": 1491850800000000000,
+			"Time": "2017-04-10T15:00:00-04:00",
+			"User": "token:8f3a1c0e2b4d6f70",
+			"Method": "POST",
+			"Route": "/api/node/3f8c/segmentation/split/23",
+			"Repo": "3f01a8856...",
+			"UUID": "3f8c...",
+			"Instance": "segmentation",
+			"Digest": "sha256:9f86d081...",
+			"Bytes": 1024,
+			"Status": 200
+		},
+		...
+	]
+
+	"Digest" is the SHA-256 of the request body or, for commands, of the command and its
+	input.  "Status" is the HTTP status of the response, or 200 or 400 for commands, whose
+	errors are given in "Error".  Commands have "RPC" as their method and route of the
+	command and subcommand, e.g., "repos new".
+
+	Query-string Options:
+
+	since     Only records at or after the time, in RFC 3339 format.
+	until     Only records before the time, in RFC 3339 format.
+	user      Only records of the user, e.g., "token:8f3a1c0e2b4d6f70".
+	repo      Only records of the repo with the UUID.
+	instance  Only records of the data instance with the name.
+	limit     Maximum number of records to return.  If the limit is reached, the response
+	            has X-Next-Cursor and Link headers for the next page.
+	cursor    Return records after the ID given by a previous page's X-Next-Cursor.
+
 -------------------------
 Memory Profiler endpoints
 -------------------------
@@ -174,6 +441,84 @@ Memory Profiler endpoints
  	Returns JSON of memory usage data.
 
 
+-------------------------
+Resumable upload endpoints
+-------------------------
+
+ POST /api/uploads
+
+	Starts a resumable upload of a large POST body, e.g., a multi-GB volume, to a data
+	instance endpoint.  Expects JSON giving the endpoint with any query string, the total
+	bytes of the body, and an optional content type:
+
+	{ "path": "/api/node/3f8c/grayscale/raw/0_1_2/4096_4096_4096/0_0_0", "size": 68719476736 }
+
+	The requester must be able to write to the endpoint's repo.  Returns status 201 with
+	the session JSON, whose "ID" is used below.  Sessions are kept in the metadata store
+	and are removed if they receive no bytes for 7 days.
+
+ GET  /api/uploads/{id}
+
+	Returns the session JSON, including the "Received" byte ranges, so an interrupted
+	client can resend only the missing ranges.
+
+ PATCH /api/uploads/{id}
+
+	Stores the bytes given in the body at the range of the Content-Range header, e.g.,
+	"Content-Range: bytes 0-268435455/68719476736".  Ranges can be sent in any order, in
+	parallel, and more than once, up to 256 MB each.  Returns the session JSON.
+
+ POST /api/uploads/{id}/finalize
+
+	POSTs the assembled body to the endpoint as if it were sent directly, using the
+	headers of this request, e.g., its Authorization, and returns the endpoint's response.
+	Returns status 409 (Conflict) if any bytes are missing.  The session is removed if
+	the POST succeeds.
+
+ DEL  /api/uploads/{id}
+
+	Abandons the upload and removes its received bytes.
+
+Only the user that started an upload, or an admin, can use its session.
+
+-------------------------
+Batch endpoint
+-------------------------
+
+ POST /api/batch[?atomic=true]
+
+	Runs a list of sub-requests in order with one round trip, e.g., hundreds of small
+	keyvalue writes.  Each sub-request is authorized, rate limited, and audited as if sent
+	directly, using the Authorization of the batch request if it has none, but all must
+	finish within the batch request's timeout.  At most 10000 sub-requests are allowed,
+	and /api/batch and /api/server/events can't be sub-requests.
+
+	The body is either a JSON list of sub-requests, where a binary body is given base64
+	encoded as "body64" instead of "body":
+
+	[
+		{ "method": "POST", "path": "/api/node/3f8c/annotations/key/synapse-1", "body": "..." },
+		{ "method": "DELETE", "path": "/api/node/3f8c/annotations/key/synapse-2" },
+		{ "method": "GET", "path": "/api/node/3f8c/annotations/keys", "headers": { "Accept": "application/json" } }
+	]
+
+	which returns a JSON list of responses, each with its "status", "headers", and "body"
+	or, if not UTF-8, base64 encoded "body64"; or it's a multipart/mixed body whose parts
+	each have Content-Type "application/http" and hold a raw HTTP request, which returns a
+	multipart/mixed body of raw HTTP responses with the Content-ID of each request part.
+
+	Query-string Options:
+
+	atomic    If "true", stops at the first sub-request without a 2xx status and
+	            returns status 400 with the responses so far.  Storage puts and deletes
+	            of data instances are queued and, only if all sub-requests succeed,
+	            committed with one storage batch for each data instance and version.
+	            Queued writes aren't visible to later sub-requests.  Status 501 is
+	            returned and nothing is written if a mutating sub-request isn't to a
+	            data instance or makes a write that can't be queued, e.g., a range
+	            delete or a write to a store that can't batch.  Audit records of
+	            sub-requests of a batch that isn't committed are marked rolled back.
+
 -------------------------
 Repo-Level REST endpoints
 -------------------------
@@ -184,9 +529,54 @@ Repo-Level REST endpoints
 	Configuration is a JSON object with optional "alias", "description", and "passcode"
     properties.  Returns the root UUID of the newly created repo in JSON object: {"root": uuid}
 
+ POST /api/repos/import
+
+	Imports a repo from an archive, the body of the POST, written by the export endpoint
+	below, e.g., on another DVID server.  None of the repo's nodes may already exist on this
+	server.  Data instances are assigned to stores by this server's configuration and tags
+	already used by other repos are dropped.  Returns the root UUID of the imported repo in
+	JSON object: {"root": uuid}.  Restart the server after importing repos with synced data.
+
  GET  /api/repos/info
 
-	Returns JSON for the repositories under management by this server.
+	Returns JSON for the repositories under management by this server, keyed by root UUID.
+
+	Query-string Options:
+
+	limit     Maximum number of repos to return.  If more remain, the response has an
+	            X-Next-Cursor header with the cursor of the next page and a Link header
+	            with the URL of the next page.
+	offset    Number of repos to skip.
+	cursor    Return repos after the root UUID given by a previous page's X-Next-Cursor.
+	fields    Comma-separated fields of each repo to return, e.g., "Alias,Description".
+	            Nested fields are given with dots, e.g., "DAG.Root".
+
+ GET  /api/namespaces
+
+	Returns a JSON list of the namespaces defined in the server's [namespace] configuration.
+	Namespaces group repos, e.g., of one lab, with a storage quota for all their repos, API
+	token scopes limited to their repos, e.g., "write:lab1", and their own instance ID
+	space.  Repos of a private namespace can only be read with a token having a scope for
+	the namespace, e.g., "read:lab1".  A repo's namespace is set when it's created and is
+	given by its "namespace" property.
+
+ GET  /api/ns/{namespace}/info
+
+	Returns JSON of the namespace's settings and the approximate bytes used by its repos,
+	where a zero quota means there is no limit:
+
+	{ "Name": "lab1", "Quota": 1000000000000, "Used": 123456789, "IDPrefix": 2, "Private": true,
+	  "Repos": 3 }
+
+ GET  /api/ns/{namespace}/repos/info
+
+	Returns JSON for the repos of the namespace, keyed by root UUID, with the same
+	query-string options as /api/repos/info.
+
+ POST /api/ns/{namespace}/repos
+
+	Creates a new repo in the namespace like POST /api/repos.  Returns 507 (Insufficient
+	Storage) if the namespace has used its quota.
 
  HEAD /api/repo/{uuid}
 
@@ -196,6 +586,28 @@ Repo-Level REST endpoints
 
 	Returns JSON for just the repository with given root UUID.  The UUID string can be
 	shortened as long as it is uniquely identifiable across the managed repositories.
+	Wherever a UUID is accepted, a tag naming a node can be used instead (see below).
+
+	The times each node and data instance were last read and written through the data
+	instance HTTP API are given by "LastRead" and "LastWrite" of each DAG node and by
+	"DataAccess", which is keyed by data instance name.  Times aren't given if there has
+	been no such access since they were first recorded.
+
+ GET  /api/repo/{uuid}/instances
+
+	Returns a JSON list of the data instances of the repo, ordered by name, giving the
+	same JSON as each instance's "info" endpoint.  For repos with many instances, this
+	can be paged and filtered instead of getting all instances from the repo's info.
+
+	Query-string Options:
+
+	type      Only return instances of the given type, e.g., "labelmap".
+	limit     Maximum number of instances to return.  If more remain, the response has
+	            X-Next-Cursor and Link headers for the next page.
+	offset    Number of instances to skip.
+	cursor    Return instances after the name given by a previous page's X-Next-Cursor.
+	fields    Comma-separated fields of each instance to return, with dots for nested
+	            fields, e.g., "Base.Name,Base.TypeName".
 
  POST /api/repo/{uuid}/instance
 
@@ -209,6 +621,26 @@ Repo-Level REST endpoints
 	REQUIRED "dataname"   Name of the new instance
 	OPTIONAL "versioned"  If "false" or "0", the data is unversioned and acts as if 
 	                      all UUIDs within a repo become the root repo UUID.  (True by default.)
+	OPTIONAL "store"      Alias of a store in the server's TOML configuration, e.g., "ssd", to
+	                      use for this instance instead of the [backend] assignment.  The store
+	                      is persisted with the instance and cannot be changed after creation.
+	OPTIONAL "Compression" Compression of stored values: "none", "snappy", "lz4", "gzip[:level]",
+	                      or "zstd[:level]", e.g., "zstd:3".  Defaults to the server's [server]
+	                      compression setting or the data type's default.
+	OPTIONAL "Checksum"   Checksum of stored values: "none" or "crc32".
+	OPTIONAL "Description" Free-form description of the instance.
+	OPTIONAL "Owner"      Person or group responsible for the instance.
+	OPTIONAL "Tags"       Comma-separated string or JSON array of tags for the instance.
+
+	The description, owner, tags, and creation and modification times of each instance
+	are returned in the repo JSON.
+
+ POST /api/repo/{uuid}/instance/{data name}
+
+	Modifies the configuration of an existing data instance, e.g., to set its "Description",
+	"Owner", or "Tags" described above.  Expects JSON with the settings to be changed as
+	the body of the POST.  Settings that can only be given on creation, like "store", are
+	rejected if changed.
 	
   GET /api/repo/{uuid}/log
  POST /api/repo/{uuid}/log
@@ -222,12 +654,159 @@ Repo-Level REST endpoints
 	descriptions for the entire repo and not just one node.  For particular versions, use
 	node-level logging (below).
 
+  GET /api/repo/{uuid}/tags
+
+	Returns JSON of the tags of the repo containing the node with given UUID, mapping each
+	tag to the UUID of the node it names:
+
+	{ "release-1.0": "3f01a8856", "proofread": "a7d2b9c0e" }
+
+  GET /api/repo/{uuid}/dag
+
+	Returns JSON of the version DAG of the repo containing the node with given UUID as
+	lists of nodes, ordered by creation time, and parent-to-child edges.  Edges from the
+	second or later parents of a merged node have "Merge" set to true:
+
+	{
+		"Root": "3f01a8856",
+		"Alias": "fib25",
+		"Nodes": [
+			{ "UUID": "3f01a8856", "Locked": true, "Tags": ["release-1.0"],
+			  "Created": "2017-04-10T15:00:00-04:00", "Updated": "2017-04-10T16:00:00-04:00" },
+			{ "UUID": "a7d2b9c0e", "Branch": "proofreading", "Note": "round 2", "Locked": false,
+			  "Created": "2017-04-10T16:00:00-04:00", "Updated": "2017-04-10T16:00:00-04:00" }
+		],
+		"Edges": [ { "Parent": "3f01a8856", "Child": "a7d2b9c0e", "Merge": false } ]
+	}
+
+  GET /api/repo/{uuid}/trash
+
+	Returns JSON of the data instances in the trash of the repo with given UUID, which can
+	be restored with the "dvid repo <UUID> restore <data name>" command until they expire:
+
+	[ { "Name": "segmentation", "Type": "labelmap", "DataUUID": "8b2e5cd2...",
+	    "Trashed": "2017-04-10T15:00:00-04:00", "Expires": "2017-04-17T15:00:00-04:00" } ]
+
+  GET /api/repo/{uuid}/usage[?versions=true]
+
+	Returns JSON of the approximate disk usage of the repo with given UUID.  See
+	/api/storage/usage for the format and query string options.
+
+  GET /api/repo/{uuid}/version-usage
+ POST /api/repo/{uuid}/version-usage
+
+	The POST starts a background job that scans all data instances of the repo with given
+	UUID to measure the bytes stored in each version, e.g., to find proofreading sessions
+	that bloated the datastore before pruning branches.  It returns JSON with the job ID,
+	{"Job": 12}, whose progress can be queried via /api/server/jobs/{id}.  The GET returns
+	the last measurement, where bytes are counted for key-value pairs written in a version
+	and not those inherited from ancestors:
+
+	{
+		"RootUUID": "a8f2...",
+		"Measured": "2017-04-10T15:00:00-04:00",
+		"Bytes": 123456789,
+		"Versions": [   // sorted by descending bytes
+			{
+				"UUID": "7be2...",
+				"Branch": "proofreading",
+				"Note": "session 12",
+				"Locked": true,
+				"Bytes": 100000000,
+				"Instances": { "segmentation": 99000000, "bookmarks": 1000000 }
+			},
+			...
+		],
+		"Errors": { "grayscale": "..." }  // only for instances that couldn't be scanned
+	}
+
+  GET /api/repo/{uuid}/export
+
+	Streams a self-describing archive of the repo with given UUID, including its metadata
+	and all versions of its data instances' key-value pairs, which can be kept offline or
+	imported by another DVID server.  Writes during the export may or may not be captured.
+
+  GET /api/repo/{uuid}/quota
+ POST /api/repo/{uuid}/quota
+
+	GETs or POSTs the storage quota for the repo with given UUID.  The GET returns JSON
+	of the following format, where "Used" is the approximate bytes used by the repo's
+	data instances plus bytes written since usage was last measured:
+
+	{ "Quota": 1000000000000, "Used": 123456789 }
+
+	The POST body should be JSON of the format { "Quota": 1000000000000 } where a zero
+	quota removes the limit.  Once a repo has used its quota, requests that would mutate
+	its data instances return 507 (Insufficient Storage).
+
+  GET /api/repo/{uuid}/properties
+ POST /api/repo/{uuid}/properties
+
+	GETs or POSTs the properties of the repo with given UUID, which are persisted with the
+	repo and also shown in its info.  The GET returns a JSON object of all properties.  The
+	POST body should be a JSON object of properties to set, where a null value removes a
+	property:
+
+	{ "read-only": true, "compression": "zstd:3", "project": "hemibrain" }
+
+	Known settings are checked:
+
+	auto-branch     Boolean enabling auto-branching (see /api/repo/{uuid}/autobranch).
+	storage-quota   Storage quota in bytes (see /api/repo/{uuid}/quota).
+	compression     Default compression of new data instances in the repo, e.g., "lz4"
+	                or "zstd:3", unless given by their "Compression" setting.
+	read-only       Boolean refusing writes to all data instances in the repo.
+	namespace       Namespace of the repo (see /api/namespaces), which can't be changed.
+
+	Other properties can be strings, numbers, or booleans.
+
+  GET /api/repo/{uuid}/autobranch
+ POST /api/repo/{uuid}/autobranch
+
+	GETs or POSTs the auto-branch setting for the repo with given UUID as JSON of the
+	format { "AutoBranch": true }.  Auto-branching is disabled by default.  When enabled,
+	a request that would mutate a data instance in a locked node creates a new child of
+	the node and is applied to the child instead of failing.  The child's UUID is returned
+	in the "X-DVID-Auto-Branch" response header and should be used for subsequent writes.
+	The child continues the locked node's branch unless the node already has a child on
+	that branch.
+
+  GET /api/repo/{uuid}/roles
+ POST /api/repo/{uuid}/roles
+
+	GETs or POSTs the role assignments of the repo with given UUID as JSON mapping each
+	principal to a role of "read", "write", or "admin":
+
+	{ "jane@example.org": "admin", "token:8f3a1c0e2b4d6f70": "write", "*": "read" }
+
+	Principals are users authenticated by JWTs, API tokens given as "token:<token ID>",
+	or "*" for all users including unauthenticated ones.  A POST changes only the given
+	principals, and the role "none" removes an assignment.  Repos without assignments are
+	open to all, while a repo with assignments requires the "read" role for any request,
+	"write" to modify data or versions, and "admin" to change the repo's properties,
+	quota, auto-branching, or roles.  Tokens with the "admin" scope have all roles.
+
+ GET /api/repo/{uuid}/diff?data=<name>&from=<uuid>&to=<uuid>[&keys=true]
+
+	Returns JSON summarizing how the data instance with given name changed going from the
+	"from" version to the "to" version, e.g., to review what a branch changed before merging
+	it.  Values are compared for all keys with different writes in the two versions:
+
+	{ "Added": 10, "Modified": 2, "Deleted": 1, "BytesAdded": 12000, "BytesDeleted": 3000 }
+
+	"BytesAdded" and "BytesDeleted" count the bytes of added and deleted values, with modified
+	values counted in both.  If "keys=true", a "Changes" list is included that gives each
+	changed key, described in a type-specific way if possible or else hex-encoded, and
+	whether it was "added", "modified", or "deleted".
+
  POST /api/repo/{uuid}/merge
 
-	Creates a conflict-free merge of a set of committed parent UUIDs into a child.  Note
-	the merge will not necessarily create an error immediately, but later GETs that
+	Creates a merge of a set of committed parent UUIDs into a child.  A "conflict-free"
+	merge will not necessarily create an error immediately, but later GETs that
 	detect conflicts will produce an error at that time.  These can be resolved by
-	doing a POST on the "resolve" endpoint below.
+	doing a POST on the "resolve" endpoint below.  Other merge types scan the data of
+	all versioned instances for conflicts before the child is created and write the
+	resolved values in the child.
 
 	The post body should be JSON of the following format: 
 
@@ -239,9 +818,16 @@ Repo-Level REST endpoints
 
 	The elements of the JSON object are:
 
-		mergeType:  must be "conflict-free".  We will introduce other merge options in future.
+		mergeType:  one of the following:
+		              "conflict-free": assume parents have no conflicting changes.
+		              "ours": conflicts resolve to the earliest parent in the list.
+		              "theirs": conflicts resolve to the latest parent in the list.
+		              "conflict-error": fail with no child created if any data conflicts.
 		parents:    a list of the parent UUIDs to be merged. 
 		note:       any note that should be set for the child version.
+		typeMergeTypes:  optional object mapping datatype names to "ours", "theirs", or
+		              "conflict-error" to override the mergeType for instances of that type,
+		              e.g., { "labelmap": "conflict-error" }.
 
 	A JSON response will be sent with the following format:
 
@@ -313,9 +899,19 @@ Node-Level REST endpoints
 
   GET /api/node/{uuid}/commit
 
-    Returns the commit or lock state of the node with given UUID in JSON format:
+    Returns the commit or lock state of the node with given UUID in JSON format.  For
+    committed nodes, the commit message, user, application, and time are included if
+    they were recorded:
 
-	{ "Locked": true }
+	{
+		"Locked": true,
+		"Commit": {
+			"Message": "this is a description of what I did on this commit",
+			"User": "jdoe",
+			"App": "neutu",
+			"Time": "2017-05-01T12:30:00.123456-04:00"
+		}
+	}
 
  POST /api/node/{uuid}/commit
 
@@ -325,16 +921,64 @@ Node-Level REST endpoints
 
 	{ 
 		"note": "this is a description of what I did on this commit",
-		"log": [ "provenance data...", "provenance data...", ...] 
+		"log": [ "provenance data...", "provenance data...", ...],
+		"user": "jdoe",
+		"app": "neutu"
 	}
 
 	The note is a human-readable commit message.  The log is a slice of strings that may
-	be computer-readable.
+	be computer-readable.  The optional user and app identify who committed the node and
+	with what application, and can also be given by "u" and "app" query strings.  The
+	log and a record of the commit are appended to the node log (see GET /api/node/{uuid}/log).
+
+ POST /api/node/{uuid}/unlock
+
+	Reopens a committed (locked) node so fixes can be written in it, e.g., after a premature
+	commit.  Since writes to a node change the data seen by its descendants, only nodes
+	without children can be unlocked.  The post body should be JSON of the following format:
+
+	{
+		"reason": "committed before the last proofreading session was saved",
+		"user": "jdoe",
+		"passcode": "secret"
+	}
+
+	The reason is required, and the optional user can also be given by a "u" query string.
+	The passcode is required if the repo has one.  The unlock is recorded in the node log,
+	and the node's commit information is cleared until it is committed again.
 
 	If successful, a valid JSON response will be sent with the following format:
 
 	{ "committed": "3f01a8856" }
 
+ POST /api/node/{uuid}/tag
+ DEL  /api/node/{uuid}/tag?tag=<tag>
+
+	POSTs or DELETEs a tag, a name like "release-1.0" that can be used in place of the
+	node's UUID in any request.  The post body should be JSON of the format:
+
+	{ "tag": "release-1.0" }
+
+	Tags must be unique across repos and can't contain slashes or whitespace.  To avoid
+	confusion with UUIDs, tags must include a character other than a hexadecimal digit.
+	POSTing a tag already in the repo moves it to the given node.  A DELETE removes the
+	tag from the repo containing the given node.  Tag changes are recorded in the repo log.
+
+ POST /api/node/{uuid}/revert
+
+	Starts a job that deletes all changes to a versioned data instance made in the given
+	uncommitted node, so the data is the same as in the node's parents.  The post body
+	should be JSON of the format:
+
+	{ "data": "grayscale" }
+
+	The revert is recorded in the node log.  If successful, the job ID is returned:
+
+	{"Job": 15}
+
+	The job's progress can be queried via /api/server/jobs/{id}.  The data shouldn't be
+	written in the node, and the node shouldn't be committed, until the job is done.
+
  POST /api/node/{uuid}/branch
 
 	Creates a new branch child node (version) of the node with given UUID.
@@ -385,6 +1029,34 @@ Node-Level REST endpoints
 		   non-interactive (i.e., you don't mind if it's delayed) by appending a query string
 		   <code>interactive=false</code>.
 
+		<p>Throttled requests and storage operations are scheduled by priority, with clients of
+		   equal priority taking turns.  Reads are interactive by default while writes are bulk,
+		   and requests marked <code>interactive=false</code> are bulk.  The priority can be
+		   set explicitly by appending <code>priority=interactive</code>,
+		   <code>priority=normal</code>, <code>priority=bulk</code>, or
+		   <code>priority=background</code>.  Ingestion and export jobs should use bulk
+		   priority so they don't delay interactive requests like tile reads, and interactive
+		   writes like proofreading edits should set <code>priority=interactive</code>.
+
+		<p>GETs of data type and repo endpoints honor a <code>Range</code> header of a single
+		   byte range, e.g., <code>Range: bytes=1048576-</code>, returning status 206 with just
+		   those bytes so clients can resume interrupted downloads or fetch parts of large
+		   volumes and exports in parallel.  Responses over 512 MB without a known length are
+		   sent in full.
+
+		<p>JSON, text, and uncompressed binary responses are compressed with zstd or gzip if
+		   the request's <code>Accept-Encoding</code> header accepts them, preferring zstd.
+		   Endpoints that send already-compressed data, e.g., label blocks in lz4 or gzip
+		   format, or that are asked for byte ranges, aren't compressed again.
+
+		<p>GETs of data type endpoints return an <code>ETag</code> derived from the node and
+		   the mutations of the data instance, and a request whose <code>If-None-Match</code>
+		   header gives the current ETag gets status 304 (Not Modified) without reading any
+		   data.  ETags of locked nodes only change when the server restarts or the data is
+		   reverted or rewritten, so viewers polling tiles of committed nodes can revalidate
+		   cheaply.
+		   Data synced with other instances isn't given ETags on unlocked nodes.
+
 		<h3>Licensing</h3>
 		<p><a href="https://github.com/janelia-flyem/dvid">DVID</a> is released under the
 			<a href="http://janelia-flyem.github.com/janelia_farm_license.html">Janelia Farm license</a>, a
@@ -411,11 +1083,19 @@ const (
 	// The relative URL path to our Level 2 REST API
 	WebAPIPath = "/api/" + WebAPIVersion
 
-	// WriteTimeout is the maximum time in seconds DVID will wait to write data down HTTP connection.
+	// WriteTimeout is the default maximum time of HTTP requests other than GET and HEAD.
 	WriteTimeout = 300 * time.Second
 
-	// ReadTimeout is the maximum time in seconds DVID will wait to read data from HTTP connection.
+	// ReadTimeout is the default maximum time of HTTP GET and HEAD requests.
 	ReadTimeout = 300 * time.Second
+
+	// LongTimeout is the default maximum time of long-running HTTP requests, e.g., repo
+	// exports and event streams.
+	LongTimeout = time.Hour
+
+	// AutoBranchHeader is the response header giving the UUID of a child auto-branched
+	// from a locked node for a write.
+	AutoBranchHeader = "X-DVID-Auto-Branch"
 )
 
 type WebMux struct {
@@ -434,27 +1114,44 @@ func init() {
 	webMux.Use(middleware.RequestID)
 }
 
-// ThrottledHTTP checks if a request can continue under throttling.  If so, it returns
-// false.  If it cannot (throttled state), it sends a http.StatusServiceUnavailable and
-// returns true.  Throttling is controlled by
-func ThrottledHTTP(w http.ResponseWriter) bool {
-	curThrottleMu.Lock()
-	if curThrottledOps < maxThrottledOps {
-		curThrottledOps++
-		curThrottleMu.Unlock()
-		return false
-	}
-	curThrottleMu.Unlock()
-	msg := fmt.Sprintf("Server already running %d throttled operations (max = %d)\n", curThrottledOps, maxThrottledOps)
-	http.Error(w, msg, http.StatusServiceUnavailable)
-	return true
+// ThrottledHTTP waits until a request can continue under throttling, scheduled by the
+// priority and client of the request, and returns false.  If the request is cancelled
+// while waiting, it sends a http.StatusServiceUnavailable and returns true.  The number
+// of concurrent throttled requests is set by SetMaxThrottleOps.
+func ThrottledHTTP(w http.ResponseWriter, r *http.Request) bool {
+	p, client := storage.RequestPriority(r.Context())
+	if err := throttle.Acquire(r.Context(), p, client); err != nil {
+		msg := fmt.Sprintf("Request cancelled while waiting for %d throttled operations: %v\n", throttle.Slots(), err)
+		http.Error(w, msg, http.StatusServiceUnavailable)
+		return true
+	}
+	return false
 }
 
 // ThrottleOpDone marks the end of a throttled operation, allowing another op blocked by ThrottledHTTP() to succeed.
 func ThrottledOpDone() {
-	curThrottleMu.Lock()
-	curThrottledOps--
-	curThrottleMu.Unlock()
+	throttle.Release()
+}
+
+// requestPriority returns the scheduling priority of a request given by its "priority"
+// or "interactive" query strings, and the client, which is the remote host.  Otherwise
+// mutations are bulk and other requests interactive.
+func requestPriority(r *http.Request, mutation bool) (storage.Priority, string, error) {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	queryStrings := r.URL.Query()
+	if s := queryStrings.Get("priority"); s != "" {
+		p, err := storage.ParsePriority(s)
+		return p, client, err
+	}
+	if interactive := queryStrings.Get("interactive"); interactive == "false" || interactive == "0" {
+		return storage.PriorityBulk, client, nil
+	} else if interactive != "" || !mutation {
+		return storage.PriorityInteractive, client, nil
+	}
+	return storage.PriorityBulk, client, nil
 }
 
 // ServeSingleHTTP fulfills one request using the default web Mux.
@@ -481,6 +1178,14 @@ func serveHTTP() {
 	} else if fullwrite {
 		mode = " (full write mode)"
 	}
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		dvid.LogImmediately(fmt.Sprintf("Unable to configure HTTPS: %v\n", err))
+		os.Exit(1)
+	}
+	if tlsConfig != nil {
+		mode += " using HTTPS"
+	}
 	dvid.Infof("Web server listening at %s%s ...\n", config.HTTPAddress(), mode)
 	if !webMux.routesSetup {
 		initRoutes()
@@ -494,13 +1199,26 @@ func serveHTTP() {
 	// unless package is modified.  Not sure graceful features needed whereas tailoring
 	// of server is more important.
 
+	// Connections are bounded by the longest request timeout, and limitHandler sets the
+	// deadlines of each request by its class.
 	s := &http.Server{
-		Addr:         config.HTTPAddress(),
-		WriteTimeout: WriteTimeout,
-		ReadTimeout:  ReadTimeout,
+		Addr:              config.HTTPAddress(),
+		WriteTimeout:      limits.maxTimeout(),
+		ReadTimeout:       limits.maxTimeout(),
+		ReadHeaderTimeout: limits.timeouts[readRequest],
+		IdleTimeout:       limits.timeouts[readRequest],
+		ConnContext:       withConn,
+		TLSConfig:         tlsConfig,
 	}
 	httpAvail = true
-	log.Fatal(s.ListenAndServe())
+	if tlsConfig != nil {
+		// The certificate is already loaded in the TLS config.
+		err = s.ListenAndServeTLS("", "")
+	} else {
+		err = s.ListenAndServe()
+	}
+	dvid.LogImmediately(fmt.Sprintf("Web server stopped: %v\n", err))
+	os.Exit(1)
 
 	// graceful.HandleSignals()
 	// if err := graceful.ListenAndServe(address, http.DefaultServeMux); err != nil {
@@ -521,78 +1239,167 @@ func initRoutes() {
 	silentMux := web.New()
 	webMux.Handle("/api/load", silentMux)
 	silentMux.Use(corsHandler)
-	silentMux.Get("/api/load", loadHandler)
+	route(silentMux, "GET", "/api/load", loadHandler, "Returns JSON of server load statistics.")
+
+	// Prometheus scrapes and health probes aren't logged.
+	handle(webMux.Mux, datastore.Route{Method: "GET", Path: "/metrics", Summary: "Returns server metrics in the Prometheus text format.", Returns: "text/plain"}, metricsExportHandler)
+	route(webMux.Mux, "GET", "/livez", livenessHandler, "Returns status 200 if the server process is live.")
+	route(webMux.Mux, "GET", "/healthz", healthHandler, "Returns status 200 if the server and its stores are healthy.")
+	route(webMux.Mux, "GET", "/readyz", readinessHandler, "Returns status 200 if the server is ready for requests.")
 
 	mainMux := web.New()
 	webMux.Handle("/*", mainMux)
-	mainMux.Use(middleware.Logger)
+	mainMux.Use(logHandler)
+	mainMux.Use(metricsHandler)
+	mainMux.Use(tracingHandler)
 	mainMux.Use(middleware.AutomaticOptions)
 	mainMux.Use(recoverHandler)
 	mainMux.Use(corsHandler)
+	mainMux.Use(auditHandler)
+	mainMux.Use(limitHandler)
+	mainMux.Use(authHandler)
+	mainMux.Use(rateLimitHandler)
+	mainMux.Use(compressHandler)
 
 	// Handle RAML interface
-	mainMux.Get("/interface", interfaceHandler)
-	mainMux.Get("/interface/version", versionHandler)
+	handle(mainMux, datastore.Route{Method: "GET", Path: "/interface", Summary: "Returns the RAML interface of the HTTP API.", Returns: "application/raml+yaml"}, interfaceHandler)
+	route(mainMux, "GET", "/interface/version", versionHandler, "Returns the version of the RAML interface.")
 
-	mainMux.Get("/api/help", helpHandler)
+	handle(mainMux, datastore.Route{Method: "GET", Path: "/api/help", Summary: "Returns the help for the HTTP API.", Returns: "text/html"}, helpHandler)
 	mainMux.Get("/api/help/", helpHandler)
-	mainMux.Get("/api/help/:typename", typehelpHandler)
-
-	mainMux.Get("/api/storage", serverStorageHandler)
-
-	mainMux.Get("/api/server/info", serverInfoHandler)
+	handle(mainMux, datastore.Route{Method: "GET", Path: "/api/help/:typename", Summary: "Returns the help for a datatype.", Returns: "text/plain"}, typehelpHandler)
+	route(mainMux, "GET", "/api/spec", specHandler, "Returns the OpenAPI specification of the HTTP API.",
+		datastore.RouteParam{Name: "type", Description: "Only the endpoints of the datatype's instances."})
+
+	route(mainMux, "GET", "/api/storage", serverStorageHandler, "Returns JSON of the data instances in each store.")
+	route(mainMux, "GET", "/api/storage/usage", serverUsageHandler, "Returns JSON of the approximate disk usage of each repo.", versionsParam)
+	route(mainMux, "GET", "/api/storage/metrics", serverStorageMetricsHandler, "Returns JSON of storage operation metrics for each data instance.")
+	route(mainMux, "POST", "/api/storage/compact", serverCompactHandler, "Starts compaction of a store or data instance.",
+		datastore.RouteParam{Name: "store", Description: "Alias of the store to compact."},
+		datastore.RouteParam{Name: "uuid", Description: "UUID of the data instance to compact."},
+		datastore.RouteParam{Name: "name", Description: "Name of the data instance to compact."})
+	route(mainMux, "POST", "/api/storage/gc", serverGCHandler, "Starts garbage collection of key-value pairs of deleted versions.")
+
+	route(mainMux, "GET", "/api/server/info", serverInfoHandler, "Returns JSON of server properties.")
 	mainMux.Get("/api/server/info/", serverInfoHandler)
-	mainMux.Get("/api/server/note", serverNoteHandler)
+	route(mainMux, "GET", "/api/server/note", serverNoteHandler, "Returns the server note from the configuration.")
 	mainMux.Get("/api/server/note/", serverNoteHandler)
-	mainMux.Get("/api/server/types", serverTypesHandler)
+	route(mainMux, "GET", "/api/server/types", serverTypesHandler, "Returns JSON of the datatypes of stored data instances.")
 	mainMux.Get("/api/server/types/", serverTypesHandler)
-	mainMux.Get("/api/server/compiled-types", serverCompiledTypesHandler)
+	route(mainMux, "GET", "/api/server/compiled-types", serverCompiledTypesHandler, "Returns JSON of the datatypes compiled into the server.")
 	mainMux.Get("/api/server/compiled-types/", serverCompiledTypesHandler)
-	mainMux.Get("/api/server/groupcache", serverGroupcacheHandler)
+	route(mainMux, "GET", "/api/server/groupcache", serverGroupcacheHandler, "Returns JSON of groupcache statistics.")
 	mainMux.Get("/api/server/groupcache/", serverGroupcacheHandler)
-	mainMux.Post("/api/server/settings", serverSettingsHandler)
-	mainMux.Post("/api/server/reload-metadata", serverReload)
+	route(mainMux, "GET", "/api/server/lrucache", serverLRUCacheHandler, "Returns JSON of LRU cache statistics.")
+	mainMux.Get("/api/server/lrucache/", serverLRUCacheHandler)
+	route(mainMux, "GET", "/api/server/jobs", serverJobsHandler, "Returns JSON of running and recently finished background jobs.")
+	route(mainMux, "GET", "/api/server/jobs/:id", serverJobHandler, "Returns JSON of a background job.")
+	handle(mainMux, datastore.Route{
+		Method:  "GET",
+		Path:    "/api/server/events",
+		Summary: "Streams datastore events as server-sent events.",
+		Query:   []datastore.RouteParam{{Name: "types", Description: "Comma-separated event types to send."}},
+		Returns: "text/event-stream",
+	}, serverEventsHandler)
+	route(mainMux, "POST", "/api/server/settings", serverSettingsHandler, "Sets server parameters.")
+	route(mainMux, "POST", "/api/server/reload-metadata", serverReload, "Reloads the metadata from storage.")
 	mainMux.Post("/api/server/reload-metadata/", serverReload)
+	route(mainMux, "GET", "/api/server/tokens", getTokensHandler, "Returns JSON of the API tokens.")
+	route(mainMux, "POST", "/api/server/tokens", postTokensHandler, "Creates an API token.")
+	route(mainMux, "DELETE", "/api/server/tokens/:id", deleteTokenHandler, "Revokes an API token.")
+	route(mainMux, "GET", "/api/server/audit", serverAuditHandler, "Returns JSON of audit log records.", append([]datastore.RouteParam{
+		{Name: "since", Description: "Only records at or after the time, in RFC 3339 format."},
+		{Name: "until", Description: "Only records before the time, in RFC 3339 format."},
+		{Name: "user", Description: "Only records of the user."},
+		{Name: "repo", Description: "Only records of the repo with the given root UUID."},
+		{Name: "instance", Description: "Only records of the data instance."},
+	}, pageParams[:2]...)...) // limit and cursor but not offset.
+
+	route(mainMux, "POST", "/api/uploads", postUploadHandler, "Starts a resumable upload of a POST body to an endpoint.")
+	route(mainMux, "GET", "/api/uploads/:id", getUploadHandler, "Returns JSON of an upload session.")
+	handle(mainMux, datastore.Route{Method: "PATCH", Path: "/api/uploads/:id", Summary: "Stores the body at the Content-Range of an upload.", Body: "application/octet-stream", Returns: "application/json"}, patchUploadHandler)
+	handle(mainMux, datastore.Route{Method: "POST", Path: "/api/uploads/:id/finalize", Summary: "POSTs the assembled body of an upload to its endpoint."}, finalizeUploadHandler)
+	route(mainMux, "DELETE", "/api/uploads/:id", deleteUploadHandler, "Abandons an upload.")
+
+	route(mainMux, "POST", "/api/batch", batchHandler, "Runs a list of sub-requests.",
+		datastore.RouteParam{Name: "atomic", Description: "If \"true\", writes are made only if all sub-requests succeed."})
+
+	if !readonly {
+		route(mainMux, "POST", "/api/repos", reposPostHandler, "Creates a repo.")
+		handle(mainMux, datastore.Route{Method: "POST", Path: "/api/repos/import", Summary: "Imports a repo from an exported archive.", Body: "application/octet-stream", Returns: "application/json"}, reposImportHandler)
+	}
+	route(mainMux, "GET", "/api/repos/info", reposInfoHandler, "Returns JSON of the repos keyed by root UUID.", listParams...)
 
+	route(mainMux, "GET", "/api/namespaces", namespacesHandler, "Returns a JSON list of the namespaces.")
+	route(mainMux, "GET", "/api/ns/:ns/info", namespaceInfoHandler, "Returns JSON of a namespace's settings and usage.")
+	route(mainMux, "GET", "/api/ns/:ns/repos/info", namespaceReposInfoHandler, "Returns JSON of the repos of a namespace keyed by root UUID.", listParams...)
 	if !readonly {
-		mainMux.Post("/api/repos", reposPostHandler)
+		route(mainMux, "POST", "/api/ns/:ns/repos", namespaceReposPostHandler, "Creates a repo in a namespace.")
 	}
-	mainMux.Get("/api/repos/info", reposInfoHandler)
 
 	repoRawMux := web.New()
 	mainMux.Handle("/api/repo/:uuid", repoRawMux)
 	repoRawMux.Use(repoRawSelector)
-	repoRawMux.Head("/api/repo/:uuid", repoHeadHandler)
+	handle(repoRawMux, datastore.Route{Method: "HEAD", Path: "/api/repo/:uuid", Summary: "Returns status 200 if the repo exists."}, repoHeadHandler)
 
 	repoMux := web.New()
 	mainMux.Handle("/api/repo/:uuid/:action", repoMux)
+	mainMux.Handle("/api/repo/:uuid/:action/*", repoMux)
 	repoMux.Use(repoSelector)
-	repoMux.Get("/api/repo/:uuid/info", repoInfoHandler)
-	repoMux.Post("/api/repo/:uuid/instance", repoNewDataHandler)
-	repoMux.Get("/api/repo/:uuid/log", getRepoLogHandler)
-	repoMux.Post("/api/repo/:uuid/log", postRepoLogHandler)
-	repoMux.Post("/api/repo/:uuid/merge", repoMergeHandler)
-	repoMux.Post("/api/repo/:uuid/resolve", repoResolveHandler)
+	repoMux.Use(rangeHandler)
+	route(repoMux, "GET", "/api/repo/:uuid/info", repoInfoHandler, "Returns JSON of the repo.")
+	route(repoMux, "GET", "/api/repo/:uuid/instances", getRepoInstancesHandler, "Returns a JSON list of the repo's data instances.", append([]datastore.RouteParam{{Name: "type", Description: "Only instances of the datatype."}}, listParams...)...)
+	route(repoMux, "POST", "/api/repo/:uuid/instance", repoNewDataHandler, "Creates a data instance.")
+	route(repoMux, "POST", "/api/repo/:uuid/instance/:dataname", repoModifyDataHandler, "Modifies the configuration of a data instance.")
+	route(repoMux, "GET", "/api/repo/:uuid/log", getRepoLogHandler, "Returns JSON of the repo log.")
+	route(repoMux, "POST", "/api/repo/:uuid/log", postRepoLogHandler, "Appends to the repo log.")
+	route(repoMux, "GET", "/api/repo/:uuid/tags", getRepoTagsHandler, "Returns JSON mapping the repo's tags to UUIDs.")
+	route(repoMux, "GET", "/api/repo/:uuid/dag", getRepoDAGHandler, "Returns JSON of the repo's version DAG.")
+	route(repoMux, "GET", "/api/repo/:uuid/trash", getRepoTrashHandler, "Returns JSON of the data instances in the repo's trash.")
+	route(repoMux, "GET", "/api/repo/:uuid/usage", repoUsageHandler, "Returns JSON of the approximate disk usage of the repo.", versionsParam)
+	route(repoMux, "GET", "/api/repo/:uuid/version-usage", getRepoVersionUsageHandler, "Returns JSON of the bytes stored in each version.")
+	route(repoMux, "POST", "/api/repo/:uuid/version-usage", postRepoVersionUsageHandler, "Starts a job measuring the bytes stored in each version.")
+	handle(repoMux, datastore.Route{Method: "GET", Path: "/api/repo/:uuid/export", Summary: "Streams an archive of the repo.", Returns: "application/octet-stream"}, repoExportHandler)
+	route(repoMux, "GET", "/api/repo/:uuid/quota", getRepoQuotaHandler, "Returns JSON of the repo's storage quota and usage.")
+	route(repoMux, "POST", "/api/repo/:uuid/quota", postRepoQuotaHandler, "Sets the repo's storage quota.")
+	route(repoMux, "GET", "/api/repo/:uuid/properties", getRepoPropertiesHandler, "Returns JSON of the repo's properties.")
+	route(repoMux, "POST", "/api/repo/:uuid/properties", postRepoPropertiesHandler, "Sets or removes repo properties.")
+	route(repoMux, "GET", "/api/repo/:uuid/autobranch", getRepoAutoBranchHandler, "Returns JSON of the repo's auto-branch setting.")
+	route(repoMux, "POST", "/api/repo/:uuid/autobranch", postRepoAutoBranchHandler, "Sets the repo's auto-branch setting.")
+	route(repoMux, "GET", "/api/repo/:uuid/roles", getRepoRolesHandler, "Returns JSON of the repo's role assignments.")
+	route(repoMux, "POST", "/api/repo/:uuid/roles", postRepoRolesHandler, "Sets the repo's role assignments.")
+	route(repoMux, "GET", "/api/repo/:uuid/diff", repoDiffHandler, "Returns JSON of the changes to a data instance between versions.",
+		datastore.RouteParam{Name: "data", Description: "Name of the data instance.", Required: true},
+		datastore.RouteParam{Name: "from", Description: "UUID of the earlier version.", Required: true},
+		datastore.RouteParam{Name: "to", Description: "UUID of the later version.", Required: true},
+		datastore.RouteParam{Name: "keys", Description: "If \"true\", lists the changed keys."})
+	route(repoMux, "POST", "/api/repo/:uuid/merge", repoMergeHandler, "Merges committed parents into a child.")
+	route(repoMux, "POST", "/api/repo/:uuid/resolve", repoResolveHandler, "Merges committed parents into a child, resolving conflicts by parent order.")
 
 	nodeMux := web.New()
 	mainMux.Handle("/api/node/:uuid", nodeMux)
 	mainMux.Handle("/api/node/:uuid/:action", nodeMux)
 	nodeMux.Use(repoRawSelector)
 	nodeMux.Use(nodeSelector)
-	nodeMux.Get("/api/node/:uuid/note", getNodeNoteHandler)
-	nodeMux.Post("/api/node/:uuid/note", postNodeNoteHandler)
-	nodeMux.Get("/api/node/:uuid/log", getNodeLogHandler)
-	nodeMux.Post("/api/node/:uuid/log", postNodeLogHandler)
-	nodeMux.Get("/api/node/:uuid/commit", repoCommitStateHandler)
-	nodeMux.Post("/api/node/:uuid/commit", repoCommitHandler)
-	nodeMux.Post("/api/node/:uuid/branch", repoBranchHandler)
-	nodeMux.Post("/api/node/:uuid/newversion", repoNewVersionHandler)
+	route(nodeMux, "GET", "/api/node/:uuid/note", getNodeNoteHandler, "Returns JSON of the node's note.")
+	route(nodeMux, "POST", "/api/node/:uuid/note", postNodeNoteHandler, "Sets the node's note.")
+	route(nodeMux, "GET", "/api/node/:uuid/log", getNodeLogHandler, "Returns JSON of the node's log.")
+	route(nodeMux, "POST", "/api/node/:uuid/log", postNodeLogHandler, "Appends to the node's log.")
+	route(nodeMux, "GET", "/api/node/:uuid/commit", repoCommitStateHandler, "Returns JSON of whether the node is committed.")
+	route(nodeMux, "POST", "/api/node/:uuid/commit", repoCommitHandler, "Commits (locks) the node.")
+	route(nodeMux, "POST", "/api/node/:uuid/unlock", repoUnlockHandler, "Reopens a committed node.")
+	route(nodeMux, "POST", "/api/node/:uuid/tag", postNodeTagHandler, "Adds a tag naming the node.")
+	route(nodeMux, "DELETE", "/api/node/:uuid/tag", deleteNodeTagHandler, "Removes a tag of the node.", datastore.RouteParam{Name: "tag", Description: "The tag to remove.", Required: true})
+	route(nodeMux, "POST", "/api/node/:uuid/revert", postNodeRevertHandler, "Starts a job reverting a data instance's changes in the node.")
+	route(nodeMux, "POST", "/api/node/:uuid/branch", repoBranchHandler, "Creates a child of the node on a new branch.")
+	route(nodeMux, "POST", "/api/node/:uuid/newversion", repoNewVersionHandler, "Creates a child of the node on the same branch.")
 
 	instanceMux := web.New()
 	mainMux.Handle("/api/node/:uuid/:dataname/:keyword", instanceMux)
 	mainMux.Handle("/api/node/:uuid/:dataname/:keyword/*", instanceMux)
 	instanceMux.Use(repoRawSelector)
 	instanceMux.Use(instanceSelector)
+	instanceMux.Use(rangeHandler)
 	instanceMux.NotFound(NotFound)
 
 	mainMux.Get("/*", mainHandler)
@@ -630,6 +1437,40 @@ func recoverHandler(c *web.C, h http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// requestLog returns a logger with the ID of a request and any repo and data instance
+// it accesses.
+func requestLog(c web.C) dvid.FieldLogger {
+	fields := dvid.Fields{"request_id": middleware.GetReqID(c)}
+	if uuid, ok := c.Env["uuid"].(dvid.UUID); ok {
+		fields["repo"] = string(uuid)
+	}
+	if data, ok := c.Env["data"].(dvid.Data); ok {
+		fields["instance"] = string(data.DataName())
+	}
+	return dvid.WithFields(fields)
+}
+
+// Middleware that logs each request after it completes with its status, duration, and
+// bytes written.
+func logHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := mutil.WrapWriter(w)
+		h.ServeHTTP(ww, r)
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+		requestLog(*c).WithFields(dvid.Fields{
+			"status":   code,
+			"duration": time.Since(start),
+			"bytes":    ww.BytesWritten(),
+			"remote":   r.RemoteAddr,
+		}).Infof("%s %s\n", r.Method, r.URL.RequestURI())
+	}
+	return http.HandlerFunc(fn)
+}
+
 func NotFound(w http.ResponseWriter, r *http.Request) {
 	errorMsg := fmt.Sprintf("Could not find the URL: %s", r.URL.Path)
 	dvid.Infof(errorMsg)
@@ -717,6 +1558,9 @@ func repoRawSelector(c *web.C, h http.Handler) http.Handler {
 			return
 		}
 		c.Env["uuid"] = uuid
+		if !authorizeRepo(c, w, r, uuid, datastore.RoleRead) {
+			return
+		}
 
 		h.ServeHTTP(w, r)
 	}
@@ -740,10 +1584,15 @@ func nodeSelector(c *web.C, h http.Handler) http.Handler {
 		}
 		action := strings.ToLower(r.Method)
 		branchRequest := (c.URLParams["action"] == "branch") || (c.URLParams["action"] == "newversion")
-		if !fullwrite && locked && !branchRequest && action != "get" && action != "head" {
+		tagRequest := c.URLParams["action"] == "tag" // tags name nodes without modifying them
+		unlockRequest := c.URLParams["action"] == "unlock"
+		if !fullwrite && locked && !branchRequest && !tagRequest && !unlockRequest && action != "get" && action != "head" {
 			BadRequest(w, r, "Cannot do %s on locked node %s", action, uuid)
 			return
 		}
+		if action != "get" && action != "head" && !authorizeRepo(c, w, r, uuid, datastore.RoleWrite) {
+			return
+		}
 		h.ServeHTTP(w, r)
 	}
 	return http.HandlerFunc(fn)
@@ -768,6 +1617,9 @@ func repoSelector(c *web.C, h http.Handler) http.Handler {
 			return
 		}
 		c.Env["uuid"] = uuid
+		if !authorizeRepo(c, w, r, uuid, repoActionRole(c.URLParams["action"], r.Method)) {
+			return
+		}
 		h.ServeHTTP(w, r)
 	}
 	return http.HandlerFunc(fn)
@@ -794,12 +1646,16 @@ func instanceSelector(c *web.C, h http.Handler) http.Handler {
 			BadRequest(w, r, err)
 			return
 		}
+		c.Env["data"] = data // for metrics of bytes served
 		v, err := datastore.VersionFromUUID(uuid)
 		if err != nil {
 			BadRequest(w, r, err)
 			return
 		}
 
+		if data.IsMutationRequest(r.Method, c.URLParams["keyword"]) && !authorizeRepo(c, w, r, uuid, datastore.RoleWrite) {
+			return
+		}
 		if data.Versioned() {
 			// Make sure we aren't trying mutable methods on committed nodes.
 			locked, err := datastore.LockedUUID(uuid)
@@ -808,8 +1664,23 @@ func instanceSelector(c *web.C, h http.Handler) http.Handler {
 				return
 			}
 			if !fullwrite && locked && data.IsMutationRequest(r.Method, c.URLParams["keyword"]) {
-				BadRequest(w, r, "Cannot do %s on endpoint %q of locked node %s", r.Method, c.URLParams["keyword"], uuid)
-				return
+				autoBranch, err := datastore.AutoBranch(uuid)
+				if err != nil {
+					BadRequest(w, r, err)
+					return
+				}
+				if !autoBranch {
+					BadRequest(w, r, "Cannot do %s on endpoint %q of locked node %s", r.Method, c.URLParams["keyword"], uuid)
+					return
+				}
+				// Redirect the write to a new child of the locked node.
+				if uuid, v, err = datastore.AutoBranchVersion(uuid); err != nil {
+					BadRequest(w, r, err)
+					return
+				}
+				c.Env["uuid"] = uuid
+				c.Env["versionID"] = v
+				w.Header().Set(AutoBranchHeader, string(uuid))
 			}
 		} else {
 			// Map everything to root version.
@@ -819,10 +1690,75 @@ func instanceSelector(c *web.C, h http.Handler) http.Handler {
 				return
 			}
 		}
+		// Reject mutations once a repo's quota is used, and account for bytes written.
+		if data.IsMutationRequest(r.Method, c.URLParams["keyword"]) {
+			if err := datastore.CheckRepoQuota(uuid); err != nil {
+				if _, ok := err.(datastore.QuotaExceededError); ok {
+					http.Error(w, err.Error(), http.StatusInsufficientStorage)
+					return
+				}
+				BadRequest(w, r, err)
+				return
+			}
+			body := &countingReader{ReadCloser: r.Body}
+			if r.Body != nil {
+				r.Body = body
+			}
+			defer func() {
+				datastore.AddRepoBytes(uuid, body.n)
+			}()
+		}
+		if err := datastore.RecordAccess(uuid, data, data.IsMutationRequest(r.Method, c.URLParams["keyword"])); err != nil {
+			requestLog(*c).Errorf("Unable to record access of data %q in %s: %v\n", data.DataName(), uuid, err)
+		}
+
+		// Count mutations so ETags change, and answer conditional GETs of current data.
+		if data.IsMutationRequest(r.Method, c.URLParams["keyword"]) {
+			defer datastore.RecordMutation(data.DataUUID())
+		} else if notModified(w, r, uuid, data) {
+			return
+		}
 		ctx := datastore.NewVersionedCtx(data, v)
 
-		// Also set the web request information in case logging needs it downstream.
+		// Schedule throttled and buffered storage operations by the request's priority.
+		priority, client, err := requestPriority(r, data.IsMutationRequest(r.Method, c.URLParams["keyword"]))
+		if err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+		r = r.WithContext(storage.WithPriority(r.Context(), priority, client))
+
+		// Limit the concurrency and bandwidth of any throttled datatype or data instance.
+		if t := instanceThrottleFor(data, c.URLParams["keyword"]); t != nil {
+			if err := t.acquire(r.Context(), priority, client); err != nil {
+				msg := fmt.Sprintf("Request cancelled while waiting for throttled data %q: %v\n", data.DataName(), err)
+				http.Error(w, msg, http.StatusServiceUnavailable)
+				return
+			}
+			body := &countingReader{ReadCloser: r.Body}
+			if r.Body != nil {
+				r.Body = body
+			}
+			ww := mutil.WrapWriter(w)
+			w = ww
+			defer func() {
+				t.release(body.n + uint64(ww.BytesWritten()))
+			}()
+		}
+
+		// Trace the data instance's handling within the request's span so storage
+		// operations made with the versioned context are recorded as its children.
+		spanCtx, span := dvid.StartSpan(r.Context(), fmt.Sprintf("%s %s", data.TypeName(), c.URLParams["keyword"]), dvid.SpanInternal)
+		defer span.End(nil)
+		span.SetAttribute("dvid.data", string(data.DataName()))
+		span.SetAttribute("dvid.data_uuid", string(data.DataUUID()))
+		span.SetAttribute("dvid.uuid", string(uuid))
+		r = r.WithContext(spanCtx)
+
+		// Also set the web request information in case logging needs it downstream, and
+		// the request's context so storage range queries stop if the request is cancelled.
 		ctx.SetRequestID(middleware.GetReqID(*c))
+		ctx.SetContext(r.Context())
 
 		// Handle DVID-wide query string commands like non-interactive call designations
 		queryStrings := r.URL.Query()
@@ -842,6 +1778,18 @@ func instanceSelector(c *web.C, h http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n uint64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += uint64(n)
+	return n, err
+}
+
 // ---- Function types that fulfill http.Handler.  How can a bare function satisfy an interface?
 //      See http://www.onebigfluke.com/2014/04/gos-power-is-in-emergent-behavior.html
 
@@ -950,40 +1898,114 @@ func serverStorageHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, jsonStr)
 }
 
-func serverInfoHandler(w http.ResponseWriter, r *http.Request) {
-	jsonStr, err := AboutJSON()
+func serverUsageHandler(w http.ResponseWriter, r *http.Request) {
+	versions := r.URL.Query().Get("versions") == "true"
+	usage, err := datastore.GetUsage(versions)
 	if err != nil {
 		BadRequest(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, jsonStr)
-}
-
-func serverNoteHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain")
-	fmt.Fprintf(w, tc.Server.Note)
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
+		BadRequest(w, r, err)
+	}
 }
 
-func serverTypesHandler(w http.ResponseWriter, r *http.Request) {
-	jsonMap := make(map[dvid.TypeString]string)
-	typemap, err := datastore.Types()
+func serverStorageMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics, err := datastore.GetStoreMetrics()
 	if err != nil {
-		msg := fmt.Sprintf("Cannot return server datatypes: %v\n", err)
-		BadRequest(w, r, msg)
+		BadRequest(w, r, err)
 		return
 	}
-	for _, t := range typemap {
-		jsonMap[t.GetTypeName()] = string(t.GetTypeURL())
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(metrics); err != nil {
+		BadRequest(w, r, err)
 	}
-	m, err := json.Marshal(jsonMap)
-	if err != nil {
-		msg := fmt.Sprintf("Cannot marshal JSON datatype info: %v (%v)\n", jsonMap, err)
-		BadRequest(w, r, msg)
+}
+
+func serverCompactHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	alias := storage.Alias(query.Get("store"))
+	uuidStr, name := query.Get("uuid"), dvid.InstanceName(query.Get("name"))
+	var compact func() error
+	var target string
+	switch {
+	case alias != "" && uuidStr == "" && name == "":
+		if _, err := storage.GetStoreByAlias(alias); err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+		compact = func() error { return datastore.CompactStore(alias) }
+		target = fmt.Sprintf("store %q", alias)
+	case alias == "" && uuidStr != "" && name != "":
+		uuid, _, err := datastore.MatchingUUID(uuidStr)
+		if err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+		if _, err := datastore.GetDataByUUIDName(uuid, name); err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+		compact = func() error { return datastore.CompactData(uuid, name) }
+		target = fmt.Sprintf("data %q @ %s", name, uuid)
+	default:
+		BadRequest(w, r, "compaction requires either a store alias or a data instance uuid and name")
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, string(m))
+	go func() {
+		if err := compact(); err != nil {
+			dvid.Errorf("Compaction of %s failed: %v\n", target, err)
+		}
+	}()
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Started compaction of %s\n", target)
+}
+
+func serverGCHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := datastore.CollectGarbage()
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"Job": %d}`, job)
+}
+
+func serverInfoHandler(w http.ResponseWriter, r *http.Request) {
+	jsonStr, err := AboutJSON()
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, jsonStr)
+}
+
+func serverNoteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, tc.Server.Note)
+}
+
+func serverTypesHandler(w http.ResponseWriter, r *http.Request) {
+	jsonMap := make(map[dvid.TypeString]string)
+	typemap, err := datastore.Types()
+	if err != nil {
+		msg := fmt.Sprintf("Cannot return server datatypes: %v\n", err)
+		BadRequest(w, r, msg)
+		return
+	}
+	for _, t := range typemap {
+		jsonMap[t.GetTypeName()] = string(t.GetTypeURL())
+	}
+	m, err := json.Marshal(jsonMap)
+	if err != nil {
+		msg := fmt.Sprintf("Cannot marshal JSON datatype info: %v (%v)\n", jsonMap, err)
+		BadRequest(w, r, msg)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(m))
 }
 
 func serverCompiledTypesHandler(w http.ResponseWriter, r *http.Request) {
@@ -1017,6 +2039,46 @@ func serverGroupcacheHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, string(m))
 }
 
+func serverLRUCacheHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := storage.GetLRUCacheStats()
+	if err != nil {
+		BadRequest(w, r, fmt.Sprintf("cannot get LRU cache stats: %v", err))
+		return
+	}
+	m, err := json.Marshal(stats)
+	if err != nil {
+		msg := fmt.Sprintf("Cannot marshal JSON LRU cache stats info: %v (%v)\n", stats, err)
+		BadRequest(w, r, msg)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(m))
+}
+
+func serverJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(datastore.GetJobs()); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
+func serverJobHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(c.URLParams["id"], 10, 64)
+	if err != nil {
+		BadRequest(w, r, "bad job id %q", c.URLParams["id"])
+		return
+	}
+	job, err := datastore.GetJob(id)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
 func serverSettingsHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	config := dvid.NewConfig()
 	if err := config.SetByJSON(r.Body); err != nil {
@@ -1043,10 +2105,22 @@ func serverSettingsHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if found {
-		old := maxThrottledOps
+		old := throttle.Slots()
 		SetMaxThrottleOps(maxOps)
 		fmt.Fprintf(w, "Maximum throttled ops set to %d from %d\n", maxOps, old)
 	}
+
+	// Handle throttle slots reserved for interactive requests
+	reserved, found, err := config.GetInt("throttle_reserved")
+	if err != nil {
+		BadRequest(w, r, "POST on settings endpoint had bad parsing of 'throttle_reserved' key: %v", err)
+		return
+	}
+	if found {
+		old := throttle.Reserved()
+		throttle.SetReserved(reserved)
+		fmt.Fprintf(w, "Throttled ops reserved for interactive requests set to %d from %d\n", reserved, old)
+	}
 }
 
 func serverReload(c web.C, w http.ResponseWriter, r *http.Request) {
@@ -1058,19 +2132,69 @@ func serverReload(c web.C, w http.ResponseWriter, r *http.Request) {
 	datastore.MetadataUniversalUnlock()
 }
 
-func reposInfoHandler(w http.ResponseWriter, r *http.Request) {
+func reposInfoHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	jsonBytes, err := datastore.MarshalJSON()
 	if err != nil {
 		BadRequest(w, r, err)
 		return
 	}
+	if jsonBytes, err = filterRepos(c, jsonBytes); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	page, err := ParsePage(r)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	if fields := parseFields(r); page.Paged() || fields != nil {
+		if jsonBytes, err = pageRepos(w, r, jsonBytes, page, fields); err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprintf(w, string(jsonBytes))
 }
 
+// pageRepos returns a page of the repos JSON, keyed and ordered by root UUID, with only
+// the given fields of each repo if any are given.
+func pageRepos(w http.ResponseWriter, r *http.Request, jsonBytes []byte, page Page, fields []string) ([]byte, error) {
+	var repos map[string]json.RawMessage
+	if err := json.Unmarshal(jsonBytes, &repos); err != nil {
+		return nil, err
+	}
+	uuids := make([]string, 0, len(repos))
+	for uuid := range repos {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	start, end := page.Bounds(uuids)
+	paged := make(map[string]json.RawMessage, end-start)
+	for _, uuid := range uuids[start:end] {
+		repo := repos[uuid]
+		if fields != nil {
+			var err error
+			if repo, err = selectFields(repo, fields); err != nil {
+				return nil, err
+			}
+		}
+		paged[uuid] = repo
+	}
+	if end < len(uuids) {
+		SetNextPage(w, r, uuids[end-1])
+	}
+	return json.Marshal(paged)
+}
+
 // TODO -- Maybe allow assignment of child UUID via JSON in POST.  Right now, we only
 // allow this potentially dangerous function via command-line.
 func reposPostHandler(w http.ResponseWriter, r *http.Request) {
+	newRepo(w, r, "")
+}
+
+// newRepo creates a repo from the POSTed JSON config, in the given namespace if not "".
+func newRepo(w http.ResponseWriter, r *http.Request, namespace string) {
 	// Apply a global lock (if relevant) and reloads meta
 	if err := datastore.MetadataUniversalLock(); err != nil {
 		BadRequest(w, r, err)
@@ -1109,7 +2233,127 @@ func reposPostHandler(w http.ResponseWriter, r *http.Request) {
 		passcode = ""
 	}
 
-	root, err := datastore.NewRepo(alias, description, nil, passcode)
+	var root dvid.UUID
+	if namespace == "" {
+		root, err = datastore.NewRepo(alias, description, nil, passcode)
+	} else {
+		root, err = datastore.NewNamespaceRepo(namespace, alias, description, nil, passcode)
+	}
+	if err != nil {
+		if _, ok := err.(datastore.QuotaExceededError); ok {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %q}", "root", root)
+}
+
+func namespacesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(datastore.Namespaces()); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
+func namespaceInfoHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	namespace := c.URLParams["ns"]
+	config, err := datastore.GetNamespace(namespace)
+	if err != nil {
+		NotFound(w, r)
+		return
+	}
+	quota, used, err := datastore.GetNamespaceQuota(namespace)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	uuids, err := datastore.NamespaceRepos(namespace)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	info := struct {
+		Name     string
+		Quota    uint64
+		Used     uint64
+		IDPrefix uint32
+		Private  bool
+		Repos    int
+	}{namespace, quota, used, config.IDPrefix, config.Private, len(uuids)}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
+func namespaceReposInfoHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	namespace := c.URLParams["ns"]
+	if _, err := datastore.GetNamespace(namespace); err != nil {
+		NotFound(w, r)
+		return
+	}
+	jsonBytes, err := datastore.MarshalJSON()
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	var repos map[string]json.RawMessage
+	if err := json.Unmarshal(jsonBytes, &repos); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	for uuid := range repos {
+		if repoNamespace, err := datastore.RepoNamespace(dvid.UUID(uuid)); err != nil || repoNamespace != namespace {
+			delete(repos, uuid)
+		}
+	}
+	if jsonBytes, err = json.Marshal(repos); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	if jsonBytes, err = filterRepos(c, jsonBytes); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	page, err := ParsePage(r)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	if fields := parseFields(r); page.Paged() || fields != nil {
+		if jsonBytes, err = pageRepos(w, r, jsonBytes, page, fields); err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func namespaceReposPostHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	namespace := c.URLParams["ns"]
+	if _, err := datastore.GetNamespace(namespace); err != nil {
+		NotFound(w, r)
+		return
+	}
+	newRepo(w, r, namespace)
+}
+
+func reposImportHandler(w http.ResponseWriter, r *http.Request) {
+	if err := datastore.MetadataUniversalLock(); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	defer datastore.MetadataUniversalUnlock()
+
+	if httpUnavailable(w) {
+		return
+	}
+
+	root, err := datastore.ImportRepo(r.Body)
 	if err != nil {
 		BadRequest(w, r, err)
 		return
@@ -1140,6 +2384,50 @@ func repoInfoHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, jsonStr)
 }
 
+func getRepoInstancesHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	page, err := ParsePage(r)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	instances, err := datastore.GetRepoData(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	typename := r.URL.Query().Get("type")
+	var names []string
+	var matched []datastore.DataService
+	for _, data := range instances {
+		if typename == "" || string(data.TypeName()) == typename {
+			names = append(names, string(data.DataName()))
+			matched = append(matched, data)
+		}
+	}
+	start, end := page.Bounds(names)
+	fields := parseFields(r)
+	items := make([]json.RawMessage, 0, end-start)
+	for _, data := range matched[start:end] {
+		item, err := json.Marshal(data)
+		if err == nil && fields != nil {
+			item, err = selectFields(item, fields)
+		}
+		if err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+		items = append(items, item)
+	}
+	if end < len(names) {
+		SetNextPage(w, r, names[end-1])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(items); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
 func repoNewDataHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	// Apply a global lock (if relevant) and reloads meta
 	if err := datastore.MetadataUniversalLock(); err != nil {
@@ -1191,6 +2479,29 @@ func repoNewDataHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, `{%q: "Added %s [%s] to node %s"}`, "result", dataname, typename, uuid)
 }
 
+func repoModifyDataHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	if err := datastore.MetadataUniversalLock(); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	defer datastore.MetadataUniversalUnlock()
+
+	uuid := c.Env["uuid"].(dvid.UUID)
+	dataname := dvid.InstanceName(c.URLParams["dataname"])
+
+	config := dvid.NewConfig()
+	if err := config.SetByJSON(r.Body); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Error decoding POSTed JSON config for data %q: %v", dataname, err))
+		return
+	}
+	if err := datastore.ModifyDataConfigByName(uuid, dataname, config); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{%q: "Modified %s in repo %s"}`, "result", dataname, uuid)
+}
+
 func getRepoLogHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	uuid := c.Env["uuid"].(dvid.UUID)
 	logdata, err := datastore.GetRepoLog(uuid)
@@ -1229,6 +2540,231 @@ func postRepoLogHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func repoUsageHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	versions := r.URL.Query().Get("versions") == "true"
+	usage, err := datastore.GetRepoUsage(uuid, versions)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
+func getRepoVersionUsageHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	report, err := datastore.GetVersionUsage(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
+func postRepoVersionUsageHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	job, err := datastore.MeasureVersionUsage(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"Job": %d}`, job)
+}
+
+func repoExportHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := datastore.ExportRepo(uuid, w); err != nil {
+		// Headers may already have been sent, so just log the error.
+		dvid.Errorf("export of repo %s failed: %v\n", uuid, err)
+	}
+}
+
+func getRepoQuotaHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	quota, used, err := datastore.GetRepoQuota(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"Quota": %d, "Used": %d}`, quota, used)
+}
+
+func postRepoQuotaHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	var jsonData struct {
+		Quota *uint64
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonData); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %s", err))
+		return
+	}
+	if jsonData.Quota == nil {
+		BadRequest(w, r, "Could not find 'Quota' value in POSTed JSON.")
+		return
+	}
+	if err := datastore.SetRepoQuota(uuid, *jsonData.Quota); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+}
+
+func getRepoPropertiesHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	props, err := datastore.GetRepoProperties(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(props); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
+func postRepoPropertiesHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	var props map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&props); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %s", err))
+		return
+	}
+	if err := datastore.SetRepoProperties(uuid, props); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	dvid.Infof("Set %d properties of repo %s\n", len(props), uuid)
+}
+
+func getRepoAutoBranchHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	autoBranch, err := datastore.AutoBranch(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"AutoBranch": %t}`, autoBranch)
+}
+
+func postRepoAutoBranchHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	var jsonData struct {
+		AutoBranch *bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonData); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %s", err))
+		return
+	}
+	if jsonData.AutoBranch == nil {
+		BadRequest(w, r, "Could not find 'AutoBranch' value in POSTed JSON.")
+		return
+	}
+	if err := datastore.SetAutoBranch(uuid, *jsonData.AutoBranch); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+}
+
+func getRepoTagsHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	tags, err := datastore.GetTags(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tags); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
+func getRepoDAGHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	dag, err := datastore.GetDAG(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dag); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
+func getRepoTrashHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	trash, err := datastore.GetTrash(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(trash); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
+func postNodeTagHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	var jsonData struct {
+		Tag string `json:"tag"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonData); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %s", err))
+		return
+	}
+	if err := datastore.SetTag(uuid, jsonData.Tag); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	dvid.Infof("Tagged node %s as %q\n", uuid, jsonData.Tag)
+}
+
+func deleteNodeTagHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	tag := r.URL.Query().Get("tag")
+	if tag == "" {
+		BadRequest(w, r, "DELETE of tag requires 'tag' query string")
+		return
+	}
+	if err := datastore.DeleteTag(uuid, tag); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	dvid.Infof("Deleted tag %q from repo with node %s\n", tag, uuid)
+}
+
+func postNodeRevertHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	var jsonData struct {
+		Data dvid.InstanceName `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonData); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %s", err))
+		return
+	}
+	if jsonData.Data == "" {
+		BadRequest(w, r, "POST of revert requires 'data' in JSON body")
+		return
+	}
+	job, err := datastore.RevertNode(uuid, jsonData.Data)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"Job": %d}`, job)
+}
+
 func getNodeNoteHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	uuid := c.Env["uuid"].(dvid.UUID)
 	note, err := datastore.GetNodeNote(uuid)
@@ -1318,10 +2854,23 @@ func repoCommitStateHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	locked, err := datastore.LockedUUID(uuid)
 	if err != nil {
 		BadRequest(w, r, err)
-	} else {
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"Locked":%t}`, locked)
+		return
 	}
+	info, err := datastore.GetCommitInfo(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	jsonBytes, err := json.Marshal(struct {
+		Locked bool
+		Commit *datastore.CommitInfo `json:",omitempty"`
+	}{locked, info})
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
 }
 
 func repoCommitHandler(c web.C, w http.ResponseWriter, r *http.Request) {
@@ -1351,13 +2900,22 @@ func repoCommitHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	jsonData := struct {
 		Note string   `json:"note"`
 		Log  []string `json:"log"`
+		User string   `json:"user"`
+		App  string   `json:"app"`
 	}{}
 	if err := json.Unmarshal(data, &jsonData); err != nil {
 		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %v", err))
 		return
 	}
+	info := datastore.CommitInfo{Message: jsonData.Note, User: jsonData.User, App: jsonData.App}
+	if info.User == "" {
+		info.User = r.URL.Query().Get("u")
+	}
+	if info.App == "" {
+		info.App = r.URL.Query().Get("app")
+	}
 
-	err = datastore.Commit(uuid, jsonData.Note, jsonData.Log)
+	err = datastore.CommitWithInfo(uuid, info, jsonData.Log)
 	if err != nil {
 		BadRequest(w, r, err)
 	} else {
@@ -1366,6 +2924,36 @@ func repoCommitHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func repoUnlockHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Apply a global lock (if relevant) and reloads meta
+	if err := datastore.MetadataUniversalLock(); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	defer datastore.MetadataUniversalUnlock()
+
+	uuid := c.Env["uuid"].(dvid.UUID)
+	var jsonData struct {
+		Reason   string `json:"reason"`
+		User     string `json:"user"`
+		Passcode string `json:"passcode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jsonData); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %v", err))
+		return
+	}
+	if jsonData.User == "" {
+		jsonData.User = r.URL.Query().Get("u")
+	}
+	if err := datastore.Unlock(uuid, jsonData.Reason, jsonData.User, jsonData.Passcode); err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	dvid.Infof("Unlocked node %s: %s\n", uuid, jsonData.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %q}", "unlocked", uuid)
+}
+
 // repoNewVersionHandler creates a new version node with the same branch as the parent
 func repoNewVersionHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	// Apply a global lock (if relevant) and reloads meta
@@ -1454,6 +3042,51 @@ func repoBranchHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func repoDiffHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := c.Env["uuid"].(dvid.UUID)
+	queryStrings := r.URL.Query()
+	name := dvid.InstanceName(queryStrings.Get("data"))
+	if name == "" {
+		BadRequest(w, r, "diff requires a data instance to be specified by the 'data' query string")
+		return
+	}
+	data, err := datastore.GetDataByUUIDName(uuid, name)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	root, err := datastore.GetRepoRoot(uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	var versions [2]dvid.VersionID
+	for i, param := range []string{"from", "to"} {
+		if queryStrings.Get(param) == "" {
+			BadRequest(w, r, "diff requires versions to be specified by 'from' and 'to' query strings")
+			return
+		}
+		var vuuid dvid.UUID
+		if vuuid, versions[i], err = datastore.MatchingUUID(queryStrings.Get(param)); err != nil {
+			BadRequest(w, r, fmt.Sprintf("can't match %q version: %v", param, err))
+			return
+		}
+		if vroot, err := datastore.GetRepoRoot(vuuid); err != nil || vroot != root {
+			BadRequest(w, r, fmt.Sprintf("%q version %s is not in repo %s", param, vuuid, root))
+			return
+		}
+	}
+	diff, err := datastore.DiffVersions(data, versions[0], versions[1], queryStrings.Get("keys") == "true")
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(diff); err != nil {
+		BadRequest(w, r, err)
+	}
+}
+
 func repoMergeHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	if r.Body == nil {
 		BadRequest(w, r, "merge requires JSON to be POSTed per API documentation")
@@ -1466,9 +3099,10 @@ func repoMergeHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	}
 
 	jsonData := struct {
-		MergeType string   `json:"mergeType"`
-		Note      string   `json:"note"`
-		Parents   []string `json:"parents"`
+		MergeType      string            `json:"mergeType"`
+		Note           string            `json:"note"`
+		Parents        []string          `json:"parents"`
+		TypeMergeTypes map[string]string `json:"typeMergeTypes"`
 	}{}
 	if err := json.Unmarshal(data, &jsonData); err != nil {
 		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %v", err))
@@ -1490,18 +3124,29 @@ func repoMergeHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 		parents[i] = uuid
 	}
 
-	// Convert merge type designation
-	var mt datastore.MergeType
-	switch jsonData.MergeType {
-	case "conflict-free":
-		mt = datastore.MergeConflictFree
-	default:
-		BadRequest(w, r, fmt.Sprintf("'mergeType' must be 'conflict-free' at this time"))
-		return
-	}
-
 	// Do the merge
-	newuuid, err := datastore.Merge(parents, jsonData.Note, mt)
+	var newuuid dvid.UUID
+	if jsonData.MergeType == "conflict-free" {
+		if len(jsonData.TypeMergeTypes) != 0 {
+			BadRequest(w, r, "'typeMergeTypes' can't be used with a conflict-free merge")
+			return
+		}
+		newuuid, err = datastore.Merge(parents, jsonData.Note, datastore.MergeConflictFree)
+	} else {
+		var strategy datastore.MergeStrategy
+		if strategy, err = datastore.ParseMergeStrategy(jsonData.MergeType); err != nil {
+			BadRequest(w, r, fmt.Sprintf("bad 'mergeType': %v", err))
+			return
+		}
+		typeStrategies := make(map[dvid.TypeString]datastore.MergeStrategy, len(jsonData.TypeMergeTypes))
+		for typename, mergeType := range jsonData.TypeMergeTypes {
+			if typeStrategies[dvid.TypeString(typename)], err = datastore.ParseMergeStrategy(mergeType); err != nil {
+				BadRequest(w, r, fmt.Sprintf("bad merge type for datatype %q: %v", typename, err))
+				return
+			}
+		}
+		newuuid, err = datastore.MergeWithStrategy(parents, jsonData.Note, strategy, typeStrategies)
+	}
 	if err != nil {
 		BadRequest(w, r, err)
 	} else {
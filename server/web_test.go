@@ -1,14 +1,31 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/zenazn/goji/web"
 )
 
 func testLog(t *testing.T, got, expect string) {
@@ -128,16 +145,33 @@ func TestCommitBranchMergeDelete(t *testing.T) {
 	}
 
 	// Commit it.
-	payload := bytes.NewBufferString(`{"note": "This is my test commit", "log": ["line1", "line2", "some more stuff in a line"]}`)
+	payload := bytes.NewBufferString(`{"note": "This is my test commit", "log": ["line1", "line2", "some more stuff in a line"], "user": "tester"}`)
 	apiStr := fmt.Sprintf("%snode/%s/commit", WebAPIPath, uuid)
 	TestHTTP(t, "POST", apiStr, payload)
 
 	// Check commit status
 	checkReq = fmt.Sprintf("%snode/%s/commit", WebAPIPath, uuid)
 	retVal = TestHTTP(t, "GET", checkReq, nil)
-	if string(retVal) != `{"Locked":true}` {
-		t.Errorf("Expected locked commit status, got: %s\n", string(retVal))
+	var status struct {
+		Locked bool
+		Commit *datastore.CommitInfo
+	}
+	if err := json.Unmarshal(retVal, &status); err != nil {
+		t.Fatalf("Unable to parse commit status %s: %v\n", string(retVal), err)
+	}
+	if !status.Locked || status.Commit == nil || status.Commit.Message != "This is my test commit" || status.Commit.User != "tester" {
+		t.Errorf("Expected locked commit status with commit info, got: %s\n", string(retVal))
+	}
+
+	// The commit should be recorded in the node log after the posted log.
+	nodeLog, err := datastore.GetNodeLog(uuid)
+	if err != nil {
+		t.Fatalf("Unable to get node log: %v\n", err)
+	}
+	if len(nodeLog) != 4 {
+		t.Fatalf("Expected posted log and commit in node log, got %v\n", nodeLog)
 	}
+	testLog(t, nodeLog[3], "committed by tester: This is my test commit")
 
 	// Make sure committed nodes can only be read.
 	// We shouldn't be able to write to log.
@@ -179,3 +213,1026 @@ func TestCommitBranchMergeDelete(t *testing.T) {
 	apiStr = fmt.Sprintf("%srepo/%s/merge", WebAPIPath, parent1)
 	TestHTTP(t, "POST", apiStr, payload)
 }
+
+func TestNodeTags(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+	if err := datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit root %s: %v\n", uuid, err)
+	}
+
+	// Committed nodes can be tagged, but tags that could be UUID prefixes are rejected.
+	tagReq := fmt.Sprintf("%snode/%s/tag", WebAPIPath, uuid)
+	TestHTTP(t, "POST", tagReq, bytes.NewBufferString(`{"tag": "release-1.0"}`))
+	TestBadHTTP(t, "POST", tagReq, bytes.NewBufferString(`{"tag": "abc123"}`))
+
+	retVal := TestHTTP(t, "GET", fmt.Sprintf("%srepo/release-1.0/tags", WebAPIPath), nil)
+	var tags map[string]dvid.UUID
+	if err := json.Unmarshal(retVal, &tags); err != nil {
+		t.Fatalf("Unable to parse tags %s: %v\n", string(retVal), err)
+	}
+	if len(tags) != 1 || tags["release-1.0"] != uuid {
+		t.Errorf("Expected only tag release-1.0 for %s, got %v\n", uuid, tags)
+	}
+	if matched, _, err := datastore.MatchingUUID("release-1.0"); err != nil || matched != uuid {
+		t.Errorf("Expected tag to match %s, got %s: %v\n", uuid, matched, err)
+	}
+
+	TestHTTP(t, "DELETE", fmt.Sprintf("%snode/release-1.0/tag?tag=release-1.0", WebAPIPath), nil)
+	TestBadHTTP(t, "GET", fmt.Sprintf("%srepo/release-1.0/info", WebAPIPath), nil)
+
+	repoLog, err := datastore.GetRepoLog(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repoLog) < 2 || !strings.HasSuffix(repoLog[len(repoLog)-2], fmt.Sprintf("Tagged node %s as \"release-1.0\"", uuid)) ||
+		!strings.HasSuffix(repoLog[len(repoLog)-1], fmt.Sprintf("Removed tag \"release-1.0\" from node %s", uuid)) {
+		t.Errorf("Expected tag changes in repo log, got %v\n", repoLog)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+
+	SetRequireTokens(true)
+	defer SetRequireTokens(false)
+
+	noteReq := fmt.Sprintf("%snode/%s/note", WebAPIPath, uuid)
+	TestHTTP(t, "GET", noteReq, nil)
+	resp := TestHTTPResponse(t, "POST", noteReq, bytes.NewBufferString(`{"note": "no token"}`))
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized note POST without token, got %d\n", resp.Code)
+	}
+
+	admin, _, err := datastore.CreateAPIToken("admin", []string{datastore.ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokensReq := fmt.Sprintf("%sserver/tokens", WebAPIPath)
+	req, _ := http.NewRequest("POST", tokensReq, bytes.NewBufferString(`{"name": "writer", "scopes": ["write"]}`))
+	req.Header.Set("Authorization", "Bearer "+admin)
+	w := httptest.NewRecorder()
+	ServeSingleHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unable to create token: %s\n", w.Body.String())
+	}
+	var created struct {
+		ID    string
+		Token string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("bad token creation response %s: %v\n", w.Body.String(), err)
+	}
+
+	req, _ = http.NewRequest("POST", noteReq, bytes.NewBufferString(`{"note": "with token"}`))
+	req.Header.Set("Authorization", "Bearer "+created.Token)
+	w = httptest.NewRecorder()
+	ServeSingleHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected note POST with write token to succeed, got %d: %s\n", w.Code, w.Body.String())
+	}
+
+	// Write tokens can't manage tokens.
+	req, _ = http.NewRequest("GET", tokensReq, nil)
+	req.Header.Set("Authorization", "Bearer "+created.Token)
+	w = httptest.NewRecorder()
+	ServeSingleHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected forbidden token listing with write token, got %d\n", w.Code)
+	}
+
+	if err := datastore.RevokeAPIToken(created.ID); err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest("POST", noteReq, bytes.NewBufferString(`{"note": "revoked"}`))
+	req.Header.Set("Authorization", "Bearer "+created.Token)
+	w = httptest.NewRecorder()
+	ServeSingleHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized note POST with revoked token, got %d\n", w.Code)
+	}
+}
+
+func TestTokenBootstrap(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	SetBootstrapSecret("bootstrap secret")
+	defer SetBootstrapSecret("")
+
+	tokensReq := fmt.Sprintf("%sserver/tokens", WebAPIPath)
+	createToken := func(remoteAddr, bearer string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", tokensReq, bytes.NewBufferString(`{"name": "first", "scopes": ["admin"]}`))
+		req.RemoteAddr = remoteAddr
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		w := httptest.NewRecorder()
+		ServeSingleHTTP(w, req)
+		return w
+	}
+
+	// Tokens are managed only by admins even if tokens aren't required.
+	if w := createToken("192.0.2.1:1234", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized remote token creation without token, got %d\n", w.Code)
+	}
+	if w := createToken("192.0.2.1:1234", "wrong secret"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized remote token creation with wrong secret, got %d\n", w.Code)
+	}
+	req, _ := http.NewRequest("GET", tokensReq, nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	w := httptest.NewRecorder()
+	ServeSingleHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized local token listing without token, got %d\n", w.Code)
+	}
+
+	// The first admin token can be created from localhost or with the bootstrap secret.
+	if w := createToken("127.0.0.1:1234", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected local creation of first token, got %d: %s\n", w.Code, w.Body.String())
+	}
+	if w := createToken("[::1]:1234", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized local token creation after admin token exists, got %d\n", w.Code)
+	}
+	if w := createToken("192.0.2.1:1234", "bootstrap secret"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized token creation with secret after admin token exists, got %d\n", w.Code)
+	}
+	tokens, err := datastore.APITokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if err := datastore.RevokeAPIToken(token.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := createToken("192.0.2.1:1234", "bootstrap secret"); w.Code != http.StatusOK {
+		t.Fatalf("expected creation of first token with secret, got %d: %s\n", w.Code, w.Body.String())
+	}
+}
+
+func TestRPCTokens(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	create := &datastore.Request{Command: dvid.Command{"tokens", "create", "first", "admin"}}
+	if err := checkRPCToken(create, "192.0.2.1:5000"); err == nil {
+		t.Errorf("expected error on remote token creation without token\n")
+	}
+	if err := checkRPCToken(create, "127.0.0.1:5000"); err != nil {
+		t.Errorf("expected local creation of first token, got %v\n", err)
+	}
+	admin, _, err := datastore.CreateAPIToken("admin", []string{datastore.ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRPCToken(create, "127.0.0.1:5000"); err == nil {
+		t.Errorf("expected error on local token creation without token after admin token exists\n")
+	}
+	writer, _, err := datastore.CreateAPIToken("writer", []string{datastore.ScopeWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := &datastore.Request{Command: dvid.Command{"tokens", "list"}, Token: writer}
+	if err := checkRPCToken(list, "127.0.0.1:5000"); err == nil {
+		t.Errorf("expected error on token listing with write token\n")
+	}
+	list.Token = admin
+	if err := checkRPCToken(list, "192.0.2.1:5000"); err != nil {
+		t.Errorf("expected token listing with admin token, got %v\n", err)
+	}
+
+	// Scopes of given tokens are checked even if tokens aren't required.
+	shutdown := &datastore.Request{Command: dvid.Command{"shutdown"}}
+	if err := checkRPCToken(shutdown, "192.0.2.1:5000"); err != nil {
+		t.Errorf("expected shutdown without token when tokens aren't required, got %v\n", err)
+	}
+	shutdown.Token = writer
+	if err := checkRPCToken(shutdown, "192.0.2.1:5000"); err == nil {
+		t.Errorf("expected error on shutdown with write token\n")
+	}
+	newRepo := &datastore.Request{Command: dvid.Command{"repos", "new", "a", "b"}, Token: "bad token"}
+	if err := checkRPCToken(newRepo, "192.0.2.1:5000"); err == nil {
+		t.Errorf("expected error on command with invalid token\n")
+	}
+	newRepo.Token = writer
+	if err := checkRPCToken(newRepo, "192.0.2.1:5000"); err != nil {
+		t.Errorf("expected new repo with write token, got %v\n", err)
+	}
+}
+
+func TestRepoRoles(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+	other, _ := datastore.NewTestRepo()
+
+	readerToken, reader, err := datastore.CreateAPIToken("reader", []string{datastore.ScopeWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Repos without role assignments are open.
+	noteReq := fmt.Sprintf("%snode/%s/note", WebAPIPath, uuid)
+	TestHTTP(t, "POST", noteReq, bytes.NewBufferString(`{"note": "open"}`))
+
+	rolesReq := fmt.Sprintf("%srepo/%s/roles", WebAPIPath, uuid)
+	principal := datastore.APITokenPrincipal(reader.ID)
+	TestHTTP(t, "POST", rolesReq, bytes.NewBufferString(fmt.Sprintf(`{%q: "read"}`, principal)))
+
+	// Now the repo is restricted and unauthenticated requests are refused.
+	resp := TestHTTPResponse(t, "GET", noteReq, nil)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized GET of restricted repo, got %d\n", resp.Code)
+	}
+	TestBadHTTP(t, "POST", rolesReq, bytes.NewBufferString(`{"*": "admin"}`))
+
+	request := func(method, url, body string) int {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+readerToken)
+		w := httptest.NewRecorder()
+		ServeSingleHTTP(w, req)
+		return w.Code
+	}
+	if code := request("GET", noteReq, ""); code != http.StatusOK {
+		t.Errorf("expected reader to GET note, got %d\n", code)
+	}
+	if code := request("POST", noteReq, `{"note": "denied"}`); code != http.StatusForbidden {
+		t.Errorf("expected reader to be forbidden from POST of note, got %d\n", code)
+	}
+
+	// Restricted repos are hidden from others in the repos listing.
+	var repos map[dvid.UUID]interface{}
+	if err := json.Unmarshal(TestHTTP(t, "GET", WebAPIPath+"repos/info", nil), &repos); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := repos[uuid]; found {
+		t.Errorf("expected restricted repo to be hidden from unauthenticated listing\n")
+	}
+	if _, found := repos[other]; !found {
+		t.Errorf("expected open repo %s in listing\n", other)
+	}
+
+	// Removing all assignments opens the repo again.
+	if err := datastore.SetRepoRole(uuid, principal, datastore.RoleNone); err != nil {
+		t.Fatal(err)
+	}
+	TestHTTP(t, "POST", noteReq, bytes.NewBufferString(`{"note": "open again"}`))
+}
+
+func TestNamespaces(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	if err := datastore.SetNamespaces(map[string]datastore.NamespaceConfig{"lab1": {}, "lab2": {Private: true}}); err != nil {
+		t.Fatal(err)
+	}
+	defer datastore.SetNamespaces(nil)
+
+	SetRequireTokens(true)
+	defer SetRequireTokens(false)
+
+	labToken, _, err := datastore.CreateAPIToken("lab1", []string{datastore.NamespaceScope(datastore.ScopeWrite, "lab1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+labToken)
+		w := httptest.NewRecorder()
+		ServeSingleHTTP(w, req)
+		return w
+	}
+
+	w := request("POST", WebAPIPath+"ns/lab1/repos", `{"alias": "lab repo"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected repo creation in namespace with its token, got %d: %s\n", w.Code, w.Body.String())
+	}
+	var created struct {
+		Root dvid.UUID
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if w := request("POST", WebAPIPath+"ns/lab2/repos", `{"alias": "other lab"}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected repo creation in another namespace to be forbidden, got %d\n", w.Code)
+	}
+	if w := request("POST", WebAPIPath+"repos", `{"alias": "no namespace"}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected repo creation outside namespace to be forbidden, got %d\n", w.Code)
+	}
+
+	other, _ := datastore.NewTestRepo()
+	noteReq := fmt.Sprintf("%snode/%s/note", WebAPIPath, created.Root)
+	if w := request("POST", noteReq, `{"note": "lab note"}`); w.Code != http.StatusOK {
+		t.Errorf("expected note POST in namespace repo, got %d: %s\n", w.Code, w.Body.String())
+	}
+	noteReq = fmt.Sprintf("%snode/%s/note", WebAPIPath, other)
+	if w := request("POST", noteReq, `{"note": "not lab"}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected note POST in repo outside namespace to be forbidden, got %d\n", w.Code)
+	}
+
+	// Repos of a private namespace are hidden without a scope for it.
+	private, err := datastore.NewNamespaceRepo("lab2", "private", "", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := TestHTTPResponse(t, "GET", fmt.Sprintf("%srepo/%s/info", WebAPIPath, private), nil)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized GET of private namespace repo, got %d\n", resp.Code)
+	}
+	var repos map[dvid.UUID]interface{}
+	if err := json.Unmarshal(TestHTTP(t, "GET", WebAPIPath+"repos/info", nil), &repos); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := repos[private]; found {
+		t.Errorf("expected private namespace repo to be hidden from listing\n")
+	}
+	if _, found := repos[created.Root]; !found {
+		t.Errorf("expected repo %s of public namespace in listing\n", created.Root)
+	}
+	repos = nil
+	if err := json.Unmarshal(TestHTTP(t, "GET", WebAPIPath+"ns/lab1/repos/info", nil), &repos); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := repos[created.Root]; !found || len(repos) != 1 {
+		t.Errorf("expected only repo %s in namespace listing, got %v\n", created.Root, repos)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+	noteReq := fmt.Sprintf("%snode/%s/note", WebAPIPath, uuid)
+	TestHTTP(t, "POST", noteReq, bytes.NewBufferString(`{"note": "not audited"}`))
+
+	SetAuditing(true)
+	defer SetAuditing(false)
+
+	const body = `{"note": "audited"}`
+	TestHTTP(t, "POST", noteReq, bytes.NewBufferString(body))
+	TestHTTP(t, "GET", noteReq, nil)
+	TestBadHTTP(t, "POST", fmt.Sprintf("%snode/%s/note", WebAPIPath, "badbadbad"), bytes.NewBufferString(body))
+
+	var records []datastore.AuditRecord
+	if err := json.Unmarshal(TestHTTP(t, "GET", WebAPIPath+"server/audit", nil), &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 audit records of mutating requests, got %v\n", records)
+	}
+	digest := sha256.Sum256([]byte(body))
+	rec := records[0]
+	if rec.Method != "POST" || rec.Route != "/api/node/"+string(uuid)+"/note" || rec.Repo != uuid || rec.Status != http.StatusOK {
+		t.Errorf("bad audit record: %v\n", rec)
+	}
+	if rec.Digest != "sha256:"+hex.EncodeToString(digest[:]) || rec.Bytes != int64(len(body)) {
+		t.Errorf("bad payload digest %q of %d bytes\n", rec.Digest, rec.Bytes)
+	}
+	if records[1].Status != http.StatusBadRequest || records[1].ID <= rec.ID {
+		t.Errorf("expected later audit record of failed request, got %v\n", records[1])
+	}
+
+	// Page through records of the repo.
+	req := fmt.Sprintf("%sserver/audit?repo=%s&limit=1", WebAPIPath, uuid)
+	resp := TestHTTPResponse(t, "GET", req, nil)
+	if err := json.Unmarshal(resp.Body.Bytes(), &records); err != nil {
+		t.Fatal(err)
+	}
+	cursor := resp.Header().Get(NextCursorHeader)
+	if len(records) != 1 || cursor != fmt.Sprintf("%d", rec.ID) {
+		t.Fatalf("expected first page with record %d, got %v and cursor %q\n", rec.ID, records, cursor)
+	}
+	if err := json.Unmarshal(TestHTTP(t, "GET", req+"&cursor="+cursor, nil), &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected no more audit records of repo, got %v\n", records)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+	TestHTTP(t, "GET", fmt.Sprintf("%snode/%s/note", WebAPIPath, uuid), nil)
+
+	metrics := string(TestHTTP(t, "GET", "/metrics", nil))
+	expected := []string{
+		`dvid_http_requests_total{method="GET",route="/api/node/:uuid/note",code="200"}`,
+		`dvid_http_request_duration_seconds_bucket{method="GET",route="/api/node/:uuid/note",le="+Inf"}`,
+		"# TYPE dvid_handler_tokens_in_use gauge",
+	}
+	for _, s := range expected {
+		if !strings.Contains(metrics, s) {
+			t.Errorf("expected %q in metrics:\n%s\n", s, metrics)
+		}
+	}
+
+	routes := map[string]string{
+		"/api/node/abc123/grayscale/raw/xy/512_256/0_0_100": "/api/node/:uuid/:dataname/raw",
+		"/api/repo/abc123/instance/grayscale":               "/api/repo/:uuid/instance/:dataname",
+		"/api/server/jobs/12":                               "/api/server/jobs/:id",
+		"/index.html":                                       "other",
+	}
+	for path, route := range routes {
+		if got := routeLabel(path); got != route {
+			t.Errorf("expected route %q for %s, got %q\n", route, path, got)
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+	otherUUID, _ := datastore.NewTestRepo()
+
+	server := httptest.NewServer(http.HandlerFunc(ServeSingleHTTP))
+	defer server.Close()
+	resp, err := http.Get(fmt.Sprintf("%s/api/server/events?types=Lock&repo=%s", server.URL, uuid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected event stream, got Content-Type %q\n", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+	if line, err := reader.ReadString('\n'); err != nil || line != "retry: 1000\n" {
+		t.Fatalf("expected retry at start of stream, got %q, %v\n", line, err)
+	}
+
+	// Only the lock of the requested repo should be sent.
+	datastore.PublishEvent(datastore.Event{Type: datastore.EventNewVersion, Repo: uuid, UUID: uuid})
+	datastore.PublishEvent(datastore.Event{Type: datastore.EventLock, Repo: otherUUID, UUID: otherUUID})
+	datastore.PublishEvent(datastore.Event{Type: datastore.EventLock, Repo: uuid, UUID: uuid, Note: "locked"})
+
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error reading event stream: %v\n", err)
+		}
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, ":") {
+			lines = append(lines, line)
+		}
+	}
+	if lines[0] != "id: 1" || lines[1] != "event: Lock" || !strings.HasPrefix(lines[2], "data: ") {
+		t.Fatalf("bad event in stream: %v\n", lines)
+	}
+	var e datastore.Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &e); err != nil {
+		t.Fatalf("bad event JSON %q: %v\n", lines[2], err)
+	}
+	if e.Repo != uuid || e.Note != "locked" {
+		t.Errorf("expected lock event of repo %s, got %v\n", uuid, e)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	for _, path := range []string{"/livez", "/healthz", "/readyz"} {
+		var status healthStatus
+		if err := json.Unmarshal(TestHTTP(t, "GET", path, nil), &status); err != nil {
+			t.Fatalf("bad JSON from %s: %v\n", path, err)
+		}
+		if status.Status != "ok" {
+			t.Errorf("expected %s to be ok, got %v\n", path, status)
+		}
+	}
+
+	status := checkHealth(true)
+	for _, name := range []string{"metadata", "stores", "handlers", "server"} {
+		if check, found := status.Checks[name]; !found || !check.OK {
+			t.Errorf("expected %s check to pass, got %v\n", name, status.Checks)
+		}
+	}
+	if len(status.Checks["stores"].Stores) == 0 {
+		t.Errorf("expected stores to be checked: %v\n", status.Checks["stores"])
+	}
+
+	// Saturated chunk handlers make the server unready but still healthy.
+	var tokens []int
+	for len(HandlerToken) > 0 {
+		tokens = append(tokens, <-HandlerToken)
+	}
+	ready := checkHealth(true)
+	healthy := checkHealth(false)
+	for _, token := range tokens {
+		HandlerToken <- token
+	}
+	if ready.Status != "unavailable" || ready.Checks["handlers"].OK {
+		t.Errorf("expected unready server with all handlers busy, got %v\n", ready)
+	}
+	if healthy.Status != "ok" {
+		t.Errorf("expected healthy server with all handlers busy, got %v\n", healthy)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+	defer setRateLimits(rateLimitConfig{})
+
+	uuid, _ := datastore.NewTestRepo()
+	noteURL := fmt.Sprintf("%snode/%s/note", WebAPIPath, uuid)
+
+	if err := setRateLimits(rateLimitConfig{RequestsPerSec: 0.5, RequestBurst: 2}); err != nil {
+		t.Fatal(err)
+	}
+	TestHTTP(t, "GET", noteURL, nil)
+	TestHTTP(t, "GET", noteURL, nil)
+	resp := TestHTTPResponse(t, "GET", noteURL, nil)
+	if resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 after burst, got %d\n", resp.Code)
+	}
+	if retry := resp.Header().Get("Retry-After"); retry != "2" {
+		t.Errorf("expected Retry-After of 2 seconds, got %q\n", retry)
+	}
+
+	// Exempt clients aren't limited.
+	req, _ := http.NewRequest("GET", noteURL, nil)
+	req.RemoteAddr = "10.0.0.5:4321"
+	if err := setRateLimits(rateLimitConfig{RequestsPerSec: 0.5, RequestBurst: 1, Exempt: []string{"10.0.0.5"}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		ServeSingleHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected exempt client to be allowed, got status %d\n", w.Code)
+		}
+	}
+
+	// Bytes transferred beyond the limit refuse later requests.
+	if err := setRateLimits(rateLimitConfig{BytesPerSec: 1, ByteBurst: 10}); err != nil {
+		t.Fatal(err)
+	}
+	TestHTTP(t, "POST", noteURL, strings.NewReader(`{"note": "a note longer than ten bytes"}`))
+	if resp := TestHTTPResponse(t, "GET", noteURL, nil); resp.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429 after exceeding byte limit, got %d\n", resp.Code)
+	}
+	if err := setRateLimits(rateLimitConfig{RequestsPerSec: -1}); err == nil {
+		t.Errorf("expected error for negative rate limit\n")
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	// Responses without a known length are buffered and cut.
+	uuid, _ := datastore.NewTestRepo()
+	infoURL := fmt.Sprintf("%srepo/%s/info", WebAPIPath, uuid)
+	full := TestHTTP(t, "GET", infoURL, nil)
+	req, _ := http.NewRequest("GET", infoURL, nil)
+	req.Header.Set("Range", "bytes=0-9")
+	w := httptest.NewRecorder()
+	ServeSingleHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != string(full[:10]) {
+		t.Fatalf("expected status 206 with first 10 bytes %q, got %d with %q\n", full[:10], w.Code, w.Body.String())
+	}
+	if cr := w.Header().Get("Content-Range"); cr != fmt.Sprintf("bytes 0-9/%d", len(full)) {
+		t.Errorf("bad Content-Range %q\n", cr)
+	}
+
+	// Responses with a Content-Length are streamed, passing only bytes in range.
+	body := "0123456789abcdefghij"
+	h := rangeHandler(&web.C{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+		for i := 0; i < len(body); i += 3 {
+			end := i + 3
+			if end > len(body) {
+				end = len(body)
+			}
+			w.Write([]byte(body[i:end]))
+		}
+	}))
+	tests := []struct {
+		rangeHeader string
+		status      int
+		expected    string
+	}{
+		{"bytes=4-11", http.StatusPartialContent, body[4:12]},
+		{"bytes=15-", http.StatusPartialContent, body[15:]},
+		{"bytes=-5", http.StatusPartialContent, body[15:]},
+		{"bytes=0-3,8-9", http.StatusOK, body},
+		{"bytes=30-", http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for _, tc := range tests {
+		req, _ := http.NewRequest("GET", "/api/node/abc/data/raw", nil)
+		req.Header.Set("Range", tc.rangeHeader)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("range %q: expected status %d, got %d\n", tc.rangeHeader, tc.status, w.Code)
+		}
+		if tc.status != http.StatusRequestedRangeNotSatisfiable && w.Body.String() != tc.expected {
+			t.Errorf("range %q: expected %q, got %q\n", tc.rangeHeader, tc.expected, w.Body.String())
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+	infoURL := fmt.Sprintf("%srepo/%s/info", WebAPIPath, uuid)
+	full := TestHTTP(t, "GET", infoURL, nil)
+
+	req, _ := http.NewRequest("GET", infoURL, nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	w := httptest.NewRecorder()
+	ServeSingleHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzipped response, got status %d and encoding %q\n", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("bad gzip response: %v\n", err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("bad gzip response: %v\n", err)
+	}
+	if !bytes.Equal(data, full) {
+		t.Errorf("expected decompressed response %q, got %q\n", string(full), string(data))
+	}
+
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"gzip;q=0.5, zstd", "zstd"},
+		{"gzip, zstd", "zstd"},
+		{"gzip, zstd;q=0", "gzip"},
+		{"deflate, br", ""},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if encoding := acceptedEncoding(tc.header); encoding != tc.expected {
+			t.Errorf("expected encoding %q for Accept-Encoding %q, got %q\n", tc.expected, tc.header, encoding)
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	const etag = `"abc-1"`
+	tests := []struct {
+		header  string
+		matches bool
+	}{
+		{`"abc-1"`, true},
+		{`W/"abc-1"`, true},
+		{`"xyz", "abc-1"`, true},
+		{`"abc-1-gzip"`, true},
+		{`"abc-1-zstd"`, true},
+		{`*`, true},
+		{`"abc-2"`, false},
+		{`"abc-1-br"`, false},
+	}
+	for _, tc := range tests {
+		if etagMatches(tc.header, etag) != tc.matches {
+			t.Errorf("expected match %t for If-None-Match %s\n", tc.matches, tc.header)
+		}
+	}
+}
+
+func TestPaging(t *testing.T) {
+	sorted := []string{"a", "b", "c", "d", "e"}
+	tests := []struct {
+		page       Page
+		start, end int
+	}{
+		{Page{}, 0, 5},
+		{Page{Limit: 2}, 0, 2},
+		{Page{Limit: 2, Cursor: "b"}, 2, 4},
+		{Page{Limit: 2, Cursor: "bb"}, 2, 4},
+		{Page{Offset: 1, Cursor: "c"}, 4, 5},
+		{Page{Limit: 10, Offset: 7}, 5, 5},
+	}
+	for _, tc := range tests {
+		if start, end := tc.page.Bounds(sorted); start != tc.start || end != tc.end {
+			t.Errorf("page %v: expected bounds [%d,%d), got [%d,%d)\n", tc.page, tc.start, tc.end, start, end)
+		}
+	}
+
+	item := json.RawMessage(`{"Base":{"Name":"grayscale","TypeName":"uint8blk"},"Extended":{"MinPoint":null}}`)
+	selected, err := selectFields(item, []string{"Base.Name", "Missing"})
+	if err != nil {
+		t.Fatalf("error selecting fields: %v\n", err)
+	}
+	if string(selected) != `{"Base":{"Name":"grayscale"}}` {
+		t.Errorf("bad selected fields: %s\n", selected)
+	}
+
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	for i := 0; i < 3; i++ {
+		datastore.NewTestRepo()
+	}
+	reposURL := fmt.Sprintf("%srepos/info?limit=2&fields=Alias", WebAPIPath)
+	var cursor string
+	var numRepos int
+	for reposURL != "" {
+		req, _ := http.NewRequest("GET", reposURL, nil)
+		w := httptest.NewRecorder()
+		ServeSingleHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("bad status %d for %s: %s\n", w.Code, reposURL, w.Body.String())
+		}
+		var repos map[string]map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &repos); err != nil {
+			t.Fatalf("can't unmarshal repos page: %v\n", err)
+		}
+		for uuid, repo := range repos {
+			if uuid <= cursor {
+				t.Errorf("repo %s returned after cursor %s\n", uuid, cursor)
+			}
+			if _, found := repo["Alias"]; !found || len(repo) != 1 {
+				t.Errorf("expected only Alias field of repo %s, got %v\n", uuid, repo)
+			}
+		}
+		numRepos += len(repos)
+		cursor = w.Header().Get(NextCursorHeader)
+		reposURL = ""
+		if cursor != "" {
+			reposURL = fmt.Sprintf("%srepos/info?limit=2&fields=Alias&cursor=%s", WebAPIPath, cursor)
+		}
+	}
+	if numRepos != 3 {
+		t.Errorf("expected 3 repos over all pages, got %d\n", numRepos)
+	}
+}
+
+func TestInstanceThrottles(t *testing.T) {
+	configs := map[dvid.DataSpecifier]instanceThrottleConfig{
+		"labelblk":         {BytesPerSec: 1000},
+		"tiles:99ef22cd85": {Concurrency: 1, Endpoints: []string{"raw"}},
+	}
+	types, instances, err := parseInstanceThrottles(configs)
+	if err != nil {
+		t.Fatalf("error parsing throttles: %v\n", err)
+	}
+	if types["labelblk"] == nil || types["labelblk"].bytes == nil {
+		t.Errorf("expected bandwidth throttle for labelblk datatype\n")
+	}
+	throttle := instances[dvid.GetDataSpecifier("tiles", "99ef22cd85")]
+	if throttle == nil || throttle.sched == nil {
+		t.Fatalf("expected concurrency throttle for tiles instance\n")
+	}
+	if _, _, err := parseInstanceThrottles(map[dvid.DataSpecifier]instanceThrottleConfig{"labelblk": {}}); err == nil {
+		t.Errorf("expected error for throttle without limits\n")
+	}
+
+	// A second request waits for the first to finish.
+	if err := throttle.acquire(context.Background(), storage.PriorityInteractive, "client"); err != nil {
+		t.Fatalf("error acquiring throttle: %v\n", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := throttle.acquire(ctx, storage.PriorityInteractive, "client"); err == nil {
+		t.Errorf("expected second request to wait past its deadline\n")
+	}
+	throttle.release(0)
+	if err := throttle.acquire(context.Background(), storage.PriorityInteractive, "client"); err != nil {
+		t.Errorf("error acquiring released throttle: %v\n", err)
+	}
+	throttle.release(0)
+}
+
+// timeoutReader returns some bytes and then a timeout error as if the client stalled.
+type timeoutReader struct {
+	sent bool
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	if r.sent {
+		return 0, timeoutError{}
+	}
+	r.sent = true
+	return copy(p, `{"note": `), nil
+}
+
+func TestRequestLimits(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	if err := setRequestLimits(limitsConfig{ReadTimeout: 10, LongTimeout: 5}); err == nil {
+		t.Errorf("expected error for long-running timeout shorter than read timeout\n")
+	}
+	if err := setRequestLimits(limitsConfig{MaxBodyBytes: 20, LongEndpoints: []string{"blocks"}}); err != nil {
+		t.Fatal(err)
+	}
+	defer setRequestLimits(limitsConfig{})
+
+	for path, expected := range map[string]requestClass{
+		"/api/node/abc/grayscale/raw/0_1/512_256":  readRequest,
+		"/api/node/abc/labels/blocks/64_64_64/0_0": longRequest,
+		"/api/repo/abc/export":                     longRequest,
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		if class := limits.class(req); class != expected {
+			t.Errorf("expected class %d for %s, got %d\n", expected, path, class)
+		}
+	}
+
+	uuid, _ := datastore.NewTestRepo()
+	noteReq := fmt.Sprintf("%snode/%s/note", WebAPIPath, uuid)
+	TestHTTP(t, "POST", noteReq, bytes.NewBufferString(`{"note": "small"}`))
+
+	resp := TestHTTPResponse(t, "POST", noteReq, bytes.NewBufferString(`{"note": "too large for the limit"}`))
+	if resp.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for body over maximum size, got %d\n", resp.Code)
+	}
+	// Without a content length, the body is refused once too much is read.
+	resp = TestHTTPResponse(t, "POST", noteReq, ioutil.NopCloser(strings.NewReader(`{"note": "too large for the limit"}`)))
+	if resp.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for streamed body over maximum size, got %d\n", resp.Code)
+	}
+	resp = TestHTTPResponse(t, "POST", noteReq, &timeoutReader{})
+	if resp.Code != http.StatusRequestTimeout {
+		t.Errorf("expected 408 for body not received in time, got %d: %s\n", resp.Code, resp.Body.String())
+	}
+}
+
+// connRecorder is a response recorder whose connection can be hijacked and notifies when
+// it's closed.
+type connRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+	closed   chan bool
+}
+
+func (w *connRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return nil, nil, nil
+}
+
+func (w *connRecorder) CloseNotify() <-chan bool {
+	return w.closed
+}
+
+func TestLimitWriterPassThrough(t *testing.T) {
+	rec := &connRecorder{ResponseRecorder: httptest.NewRecorder(), closed: make(chan bool, 1)}
+	lw := &limitWriter{ResponseWriter: rec, body: &limitedBody{}}
+	if _, _, err := lw.Hijack(); err != nil || !rec.hijacked {
+		t.Errorf("expected hijack to pass through to wrapped writer: %v\n", err)
+	}
+	rec.closed <- true
+	select {
+	case <-lw.CloseNotify():
+	default:
+		t.Errorf("expected close notification from wrapped writer\n")
+	}
+
+	lw = &limitWriter{ResponseWriter: httptest.NewRecorder(), body: &limitedBody{}}
+	if _, _, err := lw.Hijack(); err == nil {
+		t.Errorf("expected error on hijack of writer that doesn't support it\n")
+	}
+	if lw.CloseNotify() == nil {
+		t.Errorf("expected close notification channel for writer that doesn't support it\n")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+	notePath := fmt.Sprintf("/api/node/%s/note", uuid)
+	batch := fmt.Sprintf(`[
+		{"method": "POST", "path": %q, "body": "{\"note\": \"batched\"}"},
+		{"method": "GET", "path": %q},
+		{"method": "POST", "path": "/api/node/badbadbad/note", "body": "{}"}
+	]`, notePath, notePath)
+	var results []batchResponse
+	if err := json.Unmarshal(TestHTTP(t, "POST", WebAPIPath+"batch", strings.NewReader(batch)), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[0].Status != http.StatusOK || results[2].Status != http.StatusBadRequest {
+		t.Fatalf("bad batch responses: %v\n", results)
+	}
+	if results[1].Status != http.StatusOK || !strings.Contains(results[1].Body, "batched") {
+		t.Errorf("expected note written by earlier sub-request, got %v\n", results[1])
+	}
+
+	// An atomic batch can't write metadata of nodes.
+	resp := TestHTTPResponse(t, "POST", WebAPIPath+"batch?atomic=true", strings.NewReader(batch))
+	if resp.Code != http.StatusNotImplemented {
+		t.Fatalf("expected status 501 for atomic batch with node write, got %d\n", resp.Code)
+	}
+
+	// An atomic batch stops at the failed sub-request.
+	batch = fmt.Sprintf(`[
+		{"method": "GET", "path": %q},
+		{"method": "GET", "path": "/api/node/badbadbad/note"},
+		{"method": "GET", "path": %q}
+	]`, notePath, notePath)
+	resp = TestHTTPResponse(t, "POST", WebAPIPath+"batch?atomic=true", strings.NewReader(batch))
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for failed atomic batch, got %d\n", resp.Code)
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &results); err != nil || len(results) != 2 {
+		t.Fatalf("expected responses up to failed sub-request, got %v: %v\n", results, err)
+	}
+	if !instanceRequest("/api/node/3f8c/annotations/key/a") || instanceRequest("/api/node/3f8c/commit") {
+		t.Errorf("bad detection of data instance endpoints\n")
+	}
+	TestBadHTTP(t, "POST", WebAPIPath+"batch", strings.NewReader(`[{"method": "POST", "path": "/api/batch"}]`))
+
+	// Multipart batches have raw HTTP requests and responses as parts.
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/http"}, "Content-ID": {"note-1"}})
+	fmt.Fprintf(part, "GET %s HTTP/1.1\r\nHost: dvid\r\n\r\n", notePath)
+	mw.Close()
+	req, _ := http.NewRequest("POST", WebAPIPath+"batch", &body)
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w := httptest.NewRecorder()
+	ServeSingleHTTP(w, req)
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if w.Code != http.StatusOK || err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("bad multipart batch response (%d) %q: %s\n", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	respPart, err := multipart.NewReader(w.Body, params["boundary"]).NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if respPart.Header.Get("Content-ID") != "note-1" {
+		t.Errorf("expected Content-ID of request part, got %q\n", respPart.Header.Get("Content-ID"))
+	}
+	noteResp, err := http.ReadResponse(bufio.NewReader(respPart), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note, _ := ioutil.ReadAll(noteResp.Body)
+	if noteResp.StatusCode != http.StatusOK || !strings.Contains(string(note), "batched") {
+		t.Errorf("bad multipart sub-request response (%d): %s\n", noteResp.StatusCode, note)
+	}
+}
+
+func TestSpec(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	var spec openAPISpec
+	if err := json.Unmarshal(TestHTTP(t, "GET", WebAPIPath+"spec", nil), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.OpenAPI != openAPIVersion {
+		t.Errorf("expected OpenAPI version %s, got %q\n", openAPIVersion, spec.OpenAPI)
+	}
+	op, found := spec.Paths["/api/node/{uuid}/note"]["post"]
+	if !found {
+		t.Fatalf("expected POST of node note in spec paths: %v\n", spec.Paths)
+	}
+	if len(op.Parameters) != 1 || op.Parameters[0].Name != "uuid" || op.Parameters[0].In != "path" || op.RequestBody == nil {
+		t.Errorf("bad operation for POST of node note: %v\n", op)
+	}
+	op, found = spec.Paths["/api/server/audit"]["get"]
+	if !found || len(op.Parameters) != 7 || op.Tags[0] != "server" {
+		t.Errorf("bad operation for GET of audit log: %v\n", op)
+	}
+	if _, found := spec.Paths["/api/spec"]["get"]; !found {
+		t.Errorf("expected spec to describe itself\n")
+	}
+	TestBadHTTP(t, "GET", WebAPIPath+"spec?type=nosuchtype", nil)
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		params        string
+	}{
+		{"/api/server/info", "/api/server/info", ""},
+		{"/api/node/:uuid/:dataname/key/:key", "/api/node/{uuid}/{dataname}/key/{key}", "uuid,dataname,key"},
+		{"/api/node/:uuid/:dataname/:endpoint/*", "/api/node/{uuid}/{dataname}/{endpoint}/{path}", "uuid,dataname,endpoint,path"},
+	}
+	for _, test := range tests {
+		path, params := openAPIPath(test.pattern)
+		if path != test.path || strings.Join(params, ",") != test.params {
+			t.Errorf("expected %q with params %q for %q, got %q with %v\n", test.path, test.params, test.pattern, path, params)
+		}
+	}
+}
@@ -0,0 +1,558 @@
+// +build azblob
+
+/*
+	Package azblob implements a storage engine for Azure Blob Storage.  Each key-value pair
+	is stored as a single block blob whose name is the hex encoding of the full key, so
+	lexicographic listing of blob names preserves DVID key order.
+
+	Like the s3 engine, it is intended for block data while metadata stays in a local
+	ordered key-value store.  It satisfies the KeyValueGetter, KeyValueSetter, and
+	KeyValueIngestable interfaces but does not provide range queries.
+
+	Authentication is selected with the "auth" store setting:
+
+		"sas"              A shared access signature given by "sastoken".
+		"sharedkey"        The storage account key given by "accountkey" or, if absent,
+		                   the AZURE_STORAGE_KEY environment variable.
+		"managedidentity"  An Azure managed identity.  A user-assigned identity can be
+		                   selected via "clientid"; otherwise the system-assigned identity
+		                   is used.  This is the default.
+*/
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	api "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in azblob: %v\n", err)
+	}
+	e := Engine{"azblob", "Azure Blob Storage", ver}
+	storage.RegisterEngine(e)
+}
+
+const (
+	// INITKEY is the name of the blob written to mark an initialized store.
+	INITKEY = "initialized"
+)
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return true
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns an Azure Blob container store.  The passed Config must contain:
+// "account": name of the storage account
+// "container": name of an existing container
+// Optional settings:
+// "auth": "sas", "sharedkey", or "managedidentity" (default)
+// "sastoken", "accountkey", "clientid": credentials for the chosen auth method
+// "prefix": blob name prefix so multiple stores can share a container
+// "endpoint": service URL, e.g., for the Azurite emulator
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newBlobStore(config)
+}
+
+func stringSetting(c map[string]interface{}, key string) (string, error) {
+	v, found := c[key]
+	if !found {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%q setting must be a string (%v)", key, v)
+	}
+	return s, nil
+}
+
+type blobConfig struct {
+	account    string
+	container  string
+	prefix     string
+	endpoint   string
+	auth       string
+	sasToken   string
+	accountKey string
+	clientID   string
+}
+
+func parseConfig(config dvid.StoreConfig) (*blobConfig, error) {
+	c := config.GetAll()
+	bc := new(blobConfig)
+	settings := map[string]*string{
+		"account":    &bc.account,
+		"container":  &bc.container,
+		"prefix":     &bc.prefix,
+		"endpoint":   &bc.endpoint,
+		"auth":       &bc.auth,
+		"sastoken":   &bc.sasToken,
+		"accountkey": &bc.accountKey,
+		"clientid":   &bc.clientID,
+	}
+	for name, dest := range settings {
+		s, err := stringSetting(c, name)
+		if err != nil {
+			return nil, err
+		}
+		*dest = s
+	}
+	if bc.container == "" {
+		return nil, fmt.Errorf("%q must be specified for azblob configuration", "container")
+	}
+	if bc.endpoint == "" {
+		if bc.account == "" {
+			return nil, fmt.Errorf("%q or %q must be specified for azblob configuration", "account", "endpoint")
+		}
+		bc.endpoint = fmt.Sprintf("https://%s.blob.core.windows.net/", bc.account)
+	}
+	if bc.auth == "" {
+		bc.auth = "managedidentity"
+	}
+	switch bc.auth {
+	case "sas":
+		if bc.sasToken == "" {
+			return nil, fmt.Errorf("azblob %q auth requires %q setting", "sas", "sastoken")
+		}
+	case "sharedkey":
+		if bc.accountKey == "" {
+			bc.accountKey = os.Getenv("AZURE_STORAGE_KEY")
+		}
+		if bc.account == "" || bc.accountKey == "" {
+			return nil, fmt.Errorf("azblob %q auth requires %q and %q (or AZURE_STORAGE_KEY)", "sharedkey", "account", "accountkey")
+		}
+	case "managedidentity":
+	default:
+		return nil, fmt.Errorf("unknown azblob auth %q: must be sas, sharedkey, or managedidentity", bc.auth)
+	}
+	return bc, nil
+}
+
+// newClient returns a blob service client using the configured authentication.
+func (bc *blobConfig) newClient() (*api.Client, error) {
+	switch bc.auth {
+	case "sas":
+		url := strings.TrimSuffix(bc.endpoint, "/") + "/?" + strings.TrimPrefix(bc.sasToken, "?")
+		return api.NewClientWithNoCredential(url, nil)
+	case "sharedkey":
+		cred, err := api.NewSharedKeyCredential(bc.account, bc.accountKey)
+		if err != nil {
+			return nil, err
+		}
+		return api.NewClientWithSharedKeyCredential(bc.endpoint, cred, nil)
+	default:
+		var opts azidentity.ManagedIdentityCredentialOptions
+		if bc.clientID != "" {
+			opts.ID = azidentity.ClientID(bc.clientID)
+		}
+		cred, err := azidentity.NewManagedIdentityCredential(&opts)
+		if err != nil {
+			return nil, err
+		}
+		return api.NewClient(bc.endpoint, cred, nil)
+	}
+}
+
+// newBlobStore sets up a client for the configured container, which must already exist.
+func (e Engine) newBlobStore(config dvid.StoreConfig) (*BlobStore, bool, error) {
+	bc, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+	client, err := bc.newClient()
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to create Azure blob client for container %q: %v", bc.container, err)
+	}
+	db := &BlobStore{
+		config: bc,
+		client: client,
+		ctx:    context.Background(),
+	}
+
+	// container must already exist -- check existence
+	container := client.ServiceClient().NewContainerClient(bc.container)
+	if _, err := container.GetProperties(db.ctx, nil); err != nil {
+		return nil, false, fmt.Errorf("unable to access %s: %v", db, err)
+	}
+
+	// mark as initialized if this is a new store.
+	val, err := db.getBlob(bc.prefix + INITKEY)
+	if err != nil {
+		return nil, false, err
+	}
+	var created bool
+	if val == nil {
+		created = true
+		if err := db.putBlob(bc.prefix+INITKEY, []byte(e.semver.String())); err != nil {
+			return nil, false, err
+		}
+	}
+	dvid.Infof("Opened %s using %s auth\n", db, bc.auth)
+	return db, created, nil
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets azblob as the default key-value backend, using the container given by
+// the DVID_TEST_AZBLOB_CONTAINER environment variable.  The account, endpoint, e.g., of
+// an Azurite emulator, auth, and SAS token are given by DVID_TEST_AZBLOB_ACCOUNT,
+// DVID_TEST_AZBLOB_ENDPOINT, DVID_TEST_AZBLOB_AUTH, and DVID_TEST_AZBLOB_SASTOKEN.  Each
+// test store uses a new prefix in the container.  The metadata isn't set since it
+// requires range queries.  If another engine is already set, it returns an error since
+// only one key-value backend should be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("azblob can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	container := os.Getenv("DVID_TEST_AZBLOB_CONTAINER")
+	if container == "" {
+		return fmt.Errorf("azblob can't be testable key-value without a container in DVID_TEST_AZBLOB_CONTAINER")
+	}
+	alias := storage.Alias("azblob")
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"container": container,
+		"account":   os.Getenv("DVID_TEST_AZBLOB_ACCOUNT"),
+		"endpoint":  os.Getenv("DVID_TEST_AZBLOB_ENDPOINT"),
+		"auth":      os.Getenv("DVID_TEST_AZBLOB_AUTH"),
+		"sastoken":  os.Getenv("DVID_TEST_AZBLOB_SASTOKEN"),
+		"prefix":    fmt.Sprintf("dvid-test-%x/", uuid.NewV4().Bytes()),
+		"testing":   true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "azblob"}
+	return nil
+}
+
+// Delete removes the blobs of a test store, whose prefix is only used by the test.  It's
+// a no-op for other stores since containers are not deleted via DVID.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	testing, _, err := config.GetBool("testing")
+	if err != nil || !testing {
+		return err
+	}
+	db, _, err := e.newBlobStore(config)
+	if err != nil {
+		return err
+	}
+	prefix := db.config.prefix
+	pager := db.client.NewListBlobsFlatPager(db.config.container, &api.ListBlobsFlatOptions{Prefix: &prefix})
+	for pager.More() {
+		resp, err := pager.NextPage(db.ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range resp.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+			if err := db.deleteBlob(*item.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// BlobStore is a key-value store backed by an Azure Blob container.
+type BlobStore struct {
+	config *blobConfig
+	client *api.Client
+	ctx    context.Context
+}
+
+func (db *BlobStore) String() string {
+	return fmt.Sprintf("azure blob container %q @ %s", db.config.container, db.config.endpoint)
+}
+
+// Close is a no-op since blob clients hold no persistent connection.
+func (db *BlobStore) Close() {}
+
+// Equal returns true if the blob store matches the given store configuration.
+func (db *BlobStore) Equal(config dvid.StoreConfig) bool {
+	other, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.config.endpoint == other.endpoint && db.config.container == other.container &&
+		db.config.prefix == other.prefix
+}
+
+// blobName returns the blob name for a full key.
+func (db *BlobStore) blobName(k storage.Key) string {
+	return db.config.prefix + hex.EncodeToString(k)
+}
+
+// keyFromBlob returns the full key for a listed blob name.
+func (db *BlobStore) keyFromBlob(name string) (storage.Key, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(name, db.config.prefix))
+	if err != nil {
+		return nil, fmt.Errorf("bad blob name %q in %s: %v", name, db, err)
+	}
+	return storage.Key(b), nil
+}
+
+// getBlob returns the value of a blob or nil if it does not exist.
+func (db *BlobStore) getBlob(name string) ([]byte, error) {
+	resp, err := db.client.DownloadStream(db.ctx, db.config.container, name, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// blobExists returns true if the named blob exists, using its properties so the blob
+// content isn't downloaded.
+func (db *BlobStore) blobExists(name string) (bool, error) {
+	blob := db.client.ServiceClient().NewContainerClient(db.config.container).NewBlobClient(name)
+	if _, err := blob.GetProperties(db.ctx, nil); err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (db *BlobStore) putBlob(name string, value []byte) error {
+	_, err := db.client.UploadBuffer(db.ctx, db.config.container, name, value, nil)
+	return err
+}
+
+// deleteBlob deletes a blob.  Deleting a missing blob is not an error.
+func (db *BlobStore) deleteBlob(name string) error {
+	_, err := db.client.DeleteBlob(db.ctx, db.config.container, name, nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return err
+	}
+	return nil
+}
+
+// getSingleKeyVersions returns all stored versions of a key, including tombstones.
+// Values are not fetched.
+func (db *BlobStore) getSingleKeyVersions(vctx storage.VersionedCtx, tk storage.TKey) ([]*storage.KeyValue, error) {
+	unvKey, _, err := vctx.UnversionedKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	prefix := db.blobName(unvKey)
+	pager := db.client.NewListBlobsFlatPager(db.config.container, &api.ListBlobsFlatOptions{Prefix: &prefix})
+
+	var kvs []*storage.KeyValue
+	for pager.More() {
+		resp, err := pager.NextPage(db.ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range resp.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+			k, err := db.keyFromBlob(*item.Name)
+			if err != nil {
+				return nil, err
+			}
+			// The prefix also matches longer type-specific keys, so make sure this is the same TKey.
+			itTKey, err := storage.TKeyFromKey(k)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(itTKey, tk) {
+				kvs = append(kvs, &storage.KeyValue{K: k})
+			}
+		}
+	}
+	return kvs, nil
+}
+
+// ---- KeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *BlobStore) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call Get() on nil Azure blob store")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	key := ctx.ConstructKey(tk)
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		kvs, err := db.getSingleKeyVersions(vctx, tk)
+		if err != nil {
+			return nil, err
+		}
+		if len(kvs) == 0 {
+			return nil, nil
+		}
+		kv, err := vctx.VersionedKeyValue(kvs)
+		if err != nil || kv == nil {
+			return nil, err
+		}
+		key = kv.K
+	}
+	val, err := db.getBlob(db.blobName(key))
+	if err != nil {
+		return nil, err
+	}
+	storage.StoreValueBytesRead <- len(val)
+	return val, nil
+}
+
+// Exists returns true if a value is stored for the key without downloading the blob.
+func (db *BlobStore) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists() on nil Azure blob store")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		kvs, err := db.getSingleKeyVersions(vctx, tk)
+		if err != nil || len(kvs) == 0 {
+			return false, err
+		}
+		kv, err := vctx.VersionedKeyValue(kvs)
+		return kv != nil, err
+	}
+	return db.blobExists(db.blobName(ctx.ConstructKey(tk)))
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key in a possibly versioned context.
+func (db *BlobStore) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put() on nil Azure blob store")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return fmt.Errorf("Bad Put(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		if err := db.deleteBlob(db.blobName(vctx.TombstoneKey(tk))); err != nil {
+			return err
+		}
+	}
+	key := ctx.ConstructKey(tk)
+	if err := db.putBlob(db.blobName(key), v); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete deletes a key-value pair so that subsequent Get on the key returns nil.
+// For versioned contexts, a tombstone is written for the current version.
+func (db *BlobStore) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete() on nil Azure blob store")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return fmt.Errorf("Bad Delete(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		if err := db.putBlob(db.blobName(vctx.TombstoneKey(tk)), dvid.EmptyValue()); err != nil {
+			return err
+		}
+	}
+	return db.deleteBlob(db.blobName(ctx.ConstructKey(tk)))
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+func (db *BlobStore) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut() on nil Azure blob store")
+	}
+	if err := db.putBlob(db.blobName(k), v); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.
+func (db *BlobStore) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete() on nil Azure blob store")
+	}
+	return db.deleteBlob(db.blobName(k))
+}
+
+// ---- KeyValueIngestable interface ------
+
+// KeyValueIngest writes a key-value pair without tombstone bookkeeping, which
+// suffices for bulk loads of immutable data.
+func (db *BlobStore) KeyValueIngest(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call KeyValueIngest() on nil Azure blob store")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in KeyValueIngest()")
+	}
+	return db.RawPut(ctx.ConstructKey(tk), v)
+}
@@ -0,0 +1,82 @@
+// +build azblob
+
+package azblob
+
+import (
+	"os"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func testConfig(c map[string]interface{}) dvid.StoreConfig {
+	var config dvid.Config
+	config.SetAll(c)
+	return dvid.StoreConfig{Config: config, Engine: "azblob"}
+}
+
+func TestAzureBlobAuthConfig(t *testing.T) {
+	os.Unsetenv("AZURE_STORAGE_KEY")
+	tests := []struct {
+		config   map[string]interface{}
+		auth     string
+		endpoint string
+	}{
+		{
+			config:   map[string]interface{}{"account": "acct", "container": "c"},
+			auth:     "managedidentity",
+			endpoint: "https://acct.blob.core.windows.net/",
+		},
+		{
+			config:   map[string]interface{}{"endpoint": "http://127.0.0.1:10000/devstoreaccount1", "container": "c", "auth": "sas", "sastoken": "?sv=1&sig=x"},
+			auth:     "sas",
+			endpoint: "http://127.0.0.1:10000/devstoreaccount1",
+		},
+		{
+			config:   map[string]interface{}{"account": "acct", "container": "c", "auth": "sharedkey", "accountkey": "a2V5"},
+			auth:     "sharedkey",
+			endpoint: "https://acct.blob.core.windows.net/",
+		},
+	}
+	for _, test := range tests {
+		bc, err := parseConfig(testConfig(test.config))
+		if err != nil {
+			t.Errorf("error parsing configuration %v: %v\n", test.config, err)
+			continue
+		}
+		if bc.auth != test.auth || bc.endpoint != test.endpoint {
+			t.Errorf("expected %s auth @ %s for configuration %v, got %s auth @ %s\n", test.auth, test.endpoint, test.config, bc.auth, bc.endpoint)
+		}
+		if test.auth != "managedidentity" {
+			if _, err := bc.newClient(); err != nil {
+				t.Errorf("unable to create client for %s auth: %v\n", test.auth, err)
+			}
+		}
+	}
+
+	bad := []map[string]interface{}{
+		{"account": "acct"},
+		{"container": "c"},
+		{"account": "acct", "container": 3},
+		{"account": "acct", "container": "c", "auth": "sas"},
+		{"account": "acct", "container": "c", "auth": "sharedkey"},
+		{"endpoint": "http://127.0.0.1:10000/", "container": "c", "auth": "sharedkey", "accountkey": "a2V5"},
+		{"account": "acct", "container": "c", "auth": "password"},
+	}
+	for _, c := range bad {
+		if _, err := parseConfig(testConfig(c)); err == nil {
+			t.Errorf("expected error parsing configuration %v\n", c)
+		}
+	}
+
+	// Shared keys can be given by the environment.
+	os.Setenv("AZURE_STORAGE_KEY", "a2V5")
+	defer os.Unsetenv("AZURE_STORAGE_KEY")
+	bc, err := parseConfig(testConfig(map[string]interface{}{"account": "acct", "container": "c", "auth": "sharedkey"}))
+	if err != nil {
+		t.Fatalf("error parsing shared key configuration with key in environment: %v\n", err)
+	}
+	if bc.accountKey != "a2V5" {
+		t.Errorf("expected account key from environment, got %q\n", bc.accountKey)
+	}
+}
@@ -0,0 +1,1119 @@
+// +build badger
+
+/*
+	Package badger implements a pure Go storage engine using dgraph-io's Badger
+	key-value store.  Unlike basholeveldb, it requires no cgo, so DVID can be
+	built as a static binary without the leveldb dependencies.
+*/
+package badger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	api "github.com/dgraph-io/badger"
+)
+
+const (
+	// DefaultSync determines whether writes are synced to disk before returning.
+	// As with basholeveldb, a process crash will not lose writes even without sync.
+	DefaultSync = false
+
+	// DefaultGCInterval is the time between attempts to garbage collect the value log.
+	DefaultGCInterval = 10 * time.Minute
+
+	// DefaultGCDiscardRatio is the fraction of a value log file that must be
+	// discardable before the file is rewritten.
+	DefaultGCDiscardRatio = 0.5
+
+	// number of operations written per badger transaction when batching deletes.
+	batchSize = 10000
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in badger: %v\n", err)
+	}
+	e := Engine{"badger", "Badger pure Go key-value store", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return false
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a badger store. The passed Config must contain "path" string.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newBadgerDB(config)
+}
+
+func parseConfig(config dvid.StoreConfig) (path string, testing bool, err error) {
+	c := config.GetAll()
+
+	v, found := c["path"]
+	if !found {
+		err = fmt.Errorf("%q must be specified for badger configuration", "path")
+		return
+	}
+	var ok bool
+	path, ok = v.(string)
+	if !ok {
+		err = fmt.Errorf("%q setting must be a string (%v)", "path", v)
+		return
+	}
+	v, found = c["testing"]
+	if found {
+		testing, ok = v.(bool)
+		if !ok {
+			err = fmt.Errorf("%q setting must be a bool (%v)", "testing", v)
+			return
+		}
+	}
+	if testing {
+		path = filepath.Join(os.TempDir(), path)
+	}
+	return
+}
+
+func getOptions(path string, config dvid.StoreConfig) (api.Options, error) {
+	opts := api.DefaultOptions(path)
+	opts.SyncWrites = DefaultSync
+
+	c := config.GetAll()
+	if v, found := c["syncwrites"]; found {
+		sync, ok := v.(bool)
+		if !ok {
+			return opts, fmt.Errorf("%q setting must be a bool (%v)", "syncwrites", v)
+		}
+		opts.SyncWrites = sync
+	}
+	if v, found := c["valuelogfilesize"]; found {
+		mb, ok := v.(int64)
+		if !ok {
+			return opts, fmt.Errorf("%q setting must be an integer in MB (%v)", "valuelogfilesize", v)
+		}
+		opts.ValueLogFileSize = mb * dvid.Mega
+	}
+	return opts, nil
+}
+
+// newBadgerDB returns a badger backend, creating the database
+// at the path if it doesn't already exist.
+func (e Engine) newBadgerDB(config dvid.StoreConfig) (*BadgerDB, bool, error) {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Is there a database already at this path?  If not, create.
+	var created bool
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		dvid.Infof("Database not already at path (%s). Creating directory...\n", path)
+		created = true
+		if err := os.MkdirAll(path, 0744); err != nil {
+			return nil, true, fmt.Errorf("Can't make directory at %s: %v", path, err)
+		}
+	} else {
+		dvid.Infof("Found directory at %s (err = %v)\n", path, err)
+	}
+
+	opts, err := getOptions(path, config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	dvid.Infof("Opening badger @ path %s\n", path)
+	bdb, err := api.Open(opts)
+	if err != nil {
+		return nil, false, err
+	}
+	db := &BadgerDB{
+		directory: path,
+		config:    config,
+		bdb:       bdb,
+		stopGC:    make(chan struct{}),
+	}
+	db.gcDone.Add(1)
+	go db.runValueLogGC()
+
+	// if we know it's newly created, just return.
+	if created {
+		return db, created, nil
+	}
+
+	// otherwise, check if there's been any metadata or we need to initialize it.
+	metadataExists, err := db.metadataExists()
+	if err != nil {
+		db.Close()
+		return nil, false, err
+	}
+	return db, !metadataExists, nil
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets badger as the default key-value backend.  If another
+// engine is already set, it returns an error since only one key-value backend should
+// be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("badger can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	if backend.Metadata != "" {
+		return fmt.Errorf("badger can't be testable key-value.  Metadata already set to %s", backend.Metadata)
+	}
+	alias := storage.Alias("badger")
+	backend.Metadata = alias
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"path":    fmt.Sprintf("dvid-test-badger-%x", uuid.NewV4().Bytes()),
+		"testing": true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "badger"}
+	return nil
+}
+
+// Delete implements the TestableEngine interface by providing a way to dispose
+// of testing databases.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Delete the directory if it exists
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("Can't delete old datastore %q: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// --- The Badger implementation must satisfy a Engine interface ----
+
+type BadgerDB struct {
+	// Directory of datastore
+	directory string
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+
+	bdb *api.DB
+
+	// value log garbage collection
+	stopGC chan struct{}
+	gcDone sync.WaitGroup
+}
+
+func (db *BadgerDB) String() string {
+	return fmt.Sprintf("badger @ %s", db.directory)
+}
+
+// runValueLogGC periodically reclaims space in the value log until the store is closed.
+func (db *BadgerDB) runValueLogGC() {
+	defer db.gcDone.Done()
+	ticker := time.NewTicker(DefaultGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stopGC:
+			return
+		case <-ticker.C:
+			// Keep rewriting files until badger reports nothing left to collect.
+			for db.bdb.RunValueLogGC(DefaultGCDiscardRatio) == nil {
+			}
+		}
+	}
+}
+
+// Close stops background garbage collection and closes the badger database.
+func (db *BadgerDB) Close() {
+	if db != nil && db.bdb != nil {
+		close(db.stopGC)
+		db.gcDone.Wait()
+		if err := db.bdb.Close(); err != nil {
+			dvid.Errorf("Error closing %s: %v\n", db, err)
+		}
+		db.bdb = nil
+	}
+}
+
+// Equal returns true if the badger db matches the given store configuration.
+func (db *BadgerDB) Equal(config dvid.StoreConfig) bool {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.directory == path
+}
+
+func (db *BadgerDB) metadataExists() (bool, error) {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	var found bool
+	err := db.bdb.View(func(txn *api.Txn) error {
+		opts := api.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		it.Seek(keyBeg)
+		if it.Valid() && bytes.Compare(it.Item().Key(), keyEnd) <= 0 {
+			found = true
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		dvid.Infof("No metadata found for %s...\n", db)
+	}
+	return found, nil
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *BadgerDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GET on nil BadgerDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+
+		// Get all versions of this key and return the most recent
+		values, err := db.getSingleKeyVersions(vctx, tk, false)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			return kv.V, err
+		}
+		return nil, err
+	}
+	key := ctx.ConstructKey(tk)
+	var v []byte
+	err := db.bdb.View(func(txn *api.Txn) error {
+		item, err := txn.Get(key)
+		if err == api.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v, err = item.ValueCopy(nil)
+		return err
+	})
+	storage.StoreValueBytesRead <- len(v)
+	return v, err
+}
+
+// Exists returns true if a value is stored for the key.  Missing keys are usually rejected
+// by the bloom filters, and values aren't read from the value log.
+func (db *BadgerDB) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists on nil BadgerDB")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		versions, err := db.getSingleKeyVersions(vctx, tk, true)
+		if err != nil {
+			return false, err
+		}
+		kv, err := vctx.VersionedKeyValue(versions)
+		return kv != nil, err
+	}
+	var found bool
+	err := db.bdb.View(func(txn *api.Txn) error {
+		_, err := txn.Get(ctx.ConstructKey(tk))
+		if err == api.ErrKeyNotFound {
+			return nil
+		}
+		found = err == nil
+		return err
+	})
+	return found, err
+}
+
+// getSingleKeyVersions returns all versions of a key, without values if keysOnly is true.
+// These key-value pairs will be sorted in ascending key order and could include a tombstone
+// key.
+func (db *BadgerDB) getSingleKeyVersions(vctx storage.VersionedCtx, tk []byte, keysOnly bool) ([]*storage.KeyValue, error) {
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []*storage.KeyValue{}
+	err = db.bdb.View(func(txn *api.Txn) error {
+		opts := api.DefaultIteratorOptions
+		opts.PrefetchValues = !keysOnly
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(begKey); it.Valid(); it.Next() {
+			item := it.Item()
+			itKey := item.KeyCopy(nil)
+			storage.StoreKeyBytesRead <- len(itKey)
+			if bytes.Compare(itKey, endKey) > 0 {
+				return nil
+			}
+			if keysOnly {
+				values = append(values, &storage.KeyValue{K: itKey})
+				continue
+			}
+			itValue, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			storage.StoreValueBytesRead <- len(itValue)
+			values = append(values, &storage.KeyValue{K: itKey, V: itValue})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+type errorableKV struct {
+	*storage.KeyValue
+	error
+}
+
+func sendKV(vctx storage.VersionedCtx, values []*storage.KeyValue, ch chan errorableKV) {
+	if len(values) != 0 {
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil {
+			ch <- errorableKV{nil, err}
+			return
+		}
+		if kv != nil {
+			ch <- errorableKV{kv, nil}
+		}
+	}
+}
+
+// versionedRange sends a range of key-value pairs for a particular version down a channel.
+func (db *BadgerDB) versionedRange(vctx storage.VersionedCtx, begTKey, endTKey storage.TKey, ch chan errorableKV, done <-chan struct{}, keysOnly bool) {
+	minKey, err := vctx.MinVersionKey(begTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxKey, err := vctx.MaxVersionKey(endTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(begTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+
+	values := []*storage.KeyValue{}
+	err = db.bdb.View(func(txn *api.Txn) error {
+		opts := api.DefaultIteratorOptions
+		opts.PrefetchValues = !keysOnly
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(minKey); it.Valid(); it.Next() {
+			select {
+			case <-done: // only happens if we don't care about rest of data.
+				values = nil
+				return nil
+			default:
+			}
+			item := it.Item()
+			itKey := item.KeyCopy(nil)
+			storage.StoreKeyBytesRead <- len(itKey)
+			var itValue []byte
+			if !keysOnly {
+				var err error
+				if itValue, err = item.ValueCopy(nil); err != nil {
+					return err
+				}
+				storage.StoreValueBytesRead <- len(itValue)
+			}
+
+			// Did we pass all versions for last key read?
+			if bytes.Compare(itKey, maxVersionKey) > 0 {
+				indexBytes, err := storage.TKeyFromKey(itKey)
+				if err != nil {
+					return err
+				}
+				maxVersionKey, err = vctx.MaxVersionKey(indexBytes)
+				if err != nil {
+					return err
+				}
+				sendKV(vctx, values, ch)
+				values = []*storage.KeyValue{}
+			}
+			// Did we pass the final key?
+			if bytes.Compare(itKey, maxKey) > 0 {
+				break
+			}
+			values = append(values, &storage.KeyValue{K: itKey, V: itValue})
+		}
+		return nil
+	})
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	sendKV(vctx, values, ch)
+	ch <- errorableKV{nil, nil}
+}
+
+// unversionedRange sends a range of key-value pairs down a channel.
+func (db *BadgerDB) unversionedRange(ctx storage.Context, begTKey, endTKey storage.TKey, ch chan errorableKV, done <-chan struct{}, keysOnly bool) {
+	begKey := ctx.ConstructKey(begTKey)
+	endKey := ctx.ConstructKey(endTKey)
+
+	err := db.bdb.View(func(txn *api.Txn) error {
+		opts := api.DefaultIteratorOptions
+		opts.PrefetchValues = !keysOnly
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(begKey); it.Valid(); it.Next() {
+			item := it.Item()
+			itKey := item.KeyCopy(nil)
+			storage.StoreKeyBytesRead <- len(itKey)
+			// Did we pass the final key?
+			if bytes.Compare(itKey, endKey) > 0 {
+				return nil
+			}
+			var itValue []byte
+			if !keysOnly {
+				var err error
+				if itValue, err = item.ValueCopy(nil); err != nil {
+					return err
+				}
+				storage.StoreValueBytesRead <- len(itValue)
+			}
+			select {
+			case <-done:
+				return nil
+			case ch <- errorableKV{&storage.KeyValue{K: itKey, V: itValue}, nil}:
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		ch <- errorableKV{nil, err}
+	} else {
+		ch <- errorableKV{nil, nil}
+	}
+}
+
+// rangeQuery launches a possibly versioned range query in a goroutine.
+func (db *BadgerDB) rangeQuery(ctx storage.Context, kStart, kEnd storage.TKey, done <-chan struct{}, keysOnly bool) chan errorableKV {
+	ch := make(chan errorableKV)
+	go func() {
+		if !ctx.Versioned() {
+			db.unversionedRange(ctx, kStart, kEnd, ch, done, keysOnly)
+		} else {
+			db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, keysOnly)
+		}
+	}()
+	return ch
+}
+
+// drain consumes any remaining results so the range goroutine can exit.
+func drain(ch chan errorableKV) {
+	go func() {
+		for result := range ch {
+			if result.KeyValue == nil {
+				return
+			}
+		}
+	}()
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *BadgerDB) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil BadgerDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	// Consume the keys.
+	values := []storage.TKey{}
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			return values, result.error
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return nil, err
+		}
+		values = append(values, tk)
+	}
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *BadgerDB) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, kch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil BadgerDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	// Consume the keys.
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			kch <- nil
+			return result.error
+		}
+		kch <- result.KeyValue.K
+	}
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *BadgerDB) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil BadgerDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, false)
+
+	// Consume the key-value pairs.
+	values := []*storage.TKeyValue{}
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return nil, result.error
+			}
+			return values, nil
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return nil, err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: result.KeyValue.V})
+	}
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *BadgerDB) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil BadgerDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	f = storage.CancelableChunkFunc(ctx, f)
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, false)
+
+	// Consume the key-value pairs.
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			return result.error
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return err
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tkv := storage.TKeyValue{K: tk, V: result.KeyValue.V}
+		chunk := &storage.Chunk{ChunkOp: op, TKeyValue: &tkv}
+		if err := f(chunk); err != nil {
+			drain(ch)
+			return err
+		}
+	}
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (db *BadgerDB) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil BadgerDB")
+	}
+	var cancelled bool
+	err := db.bdb.View(func(txn *api.Txn) error {
+		opts := api.DefaultIteratorOptions
+		opts.PrefetchValues = !keysOnly
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(kStart); it.Valid(); it.Next() {
+			item := it.Item()
+			itKey := item.KeyCopy(nil)
+			storage.StoreKeyBytesRead <- len(itKey)
+			// Did we pass the final key?
+			if bytes.Compare(itKey, kEnd) > 0 {
+				return nil
+			}
+			var itValue []byte
+			if !keysOnly {
+				var err error
+				if itValue, err = item.ValueCopy(nil); err != nil {
+					return err
+				}
+				storage.StoreValueBytesRead <- len(itValue)
+			}
+			select {
+			case out <- &storage.KeyValue{K: itKey, V: itValue}:
+			case <-ctx.Done():
+				cancelled = true
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil || cancelled {
+		return err
+	}
+	out <- nil
+	return nil
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *BadgerDB) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil BadgerDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Put(tk, v)
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of Put: %v\n", err)
+		return fmt.Errorf("Error on batch commit of Put: %v", err)
+	}
+	return nil
+}
+
+// PutTTL writes a value with given key that badger removes after the time-to-live.
+func (db *BadgerDB) PutTTL(ctx storage.Context, tk storage.TKey, v []byte, ttl time.Duration) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutTTL on nil BadgerDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutTTL()")
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("bad time-to-live %s in PutTTL()", ttl)
+	}
+	key := ctx.ConstructKey(tk)
+	var ops []batchOp
+	if vctx, ok := ctx.(storage.VersionedCtx); ok {
+		ops = append(ops, batchOp{key: vctx.TombstoneKey(tk), del: true})
+	}
+	ops = append(ops, batchOp{key: key, value: v, ttl: ttl})
+	if err := db.writeOps(ops); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *BadgerDB) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil BadgerDB")
+	}
+	err := db.bdb.Update(func(txn *api.Txn) error {
+		return txn.Set(k, v)
+	})
+	if err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *BadgerDB) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil BadgerDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of Delete: %v\n", err)
+		return fmt.Errorf("Error on batch commit of Delete: %v", err)
+	}
+	return nil
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *BadgerDB) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil BadgerDB")
+	}
+	return db.bdb.Update(func(txn *api.Txn) error {
+		return txn.Delete(k)
+	})
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+func (db *BadgerDB) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange on nil BadgerDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of PutRange: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.
+func (db *BadgerDB) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil BadgerDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+	tkeys, err := db.KeysInRange(ctx, kStart, kEnd)
+	if err != nil {
+		return err
+	}
+	batch := db.NewBatch(ctx)
+	for _, tk := range tkeys {
+		batch.Delete(tk)
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of DeleteRange: %v\n", err)
+		return fmt.Errorf("Error on batch commit of DeleteRange: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", len(tkeys), ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+func (db *BadgerDB) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil BadgerDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+
+	var minKey, maxKey storage.Key
+	var err error
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if !allVersions && !versioned {
+		return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+	}
+	if versioned {
+		minKey, err = vctx.MinVersionKey(storage.MinTKey(storage.TKeyMinClass))
+		if err != nil {
+			return err
+		}
+		maxKey, err = vctx.MaxVersionKey(storage.MaxTKey(storage.TKeyMaxClass))
+		if err != nil {
+			return err
+		}
+	} else {
+		minKey, maxKey = ctx.KeyRange()
+	}
+
+	// Gather keys to delete with a keys-only scan, then delete in bounded transactions.
+	var keys []storage.Key
+	err = db.bdb.View(func(txn *api.Txn) error {
+		opts := api.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		deleteVersion := ctx.VersionID()
+		for it.Seek(minKey); it.Valid(); it.Next() {
+			itKey := it.Item().KeyCopy(nil)
+			storage.StoreKeyBytesRead <- len(itKey)
+			if bytes.Compare(itKey, maxKey) > 0 {
+				return nil
+			}
+			if !allVersions {
+				_, v, _, err := storage.DataKeyToLocalIDs(itKey)
+				if err != nil {
+					return fmt.Errorf("Error on DELETE ALL for version %d: %v", deleteVersion, err)
+				}
+				if v != deleteVersion {
+					continue
+				}
+			}
+			keys = append(keys, itKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error iterating during DeleteAll for %s: %v", ctx, err)
+	}
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		ops := make([]batchOp, end-start)
+		for i, k := range keys[start:end] {
+			ops[i] = batchOp{key: k, del: true}
+		}
+		if err := db.writeOps(ops); err != nil {
+			dvid.Criticalf("Error on batch commit of DeleteAll at key-value pair %d: %v\n", start, err)
+			return fmt.Errorf("Error on batch commit of DeleteAll at key-value pair %d: %v", start, err)
+		}
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", len(keys), ctx)
+	return nil
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	key   storage.Key
+	value []byte
+	del   bool
+	ttl   time.Duration // if non-zero, the key expires after this duration.
+}
+
+// writeOps applies the operations in as few badger transactions as possible,
+// splitting the work whenever a transaction grows too large.
+func (db *BadgerDB) writeOps(ops []batchOp) error {
+	for len(ops) > 0 {
+		var n int
+		err := db.bdb.Update(func(txn *api.Txn) error {
+			n = 0
+			for _, op := range ops {
+				var err error
+				switch {
+				case op.del:
+					err = txn.Delete(op.key)
+				case op.ttl != 0:
+					err = txn.SetEntry(api.NewEntry(op.key, op.value).WithTTL(op.ttl))
+				default:
+					err = txn.Set(op.key, op.value)
+				}
+				if err == api.ErrTxnTooBig && n > 0 {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+type goBatch struct {
+	db   *BadgerDB
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	ops  []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes
+func (db *BadgerDB) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil BadgerDB\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		vctx = nil
+	}
+	return &goBatch{db: db, ctx: ctx, vctx: vctx}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, value: dvid.EmptyValue()})
+	}
+	batch.ops = append(batch.ops, batchOp{key: key, del: true})
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, del: true})
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.ops = append(batch.ops, batchOp{key: key, value: v})
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	err := batch.db.writeOps(batch.ops)
+	batch.ops = nil
+	return err
+}
+
+// ---- SizeViewer interface ------
+
+// GetApproximateSizes returns the estimated on-disk size of each key range.  Badger
+// doesn't track sizes per key range, so this requires a keys-only scan of each range.
+func (db *BadgerDB) GetApproximateSizes(ranges []storage.KeyRange) ([]uint64, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetApproximateSizes on nil BadgerDB")
+	}
+	sizes := make([]uint64, len(ranges))
+	err := db.bdb.View(func(txn *api.Txn) error {
+		opts := api.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for i, kr := range ranges {
+			for it.Seek(kr.Start); it.Valid(); it.Next() {
+				item := it.Item()
+				if bytes.Compare(item.Key(), kr.OpenEnd) >= 0 {
+					break
+				}
+				sizes[i] += uint64(item.EstimatedSize())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
@@ -0,0 +1,51 @@
+// +build badger
+
+package badger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func TestBadgerPutTTL(t *testing.T) {
+	var e Engine
+	backend := new(storage.Backend)
+	if err := e.AddTestConfig(backend); err != nil {
+		t.Fatal(err)
+	}
+	config := backend.Stores[backend.DefaultKVDB]
+	db, _, err := e.newBadgerDB(config)
+	if err != nil {
+		t.Fatalf("unable to open test store: %v\n", err)
+	}
+	defer func() {
+		db.Close()
+		if err := e.Delete(config); err != nil {
+			t.Errorf("unable to delete test store: %v\n", err)
+		}
+	}()
+
+	var ctx storage.MetadataContext
+	if err := db.PutTTL(ctx, storage.TKey("a"), []byte("a"), 0); err == nil {
+		t.Errorf("expected error on put with zero time-to-live\n")
+	}
+	if err := db.PutTTL(ctx, storage.TKey("a"), []byte("expiring"), time.Second); err != nil {
+		t.Fatalf("error on put with time-to-live: %v\n", err)
+	}
+	if err := db.Put(ctx, storage.TKey("b"), []byte("lasting")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if v, err := db.Get(ctx, storage.TKey("a")); err != nil || string(v) != "expiring" {
+		t.Fatalf("expected value before expiration, got %q: %v\n", v, err)
+	}
+
+	time.Sleep(2 * time.Second)
+	if v, err := db.Get(ctx, storage.TKey("a")); err != nil || v != nil {
+		t.Errorf("expected no value after expiration, got %q: %v\n", v, err)
+	}
+	if v, err := db.Get(ctx, storage.TKey("b")); err != nil || string(v) != "lasting" {
+		t.Errorf("expected value without time-to-live to remain, got %q: %v\n", v, err)
+	}
+}
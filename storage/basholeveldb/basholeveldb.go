@@ -4,6 +4,7 @@ package basholeveldb
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -55,6 +56,10 @@ const (
 	// the next time the database is opened.
 	DefaultWriteBufferSize = 62914560
 
+	// Values larger than this are split into chunks (see storage.ValueSizeLimiter) since
+	// large values fill write buffers and are rewritten by each compaction that reaches them.
+	MaxValueSize = 8 * dvid.Mega
+
 	// Write Options
 
 	// If Sync=true, the write will be flushed from the operating system
@@ -283,6 +288,11 @@ func (db *LevelDB) String() string {
 	return fmt.Sprintf("basholeveldb @ %s", db.directory)
 }
 
+// MaxValueSize returns the size above which values are split into chunks.
+func (db *LevelDB) MaxValueSize() int {
+	return MaxValueSize
+}
+
 // --- The Leveldb Implementation must satisfy a Engine interface ----
 
 type LevelDB struct {
@@ -485,6 +495,136 @@ func (db *LevelDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
 	}
 }
 
+// Exists returns true if a value is stored for the key.  Missing unversioned keys are
+// usually rejected by the bloom filters, and versioned keys are checked without reading
+// the values of each version.
+func (db *LevelDB) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists on nil LevelDB")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if !ctx.Versioned() {
+		dvid.StartCgo()
+		v, err := db.ldb.Get(db.options.ReadOptions, ctx.ConstructKey(tk))
+		dvid.StopCgo()
+		return v != nil, err
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return false, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return false, err
+	}
+	dvid.StartCgo()
+	ro := levigo.NewReadOptions()
+	it := db.ldb.NewIterator(ro)
+	defer func() {
+		it.Close()
+		ro.Close()
+		dvid.StopCgo()
+	}()
+	var versions []*storage.KeyValue
+	for it.Seek(begKey); it.Valid(); it.Next() {
+		itKey := it.Key()
+		storage.StoreKeyBytesRead <- len(itKey)
+		if bytes.Compare(itKey, endKey) > 0 {
+			break
+		}
+		versions = append(versions, &storage.KeyValue{K: itKey})
+	}
+	if err := it.GetError(); err != nil {
+		return false, err
+	}
+	kv, err := vctx.VersionedKeyValue(versions)
+	return kv != nil, err
+}
+
+// GetBatch returns the values of the given keys, with nil for keys not found.  All keys
+// are read from one consistent view, and versioned keys share a single iterator, which
+// avoids the cgo and iterator setup costs of a Get per key.
+func (db *LevelDB) GetBatch(ctx storage.Context, tks []storage.TKey) ([][]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetBatch on nil LevelDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetBatch()")
+	}
+	values := make([][]byte, len(tks))
+	if !ctx.Versioned() {
+		dvid.StartCgo()
+		snapshot := db.ldb.NewSnapshot()
+		ro := levigo.NewReadOptions()
+		ro.SetSnapshot(snapshot)
+		defer func() {
+			ro.Close()
+			db.ldb.ReleaseSnapshot(snapshot)
+			dvid.StopCgo()
+		}()
+		for i, tk := range tks {
+			v, err := db.ldb.Get(ro, ctx.ConstructKey(tk))
+			if err != nil {
+				return nil, err
+			}
+			storage.StoreValueBytesRead <- len(v)
+			values[i] = v
+		}
+		return values, nil
+	}
+
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return nil, fmt.Errorf("Bad GetBatch(): context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	dvid.StartCgo()
+	ro := levigo.NewReadOptions()
+	it := db.ldb.NewIterator(ro)
+	defer func() {
+		it.Close()
+		ro.Close()
+		dvid.StopCgo()
+	}()
+	for i, tk := range tks {
+		begKey, err := vctx.MinVersionKey(tk)
+		if err != nil {
+			return nil, err
+		}
+		endKey, err := vctx.MaxVersionKey(tk)
+		if err != nil {
+			return nil, err
+		}
+		var versions []*storage.KeyValue
+		for it.Seek(begKey); it.Valid(); it.Next() {
+			itKey := it.Key()
+			storage.StoreKeyBytesRead <- len(itKey)
+			if bytes.Compare(itKey, endKey) > 0 {
+				break
+			}
+			itValue := it.Value()
+			storage.StoreValueBytesRead <- len(itValue)
+			versions = append(versions, &storage.KeyValue{K: itKey, V: itValue})
+		}
+		if err := it.GetError(); err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(versions)
+		if err != nil {
+			return nil, err
+		}
+		if kv != nil {
+			values[i] = kv.V
+		}
+	}
+	return values, nil
+}
+
 // getSingleKeyVersions returns all versions of a key.  These key-value pairs will be sorted
 // in ascending key order and could include a tombstone key.
 func (db *LevelDB) getSingleKeyVersions(vctx storage.VersionedCtx, tk []byte) ([]*storage.KeyValue, error) {
@@ -773,100 +913,81 @@ func (db *LevelDB) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKe
 	}
 }
 
-// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
-// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
-// pairs for the particular version will be returned.
-func (db *LevelDB) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+// GetRangeStream returns a stream of key-value pairs spanning (kStart, kEnd) keys in
+// ascending key order.  If the keys are versioned, only key-value pairs for the context's
+// version will be sent.
+func (db *LevelDB) GetRangeStream(ctx storage.Context, kStart, kEnd storage.TKey) (*storage.RangeStream, error) {
 	if db == nil {
-		return nil, fmt.Errorf("Can't call GetRange on nil LevelDB")
+		return nil, fmt.Errorf("Can't call GetRangeStream on nil LevelDB")
 	}
 	if ctx == nil {
-		return nil, fmt.Errorf("Received nil context in GetRange()")
+		return nil, fmt.Errorf("Received nil context in GetRangeStream()")
 	}
-	ch := make(chan errorableKV)
-	done := make(chan struct{})
-	defer close(done)
+	return storage.StreamRange(ctx, func(send func(*storage.TKeyValue) bool) error {
+		ch := make(chan errorableKV)
+		done := make(chan struct{})
 
-	// Run the range query on a potentially versioned key in a goroutine.
-	go func() {
-		if ctx == nil || !ctx.Versioned() {
-			db.unversionedRange(ctx, kStart, kEnd, ch, done, false)
-		} else {
-			db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, false)
+		// Run the range query on a potentially versioned key in a goroutine.
+		go func() {
+			if !ctx.Versioned() {
+				db.unversionedRange(ctx, kStart, kEnd, ch, done, false)
+			} else {
+				db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, false)
+			}
+		}()
+
+		// Relay the key-value pairs until the range is complete or the stream is closed,
+		// after which the range query is stopped and its final result drained.
+		for {
+			result := <-ch
+			if result.error != nil {
+				return result.error
+			}
+			if result.KeyValue == nil {
+				return nil
+			}
+			tk, err := storage.TKeyFromKey(result.KeyValue.K)
+			if err == nil && send(&storage.TKeyValue{K: tk, V: result.KeyValue.V}) {
+				continue
+			}
+			close(done)
+			for result = range ch {
+				if result.KeyValue == nil {
+					break
+				}
+			}
+			return err
 		}
-	}()
+	}), nil
+}
 
-	// Consume the key-value pairs.
-	values := []*storage.TKeyValue{}
-	for {
-		result := <-ch
-		if result.KeyValue == nil {
-			return values, nil
-		}
-		if result.error != nil {
-			return nil, result.error
-		}
-		tk, err := storage.TKeyFromKey(result.KeyValue.K)
-		if err != nil {
-			return nil, err
-		}
-		tkv := storage.TKeyValue{tk, result.KeyValue.V}
-		values = append(values, &tkv)
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *LevelDB) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	stream, err := db.GetRangeStream(ctx, kStart, kEnd)
+	if err != nil {
+		return nil, err
 	}
+	return stream.Collect()
 }
 
 // ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
 // only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
 // function is immediately terminated and returns an error.
 func (db *LevelDB) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
-	if db == nil {
-		return fmt.Errorf("Can't call ProcessRange on nil LevelDB")
-	}
-	if ctx == nil {
-		return fmt.Errorf("Received nil context in ProcessRange()")
-	}
-	ch := make(chan errorableKV)
-	done := make(chan struct{})
-	defer close(done)
-
-	// Run the range query on a potentially versioned key in a goroutine.
-	go func() {
-		if ctx == nil || !ctx.Versioned() {
-			db.unversionedRange(ctx, kStart, kEnd, ch, done, false)
-		} else {
-			db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, false)
-		}
-	}()
-
-	// Consume the key-value pairs.
-	for {
-		result := <-ch
-		if result.KeyValue == nil {
-			return nil
-		}
-		if result.error != nil {
-			return result.error
-		}
-		if op != nil && op.Wg != nil {
-			op.Wg.Add(1)
-		}
-		tk, err := storage.TKeyFromKey(result.KeyValue.K)
-		if err != nil {
-			return err
-		}
-		tkv := storage.TKeyValue{tk, result.KeyValue.V}
-		chunk := &storage.Chunk{op, &tkv}
-		if err := f(chunk); err != nil {
-			return err
-		}
+	stream, err := db.GetRangeStream(ctx, kStart, kEnd)
+	if err != nil {
+		return err
 	}
+	return stream.Process(op, f)
 }
 
 // RawRangeQuery sends a range of full keys.  This is to be used for low-level data
 // retrieval like DVID-to-DVID communication and should not be used by data type
 // implementations if possible.  A nil is sent down the channel when the
 // range is complete.
-func (db *LevelDB) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+func (db *LevelDB) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
 	if db == nil {
 		return fmt.Errorf("Can't call RawRangeQuery on nil LevelDB")
 	}
@@ -895,7 +1016,7 @@ func (db *LevelDB) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out ch
 			kv := storage.KeyValue{itKey, itValue}
 			select {
 			case out <- &kv:
-			case <-cancel:
+			case <-ctx.Done():
 				return nil
 			}
 			//out <- &kv
@@ -1373,6 +1494,24 @@ func (db *LevelDB) GetApproximateSizes(ranges []storage.KeyRange) ([]uint64, err
 	return sizes, nil
 }
 
+// ---- Compacter interface ------
+
+// Compact compacts the underlying storage for the key range, discarding deleted and
+// overwritten values.
+func (db *LevelDB) Compact(r storage.KeyRange) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Compact on nil LevelDB")
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	db.ldb.CompactRange(levigo.Range{
+		Start: []byte(r.Start),
+		Limit: []byte(r.OpenEnd),
+	})
+	return nil
+}
+
 // --- Options ----
 
 type leveldbOptions struct {
@@ -344,6 +344,37 @@ func (db *BigTable) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
 	return value, nil
 }
 
+// Exists returns true if a value is stored for the key.  Values are stripped from the
+// row by the server so only column names are transferred.
+func (db *BigTable) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists() on nil BigTable")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+
+	unvKey, verKey, err := ctx.SplitKey(tk)
+	if err != nil {
+		return false, err
+	}
+
+	r, err := tbl.ReadRow(db.ctx, encodeKey(unvKey), api.RowFilter(api.StripValueFilter()))
+	if err != nil || len(r) == 0 {
+		return false, err
+	}
+	for _, readItem := range r[familyName] {
+		itemVer, err := decodeKey(readItem.Column)
+		if err != nil {
+			return false, fmt.Errorf("Error in Exists(): %s\n", err)
+		}
+		if bytes.Equal(itemVer, verKey) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // GetRange returns a range of values spanning (TkBeg, kEnd) keys.
 func (db *BigTable) GetRange(ctx storage.Context, TkBeg, TkEnd storage.TKey) ([]*storage.TKeyValue, error) {
 	if db == nil {
@@ -519,6 +550,7 @@ func (db *BigTable) ProcessRange(ctx storage.Context, TkBeg, TkEnd storage.TKey,
 	if ctx == nil {
 		return fmt.Errorf("Received nil context in ProcessRange()")
 	}
+	f = storage.CancelableChunkFunc(ctx, f)
 
 	unvKeyBeg, verKey, err := ctx.SplitKey(TkBeg)
 	if err != nil {
@@ -584,7 +616,7 @@ func (db *BigTable) ProcessRange(ctx storage.Context, TkBeg, TkEnd storage.TKey,
 // implementations if possible because each version's key-value pairs are sent
 // without filtering by the current version and its ancestor graph.  A nil is sent
 // down the channel when the range is complete.
-func (db *BigTable) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+func (db *BigTable) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
 	if db == nil {
 		return fmt.Errorf("Can't call RawRangeQuery() on nil BigTable")
 	}
@@ -604,13 +636,10 @@ func (db *BigTable) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out c
 	rr := api.NewRange(encodeKey(unvKeyBeg), encodeKey(unvKeyEnd))
 
 	err = tbl.ReadRows(db.ctx, rr, func(r api.Row) bool {
-		if cancel != nil {
-			select {
-			case <-cancel:
-				out <- nil
-				return nil
-			default:
-			}
+		select {
+		case <-ctx.Done():
+			return false
+		default:
 		}
 
 		unvKeyRow, err := decodeKey(r.Key())
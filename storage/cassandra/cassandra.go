@@ -0,0 +1,668 @@
+// +build cassandra
+
+/*
+	Package cassandra implements a storage engine on Apache Cassandra or ScyllaDB, giving
+	multi-node replication for block data.
+
+	DVID keys are split (see storage.SplitKey) into a partition key holding the instance
+	and type-specific key, and a clustering key holding the version, client, and
+	tombstone mark:
+
+		CREATE TABLE <keyspace>.<table> (
+			unv blob,  -- instance ID + TKey
+			ver blob,  -- version ID + client ID + mark
+			v   blob,
+			PRIMARY KEY (unv, ver)
+		)
+
+	All versions of a key therefore live in one wide partition and a versioned Get is a
+	single partition read.  Since partitions are distributed by token, the store is an
+	unordered KeyValueDB.  RawRangeQuery is still provided for DVID-to-DVID transfers by
+	scanning the token ring in splits and filtering keys, but results are not returned in
+	key order.
+*/
+package cassandra
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	// DefaultTable is the table used if none is configured.
+	DefaultTable = "dvid_kv"
+
+	// DefaultReplication is the replication factor used when creating a keyspace.
+	DefaultReplication = 3
+
+	// DefaultScanSplits is the number of token ranges scanned in turn by RawRangeQuery.
+	DefaultScanSplits = 256
+
+	// ScanPageSize is the number of rows fetched per page during scans.
+	ScanPageSize = 1000
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in cassandra: %v\n", err)
+	}
+	e := Engine{"cassandra", "Apache Cassandra or ScyllaDB wide-row store", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return true
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a Cassandra store.  The passed Config must contain:
+// "hosts": list of contact points, e.g., ["cass1:9042", "cass2:9042"]
+// "keyspace": keyspace name, created with SimpleStrategy if it doesn't exist
+// Optional settings:
+// "table": table name (default "dvid_kv")
+// "replication": replication factor for a newly created keyspace (default 3)
+// "consistency": read/write consistency level (default "quorum")
+// "username", "password": credentials for password authentication
+// "scansplits": number of token ranges for RawRangeQuery (default 256)
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newCassandra(config)
+}
+
+type cassConfig struct {
+	hosts       []string
+	keyspace    string
+	table       string
+	replication int
+	consistency gocql.Consistency
+	username    string
+	password    string
+	scanSplits  int
+}
+
+func parseConfig(config dvid.StoreConfig) (cc cassConfig, err error) {
+	c := config.GetAll()
+	cc.table = DefaultTable
+	cc.replication = DefaultReplication
+	cc.consistency = gocql.Quorum
+	cc.scanSplits = DefaultScanSplits
+
+	v, found := c["hosts"]
+	if !found {
+		err = fmt.Errorf("%q must be specified for cassandra configuration", "hosts")
+		return
+	}
+	switch hosts := v.(type) {
+	case string:
+		cc.hosts = strings.Split(hosts, ",")
+	case []interface{}:
+		for _, host := range hosts {
+			s, ok := host.(string)
+			if !ok {
+				err = fmt.Errorf("%q setting must be a list of strings (%v)", "hosts", v)
+				return
+			}
+			cc.hosts = append(cc.hosts, s)
+		}
+	case []string:
+		cc.hosts = hosts
+	default:
+		err = fmt.Errorf("%q setting must be a list of strings (%v)", "hosts", v)
+		return
+	}
+	if len(cc.hosts) == 0 {
+		err = fmt.Errorf("at least one host must be given for cassandra configuration")
+		return
+	}
+
+	var consistency string
+	settings := map[string]*string{
+		"keyspace":    &cc.keyspace,
+		"table":       &cc.table,
+		"consistency": &consistency,
+		"username":    &cc.username,
+		"password":    &cc.password,
+	}
+	for name, dest := range settings {
+		v, found := c[name]
+		if !found {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			err = fmt.Errorf("%q setting must be a string (%v)", name, v)
+			return
+		}
+		*dest = s
+	}
+	if cc.keyspace == "" {
+		err = fmt.Errorf("%q must be specified for cassandra configuration", "keyspace")
+		return
+	}
+	if consistency != "" {
+		if err = cc.consistency.UnmarshalText([]byte(strings.ToUpper(consistency))); err != nil {
+			err = fmt.Errorf("bad cassandra consistency %q: %v", consistency, err)
+			return
+		}
+	}
+
+	ints := map[string]*int{
+		"replication": &cc.replication,
+		"scansplits":  &cc.scanSplits,
+	}
+	for name, dest := range ints {
+		v, found := c[name]
+		if !found {
+			continue
+		}
+		switch n := v.(type) {
+		case int64:
+			*dest = int(n)
+		case int:
+			*dest = n
+		default:
+			err = fmt.Errorf("%q setting must be an integer (%v)", name, v)
+			return
+		}
+		if *dest <= 0 {
+			err = fmt.Errorf("%q setting must be positive (%v)", name, v)
+			return
+		}
+	}
+	return
+}
+
+func (cc cassConfig) newCluster() *gocql.ClusterConfig {
+	cluster := gocql.NewCluster(cc.hosts...)
+	cluster.Consistency = cc.consistency
+	cluster.PageSize = ScanPageSize
+	if cc.username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: cc.username,
+			Password: cc.password,
+		}
+	}
+	return cluster
+}
+
+// newCassandra connects to the cluster and creates the keyspace and table if necessary.
+func (e Engine) newCassandra(config dvid.StoreConfig) (*Cassandra, bool, error) {
+	cc, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Schema changes are done with a session not bound to the keyspace.
+	cluster := cc.newCluster()
+	admin, err := cluster.CreateSession()
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to connect to cassandra hosts %v: %v", cc.hosts, err)
+	}
+	var created bool
+	var name string
+	err = admin.Query(`SELECT table_name FROM system_schema.tables WHERE keyspace_name = ? AND table_name = ?`,
+		cc.keyspace, cc.table).Scan(&name)
+	switch err {
+	case nil:
+	case gocql.ErrNotFound:
+		created = true
+		stmt := fmt.Sprintf(`CREATE KEYSPACE IF NOT EXISTS %q WITH replication = {'class': 'SimpleStrategy', 'replication_factor': %d}`,
+			cc.keyspace, cc.replication)
+		if err = admin.Query(stmt).Exec(); err != nil {
+			admin.Close()
+			return nil, false, fmt.Errorf("unable to create cassandra keyspace %q: %v", cc.keyspace, err)
+		}
+		stmt = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q.%q (unv blob, ver blob, v blob, PRIMARY KEY (unv, ver))`,
+			cc.keyspace, cc.table)
+		if err = admin.Query(stmt).Exec(); err != nil {
+			admin.Close()
+			return nil, false, fmt.Errorf("unable to create cassandra table %q: %v", cc.table, err)
+		}
+	default:
+		admin.Close()
+		return nil, false, fmt.Errorf("unable to read cassandra schema: %v", err)
+	}
+	admin.Close()
+
+	cluster.Keyspace = cc.keyspace
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to open cassandra keyspace %q: %v", cc.keyspace, err)
+	}
+	db := &Cassandra{
+		cassConfig: cc,
+		config:     config,
+		session:    session,
+	}
+	dvid.Infof("Opened %s\n", db)
+	return db, created, nil
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets cassandra as the default key-value backend, using the hosts given by
+// the DVID_TEST_CASSANDRA environment variable.  Each test store uses a new table in the
+// "dvid_test" keyspace with a replication factor of 1.  The metadata isn't set since it
+// requires an ordered store.  If another engine is already set, it returns an error since
+// only one key-value backend should be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("cassandra can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	hosts := os.Getenv("DVID_TEST_CASSANDRA")
+	if hosts == "" {
+		return fmt.Errorf("cassandra can't be testable key-value without hosts in DVID_TEST_CASSANDRA")
+	}
+	alias := storage.Alias("cassandra")
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"hosts":       hosts,
+		"keyspace":    "dvid_test",
+		"table":       fmt.Sprintf("dvid_test_%x", uuid.NewV4().Bytes()),
+		"replication": 1,
+		"consistency": "one",
+		"testing":     true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "cassandra"}
+	return nil
+}
+
+// Delete drops the configured table, leaving the keyspace.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	cc, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	session, err := cc.newCluster().CreateSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return session.Query(fmt.Sprintf(`DROP TABLE IF EXISTS %q.%q`, cc.keyspace, cc.table)).Exec()
+}
+
+// Cassandra is a key-value store on a Cassandra or ScyllaDB table.
+type Cassandra struct {
+	cassConfig
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+
+	session *gocql.Session
+}
+
+func (db *Cassandra) String() string {
+	return fmt.Sprintf("cassandra table %s.%s @ %s", db.keyspace, db.table, strings.Join(db.hosts, ","))
+}
+
+// Close closes the session.
+func (db *Cassandra) Close() {
+	if db != nil && db.session != nil {
+		db.session.Close()
+		db.session = nil
+	}
+}
+
+// Equal returns true if the cassandra store matches the given store configuration.
+func (db *Cassandra) Equal(config dvid.StoreConfig) bool {
+	cc, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.keyspace == cc.keyspace && db.table == cc.table &&
+		strings.Join(db.hosts, ",") == strings.Join(cc.hosts, ",")
+}
+
+// stmt returns a CQL statement with the table name substituted for %s.
+func (db *Cassandra) stmt(format string) string {
+	return fmt.Sprintf(format, fmt.Sprintf("%q", db.table))
+}
+
+// getVersions returns all stored key-value pairs in a partition, which are returned by
+// cassandra in clustering order and so are in ascending key order.  If keysOnly is true,
+// the values are not read.
+func (db *Cassandra) getVersions(unversioned storage.Key, keysOnly bool) ([]*storage.KeyValue, error) {
+	var ver, v []byte
+	query, dest := `SELECT ver, v FROM %s WHERE unv = ?`, []interface{}{&ver, &v}
+	if keysOnly {
+		query, dest = `SELECT ver FROM %s WHERE unv = ?`, dest[:1]
+	}
+	iter := db.session.Query(db.stmt(query), []byte(unversioned)).Iter()
+	var kvs []*storage.KeyValue
+	for iter.Scan(dest...) {
+		k := storage.MergeKey(append([]byte{}, unversioned...), ver)
+		kvs = append(kvs, &storage.KeyValue{K: k, V: v})
+		ver, v = nil, nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return kvs, nil
+}
+
+// ---- KeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *Cassandra) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call Get on nil Cassandra")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	unversioned, ver, err := ctx.SplitKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		values, err := db.getVersions(unversioned, false)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			storage.StoreValueBytesRead <- len(kv.V)
+			return kv.V, err
+		}
+		return nil, err
+	}
+	var v []byte
+	err = db.session.Query(db.stmt(`SELECT v FROM %s WHERE unv = ? AND ver = ?`), []byte(unversioned), ver).Scan(&v)
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	storage.StoreValueBytesRead <- len(v)
+	return v, nil
+}
+
+// Exists returns true if a value is stored for the key.  Cassandra's bloom filters skip
+// SSTables without the partition, and values aren't transferred.
+func (db *Cassandra) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists on nil Cassandra")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	unversioned, ver, err := ctx.SplitKey(tk)
+	if err != nil {
+		return false, err
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		versions, err := db.getVersions(unversioned, true)
+		if err != nil {
+			return false, err
+		}
+		kv, err := vctx.VersionedKeyValue(versions)
+		return kv != nil, err
+	}
+	var found []byte
+	err = db.session.Query(db.stmt(`SELECT ver FROM %s WHERE unv = ? AND ver = ?`), []byte(unversioned), ver).Scan(&found)
+	if err == gocql.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *Cassandra) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil Cassandra")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Put(tk, v)
+	return batch.Commit()
+}
+
+// Delete removes a value with given key.
+func (db *Cassandra) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil Cassandra")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	return batch.Commit()
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *Cassandra) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil Cassandra")
+	}
+	unversioned, ver, err := storage.SplitKey(k)
+	if err != nil {
+		return err
+	}
+	if err := db.session.Query(db.stmt(`INSERT INTO %s (unv, ver, v) VALUES (?, ?, ?)`), []byte(unversioned), []byte(ver), v).Exec(); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *Cassandra) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil Cassandra")
+	}
+	unversioned, ver, err := storage.SplitKey(k)
+	if err != nil {
+		return err
+	}
+	return db.session.Query(db.stmt(`DELETE FROM %s WHERE unv = ? AND ver = ?`), []byte(unversioned), []byte(ver)).Exec()
+}
+
+// RawRangeQuery sends full keys in [kStart, kEnd] by scanning the token ring.  This is
+// to be used for low-level data retrieval like DVID-to-DVID communication and should
+// not be used by data type implementations.  Unlike ordered engines, key-value pairs
+// are NOT sent in key order, although all versions of a key are sent consecutively.
+// A nil is sent down the channel when the range is complete.
+func (db *Cassandra) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil Cassandra")
+	}
+	query := `SELECT unv, ver, v FROM %s WHERE token(unv) >= ? AND token(unv) <= ?`
+	if keysOnly {
+		query = `SELECT unv, ver FROM %s WHERE token(unv) >= ? AND token(unv) <= ?`
+	}
+	query = db.stmt(query)
+
+	// Split the Murmur3 token ring, [MinInt64, MaxInt64], into contiguous ranges.
+	step := uint64(math.MaxUint64/uint64(db.scanSplits)) + 1
+	for i := 0; i < db.scanSplits; i++ {
+		minToken := int64(uint64(1)<<63 + uint64(i)*step)
+		maxToken := int64(math.MaxInt64)
+		if i < db.scanSplits-1 {
+			maxToken = minToken + int64(step-1)
+		}
+		iter := db.session.Query(query, minToken, maxToken).Iter()
+		var unversioned, ver, v []byte
+		dest := []interface{}{&unversioned, &ver, &v}
+		if keysOnly {
+			dest = dest[:2]
+		}
+		for iter.Scan(dest...) {
+			k := storage.MergeKey(unversioned, ver)
+			unversioned, ver, v = nil, nil, nil
+			if bytes.Compare(k, kStart) < 0 || bytes.Compare(k, kEnd) > 0 {
+				continue
+			}
+			storage.StoreKeyBytesRead <- len(k)
+			storage.StoreValueBytesRead <- len(v)
+			select {
+			case out <- &storage.KeyValue{K: k, V: v}:
+			case <-ctx.Done():
+				return iter.Close()
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return fmt.Errorf("error scanning %s tokens [%d, %d]: %v", db, minToken, maxToken, err)
+		}
+	}
+	out <- nil
+	return nil
+}
+
+// ---- KeyValueIngestable interface ------
+
+// KeyValueIngest writes a key-value pair without tombstone bookkeeping, which
+// suffices for bulk loads of immutable data.
+func (db *Cassandra) KeyValueIngest(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call KeyValueIngest on nil Cassandra")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in KeyValueIngest()")
+	}
+	return db.RawPut(ctx.ConstructKey(tk), v)
+}
+
+// --- Batcher interface ----
+
+type goBatch struct {
+	db    *Cassandra
+	ctx   storage.Context
+	vctx  storage.VersionedCtx
+	batch *gocql.Batch
+}
+
+// NewBatch returns an implementation that allows batch writes.  Batches are logged
+// so that they are applied atomically, even across partitions.
+func (db *Cassandra) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil Cassandra\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	var vctx storage.VersionedCtx
+	if ctx.Versioned() {
+		vctx, _ = ctx.(storage.VersionedCtx)
+	}
+	return &goBatch{db: db, ctx: ctx, vctx: vctx, batch: db.session.NewBatch(gocql.LoggedBatch)}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) put(k storage.Key, v []byte) {
+	unversioned, ver, err := storage.SplitKey(k)
+	if err != nil {
+		dvid.Criticalf("Bad key in cassandra batch put: %v\n", err)
+		return
+	}
+	batch.batch.Query(batch.db.stmt(`INSERT INTO %s (unv, ver, v) VALUES (?, ?, ?)`), []byte(unversioned), []byte(ver), v)
+}
+
+func (batch *goBatch) delete(k storage.Key) {
+	unversioned, ver, err := storage.SplitKey(k)
+	if err != nil {
+		dvid.Criticalf("Bad key in cassandra batch delete: %v\n", err)
+		return
+	}
+	batch.batch.Query(batch.db.stmt(`DELETE FROM %s WHERE unv = ? AND ver = ?`), []byte(unversioned), []byte(ver))
+}
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.put(tombstone, dvid.EmptyValue())
+	}
+	batch.delete(batch.ctx.ConstructKey(tk))
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.delete(tombstone)
+	}
+	key := batch.ctx.ConstructKey(tk)
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.put(key, v)
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	if batch.batch.Size() == 0 {
+		return nil
+	}
+	if err := batch.db.session.ExecuteBatch(batch.batch); err != nil {
+		dvid.Criticalf("Error on batch commit of %d statements to %s: %v\n", batch.batch.Size(), batch.db, err)
+		return err
+	}
+	batch.batch = batch.db.session.NewBatch(gocql.LoggedBatch)
+	return nil
+}
@@ -0,0 +1,114 @@
+// +build cassandra
+
+package cassandra
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/storage/storetest"
+
+	"github.com/gocql/gocql"
+)
+
+func testConfig(c map[string]interface{}) dvid.StoreConfig {
+	var config dvid.Config
+	config.SetAll(c)
+	return dvid.StoreConfig{Config: config, Engine: "cassandra"}
+}
+
+func TestCassandraConfig(t *testing.T) {
+	expected := []string{"cass1", "cass2"}
+	for _, hosts := range []interface{}{"cass1,cass2", []interface{}{"cass1", "cass2"}, expected} {
+		cc, err := parseConfig(testConfig(map[string]interface{}{"hosts": hosts, "keyspace": "dvid"}))
+		if err != nil {
+			t.Errorf("error parsing hosts %v: %v\n", hosts, err)
+			continue
+		}
+		if !reflect.DeepEqual(cc.hosts, expected) {
+			t.Errorf("expected hosts %v from %v, got %v\n", expected, hosts, cc.hosts)
+		}
+		if cc.table != DefaultTable || cc.replication != DefaultReplication || cc.scanSplits != DefaultScanSplits || cc.consistency != gocql.Quorum {
+			t.Errorf("expected default settings, got %+v\n", cc)
+		}
+	}
+
+	cc, err := parseConfig(testConfig(map[string]interface{}{
+		"hosts":       "cass1",
+		"keyspace":    "dvid",
+		"table":       "kv",
+		"consistency": "local_quorum",
+		"replication": int64(1),
+		"scansplits":  16,
+	}))
+	if err != nil {
+		t.Fatalf("error parsing configuration: %v\n", err)
+	}
+	if cc.table != "kv" || cc.consistency != gocql.LocalQuorum || cc.replication != 1 || cc.scanSplits != 16 {
+		t.Errorf("bad parsed configuration %+v\n", cc)
+	}
+
+	bad := []map[string]interface{}{
+		{"keyspace": "dvid"},
+		{"hosts": "cass1"},
+		{"hosts": 9042, "keyspace": "dvid"},
+		{"hosts": []interface{}{"cass1", 2}, "keyspace": "dvid"},
+		{"hosts": []string{}, "keyspace": "dvid"},
+		{"hosts": "cass1", "keyspace": "dvid", "consistency": "most"},
+		{"hosts": "cass1", "keyspace": "dvid", "replication": 0},
+		{"hosts": "cass1", "keyspace": "dvid", "scansplits": "16"},
+	}
+	for _, c := range bad {
+		if _, err := parseConfig(testConfig(c)); err == nil {
+			t.Errorf("expected error parsing configuration %v\n", c)
+		}
+	}
+
+	db := &Cassandra{cassConfig: cassConfig{hosts: expected, keyspace: "dvid", table: DefaultTable}}
+	if !db.Equal(testConfig(map[string]interface{}{"hosts": "cass1,cass2", "keyspace": "dvid"})) {
+		t.Errorf("expected store to equal its configuration\n")
+	}
+	if db.Equal(testConfig(map[string]interface{}{"hosts": "cass1,cass2", "keyspace": "dvid", "table": "kv"})) {
+		t.Errorf("expected store not to equal configuration with another table\n")
+	}
+}
+
+// checkPartitions checks that each unversioned key is its own partition, so keys that
+// share a prefix aren't returned with each other's versions.
+func checkPartitions(store dvid.Store) error {
+	db := store.(*Cassandra)
+	var ctx storage.MetadataContext
+	for _, k := range []string{"part", "partition"} {
+		if err := db.Put(ctx, storage.TKey(k), []byte(k)); err != nil {
+			return fmt.Errorf("error on put of %q: %v", k, err)
+		}
+		defer db.Delete(ctx, storage.TKey(k))
+	}
+	unversioned, ver, err := ctx.SplitKey(storage.TKey("part"))
+	if err != nil {
+		return err
+	}
+	kvs, err := db.getVersions(unversioned, false)
+	if err != nil {
+		return fmt.Errorf("error getting versions: %v", err)
+	}
+	if len(kvs) != 1 {
+		return fmt.Errorf("expected 1 version in partition, got %d", len(kvs))
+	}
+	if !bytes.Equal(kvs[0].K, storage.MergeKey(unversioned, ver)) || string(kvs[0].V) != "part" {
+		return fmt.Errorf("bad key-value in partition: %v", kvs[0])
+	}
+	kvs, err = db.getVersions(unversioned, true)
+	if err != nil || len(kvs) != 1 || kvs[0].V != nil {
+		return fmt.Errorf("expected only key of partition, got %v: %v", kvs, err)
+	}
+	return nil
+}
+
+func TestCassandraStore(t *testing.T) {
+	storetest.RunEngine(t, "cassandra", []storetest.Check{{Name: "Partitions", Run: checkPartitions}})
+}
@@ -0,0 +1,482 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// ChunkConfig handles settings for splitting large values into chunks.  Stores whose
+// engines declare a maximum value size (see ValueSizeLimiter) are always chunked.
+type ChunkConfig struct {
+	MaxBytes int     `toml:"max_bytes"` // values larger than this are split into chunks.
+	Stores   []Alias // aliases of stores chunked even if their engines set no maximum.
+}
+
+// chunkThreshold returns the size above which values of a store are split into chunks,
+// or zero if values are stored whole.
+func (c ChunkConfig) chunkThreshold(store dvid.Store, chunked bool) (int, error) {
+	var maxBytes int
+	if limiter, ok := store.(ValueSizeLimiter); ok {
+		maxBytes = limiter.MaxValueSize()
+	}
+	if chunked {
+		if c.MaxBytes <= 0 && maxBytes <= 0 {
+			return 0, fmt.Errorf("chunking requires %q since engine of store %s sets no maximum value size", "max_bytes", store)
+		}
+		if c.MaxBytes > 0 && (maxBytes <= 0 || c.MaxBytes < maxBytes) {
+			maxBytes = c.MaxBytes
+		}
+	}
+	return maxBytes, nil
+}
+
+// chunkManifestMagic starts the value stored in place of a chunked value.
+var chunkManifestMagic = []byte("\x00dvid-chunked\x00")
+
+const chunkManifestSize = 14 + 16 + 4 + 8
+
+// chunkManifest describes the chunks of a value, which are stored under keys with a
+// random id so a new value never overwrites chunks still referenced by an old manifest.
+type chunkManifest struct {
+	id        [16]byte
+	numChunks uint32
+	size      uint64
+}
+
+func parseManifest(stored []byte) (m chunkManifest, isManifest bool) {
+	if len(stored) != chunkManifestSize || !bytes.HasPrefix(stored, chunkManifestMagic) {
+		return
+	}
+	pos := len(chunkManifestMagic)
+	copy(m.id[:], stored[pos:pos+16])
+	m.numChunks = binary.BigEndian.Uint32(stored[pos+16 : pos+20])
+	m.size = binary.BigEndian.Uint64(stored[pos+20:])
+	return m, true
+}
+
+func (m chunkManifest) bytes() []byte {
+	buf := make([]byte, chunkManifestSize)
+	pos := copy(buf, chunkManifestMagic)
+	pos += copy(buf[pos:], m.id[:])
+	binary.BigEndian.PutUint32(buf[pos:], m.numChunks)
+	binary.BigEndian.PutUint64(buf[pos+4:], m.size)
+	return buf
+}
+
+// chunkKey holds the i-th chunk of the value.
+func (m chunkManifest) chunkKey(i uint32) TKey {
+	tk := make([]byte, 1+len(m.id)+4)
+	tk[0] = chunkKeyPrefix
+	copy(tk[1:], m.id[:])
+	binary.BigEndian.PutUint32(tk[1+len(m.id):], i)
+	return TKey(tk)
+}
+
+// wrapChunking returns a store where values larger than maxBytes are split into chunks
+// stored under separate keys, with a manifest of the chunks stored under the value's key.
+// Chunks are written before the manifest that references them and deleted after it is
+// overwritten or deleted, so a crash can only leak chunks.  Only ordered stores can be
+// chunked, and the ordered and batch interfaces are preserved.
+func wrapChunking(store dvid.Store, maxBytes int) (dvid.Store, error) {
+	db, ok := store.(OrderedKeyValueDB)
+	if !ok {
+		return nil, fmt.Errorf("store %s isn't an ordered key-value store", store)
+	}
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("bad maximum value size %d for chunking store %s", maxBytes, store)
+	}
+	s := &chunkStore{OrderedKeyValueDB: db, maxBytes: maxBytes}
+	if batcher, ok := store.(KeyValueBatcher); ok {
+		return &chunkBatchStore{s, batcher}, nil
+	}
+	return s, nil
+}
+
+type chunkStore struct {
+	OrderedKeyValueDB
+	maxBytes int
+
+	// Writes of single keys hold a read lock while range deletions, which compare
+	// the manifests in the range before and after deletion, hold the write lock.
+	mu sync.RWMutex
+
+	keyLocks [dedupStripes]sync.Mutex // serialize reads of old values and their replacement.
+}
+
+// split returns the stored form of a value, writing its chunks if it's too large.
+func (s *chunkStore) split(v []byte) ([]byte, error) {
+	if len(v) <= s.maxBytes {
+		return v, nil
+	}
+	var m chunkManifest
+	if _, err := rand.Read(m.id[:]); err != nil {
+		return nil, err
+	}
+	m.size = uint64(len(v))
+	var ctx rawKeyContext
+	for beg := 0; beg < len(v); beg += s.maxBytes {
+		end := beg + s.maxBytes
+		if end > len(v) {
+			end = len(v)
+		}
+		if err := s.OrderedKeyValueDB.Put(ctx, m.chunkKey(m.numChunks), v[beg:end]); err != nil {
+			if derr := s.deleteChunks(m.bytes()); derr != nil {
+				dvid.Errorf("unable to delete chunks after failed write: %v\n", derr)
+			}
+			return nil, err
+		}
+		m.numChunks++
+	}
+	return m.bytes(), nil
+}
+
+// deleteChunks deletes the chunks of a stored value if it's a manifest.
+func (s *chunkStore) deleteChunks(stored []byte) error {
+	m, isManifest := parseManifest(stored)
+	if !isManifest {
+		return nil
+	}
+	var ctx rawKeyContext
+	for i := uint32(0); i < m.numChunks; i++ {
+		if err := s.OrderedKeyValueDB.Delete(ctx, m.chunkKey(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// join returns the value for a stored form.
+func (s *chunkStore) join(stored []byte) ([]byte, error) {
+	m, isManifest := parseManifest(stored)
+	if !isManifest {
+		return stored, nil
+	}
+	var ctx rawKeyContext
+	v := make([]byte, 0, m.size)
+	for i := uint32(0); i < m.numChunks; i++ {
+		chunk, err := s.OrderedKeyValueDB.Get(ctx, m.chunkKey(i))
+		if err != nil {
+			return nil, err
+		}
+		if chunk == nil {
+			return nil, fmt.Errorf("missing chunk %d of %d for value %x", i, m.numChunks, m.id)
+		}
+		v = append(v, chunk...)
+	}
+	if uint64(len(v)) != m.size {
+		return nil, fmt.Errorf("chunked value %x has %d bytes, expected %d", m.id, len(v), m.size)
+	}
+	return v, nil
+}
+
+// write replaces or, if del is true, deletes the value at the key.  The passed function
+// does the write given the stored form of the new value.
+func (s *chunkStore) write(k Key, v []byte, del bool, f func(stored []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lock := &s.keyLocks[keyStripe(k)]
+	lock.Lock()
+	defer lock.Unlock()
+
+	var ctx rawKeyContext
+	old, err := s.OrderedKeyValueDB.Get(ctx, TKey(k))
+	if err != nil {
+		return err
+	}
+	var stored []byte
+	if !del {
+		if stored, err = s.split(v); err != nil {
+			return err
+		}
+	}
+	if err := f(stored); err != nil {
+		if derr := s.deleteChunks(stored); derr != nil {
+			dvid.Errorf("unable to delete chunks after failed write: %v\n", derr)
+		}
+		return err
+	}
+	return s.deleteChunks(old)
+}
+
+func (s *chunkStore) Get(ctx Context, tk TKey) ([]byte, error) {
+	v, err := s.OrderedKeyValueDB.Get(ctx, tk)
+	if err != nil || v == nil {
+		return v, err
+	}
+	return s.join(v)
+}
+
+func (s *chunkStore) GetRange(ctx Context, kStart, kEnd TKey) ([]*TKeyValue, error) {
+	kvs, err := s.OrderedKeyValueDB.GetRange(ctx, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range kvs {
+		if kv.V, err = s.join(kv.V); err != nil {
+			return nil, err
+		}
+	}
+	return kvs, nil
+}
+
+func (s *chunkStore) ProcessRange(ctx Context, kStart, kEnd TKey, op *ChunkOp, f ChunkFunc) error {
+	return s.OrderedKeyValueDB.ProcessRange(ctx, kStart, kEnd, op, func(c *Chunk) error {
+		if c != nil && c.TKeyValue != nil {
+			v, err := s.join(c.V)
+			if err != nil {
+				return err
+			}
+			c.TKeyValue = &TKeyValue{K: c.K, V: v}
+		}
+		return f(c)
+	})
+}
+
+// RawRangeQuery joins chunked values and hides the keys holding chunks.
+func (s *chunkStore) RawRangeQuery(ctx context.Context, kStart, kEnd Key, keysOnly bool, out chan *KeyValue) error {
+	in := make(chan *KeyValue, cap(out))
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for kv := range in {
+			var joined *KeyValue
+			if kv != nil {
+				if err != nil || (len(kv.K) != 0 && kv.K[0] == chunkKeyPrefix) {
+					continue
+				}
+				joined = kv
+				if !keysOnly {
+					v, jerr := s.join(kv.V)
+					if jerr != nil {
+						err = jerr
+						continue
+					}
+					joined = &KeyValue{K: kv.K, V: v}
+				}
+			}
+			select {
+			case out <- joined:
+			case <-ctx.Done():
+			}
+			if kv == nil {
+				break
+			}
+		}
+		done <- err
+	}()
+	err := s.OrderedKeyValueDB.RawRangeQuery(ctx, kStart, kEnd, keysOnly, in)
+	close(in)
+	if derr := <-done; err == nil {
+		err = derr
+	}
+	return err
+}
+
+func (s *chunkStore) Put(ctx Context, tk TKey, v []byte) error {
+	return s.write(ctx.ConstructKey(tk), v, false, func(stored []byte) error {
+		return s.OrderedKeyValueDB.Put(ctx, tk, stored)
+	})
+}
+
+func (s *chunkStore) Delete(ctx Context, tk TKey) error {
+	return s.write(ctx.ConstructKey(tk), nil, true, func([]byte) error {
+		return s.OrderedKeyValueDB.Delete(ctx, tk)
+	})
+}
+
+func (s *chunkStore) RawPut(k Key, v []byte) error {
+	return s.write(k, v, false, func(stored []byte) error {
+		return s.OrderedKeyValueDB.RawPut(k, stored)
+	})
+}
+
+func (s *chunkStore) RawDelete(k Key) error {
+	return s.write(k, nil, true, func([]byte) error {
+		return s.OrderedKeyValueDB.RawDelete(k)
+	})
+}
+
+// PutRange writes each key-value pair in turn since old chunks must be deleted per key.
+func (s *chunkStore) PutRange(ctx Context, kvs []TKeyValue) error {
+	for _, kv := range kvs {
+		if err := s.Put(ctx, kv.K, kv.V); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *chunkStore) DeleteRange(ctx Context, kStart, kEnd TKey) error {
+	beg, end := ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd)
+	if r, ok := tkeyRange(ctx, kStart); ok {
+		beg = r.Beg
+	}
+	if r, ok := tkeyRange(ctx, kEnd); ok {
+		end = r.End
+	}
+	return s.deleteRangeChunks(beg, end, func() error {
+		return s.OrderedKeyValueDB.DeleteRange(ctx, kStart, kEnd)
+	})
+}
+
+func (s *chunkStore) DeleteAll(ctx Context, allVersions bool) error {
+	beg, end := ctx.KeyRange()
+	return s.deleteRangeChunks(beg, end, func() error {
+		return s.OrderedKeyValueDB.DeleteAll(ctx, allVersions)
+	})
+}
+
+// deleteRangeChunks deletes the chunks of values in the inclusive range that are deleted
+// or changed by the passed deletion.  The engine decides which versions are deleted, so
+// manifests are compared before and after the deletion.
+func (s *chunkStore) deleteRangeChunks(beg, end Key, deletion func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, err := s.rangeManifests(beg, end)
+	if err != nil {
+		return err
+	}
+	if err := deletion(); err != nil {
+		return err
+	}
+	if len(before) == 0 {
+		return nil
+	}
+	after, err := s.rangeManifests(beg, end)
+	if err != nil {
+		return err
+	}
+	for k, m := range before {
+		if m2, found := after[k]; found && m2 == m {
+			continue
+		}
+		if err := s.deleteChunks(m.bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangeManifests returns the manifests stored in the inclusive range.
+func (s *chunkStore) rangeManifests(beg, end Key) (map[string]chunkManifest, error) {
+	manifests := make(map[string]chunkManifest)
+	ch := make(chan *KeyValue, 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queryErr := make(chan error, 1)
+	go func() {
+		queryErr <- s.OrderedKeyValueDB.RawRangeQuery(ctx, beg, end, false, ch)
+		close(ch)
+	}()
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		if m, isManifest := parseManifest(kv.V); isManifest {
+			manifests[string(kv.K)] = m
+		}
+	}
+	for range ch {
+	}
+	return manifests, <-queryErr
+}
+
+type chunkBatchStore struct {
+	*chunkStore
+	batcher KeyValueBatcher
+}
+
+func (s *chunkBatchStore) NewBatch(ctx Context) Batch {
+	return &chunkBatch{ctx: ctx, s: s.chunkStore, batch: s.batcher.NewBatch(ctx), ops: make(map[string]*chunkOp)}
+}
+
+type chunkOp struct {
+	tk  TKey
+	v   []byte
+	del bool
+}
+
+// chunkBatch defers splitting values until Commit, when only the last write to each
+// key is applied.  Chunks are written outside the batch before it's committed.
+type chunkBatch struct {
+	ctx   Context
+	s     *chunkStore
+	batch Batch
+	ops   map[string]*chunkOp // keyed by full key
+}
+
+func (b *chunkBatch) Put(tk TKey, v []byte) {
+	b.ops[string(b.ctx.ConstructKey(tk))] = &chunkOp{tk: tk, v: v}
+}
+
+func (b *chunkBatch) Delete(tk TKey) {
+	b.ops[string(b.ctx.ConstructKey(tk))] = &chunkOp{tk: tk, del: true}
+}
+
+func (b *chunkBatch) Commit() error {
+	s := b.s
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Lock the stripes of all keys in order to avoid deadlock.
+	stripeSet := make(map[int]struct{})
+	for k := range b.ops {
+		stripeSet[keyStripe(Key(k))] = struct{}{}
+	}
+	stripes := make([]int, 0, len(stripeSet))
+	for stripe := range stripeSet {
+		stripes = append(stripes, stripe)
+	}
+	sort.Ints(stripes)
+	for _, stripe := range stripes {
+		s.keyLocks[stripe].Lock()
+		defer s.keyLocks[stripe].Unlock()
+	}
+
+	var ctx rawKeyContext
+	var olds, news [][]byte
+	undo := func() {
+		for _, stored := range news {
+			if err := s.deleteChunks(stored); err != nil {
+				dvid.Errorf("unable to delete chunks after failed batch: %v\n", err)
+			}
+		}
+	}
+	for k, op := range b.ops {
+		old, err := s.OrderedKeyValueDB.Get(ctx, TKey(k))
+		if err != nil {
+			undo()
+			return err
+		}
+		olds = append(olds, old)
+		if op.del {
+			b.batch.Delete(op.tk)
+			continue
+		}
+		stored, err := s.split(op.v)
+		if err != nil {
+			undo()
+			return err
+		}
+		news = append(news, stored)
+		b.batch.Put(op.tk, stored)
+	}
+	if err := b.batch.Commit(); err != nil {
+		undo()
+		return err
+	}
+	for _, old := range olds {
+		if err := s.deleteChunks(old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// chunkCount returns the number of chunks stored.
+func (db *testKVStore) chunkCount() int {
+	db.Lock()
+	defer db.Unlock()
+	var n int
+	for k := range db.kv {
+		if k[0] == chunkKeyPrefix {
+			n++
+		}
+	}
+	return n
+}
+
+func TestChunkStore(t *testing.T) {
+	mem := &testKVStore{kv: make(map[string][]byte)}
+	store, err := wrapChunking(mem, 10)
+	if err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	db := store.(OrderedKeyValueDB)
+	var ctx MetadataContext
+
+	large := []byte("a value split across three chunks")
+	if err := db.Put(ctx, TKey("large"), large); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if err := db.Put(ctx, TKey("small"), []byte("tiny")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if n := mem.chunkCount(); n != 4 {
+		t.Fatalf("expected 4 chunks, got %d\n", n)
+	}
+	checkValue(t, db, ctx, "large", large)
+	checkValue(t, db, ctx, "small", []byte("tiny"))
+
+	// Overwriting should delete the old chunks.
+	larger := bytes.Repeat([]byte("0123456789"), 5)
+	if err := db.Put(ctx, TKey("large"), larger); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if n := mem.chunkCount(); n != 5 {
+		t.Fatalf("expected 5 chunks after overwrite, got %d\n", n)
+	}
+	checkValue(t, db, ctx, "large", larger)
+
+	// Raw range queries should join values and hide chunks.
+	ch := make(chan *KeyValue, 10)
+	if err := db.RawRangeQuery(context.Background(), Key{0}, Key{0xFF}, false, ch); err != nil {
+		t.Fatalf("error on raw range query: %v\n", err)
+	}
+	var numKV int
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		numKV++
+		if string(kv.K) == string(ctx.ConstructKey(TKey("large"))) && !bytes.Equal(kv.V, larger) {
+			t.Fatalf("bad value from raw range query: %q\n", kv.V)
+		}
+	}
+	if numKV != 2 {
+		t.Fatalf("expected 2 key-value pairs from raw range query, got %d\n", numKV)
+	}
+
+	// Deleting should remove all chunks.
+	if err := db.Put(ctx, TKey("large2"), large); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if err := db.Delete(ctx, TKey("large")); err != nil {
+		t.Fatalf("error on delete: %v\n", err)
+	}
+	if n := mem.chunkCount(); n != 4 {
+		t.Fatalf("expected 4 chunks after delete, got %d\n", n)
+	}
+	if err := db.DeleteRange(ctx, TKey("large"), TKey("large9")); err != nil {
+		t.Fatalf("error on delete range: %v\n", err)
+	}
+	if n := mem.chunkCount(); n != 0 {
+		t.Fatalf("expected no chunks after range deletion, got %d\n", n)
+	}
+	if len(mem.kv) != 1 {
+		t.Fatalf("expected only the small value to remain, got %d key-value pairs\n", len(mem.kv))
+	}
+}
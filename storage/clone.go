@@ -0,0 +1,889 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// CloneConfig handles settings for copy-on-write cloning of data instances.
+type CloneConfig struct {
+	Stores []Alias // aliases of stores whose data instances can be cloned.
+}
+
+// number of lock stripes for keys of cloned instances.
+const cloneStripes = 256
+
+// cloneWhiteout is stored in a clone to hide a key of its parent.
+var cloneWhiteout = []byte("\x00dvid-clone-whiteout\x00")
+
+func isWhiteout(v []byte) bool {
+	return bytes.Equal(v, cloneWhiteout)
+}
+
+// cloneLinkKey holds the parent of a cloned instance.
+func cloneLinkKey(id dvid.InstanceID) Key {
+	return Key(append([]byte{cloneKeyPrefix}, id.Bytes()...))
+}
+
+// isDataKey returns true if the key holds data of an instance.
+func isDataKey(k Key) bool {
+	return len(k) >= 1+dvid.InstanceIDSize && k[0] == dataKeyPrefix
+}
+
+// rekey returns a copy of a data key for another instance.
+func rekey(k Key, id dvid.InstanceID) Key {
+	k2 := make(Key, len(k))
+	copy(k2, k)
+	copy(k2[1:1+dvid.InstanceIDSize], id.Bytes())
+	return k2
+}
+
+// instanceRange returns the inclusive range of all data keys of an instance.
+func instanceRange(id dvid.InstanceID) (beg, end Key) {
+	beg = Key(append([]byte{dataKeyPrefix}, id.Bytes()...))
+	end = Key(append(append([]byte{dataKeyPrefix}, id.Bytes()...), bytes.Repeat([]byte{0xFF}, 256)...))
+	return
+}
+
+// wrapClone returns a store where a data instance can be cloned by linking it to its
+// source, the parent, instead of copying keys.  Reads of a clone merge its own keys with
+// those of its ancestors.  Before a write to a parent, the old values of changed keys are
+// copied into any clone that doesn't have its own value, and deletions within a clone
+// store whiteouts that hide its parent's keys.  Raw range queries and writes aren't merged,
+// so stores migrated or restored from backups must also be cloning stores.  Only ordered
+// stores can be cloned, and the ordered and batch interfaces are preserved.
+func wrapClone(store dvid.Store) (dvid.Store, error) {
+	db, ok := store.(OrderedKeyValueDB)
+	if !ok {
+		return nil, fmt.Errorf("store %s isn't an ordered key-value store", store)
+	}
+	s := &cloneStore{
+		OrderedKeyValueDB: db,
+		parents:           make(map[dvid.InstanceID]dvid.InstanceID),
+		children:          make(map[dvid.InstanceID][]dvid.InstanceID),
+	}
+	if err := s.loadLinks(); err != nil {
+		return nil, err
+	}
+	if batcher, ok := store.(KeyValueBatcher); ok {
+		return &cloneBatchStore{s, batcher}, nil
+	}
+	return s, nil
+}
+
+type cloneStore struct {
+	OrderedKeyValueDB
+
+	// Operations hold a read lock while cloning and deletion of whole instances, which
+	// change links, hold the write lock.
+	mu       sync.RWMutex
+	parents  map[dvid.InstanceID]dvid.InstanceID
+	children map[dvid.InstanceID][]dvid.InstanceID
+
+	// Stripes are chosen ignoring the instance, so writes to a key of an instance and
+	// copies of the old value into its clones are serialized.
+	keyLocks [cloneStripes]sync.Mutex
+}
+
+func (s *cloneStore) loadLinks() error {
+	src := s.iterate(Key{cloneKeyPrefix}, Key{cloneKeyPrefix, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	defer src.close()
+	for {
+		kv, err := src.next()
+		if err != nil {
+			return err
+		}
+		if kv == nil {
+			return nil
+		}
+		if len(kv.K) != 1+dvid.InstanceIDSize || len(kv.V) != dvid.InstanceIDSize {
+			return fmt.Errorf("bad clone link %x -> %x", kv.K, kv.V)
+		}
+		s.link(dvid.InstanceIDFromBytes(kv.K[1:]), dvid.InstanceIDFromBytes(kv.V))
+	}
+}
+
+func (s *cloneStore) link(id, parent dvid.InstanceID) {
+	s.parents[id] = parent
+	s.children[parent] = append(s.children[parent], id)
+}
+
+func (s *cloneStore) unlink(id dvid.InstanceID) {
+	parent, found := s.parents[id]
+	if !found {
+		return
+	}
+	delete(s.parents, id)
+	siblings := s.children[parent]
+	for i, child := range siblings {
+		if child == id {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(s.children, parent)
+	} else {
+		s.children[parent] = siblings
+	}
+}
+
+// involved returns true if the instance of the key has a parent or clones.
+func (s *cloneStore) involved(k Key) bool {
+	if !isDataKey(k) {
+		return false
+	}
+	id := keyInstance(k)
+	_, isClone := s.parents[id]
+	return isClone || len(s.children[id]) != 0
+}
+
+// isClone returns true if the instance of the key has a parent.
+func (s *cloneStore) isClone(k Key) bool {
+	if !isDataKey(k) {
+		return false
+	}
+	id := keyInstance(k)
+	_, isClone := s.parents[id]
+	return isClone
+}
+
+func cloneStripe(k Key) int {
+	h := fnv.New32a()
+	if isDataKey(k) {
+		h.Write(k[:1])
+		h.Write(k[1+dvid.InstanceIDSize:])
+	} else {
+		h.Write(k)
+	}
+	return int(h.Sum32() % cloneStripes)
+}
+
+// lockKeys locks the stripes of the keys in order to avoid deadlock and returns the
+// function that unlocks them.
+func (s *cloneStore) lockKeys(keys []Key) func() {
+	stripeSet := make(map[int]struct{}, len(keys))
+	for _, k := range keys {
+		stripeSet[cloneStripe(k)] = struct{}{}
+	}
+	stripes := make([]int, 0, len(stripeSet))
+	for stripe := range stripeSet {
+		stripes = append(stripes, stripe)
+	}
+	sort.Ints(stripes)
+	for _, stripe := range stripes {
+		s.keyLocks[stripe].Lock()
+	}
+	return func() {
+		for _, stripe := range stripes {
+			s.keyLocks[stripe].Unlock()
+		}
+	}
+}
+
+// kvSource returns key-value pairs in key order, and nil when done.
+type kvSource interface {
+	next() (*KeyValue, error)
+	close()
+}
+
+// rangeIter streams the key-value pairs of a raw range query of the wrapped store.
+type rangeIter struct {
+	ch     chan *KeyValue
+	cancel context.CancelFunc
+	errc   chan error
+	done   bool
+	err    error
+}
+
+func (s *cloneStore) iterate(beg, end Key) *rangeIter {
+	ctx, cancel := context.WithCancel(context.Background())
+	it := &rangeIter{ch: make(chan *KeyValue, 1000), cancel: cancel, errc: make(chan error, 1)}
+	go func() {
+		it.errc <- s.OrderedKeyValueDB.RawRangeQuery(ctx, beg, end, false, it.ch)
+		close(it.ch)
+	}()
+	return it
+}
+
+func (it *rangeIter) next() (*KeyValue, error) {
+	if it.done {
+		return nil, it.err
+	}
+	if kv, ok := <-it.ch; ok && kv != nil {
+		return kv, nil
+	}
+	it.finish()
+	return nil, it.err
+}
+
+func (it *rangeIter) finish() {
+	for range it.ch {
+	}
+	it.done = true
+	it.err = <-it.errc
+}
+
+func (it *rangeIter) close() {
+	it.cancel()
+	if !it.done {
+		it.finish()
+	}
+}
+
+// mergeIter merges the key-value pairs of a clone with those of its parent, whose keys
+// are converted to the clone's instance.  The clone's pairs override its parent's.
+type mergeIter struct {
+	own, parent  kvSource
+	id           dvid.InstanceID
+	ownKV, parKV *KeyValue
+	started      bool
+}
+
+func (m *mergeIter) advanceOwn() (err error) {
+	m.ownKV, err = m.own.next()
+	return
+}
+
+func (m *mergeIter) advanceParent() error {
+	kv, err := m.parent.next()
+	if err != nil {
+		return err
+	}
+	m.parKV = nil
+	if kv != nil {
+		m.parKV = &KeyValue{K: rekey(kv.K, m.id), V: kv.V}
+	}
+	return nil
+}
+
+func (m *mergeIter) next() (*KeyValue, error) {
+	if !m.started {
+		m.started = true
+		if err := m.advanceOwn(); err != nil {
+			return nil, err
+		}
+		if err := m.advanceParent(); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case m.ownKV == nil && m.parKV == nil:
+		return nil, nil
+	case m.parKV == nil || (m.ownKV != nil && bytes.Compare(m.ownKV.K, m.parKV.K) <= 0):
+		kv := m.ownKV
+		if m.parKV != nil && bytes.Equal(kv.K, m.parKV.K) {
+			if err := m.advanceParent(); err != nil {
+				return nil, err
+			}
+		}
+		return kv, m.advanceOwn()
+	default:
+		kv := m.parKV
+		return kv, m.advanceParent()
+	}
+}
+
+func (m *mergeIter) close() {
+	m.own.close()
+	m.parent.close()
+}
+
+// source returns the key-value pairs in an inclusive range of one instance's data keys
+// as seen by that instance, including whiteouts.
+func (s *cloneStore) source(beg, end Key) kvSource {
+	it := s.iterate(beg, end)
+	id := keyInstance(beg)
+	parent, isClone := s.parents[id]
+	if !isClone {
+		return it
+	}
+	return &mergeIter{own: it, parent: s.source(rekey(beg, parent), rekey(end, parent)), id: id}
+}
+
+// lookup returns the value of a data key as seen by its instance, including whiteouts.
+func (s *cloneStore) lookup(k Key) (v []byte, found bool, err error) {
+	src := s.source(k, k)
+	defer src.close()
+	kv, err := src.next()
+	if err != nil || kv == nil {
+		return nil, false, err
+	}
+	return kv.V, true, nil
+}
+
+// rawFound returns true if the key is stored in the wrapped store.
+func (s *cloneStore) rawFound(k Key) (bool, error) {
+	it := s.iterate(k, k)
+	defer it.close()
+	kv, err := it.next()
+	return kv != nil, err
+}
+
+// visible calls f for each key-value pair visible through the context within the range
+// of type-specific keys, resolving versions like the engines do.
+func (s *cloneStore) visible(ctx Context, kStart, kEnd TKey, f func(*KeyValue) error) error {
+	vctx, versioned := ctx.(VersionedCtx)
+	versioned = versioned && ctx.Versioned()
+	beg, end := ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd)
+	if versioned {
+		var err error
+		if beg, err = vctx.MinVersionKey(kStart); err != nil {
+			return err
+		}
+		if end, err = vctx.MaxVersionKey(kEnd); err != nil {
+			return err
+		}
+	}
+	src := s.source(beg, end)
+	defer src.close()
+
+	var unv Key
+	var values []*KeyValue
+	flush := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		values = nil
+		if err != nil || kv == nil {
+			return err
+		}
+		return f(kv)
+	}
+	for {
+		kv, err := src.next()
+		if err != nil {
+			return err
+		}
+		if kv == nil {
+			break
+		}
+		if isWhiteout(kv.V) {
+			continue
+		}
+		if !versioned {
+			if err := f(kv); err != nil {
+				return err
+			}
+			continue
+		}
+		u, _, err := SplitKey(kv.K)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(u, unv) {
+			if err := flush(); err != nil {
+				return err
+			}
+			unv = u
+		}
+		values = append(values, kv)
+	}
+	return flush()
+}
+
+// visibleTKeys returns the type-specific keys visible through the context within the range.
+func (s *cloneStore) visibleTKeys(ctx Context, kStart, kEnd TKey) ([]TKey, error) {
+	var tks []TKey
+	err := s.visible(ctx, kStart, kEnd, func(kv *KeyValue) error {
+		tk, err := TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		tks = append(tks, tk)
+		return nil
+	})
+	return tks, err
+}
+
+// preserve copies the current value of an instance's key, or a whiteout if there's no
+// value, into each of its clones that doesn't have its own value, so the clones are
+// unchanged by a write to the key.
+func (s *cloneStore) preserve(k Key) error {
+	id := keyInstance(k)
+	children := s.children[id]
+	if len(children) == 0 {
+		return nil
+	}
+	v, found, err := s.lookup(k)
+	if err != nil {
+		return err
+	}
+	if !found {
+		v = cloneWhiteout
+	}
+	for _, child := range children {
+		ck := rekey(k, child)
+		hasOwn, err := s.rawFound(ck)
+		if err != nil {
+			return err
+		}
+		if hasOwn {
+			continue
+		}
+		if err := s.OrderedKeyValueDB.RawPut(ck, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hide stores a whiteout for a clone's key that was removed by a write if the key would
+// otherwise be inherited from its parent.
+func (s *cloneStore) hide(k Key) error {
+	id := keyInstance(k)
+	parent, isClone := s.parents[id]
+	if !isClone {
+		return nil
+	}
+	hasOwn, err := s.rawFound(k)
+	if err != nil || hasOwn {
+		return err
+	}
+	v, found, err := s.lookup(rekey(k, parent))
+	if err != nil || !found || isWhiteout(v) {
+		return err
+	}
+	return s.OrderedKeyValueDB.RawPut(k, cloneWhiteout)
+}
+
+// write does a write that might change the given keys of an instance with a parent or
+// clones.  The keys are preserved for any clones before the write and hidden afterwards
+// if the write removed them from a clone.
+func (s *cloneStore) write(keys []Key, f func() error) error {
+	unlock := s.lockKeys(keys)
+	defer unlock()
+	for _, k := range keys {
+		if err := s.preserve(k); err != nil {
+			return err
+		}
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := s.hide(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeKeys returns the full keys that might be changed by a write of a type-specific key,
+// or nil if its instance has no parent or clones.
+func (s *cloneStore) writeKeys(ctx Context, tk TKey) []Key {
+	k := ctx.ConstructKey(tk)
+	if !s.involved(k) {
+		return nil
+	}
+	keys := []Key{k}
+	if vctx, ok := ctx.(VersionedCtx); ok && ctx.Versioned() {
+		keys = append(keys, vctx.TombstoneKey(tk))
+	}
+	return keys
+}
+
+// CloneInstance makes the destination instance, which must not have any data, a clone of
+// the source instance.  Only a link is written, and keys are copied as either instance
+// changes.
+func (s *cloneStore) CloneInstance(src, dst dvid.InstanceID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if src == dst {
+		return fmt.Errorf("can't clone instance %d to itself", src)
+	}
+	if _, isClone := s.parents[dst]; isClone || len(s.children[dst]) != 0 {
+		return fmt.Errorf("can't clone to instance %d, which is already cloned", dst)
+	}
+	beg, end := instanceRange(dst)
+	it := s.iterate(beg, end)
+	kv, err := it.next()
+	it.close()
+	if err != nil {
+		return err
+	}
+	if kv != nil {
+		return fmt.Errorf("can't clone to instance %d, which already has data", dst)
+	}
+	if err := s.OrderedKeyValueDB.RawPut(cloneLinkKey(dst), src.Bytes()); err != nil {
+		return err
+	}
+	s.link(dst, src)
+	dvid.Infof("Cloned instance %d to instance %d\n", src, dst)
+	return nil
+}
+
+func (s *cloneStore) Get(ctx Context, tk TKey) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k := ctx.ConstructKey(tk)
+	if !s.isClone(k) {
+		return s.OrderedKeyValueDB.Get(ctx, tk)
+	}
+	unlock := s.lockKeys([]Key{k})
+	defer unlock()
+	var v []byte
+	err := s.visible(ctx, tk, tk, func(kv *KeyValue) error {
+		if vtk, err := TKeyFromKey(kv.K); err == nil && bytes.Equal(vtk, tk) {
+			v = kv.V
+		}
+		return nil
+	})
+	return v, err
+}
+
+// Exists checks the merged view of clones, which might hide a parent's key by a whiteout.
+func (s *cloneStore) Exists(ctx Context, tk TKey) (bool, error) {
+	s.mu.RLock()
+	isClone := s.isClone(ctx.ConstructKey(tk))
+	s.mu.RUnlock()
+	if !isClone {
+		return s.OrderedKeyValueDB.Exists(ctx, tk)
+	}
+	v, err := s.Get(ctx, tk)
+	return v != nil, err
+}
+
+func (s *cloneStore) GetRange(ctx Context, kStart, kEnd TKey) ([]*TKeyValue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.isClone(ctx.ConstructKey(kStart)) {
+		return s.OrderedKeyValueDB.GetRange(ctx, kStart, kEnd)
+	}
+	var kvs []*TKeyValue
+	err := s.visible(ctx, kStart, kEnd, func(kv *KeyValue) error {
+		tk, err := TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		kvs = append(kvs, &TKeyValue{K: tk, V: kv.V})
+		return nil
+	})
+	return kvs, err
+}
+
+func (s *cloneStore) KeysInRange(ctx Context, kStart, kEnd TKey) ([]TKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.isClone(ctx.ConstructKey(kStart)) {
+		return s.OrderedKeyValueDB.KeysInRange(ctx, kStart, kEnd)
+	}
+	return s.visibleTKeys(ctx, kStart, kEnd)
+}
+
+func (s *cloneStore) SendKeysInRange(ctx Context, kStart, kEnd TKey, ch KeyChan) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.isClone(ctx.ConstructKey(kStart)) {
+		return s.OrderedKeyValueDB.SendKeysInRange(ctx, kStart, kEnd, ch)
+	}
+	err := s.visible(ctx, kStart, kEnd, func(kv *KeyValue) error {
+		ch <- kv.K
+		return nil
+	})
+	ch <- nil
+	return err
+}
+
+func (s *cloneStore) ProcessRange(ctx Context, kStart, kEnd TKey, op *ChunkOp, f ChunkFunc) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.isClone(ctx.ConstructKey(kStart)) {
+		return s.OrderedKeyValueDB.ProcessRange(ctx, kStart, kEnd, op, f)
+	}
+	f = CancelableChunkFunc(ctx, f)
+	return s.visible(ctx, kStart, kEnd, func(kv *KeyValue) error {
+		tk, err := TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		return f(&Chunk{op, &TKeyValue{K: tk, V: kv.V}})
+	})
+}
+
+func (s *cloneStore) put(ctx Context, tk TKey, v []byte) error {
+	keys := s.writeKeys(ctx, tk)
+	if keys == nil {
+		return s.OrderedKeyValueDB.Put(ctx, tk, v)
+	}
+	return s.write(keys, func() error {
+		return s.OrderedKeyValueDB.Put(ctx, tk, v)
+	})
+}
+
+func (s *cloneStore) del(ctx Context, tk TKey) error {
+	keys := s.writeKeys(ctx, tk)
+	if keys == nil {
+		return s.OrderedKeyValueDB.Delete(ctx, tk)
+	}
+	return s.write(keys, func() error {
+		return s.OrderedKeyValueDB.Delete(ctx, tk)
+	})
+}
+
+func (s *cloneStore) Put(ctx Context, tk TKey, v []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.put(ctx, tk, v)
+}
+
+func (s *cloneStore) Delete(ctx Context, tk TKey) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.del(ctx, tk)
+}
+
+func (s *cloneStore) RawPut(k Key, v []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.involved(k) {
+		return s.OrderedKeyValueDB.RawPut(k, v)
+	}
+	return s.write([]Key{k}, func() error {
+		return s.OrderedKeyValueDB.RawPut(k, v)
+	})
+}
+
+func (s *cloneStore) RawDelete(k Key) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.involved(k) {
+		return s.OrderedKeyValueDB.RawDelete(k)
+	}
+	return s.write([]Key{k}, func() error {
+		return s.OrderedKeyValueDB.RawDelete(k)
+	})
+}
+
+func (s *cloneStore) PutRange(ctx Context, kvs []TKeyValue) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(kvs) == 0 || !s.involved(ctx.ConstructKey(kvs[0].K)) {
+		return s.OrderedKeyValueDB.PutRange(ctx, kvs)
+	}
+	for _, kv := range kvs {
+		if err := s.put(ctx, kv.K, kv.V); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteRange deletes each key visible in the range like the engines do, so the keys a
+// clone inherits are hidden and the old values are preserved for any clones.
+func (s *cloneStore) DeleteRange(ctx Context, kStart, kEnd TKey) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.involved(ctx.ConstructKey(kStart)) {
+		return s.OrderedKeyValueDB.DeleteRange(ctx, kStart, kEnd)
+	}
+	tks, err := s.visibleTKeys(ctx, kStart, kEnd)
+	if err != nil {
+		return err
+	}
+	for _, tk := range tks {
+		if err := s.del(ctx, tk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *cloneStore) DeleteAll(ctx Context, allVersions bool) error {
+	beg, _ := ctx.KeyRange()
+	if allVersions {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.involved(beg) {
+			return s.OrderedKeyValueDB.DeleteAll(ctx, allVersions)
+		}
+		return s.deleteInstance(ctx)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.involved(beg) {
+		return s.OrderedKeyValueDB.DeleteAll(ctx, allVersions)
+	}
+	return s.deleteVersion(ctx)
+}
+
+// deleteVersion deletes each key of the context's version seen by its instance.
+func (s *cloneStore) deleteVersion(ctx Context) error {
+	beg, _ := ctx.KeyRange()
+	id := keyInstance(beg)
+	beg, end := instanceRange(id)
+	var keys []Key
+	src := s.source(beg, end)
+	for {
+		kv, err := src.next()
+		if err != nil {
+			src.close()
+			return err
+		}
+		if kv == nil {
+			break
+		}
+		if _, v, _, err := DataKeyToLocalIDs(kv.K); err == nil && v == ctx.VersionID() && !isWhiteout(kv.V) {
+			keys = append(keys, kv.K)
+		}
+	}
+	src.close()
+	for _, k := range keys {
+		k := k
+		if err := s.write([]Key{k}, func() error { return s.OrderedKeyValueDB.RawDelete(k) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteInstance deletes all versions of an instance, first copying its keys into any
+// clones lacking their own values and relinking the clones to the instance's parent.
+func (s *cloneStore) deleteInstance(ctx Context) error {
+	beg, _ := ctx.KeyRange()
+	id := keyInstance(beg)
+	parent, isClone := s.parents[id]
+	children := append([]dvid.InstanceID{}, s.children[id]...)
+	for _, child := range children {
+		if err := s.copyRaw(id, child, isClone); err != nil {
+			return err
+		}
+		if isClone {
+			if err := s.OrderedKeyValueDB.RawPut(cloneLinkKey(child), parent.Bytes()); err != nil {
+				return err
+			}
+		} else {
+			if err := s.OrderedKeyValueDB.RawDelete(cloneLinkKey(child)); err != nil {
+				return err
+			}
+			if err := s.removeWhiteouts(child); err != nil {
+				return err
+			}
+		}
+		s.unlink(child)
+		if isClone {
+			s.link(child, parent)
+		}
+	}
+	if isClone {
+		if err := s.OrderedKeyValueDB.RawDelete(cloneLinkKey(id)); err != nil {
+			return err
+		}
+		s.unlink(id)
+	}
+	return s.OrderedKeyValueDB.DeleteAll(ctx, true)
+}
+
+// copyRaw copies the stored keys of an instance into a clone that lacks its own values.
+// Whiteouts are only copied if the clone will still have a parent.
+func (s *cloneStore) copyRaw(id, child dvid.InstanceID, whiteouts bool) error {
+	beg, end := instanceRange(id)
+	src := s.iterate(beg, end)
+	defer src.close()
+	for {
+		kv, err := src.next()
+		if err != nil || kv == nil {
+			return err
+		}
+		if !whiteouts && isWhiteout(kv.V) {
+			continue
+		}
+		ck := rekey(kv.K, child)
+		hasOwn, err := s.rawFound(ck)
+		if err != nil {
+			return err
+		}
+		if hasOwn {
+			continue
+		}
+		if err := s.OrderedKeyValueDB.RawPut(ck, kv.V); err != nil {
+			return err
+		}
+	}
+}
+
+// removeWhiteouts deletes the whiteouts of an instance that no longer has a parent.
+func (s *cloneStore) removeWhiteouts(id dvid.InstanceID) error {
+	beg, end := instanceRange(id)
+	var keys []Key
+	src := s.iterate(beg, end)
+	for {
+		kv, err := src.next()
+		if err != nil {
+			src.close()
+			return err
+		}
+		if kv == nil {
+			break
+		}
+		if isWhiteout(kv.V) {
+			keys = append(keys, kv.K)
+		}
+	}
+	src.close()
+	for _, k := range keys {
+		if err := s.OrderedKeyValueDB.RawDelete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type cloneBatchStore struct {
+	*cloneStore
+	batcher KeyValueBatcher
+}
+
+func (s *cloneBatchStore) NewBatch(ctx Context) Batch {
+	return &cloneBatch{ctx: ctx, s: s}
+}
+
+type cloneOp struct {
+	tk  TKey
+	v   []byte
+	del bool
+}
+
+// cloneBatch defers the batch until Commit, when it is known whether the instance has
+// a parent or clones.
+type cloneBatch struct {
+	ctx Context
+	s   *cloneBatchStore
+	ops []cloneOp
+}
+
+func (b *cloneBatch) Put(tk TKey, v []byte) {
+	b.ops = append(b.ops, cloneOp{tk: tk, v: v})
+}
+
+func (b *cloneBatch) Delete(tk TKey) {
+	b.ops = append(b.ops, cloneOp{tk: tk, del: true})
+}
+
+func (b *cloneBatch) Commit() error {
+	s := b.s
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	batch := s.batcher.NewBatch(b.ctx)
+	var keys []Key
+	for _, op := range b.ops {
+		keys = append(keys, s.writeKeys(b.ctx, op.tk)...)
+		if op.del {
+			batch.Delete(op.tk)
+		} else {
+			batch.Put(op.tk, op.v)
+		}
+	}
+	if len(keys) == 0 {
+		return batch.Commit()
+	}
+	return s.write(keys, batch.Commit)
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func (db *testKVStore) DeleteAll(ctx Context, allVersions bool) error {
+	beg, end := ctx.KeyRange()
+	for _, k := range db.sortedKeys(beg, end) {
+		db.RawDelete(Key(k))
+	}
+	return nil
+}
+
+func checkValue(t *testing.T, db OrderedKeyValueDB, ctx Context, tk string, expected []byte) {
+	v, err := db.Get(ctx, TKey(tk))
+	if err != nil {
+		t.Fatalf("error on get of %q: %v\n", tk, err)
+	}
+	if !bytes.Equal(v, expected) {
+		t.Fatalf("expected %q for %q, got %q\n", expected, tk, v)
+	}
+}
+
+func TestCloneStore(t *testing.T) {
+	mem := &testKVStore{kv: make(map[string][]byte)}
+	store, err := wrapClone(mem)
+	if err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	db := store.(OrderedKeyValueDB)
+	src := NewDataContext(&testData{instanceID: 1}, 1)
+	dst := NewDataContext(&testData{instanceID: 2}, 1)
+
+	if err := db.Put(src, TKey("a"), []byte("a0")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if err := db.Put(src, TKey("b"), []byte("b0")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if err := CloneInstance(store, 1, 2); err != nil {
+		t.Fatalf("unable to clone instance: %v\n", err)
+	}
+	if err := CloneInstance(store, 1, 2); err == nil {
+		t.Fatalf("expected error cloning to an instance that is already cloned\n")
+	}
+	if len(mem.kv) != 3 {
+		t.Fatalf("expected only a link to be written by cloning, got %d key-value pairs\n", len(mem.kv))
+	}
+	checkValue(t, db, dst, "a", []byte("a0"))
+	checkValue(t, db, dst, "b", []byte("b0"))
+
+	// Writes to either instance shouldn't change the other.
+	if err := db.Put(src, TKey("a"), []byte("a1")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if err := db.Put(src, TKey("c"), []byte("c1")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if err := db.Put(dst, TKey("b"), []byte("b2")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if err := db.Delete(dst, TKey("a")); err != nil {
+		t.Fatalf("error on delete: %v\n", err)
+	}
+	checkValue(t, db, src, "a", []byte("a1"))
+	checkValue(t, db, src, "b", []byte("b0"))
+	checkValue(t, db, dst, "a", nil)
+	checkValue(t, db, dst, "b", []byte("b2"))
+	checkValue(t, db, dst, "c", nil)
+	for _, tc := range []struct {
+		ctx    Context
+		tk     string
+		exists bool
+	}{{src, "c", true}, {dst, "a", false}, {dst, "b", true}, {dst, "c", false}} {
+		found, err := db.Exists(tc.ctx, TKey(tc.tk))
+		if err != nil {
+			t.Fatalf("error on exists of %q: %v\n", tc.tk, err)
+		}
+		if found != tc.exists {
+			t.Fatalf("expected exists %t for %q, got %t\n", tc.exists, tc.tk, found)
+		}
+	}
+
+	tks, err := db.KeysInRange(dst, TKey("a"), TKey("z"))
+	if err != nil {
+		t.Fatalf("error on keys in range: %v\n", err)
+	}
+	if len(tks) != 1 || string(tks[0]) != "b" {
+		t.Fatalf("expected only key %q in clone, got %v\n", "b", tks)
+	}
+
+	// Links should persist.
+	reopened, err := wrapClone(mem)
+	if err != nil {
+		t.Fatalf("unable to rewrap store: %v\n", err)
+	}
+	if parent := reopened.(*cloneStore).parents[2]; parent != 1 {
+		t.Fatalf("expected clone of instance 1 after reopening store, got parent %d\n", parent)
+	}
+
+	// Deleting the source should leave the clone intact and without whiteouts.
+	if err := db.Put(src, TKey("d"), []byte("d1")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if err := db.DeleteAll(src, true); err != nil {
+		t.Fatalf("error on delete all: %v\n", err)
+	}
+	checkValue(t, db, src, "a", nil)
+	checkValue(t, db, dst, "a", nil)
+	checkValue(t, db, dst, "b", []byte("b2"))
+	checkValue(t, db, dst, "d", nil)
+	if len(mem.kv) != 1 {
+		t.Fatalf("expected only the clone's value to remain, got %d key-value pairs\n", len(mem.kv))
+	}
+}
@@ -0,0 +1,116 @@
+// +build !clustered,!gcloud
+
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// CompactionConfig handles settings for scheduled compaction of stores.
+type CompactionConfig struct {
+	Hours  int     // Hours between scheduled compactions.  No scheduled compaction if 0.
+	Stores []Alias // aliases of stores to compact.  If empty, all stores that support compaction.
+}
+
+// compactionT serializes compactions and runs any scheduled compaction.
+type compactionT struct {
+	sync.Mutex
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// schedule starts periodic compaction of the given stores.
+func (c *compactionT) schedule(interval time.Duration, aliases []Alias) {
+	c.done = make(chan struct{})
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, alias := range aliases {
+					if err := CompactStore(alias); err != nil {
+						dvid.Errorf("Scheduled compaction: %v\n", err)
+					}
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// stop ends any scheduled compaction, waiting for a running compaction to finish.
+func (c *compactionT) stop() {
+	if c.done != nil {
+		close(c.done)
+		c.wg.Wait()
+		c.done = nil
+	}
+}
+
+// setupCompaction validates the compaction configuration and schedules compaction if
+// an interval was given.
+func setupCompaction(config CompactionConfig) error {
+	aliases := config.Stores
+	if len(aliases) == 0 {
+		for alias, store := range manager.engines {
+			if _, ok := store.(Compacter); ok {
+				aliases = append(aliases, alias)
+			}
+		}
+	}
+	for _, alias := range aliases {
+		store, found := manager.engines[alias]
+		if !found {
+			return fmt.Errorf("compaction specified for unknown store %q", alias)
+		}
+		if _, ok := store.(Compacter); !ok {
+			return fmt.Errorf("compaction specified for store %q, which does not support compaction", alias)
+		}
+	}
+	if config.Hours <= 0 || len(aliases) == 0 {
+		return nil
+	}
+	dvid.Infof("Compacting stores %v every %d hours\n", aliases, config.Hours)
+	manager.compaction.schedule(time.Duration(config.Hours)*time.Hour, aliases)
+	return nil
+}
+
+// CompactStore compacts all keys in the store with the given alias.  This can take a
+// long time for large stores, and only one compaction runs at a time.
+func CompactStore(alias Alias) error {
+	return compactStore(alias, AllKeys)
+}
+
+// CompactInstance compacts the keys of a data instance in the store with the given alias,
+// e.g., to reclaim space after the data instance has been deleted.
+func CompactInstance(alias Alias, instance dvid.InstanceID) error {
+	return compactStore(alias, DataKeyRange(instance))
+}
+
+func compactStore(alias Alias, r KeyRange) error {
+	if !manager.setup {
+		return fmt.Errorf("Storage manager not initialized before requesting compaction of store %q", alias)
+	}
+	store, found := manager.engines[alias]
+	if !found {
+		return fmt.Errorf("could not find store with alias %q in TOML config file", alias)
+	}
+	manager.compaction.Lock()
+	defer manager.compaction.Unlock()
+
+	dvid.Infof("Compacting store %q keys %x to %x...\n", alias, r.Start, r.OpenEnd)
+	start := time.Now()
+	if err := Compact(store, r); err != nil {
+		return fmt.Errorf("unable to compact store %q: %v", alias, err)
+	}
+	dvid.Infof("Compacted store %q in %s\n", alias, time.Since(start))
+	return nil
+}
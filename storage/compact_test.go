@@ -0,0 +1,91 @@
+// +build !clustered,!gcloud
+
+package storage
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// testCompactStore is a store that records the ranges it's asked to compact.
+type testCompactStore struct {
+	*testKVStore
+	mu     sync.Mutex
+	ranges []KeyRange
+}
+
+func (db *testCompactStore) Compact(r KeyRange) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.ranges = append(db.ranges, r)
+	return nil
+}
+
+func (db *testCompactStore) numCompactions() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return len(db.ranges)
+}
+
+func TestCompaction(t *testing.T) {
+	compactable := &testCompactStore{testKVStore: &testKVStore{kv: make(map[string][]byte)}}
+	manager.setup = true
+	manager.engines = map[Alias]dvid.Store{
+		"compactable": compactable,
+		"plain":       &testKVStore{kv: make(map[string][]byte)},
+	}
+	defer func() {
+		manager.compaction.stop()
+		manager.engines = nil
+		manager.setup = false
+	}()
+
+	if err := CompactStore("compactable"); err != nil {
+		t.Fatalf("error compacting store: %v\n", err)
+	}
+	if err := CompactInstance("compactable", 3); err != nil {
+		t.Fatalf("error compacting instance: %v\n", err)
+	}
+	expected := []KeyRange{AllKeys, DataKeyRange(3)}
+	if !reflect.DeepEqual(compactable.ranges, expected) {
+		t.Errorf("expected compacted ranges %v, got %v\n", expected, compactable.ranges)
+	}
+	if err := CompactStore("plain"); err == nil {
+		t.Errorf("expected error compacting store without compaction support\n")
+	}
+	if err := CompactStore("unknown"); err == nil {
+		t.Errorf("expected error compacting unknown store\n")
+	}
+
+	if err := setupCompaction(CompactionConfig{Hours: 1, Stores: []Alias{"plain"}}); err == nil {
+		t.Errorf("expected error scheduling compaction of store without compaction support\n")
+	}
+	if err := setupCompaction(CompactionConfig{Hours: 1, Stores: []Alias{"unknown"}}); err == nil {
+		t.Errorf("expected error scheduling compaction of unknown store\n")
+	}
+	if err := setupCompaction(CompactionConfig{}); err != nil {
+		t.Errorf("expected no error without scheduled compaction, got %v\n", err)
+	}
+	if manager.compaction.done != nil {
+		t.Errorf("expected no compaction scheduled without an interval\n")
+	}
+
+	manager.compaction.schedule(10*time.Millisecond, []Alias{"compactable"})
+	deadline := time.Now().Add(5 * time.Second)
+	for compactable.numCompactions() < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	manager.compaction.stop()
+	n := compactable.numCompactions()
+	if n < 4 {
+		t.Fatalf("expected scheduled compactions, got %d compactions\n", n)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if compactable.numCompactions() != n {
+		t.Errorf("expected no compactions after stopping schedule\n")
+	}
+}
@@ -6,6 +6,7 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -49,8 +50,8 @@ type Context interface {
 	// String prints a description of the Context
 	String() string
 
-	// Returns a sync.Mutex specific to this context.
-	Mutex() *sync.Mutex
+	// Returns a lock specific to this context.
+	Mutex() RWLocker
 
 	// Versioned is true if this Context is also a VersionedCtx.
 	Versioned() bool
@@ -112,6 +113,24 @@ type RequestCtx interface {
 
 	// SetRequestID sets a string identifier.
 	SetRequestID(id string)
+
+	// GetContext returns the context.Context of the request or nil if none has been set.
+	GetContext() context.Context
+
+	// SetContext sets the context.Context of the request, which allows storage
+	// operations to be stopped when the request is cancelled.
+	SetContext(c context.Context)
+}
+
+// RequestContext returns the context.Context of the request associated with a storage
+// Context, or context.Background() if there is none.
+func RequestContext(ctx Context) context.Context {
+	if reqCtx, ok := ctx.(RequestCtx); ok {
+		if c := reqCtx.GetContext(); c != nil {
+			return c
+		}
+	}
+	return context.Background()
 }
 
 const (
@@ -158,6 +177,18 @@ func TKeyFromKey(key Key) (TKey, error) {
 	}
 }
 
+// DataKeyClass returns the TKeyClass of a full key constructed by a DataContext.  An error is
+// returned if the key is not a data key or is too short to hold a type-specific key class.
+func DataKeyClass(key Key) (TKeyClass, error) {
+	if len(key) == 0 || key[0] != dataKeyPrefix {
+		return 0, fmt.Errorf("Cannot extract type-specific key class from non-DataContext key")
+	}
+	if len(key) < 2+dvid.InstanceIDSize {
+		return 0, fmt.Errorf("Cannot extract type-specific key class from DataKey that is only %d bytes", len(key))
+	}
+	return TKeyClass(key[1+dvid.InstanceIDSize]), nil
+}
+
 // SplitKey returns key components depending on whether the passed Key
 // is a metadata or data key.  If metadata, it returns the key and a 0 version id.
 // If it is a data key, it returns the unversioned portion of the Key and the
@@ -193,17 +224,15 @@ func (ctx *DataContext) SplitKey(tk TKey) (Key, []byte, error) {
 	return Key(unvKey), verKey, nil
 }
 
-var contextMutexes map[mutexID]*sync.Mutex
-
-func init() {
-	contextMutexes = make(map[mutexID]*sync.Mutex)
-}
-
 // ---- Context implementations -----
 
 const (
 	metadataKeyPrefix byte = iota
 	dataKeyPrefix
+	mutationKeyPrefix // index of mutated key ranges when tracking mutations
+	dedupKeyPrefix    // content-addressed values and reference counts when deduplicating
+	cloneKeyPrefix    // parents of data instances cloned copy-on-write
+	chunkKeyPrefix    // chunks of values split because they're too large for a store
 )
 
 // MetadataContext is an implementation of Context for MetadataContext persistence.
@@ -238,9 +267,9 @@ func (ctx MetadataContext) KeyRange() (min, max Key) {
 	return []byte{metadataKeyPrefix}, []byte{dataKeyPrefix}
 }
 
-var metadataMutex sync.Mutex
+var metadataMutex sync.RWMutex
 
-func (ctx MetadataContext) Mutex() *sync.Mutex {
+func (ctx MetadataContext) Mutex() RWLocker {
 	return &metadataMutex
 }
 
@@ -287,6 +316,7 @@ type DataContext struct {
 	version dvid.VersionID
 	client  dvid.ClientID
 	reqID   string
+	reqCtx  context.Context
 }
 
 // NewDataContext provides a way for datatypes to create a Context that adheres to DVID
@@ -294,7 +324,7 @@ type DataContext struct {
 // only be implemented within package storage, we force compatible implementations to embed
 // DataContext and initialize it via this function.
 func NewDataContext(data dvid.Data, versionID dvid.VersionID) *DataContext {
-	return &DataContext{data, versionID, 0, ""}
+	return &DataContext{data, versionID, 0, "", nil}
 }
 
 func (ctx *DataContext) UpdateInstance(k Key) error {
@@ -337,6 +367,16 @@ func (ctx *DataContext) SetRequestID(id string) {
 	ctx.reqID = id
 }
 
+// GetContext returns the context.Context of the request or nil if none has been set.
+func (ctx *DataContext) GetContext() context.Context {
+	return ctx.reqCtx
+}
+
+// SetContext sets the context.Context of the request.
+func (ctx *DataContext) SetContext(c context.Context) {
+	ctx.reqCtx = c
+}
+
 // ---- storage.Context implementation
 
 func (ctx *DataContext) implementsOpaque() {}
@@ -475,24 +515,9 @@ func (ctx *DataContext) RequestID() string {
 	return ""
 }
 
-type mutexID struct {
-	instance dvid.InstanceID
-	version  dvid.VersionID
-}
-
-var dataMutex sync.Mutex
-
-func (ctx *DataContext) Mutex() *sync.Mutex {
-	dataMutex.Lock()
-	defer dataMutex.Unlock()
-
-	id := mutexID{ctx.data.InstanceID(), ctx.version}
-	mu, found := contextMutexes[id]
-	if !found {
-		mu = new(sync.Mutex)
-		contextMutexes[id] = mu
-	}
-	return mu
+// Mutex returns the lock for the context's data instance and version.
+func (ctx *DataContext) Mutex() RWLocker {
+	return NewNodeMutex(ctx.data.InstanceID(), ctx.version)
 }
 
 func (ctx *DataContext) String() string {
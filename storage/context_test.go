@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/janelia-flyem/dvid/dvid"
 )
@@ -175,3 +176,64 @@ func TestUnversionedKey(t *testing.T) {
 		t.Errorf("Expected version id of data key from using context to be 3, got %d\n", v3)
 	}
 }
+
+func TestDataKeyClass(t *testing.T) {
+	var mctx MetadataContext
+	tk := NewTKey(0x2A, []byte{0x01, 0x02})
+	if _, err := DataKeyClass(mctx.ConstructKey(tk)); err == nil {
+		t.Errorf("Expected error getting data key class from metadata key\n")
+	}
+
+	dctx := GetTestDataContext(TestUUID1, "mydata", 13)
+	class, err := DataKeyClass(dctx.ConstructKey(tk))
+	if err != nil {
+		t.Fatalf("Error getting data key class: %v\n", err)
+	}
+	if class != 0x2A {
+		t.Errorf("Expected data key class 0x2A, got %v\n", class)
+	}
+
+	// The instance range keys are too short to hold a class.
+	minKey, _ := dctx.KeyRange()
+	if _, err := DataKeyClass(minKey); err == nil {
+		t.Errorf("Expected error getting data key class from instance range key\n")
+	}
+}
+
+func TestNodeMutex(t *testing.T) {
+	data := &testData{name: "test", instanceID: 13}
+	ctx := NewDataContext(data, 1)
+	mu := ctx.Mutex()
+	mu.Lock()
+
+	// A different version isn't blocked.
+	other := NewDataContext(data, 2).Mutex()
+	other.RLock()
+	other.RLock()
+	other.RUnlock()
+	other.RUnlock()
+
+	locked := make(chan struct{})
+	go func() {
+		NewDataContext(data, 1).Mutex().Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("node locked twice\n")
+	case <-time.After(50 * time.Millisecond):
+	}
+	mu.Unlock()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatalf("node not locked after unlock\n")
+	}
+	if n := lockedNodes(); n != 1 {
+		t.Errorf("expected 1 node in lock table, got %d\n", n)
+	}
+	mu.Unlock()
+	if n := lockedNodes(); n != 0 {
+		t.Errorf("expected lock table to be empty after unlocking, got %d nodes\n", n)
+	}
+}
@@ -0,0 +1,521 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+
+	"github.com/zeebo/blake3"
+)
+
+// DedupConfig handles settings for content-addressed deduplication of stored values.
+type DedupConfig struct {
+	MinBytes int     `toml:"min_bytes"` // values smaller than this are stored inline.
+	Stores   []Alias // aliases of stores whose values are deduplicated.
+}
+
+// DefaultDedupMinBytes is used if a minimum size for deduplicated values isn't set.
+const DefaultDedupMinBytes = 512
+
+// Stored values of a deduplicating store are tagged by their first byte.  Empty values,
+// e.g., tombstones written by the engines, are stored as-is.
+const (
+	dedupInline byte = 0 // followed by the value
+	dedupRef    byte = 1 // followed by the blake3 hash of the value
+)
+
+// number of lock stripes for keys and content hashes.
+const dedupStripes = 256
+
+// rawKeyContext is an unversioned context whose TKeys are full keys, which allows exact
+// keys to be read and written through a Context regardless of versioning.
+type rawKeyContext struct {
+	MetadataContext
+}
+
+func (ctx rawKeyContext) ConstructKey(tk TKey) Key {
+	return Key(tk)
+}
+
+func (ctx rawKeyContext) ConstructKeyVersion(tk TKey, version dvid.VersionID) Key {
+	return Key(tk)
+}
+
+func (ctx rawKeyContext) SplitKey(tk TKey) (Key, []byte, error) {
+	return Key(tk), []byte{}, nil
+}
+
+func (ctx rawKeyContext) String() string {
+	return "Raw Key Context"
+}
+
+type dedupHash [32]byte
+
+// contentKey holds the deduplicated value with the given hash.
+func (h dedupHash) contentKey() TKey {
+	return TKey(append(append([]byte{dedupKeyPrefix}, h[:]...), 'v'))
+}
+
+// countKey holds the number of references to the deduplicated value.
+func (h dedupHash) countKey() TKey {
+	return TKey(append(append([]byte{dedupKeyPrefix}, h[:]...), 'c'))
+}
+
+// wrapDedup returns a store where values of at least minBytes are replaced by a reference
+// to a single reference-counted copy keyed by the value's hash, so identical values, e.g.,
+// blocks unchanged across versions or repeated empty blocks, are stored once.  Counts
+// are incremented before a reference is written and decremented after it is overwritten
+// or deleted, so a crash can only leak values.  Only ordered stores can be deduplicated,
+// and the ordered and batch interfaces are preserved.
+func wrapDedup(store dvid.Store, minBytes int) (dvid.Store, error) {
+	db, ok := store.(OrderedKeyValueDB)
+	if !ok {
+		return nil, fmt.Errorf("store %s isn't an ordered key-value store", store)
+	}
+	if minBytes <= 0 {
+		minBytes = DefaultDedupMinBytes
+	}
+	s := &dedupStore{OrderedKeyValueDB: db, minBytes: minBytes}
+	if batcher, ok := store.(KeyValueBatcher); ok {
+		return &dedupBatchStore{s, batcher}, nil
+	}
+	return s, nil
+}
+
+type dedupStore struct {
+	OrderedKeyValueDB
+	minBytes int
+
+	// Writes of single keys hold a read lock while range deletions, which compare
+	// the references in the range before and after deletion, hold the write lock.
+	mu sync.RWMutex
+
+	keyLocks  [dedupStripes]sync.Mutex // serialize reads of old values and their replacement.
+	hashLocks [dedupStripes]sync.Mutex // serialize reference count updates.
+}
+
+func keyStripe(k Key) int {
+	h := fnv.New32a()
+	h.Write(k)
+	return int(h.Sum32() % dedupStripes)
+}
+
+// ref returns the stored form of a value, adding a reference if it's deduplicated.
+func (s *dedupStore) ref(v []byte) ([]byte, error) {
+	if len(v) == 0 {
+		return v, nil
+	}
+	if len(v) < s.minBytes {
+		return append([]byte{dedupInline}, v...), nil
+	}
+	h := dedupHash(blake3.Sum256(v))
+	lock := &s.hashLocks[h[0]]
+	lock.Lock()
+	defer lock.Unlock()
+
+	var ctx rawKeyContext
+	n, err := s.count(h)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		if err := s.OrderedKeyValueDB.Put(ctx, h.contentKey(), v); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.putCount(h, n+1); err != nil {
+		return nil, err
+	}
+	return append([]byte{dedupRef}, h[:]...), nil
+}
+
+// unref removes a reference held by a stored value, deleting the deduplicated value
+// when no references remain.
+func (s *dedupStore) unref(stored []byte) error {
+	h, isRef := refHash(stored)
+	if !isRef {
+		return nil
+	}
+	lock := &s.hashLocks[h[0]]
+	lock.Lock()
+	defer lock.Unlock()
+
+	var ctx rawKeyContext
+	n, err := s.count(h)
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		return s.putCount(h, n-1)
+	}
+	// Delete the count first so a crash leaves an unreferenced value that is simply
+	// overwritten if referenced again.
+	if err := s.OrderedKeyValueDB.Delete(ctx, h.countKey()); err != nil {
+		return err
+	}
+	return s.OrderedKeyValueDB.Delete(ctx, h.contentKey())
+}
+
+func (s *dedupStore) count(h dedupHash) (uint64, error) {
+	var ctx rawKeyContext
+	v, err := s.OrderedKeyValueDB.Get(ctx, h.countKey())
+	if err != nil {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+func (s *dedupStore) putCount(h dedupHash, n uint64) error {
+	var ctx rawKeyContext
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, n)
+	return s.OrderedKeyValueDB.Put(ctx, h.countKey(), buf)
+}
+
+func refHash(stored []byte) (h dedupHash, isRef bool) {
+	if len(stored) != 1+len(h) || stored[0] != dedupRef {
+		return
+	}
+	copy(h[:], stored[1:])
+	return h, true
+}
+
+// resolve returns the value for a stored form.
+func (s *dedupStore) resolve(stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return stored, nil
+	}
+	switch stored[0] {
+	case dedupInline:
+		return stored[1:], nil
+	case dedupRef:
+		h, isRef := refHash(stored)
+		if !isRef {
+			return nil, fmt.Errorf("bad deduplicated value reference of %d bytes", len(stored))
+		}
+		var ctx rawKeyContext
+		v, err := s.OrderedKeyValueDB.Get(ctx, h.contentKey())
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, fmt.Errorf("missing deduplicated value %x", h)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("bad deduplicated value tag %d", stored[0])
+	}
+}
+
+// write replaces or, if del is true, deletes the value at the key.  The passed function
+// does the write given the stored form of the new value.
+func (s *dedupStore) write(k Key, v []byte, del bool, f func(stored []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lock := &s.keyLocks[keyStripe(k)]
+	lock.Lock()
+	defer lock.Unlock()
+
+	var ctx rawKeyContext
+	old, err := s.OrderedKeyValueDB.Get(ctx, TKey(k))
+	if err != nil {
+		return err
+	}
+	var stored []byte
+	if !del {
+		if stored, err = s.ref(v); err != nil {
+			return err
+		}
+	}
+	if err := f(stored); err != nil {
+		if uerr := s.unref(stored); uerr != nil {
+			dvid.Errorf("unable to remove reference after failed write: %v\n", uerr)
+		}
+		return err
+	}
+	return s.unref(old)
+}
+
+func (s *dedupStore) Get(ctx Context, tk TKey) ([]byte, error) {
+	v, err := s.OrderedKeyValueDB.Get(ctx, tk)
+	if err != nil || v == nil {
+		return v, err
+	}
+	return s.resolve(v)
+}
+
+func (s *dedupStore) GetRange(ctx Context, kStart, kEnd TKey) ([]*TKeyValue, error) {
+	kvs, err := s.OrderedKeyValueDB.GetRange(ctx, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range kvs {
+		if kv.V, err = s.resolve(kv.V); err != nil {
+			return nil, err
+		}
+	}
+	return kvs, nil
+}
+
+func (s *dedupStore) ProcessRange(ctx Context, kStart, kEnd TKey, op *ChunkOp, f ChunkFunc) error {
+	return s.OrderedKeyValueDB.ProcessRange(ctx, kStart, kEnd, op, func(c *Chunk) error {
+		if c != nil && c.TKeyValue != nil {
+			v, err := s.resolve(c.V)
+			if err != nil {
+				return err
+			}
+			c.TKeyValue = &TKeyValue{K: c.K, V: v}
+		}
+		return f(c)
+	})
+}
+
+// RawRangeQuery resolves values and hides the keys holding deduplicated values.
+func (s *dedupStore) RawRangeQuery(ctx context.Context, kStart, kEnd Key, keysOnly bool, out chan *KeyValue) error {
+	in := make(chan *KeyValue, cap(out))
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for kv := range in {
+			var resolved *KeyValue
+			if kv != nil {
+				if err != nil || (len(kv.K) != 0 && kv.K[0] == dedupKeyPrefix) {
+					continue
+				}
+				resolved = kv
+				if !keysOnly {
+					v, rerr := s.resolve(kv.V)
+					if rerr != nil {
+						err = rerr
+						continue
+					}
+					resolved = &KeyValue{K: kv.K, V: v}
+				}
+			}
+			select {
+			case out <- resolved:
+			case <-ctx.Done():
+			}
+			if kv == nil {
+				break
+			}
+		}
+		done <- err
+	}()
+	err := s.OrderedKeyValueDB.RawRangeQuery(ctx, kStart, kEnd, keysOnly, in)
+	close(in)
+	if derr := <-done; err == nil {
+		err = derr
+	}
+	return err
+}
+
+func (s *dedupStore) Put(ctx Context, tk TKey, v []byte) error {
+	return s.write(ctx.ConstructKey(tk), v, false, func(stored []byte) error {
+		return s.OrderedKeyValueDB.Put(ctx, tk, stored)
+	})
+}
+
+func (s *dedupStore) Delete(ctx Context, tk TKey) error {
+	return s.write(ctx.ConstructKey(tk), nil, true, func([]byte) error {
+		return s.OrderedKeyValueDB.Delete(ctx, tk)
+	})
+}
+
+func (s *dedupStore) RawPut(k Key, v []byte) error {
+	return s.write(k, v, false, func(stored []byte) error {
+		return s.OrderedKeyValueDB.RawPut(k, stored)
+	})
+}
+
+func (s *dedupStore) RawDelete(k Key) error {
+	return s.write(k, nil, true, func([]byte) error {
+		return s.OrderedKeyValueDB.RawDelete(k)
+	})
+}
+
+// PutRange writes each key-value pair in turn since references must be counted per key.
+func (s *dedupStore) PutRange(ctx Context, kvs []TKeyValue) error {
+	for _, kv := range kvs {
+		if err := s.Put(ctx, kv.K, kv.V); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *dedupStore) DeleteRange(ctx Context, kStart, kEnd TKey) error {
+	beg, end := ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd)
+	if r, ok := tkeyRange(ctx, kStart); ok {
+		beg = r.Beg
+	}
+	if r, ok := tkeyRange(ctx, kEnd); ok {
+		end = r.End
+	}
+	return s.deleteRefs(beg, end, func() error {
+		return s.OrderedKeyValueDB.DeleteRange(ctx, kStart, kEnd)
+	})
+}
+
+func (s *dedupStore) DeleteAll(ctx Context, allVersions bool) error {
+	beg, end := ctx.KeyRange()
+	return s.deleteRefs(beg, end, func() error {
+		return s.OrderedKeyValueDB.DeleteAll(ctx, allVersions)
+	})
+}
+
+// deleteRefs removes the references held by keys in the inclusive range that are deleted
+// or changed by the passed deletion.  The engine decides which versions are deleted, so
+// references are compared before and after the deletion.
+func (s *dedupStore) deleteRefs(beg, end Key, deletion func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, err := s.rangeRefs(beg, end)
+	if err != nil {
+		return err
+	}
+	if err := deletion(); err != nil {
+		return err
+	}
+	if len(before) == 0 {
+		return nil
+	}
+	after, err := s.rangeRefs(beg, end)
+	if err != nil {
+		return err
+	}
+	for k, h := range before {
+		if h2, found := after[k]; found && h2 == h {
+			continue
+		}
+		if err := s.unref(append([]byte{dedupRef}, h[:]...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangeRefs returns the hashes referenced by keys in the inclusive range.
+func (s *dedupStore) rangeRefs(beg, end Key) (map[string]dedupHash, error) {
+	refs := make(map[string]dedupHash)
+	ch := make(chan *KeyValue, 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queryErr := make(chan error, 1)
+	go func() {
+		queryErr <- s.OrderedKeyValueDB.RawRangeQuery(ctx, beg, end, false, ch)
+		close(ch)
+	}()
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		if h, isRef := refHash(kv.V); isRef {
+			refs[string(kv.K)] = h
+		}
+	}
+	for range ch {
+	}
+	return refs, <-queryErr
+}
+
+type dedupBatchStore struct {
+	*dedupStore
+	batcher KeyValueBatcher
+}
+
+func (s *dedupBatchStore) NewBatch(ctx Context) Batch {
+	return &dedupBatch{ctx: ctx, s: s.dedupStore, batch: s.batcher.NewBatch(ctx), ops: make(map[string]*dedupOp)}
+}
+
+type dedupOp struct {
+	tk  TKey
+	v   []byte
+	del bool
+}
+
+// dedupBatch defers counting references until Commit, when only the last write to each
+// key is applied.
+type dedupBatch struct {
+	ctx   Context
+	s     *dedupStore
+	batch Batch
+	ops   map[string]*dedupOp // keyed by full key
+}
+
+func (b *dedupBatch) Put(tk TKey, v []byte) {
+	b.ops[string(b.ctx.ConstructKey(tk))] = &dedupOp{tk: tk, v: v}
+}
+
+func (b *dedupBatch) Delete(tk TKey) {
+	b.ops[string(b.ctx.ConstructKey(tk))] = &dedupOp{tk: tk, del: true}
+}
+
+func (b *dedupBatch) Commit() error {
+	s := b.s
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Lock the stripes of all keys in order to avoid deadlock.
+	stripeSet := make(map[int]struct{})
+	for k := range b.ops {
+		stripeSet[keyStripe(Key(k))] = struct{}{}
+	}
+	stripes := make([]int, 0, len(stripeSet))
+	for stripe := range stripeSet {
+		stripes = append(stripes, stripe)
+	}
+	sort.Ints(stripes)
+	for _, stripe := range stripes {
+		s.keyLocks[stripe].Lock()
+		defer s.keyLocks[stripe].Unlock()
+	}
+
+	var ctx rawKeyContext
+	var olds, news [][]byte
+	undo := func() {
+		for _, stored := range news {
+			if err := s.unref(stored); err != nil {
+				dvid.Errorf("unable to remove reference after failed batch: %v\n", err)
+			}
+		}
+	}
+	for k, op := range b.ops {
+		old, err := s.OrderedKeyValueDB.Get(ctx, TKey(k))
+		if err != nil {
+			undo()
+			return err
+		}
+		olds = append(olds, old)
+		if op.del {
+			b.batch.Delete(op.tk)
+			continue
+		}
+		stored, err := s.ref(op.v)
+		if err != nil {
+			undo()
+			return err
+		}
+		news = append(news, stored)
+		b.batch.Put(op.tk, stored)
+	}
+	if err := b.batch.Commit(); err != nil {
+		undo()
+		return err
+	}
+	for _, old := range olds {
+		if err := s.unref(old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// testKVStore is an unversioned in-memory store with just the methods used by dedupStore.
+type testKVStore struct {
+	OrderedKeyValueDB
+	sync.Mutex
+	kv map[string][]byte
+}
+
+func (db *testKVStore) String() string {
+	return "test kv store"
+}
+
+func (db *testKVStore) Get(ctx Context, tk TKey) ([]byte, error) {
+	db.Lock()
+	defer db.Unlock()
+	return db.kv[string(ctx.ConstructKey(tk))], nil
+}
+
+func (db *testKVStore) Exists(ctx Context, tk TKey) (bool, error) {
+	db.Lock()
+	defer db.Unlock()
+	_, found := db.kv[string(ctx.ConstructKey(tk))]
+	return found, nil
+}
+
+func (db *testKVStore) Put(ctx Context, tk TKey, v []byte) error {
+	return db.RawPut(ctx.ConstructKey(tk), v)
+}
+
+func (db *testKVStore) Delete(ctx Context, tk TKey) error {
+	return db.RawDelete(ctx.ConstructKey(tk))
+}
+
+func (db *testKVStore) RawPut(k Key, v []byte) error {
+	db.Lock()
+	defer db.Unlock()
+	db.kv[string(k)] = v
+	return nil
+}
+
+func (db *testKVStore) RawDelete(k Key) error {
+	db.Lock()
+	defer db.Unlock()
+	delete(db.kv, string(k))
+	return nil
+}
+
+func (db *testKVStore) sortedKeys(kStart, kEnd Key) []string {
+	db.Lock()
+	defer db.Unlock()
+	var keys []string
+	for k := range db.kv {
+		if bytes.Compare([]byte(k), kStart) >= 0 && bytes.Compare([]byte(k), kEnd) <= 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (db *testKVStore) RawRangeQuery(ctx context.Context, kStart, kEnd Key, keysOnly bool, out chan *KeyValue) error {
+	for _, k := range db.sortedKeys(kStart, kEnd) {
+		db.Lock()
+		v := db.kv[k]
+		db.Unlock()
+		out <- &KeyValue{K: Key(k), V: v}
+	}
+	out <- nil
+	return nil
+}
+
+func (db *testKVStore) DeleteRange(ctx Context, kStart, kEnd TKey) error {
+	for _, k := range db.sortedKeys(ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd)) {
+		db.RawDelete(Key(k))
+	}
+	return nil
+}
+
+// dedupCounts returns the reference count of each deduplicated value in the store.
+func (db *testKVStore) dedupCounts() map[string]uint64 {
+	counts := make(map[string]uint64)
+	db.Lock()
+	defer db.Unlock()
+	for k, v := range db.kv {
+		if k[0] == dedupKeyPrefix && k[len(k)-1] == 'c' {
+			counts[k[1:len(k)-1]] = uint64(v[7])
+		}
+	}
+	return counts
+}
+
+func TestDedupStore(t *testing.T) {
+	mem := &testKVStore{kv: make(map[string][]byte)}
+	store, err := wrapDedup(mem, 8)
+	if err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	db := store.(OrderedKeyValueDB)
+	var ctx MetadataContext
+
+	block := []byte("identical block value")
+	for i := 0; i < 3; i++ {
+		if err := db.Put(ctx, TKey(fmt.Sprintf("block%d", i)), block); err != nil {
+			t.Fatalf("error on put: %v\n", err)
+		}
+	}
+	if err := db.Put(ctx, TKey("small"), []byte("tiny")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	counts := mem.dedupCounts()
+	if len(counts) != 1 {
+		t.Fatalf("expected 1 deduplicated value, got %d\n", len(counts))
+	}
+	for _, n := range counts {
+		if n != 3 {
+			t.Fatalf("expected 3 references, got %d\n", n)
+		}
+	}
+	for _, tk := range []string{"block0", "block2", "small"} {
+		v, err := db.Get(ctx, TKey(tk))
+		if err != nil {
+			t.Fatalf("error on get: %v\n", err)
+		}
+		if tk == "small" && string(v) != "tiny" || tk != "small" && !bytes.Equal(v, block) {
+			t.Fatalf("bad value for %q: %q\n", tk, v)
+		}
+	}
+
+	// Overwriting and deleting should decrement references.
+	if err := db.Put(ctx, TKey("block0"), []byte("a different block value")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if err := db.Put(ctx, TKey("block1"), block); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if err := db.Delete(ctx, TKey("block2")); err != nil {
+		t.Fatalf("error on delete: %v\n", err)
+	}
+	counts = mem.dedupCounts()
+	if len(counts) != 2 {
+		t.Fatalf("expected 2 deduplicated values, got %d\n", len(counts))
+	}
+	for _, n := range counts {
+		if n != 1 {
+			t.Fatalf("expected 1 reference to each value, got %d\n", n)
+		}
+	}
+
+	// Raw range queries should resolve values and hide deduplicated values.
+	ch := make(chan *KeyValue, 10)
+	if err := db.RawRangeQuery(context.Background(), Key{0}, Key{0xFF}, false, ch); err != nil {
+		t.Fatalf("error on raw range query: %v\n", err)
+	}
+	var numKV int
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		numKV++
+		if string(kv.K) == string(ctx.ConstructKey(TKey("block1"))) && !bytes.Equal(kv.V, block) {
+			t.Fatalf("bad value from raw range query: %q\n", kv.V)
+		}
+	}
+	if numKV != 3 {
+		t.Fatalf("expected 3 key-value pairs from raw range query, got %d\n", numKV)
+	}
+
+	// Deleting a range should remove all unreferenced values.
+	if err := db.DeleteRange(ctx, TKey("block"), TKey("block9")); err != nil {
+		t.Fatalf("error on delete range: %v\n", err)
+	}
+	if counts = mem.dedupCounts(); len(counts) != 0 {
+		t.Fatalf("expected no deduplicated values after range deletion, got %d\n", len(counts))
+	}
+	if len(mem.kv) != 1 {
+		t.Fatalf("expected only the small value to remain, got %d key-value pairs\n", len(mem.kv))
+	}
+}
@@ -0,0 +1,724 @@
+// +build dynamodb
+
+/*
+	Package dynamodb implements a storage engine on Amazon DynamoDB so AWS-native
+	deployments can run DVID without managing disks.  It satisfies the KeyValueGetter,
+	KeyValueSetter, KeyValueBatcher, and KeyValueIngestable interfaces but does not
+	provide range queries, so metadata should stay in an ordered key-value store.
+
+	Each DVID key is split (see storage.SplitKey) into a partition key "k" holding the
+	unversioned key and a sort key "s" holding a type byte followed by the version
+	suffix.  All versions of a key share a partition, so a versioned Get is a keys-only
+	query followed by a read of the chosen version.
+
+	DynamoDB limits items to 400 KB.  Larger values are split into chunks: the first chunk
+	is kept in the main item along with the total chunk count "n", and the remaining
+	chunks are written as separate items in the same partition whose sort keys use a
+	different type byte and so are never mistaken for versions.
+
+	Credentials are obtained from the standard AWS provider chain: environment variables,
+	the shared credentials file, or an EC2/ECS instance role.
+*/
+package dynamodb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	api "github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	// MaxChunkSize is the largest value stored in a single item, leaving room under
+	// DynamoDB's 400 KB item limit for keys and attribute names.
+	MaxChunkSize = 384 * 1024
+
+	// MaxBatchWrite is the maximum number of requests in a BatchWriteItem call.
+	MaxBatchWrite = 25
+
+	// MaxBatchRetries is the number of times unprocessed batch items are resent.
+	MaxBatchRetries = 8
+
+	// sort key type bytes
+	versionItem = 'v'
+	chunkItem   = 'c'
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in dynamodb: %v\n", err)
+	}
+	e := Engine{"dynamodb", "Amazon DynamoDB key-value store", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return true
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a DynamoDB store.  The passed Config must contain:
+// "table": name of the table, created with on-demand billing if it doesn't exist
+// Optional settings:
+// "region": AWS region of the table
+// "endpoint": URL of a DynamoDB-compatible service, e.g., DynamoDB Local
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newDynamoDB(config)
+}
+
+func stringSetting(c map[string]interface{}, key string) (string, error) {
+	v, found := c[key]
+	if !found {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%q setting must be a string (%v)", key, v)
+	}
+	return s, nil
+}
+
+func parseConfig(config dvid.StoreConfig) (*DynamoDB, error) {
+	c := config.GetAll()
+	table, err := stringSetting(c, "table")
+	if err != nil {
+		return nil, err
+	}
+	if table == "" {
+		return nil, fmt.Errorf("%q must be specified for dynamodb configuration", "table")
+	}
+	db := &DynamoDB{table: table}
+	if db.region, err = stringSetting(c, "region"); err != nil {
+		return nil, err
+	}
+	if db.endpoint, err = stringSetting(c, "endpoint"); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// newDynamoDB sets up a client and creates the table if it doesn't exist.
+func (e Engine) newDynamoDB(config dvid.StoreConfig) (*DynamoDB, bool, error) {
+	db, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	awsConfig := aws.Config{}
+	if db.region != "" {
+		awsConfig.Region = aws.String(db.region)
+	}
+	if db.endpoint != "" {
+		awsConfig.Endpoint = aws.String(db.endpoint)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to create AWS session for %s: %v", db, err)
+	}
+	db.client = api.New(sess)
+
+	var created bool
+	_, err = db.client.DescribeTable(&api.DescribeTableInput{TableName: aws.String(db.table)})
+	if err != nil {
+		aerr, ok := err.(awserr.Error)
+		if !ok || aerr.Code() != api.ErrCodeResourceNotFoundException {
+			return nil, false, fmt.Errorf("unable to access %s: %v", db, err)
+		}
+		if err = db.createTable(); err != nil {
+			return nil, false, err
+		}
+		created = true
+	}
+	dvid.Infof("Opened %s\n", db)
+	return db, created, nil
+}
+
+func (db *DynamoDB) createTable() error {
+	_, err := db.client.CreateTable(&api.CreateTableInput{
+		TableName: aws.String(db.table),
+		AttributeDefinitions: []*api.AttributeDefinition{
+			{AttributeName: aws.String("k"), AttributeType: aws.String(api.ScalarAttributeTypeB)},
+			{AttributeName: aws.String("s"), AttributeType: aws.String(api.ScalarAttributeTypeB)},
+		},
+		KeySchema: []*api.KeySchemaElement{
+			{AttributeName: aws.String("k"), KeyType: aws.String(api.KeyTypeHash)},
+			{AttributeName: aws.String("s"), KeyType: aws.String(api.KeyTypeRange)},
+		},
+		BillingMode: aws.String(api.BillingModePayPerRequest),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %v", db, err)
+	}
+	dvid.Infof("Waiting for creation of %s...\n", db)
+	return db.client.WaitUntilTableExists(&api.DescribeTableInput{TableName: aws.String(db.table)})
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets dynamodb as the default key-value backend, using the region given by
+// the DVID_TEST_DYNAMODB_REGION environment variable and any endpoint, e.g., of DynamoDB
+// Local, given by DVID_TEST_DYNAMODB_ENDPOINT.  Each test store uses a new table.  The
+// metadata isn't set since it requires range queries.  If another engine is already set,
+// it returns an error since only one key-value backend should be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("dynamodb can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	region := os.Getenv("DVID_TEST_DYNAMODB_REGION")
+	if region == "" {
+		return fmt.Errorf("dynamodb can't be testable key-value without a region in DVID_TEST_DYNAMODB_REGION")
+	}
+	alias := storage.Alias("dynamodb")
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"table":    fmt.Sprintf("dvid-test-%x", uuid.NewV4().Bytes()),
+		"region":   region,
+		"endpoint": os.Getenv("DVID_TEST_DYNAMODB_ENDPOINT"),
+		"testing":  true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "dynamodb"}
+	return nil
+}
+
+// Delete removes the table of a test store.  It's a no-op for other stores since tables
+// are not deleted via DVID.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	testing, _, err := config.GetBool("testing")
+	if err != nil || !testing {
+		return err
+	}
+	db, _, err := e.newDynamoDB(config)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.DeleteTable(&api.DeleteTableInput{TableName: aws.String(db.table)})
+	return err
+}
+
+// DynamoDB is a key-value store backed by a DynamoDB table.
+type DynamoDB struct {
+	table    string
+	region   string
+	endpoint string
+	client   *api.DynamoDB
+}
+
+func (db *DynamoDB) String() string {
+	if db.endpoint != "" {
+		return fmt.Sprintf("dynamodb table %q @ %s", db.table, db.endpoint)
+	}
+	return fmt.Sprintf("dynamodb table %q", db.table)
+}
+
+// Close is a no-op since DynamoDB clients hold no persistent connection.
+func (db *DynamoDB) Close() {}
+
+// Equal returns true if the DynamoDB store matches the given store configuration.
+func (db *DynamoDB) Equal(config dvid.StoreConfig) bool {
+	other, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.table == other.table && db.region == other.region && db.endpoint == other.endpoint
+}
+
+// itemKey returns the primary key attributes of the main item for a full key.
+func itemKey(k storage.Key) (map[string]*api.AttributeValue, error) {
+	unversioned, ver, err := storage.SplitKey(k)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]*api.AttributeValue{
+		"k": {B: unversioned},
+		"s": {B: append([]byte{versionItem}, ver...)},
+	}, nil
+}
+
+// chunkPrefix returns the sort key prefix of all overflow chunks for a full key.
+func chunkPrefix(ver []byte) []byte {
+	return append([]byte{chunkItem}, ver...)
+}
+
+// chunkKey returns the primary key attributes of the i-th chunk for a full key.
+func chunkKey(k storage.Key, i int) (map[string]*api.AttributeValue, error) {
+	unversioned, ver, err := storage.SplitKey(k)
+	if err != nil {
+		return nil, err
+	}
+	sk := make([]byte, 4)
+	binary.BigEndian.PutUint32(sk, uint32(i))
+	return map[string]*api.AttributeValue{
+		"k": {B: unversioned},
+		"s": {B: append(chunkPrefix(ver), sk...)},
+	}, nil
+}
+
+// putRequests returns the write requests that store a value, splitting it into
+// chunks if necessary.  The main item is last so readers never see a chunk count
+// before its overflow chunks are written.
+func putRequests(k storage.Key, v []byte) ([]*api.WriteRequest, error) {
+	numChunks := (len(v) + MaxChunkSize - 1) / MaxChunkSize
+	if numChunks == 0 {
+		numChunks = 1
+	}
+	reqs := make([]*api.WriteRequest, 0, numChunks)
+	for i := numChunks - 1; i >= 0; i-- {
+		var item map[string]*api.AttributeValue
+		var err error
+		if i == 0 {
+			item, err = itemKey(k)
+		} else {
+			item, err = chunkKey(k, i)
+		}
+		if err != nil {
+			return nil, err
+		}
+		end := (i + 1) * MaxChunkSize
+		if end > len(v) {
+			end = len(v)
+		}
+		item["v"] = &api.AttributeValue{B: v[i*MaxChunkSize : end]}
+		if i == 0 && numChunks > 1 {
+			item["n"] = &api.AttributeValue{N: aws.String(strconv.Itoa(numChunks))}
+		}
+		reqs = append(reqs, &api.WriteRequest{PutRequest: &api.PutRequest{Item: item}})
+	}
+	return reqs, nil
+}
+
+// deleteRequests returns the write requests that delete a key and any overflow chunks.
+func (db *DynamoDB) deleteRequests(k storage.Key) ([]*api.WriteRequest, error) {
+	key, err := itemKey(k)
+	if err != nil {
+		return nil, err
+	}
+	reqs := []*api.WriteRequest{{DeleteRequest: &api.DeleteRequest{Key: key}}}
+	_, ver, err := storage.SplitKey(k)
+	if err != nil {
+		return nil, err
+	}
+	err = db.queryPartition(key["k"].B, chunkPrefix(ver), func(sk []byte) {
+		chunk := map[string]*api.AttributeValue{
+			"k": {B: key["k"].B},
+			"s": {B: sk},
+		}
+		reqs = append(reqs, &api.WriteRequest{DeleteRequest: &api.DeleteRequest{Key: chunk}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reqs, nil
+}
+
+// queryPartition calls f on the sort key of each item in the partition whose sort key
+// begins with the given prefix.
+func (db *DynamoDB) queryPartition(unversioned, prefix []byte, f func(sk []byte)) error {
+	input := &api.QueryInput{
+		TableName:              aws.String(db.table),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("#k = :k AND begins_with(#s, :s)"),
+		ProjectionExpression:   aws.String("#s"),
+		ExpressionAttributeNames: map[string]*string{
+			"#k": aws.String("k"),
+			"#s": aws.String("s"),
+		},
+		ExpressionAttributeValues: map[string]*api.AttributeValue{
+			":k": {B: unversioned},
+			":s": {B: prefix},
+		},
+	}
+	return db.client.QueryPages(input, func(page *api.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			if sk, found := item["s"]; found {
+				f(sk.B)
+			}
+		}
+		return true
+	})
+}
+
+// batchWrite sends write requests in groups, retrying unprocessed items with
+// exponential backoff.  Requests within a call must be for distinct items.
+func (db *DynamoDB) batchWrite(reqs []*api.WriteRequest) error {
+	for start := 0; start < len(reqs); start += MaxBatchWrite {
+		end := start + MaxBatchWrite
+		if end > len(reqs) {
+			end = len(reqs)
+		}
+		pending := map[string][]*api.WriteRequest{db.table: reqs[start:end]}
+		backoff := 50 * time.Millisecond
+		for try := 0; len(pending[db.table]) != 0; try++ {
+			if try > MaxBatchRetries {
+				return fmt.Errorf("unable to write %d items to %s after %d retries", len(pending[db.table]), db, MaxBatchRetries)
+			}
+			if try > 0 {
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+			out, err := db.client.BatchWriteItem(&api.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return err
+			}
+			pending = out.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+// getValue returns the value for a full key or nil if it does not exist, reassembling
+// chunked values.
+func (db *DynamoDB) getValue(k storage.Key) ([]byte, error) {
+	key, err := itemKey(k)
+	if err != nil {
+		return nil, err
+	}
+	out, err := db.client.GetItem(&api.GetItemInput{
+		TableName:      aws.String(db.table),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	v := out.Item["v"].B
+	if v == nil {
+		v = []byte{}
+	}
+	n, found := out.Item["n"]
+	if !found || n.N == nil {
+		return v, nil
+	}
+	numChunks, err := strconv.Atoi(*n.N)
+	if err != nil {
+		return nil, fmt.Errorf("bad chunk count %q for key %v in %s", *n.N, k, db)
+	}
+	for i := 1; i < numChunks; i++ {
+		ck, err := chunkKey(k, i)
+		if err != nil {
+			return nil, err
+		}
+		out, err := db.client.GetItem(&api.GetItemInput{
+			TableName:      aws.String(db.table),
+			Key:            ck,
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if out.Item == nil {
+			return nil, fmt.Errorf("missing chunk %d of %d for key %v in %s", i, numChunks, k, db)
+		}
+		v = append(v, out.Item["v"].B...)
+	}
+	return v, nil
+}
+
+// ---- KeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *DynamoDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call Get() on nil DynamoDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	key := ctx.ConstructKey(tk)
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		unversioned, _, err := ctx.SplitKey(tk)
+		if err != nil {
+			return nil, err
+		}
+		var kvs []*storage.KeyValue
+		err = db.queryPartition(unversioned, []byte{versionItem}, func(sk []byte) {
+			k := storage.MergeKey(append([]byte{}, unversioned...), sk[1:])
+			kvs = append(kvs, &storage.KeyValue{K: k})
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(kvs) == 0 {
+			return nil, nil
+		}
+		kv, err := vctx.VersionedKeyValue(kvs)
+		if err != nil || kv == nil {
+			return nil, err
+		}
+		key = kv.K
+	}
+	v, err := db.getValue(key)
+	if err != nil {
+		return nil, err
+	}
+	storage.StoreValueBytesRead <- len(v)
+	return v, nil
+}
+
+// Exists returns true if a value is stored for the key.  Only the keys of items are
+// read, so neither values nor their overflow chunks are transferred.
+func (db *DynamoDB) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists() on nil DynamoDB")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		unversioned, _, err := ctx.SplitKey(tk)
+		if err != nil {
+			return false, err
+		}
+		var kvs []*storage.KeyValue
+		err = db.queryPartition(unversioned, []byte{versionItem}, func(sk []byte) {
+			k := storage.MergeKey(append([]byte{}, unversioned...), sk[1:])
+			kvs = append(kvs, &storage.KeyValue{K: k})
+		})
+		if err != nil || len(kvs) == 0 {
+			return false, err
+		}
+		kv, err := vctx.VersionedKeyValue(kvs)
+		return kv != nil, err
+	}
+	key, err := itemKey(ctx.ConstructKey(tk))
+	if err != nil {
+		return false, err
+	}
+	out, err := db.client.GetItem(&api.GetItemInput{
+		TableName:            aws.String(db.table),
+		Key:                  key,
+		ConsistentRead:       aws.Bool(true),
+		ProjectionExpression: aws.String("#s"),
+		ExpressionAttributeNames: map[string]*string{
+			"#s": aws.String("s"),
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return out.Item != nil, nil
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key in a possibly versioned context.
+func (db *DynamoDB) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put() on nil DynamoDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Put(tk, v)
+	return batch.Commit()
+}
+
+// Delete deletes a key-value pair so that subsequent Get on the key returns nil.
+// For versioned contexts, a tombstone is written for the current version.
+func (db *DynamoDB) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete() on nil DynamoDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	return batch.Commit()
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+func (db *DynamoDB) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut() on nil DynamoDB")
+	}
+	reqs, err := putRequests(k, v)
+	if err != nil {
+		return err
+	}
+	if err := db.batchWrite(reqs); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.
+func (db *DynamoDB) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete() on nil DynamoDB")
+	}
+	reqs, err := db.deleteRequests(k)
+	if err != nil {
+		return err
+	}
+	return db.batchWrite(reqs)
+}
+
+// ---- KeyValueIngestable interface ------
+
+// KeyValueIngest writes a key-value pair without tombstone bookkeeping, which
+// suffices for bulk loads of immutable data.
+func (db *DynamoDB) KeyValueIngest(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call KeyValueIngest() on nil DynamoDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in KeyValueIngest()")
+	}
+	return db.RawPut(ctx.ConstructKey(tk), v)
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	key    storage.Key
+	value  []byte
+	delete bool
+}
+
+type goBatch struct {
+	db   *DynamoDB
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	ops  []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes.  DynamoDB batches are
+// not atomic, so a failed Commit may leave the batch partially applied.
+func (db *DynamoDB) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil DynamoDB\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	var vctx storage.VersionedCtx
+	if ctx.Versioned() {
+		vctx, _ = ctx.(storage.VersionedCtx)
+	}
+	return &goBatch{db: db, ctx: ctx, vctx: vctx}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, value: dvid.EmptyValue()})
+	}
+	batch.ops = append(batch.ops, batchOp{key: key, delete: true})
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, delete: true})
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.ops = append(batch.ops, batchOp{key: key, value: v})
+}
+
+// Commit writes the batch.  BatchWriteItem rejects requests with repeated items, so
+// only the last operation on each key is sent.
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	last := make(map[string]int, len(batch.ops))
+	for i, op := range batch.ops {
+		last[string(op.key)] = i
+	}
+	var reqs []*api.WriteRequest
+	for i, op := range batch.ops {
+		if last[string(op.key)] != i {
+			continue
+		}
+		var opReqs []*api.WriteRequest
+		var err error
+		if op.delete {
+			opReqs, err = batch.db.deleteRequests(op.key)
+		} else {
+			opReqs, err = putRequests(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+		reqs = append(reqs, opReqs...)
+	}
+	if err := batch.db.batchWrite(reqs); err != nil {
+		dvid.Criticalf("Error on batch commit of %d operations to %s: %v\n", len(batch.ops), batch.db, err)
+		return err
+	}
+	batch.ops = nil
+	return nil
+}
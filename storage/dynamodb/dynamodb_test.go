@@ -0,0 +1,100 @@
+// +build dynamodb
+
+package dynamodb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func testConfig(c map[string]interface{}) dvid.StoreConfig {
+	var config dvid.Config
+	config.SetAll(c)
+	return dvid.StoreConfig{Config: config, Engine: "dynamodb"}
+}
+
+func TestDynamoDBConfig(t *testing.T) {
+	db, err := parseConfig(testConfig(map[string]interface{}{"table": "dvid", "region": "us-east-1", "endpoint": "http://localhost:8000"}))
+	if err != nil || db.table != "dvid" || db.region != "us-east-1" || db.endpoint != "http://localhost:8000" {
+		t.Errorf("bad parsed configuration %v: %v\n", db, err)
+	}
+	for _, c := range []map[string]interface{}{{}, {"table": ""}, {"table": 1}, {"table": "dvid", "region": true}} {
+		if _, err := parseConfig(testConfig(c)); err == nil {
+			t.Errorf("expected error parsing configuration %v\n", c)
+		}
+	}
+	if !db.Equal(testConfig(map[string]interface{}{"table": "dvid", "region": "us-east-1", "endpoint": "http://localhost:8000"})) {
+		t.Errorf("expected store to equal its configuration\n")
+	}
+	if db.Equal(testConfig(map[string]interface{}{"table": "dvid", "region": "us-west-2", "endpoint": "http://localhost:8000"})) {
+		t.Errorf("expected store not to equal configuration in another region\n")
+	}
+}
+
+func TestDynamoDBChunks(t *testing.T) {
+	var ctx storage.MetadataContext
+	k := ctx.ConstructKey(storage.TKey("big"))
+	unversioned, ver, err := storage.SplitKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Small and empty values are stored in the main item without a chunk count.
+	for _, v := range [][]byte{nil, []byte("small")} {
+		reqs, err := putRequests(k, v)
+		if err != nil {
+			t.Fatalf("error getting put requests: %v\n", err)
+		}
+		if len(reqs) != 1 {
+			t.Fatalf("expected 1 put request for %d bytes, got %d\n", len(v), len(reqs))
+		}
+		item := reqs[0].PutRequest.Item
+		if !bytes.Equal(item["k"].B, unversioned) || item["s"].B[0] != versionItem || !bytes.Equal(item["v"].B, v) {
+			t.Errorf("bad main item %v\n", item)
+		}
+		if _, found := item["n"]; found {
+			t.Errorf("expected no chunk count for %d bytes\n", len(v))
+		}
+	}
+
+	// Large values are split into chunks written before the main item.
+	v := make([]byte, 2*MaxChunkSize+10)
+	for i := range v {
+		v[i] = byte(i % 251)
+	}
+	reqs, err := putRequests(k, v)
+	if err != nil {
+		t.Fatalf("error getting put requests: %v\n", err)
+	}
+	if len(reqs) != 3 {
+		t.Fatalf("expected 3 put requests, got %d\n", len(reqs))
+	}
+	main := reqs[2].PutRequest.Item
+	if main["n"] == nil || *main["n"].N != "3" {
+		t.Errorf("expected chunk count 3 in main item, got %v\n", main["n"])
+	}
+	var joined []byte
+	for i := 2; i >= 0; i-- {
+		item := reqs[i].PutRequest.Item
+		if !bytes.Equal(item["k"].B, unversioned) {
+			t.Errorf("expected chunk %d in partition of key\n", 2-i)
+		}
+		joined = append(joined, item["v"].B...)
+	}
+	if !bytes.Equal(joined, v) {
+		t.Errorf("expected chunks to join into value\n")
+	}
+	for i := 1; i < 3; i++ {
+		ck, err := chunkKey(k, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sk := reqs[2-i].PutRequest.Item["s"].B
+		if !bytes.Equal(sk, ck["s"].B) || !bytes.HasPrefix(sk, chunkPrefix(ver)) {
+			t.Errorf("bad sort key %v for chunk %d\n", sk, i)
+		}
+	}
+}
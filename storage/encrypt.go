@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// EncryptionConfig handles settings for encryption at rest of stored values.  Exactly
+// one source of the AES key should be given: Key, KeyFile, or KMSKey.
+type EncryptionConfig struct {
+	Key       string  // hex or base64 encoded 16, 24, or 32 byte AES key.
+	KeyFile   string  `toml:"keyfile"` // file holding a hex or base64 encoded key.
+	KMSKey    string  `toml:"kmskey"`  // base64 encoded data key encrypted by a KMS.
+	KMSRegion string  `toml:"kmsregion"`
+	Stores    []Alias // aliases of stores whose values are encrypted.
+}
+
+// KMSDecrypter decrypts a data key using a key management service.  It is nil unless
+// DVID is built with KMS support.
+var KMSDecrypter func(ciphertext []byte, region string) ([]byte, error)
+
+func decodeKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil {
+		return key, nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// encryptionKey returns the AES key from whatever source is configured.
+func (c EncryptionConfig) encryptionKey() ([]byte, error) {
+	switch {
+	case c.Key != "":
+		return decodeKey(c.Key)
+	case c.KeyFile != "":
+		data, err := ioutil.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read encryption key file %q: %v", c.KeyFile, err)
+		}
+		return decodeKey(string(data))
+	case c.KMSKey != "":
+		if KMSDecrypter == nil {
+			return nil, fmt.Errorf("encryption key from KMS requested but DVID was not built with KMS support")
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(c.KMSKey)
+		if err != nil {
+			return nil, fmt.Errorf("bad base64 KMS key: %v", err)
+		}
+		return KMSDecrypter(ciphertext, c.KMSRegion)
+	default:
+		return nil, fmt.Errorf("no encryption key, keyfile, or kmskey given")
+	}
+}
+
+// newAEAD returns an AES-GCM cipher if any stores are to be encrypted.
+func (c EncryptionConfig) newAEAD() (cipher.AEAD, error) {
+	if len(c.Stores) == 0 {
+		return nil, nil
+	}
+	key, err := c.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// crypter encrypts values as a random nonce followed by the AES-GCM sealed value.
+type crypter struct {
+	aead cipher.AEAD
+}
+
+func (c crypter) seal(v []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	out := make([]byte, nonceSize, nonceSize+len(v)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, out); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(out, out, v, nil), nil
+}
+
+func (c crypter) open(v []byte) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(v) < nonceSize+c.aead.Overhead() {
+		return nil, fmt.Errorf("encrypted value is only %d bytes", len(v))
+	}
+	return c.aead.Open(nil, v[:nonceSize], v[nonceSize:], nil)
+}
+
+// wrapEncryption returns a store that encrypts all values written to the passed store
+// and decrypts values read from it.  Ordered and batch interfaces are preserved.
+func wrapEncryption(store dvid.Store, aead cipher.AEAD) (dvid.Store, error) {
+	c := crypter{aead}
+	batcher, canBatch := store.(KeyValueBatcher)
+	if db, ok := store.(OrderedKeyValueDB); ok {
+		s := encryptedOrderedStore{OrderedKeyValueDB: db, crypter: c}
+		if canBatch {
+			return encryptedOrderedBatchStore{s, batcher}, nil
+		}
+		return s, nil
+	}
+	if db, ok := store.(KeyValueDB); ok {
+		s := encryptedStore{KeyValueDB: db, crypter: c}
+		if canBatch {
+			return encryptedBatchStore{s, batcher}, nil
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("store %s doesn't implement KeyValueDB", store)
+}
+
+type encryptedStore struct {
+	KeyValueDB
+	crypter
+}
+
+func (s encryptedStore) Get(ctx Context, tk TKey) ([]byte, error) {
+	v, err := s.KeyValueDB.Get(ctx, tk)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(v)
+}
+
+func (s encryptedStore) Put(ctx Context, tk TKey, v []byte) error {
+	sealed, err := s.seal(v)
+	if err != nil {
+		return err
+	}
+	return s.KeyValueDB.Put(ctx, tk, sealed)
+}
+
+func (s encryptedStore) RawPut(k Key, v []byte) error {
+	sealed, err := s.seal(v)
+	if err != nil {
+		return err
+	}
+	return s.KeyValueDB.RawPut(k, sealed)
+}
+
+type encryptedBatchStore struct {
+	encryptedStore
+	batcher KeyValueBatcher
+}
+
+func (s encryptedBatchStore) NewBatch(ctx Context) Batch {
+	return &encryptedBatch{Batch: s.batcher.NewBatch(ctx), crypter: s.crypter}
+}
+
+type encryptedOrderedStore struct {
+	OrderedKeyValueDB
+	crypter
+}
+
+func (s encryptedOrderedStore) Get(ctx Context, tk TKey) ([]byte, error) {
+	v, err := s.OrderedKeyValueDB.Get(ctx, tk)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(v)
+}
+
+func (s encryptedOrderedStore) GetRange(ctx Context, kStart, kEnd TKey) ([]*TKeyValue, error) {
+	kvs, err := s.OrderedKeyValueDB.GetRange(ctx, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range kvs {
+		if kv.V, err = s.open(kv.V); err != nil {
+			return nil, err
+		}
+	}
+	return kvs, nil
+}
+
+func (s encryptedOrderedStore) ProcessRange(ctx Context, kStart, kEnd TKey, op *ChunkOp, f ChunkFunc) error {
+	return s.OrderedKeyValueDB.ProcessRange(ctx, kStart, kEnd, op, func(c *Chunk) error {
+		if c != nil && c.TKeyValue != nil {
+			v, err := s.open(c.V)
+			if err != nil {
+				return err
+			}
+			c.TKeyValue = &TKeyValue{K: c.K, V: v}
+		}
+		return f(c)
+	})
+}
+
+func (s encryptedOrderedStore) RawRangeQuery(ctx context.Context, kStart, kEnd Key, keysOnly bool, out chan *KeyValue) error {
+	if keysOnly {
+		return s.OrderedKeyValueDB.RawRangeQuery(ctx, kStart, kEnd, keysOnly, out)
+	}
+	in := make(chan *KeyValue, cap(out))
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for kv := range in {
+			var decrypted *KeyValue
+			if kv != nil {
+				if err != nil {
+					continue
+				}
+				v, oerr := s.open(kv.V)
+				if oerr != nil {
+					err = oerr
+					continue
+				}
+				decrypted = &KeyValue{K: kv.K, V: v}
+			}
+			select {
+			case out <- decrypted:
+			case <-ctx.Done():
+			}
+			if kv == nil {
+				break
+			}
+		}
+		done <- err
+	}()
+	err := s.OrderedKeyValueDB.RawRangeQuery(ctx, kStart, kEnd, keysOnly, in)
+	close(in)
+	if derr := <-done; err == nil {
+		err = derr
+	}
+	return err
+}
+
+func (s encryptedOrderedStore) Put(ctx Context, tk TKey, v []byte) error {
+	sealed, err := s.seal(v)
+	if err != nil {
+		return err
+	}
+	return s.OrderedKeyValueDB.Put(ctx, tk, sealed)
+}
+
+func (s encryptedOrderedStore) RawPut(k Key, v []byte) error {
+	sealed, err := s.seal(v)
+	if err != nil {
+		return err
+	}
+	return s.OrderedKeyValueDB.RawPut(k, sealed)
+}
+
+func (s encryptedOrderedStore) PutRange(ctx Context, kvs []TKeyValue) error {
+	sealedKVs := make([]TKeyValue, len(kvs))
+	for i, kv := range kvs {
+		sealed, err := s.seal(kv.V)
+		if err != nil {
+			return err
+		}
+		sealedKVs[i] = TKeyValue{K: kv.K, V: sealed}
+	}
+	return s.OrderedKeyValueDB.PutRange(ctx, sealedKVs)
+}
+
+type encryptedOrderedBatchStore struct {
+	encryptedOrderedStore
+	batcher KeyValueBatcher
+}
+
+func (s encryptedOrderedBatchStore) NewBatch(ctx Context) Batch {
+	return &encryptedBatch{Batch: s.batcher.NewBatch(ctx), crypter: s.crypter}
+}
+
+type encryptedBatch struct {
+	Batch
+	crypter
+	err error // first encryption error, returned on Commit.
+}
+
+func (b *encryptedBatch) Put(tk TKey, v []byte) {
+	sealed, err := b.seal(v)
+	if err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return
+	}
+	b.Batch.Put(tk, sealed)
+}
+
+func (b *encryptedBatch) Commit() error {
+	if b.err != nil {
+		return fmt.Errorf("unable to encrypt batch value: %v", b.err)
+	}
+	return b.Batch.Commit()
+}
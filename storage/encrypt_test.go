@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	config := EncryptionConfig{Key: strings.Repeat("ab", 32), Stores: []Alias{"encrypted"}}
+	aead, err := config.newAEAD()
+	if err != nil {
+		t.Fatalf("unable to create cipher: %v\n", err)
+	}
+	mem := &testBatchStore{testKVStore: &testKVStore{kv: make(map[string][]byte)}}
+	store, err := wrapEncryption(mem, aead)
+	if err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	db := store.(OrderedKeyValueDB)
+	var ctx MetadataContext
+
+	secret := []byte("a secret value")
+	if err := db.Put(ctx, TKey("a"), secret); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	batch := store.(KeyValueBatcher).NewBatch(ctx)
+	batch.Put(TKey("b"), secret)
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("error on batch commit: %v\n", err)
+	}
+	for k, v := range mem.kv {
+		if bytes.Contains(v, secret) {
+			t.Errorf("expected encrypted value for key %x, got %q\n", k, v)
+		}
+	}
+	checkValue(t, db, ctx, "a", secret)
+	checkValue(t, db, ctx, "b", secret)
+
+	kStart, kEnd := ctx.KeyRange()
+	out := make(chan *KeyValue, 3)
+	if err := db.RawRangeQuery(context.Background(), kStart, kEnd, false, out); err != nil {
+		t.Fatalf("error on raw range query: %v\n", err)
+	}
+	for i := 0; i < 2; i++ {
+		if kv := <-out; kv == nil || !bytes.Equal(kv.V, secret) {
+			t.Errorf("expected decrypted value from raw range query, got %v\n", kv)
+		}
+	}
+	if kv := <-out; kv != nil {
+		t.Errorf("expected nil at end of raw range query, got %v\n", kv)
+	}
+
+	// Values can't be read with another key.
+	other := EncryptionConfig{Key: strings.Repeat("cd", 32), Stores: []Alias{"encrypted"}}
+	otherAEAD, err := other.newAEAD()
+	if err != nil {
+		t.Fatalf("unable to create cipher: %v\n", err)
+	}
+	otherStore, err := wrapEncryption(mem, otherAEAD)
+	if err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	if _, err := otherStore.(OrderedKeyValueDB).Get(ctx, TKey("a")); err == nil {
+		t.Errorf("expected error on get with wrong key\n")
+	}
+
+	if aead, err := (EncryptionConfig{Key: "abcd", Stores: []Alias{"encrypted"}}).newAEAD(); err == nil || aead != nil {
+		t.Errorf("expected error on key of bad length\n")
+	}
+	if aead, err := (EncryptionConfig{}).newAEAD(); err != nil || aead != nil {
+		t.Errorf("expected no cipher without encrypted stores, got %v: %v\n", aead, err)
+	}
+}
@@ -0,0 +1,910 @@
+// +build fdb
+
+/*
+	Package fdb implements a storage engine on FoundationDB, a distributed ordered
+	key-value store with serializable multi-key transactions.  Unlike engines that
+	emulate TransactionDB, batches, Patch() and LockKey() are executed as single
+	FoundationDB transactions and so are atomic across keys and across DVID servers
+	sharing the same cluster.
+
+	FoundationDB limits keys to 10 KB, values to 100 KB, and transactions to 10 MB and
+	five seconds.  Range reads are therefore split across several read transactions,
+	and batches that exceed the transaction limits return an error rather than being
+	applied partially.  Larger values can be stored by listing the store in the [chunk]
+	configuration, but chunked stores emulate TransactionDB within one DVID server.
+*/
+package fdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	api "github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+const (
+	// APIVersion is the FoundationDB client API version required by this driver.
+	APIVersion = 620
+
+	// RangeChunkSize is the maximum number of key-value pairs read per range transaction.
+	RangeChunkSize = 1000
+
+	// MaxValueSize is the largest value FoundationDB accepts.
+	MaxValueSize = 100000
+
+	// lockRetryDelay is the initial wait before retrying a held lock.
+	lockRetryDelay = 10 * time.Millisecond
+
+	// maxLockRetryDelay caps the exponential backoff while waiting on a lock.
+	maxLockRetryDelay = time.Second
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in fdb: %v\n", err)
+	}
+	e := Engine{"fdb", "FoundationDB transactional key-value store", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return true
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a FoundationDB store.  Optional settings:
+// "clusterfile": path to the fdb.cluster file (default is the system cluster file)
+// "prefix": string prepended to all keys so several stores can share a cluster
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newFDB(config)
+}
+
+func parseConfig(config dvid.StoreConfig) (clusterFile, prefix string, err error) {
+	c := config.GetAll()
+	if v, found := c["clusterfile"]; found {
+		var ok bool
+		if clusterFile, ok = v.(string); !ok {
+			err = fmt.Errorf("%q setting must be a string (%v)", "clusterfile", v)
+			return
+		}
+	}
+	if v, found := c["prefix"]; found {
+		var ok bool
+		if prefix, ok = v.(string); !ok {
+			err = fmt.Errorf("%q setting must be a string (%v)", "prefix", v)
+			return
+		}
+	}
+	return
+}
+
+func (e Engine) newFDB(config dvid.StoreConfig) (*FDB, bool, error) {
+	clusterFile, prefix, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+	// Setting the same API version again for a second store is not an error.
+	if err := api.APIVersion(APIVersion); err != nil {
+		return nil, false, fmt.Errorf("unable to set FoundationDB API version %d: %v", APIVersion, err)
+	}
+	var fdb api.Database
+	if clusterFile == "" {
+		fdb, err = api.OpenDefault()
+	} else {
+		fdb, err = api.OpenDatabase(clusterFile)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to open FoundationDB: %v", err)
+	}
+	db := &FDB{
+		clusterFile: clusterFile,
+		prefix:      []byte(prefix),
+		config:      config,
+		fdb:         fdb,
+	}
+	metadataExists, err := db.metadataExists()
+	if err != nil {
+		return nil, false, err
+	}
+	return db, !metadataExists, nil
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets fdb as the default key-value backend and metadata store, using the
+// cluster file given by the DVID_TEST_FDB_CLUSTERFILE environment variable.  Each test
+// store uses a new key prefix.  If another engine is already set, it returns an error
+// since only one key-value backend should be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("fdb can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	if backend.Metadata != "" {
+		return fmt.Errorf("fdb can't be testable key-value.  Metadata already set to %s", backend.Metadata)
+	}
+	clusterFile := os.Getenv("DVID_TEST_FDB_CLUSTERFILE")
+	if clusterFile == "" {
+		return fmt.Errorf("fdb can't be testable key-value without a cluster file in DVID_TEST_FDB_CLUSTERFILE")
+	}
+	alias := storage.Alias("fdb")
+	backend.Metadata = alias
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"clusterfile": clusterFile,
+		"prefix":      fmt.Sprintf("dvid-test-%x/", uuid.NewV4().Bytes()),
+		"testing":     true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "fdb"}
+	return nil
+}
+
+// Delete implements the TestableEngine interface by clearing all keys with the store's
+// prefix.  A store without a prefix isn't deleted since it may share the cluster.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	_, prefix, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	if prefix == "" {
+		return fmt.Errorf("can't delete fdb store without a prefix")
+	}
+	db, _, err := e.newFDB(config)
+	if err != nil {
+		return err
+	}
+	kr, err := api.PrefixRange(db.prefix)
+	if err != nil {
+		return err
+	}
+	_, err = db.fdb.Transact(func(tr api.Transaction) (interface{}, error) {
+		tr.ClearRange(kr)
+		return nil, nil
+	})
+	return err
+}
+
+// --- The FoundationDB implementation must satisfy a Engine interface ----
+
+type FDB struct {
+	clusterFile string
+	prefix      []byte
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+
+	fdb api.Database
+}
+
+func (db *FDB) String() string {
+	if db.clusterFile == "" {
+		return fmt.Sprintf("foundationdb (prefix %q)", db.prefix)
+	}
+	return fmt.Sprintf("foundationdb @ %s (prefix %q)", db.clusterFile, db.prefix)
+}
+
+// Close is a no-op since the FoundationDB network thread lasts for the process.
+func (db *FDB) Close() {}
+
+// Equal returns true if the FoundationDB store matches the given store configuration.
+func (db *FDB) Equal(config dvid.StoreConfig) bool {
+	clusterFile, prefix, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.clusterFile == clusterFile && string(db.prefix) == prefix
+}
+
+// fdbKey returns the FoundationDB key for a full DVID key.  The returned key never
+// shares memory with k so it can be safely appended to.
+func (db *FDB) fdbKey(k storage.Key) api.Key {
+	key := make([]byte, 0, len(db.prefix)+len(k)+1)
+	key = append(key, db.prefix...)
+	return api.Key(append(key, k...))
+}
+
+// dvidKey strips the store prefix from a FoundationDB key.
+func (db *FDB) dvidKey(k api.Key) storage.Key {
+	return storage.Key(append([]byte{}, k[len(db.prefix):]...))
+}
+
+func (db *FDB) metadataExists() (bool, error) {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	found, err := db.fdb.ReadTransact(func(rtr api.ReadTransaction) (interface{}, error) {
+		kvs, err := rtr.GetRange(api.KeyRange{Begin: db.fdbKey(keyBeg), End: db.fdbKey(keyEnd)}, api.RangeOptions{Limit: 1}).GetSliceWithError()
+		return len(kvs) != 0, err
+	})
+	if err != nil {
+		return false, err
+	}
+	if !found.(bool) {
+		dvid.Infof("No metadata found for %s...\n", db)
+	}
+	return found.(bool), nil
+}
+
+// scanRange calls f on each key-value pair with keys in [kStart, kEnd] in ascending order.
+// The range is read in chunks so it does not exceed FoundationDB transaction limits.  If
+// f returns false, the scan stops.
+func (db *FDB) scanRange(kStart, kEnd storage.Key, keysOnly bool, f func(*storage.KeyValue) bool) error {
+	begin := db.fdbKey(kStart)
+	end := append(db.fdbKey(kEnd), 0) // make end inclusive
+	for {
+		r, err := db.fdb.ReadTransact(func(rtr api.ReadTransaction) (interface{}, error) {
+			return rtr.GetRange(api.KeyRange{Begin: begin, End: end}, api.RangeOptions{Limit: RangeChunkSize}).GetSliceWithError()
+		})
+		if err != nil {
+			return err
+		}
+		kvs := r.([]api.KeyValue)
+		for _, kv := range kvs {
+			k := db.dvidKey(kv.Key)
+			storage.StoreKeyBytesRead <- len(k)
+			var v []byte
+			if !keysOnly {
+				v = kv.Value
+				storage.StoreValueBytesRead <- len(v)
+			}
+			if !f(&storage.KeyValue{K: k, V: v}) {
+				return nil
+			}
+		}
+		if len(kvs) < RangeChunkSize {
+			return nil
+		}
+		begin = append(append(api.Key{}, kvs[len(kvs)-1].Key...), 0)
+	}
+}
+
+// getSingleKeyVersions returns all versions of a key within a transaction.  These key-value
+// pairs will be sorted in ascending key order and could include a tombstone key.
+func (db *FDB) getSingleKeyVersions(rtr api.ReadTransaction, vctx storage.VersionedCtx, tk storage.TKey) ([]*storage.KeyValue, error) {
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	kr := api.KeyRange{Begin: db.fdbKey(begKey), End: append(db.fdbKey(endKey), 0)}
+	kvs, err := rtr.GetRange(kr, api.RangeOptions{}).GetSliceWithError()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]*storage.KeyValue, len(kvs))
+	for i, kv := range kvs {
+		values[i] = &storage.KeyValue{K: db.dvidKey(kv.Key), V: kv.Value}
+	}
+	return values, nil
+}
+
+// getValue returns the value for a possibly versioned key within a transaction.
+func (db *FDB) getValue(rtr api.ReadTransaction, ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		values, err := db.getSingleKeyVersions(rtr, vctx, tk)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			return kv.V, err
+		}
+		return nil, err
+	}
+	return rtr.Get(db.fdbKey(ctx.ConstructKey(tk))).Get()
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *FDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call Get on nil FDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	v, err := db.fdb.ReadTransact(func(rtr api.ReadTransaction) (interface{}, error) {
+		return db.getValue(rtr, ctx, tk)
+	})
+	if err != nil {
+		return nil, err
+	}
+	value := v.([]byte)
+	storage.StoreValueBytesRead <- len(value)
+	return value, nil
+}
+
+// Exists returns true if a value is stored for the key.  FoundationDB has no cheaper
+// existence check, but values are small since they're limited to MaxValueSize.
+func (db *FDB) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists on nil FDB")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	v, err := db.fdb.ReadTransact(func(rtr api.ReadTransaction) (interface{}, error) {
+		return db.getValue(rtr, ctx, tk)
+	})
+	if err != nil {
+		return false, err
+	}
+	return v.([]byte) != nil, nil
+}
+
+// rangeQuery calls f on each key-value pair visible in the context's version with
+// type-specific keys in [kStart, kEnd].
+func (db *FDB) rangeQuery(ctx storage.Context, kStart, kEnd storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	var ferr error
+	if !ctx.Versioned() {
+		err := db.scanRange(ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd), keysOnly, func(kv *storage.KeyValue) bool {
+			ferr = f(kv)
+			return ferr == nil
+		})
+		if err != nil {
+			return err
+		}
+		return ferr
+	}
+
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return fmt.Errorf("context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	minKey, err := vctx.MinVersionKey(kStart)
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(kEnd)
+	if err != nil {
+		return err
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(kStart)
+	if err != nil {
+		return err
+	}
+	values := []*storage.KeyValue{}
+	sendKV := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil || kv == nil {
+			return err
+		}
+		return f(kv)
+	}
+	err = db.scanRange(minKey, maxKey, keysOnly, func(kv *storage.KeyValue) bool {
+		// Did we pass all versions for last key read?
+		if bytes.Compare(kv.K, maxVersionKey) > 0 {
+			var tk storage.TKey
+			if tk, ferr = storage.TKeyFromKey(kv.K); ferr != nil {
+				return false
+			}
+			if maxVersionKey, ferr = vctx.MaxVersionKey(tk); ferr != nil {
+				return false
+			}
+			if ferr = sendKV(); ferr != nil {
+				return false
+			}
+			values = []*storage.KeyValue{}
+		}
+		values = append(values, kv)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if ferr != nil {
+		return ferr
+	}
+	return sendKV()
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *FDB) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil FDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	keys := []storage.TKey{}
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, tk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *FDB) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, ch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil FDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		ch <- kv.K
+		return nil
+	})
+	ch <- nil
+	return err
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *FDB) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil FDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	values := []*storage.TKeyValue{}
+	err := db.rangeQuery(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: kv.V})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *FDB) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil FDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	f = storage.CancelableChunkFunc(ctx, f)
+	return db.rangeQuery(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tkv := storage.TKeyValue{K: tk, V: kv.V}
+		return f(&storage.Chunk{ChunkOp: op, TKeyValue: &tkv})
+	})
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (db *FDB) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil FDB")
+	}
+	var cancelled bool
+	err := db.scanRange(kStart, kEnd, keysOnly, func(kv *storage.KeyValue) bool {
+		select {
+		case out <- kv:
+			return true
+		case <-ctx.Done():
+			cancelled = true
+			return false
+		}
+	})
+	if err != nil || cancelled {
+		return err
+	}
+	out <- nil
+	return nil
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *FDB) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil FDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Put(tk, v)
+	return batch.Commit()
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *FDB) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil FDB")
+	}
+	if len(v) > MaxValueSize {
+		return fmt.Errorf("value of %d bytes exceeds FoundationDB maximum of %d bytes", len(v), MaxValueSize)
+	}
+	_, err := db.fdb.Transact(func(tr api.Transaction) (interface{}, error) {
+		tr.Set(db.fdbKey(k), v)
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *FDB) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil FDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	return batch.Commit()
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *FDB) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil FDB")
+	}
+	_, err := db.fdb.Transact(func(tr api.Transaction) (interface{}, error) {
+		tr.Clear(db.fdbKey(k))
+		return nil, nil
+	})
+	return err
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order
+// within a single transaction.
+func (db *FDB) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange on nil FDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	return batch.Commit()
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.  For versioned
+// contexts, tombstones are written for the deleted keys.
+func (db *FDB) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil FDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+	var tkeys []storage.TKey
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		tkeys = append(tkeys, tk)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	const BATCH_SIZE = 10000
+	for start := 0; start < len(tkeys); start += BATCH_SIZE {
+		end := start + BATCH_SIZE
+		if end > len(tkeys) {
+			end = len(tkeys)
+		}
+		batch := db.NewBatch(ctx)
+		for _, tk := range tkeys[start:end] {
+			batch.Delete(tk)
+		}
+		if err := batch.Commit(); err != nil {
+			return fmt.Errorf("Error on batch commit of DeleteRange at key-value pair %d: %v", start, err)
+		}
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", len(tkeys), ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+// Deletion of all versions is a single range clear.
+func (db *FDB) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil FDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	if allVersions {
+		minKey, maxKey := ctx.KeyRange()
+		_, err := db.fdb.Transact(func(tr api.Transaction) (interface{}, error) {
+			tr.ClearRange(api.KeyRange{Begin: db.fdbKey(minKey), End: db.fdbKey(maxKey)})
+			return nil, nil
+		})
+		if err != nil {
+			return fmt.Errorf("Error on DELETE ALL for %s: %v", ctx, err)
+		}
+		return nil
+	}
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if !versioned {
+		return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+	}
+	minKey, err := vctx.MinVersionKey(storage.MinTKey(storage.TKeyMinClass))
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(storage.MaxTKey(storage.TKeyMaxClass))
+	if err != nil {
+		return err
+	}
+	deleteVersion := vctx.VersionID()
+	var keys []storage.Key
+	err = db.scanRange(minKey, maxKey, true, func(kv *storage.KeyValue) bool {
+		_, v, _, err := storage.DataKeyToLocalIDs(kv.K)
+		if err == nil && v == deleteVersion {
+			keys = append(keys, kv.K)
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("Error on DELETE ALL for version %d: %v", deleteVersion, err)
+	}
+	const BATCH_SIZE = 10000
+	for start := 0; start < len(keys); start += BATCH_SIZE {
+		end := start + BATCH_SIZE
+		if end > len(keys) {
+			end = len(keys)
+		}
+		_, err := db.fdb.Transact(func(tr api.Transaction) (interface{}, error) {
+			for _, k := range keys[start:end] {
+				tr.Clear(db.fdbKey(k))
+			}
+			return nil, nil
+		})
+		if err != nil {
+			return fmt.Errorf("Error on DELETE ALL for version %d at key-value pair %d: %v", deleteVersion, start, err)
+		}
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", len(keys), vctx)
+	return nil
+}
+
+// ---- TransactionDB interface ------
+
+// LockKey atomically creates the given key as a lock.  If the key already exists, the
+// lock is held elsewhere and LockKey retries with exponential backoff until it is free.
+func (db *FDB) LockKey(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call LockKey on nil FDB")
+	}
+	key := db.fdbKey(k)
+	delay := lockRetryDelay
+	for {
+		acquired, err := db.fdb.Transact(func(tr api.Transaction) (interface{}, error) {
+			v, err := tr.Get(key).Get()
+			if err != nil {
+				return false, err
+			}
+			if v != nil {
+				return false, nil
+			}
+			tr.Set(key, dvid.EmptyValue())
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+		if acquired.(bool) {
+			return nil
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxLockRetryDelay {
+			delay = maxLockRetryDelay
+		}
+	}
+}
+
+// UnlockKey deletes the lock key, releasing the lock.
+func (db *FDB) UnlockKey(k storage.Key) error {
+	return db.RawDelete(k)
+}
+
+// Patch reads the value visible at the context's version, applies f, and writes the
+// result within one transaction.  Concurrent patches of the same key are serialized by
+// FoundationDB conflict detection, so f may be called more than once and must not have
+// side effects beyond computing the new value.
+func (db *FDB) Patch(ctx storage.Context, tk storage.TKey, f storage.PatchFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Patch on nil FDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Patch()")
+	}
+	_, err := db.fdb.Transact(func(tr api.Transaction) (interface{}, error) {
+		val, err := db.getValue(tr, ctx, tk)
+		if err != nil {
+			return nil, err
+		}
+		if val, err = f(val); err != nil {
+			return nil, err
+		}
+		if len(val) > MaxValueSize {
+			return nil, fmt.Errorf("patched value of %d bytes exceeds FoundationDB maximum of %d bytes", len(val), MaxValueSize)
+		}
+		if vctx, ok := ctx.(storage.VersionedCtx); ok && ctx.Versioned() {
+			tr.Clear(db.fdbKey(vctx.TombstoneKey(tk)))
+		}
+		tr.Set(db.fdbKey(ctx.ConstructKey(tk)), val)
+		return nil, nil
+	})
+	return err
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	key    storage.Key
+	value  []byte
+	delete bool
+}
+
+type goBatch struct {
+	db   *FDB
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	ops  []batchOp
+	err  error
+}
+
+// NewBatch returns an implementation that allows batch writes.  All operations in
+// the batch are committed atomically in a single FoundationDB transaction.
+func (db *FDB) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil FDB\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	var vctx storage.VersionedCtx
+	if ctx.Versioned() {
+		vctx, _ = ctx.(storage.VersionedCtx)
+	}
+	return &goBatch{db: db, ctx: ctx, vctx: vctx}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, value: dvid.EmptyValue()})
+	}
+	batch.ops = append(batch.ops, batchOp{key: key, delete: true})
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	if len(v) > MaxValueSize && batch.err == nil {
+		batch.err = fmt.Errorf("value of %d bytes exceeds FoundationDB maximum of %d bytes", len(v), MaxValueSize)
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, delete: true})
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.ops = append(batch.ops, batchOp{key: key, value: v})
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	if batch.err != nil {
+		return batch.err
+	}
+	db := batch.db
+	_, err := db.fdb.Transact(func(tr api.Transaction) (interface{}, error) {
+		for _, op := range batch.ops {
+			if op.delete {
+				tr.Clear(db.fdbKey(op.key))
+			} else {
+				tr.Set(db.fdbKey(op.key), op.value)
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		dvid.Criticalf("Error on batch commit of %d operations to %s: %v\n", len(batch.ops), db, err)
+		return err
+	}
+	batch.ops = nil
+	return nil
+}
@@ -0,0 +1,56 @@
+// +build fdb
+
+package fdb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func testConfig(c map[string]interface{}) dvid.StoreConfig {
+	var config dvid.Config
+	config.SetAll(c)
+	return dvid.StoreConfig{Config: config, Engine: "fdb"}
+}
+
+func TestFDBConfig(t *testing.T) {
+	clusterFile, prefix, err := parseConfig(testConfig(map[string]interface{}{}))
+	if err != nil || clusterFile != "" || prefix != "" {
+		t.Errorf("expected default cluster file without prefix, got %q, %q: %v\n", clusterFile, prefix, err)
+	}
+	clusterFile, prefix, err = parseConfig(testConfig(map[string]interface{}{"clusterfile": "/etc/fdb.cluster", "prefix": "dvid/"}))
+	if err != nil || clusterFile != "/etc/fdb.cluster" || prefix != "dvid/" {
+		t.Errorf("bad parsed configuration %q, %q: %v\n", clusterFile, prefix, err)
+	}
+	for _, c := range []map[string]interface{}{{"clusterfile": 1}, {"prefix": true}} {
+		if _, _, err := parseConfig(testConfig(c)); err == nil {
+			t.Errorf("expected error parsing configuration %v\n", c)
+		}
+	}
+
+	// Stores without a prefix may share the cluster so aren't deleted.
+	var e Engine
+	if err := e.Delete(testConfig(map[string]interface{}{})); err == nil {
+		t.Errorf("expected error deleting store without a prefix\n")
+	}
+}
+
+func TestFDBPrefixedKeys(t *testing.T) {
+	db := &FDB{prefix: []byte("dvid/")}
+	k := storage.Key{0, 1, 2}
+	fk := db.fdbKey(k)
+	if !bytes.Equal(fk, []byte("dvid/\x00\x01\x02")) {
+		t.Errorf("expected prefixed key, got %v\n", fk)
+	}
+	if dk := db.dvidKey(fk); !bytes.Equal(dk, k) {
+		t.Errorf("expected key %v after stripping prefix, got %v\n", k, dk)
+	}
+
+	// Prefixing keeps the order of keys.
+	if bytes.Compare(db.fdbKey(storage.Key{1}), db.fdbKey(storage.Key{1, 0})) >= 0 {
+		t.Errorf("expected prefixed keys in key order\n")
+	}
+}
@@ -0,0 +1,946 @@
+// +build filestore
+
+/*
+	Package filestore implements an ordered key-value store that keeps each key-value pair
+	as a file under a sharded directory tree.  It is meant for debugging, small sparse
+	datasets, and interop with shell tooling, not for performance.
+
+	The first "shardbytes" bytes of a key (default 8) select nested directories named by
+	the hex encoding of each byte, and the file name is the hex encoding of the remaining
+	bytes.  A key no longer than the shard prefix is stored in the directory of its last
+	byte under the name "_".  For example, with shardbytes = 2, key 0x01020304 is stored
+	at <path>/01/02/0304.  Since hex encoding preserves byte order, a sorted walk of the
+	tree visits keys in ascending order, which provides range queries.
+
+	Each file is written to a temporary file and renamed into place, so readers never
+	see a partial value.  Batches are not atomic.
+*/
+package filestore
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+)
+
+const (
+	// DefaultShardBytes is the number of leading key bytes used as directory levels.
+	DefaultShardBytes = 8
+
+	// exactName is the file name of a key that ends at its directory's prefix.
+	exactName = "_"
+
+	// maxNameLength is the longest file name allowed by common file systems.
+	maxNameLength = 255
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in filestore: %v\n", err)
+	}
+	e := Engine{"filestore", "Directory of files, one per key-value pair", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return false
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a file store.  The passed Config must contain "path" string giving
+// the root directory.  The optional "shardbytes" setting gives the number of leading
+// key bytes used as directory levels and must not change once data is written.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newFileStore(config)
+}
+
+func parseConfig(config dvid.StoreConfig) (path string, shardBytes int, err error) {
+	c := config.GetAll()
+
+	v, found := c["path"]
+	if !found {
+		err = fmt.Errorf("%q must be specified for filestore configuration", "path")
+		return
+	}
+	var ok bool
+	path, ok = v.(string)
+	if !ok {
+		err = fmt.Errorf("%q setting must be a string (%v)", "path", v)
+		return
+	}
+	var testing bool
+	v, found = c["testing"]
+	if found {
+		testing, ok = v.(bool)
+		if !ok {
+			err = fmt.Errorf("%q setting must be a bool (%v)", "testing", v)
+			return
+		}
+	}
+	if testing {
+		path = filepath.Join(os.TempDir(), path)
+	}
+	shardBytes = DefaultShardBytes
+	if v, found = c["shardbytes"]; found {
+		switch n := v.(type) {
+		case int64:
+			shardBytes = int(n)
+		case int:
+			shardBytes = n
+		default:
+			err = fmt.Errorf("%q setting must be an integer (%v)", "shardbytes", v)
+			return
+		}
+		if shardBytes < 0 {
+			err = fmt.Errorf("%q setting can't be negative (%d)", "shardbytes", shardBytes)
+		}
+	}
+	return
+}
+
+// newFileStore returns a file store, creating the root directory if it doesn't exist.
+func (e Engine) newFileStore(config dvid.StoreConfig) (*FileStore, bool, error) {
+	path, shardBytes, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var created bool
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		dvid.Infof("Database not already at path (%s). Creating...\n", path)
+		created = true
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, true, fmt.Errorf("Can't make directory %s: %v", path, err)
+		}
+	}
+	db := &FileStore{
+		path:       path,
+		shardBytes: shardBytes,
+		config:     config,
+	}
+	dvid.Infof("Opening filestore @ path %s\n", path)
+
+	// if we know it's newly created, just return.
+	if created {
+		return db, created, nil
+	}
+
+	// otherwise, check if there's been any metadata or we need to initialize it.
+	metadataExists, err := db.metadataExists()
+	if err != nil {
+		return nil, false, err
+	}
+	return db, !metadataExists, nil
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets filestore as the default key-value backend.  If another
+// engine is already set, it returns an error since only one key-value backend should
+// be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("filestore can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	if backend.Metadata != "" {
+		return fmt.Errorf("filestore can't be testable key-value.  Metadata already set to %s", backend.Metadata)
+	}
+	alias := storage.Alias("filestore")
+	backend.Metadata = alias
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"path":    fmt.Sprintf("dvid-test-filestore-%x", uuid.NewV4().Bytes()),
+		"testing": true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "filestore"}
+	return nil
+}
+
+// Delete implements the TestableEngine interface by providing a way to dispose
+// of testing databases.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Delete the directory if it exists
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("Can't delete old datastore %q: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// --- The FileStore implementation must satisfy a Engine interface ----
+
+// FileStore is an ordered key-value store with one file per key-value pair.
+type FileStore struct {
+	// Root directory of datastore
+	path string
+
+	// Number of leading key bytes used as directory levels
+	shardBytes int
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+}
+
+func (db *FileStore) String() string {
+	return fmt.Sprintf("filestore @ %s", db.path)
+}
+
+// Close is a no-op since no files are held open between calls.
+func (db *FileStore) Close() {}
+
+// Equal returns true if the filestore matches the given store configuration.
+func (db *FileStore) Equal(config dvid.StoreConfig) bool {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return path == db.path
+}
+
+// filePath returns the directory and file path for a key.
+func (db *FileStore) filePath(k storage.Key) (dir, path string, err error) {
+	n := db.shardBytes
+	if len(k) < n {
+		n = len(k)
+	}
+	elems := make([]string, n+1)
+	elems[0] = db.path
+	for i := 0; i < n; i++ {
+		elems[i+1] = hex.EncodeToString(k[i : i+1])
+	}
+	dir = filepath.Join(elems...)
+	name := exactName
+	if len(k) > n {
+		name = hex.EncodeToString(k[n:])
+	}
+	if len(name) > maxNameLength {
+		err = fmt.Errorf("key of %d bytes is too long for %s", len(k), db)
+		return
+	}
+	return dir, filepath.Join(dir, name), nil
+}
+
+func (db *FileStore) metadataExists() (bool, error) {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	var found bool
+	err := db.scanRange(keyBeg, keyEnd, true, func(kv *storage.KeyValue) bool {
+		found = true
+		return false
+	})
+	return found, err
+}
+
+// compareBound compares a key prefix with the same-length prefix of a bound.  A bound
+// shorter than the prefix compares as if padded with zero bytes.
+func compareBound(prefix, bound []byte) int {
+	if len(bound) > len(prefix) {
+		bound = bound[:len(prefix)]
+	}
+	return bytes.Compare(prefix, bound)
+}
+
+// errStopScan is returned from walk when the callback asks to stop.
+var errStopScan = fmt.Errorf("scan stopped")
+
+// walk visits, in ascending key order, the keys within [kStart, kEnd] stored under the
+// directory for the given key prefix.
+func (db *FileStore) walk(dir string, prefix []byte, kStart, kEnd storage.Key, keysOnly bool, f func(*storage.KeyValue) bool) error {
+	infos, err := ioutil.ReadDir(dir) // sorted by name
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	visit := func(k storage.Key, path string) error {
+		if bytes.Compare(k, kStart) < 0 || bytes.Compare(k, kEnd) > 0 {
+			return nil
+		}
+		kv := &storage.KeyValue{K: k}
+		if !keysOnly {
+			v, err := ioutil.ReadFile(path)
+			if os.IsNotExist(err) {
+				return nil // deleted since the directory was read
+			}
+			if err != nil {
+				return err
+			}
+			kv.V = v
+		}
+		storage.StoreKeyBytesRead <- len(kv.K)
+		storage.StoreValueBytesRead <- len(kv.V)
+		if !f(kv) {
+			return errStopScan
+		}
+		return nil
+	}
+
+	// A key equal to the prefix sorts before all longer keys.
+	for _, info := range infos {
+		if info.Name() == exactName && !info.IsDir() {
+			if err := visit(append([]byte{}, prefix...), filepath.Join(dir, exactName)); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if name == exactName || strings.HasPrefix(name, ".") {
+			continue
+		}
+		b, err := hex.DecodeString(name)
+		if err != nil {
+			dvid.Errorf("Skipping unexpected file %q in %s\n", filepath.Join(dir, name), db)
+			continue
+		}
+		k := make([]byte, len(prefix)+len(b))
+		copy(k, prefix)
+		copy(k[len(prefix):], b)
+		if info.IsDir() {
+			if len(b) != 1 || len(prefix) >= db.shardBytes {
+				continue
+			}
+			if compareBound(k, kStart) < 0 {
+				continue
+			}
+			if compareBound(k, kEnd) > 0 {
+				return nil
+			}
+			if err := db.walk(filepath.Join(dir, name), k, kStart, kEnd, keysOnly, f); err != nil {
+				return err
+			}
+		} else {
+			if len(prefix) < db.shardBytes {
+				continue
+			}
+			if bytes.Compare(k, kEnd) > 0 {
+				return nil
+			}
+			if err := visit(k, filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scanRange calls f on the key-value pairs with keys in [kStart, kEnd] in ascending key
+// order.  If f returns false, the scan stops.
+func (db *FileStore) scanRange(kStart, kEnd storage.Key, keysOnly bool, f func(*storage.KeyValue) bool) error {
+	err := db.walk(db.path, []byte{}, kStart, kEnd, keysOnly, f)
+	if err == errStopScan {
+		return nil
+	}
+	return err
+}
+
+// readFile returns the value for a key or nil if it doesn't exist.
+func (db *FileStore) readFile(k storage.Key) ([]byte, error) {
+	_, path, err := db.filePath(k)
+	if err != nil {
+		return nil, err
+	}
+	v, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return v, err
+}
+
+// writeFile atomically writes a value by renaming a temporary file into place.
+func (db *FileStore) writeFile(k storage.Key, v []byte) error {
+	dir, path, err := db.filePath(k)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(v); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// removeFile deletes the file for a key.  Deleting a missing key is not an error.
+func (db *FileStore) removeFile(k storage.Key) error {
+	_, path, err := db.filePath(k)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *FileStore) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call Get on nil FileStore")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	if !ctx.Versioned() {
+		v, err := db.readFile(ctx.ConstructKey(tk))
+		if err != nil {
+			return nil, err
+		}
+		storage.StoreValueBytesRead <- len(v)
+		return v, nil
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	values := []*storage.KeyValue{}
+	err = db.scanRange(begKey, endKey, true, func(kv *storage.KeyValue) bool {
+		values = append(values, kv)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	kv, err := vctx.VersionedKeyValue(values)
+	if err != nil || kv == nil {
+		return nil, err
+	}
+	v, err := db.readFile(kv.K)
+	if err != nil {
+		return nil, err
+	}
+	storage.StoreValueBytesRead <- len(v)
+	return v, nil
+}
+
+// Exists returns true if a value is stored for the key without reading its file.
+func (db *FileStore) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists on nil FileStore")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if !ctx.Versioned() {
+		_, path, err := db.filePath(ctx.ConstructKey(tk))
+		if err != nil {
+			return false, err
+		}
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return false, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return false, err
+	}
+	versions := []*storage.KeyValue{}
+	err = db.scanRange(begKey, endKey, true, func(kv *storage.KeyValue) bool {
+		versions = append(versions, kv)
+		return true
+	})
+	if err != nil {
+		return false, err
+	}
+	kv, err := vctx.VersionedKeyValue(versions)
+	return kv != nil, err
+}
+
+// rangeQuery calls f on each key-value pair visible in the context's version with
+// type-specific keys in [kStart, kEnd].
+func (db *FileStore) rangeQuery(ctx storage.Context, kStart, kEnd storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	var ferr error
+	if !ctx.Versioned() {
+		err := db.scanRange(ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd), keysOnly, func(kv *storage.KeyValue) bool {
+			ferr = f(kv)
+			return ferr == nil
+		})
+		if err != nil {
+			return err
+		}
+		return ferr
+	}
+
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return fmt.Errorf("context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	minKey, err := vctx.MinVersionKey(kStart)
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(kEnd)
+	if err != nil {
+		return err
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(kStart)
+	if err != nil {
+		return err
+	}
+	values := []*storage.KeyValue{}
+	sendKV := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil || kv == nil {
+			return err
+		}
+		return f(kv)
+	}
+	err = db.scanRange(minKey, maxKey, keysOnly, func(kv *storage.KeyValue) bool {
+		// Did we pass all versions for last key read?
+		if bytes.Compare(kv.K, maxVersionKey) > 0 {
+			var tk storage.TKey
+			if tk, ferr = storage.TKeyFromKey(kv.K); ferr != nil {
+				return false
+			}
+			if maxVersionKey, ferr = vctx.MaxVersionKey(tk); ferr != nil {
+				return false
+			}
+			if ferr = sendKV(); ferr != nil {
+				return false
+			}
+			values = []*storage.KeyValue{}
+		}
+		values = append(values, kv)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if ferr != nil {
+		return ferr
+	}
+	return sendKV()
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *FileStore) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil FileStore")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	keys := []storage.TKey{}
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, tk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *FileStore) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, ch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil FileStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		ch <- kv.K
+		return nil
+	})
+	ch <- nil
+	return err
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *FileStore) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil FileStore")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	values := []*storage.TKeyValue{}
+	err := db.rangeQuery(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: kv.V})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *FileStore) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil FileStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	f = storage.CancelableChunkFunc(ctx, f)
+	return db.rangeQuery(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tkv := storage.TKeyValue{K: tk, V: kv.V}
+		return f(&storage.Chunk{ChunkOp: op, TKeyValue: &tkv})
+	})
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (db *FileStore) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil FileStore")
+	}
+	var cancelled bool
+	err := db.scanRange(kStart, kEnd, keysOnly, func(kv *storage.KeyValue) bool {
+		select {
+		case out <- kv:
+			return true
+		case <-ctx.Done():
+			cancelled = true
+			return false
+		}
+	})
+	if err != nil || cancelled {
+		return err
+	}
+	out <- nil
+	return nil
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *FileStore) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil FileStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Put(tk, v)
+	return batch.Commit()
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *FileStore) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil FileStore")
+	}
+	if err := db.writeFile(k, v); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *FileStore) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil FileStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	return batch.Commit()
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *FileStore) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil FileStore")
+	}
+	return db.removeFile(k)
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+func (db *FileStore) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange on nil FileStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	return batch.Commit()
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.  For versioned
+// contexts, tombstones are written for the deleted keys.
+func (db *FileStore) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil FileStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+	var tkeys []storage.TKey
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		tkeys = append(tkeys, tk)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	batch := db.NewBatch(ctx)
+	for _, tk := range tkeys {
+		batch.Delete(tk)
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("Error on batch commit of DeleteRange: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", len(tkeys), ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+func (db *FileStore) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil FileStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	var deleteVersion dvid.VersionID
+	if !allVersions {
+		vctx, versioned := ctx.(storage.VersionedCtx)
+		if !versioned {
+			return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+		}
+		deleteVersion = vctx.VersionID()
+	}
+	minKey, maxKey := ctx.KeyRange()
+
+	var numKV int
+	var delErr error
+	err := db.scanRange(minKey, maxKey, true, func(kv *storage.KeyValue) bool {
+		if bytes.Equal(kv.K, maxKey) {
+			return false
+		}
+		if !allVersions {
+			_, v, _, err := storage.DataKeyToLocalIDs(kv.K)
+			if err != nil || v != deleteVersion {
+				return true
+			}
+		}
+		if delErr = db.removeFile(kv.K); delErr != nil {
+			return false
+		}
+		numKV++
+		return true
+	})
+	if err == nil {
+		err = delErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error on DELETE ALL for %s: %v", ctx, err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", numKV, ctx)
+	return nil
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	key    storage.Key
+	value  []byte
+	delete bool
+}
+
+type goBatch struct {
+	db   *FileStore
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	ops  []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes.  Operations are
+// applied in order on Commit but not atomically.
+func (db *FileStore) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil FileStore\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	var vctx storage.VersionedCtx
+	if ctx.Versioned() {
+		vctx, _ = ctx.(storage.VersionedCtx)
+	}
+	return &goBatch{db: db, ctx: ctx, vctx: vctx}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, value: dvid.EmptyValue()})
+	}
+	batch.ops = append(batch.ops, batchOp{key: key, delete: true})
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, delete: true})
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.ops = append(batch.ops, batchOp{key: key, value: v})
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	for i, op := range batch.ops {
+		var err error
+		if op.delete {
+			err = batch.db.removeFile(op.key)
+		} else {
+			err = batch.db.writeFile(op.key, op.value)
+		}
+		if err != nil {
+			return fmt.Errorf("Error on batch commit at operation %d of %d to %s: %v", i, len(batch.ops), batch.db, err)
+		}
+	}
+	batch.ops = nil
+	return nil
+}
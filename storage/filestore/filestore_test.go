@@ -0,0 +1,92 @@
+// +build filestore
+
+package filestore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func testConfig(c map[string]interface{}) dvid.StoreConfig {
+	var config dvid.Config
+	config.SetAll(c)
+	return dvid.StoreConfig{Config: config, Engine: "filestore"}
+}
+
+func TestFilestoreConfig(t *testing.T) {
+	bad := []map[string]interface{}{
+		{},
+		{"path": 3},
+		{"path": "db", "testing": "yes"},
+		{"path": "db", "shardbytes": "2"},
+		{"path": "db", "shardbytes": -1},
+	}
+	for _, c := range bad {
+		if _, _, err := parseConfig(testConfig(c)); err == nil {
+			t.Errorf("expected error parsing configuration %v\n", c)
+		}
+	}
+	path, shardBytes, err := parseConfig(testConfig(map[string]interface{}{"path": "db", "testing": true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(os.TempDir(), "db") || shardBytes != DefaultShardBytes {
+		t.Errorf("expected test store in temp directory with default shards, got %q, %d\n", path, shardBytes)
+	}
+	if _, shardBytes, _ = parseConfig(testConfig(map[string]interface{}{"path": "db", "shardbytes": int64(2)})); shardBytes != 2 {
+		t.Errorf("expected 2 shard bytes, got %d\n", shardBytes)
+	}
+}
+
+func TestFilestoreShards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dvid-filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var e Engine
+	db, _, err := e.newFileStore(testConfig(map[string]interface{}{"path": dir, "shardbytes": 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys shorter than, ending at, and longer than the shard directories.
+	keys := []storage.Key{{1}, {1, 0}, {1, 0, 0}, {1, 0, 5}, {1, 1}, {2}}
+	for i := len(keys) - 1; i >= 0; i-- {
+		if err := db.RawPut(keys[i], []byte(fmt.Sprintf("%x", keys[i]))); err != nil {
+			t.Fatalf("error on raw put of key %v: %v\n", keys[i], err)
+		}
+	}
+	for _, name := range []string{"01/_", "01/00/_", "01/00/00", "01/00/05", "01/01/_", "02/_"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("expected file %s: %v\n", name, err)
+		}
+	}
+
+	// Ranges are in key order across the shard directories.
+	out := make(chan *storage.KeyValue, len(keys)+1)
+	if err := db.RawRangeQuery(context.Background(), storage.Key{1}, storage.Key{1, 1}, false, out); err != nil {
+		t.Fatalf("error on raw range query: %v\n", err)
+	}
+	for _, k := range keys[:5] {
+		kv := <-out
+		if kv == nil || !bytes.Equal(kv.K, k) || string(kv.V) != fmt.Sprintf("%x", k) {
+			t.Fatalf("expected key %v in range, got %v\n", k, kv)
+		}
+	}
+	if kv := <-out; kv != nil {
+		t.Errorf("expected end of range after key %v, got %v\n", keys[4], kv)
+	}
+
+	if err := db.RawPut(bytes.Repeat([]byte{1}, maxNameLength), []byte("long")); err == nil {
+		t.Errorf("expected error on key too long for a file name\n")
+	}
+}
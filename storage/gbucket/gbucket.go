@@ -46,6 +46,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	api "cloud.google.com/go/storage"
@@ -149,10 +150,9 @@ func parseConfig(config dvid.StoreConfig) (*GBucket, error) {
 		return nil, fmt.Errorf("%q setting must be a string (%v)", "bucket", v)
 	}
 	gb := &GBucket{
-		bname:          bucket,
-		ctx:            context.Background(),
-		activeRequests: make(chan chan int, MAXCONNECTIONS),
-		activeOps:      make(chan interface{}, MAXNETOPS),
+		bname:     bucket,
+		ctx:       context.Background(),
+		scheduler: storage.NewScheduler(MAXNETOPS),
 	}
 	return gb, nil
 }
@@ -232,9 +232,6 @@ func (e *Engine) newGBucket(config dvid.StoreConfig) (*GBucket, bool, error) {
 		// TODO: pre-populate based on custom repo names
 	}
 
-	// launch background job handler
-	go gb.backgroundRequestHandler()
-
 	// assume if not newly created that data exists
 	// 'created' not meaningful if concurrent calls
 	return gb, created, nil
@@ -246,15 +243,16 @@ func (e Engine) Delete(config dvid.StoreConfig) error {
 }
 
 type GBucket struct {
-	bname          string
-	bucket         *api.BucketHandle
-	bucket_attrs   *api.BucketAttrs
-	activeRequests chan chan int
-	activeOps      chan interface{}
-	ctx            context.Context
-	client         *api.Client
-	version        float64
-	vsize          int32
+	// jobs counts network ops and is first for 64-bit alignment of atomic access.
+	jobs         uint64
+	bname        string
+	bucket       *api.BucketHandle
+	bucket_attrs *api.BucketAttrs
+	scheduler    *storage.Scheduler
+	ctx          context.Context
+	client       *api.Client
+	version      float64
+	vsize        int32
 	// repo2bucket assumes unique bucket names for now
 	// TODO: handle bucket name conflicts; allow renaming of master bucket
 	repo2bucket map[string]*api.BucketHandle
@@ -292,31 +290,20 @@ func (k KeyArray) Len() int {
 	return len(k)
 }
 
-// backgroundReuqestHandler is a background routine for handling queuing
-func (db *GBucket) backgroundRequestHandler() {
-	jobid := int(0)
-	// get request from queue
-	for true {
-		request := <-db.activeRequests
-		// add to job queue
-		db.activeOps <- nil
-
-		// if succesfully added, signal requester
-		request <- jobid
-		jobid += 1
-	}
-}
-
 // grabOpResource is a blocking function to grab a resource and returns jobid
 func (db *GBucket) grabOpResource() int {
-	waitchan := make(chan int, 1)
-	db.activeRequests <- waitchan
-	jobid := <-waitchan
-	return jobid
+	return db.grabOpResourceFor(storage.PriorityNormal, "")
+}
+
+// grabOpResourceFor grabs a resource scheduled by the priority and client of a request
+// so bulk jobs don't starve interactive requests of network ops.
+func (db *GBucket) grabOpResourceFor(p storage.Priority, client string) int {
+	db.scheduler.Acquire(context.Background(), p, client)
+	return int(atomic.AddUint64(&db.jobs, 1) - 1)
 }
 
 func (db *GBucket) releaseOpResource() {
-	<-db.activeOps
+	db.scheduler.Release()
 }
 
 // bucketHandle retrieves the bucket handle based on the context
@@ -1583,6 +1570,19 @@ func (db *GBucket) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
 	return val, nil
 }
 
+// Exists returns true if a value is stored for the key.  Resolving the visible version
+// of a key requires reading its object, so this is no cheaper than Get.
+func (db *GBucket) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	db.grabOpResource()
+	defer db.releaseOpResource()
+
+	val, err := db.getVTKey(ctx, tk, true)
+	if err != nil {
+		return false, err
+	}
+	return val != nil, nil
+}
+
 // KeysInRange returns a range of type-specific key components spanning (TkBeg, TkEnd).
 func (db *GBucket) KeysInRange(ctx storage.Context, TkBeg, TkEnd storage.TKey) ([]storage.TKey, error) {
 	if db == nil {
@@ -1688,13 +1688,58 @@ func (db *GBucket) ProcessRange(ctx storage.Context, TkBeg, TkEnd storage.TKey,
 	return err
 }
 
-// RawRangeQuery sends a range of full keys [not implemneted).
-// This is to be used for low-level data
-// retrieval like DVID-to-DVID communication and should not be used by data type
-// implementations if possible because each version's key-value pairs are sent
-// without filtering by the current version and its ancestor graph.
-func (db *GBucket) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
-	dvid.Criticalf("GBucket does not support RawRangeQuery")
+// RawRangeQuery sends a range of full keys in the master bucket.  This is to be used for
+// low-level data retrieval like DVID-to-DVID communication and should not be used by
+// data type implementations if possible because each version's key-value pairs are sent
+// without filtering by the current version and its ancestor graph.  A nil is sent down
+// the channel when the range is complete.  If the context is cancelled, the query returns
+// without sending the final nil.
+func (db *GBucket) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery() on nil GBucket")
+	}
+
+	// Objects are listed in order of their hex-encoded names, which is the order of keys.
+	object_list := db.bucket.Objects(ctx, &api.Query{Prefix: grabPrefix(kStart, kEnd)})
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		object_attr, err := object_list.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		key, err := hex.DecodeString(object_attr.Name)
+		if err != nil {
+			return err
+		}
+		if bytes.Compare(key, kStart) < 0 {
+			continue
+		}
+		if bytes.Compare(key, kEnd) > 0 {
+			break
+		}
+		kv := &storage.KeyValue{K: storage.Key(key)}
+		if !keysOnly {
+			if kv.V, err = db.getVhandle(db.bucket.Object(object_attr.Name)); err != nil {
+				return err
+			}
+		}
+		select {
+		case out <- kv:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	out <- nil
 	return nil
 }
 
@@ -2099,10 +2144,12 @@ type dbOp struct {
 
 // goBuffer allow operations to be submitted in parallel
 type goBuffer struct {
-	db    *GBucket
-	ctx   storage.Context
-	ops   []dbOp
-	mutex sync.Mutex
+	db       *GBucket
+	ctx      storage.Context
+	ops      []dbOp
+	mutex    sync.Mutex
+	priority storage.Priority
+	client   string
 }
 
 // NewBatch returns an implementation that allows batch writes
@@ -2111,11 +2158,24 @@ func (db *GBucket) NewBuffer(ctx storage.Context) storage.RequestBuffer {
 		dvid.Criticalf("Received nil context in NewBatch()")
 		return nil
 	}
-	return &goBuffer{db: db, ctx: ctx}
+	p, client := storage.RequestPriority(storage.RequestContext(ctx))
+	return &goBuffer{db: db, ctx: ctx, priority: p, client: client}
+}
+
+// grab is a blocking function to grab a resource at the buffer's priority and returns jobid
+func (buffer *goBuffer) grab() int {
+	return buffer.db.grabOpResourceFor(buffer.priority, buffer.client)
 }
 
 // --- implement RequestBuffer interface ---
 
+// SetPriority sets the priority and client used to schedule the buffer's operations.
+func (buffer *goBuffer) SetPriority(p storage.Priority, client string) {
+	buffer.mutex.Lock()
+	buffer.priority, buffer.client = p, client
+	buffer.mutex.Unlock()
+}
+
 // ProcessRange sends a range of type key-value pairs to type-specific chunk handlers,
 // allowing chunk processing to be concurrent with key-value sequential reads.
 // Since the chunks are typically sent during sequential read iteration, the
@@ -2128,6 +2188,7 @@ func (db *goBuffer) ProcessRange(ctx storage.Context, TkBeg, TkEnd storage.TKey,
 	if ctx == nil {
 		return fmt.Errorf("Received nil context in GetRange()")
 	}
+	f = storage.CancelableChunkFunc(ctx, f)
 
 	db.mutex.Lock()
 	db.ops = append(db.ops, dbOp{getOp, nil, nil, TkBeg, TkEnd, op, f, nil})
@@ -2322,7 +2383,7 @@ func (buffer *goBuffer) Flush() error {
 	// limits the number of simultaneous requests (should this be global)
 
 	for itnum, operation := range buffer.ops {
-		opid := buffer.db.grabOpResource()
+		opid := buffer.grab()
 		go func(opdata dbOp, currnum int) {
 			defer func() {
 				buffer.db.releaseOpResource()
@@ -2416,7 +2477,7 @@ func (db *goBuffer) deleteRangeLocalResource(ctx storage.Context, TkBeg, TkEnd s
 	for _, key := range keys {
 		wg.Add(1)
 		// use available threads
-		db.db.grabOpResource()
+		db.grab()
 		go func(lkey storage.Key) {
 			defer func() {
 				db.db.releaseOpResource()
@@ -2439,7 +2500,7 @@ func (db *goBuffer) deleteRangeLocalResource(ctx storage.Context, TkBeg, TkEnd s
 	wg.Wait()
 
 	// hackish -- reask for resource
-	db.db.grabOpResource()
+	db.grab()
 
 	return nil
 }
@@ -2483,7 +2544,7 @@ func (db *goBuffer) processRangeLocalResource(ctx storage.Context, TkBeg, TkEnd
 	keyvalchan := make(chan keyvalue_t, len(keys))
 	for _, key := range keys {
 		// use available threads
-		db.db.grabOpResource()
+		db.grab()
 		go func(lkey storage.Key) {
 			defer func() {
 				db.db.releaseOpResource()
@@ -2506,7 +2567,7 @@ func (db *goBuffer) processRangeLocalResource(ctx storage.Context, TkBeg, TkEnd
 	}
 
 	// hackish -- reask for resource
-	db.db.grabOpResource()
+	db.grab()
 
 	var err error
 	for _, key := range keys {
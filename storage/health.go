@@ -0,0 +1,70 @@
+// +build !clustered,!gcloud
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// StoreHealth is the result of checking that a store responds to reads.
+type StoreHealth struct {
+	Store   string  // description of the store
+	Seconds float64 // time taken by the check
+	Checked bool    // false if the store doesn't support key-value reads, e.g., a log store.
+	Error   string  `json:",omitempty"`
+}
+
+// healthProbeKey is read from each store to check it is reachable.  It needn't exist.
+var healthProbeKey = NewTKey(TKeyClass(0xFF), []byte("dvid health probe"))
+
+// CheckStores reads a key from each store and reports any that fail or don't respond
+// within the timeout.
+func CheckStores(timeout time.Duration) (map[Alias]StoreHealth, error) {
+	stores, err := AllStores()
+	if err != nil {
+		return nil, err
+	}
+	type result struct {
+		alias  Alias
+		health StoreHealth
+	}
+	ch := make(chan result, len(stores))
+	for alias, store := range stores {
+		go func(alias Alias, store dvid.Store) {
+			health := StoreHealth{Store: store.String()}
+			if db, ok := store.(KeyValueGetter); ok {
+				start := time.Now()
+				_, err := db.Get(NewMetadataContext(), healthProbeKey)
+				health.Seconds = time.Since(start).Seconds()
+				health.Checked = true
+				if err != nil {
+					health.Error = err.Error()
+				}
+			}
+			ch <- result{alias, health}
+		}(alias, store)
+	}
+	health := make(map[Alias]StoreHealth, len(stores))
+	deadline := time.After(timeout)
+	for len(health) < len(stores) {
+		select {
+		case r := <-ch:
+			health[r.alias] = r.health
+		case <-deadline:
+			for alias, store := range stores {
+				if _, found := health[alias]; !found {
+					health[alias] = StoreHealth{
+						Store:   store.String(),
+						Seconds: timeout.Seconds(),
+						Checked: true,
+						Error:   fmt.Sprintf("no response within %s", timeout),
+					}
+				}
+			}
+		}
+	}
+	return health, nil
+}
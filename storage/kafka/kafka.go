@@ -0,0 +1,224 @@
+// +build kafka
+
+/*
+	Package kafka implements an append-only log engine that publishes each mutation record
+	to a Kafka topic, so downstream pipelines can react to edits in near real time.
+
+	Each record is a message keyed by the data instance UUID, so all mutations of a data
+	instance go to one partition and are consumed in order.  The message value is the
+	record data, e.g., a serialized split or merge, and headers give the record's
+	"EntryType" as a decimal string, "DataUUID", and "Version" UUID.  Headers require
+	Kafka 0.11 or later.
+*/
+package kafka
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/semver"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	// DefaultTopic is the topic used if none is configured.
+	DefaultTopic = "dvid-mutations"
+
+	// DefaultRetries is the number of times a failed publish is retried.
+	DefaultRetries = 5
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in kafka: %v\n", err)
+	}
+	e := Engine{"kafka", "Kafka mutation log publisher", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return true
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a Kafka log.  The passed Config must contain:
+// "brokers": list of broker addresses, e.g., ["kafka1:9092", "kafka2:9092"]
+// Optional settings:
+// "topic": topic receiving mutation records (default "dvid-mutations")
+// "clientid": client ID reported to the brokers (default "dvid")
+// "retries": number of retries of a failed publish (default 5)
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newKafkaLog(config)
+}
+
+type kafkaConfig struct {
+	brokers  []string
+	topic    string
+	clientID string
+	retries  int
+}
+
+func parseConfig(config dvid.StoreConfig) (kc kafkaConfig, err error) {
+	c := config.GetAll()
+	kc.topic = DefaultTopic
+	kc.clientID = "dvid"
+	kc.retries = DefaultRetries
+
+	v, found := c["brokers"]
+	if !found {
+		err = fmt.Errorf("%q must be specified for kafka configuration", "brokers")
+		return
+	}
+	switch brokers := v.(type) {
+	case string:
+		kc.brokers = strings.Split(brokers, ",")
+	case []interface{}:
+		for _, broker := range brokers {
+			s, ok := broker.(string)
+			if !ok {
+				err = fmt.Errorf("%q setting must be a list of strings (%v)", "brokers", v)
+				return
+			}
+			kc.brokers = append(kc.brokers, s)
+		}
+	case []string:
+		kc.brokers = brokers
+	default:
+		err = fmt.Errorf("%q setting must be a list of strings (%v)", "brokers", v)
+		return
+	}
+	if len(kc.brokers) == 0 {
+		err = fmt.Errorf("at least one broker must be given for kafka configuration")
+		return
+	}
+
+	if v, found = c["topic"]; found {
+		var ok bool
+		if kc.topic, ok = v.(string); !ok || kc.topic == "" {
+			err = fmt.Errorf("%q setting must be a non-empty string (%v)", "topic", v)
+			return
+		}
+	}
+	if v, found = c["clientid"]; found {
+		var ok bool
+		if kc.clientID, ok = v.(string); !ok {
+			err = fmt.Errorf("%q setting must be a string (%v)", "clientid", v)
+			return
+		}
+	}
+	if v, found = c["retries"]; found {
+		switch n := v.(type) {
+		case int64:
+			kc.retries = int(n)
+		case int:
+			kc.retries = n
+		default:
+			err = fmt.Errorf("%q setting must be an integer (%v)", "retries", v)
+			return
+		}
+	}
+	return
+}
+
+// newKafkaLog returns a log that publishes to a Kafka topic.  Since Kafka creates
+// topics or rejects publishing on its own, the log is never reported as created.
+func (e Engine) newKafkaLog(config dvid.StoreConfig) (*KafkaLog, bool, error) {
+	kc, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	sc := sarama.NewConfig()
+	sc.ClientID = kc.clientID
+	sc.Version = sarama.V0_11_0_0
+	sc.Producer.RequiredAcks = sarama.WaitForAll
+	sc.Producer.Retry.Max = kc.retries
+	sc.Producer.Return.Successes = true
+	sc.Producer.Partitioner = sarama.NewHashPartitioner
+
+	producer, err := sarama.NewSyncProducer(kc.brokers, sc)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to connect to kafka brokers %v: %v", kc.brokers, err)
+	}
+	dvid.Infof("Publishing mutation log to kafka topic %q @ %s\n", kc.topic, strings.Join(kc.brokers, ","))
+	return &KafkaLog{kafkaConfig: kc, producer: producer}, false, nil
+}
+
+// KafkaLog is an append-only log that publishes records to a Kafka topic.  Records
+// can't be read back through DVID; consumers read the topic directly.
+type KafkaLog struct {
+	kafkaConfig
+	producer sarama.SyncProducer
+}
+
+func (kl *KafkaLog) String() string {
+	return fmt.Sprintf("kafka topic %q @ %s", kl.topic, strings.Join(kl.brokers, ","))
+}
+
+// Append publishes a record and returns after all in-sync replicas have it.
+func (kl *KafkaLog) Append(entryType uint16, dataID, version dvid.UUID, data []byte) error {
+	if kl == nil || kl.producer == nil {
+		return fmt.Errorf("can't append to closed or nil kafka log")
+	}
+	msg := &sarama.ProducerMessage{
+		Topic: kl.topic,
+		Key:   sarama.StringEncoder(dataID),
+		Value: sarama.ByteEncoder(data),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("EntryType"), Value: []byte(strconv.Itoa(int(entryType)))},
+			{Key: []byte("DataUUID"), Value: []byte(dataID)},
+			{Key: []byte("Version"), Value: []byte(version)},
+		},
+	}
+	if _, _, err := kl.producer.SendMessage(msg); err != nil {
+		return fmt.Errorf("append log %q: %v", kl, err)
+	}
+	return nil
+}
+
+// Close closes the producer after any pending records are published.
+func (kl *KafkaLog) Close() {
+	if kl != nil && kl.producer != nil {
+		if err := kl.producer.Close(); err != nil {
+			dvid.Errorf("closing %s: %v\n", kl, err)
+		}
+		kl.producer = nil
+	}
+}
+
+// Equal returns true if the kafka log matches the given store configuration.
+func (kl *KafkaLog) Equal(config dvid.StoreConfig) bool {
+	kc, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return kl.topic == kc.topic && strings.Join(kl.brokers, ",") == strings.Join(kc.brokers, ",")
+}
@@ -0,0 +1,108 @@
+// +build kafka
+
+package kafka
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/go/toml"
+
+	"github.com/Shopify/sarama/mocks"
+)
+
+const testConfig = `
+[store.mutations]
+engine = "kafka"
+brokers = ["kafka1:9092", "kafka2:9092"]
+topic = "flyem-edits"
+retries = 3
+`
+
+func storeConfig(c map[string]interface{}) dvid.StoreConfig {
+	var config dvid.Config
+	config.SetAll(c)
+	return dvid.StoreConfig{Config: config, Engine: "kafka"}
+}
+
+func TestParseConfig(t *testing.T) {
+	var tc struct {
+		Store map[string]map[string]interface{}
+	}
+	if _, err := toml.Decode(testConfig, &tc); err != nil {
+		t.Fatalf("Could not decode TOML config: %v\n", err)
+	}
+	kc, err := parseConfig(storeConfig(tc.Store["mutations"]))
+	if err != nil {
+		t.Fatalf("Error parsing kafka config: %v\n", err)
+	}
+	expected := kafkaConfig{
+		brokers:  []string{"kafka1:9092", "kafka2:9092"},
+		topic:    "flyem-edits",
+		clientID: "dvid",
+		retries:  3,
+	}
+	if !reflect.DeepEqual(kc, expected) {
+		t.Errorf("expected kafka config %+v, got %+v\n", expected, kc)
+	}
+
+	kc, err = parseConfig(storeConfig(map[string]interface{}{"brokers": "kafka1:9092,kafka2:9092"}))
+	if err != nil {
+		t.Fatalf("Error parsing kafka config: %v\n", err)
+	}
+	expected = kafkaConfig{
+		brokers:  []string{"kafka1:9092", "kafka2:9092"},
+		topic:    DefaultTopic,
+		clientID: "dvid",
+		retries:  DefaultRetries,
+	}
+	if !reflect.DeepEqual(kc, expected) {
+		t.Errorf("expected kafka config with defaults %+v, got %+v\n", expected, kc)
+	}
+
+	for _, bad := range []map[string]interface{}{
+		{},
+		{"brokers": []interface{}{}},
+		{"brokers": []interface{}{9092}},
+		{"brokers": "kafka1:9092", "topic": ""},
+		{"brokers": "kafka1:9092", "retries": "many"},
+	} {
+		if _, err := parseConfig(storeConfig(bad)); err == nil {
+			t.Errorf("expected error parsing kafka config %v\n", bad)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	kl := &KafkaLog{
+		kafkaConfig: kafkaConfig{brokers: []string{"kafka1:9092"}, topic: DefaultTopic},
+		producer:    producer,
+	}
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(val []byte) error {
+		if string(val) != "split" {
+			return fmt.Errorf("expected record data %q, got %q", "split", val)
+		}
+		return nil
+	})
+	producer.ExpectSendMessageAndFail(fmt.Errorf("broker down"))
+	if err := kl.Append(1, dvid.UUID("data"), dvid.UUID("version"), []byte("split")); err != nil {
+		t.Errorf("error on append: %v\n", err)
+	}
+	if err := kl.Append(1, dvid.UUID("data"), dvid.UUID("version"), []byte("merge")); err == nil {
+		t.Errorf("expected error on failed publish\n")
+	}
+	if !kl.Equal(storeConfig(map[string]interface{}{"brokers": "kafka1:9092"})) {
+		t.Errorf("expected kafka log to equal its configuration\n")
+	}
+	if kl.Equal(storeConfig(map[string]interface{}{"brokers": "kafka1:9092", "topic": "other"})) {
+		t.Errorf("expected kafka log not to equal configuration with another topic\n")
+	}
+
+	kl.Close()
+	if err := kl.Append(1, dvid.UUID("data"), dvid.UUID("version"), []byte("split")); err == nil {
+		t.Errorf("expected error on append to closed log\n")
+	}
+}
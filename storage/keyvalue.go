@@ -47,8 +47,10 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/janelia-flyem/dvid/dvid"
 )
@@ -176,6 +178,22 @@ type Chunk struct {
 // ChunkFunc is a function that accepts a Chunk.
 type ChunkFunc func(*Chunk) error
 
+// CancelableChunkFunc returns a ChunkFunc that returns the request's error instead of
+// calling f once the request associated with the storage Context is cancelled.  Engines
+// use it so ProcessRange stops when a request is abandoned.
+func CancelableChunkFunc(ctx Context, f ChunkFunc) ChunkFunc {
+	reqCtx := RequestContext(ctx)
+	if reqCtx.Done() == nil {
+		return f
+	}
+	return func(c *Chunk) error {
+		if err := reqCtx.Err(); err != nil {
+			return err
+		}
+		return f(c)
+	}
+}
+
 // PatchFunc is a function that accepts a value and patches that value in place
 type PatchFunc func([]byte) ([]byte, error)
 
@@ -206,6 +224,11 @@ type KeyValueIngestable interface {
 type KeyValueGetter interface {
 	// Get returns a value given a key.
 	Get(ctx Context, k TKey) ([]byte, error)
+
+	// Exists returns true if Get would return a value for the key.  Stores avoid reading
+	// the value where possible, and engines with bloom filters can reject most missing
+	// keys without any disk reads.
+	Exists(ctx Context, k TKey) (bool, error)
 }
 
 type OrderedKeyValueGetter interface {
@@ -226,16 +249,17 @@ type OrderedKeyValueGetter interface {
 	// receiving function can be organized as a pool of chunk handling goroutines.
 	// See datatype/imageblk.ProcessChunk() for an example.  If the ChunkFunc returns
 	// an error, it is expected that the ProcessRange should immediately terminate and
-	// propagate the error.
+	// propagate the error.  ProcessRange also terminates with the request's error if
+	// the request associated with the context is cancelled (see CancelableChunkFunc).
 	ProcessRange(ctx Context, kStart, kEnd TKey, op *ChunkOp, f ChunkFunc) error
 
 	// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
 	// retrieval like DVID-to-DVID communication and should not be used by data type
 	// implementations if possible because each version's key-value pairs are sent
 	// without filtering by the current version and its ancestor graph.  A nil is sent
-	// down the channel when the range is complete.  The query can be cancelled by sending
-	// a value down the cancel channel.
-	RawRangeQuery(kStart, kEnd Key, keysOnly bool, out chan *KeyValue, cancel <-chan struct{}) error
+	// down the channel when the range is complete.  If the context is cancelled, the
+	// query returns without sending the final nil.
+	RawRangeQuery(ctx context.Context, kStart, kEnd Key, keysOnly bool, out chan *KeyValue) error
 }
 
 type KeyValueSetter interface {
@@ -290,6 +314,41 @@ type OrderedKeyValueDB interface {
 	OrderedKeyValueSetter
 }
 
+// KeyValueBatchGetter stores can get many keys more efficiently than with individual
+// Gets, e.g., by sharing an iterator or coalescing network round trips.
+type KeyValueBatchGetter interface {
+	// GetBatch returns the values of the given keys in the same order, with a nil value
+	// for each key that isn't found.
+	GetBatch(ctx Context, tks []TKey) ([][]byte, error)
+}
+
+// GetBatch returns the values of the given keys in the same order, with a nil value for
+// each key that isn't found.  Stores that don't provide an optimized GetBatch are
+// queried with a Get for each key.
+func GetBatch(db KeyValueGetter, ctx Context, tks []TKey) ([][]byte, error) {
+	if batchGetter, ok := db.(KeyValueBatchGetter); ok {
+		return batchGetter.GetBatch(ctx, tks)
+	}
+	values := make([][]byte, len(tks))
+	for i, tk := range tks {
+		v, err := db.Get(ctx, tk)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// TTLPutter stores can expire key-value pairs after a time-to-live, after which the
+// engine garbage collects them and they are no longer returned by gets or range queries.
+// Stores wrapped for encryption or mutation tracking don't support TTLs.
+type TTLPutter interface {
+	// PutTTL writes a value with given key in a possibly versioned context that expires
+	// after the given duration.
+	PutTTL(ctx Context, tk TKey, v []byte, ttl time.Duration) error
+}
+
 // KeyValueBatcher allow batching operations into an atomic update or transaction.
 // For example: "Atomic Updates" in http://leveldb.googlecode.com/svn/trunk/doc/index.html
 type KeyValueBatcher interface {
@@ -303,7 +362,8 @@ type KeyValueRequester interface {
 	NewBuffer(ctx Context) RequestBuffer
 }
 
-// TransactionDB allows multiple database operations to execute as an atomic transaction
+// TransactionDB allows multiple database operations to execute as an atomic transaction.
+// The package-level LockKey, UnlockKey, and Patch functions emulate it for other stores.
 type TransactionDB interface {
 	// LockKey uses atomic get/put to use the key as a shared lock.
 	// If the lock is being used, the lock will be queried with some backoff.
@@ -317,6 +377,13 @@ type TransactionDB interface {
 	Patch(Context, TKey, PatchFunc) error
 }
 
+// LocalDB is implemented by stores that exist only within this server's process, so
+// no other server can modify their data even if they implement TransactionDB.
+type LocalDB interface {
+	// IsLocal returns true if the store can't be shared with other servers.
+	IsLocal() bool
+}
+
 // RequestBufferSubset implements a subset of the ordered key/value interface.
 // It declares interface common to both ordered key value and RequestBuffer
 type BufferableOps interface {
@@ -339,10 +406,15 @@ type BufferableOps interface {
 // RequestBuffer allows one to queue up several requests and then process
 // them all as a batch operation (if the driver supports batch
 // operations).  There is no guarantee on the order or atomicity
-// of these operations.
+// of these operations.  Operations are scheduled against those of other
+// buffers using the priority and client of the request, which defaults to
+// the one set by WithPriority on the buffer's request context.
 type RequestBuffer interface {
 	BufferableOps
 
+	// SetPriority sets the priority and client used to schedule the buffer's operations.
+	SetPriority(p Priority, client string)
+
 	// ProcessList will process all gets when flush is called
 	ProcessList(ctx Context, tkeys []TKey, op *ChunkOp, f ChunkFunc) error
 
@@ -373,46 +445,51 @@ func getNextInstance(db OrderedKeyValueGetter, curID dvid.InstanceID) (nextID dv
 	endKey := constructDataKey(dvid.MaxInstanceID, dvid.MaxVersionID, dvid.MaxClientID, maxTKey)
 
 	ch := make(chan *KeyValue)
-	cancel := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// Process each key received by range query.
-	ctx := DataContext{}
-	var wg sync.WaitGroup
-	wg.Add(1)
+	// Process each key received by range query, cancelling the query once the next
+	// instance is found.  Keys are drained until the query returns so it never blocks.
+	dctx := DataContext{}
+	done := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		for {
-			kv := <-ch
-			if kv == nil || kv.K == nil {
-				finished = true
-				return
-			}
-			nextID, err = ctx.InstanceFromKey(kv.K)
-			if err != nil {
-				cancel <- struct{}{}
-				return
-			}
-			if nextID != curID {
-				cancel <- struct{}{}
-				return
+		defer close(done)
+		var found bool
+		for kv := range ch {
+			switch {
+			case found:
+			case kv == nil || kv.K == nil:
+				finished, found = true, true
+			default:
+				nextID, err = dctx.InstanceFromKey(kv.K)
+				found = true
+				cancel()
 			}
 		}
 	}()
 
 	keysOnly := true
-	if queryErr := db.RawRangeQuery(begKey, endKey, keysOnly, ch, cancel); queryErr != nil {
+	queryErr := db.RawRangeQuery(ctx, begKey, endKey, keysOnly, ch)
+	close(ch)
+	<-done
+	if queryErr != nil {
 		err = queryErr
 	}
-	wg.Wait()
 	return
 }
 
+// DataKeyRange returns the range of keys holding all versions of a data instance's data.
+func DataKeyRange(instance dvid.InstanceID) KeyRange {
+	return KeyRange{
+		Start:   constructDataKey(instance, 0, 0, minTKey),
+		OpenEnd: constructDataKey(instance+1, 0, 0, minTKey),
+	}
+}
+
 func getInstanceSizes(sv SizeViewer, instances []dvid.InstanceID) (map[dvid.InstanceID]uint64, error) {
 	ranges := make([]KeyRange, len(instances))
 	for i, curID := range instances {
-		beg := constructDataKey(curID, 0, 0, minTKey)
-		end := constructDataKey(curID+1, 0, 0, minTKey)
-		ranges[i] = KeyRange{Start: beg, OpenEnd: end}
+		ranges[i] = DataKeyRange(curID)
 	}
 	s, err := sv.GetApproximateSizes(ranges)
 	if err != nil {
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testBatchGetStore is a store with an optimized GetBatch that counts its calls.
+type testBatchGetStore struct {
+	*testKVStore
+	batchGets int
+}
+
+func (db *testBatchGetStore) GetBatch(ctx Context, tks []TKey) ([][]byte, error) {
+	db.batchGets++
+	values := make([][]byte, len(tks))
+	for i, tk := range tks {
+		values[i], _ = db.Get(ctx, tk)
+	}
+	return values, nil
+}
+
+func TestGetBatch(t *testing.T) {
+	db := &testKVStore{kv: make(map[string][]byte)}
+	var ctx MetadataContext
+	db.Put(ctx, TKey("a"), []byte("a0"))
+	db.Put(ctx, TKey("c"), []byte("c0"))
+	tks := []TKey{TKey("c"), TKey("b"), TKey("a")}
+	expected := [][]byte{[]byte("c0"), nil, []byte("a0")}
+
+	values, err := GetBatch(db, ctx, tks)
+	if err != nil {
+		t.Fatalf("error on get batch: %v\n", err)
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected values %q in key order, got %q\n", expected, values)
+	}
+
+	batchGetter := &testBatchGetStore{testKVStore: db}
+	if values, err = GetBatch(batchGetter, ctx, tks); err != nil {
+		t.Fatalf("error on get batch: %v\n", err)
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected values %q in key order, got %q\n", expected, values)
+	}
+	if batchGetter.batchGets != 1 {
+		t.Errorf("expected store's GetBatch to be used, got %d calls\n", batchGetter.batchGets)
+	}
+}
@@ -0,0 +1,29 @@
+// +build kms
+
+package storage
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// Use AWS KMS to decrypt the data key used for encryption at rest.  Credentials are
+// read from the standard AWS environment variables, shared config, or instance role.
+func init() {
+	KMSDecrypter = func(ciphertext []byte, region string) ([]byte, error) {
+		config := aws.NewConfig()
+		if region != "" {
+			config = config.WithRegion(region)
+		}
+		sess, err := session.NewSession(config)
+		if err != nil {
+			return nil, err
+		}
+		out, err := kms.New(sess).Decrypt(&kms.DecryptInput{CiphertextBlob: ciphertext})
+		if err != nil {
+			return nil, err
+		}
+		return out.Plaintext, nil
+	}
+}
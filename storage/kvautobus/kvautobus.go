@@ -5,6 +5,7 @@ package kvautobus
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
@@ -395,26 +396,35 @@ func (db *KVAutobus) deleteRange(kStart, kEnd storage.Key) error {
 // RawRangeQuery sends a range of full keys.  This is to be used for low-level data
 // retrieval like DVID-to-DVID communication and should not be used by data type
 // implementations if possible.  A nil is sent down the channel when the
-// range is complete.
-func (db *KVAutobus) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
-	var value []byte
+// range is complete.  If the context is cancelled, the query returns without
+// sending the final nil.
+func (db *KVAutobus) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	var kvs []*storage.KeyValue
 	if keysOnly {
 		keys, err := db.getKeyRange(kStart, kEnd)
 		if err != nil {
 			return err
 		}
 		for _, key := range keys {
-			out <- &storage.KeyValue{storage.Key(key), value}
+			kvs = append(kvs, &storage.KeyValue{storage.Key(key), nil})
 		}
 	} else {
-		kvs, err := db.getKVRange(nil, kStart, kEnd)
+		kvRange, err := db.getKVRange(nil, kStart, kEnd)
 		if err != nil {
 			return err
 		}
-		for _, kv := range kvs {
-			out <- &storage.KeyValue{storage.Key(kv[0]), []byte(kv[1])}
+		for _, kv := range kvRange {
+			kvs = append(kvs, &storage.KeyValue{storage.Key(kv[0]), []byte(kv[1])})
 		}
 	}
+	for _, kv := range kvs {
+		select {
+		case out <- kv:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	out <- nil
 	return nil
 }
 
@@ -495,6 +505,13 @@ func (db *KVAutobus) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
 	}
 }
 
+// Exists returns true if a value is stored for the key.  The kvautobus service has no
+// key-only reads, so this fetches the value like Get.
+func (db *KVAutobus) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	v, err := db.Get(ctx, tk)
+	return v != nil, err
+}
+
 // getSingleKeyVersions returns all versions of a key.  These key-value pairs will be sorted
 // in ascending key order and could include a tombstone key.
 func (db *KVAutobus) getSingleKeyVersions(vctx storage.VersionedCtx, k []byte) ([]*storage.KeyValue, error) {
@@ -717,6 +734,7 @@ func (db *KVAutobus) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey
 	if ctx == nil {
 		return fmt.Errorf("Received nil context in ProcessRange()")
 	}
+	f = storage.CancelableChunkFunc(ctx, f)
 	ch := make(chan errorableKV)
 
 	// Run the range query on a potentially versioned key in a goroutine.
@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// LRUCacheConfig handles settings for the read-through LRU cache.
+type LRUCacheConfig struct {
+	MB        int      // Total size of cached keys and values in megabytes.
+	Instances []string // Data instances "<name>:<uuid>" or datatype names that use the cache.
+}
+
+// LRUCacheStats gives counters for the read-through LRU cache.
+type LRUCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+	MaxBytes  int64
+}
+
+// GetLRUCacheStats returns the stats for the read-through LRU cache.
+func GetLRUCacheStats() (stats LRUCacheStats, err error) {
+	if !manager.setup {
+		err = fmt.Errorf("Storage manager not initialized before requesting LRU cache stats")
+		return
+	}
+	if manager.lru.cache == nil {
+		return
+	}
+	return manager.lru.cache.stats(), nil
+}
+
+type lruT struct {
+	cache     *lruCache
+	instances map[dvid.DataSpecifier]struct{}
+	datatypes map[dvid.TypeString]struct{}
+}
+
+func setupLRUCache(config LRUCacheConfig) {
+	if config.MB <= 0 {
+		return
+	}
+	dvid.Infof("Initializing LRU cache with %d MB\n", config.MB)
+	manager.lru.cache = newLRUCache(int64(config.MB) << 20)
+	manager.lru.instances = make(map[dvid.DataSpecifier]struct{})
+	manager.lru.datatypes = make(map[dvid.TypeString]struct{})
+	for _, dataspec := range config.Instances {
+		name := strings.Trim(dataspec, "\"")
+		parts := strings.Split(name, ":")
+		switch len(parts) {
+		case 1:
+			manager.lru.datatypes[dvid.TypeString(name)] = struct{}{}
+		case 2:
+			dataid := dvid.GetDataSpecifier(dvid.InstanceName(parts[0]), dvid.UUID(parts[1]))
+			manager.lru.instances[dataid] = struct{}{}
+		default:
+			dvid.Errorf("bad data specification %q given for LRU cache in config file\n", dataspec)
+		}
+	}
+}
+
+// uses returns true if the given data instance or its datatype should use the LRU cache.
+func (l lruT) uses(dataid dvid.DataSpecifier, typename dvid.TypeString) bool {
+	if l.cache == nil {
+		return false
+	}
+	if _, found := l.instances[dataid]; found {
+		return true
+	}
+	_, found := l.datatypes[typename]
+	return found
+}
+
+type lruEntry struct {
+	key   string
+	unv   string
+	value []byte
+}
+
+func (e *lruEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// lruCache is a size-bounded LRU cache of full keys to values.  Entries are also indexed
+// by unversioned key so a write to any version can invalidate all cached versions.
+type lruCache struct {
+	sync.Mutex
+	maxBytes int64
+	curBytes int64
+	ll       *list.List
+	entries  map[string]*list.Element
+	byUnv    map[string]map[string]struct{}
+
+	// gen is incremented on every invalidation so a Get that read from the store
+	// concurrently with a write doesn't cache a stale value.
+	gen uint64
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newLRUCache(maxBytes int64) *lruCache {
+	return &lruCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		entries:  make(map[string]*list.Element),
+		byUnv:    make(map[string]map[string]struct{}),
+	}
+}
+
+// get returns the cached value, whether it was found, and the generation to be
+// passed to a subsequent add on a miss.
+func (c *lruCache) get(key string) (value []byte, found bool, gen uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.ll.MoveToFront(elem)
+		atomic.AddUint64(&c.hits, 1)
+		return elem.Value.(*lruEntry).value, true, c.gen
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return nil, false, c.gen
+}
+
+func (c *lruCache) add(key, unv string, value []byte, gen uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+	e := &lruEntry{key: key, unv: unv, value: value}
+	if e.size() > c.maxBytes {
+		return
+	}
+	c.entries[key] = c.ll.PushFront(e)
+	keys, found := c.byUnv[unv]
+	if !found {
+		keys = make(map[string]struct{})
+		c.byUnv[unv] = keys
+	}
+	keys[key] = struct{}{}
+	c.curBytes += e.size()
+	for c.curBytes > c.maxBytes {
+		c.removeElement(c.ll.Back())
+		atomic.AddUint64(&c.evictions, 1)
+	}
+}
+
+func (c *lruCache) removeElement(elem *list.Element) {
+	e := c.ll.Remove(elem).(*lruEntry)
+	delete(c.entries, e.key)
+	if keys, found := c.byUnv[e.unv]; found {
+		delete(keys, e.key)
+		if len(keys) == 0 {
+			delete(c.byUnv, e.unv)
+		}
+	}
+	c.curBytes -= e.size()
+}
+
+// invalidate removes all cached versions of the given unversioned key.
+func (c *lruCache) invalidate(unv string) {
+	c.Lock()
+	defer c.Unlock()
+	c.gen++
+	for key := range c.byUnv[unv] {
+		if elem, found := c.entries[key]; found {
+			c.removeElement(elem)
+		}
+	}
+}
+
+// invalidateWrites removes all cached versions of the keys written through the context.
+// If the context's request has an open RequestBatch, where writes are only queued, they
+// are removed again once the batch is committed since reads in the meantime can cache the
+// values being replaced.
+func (c *lruCache) invalidateWrites(ctx Context, tks ...TKey) {
+	unvs := make([]string, len(tks))
+	for i, tk := range tks {
+		unvs[i] = unversionedString(ctx.ConstructKey(tk))
+	}
+	invalidate := func() {
+		for _, unv := range unvs {
+			c.invalidate(unv)
+		}
+	}
+	invalidate()
+	if rb := requestBatch(ctx); rb != nil {
+		rb.afterCommit(invalidate)
+	}
+}
+
+// purge removes all entries.
+func (c *lruCache) purge() {
+	c.Lock()
+	defer c.Unlock()
+	c.gen++
+	c.ll.Init()
+	c.entries = make(map[string]*list.Element)
+	c.byUnv = make(map[string]map[string]struct{})
+	c.curBytes = 0
+}
+
+func (c *lruCache) stats() LRUCacheStats {
+	c.Lock()
+	defer c.Unlock()
+	return LRUCacheStats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		Entries:   len(c.entries),
+		Bytes:     c.curBytes,
+		MaxBytes:  c.maxBytes,
+	}
+}
+
+// unversionedString returns the unversioned portion of a full key as a string.
+func unversionedString(k Key) string {
+	unv, _, err := SplitKey(k)
+	if err != nil {
+		return string(k)
+	}
+	return string(unv)
+}
+
+// returns a store that checks the LRU cache before reading from the passed store.
+// Batching is preserved if the passed store supports it.
+func wrapLRUCache(store dvid.Store, cache *lruCache) (dvid.Store, error) {
+	kvstore, ok := store.(OrderedKeyValueDB)
+	if !ok {
+		return store, fmt.Errorf("store %s doesn't implement OrderedKeyValueDB", store)
+	}
+	s := lruCacheStore{OrderedKeyValueDB: kvstore, cache: cache}
+	if batcher, ok := store.(KeyValueBatcher); ok {
+		return lruCacheBatchStore{lruCacheStore: s, batcher: batcher}, nil
+	}
+	return s, nil
+}
+
+type lruCacheStore struct {
+	OrderedKeyValueDB
+	cache *lruCache
+}
+
+func (s lruCacheStore) Get(ctx Context, tk TKey) ([]byte, error) {
+	k := ctx.ConstructKey(tk)
+	key := string(k)
+	v, found, gen := s.cache.get(key)
+	if found {
+		return v, nil
+	}
+	v, err := s.OrderedKeyValueDB.Get(ctx, tk)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.add(key, unversionedString(k), v, gen)
+	return v, nil
+}
+
+func (s lruCacheStore) Exists(ctx Context, tk TKey) (bool, error) {
+	if v, found, _ := s.cache.get(string(ctx.ConstructKey(tk))); found {
+		return v != nil, nil
+	}
+	return s.OrderedKeyValueDB.Exists(ctx, tk)
+}
+
+func (s lruCacheStore) Put(ctx Context, tk TKey, v []byte) error {
+	defer s.cache.invalidateWrites(ctx, tk)
+	return s.OrderedKeyValueDB.Put(ctx, tk, v)
+}
+
+func (s lruCacheStore) Delete(ctx Context, tk TKey) error {
+	defer s.cache.invalidateWrites(ctx, tk)
+	return s.OrderedKeyValueDB.Delete(ctx, tk)
+}
+
+func (s lruCacheStore) RawPut(k Key, v []byte) error {
+	defer s.cache.invalidate(unversionedString(k))
+	return s.OrderedKeyValueDB.RawPut(k, v)
+}
+
+func (s lruCacheStore) RawDelete(k Key) error {
+	defer s.cache.invalidate(unversionedString(k))
+	return s.OrderedKeyValueDB.RawDelete(k)
+}
+
+func (s lruCacheStore) PutRange(ctx Context, kvs []TKeyValue) error {
+	tks := make([]TKey, len(kvs))
+	for i, kv := range kvs {
+		tks[i] = kv.K
+	}
+	defer s.cache.invalidateWrites(ctx, tks...)
+	return s.OrderedKeyValueDB.PutRange(ctx, kvs)
+}
+
+// DeleteRange and DeleteAll are rare so the entire cache is purged.
+func (s lruCacheStore) DeleteRange(ctx Context, kStart, kEnd TKey) error {
+	defer s.cache.purge()
+	return s.OrderedKeyValueDB.DeleteRange(ctx, kStart, kEnd)
+}
+
+func (s lruCacheStore) DeleteAll(ctx Context, allVersions bool) error {
+	defer s.cache.purge()
+	return s.OrderedKeyValueDB.DeleteAll(ctx, allVersions)
+}
+
+type lruCacheBatchStore struct {
+	lruCacheStore
+	batcher KeyValueBatcher
+}
+
+func (s lruCacheBatchStore) NewBatch(ctx Context) Batch {
+	return &lruCacheBatch{Batch: s.batcher.NewBatch(ctx), ctx: ctx, cache: s.cache}
+}
+
+type lruCacheBatch struct {
+	Batch
+	ctx   Context
+	cache *lruCache
+	keys  []TKey
+}
+
+func (b *lruCacheBatch) Put(tk TKey, v []byte) {
+	b.Batch.Put(tk, v)
+	b.keys = append(b.keys, tk)
+}
+
+func (b *lruCacheBatch) Delete(tk TKey) {
+	b.Batch.Delete(tk)
+	b.keys = append(b.keys, tk)
+}
+
+func (b *lruCacheBatch) Commit() error {
+	defer b.cache.invalidateWrites(b.ctx, b.keys...)
+	return b.Batch.Commit()
+}
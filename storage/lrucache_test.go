@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(100)
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%02d", i) // 5 bytes key + 5 bytes value
+		_, found, gen := c.get(key)
+		if found {
+			t.Fatalf("found key %q before it was added\n", key)
+		}
+		c.add(key, "unv", []byte("value"), gen)
+	}
+	stats := c.stats()
+	if stats.Entries != 10 || stats.Bytes != 100 || stats.Evictions != 0 {
+		t.Fatalf("bad stats after filling cache: %v\n", stats)
+	}
+
+	// Touch the oldest key so it isn't the one evicted.
+	if v, found, _ := c.get("key00"); !found || string(v) != "value" {
+		t.Fatalf("expected key00 to be cached\n")
+	}
+	_, _, gen := c.get("key10")
+	c.add("key10", "other", []byte("value"), gen)
+	if _, found, _ := c.get("key01"); found {
+		t.Fatalf("expected key01 to be evicted\n")
+	}
+	if _, found, _ := c.get("key00"); !found {
+		t.Fatalf("expected recently used key00 to be cached\n")
+	}
+	if stats = c.stats(); stats.Evictions != 1 {
+		t.Fatalf("expected 1 eviction, got %d\n", stats.Evictions)
+	}
+
+	// Invalidating an unversioned key removes all its versions.
+	c.invalidate("unv")
+	if stats = c.stats(); stats.Entries != 1 {
+		t.Fatalf("expected only key10 after invalidation, got %d entries\n", stats.Entries)
+	}
+
+	// A read that started before a write must not be cached.
+	_, _, gen = c.get("key20")
+	c.invalidate("stale")
+	c.add("key20", "stale", []byte("old"), gen)
+	if _, found, _ := c.get("key20"); found {
+		t.Fatalf("stale value was cached after concurrent invalidation\n")
+	}
+}
+
+func TestLRUCacheRequestBatch(t *testing.T) {
+	mem := &testBatchStore{testKVStore: &testKVStore{kv: make(map[string][]byte)}}
+	guarded, err := wrapWriteGuard(mem)
+	if err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	store, err := wrapLRUCache(guarded, newLRUCache(1000))
+	if err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	db := store.(OrderedKeyValueDB)
+	ctx := NewDataContext(&testData{instanceID: 1}, 1)
+	if err := db.Put(ctx, TKey("a"), []byte("old")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+
+	rb := NewRequestBatch()
+	ctx.SetContext(WithRequestBatch(context.Background(), rb))
+	if err := db.Put(ctx, TKey("a"), []byte("new")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	// A read before the commit caches the old value.
+	checkValue(t, db, ctx, "a", []byte("old"))
+	if err := rb.Commit(); err != nil {
+		t.Fatalf("error committing request batch: %v\n", err)
+	}
+	checkValue(t, db, ctx, "a", []byte("new"))
+}
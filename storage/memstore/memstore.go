@@ -0,0 +1,826 @@
+// +build memstore
+
+/*
+	Package memstore implements a pure in-memory ordered key-value store for tests and
+	ephemeral servers.  It satisfies the OrderedKeyValueDB, KeyValueBatcher, TransactionDB,
+	and SizeViewer interfaces, so datastore and datatype tests run without touching disk
+	when built with the "memstore" tag.
+
+	Key-value pairs are held in a skiplist guarded by a read-write mutex.  Stores are
+	registered by the optional "name" setting so closing and reopening a store with the
+	same name within a process sees the same data, as persistence tests expect.  Data is
+	lost when the process exits.
+*/
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+)
+
+const (
+	// ScanChunkSize is the number of key-value pairs copied per lock acquisition during
+	// range scans.  Callbacks are run without holding the lock so they may write to the store.
+	ScanChunkSize = 1000
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in memstore: %v\n", err)
+	}
+	e := Engine{"memstore", "In-memory ordered key-value store", ver}
+	storage.RegisterEngine(e)
+}
+
+// memData is the shared data of all opened stores with the same name.
+type memData struct {
+	sync.RWMutex
+	list *skiplist
+
+	// unlocked is signaled whenever a lock key is deleted.
+	unlocked *sync.Cond
+}
+
+func newMemData() *memData {
+	data := &memData{list: newSkiplist()}
+	data.unlocked = sync.NewCond(&data.RWMutex)
+	return data
+}
+
+var (
+	// named stores that persist across Close() until deleted via the Engine.
+	namedData   map[string]*memData
+	namedDataMu sync.Mutex
+)
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return false
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns an in-memory store.  If the optional "name" setting is given, the
+// store shares data with any other store of that name opened in this process.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	name, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+	var data *memData
+	if name == "" {
+		data = newMemData()
+	} else {
+		namedDataMu.Lock()
+		if namedData == nil {
+			namedData = make(map[string]*memData)
+		}
+		var found bool
+		if data, found = namedData[name]; !found {
+			data = newMemData()
+			namedData[name] = data
+		}
+		namedDataMu.Unlock()
+	}
+	db := &MemStore{name: name, config: config, data: data}
+	metadataExists, err := db.metadataExists()
+	if err != nil {
+		return nil, false, err
+	}
+	return db, !metadataExists, nil
+}
+
+func parseConfig(config dvid.StoreConfig) (name string, err error) {
+	c := config.GetAll()
+	v, found := c["name"]
+	if !found {
+		return
+	}
+	var ok bool
+	if name, ok = v.(string); !ok {
+		err = fmt.Errorf("%q setting must be a string (%v)", "name", v)
+	}
+	return
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets memstore as the default key-value backend.  If another
+// engine is already set, it returns an error since only one key-value backend should
+// be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("memstore can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	if backend.Metadata != "" {
+		return fmt.Errorf("memstore can't be testable key-value.  Metadata already set to %s", backend.Metadata)
+	}
+	alias := storage.Alias("memstore")
+	backend.Metadata = alias
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"name":    fmt.Sprintf("dvid-test-memstore-%x", uuid.NewV4().Bytes()),
+		"testing": true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "memstore"}
+	return nil
+}
+
+// Delete implements the TestableEngine interface by discarding the data of a named store.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	name, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	namedDataMu.Lock()
+	delete(namedData, name)
+	namedDataMu.Unlock()
+	return nil
+}
+
+// --- The MemStore implementation must satisfy a Engine interface ----
+
+// MemStore is an in-memory ordered key-value store.
+type MemStore struct {
+	name string
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+
+	data *memData
+}
+
+func (db *MemStore) String() string {
+	if db.name == "" {
+		return "memstore"
+	}
+	return fmt.Sprintf("memstore %q", db.name)
+}
+
+// Close is a no-op.  Named data remains available until deleted via the Engine.
+func (db *MemStore) Close() {}
+
+// Equal returns true if the memstore matches the given store configuration.  Unnamed
+// stores are never equal to a configuration since each has distinct data.
+func (db *MemStore) Equal(config dvid.StoreConfig) bool {
+	name, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.name != "" && db.name == name
+}
+
+// IsLocal returns true since memstore data is only within this server's process.
+func (db *MemStore) IsLocal() bool {
+	return true
+}
+
+func (db *MemStore) metadataExists() (bool, error) {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	var found bool
+	err := db.scanRange(keyBeg, keyEnd, true, func(kv *storage.KeyValue) bool {
+		found = true
+		return false
+	})
+	return found, err
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// scanRange calls f on copies of the key-value pairs with keys in [kStart, kEnd] in
+// ascending key order.  If f returns false, the scan stops.
+func (db *MemStore) scanRange(kStart, kEnd storage.Key, keysOnly bool, f func(*storage.KeyValue) bool) error {
+	begKey := kStart
+	inclusive := true
+	for {
+		kvs := make([]*storage.KeyValue, 0, ScanChunkSize)
+		db.data.RLock()
+		x := db.data.list.findGE(begKey, nil)
+		if x != nil && !inclusive && bytes.Equal(x.key, begKey) {
+			x = x.next[0]
+		}
+		for ; x != nil && len(kvs) < ScanChunkSize; x = x.next[0] {
+			if bytes.Compare(x.key, kEnd) > 0 {
+				break
+			}
+			kv := &storage.KeyValue{K: copyBytes(x.key)}
+			if !keysOnly {
+				kv.V = copyBytes(x.value)
+			}
+			kvs = append(kvs, kv)
+		}
+		db.data.RUnlock()
+
+		for _, kv := range kvs {
+			storage.StoreKeyBytesRead <- len(kv.K)
+			storage.StoreValueBytesRead <- len(kv.V)
+			if !f(kv) {
+				return nil
+			}
+		}
+		if len(kvs) < ScanChunkSize {
+			return nil
+		}
+		begKey = kvs[len(kvs)-1].K
+		inclusive = false
+	}
+}
+
+// getValue returns a copy of the value for a possibly versioned key.  The caller must hold
+// at least a read lock.
+func (db *MemStore) getValue(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	v, err := db.findValue(ctx, tk)
+	return copyBytes(v), err
+}
+
+// findValue returns the stored value for a possibly versioned key, which must not be
+// modified.  The caller must hold at least a read lock.
+func (db *MemStore) findValue(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if !ctx.Versioned() {
+		v, _ := db.data.list.get(ctx.ConstructKey(tk))
+		return v, nil
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	values := []*storage.KeyValue{}
+	for x := db.data.list.findGE(begKey, nil); x != nil && bytes.Compare(x.key, endKey) <= 0; x = x.next[0] {
+		values = append(values, &storage.KeyValue{K: x.key, V: x.value})
+	}
+	kv, err := vctx.VersionedKeyValue(values)
+	if kv != nil {
+		return kv.V, err
+	}
+	return nil, err
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *MemStore) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call Get on nil MemStore")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	db.data.RLock()
+	v, err := db.getValue(ctx, tk)
+	db.data.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	storage.StoreValueBytesRead <- len(v)
+	return v, nil
+}
+
+// Exists returns true if a value is stored for the key without copying the value.
+func (db *MemStore) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists on nil MemStore")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	db.data.RLock()
+	v, err := db.findValue(ctx, tk)
+	db.data.RUnlock()
+	return v != nil, err
+}
+
+// rangeQuery calls f on each key-value pair visible in the context's version with
+// type-specific keys in [kStart, kEnd].
+func (db *MemStore) rangeQuery(ctx storage.Context, kStart, kEnd storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	var ferr error
+	if !ctx.Versioned() {
+		err := db.scanRange(ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd), keysOnly, func(kv *storage.KeyValue) bool {
+			ferr = f(kv)
+			return ferr == nil
+		})
+		if err != nil {
+			return err
+		}
+		return ferr
+	}
+
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return fmt.Errorf("context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	minKey, err := vctx.MinVersionKey(kStart)
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(kEnd)
+	if err != nil {
+		return err
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(kStart)
+	if err != nil {
+		return err
+	}
+	values := []*storage.KeyValue{}
+	sendKV := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil || kv == nil {
+			return err
+		}
+		return f(kv)
+	}
+	err = db.scanRange(minKey, maxKey, keysOnly, func(kv *storage.KeyValue) bool {
+		// Did we pass all versions for last key read?
+		if bytes.Compare(kv.K, maxVersionKey) > 0 {
+			var tk storage.TKey
+			if tk, ferr = storage.TKeyFromKey(kv.K); ferr != nil {
+				return false
+			}
+			if maxVersionKey, ferr = vctx.MaxVersionKey(tk); ferr != nil {
+				return false
+			}
+			if ferr = sendKV(); ferr != nil {
+				return false
+			}
+			values = []*storage.KeyValue{}
+		}
+		values = append(values, kv)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if ferr != nil {
+		return ferr
+	}
+	return sendKV()
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *MemStore) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil MemStore")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	keys := []storage.TKey{}
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, tk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *MemStore) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, ch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil MemStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		ch <- kv.K
+		return nil
+	})
+	ch <- nil
+	return err
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *MemStore) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil MemStore")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	values := []*storage.TKeyValue{}
+	err := db.rangeQuery(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: kv.V})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *MemStore) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil MemStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	f = storage.CancelableChunkFunc(ctx, f)
+	return db.rangeQuery(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tkv := storage.TKeyValue{K: tk, V: kv.V}
+		return f(&storage.Chunk{ChunkOp: op, TKeyValue: &tkv})
+	})
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (db *MemStore) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil MemStore")
+	}
+	var cancelled bool
+	err := db.scanRange(kStart, kEnd, keysOnly, func(kv *storage.KeyValue) bool {
+		select {
+		case out <- kv:
+			return true
+		case <-ctx.Done():
+			cancelled = true
+			return false
+		}
+	})
+	if err != nil || cancelled {
+		return err
+	}
+	out <- nil
+	return nil
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *MemStore) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil MemStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Put(tk, v)
+	return batch.Commit()
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *MemStore) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil MemStore")
+	}
+	db.data.Lock()
+	db.data.list.put(copyBytes(k), copyBytes(v))
+	db.data.Unlock()
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *MemStore) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil MemStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	return batch.Commit()
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *MemStore) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil MemStore")
+	}
+	db.data.Lock()
+	db.data.list.delete(k)
+	db.data.Unlock()
+	return nil
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+func (db *MemStore) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange on nil MemStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	return batch.Commit()
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.  For versioned
+// contexts, tombstones are written for the deleted keys.
+func (db *MemStore) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil MemStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+	var tkeys []storage.TKey
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		tkeys = append(tkeys, tk)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	batch := db.NewBatch(ctx)
+	for _, tk := range tkeys {
+		batch.Delete(tk)
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("Error on batch commit of DeleteRange: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", len(tkeys), ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+func (db *MemStore) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil MemStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	var deleteVersion dvid.VersionID
+	if !allVersions {
+		vctx, versioned := ctx.(storage.VersionedCtx)
+		if !versioned {
+			return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+		}
+		deleteVersion = vctx.VersionID()
+	}
+	minKey, maxKey := ctx.KeyRange()
+
+	db.data.Lock()
+	defer db.data.Unlock()
+	var keys [][]byte
+	for x := db.data.list.findGE(minKey, nil); x != nil && bytes.Compare(x.key, maxKey) < 0; x = x.next[0] {
+		if !allVersions {
+			_, v, _, err := storage.DataKeyToLocalIDs(x.key)
+			if err != nil || v != deleteVersion {
+				continue
+			}
+		}
+		keys = append(keys, x.key)
+	}
+	for _, k := range keys {
+		db.data.list.delete(k)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", len(keys), ctx)
+	return nil
+}
+
+// ---- TransactionDB interface ------
+
+// LockKey creates the given key as a lock.  If the key already exists, the lock is held
+// elsewhere and LockKey blocks until it is released via UnlockKey.
+func (db *MemStore) LockKey(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call LockKey on nil MemStore")
+	}
+	db.data.Lock()
+	for {
+		if _, found := db.data.list.get(k); !found {
+			break
+		}
+		db.data.unlocked.Wait()
+	}
+	db.data.list.put(copyBytes(k), dvid.EmptyValue())
+	db.data.Unlock()
+	return nil
+}
+
+// UnlockKey deletes the lock key, releasing the lock.
+func (db *MemStore) UnlockKey(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call UnlockKey on nil MemStore")
+	}
+	db.data.Lock()
+	db.data.list.delete(k)
+	db.data.unlocked.Broadcast()
+	db.data.Unlock()
+	return nil
+}
+
+// Patch reads the value visible at the context's version, applies f, and writes the
+// result while holding the store's write lock, so the patch is atomic.
+func (db *MemStore) Patch(ctx storage.Context, tk storage.TKey, f storage.PatchFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Patch on nil MemStore")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Patch()")
+	}
+	db.data.Lock()
+	defer db.data.Unlock()
+	val, err := db.getValue(ctx, tk)
+	if err != nil {
+		return err
+	}
+	if val, err = f(val); err != nil {
+		return err
+	}
+	if vctx, ok := ctx.(storage.VersionedCtx); ok && ctx.Versioned() {
+		db.data.list.delete(vctx.TombstoneKey(tk))
+	}
+	key := ctx.ConstructKey(tk)
+	db.data.list.put(key, copyBytes(val))
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(val)
+	return nil
+}
+
+// ---- SizeViewer interface ------
+
+// GetApproximateSizes returns the total bytes of keys and values within each range.
+func (db *MemStore) GetApproximateSizes(ranges []storage.KeyRange) ([]uint64, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetApproximateSizes on nil MemStore")
+	}
+	sizes := make([]uint64, len(ranges))
+	db.data.RLock()
+	defer db.data.RUnlock()
+	for i, kr := range ranges {
+		for x := db.data.list.findGE(kr.Start, nil); x != nil && bytes.Compare(x.key, kr.OpenEnd) < 0; x = x.next[0] {
+			sizes[i] += uint64(len(x.key) + len(x.value))
+		}
+	}
+	return sizes, nil
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	key    storage.Key
+	value  []byte
+	delete bool
+}
+
+type goBatch struct {
+	db   *MemStore
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	ops  []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes.  All operations in the
+// batch are applied atomically under the store's write lock.
+func (db *MemStore) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil MemStore\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	var vctx storage.VersionedCtx
+	if ctx.Versioned() {
+		vctx, _ = ctx.(storage.VersionedCtx)
+	}
+	return &goBatch{db: db, ctx: ctx, vctx: vctx}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, value: dvid.EmptyValue()})
+	}
+	batch.ops = append(batch.ops, batchOp{key: key, delete: true})
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, delete: true})
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.ops = append(batch.ops, batchOp{key: key, value: copyBytes(v)})
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	data := batch.db.data
+	data.Lock()
+	for _, op := range batch.ops {
+		if op.delete {
+			data.list.delete(op.key)
+		} else {
+			data.list.put(op.key, op.value)
+		}
+	}
+	data.Unlock()
+	batch.ops = nil
+	return nil
+}
@@ -0,0 +1,119 @@
+// +build memstore
+
+package memstore
+
+import (
+	"bytes"
+	"math/rand"
+)
+
+const (
+	// maxLevel bounds the height of the skiplist, which comfortably handles
+	// billions of keys with the branching factor below.
+	maxLevel = 24
+
+	// Each node is promoted to the next level with probability 1/branching.
+	branching = 4
+)
+
+type node struct {
+	key   []byte
+	value []byte
+	next  []*node
+}
+
+// skiplist is an ordered map of byte-slice keys that is not safe for concurrent use.
+type skiplist struct {
+	head  *node
+	level int
+	size  int
+	rnd   *rand.Rand
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:  &node{next: make([]*node, maxLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(0xd71d)),
+	}
+}
+
+func (s *skiplist) randomLevel() int {
+	level := 1
+	for level < maxLevel && s.rnd.Intn(branching) == 0 {
+		level++
+	}
+	return level
+}
+
+// findGE returns the first node with key >= the given key or nil if there is none.
+// If update is non-nil, it is filled with the rightmost node before key at each level.
+func (s *skiplist) findGE(key []byte, update []*node) *node {
+	x := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && bytes.Compare(x.next[i].key, key) < 0 {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+// first returns the node with the smallest key or nil if the list is empty.
+func (s *skiplist) first() *node {
+	return s.head.next[0]
+}
+
+func (s *skiplist) get(key []byte) ([]byte, bool) {
+	x := s.findGE(key, nil)
+	if x != nil && bytes.Equal(x.key, key) {
+		return x.value, true
+	}
+	return nil, false
+}
+
+// put sets the value for a key.  The skiplist keeps the passed slices.
+func (s *skiplist) put(key, value []byte) {
+	update := make([]*node, maxLevel)
+	x := s.findGE(key, update)
+	if x != nil && bytes.Equal(x.key, key) {
+		x.value = value
+		return
+	}
+	level := s.randomLevel()
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			update[i] = s.head
+		}
+		s.level = level
+	}
+	x = &node{key: key, value: value, next: make([]*node, level)}
+	for i := 0; i < level; i++ {
+		x.next[i] = update[i].next[i]
+		update[i].next[i] = x
+	}
+	s.size++
+}
+
+// delete removes a key and returns true if it was present.
+func (s *skiplist) delete(key []byte) bool {
+	update := make([]*node, maxLevel)
+	x := s.findGE(key, update)
+	if x == nil || !bytes.Equal(x.key, key) {
+		return false
+	}
+	for i := 0; i < len(x.next); i++ {
+		update[i].next[i] = x.next[i]
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+	s.size--
+	return true
+}
+
+func (s *skiplist) len() int {
+	return s.size
+}
@@ -0,0 +1,62 @@
+// +build memstore
+
+package memstore
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSkiplistOrdering(t *testing.T) {
+	s := newSkiplist()
+	expected := make(map[string]string)
+	for i := 0; i < 2000; i++ {
+		k := fmt.Sprintf("key-%05d", rand.Intn(1000))
+		v := fmt.Sprintf("value-%d", i)
+		s.put([]byte(k), []byte(v))
+		expected[k] = v
+	}
+	for i := 0; i < 300; i++ {
+		k := fmt.Sprintf("key-%05d", rand.Intn(1000))
+		_, found := expected[k]
+		if deleted := s.delete([]byte(k)); deleted != found {
+			t.Fatalf("delete of %q returned %t, expected %t\n", k, deleted, found)
+		}
+		delete(expected, k)
+	}
+	if s.len() != len(expected) {
+		t.Fatalf("expected %d keys in skiplist, got %d\n", len(expected), s.len())
+	}
+
+	var keys []string
+	for k := range expected {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	i := 0
+	for x := s.first(); x != nil; x = x.next[0] {
+		if string(x.key) != keys[i] {
+			t.Fatalf("expected key %d to be %q, got %q\n", i, keys[i], string(x.key))
+		}
+		if string(x.value) != expected[keys[i]] {
+			t.Fatalf("bad value for key %q: %q\n", keys[i], string(x.value))
+		}
+		i++
+	}
+	if i != len(keys) {
+		t.Fatalf("iterated over %d keys, expected %d\n", i, len(keys))
+	}
+
+	if len(keys) > 1 {
+		x := s.findGE([]byte(keys[0]+"\x00"), nil)
+		if x == nil || !bytes.Equal(x.key, []byte(keys[1])) {
+			t.Fatalf("findGE didn't return successor of first key\n")
+		}
+	}
+	if _, found := s.get([]byte("missing")); found {
+		t.Fatalf("found key that was never put\n")
+	}
+}
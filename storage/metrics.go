@@ -0,0 +1,409 @@
+/*
+	This file implements optional metrics for storage operations.  Stores are wrapped so
+	each get, put, delete, range query, and batch commit is counted along with the bytes
+	transferred and its latency, keyed by the data instance accessed.  Operations on
+	metadata and other keys outside data instances are tallied under instance ID 0.
+
+	The same wrapper records a trace span for each operation made within a traced request,
+	so slow requests can be followed down to individual gets and range scans.
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// metricOp is a type of storage operation tracked by metrics.
+type metricOp uint8
+
+const (
+	metricGet    metricOp = iota // Get, GetBatch
+	metricPut                    // Put, RawPut, PutRange, PutTTL
+	metricDelete                 // Delete, RawDelete, DeleteRange, DeleteAll
+	metricRange                  // GetRange, KeysInRange, SendKeysInRange, ProcessRange, RawRangeQuery
+	metricBatch                  // Commit of a batch of puts and deletes
+	numMetricOps
+)
+
+var metricOpNames = [numMetricOps]string{"get", "put", "delete", "range", "batch"}
+
+// LatencyBuckets are the upper bounds of the latency histogram kept for each operation.
+// A final bucket counts operations slower than the last bound.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// OpMetrics are the cumulative metrics for one type of operation on a data instance.
+// Bytes read by range queries that send keys down channels, i.e., RawRangeQuery and
+// SendKeysInRange, are not counted.
+type OpMetrics struct {
+	Count    uint64
+	Errors   uint64
+	BytesIn  uint64   // bytes of keys and values written.
+	BytesOut uint64   // bytes of keys and values read.
+	Seconds  float64  // total time spent in the operations.
+	Latency  []uint64 // operations within each of the LatencyBuckets, plus slower ones.
+}
+
+type instanceMetrics [numMetricOps]OpMetrics
+
+var storeMetrics struct {
+	sync.Mutex
+	instances map[dvid.InstanceID]*instanceMetrics
+}
+
+// Whether wrapped stores record metrics and trace spans.  Set when stores are initialized.
+var (
+	recordMetrics bool
+	recordSpans   bool
+)
+
+// recordOp records an operation that started at the given time.  The context is nil for
+// operations on raw keys, which are never traced.
+func recordOp(ctx Context, instance dvid.InstanceID, op metricOp, start time.Time, bytesIn, bytesOut int, err error) {
+	if recordSpans && ctx != nil {
+		dvid.RecordSpan(RequestContext(ctx), "storage "+metricOpNames[op], start, err, map[string]interface{}{
+			"dvid.instance_id": uint64(instance),
+			"dvid.bytes_in":    bytesIn,
+			"dvid.bytes_out":   bytesOut,
+		})
+	}
+	if !recordMetrics {
+		return
+	}
+	elapsed := time.Since(start)
+	bucket := len(LatencyBuckets)
+	for i, bound := range LatencyBuckets {
+		if elapsed <= bound {
+			bucket = i
+			break
+		}
+	}
+	storeMetrics.Lock()
+	defer storeMetrics.Unlock()
+	if storeMetrics.instances == nil {
+		storeMetrics.instances = make(map[dvid.InstanceID]*instanceMetrics)
+	}
+	im, found := storeMetrics.instances[instance]
+	if !found {
+		im = new(instanceMetrics)
+		storeMetrics.instances[instance] = im
+	}
+	m := &im[op]
+	if m.Latency == nil {
+		m.Latency = make([]uint64, len(LatencyBuckets)+1)
+	}
+	m.Count++
+	if err != nil {
+		m.Errors++
+	}
+	m.BytesIn += uint64(bytesIn)
+	m.BytesOut += uint64(bytesOut)
+	m.Seconds += elapsed.Seconds()
+	m.Latency[bucket]++
+}
+
+// GetStoreMetrics returns the metrics for each operation type, e.g., "get", on each data
+// instance since the server started.  Stores only collect metrics if configured to do so.
+func GetStoreMetrics() map[dvid.InstanceID]map[string]OpMetrics {
+	storeMetrics.Lock()
+	defer storeMetrics.Unlock()
+	metrics := make(map[dvid.InstanceID]map[string]OpMetrics, len(storeMetrics.instances))
+	for instance, im := range storeMetrics.instances {
+		ops := make(map[string]OpMetrics)
+		for op, m := range im {
+			if m.Count == 0 {
+				continue
+			}
+			m.Latency = append([]uint64{}, m.Latency...)
+			ops[metricOpNames[op]] = m
+		}
+		metrics[instance] = ops
+	}
+	return metrics
+}
+
+// contextInstance returns the data instance of a context or 0 for metadata contexts.
+func contextInstance(ctx Context) dvid.InstanceID {
+	if dctx, ok := ctx.(interface {
+		Data() dvid.Data
+	}); ok {
+		if d := dctx.Data(); d != nil {
+			return d.InstanceID()
+		}
+	}
+	return 0
+}
+
+// keyInstance returns the data instance of a full key or 0 for non-data keys.
+func keyInstance(k Key) dvid.InstanceID {
+	if len(k) < 1+dvid.InstanceIDSize || k[0] != dataKeyPrefix {
+		return 0
+	}
+	return dvid.InstanceIDFromBytes(k[1 : 1+dvid.InstanceIDSize])
+}
+
+// wrapMetrics returns a store that records metrics and trace spans for all operations on the
+// passed store.
+// Ordered and batch interfaces are preserved.  Batch gets, TTL puts, streaming ranges, and
+// size queries are passed through, failing if the wrapped store doesn't support them.
+// Transactional, request buffering, and log stores can't be wrapped.
+func wrapMetrics(store dvid.Store) (dvid.Store, error) {
+	if _, ok := store.(TransactionDB); ok {
+		return nil, fmt.Errorf("store %s is transactional", store)
+	}
+	if _, ok := store.(KeyValueRequester); ok {
+		return nil, fmt.Errorf("store %s buffers requests", store)
+	}
+	if _, ok := store.(WriteLog); ok {
+		return nil, fmt.Errorf("store %s is a log", store)
+	}
+	batcher, canBatch := store.(KeyValueBatcher)
+	if db, ok := store.(OrderedKeyValueDB); ok {
+		s := metricsOrderedStore{metricsStore{db}, db}
+		if canBatch {
+			return metricsOrderedBatchStore{s, batcher}, nil
+		}
+		return s, nil
+	}
+	if db, ok := store.(KeyValueDB); ok {
+		s := metricsStore{db}
+		if canBatch {
+			return metricsBatchStore{s, batcher}, nil
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("store %s doesn't implement KeyValueDB", store)
+}
+
+type metricsStore struct {
+	KeyValueDB
+}
+
+func (s metricsStore) Get(ctx Context, tk TKey) (v []byte, err error) {
+	defer func(start time.Time) {
+		recordOp(ctx, contextInstance(ctx), metricGet, start, 0, len(tk)+len(v), err)
+	}(time.Now())
+	return s.KeyValueDB.Get(ctx, tk)
+}
+
+func (s metricsStore) Exists(ctx Context, tk TKey) (found bool, err error) {
+	defer func(start time.Time) {
+		recordOp(ctx, contextInstance(ctx), metricGet, start, 0, len(tk), err)
+	}(time.Now())
+	return s.KeyValueDB.Exists(ctx, tk)
+}
+
+func (s metricsStore) GetBatch(ctx Context, tks []TKey) (values [][]byte, err error) {
+	defer func(start time.Time) {
+		var n int
+		for i, v := range values {
+			n += len(tks[i]) + len(v)
+		}
+		recordOp(ctx, contextInstance(ctx), metricGet, start, 0, n, err)
+	}(time.Now())
+	return GetBatch(s.KeyValueDB, ctx, tks)
+}
+
+func (s metricsStore) Put(ctx Context, tk TKey, v []byte) (err error) {
+	defer func(start time.Time) {
+		recordOp(ctx, contextInstance(ctx), metricPut, start, len(tk)+len(v), 0, err)
+	}(time.Now())
+	return s.KeyValueDB.Put(ctx, tk, v)
+}
+
+func (s metricsStore) PutTTL(ctx Context, tk TKey, v []byte, ttl time.Duration) (err error) {
+	defer func(start time.Time) {
+		recordOp(ctx, contextInstance(ctx), metricPut, start, len(tk)+len(v), 0, err)
+	}(time.Now())
+	ttlPutter, ok := s.KeyValueDB.(TTLPutter)
+	if !ok {
+		return fmt.Errorf("store %s doesn't support TTLs", s.KeyValueDB)
+	}
+	return ttlPutter.PutTTL(ctx, tk, v, ttl)
+}
+
+func (s metricsStore) Delete(ctx Context, tk TKey) (err error) {
+	defer func(start time.Time) {
+		recordOp(ctx, contextInstance(ctx), metricDelete, start, len(tk), 0, err)
+	}(time.Now())
+	return s.KeyValueDB.Delete(ctx, tk)
+}
+
+func (s metricsStore) RawPut(k Key, v []byte) (err error) {
+	defer func(start time.Time) {
+		recordOp(nil, keyInstance(k), metricPut, start, len(k)+len(v), 0, err)
+	}(time.Now())
+	return s.KeyValueDB.RawPut(k, v)
+}
+
+func (s metricsStore) RawDelete(k Key) (err error) {
+	defer func(start time.Time) {
+		recordOp(nil, keyInstance(k), metricDelete, start, len(k), 0, err)
+	}(time.Now())
+	return s.KeyValueDB.RawDelete(k)
+}
+
+type metricsBatchStore struct {
+	metricsStore
+	batcher KeyValueBatcher
+}
+
+func (s metricsBatchStore) NewBatch(ctx Context) Batch {
+	return &metricsBatch{Batch: s.batcher.NewBatch(ctx), ctx: ctx, instance: contextInstance(ctx)}
+}
+
+type metricsOrderedStore struct {
+	metricsStore
+	db OrderedKeyValueDB
+}
+
+func (s metricsOrderedStore) GetRange(ctx Context, kStart, kEnd TKey) (kvs []*TKeyValue, err error) {
+	defer func(start time.Time) {
+		var n int
+		for _, kv := range kvs {
+			n += len(kv.K) + len(kv.V)
+		}
+		recordOp(ctx, contextInstance(ctx), metricRange, start, 0, n, err)
+	}(time.Now())
+	return s.db.GetRange(ctx, kStart, kEnd)
+}
+
+func (s metricsOrderedStore) GetRangeStream(ctx Context, kStart, kEnd TKey) (*RangeStream, error) {
+	start := time.Now()
+	stream, err := GetRangeStream(s.db, ctx, kStart, kEnd)
+	if err != nil {
+		recordOp(ctx, contextInstance(ctx), metricRange, start, 0, 0, err)
+		return nil, err
+	}
+	return StreamRange(ctx, func(send func(*TKeyValue) bool) error {
+		var n int
+		for kv := range stream.C {
+			n += len(kv.K) + len(kv.V)
+			if !send(kv) {
+				stream.Close()
+				for range stream.C {
+				}
+				break
+			}
+		}
+		err := stream.Err()
+		recordOp(ctx, contextInstance(ctx), metricRange, start, 0, n, err)
+		return err
+	}), nil
+}
+
+func (s metricsOrderedStore) KeysInRange(ctx Context, kStart, kEnd TKey) (tks []TKey, err error) {
+	defer func(start time.Time) {
+		var n int
+		for _, tk := range tks {
+			n += len(tk)
+		}
+		recordOp(ctx, contextInstance(ctx), metricRange, start, 0, n, err)
+	}(time.Now())
+	return s.db.KeysInRange(ctx, kStart, kEnd)
+}
+
+func (s metricsOrderedStore) SendKeysInRange(ctx Context, kStart, kEnd TKey, ch KeyChan) (err error) {
+	defer func(start time.Time) {
+		recordOp(ctx, contextInstance(ctx), metricRange, start, 0, 0, err)
+	}(time.Now())
+	return s.db.SendKeysInRange(ctx, kStart, kEnd, ch)
+}
+
+func (s metricsOrderedStore) ProcessRange(ctx Context, kStart, kEnd TKey, op *ChunkOp, f ChunkFunc) (err error) {
+	var n int
+	defer func(start time.Time) {
+		recordOp(ctx, contextInstance(ctx), metricRange, start, 0, n, err)
+	}(time.Now())
+	return s.db.ProcessRange(ctx, kStart, kEnd, op, func(c *Chunk) error {
+		if c != nil && c.TKeyValue != nil {
+			n += len(c.K) + len(c.V)
+		}
+		return f(c)
+	})
+}
+
+func (s metricsOrderedStore) RawRangeQuery(ctx context.Context, kStart, kEnd Key, keysOnly bool, out chan *KeyValue) (err error) {
+	defer func(start time.Time) {
+		recordOp(nil, keyInstance(kStart), metricRange, start, 0, 0, err)
+	}(time.Now())
+	return s.db.RawRangeQuery(ctx, kStart, kEnd, keysOnly, out)
+}
+
+func (s metricsOrderedStore) PutRange(ctx Context, kvs []TKeyValue) (err error) {
+	defer func(start time.Time) {
+		var n int
+		for _, kv := range kvs {
+			n += len(kv.K) + len(kv.V)
+		}
+		recordOp(ctx, contextInstance(ctx), metricPut, start, n, 0, err)
+	}(time.Now())
+	return s.db.PutRange(ctx, kvs)
+}
+
+func (s metricsOrderedStore) DeleteRange(ctx Context, kStart, kEnd TKey) (err error) {
+	defer func(start time.Time) {
+		recordOp(ctx, contextInstance(ctx), metricDelete, start, 0, 0, err)
+	}(time.Now())
+	return s.db.DeleteRange(ctx, kStart, kEnd)
+}
+
+func (s metricsOrderedStore) DeleteAll(ctx Context, allVersions bool) (err error) {
+	defer func(start time.Time) {
+		recordOp(ctx, contextInstance(ctx), metricDelete, start, 0, 0, err)
+	}(time.Now())
+	return s.db.DeleteAll(ctx, allVersions)
+}
+
+func (s metricsOrderedStore) GetApproximateSizes(ranges []KeyRange) ([]uint64, error) {
+	sv, ok := s.db.(SizeViewer)
+	if !ok {
+		return nil, fmt.Errorf("store %s can't report sizes", s.db)
+	}
+	return sv.GetApproximateSizes(ranges)
+}
+
+type metricsOrderedBatchStore struct {
+	metricsOrderedStore
+	batcher KeyValueBatcher
+}
+
+func (s metricsOrderedBatchStore) NewBatch(ctx Context) Batch {
+	return &metricsBatch{Batch: s.batcher.NewBatch(ctx), ctx: ctx, instance: contextInstance(ctx)}
+}
+
+type metricsBatch struct {
+	Batch
+	ctx      Context
+	instance dvid.InstanceID
+	bytesIn  int
+}
+
+func (b *metricsBatch) Put(tk TKey, v []byte) {
+	b.bytesIn += len(tk) + len(v)
+	b.Batch.Put(tk, v)
+}
+
+func (b *metricsBatch) Delete(tk TKey) {
+	b.bytesIn += len(tk)
+	b.Batch.Delete(tk)
+}
+
+func (b *metricsBatch) Commit() (err error) {
+	defer func(start time.Time) {
+		recordOp(b.ctx, b.instance, metricBatch, start, b.bytesIn, 0, err)
+	}(time.Now())
+	return b.Batch.Commit()
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStoreMetrics(t *testing.T) {
+	recordMetrics = true
+	defer func() {
+		recordMetrics = false
+		storeMetrics.Lock()
+		storeMetrics.instances = nil
+		storeMetrics.Unlock()
+	}()
+	mem := &testBatchStore{testKVStore: &testKVStore{kv: make(map[string][]byte)}}
+	store, err := wrapMetrics(mem)
+	if err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	db := store.(OrderedKeyValueDB)
+
+	ctx := NewDataContext(&testData{instanceID: 5}, 1)
+	if err := db.Put(ctx, TKey("a"), []byte("value")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	checkValue(t, db, ctx, "a", []byte("value"))
+	batch := store.(KeyValueBatcher).NewBatch(ctx)
+	batch.Put(TKey("b"), []byte("b"))
+	batch.Delete(TKey("a"))
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("error on batch commit: %v\n", err)
+	}
+	kStart, kEnd := ctx.KeyRange()
+	if err := db.RawRangeQuery(context.Background(), kStart, kEnd, false, make(chan *KeyValue, 2)); err != nil {
+		t.Fatalf("error on raw range query: %v\n", err)
+	}
+	var metaCtx MetadataContext
+	if err := db.RawPut(metaCtx.ConstructKey(TKey("meta")), []byte("m")); err != nil {
+		t.Fatalf("error on raw put: %v\n", err)
+	}
+
+	metrics := GetStoreMetrics()
+	ops := metrics[5]
+	if put := ops["put"]; put.Count != 1 || put.BytesIn != 6 || put.Errors != 0 {
+		t.Errorf("expected one put of 6 bytes, got %+v\n", put)
+	}
+	if get := ops["get"]; get.Count != 1 || get.BytesOut != 6 {
+		t.Errorf("expected one get of 6 bytes, got %+v\n", get)
+	}
+	if b := ops["batch"]; b.Count != 1 || b.BytesIn != 3 {
+		t.Errorf("expected one batch of 3 bytes, got %+v\n", b)
+	}
+	if r := ops["range"]; r.Count != 1 || r.BytesOut != 0 {
+		t.Errorf("expected one range query without counted bytes, got %+v\n", r)
+	}
+	for name, m := range ops {
+		var n uint64
+		for _, count := range m.Latency {
+			n += count
+		}
+		if len(m.Latency) != len(LatencyBuckets)+1 || n != m.Count {
+			t.Errorf("expected %d latencies for %s in %d buckets, got %v\n", m.Count, name, len(LatencyBuckets)+1, m.Latency)
+		}
+	}
+	if put := metrics[0]["put"]; put.Count != 1 {
+		t.Errorf("expected raw put of metadata under instance 0, got %+v\n", put)
+	}
+}
@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+const (
+	// MigrateBatchSize is the number of key-value pairs written per batch of RawPuts.
+	MigrateBatchSize = 1000
+
+	// number of batches that can be written concurrently during a migration.
+	migrateWriters = 4
+
+	// how often migration progress is logged.
+	migrateProgressInterval = 30 * time.Second
+)
+
+// MigrateStats summarizes a migration and its verification.
+type MigrateStats struct {
+	NumKV    uint64
+	NumBytes uint64
+
+	Verified   bool
+	Mismatches uint64 // keys with different or missing values in the destination.
+}
+
+// AllKeys is a range that covers all metadata, data, and index keys.
+var AllKeys = KeyRange{Start: Key{metadataKeyPrefix}, OpenEnd: Key{0xFF}}
+
+type migrateProgress struct {
+	sync.Mutex
+	op       string
+	start    time.Time
+	last     time.Time
+	numKV    uint64
+	numBytes uint64
+}
+
+func (p *migrateProgress) add(kv *KeyValue) {
+	p.Lock()
+	defer p.Unlock()
+	p.numKV++
+	p.numBytes += uint64(len(kv.K) + len(kv.V))
+	if time.Since(p.last) >= migrateProgressInterval {
+		p.last = time.Now()
+		elapsed := time.Since(p.start).Seconds()
+		dvid.Infof("%s: %d key-value pairs (%s) after %s, %s/sec\n", p.op, p.numKV,
+			humanize.Bytes(p.numBytes), time.Since(p.start), humanize.Bytes(uint64(float64(p.numBytes)/elapsed)))
+	}
+}
+
+// rangeQuery calls f for each key-value pair in the key range, which is open at the end.
+func rangeQuery(db OrderedKeyValueDB, r KeyRange, f func(*KeyValue) error) error {
+	ch := make(chan *KeyValue, 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queryErr := make(chan error, 1)
+	go func() {
+		queryErr <- db.RawRangeQuery(ctx, r.Start, r.OpenEnd, false, ch)
+		close(ch)
+	}()
+	var ferr error
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		if bytes.Equal(kv.K, r.OpenEnd) {
+			continue
+		}
+		if ferr = f(kv); ferr != nil {
+			break
+		}
+	}
+	if ferr != nil {
+		cancel()
+		for range ch {
+		}
+		return ferr
+	}
+	return <-queryErr
+}
+
+// Migrate copies all key-value pairs in the given key ranges from the source to the
+// destination store, which can use different storage engines.  If no ranges are given,
+// all keys are copied.  Reads from the source are streamed into batches of RawPuts on
+// the destination, with progress logged periodically.  If verify is true, the ranges are
+// then compared between the stores and any mismatches counted in the returned stats.
+func Migrate(src, dst dvid.Store, ranges []KeyRange, verify bool) (*MigrateStats, error) {
+	srcDB, ok := src.(OrderedKeyValueDB)
+	if !ok {
+		return nil, fmt.Errorf("source store %s is not an ordered key-value store", src)
+	}
+	dstDB, ok := dst.(OrderedKeyValueDB)
+	if !ok {
+		return nil, fmt.Errorf("destination store %s is not an ordered key-value store", dst)
+	}
+	if src == dst {
+		return nil, fmt.Errorf("source and destination stores are the same: %s", src)
+	}
+	if len(ranges) == 0 {
+		ranges = []KeyRange{AllKeys}
+	}
+	timedLog := dvid.NewTimeLog()
+
+	stats := new(MigrateStats)
+	progress := &migrateProgress{op: fmt.Sprintf("Migrating %s -> %s", src, dst), start: time.Now(), last: time.Now()}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var writeErr error
+	writers := make(chan struct{}, migrateWriters)
+	putBatch := func(batch []*KeyValue) {
+		writers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-writers
+				wg.Done()
+			}()
+			for _, kv := range batch {
+				if err := dstDB.RawPut(kv.K, kv.V); err != nil {
+					mu.Lock()
+					if writeErr == nil {
+						writeErr = fmt.Errorf("unable to put key %v into %s: %v", kv.K, dst, err)
+					}
+					mu.Unlock()
+					return
+				}
+				progress.add(kv)
+			}
+		}()
+	}
+	failed := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return writeErr
+	}
+
+	for _, r := range ranges {
+		batch := make([]*KeyValue, 0, MigrateBatchSize)
+		err := rangeQuery(srcDB, r, func(kv *KeyValue) error {
+			batch = append(batch, kv)
+			if len(batch) == MigrateBatchSize {
+				if err := failed(); err != nil {
+					return err
+				}
+				putBatch(batch)
+				batch = make([]*KeyValue, 0, MigrateBatchSize)
+			}
+			return nil
+		})
+		if err == nil && len(batch) != 0 {
+			putBatch(batch)
+		}
+		wg.Wait()
+		if err == nil {
+			err = failed()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("migration from %s to %s failed: %v", src, dst, err)
+		}
+	}
+	stats.NumKV = progress.numKV
+	stats.NumBytes = progress.numBytes
+	timedLog.Infof("Migrated %d key-value pairs (%s) from %s to %s", stats.NumKV, humanize.Bytes(stats.NumBytes), src, dst)
+
+	if verify {
+		mismatches, err := verifyMigration(srcDB, dstDB, ranges)
+		if err != nil {
+			return nil, fmt.Errorf("verification of migration from %s to %s failed: %v", src, dst, err)
+		}
+		stats.Verified = true
+		stats.Mismatches = mismatches
+	}
+	return stats, nil
+}
+
+// verifyMigration compares the key-value pairs in the ranges of both stores by streaming
+// them in key order, and returns the number of keys that are missing from either store
+// or have different values.
+func verifyMigration(src, dst OrderedKeyValueDB, ranges []KeyRange) (mismatches uint64, err error) {
+	timedLog := dvid.NewTimeLog()
+	progress := &migrateProgress{op: fmt.Sprintf("Verifying %s -> %s", src, dst), start: time.Now(), last: time.Now()}
+	mismatch := func(k Key, reason string) {
+		mismatches++
+		if mismatches <= 10 {
+			dvid.Errorf("Migration mismatch for key %v: %s\n", k, reason)
+		}
+	}
+	for _, r := range ranges {
+		dstCh := make(chan *KeyValue, 1000)
+		dstDone := make(chan struct{})
+		dstErr := make(chan error, 1)
+		go func(r KeyRange) {
+			dstErr <- rangeQuery(dst, r, func(kv *KeyValue) error {
+				select {
+				case dstCh <- kv:
+					return nil
+				case <-dstDone:
+					return fmt.Errorf("verification stopped")
+				}
+			})
+			close(dstCh)
+		}(r)
+
+		dstKV, more := <-dstCh
+		err = rangeQuery(src, r, func(kv *KeyValue) error {
+			progress.add(kv)
+			for more && bytes.Compare(dstKV.K, kv.K) < 0 {
+				mismatch(dstKV.K, "not in source")
+				dstKV, more = <-dstCh
+			}
+			if !more || !bytes.Equal(dstKV.K, kv.K) {
+				mismatch(kv.K, "not in destination")
+				return nil
+			}
+			if !bytes.Equal(dstKV.V, kv.V) {
+				mismatch(kv.K, "different values")
+			}
+			dstKV, more = <-dstCh
+			return nil
+		})
+		if err != nil {
+			close(dstDone)
+			for range dstCh {
+			}
+			<-dstErr
+			return
+		}
+		for ; more; dstKV, more = <-dstCh {
+			mismatch(dstKV.K, "not in source")
+		}
+		if err = <-dstErr; err != nil {
+			return
+		}
+	}
+	if mismatches == 0 {
+		timedLog.Infof("Verified %d key-value pairs migrated from %s to %s", progress.numKV, src, dst)
+	} else {
+		dvid.Errorf("Found %d mismatched keys in verification of %d key-value pairs migrated from %s to %s\n",
+			mismatches, progress.numKV, src, dst)
+	}
+	return
+}
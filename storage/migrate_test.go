@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	src := &testKVStore{kv: make(map[string][]byte)}
+	dst := &testKVStore{kv: make(map[string][]byte)}
+	ctx := NewDataContext(&testData{instanceID: 1}, 1)
+	numKV := MigrateBatchSize*2 + 10
+	for i := 0; i < numKV; i++ {
+		tk := TKey(fmt.Sprintf("%05d", i))
+		if err := src.Put(ctx, tk, []byte(fmt.Sprintf("value %d", i))); err != nil {
+			t.Fatalf("error on put: %v\n", err)
+		}
+	}
+
+	if _, err := Migrate(src, src, nil, false); err == nil {
+		t.Errorf("expected error on migration to same store\n")
+	}
+	stats, err := Migrate(src, dst, nil, true)
+	if err != nil {
+		t.Fatalf("error on migration: %v\n", err)
+	}
+	if stats.NumKV != uint64(numKV) || !stats.Verified || stats.Mismatches != 0 {
+		t.Errorf("expected %d verified key-value pairs without mismatches, got %+v\n", numKV, stats)
+	}
+	if len(dst.kv) != numKV {
+		t.Fatalf("expected %d key-value pairs in destination, got %d\n", numKV, len(dst.kv))
+	}
+	checkValue(t, dst, ctx, "00007", []byte("value 7"))
+
+	// Ranges are open at the end.
+	part := &testKVStore{kv: make(map[string][]byte)}
+	r := KeyRange{Start: ctx.ConstructKey(TKey("00010")), OpenEnd: ctx.ConstructKey(TKey("00020"))}
+	if stats, err = Migrate(src, part, []KeyRange{r}, true); err != nil {
+		t.Fatalf("error on migration of range: %v\n", err)
+	}
+	if stats.NumKV != 10 || len(part.kv) != 10 || stats.Mismatches != 0 {
+		t.Errorf("expected 10 key-value pairs migrated in range, got %+v\n", stats)
+	}
+	checkValue(t, part, ctx, "00020", nil)
+
+	// Missing, extra, and changed keys are each counted as mismatches.
+	dst.Put(ctx, TKey("00001"), []byte("changed"))
+	dst.Delete(ctx, TKey("00002"))
+	dst.Put(ctx, TKey("extra"), []byte("extra"))
+	mismatches, err := verifyMigration(src, dst, []KeyRange{AllKeys})
+	if err != nil {
+		t.Fatalf("error on verification: %v\n", err)
+	}
+	if mismatches != 3 {
+		t.Errorf("expected 3 mismatches, got %d\n", mismatches)
+	}
+}
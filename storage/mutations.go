@@ -0,0 +1,408 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// MutationSeqClass is the metadata TKey class used to persist the ceiling of reserved
+// mutation sequence numbers.  It is reserved so it will not collide with metadata classes
+// used by the datastore package.
+const MutationSeqClass TKeyClass = 0xE1
+
+// mutation sequence numbers are reserved in blocks so the ceiling is rarely persisted.
+const mutationSeqBlock = 10000
+
+// MutationRange is an inclusive range of full keys whose values may have changed.
+type MutationRange struct {
+	Beg, End Key
+}
+
+type mutationSeqT struct {
+	sync.Mutex
+	store    KeyValueDB // holds the reserved ceiling
+	next     uint64
+	reserved uint64
+	inflight map[uint64]struct{}
+}
+
+var mutationSeq mutationSeqT
+
+func (s *mutationSeqT) init(store dvid.Store) error {
+	db, ok := store.(KeyValueDB)
+	if !ok {
+		return fmt.Errorf("store %s can't hold mutation sequence numbers", store)
+	}
+	var ctx MetadataContext
+	v, err := db.Get(ctx, NewTKey(MutationSeqClass, nil))
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.store = db
+	s.inflight = make(map[uint64]struct{})
+	s.next = 1
+	if len(v) == 8 {
+		// Skip any numbers reserved but not used before the last shutdown.
+		s.next = binary.BigEndian.Uint64(v)
+	}
+	s.reserved = s.next
+	return nil
+}
+
+// alloc returns a new sequence number that is in flight until done is called.
+func (s *mutationSeqT) alloc() (uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.next >= s.reserved {
+		reserved := s.next + mutationSeqBlock
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint64(buf, reserved)
+		var ctx MetadataContext
+		if err := s.store.Put(ctx, NewTKey(MutationSeqClass, nil), buf); err != nil {
+			return 0, fmt.Errorf("unable to reserve mutation sequence numbers: %v", err)
+		}
+		s.reserved = reserved
+	}
+	seq := s.next
+	s.next++
+	s.inflight[seq] = struct{}{}
+	return seq, nil
+}
+
+func (s *mutationSeqT) done(seq uint64) {
+	s.Lock()
+	delete(s.inflight, seq)
+	s.Unlock()
+}
+
+// MutationHighWater returns the largest mutation sequence number for which it and all
+// lower numbers correspond to completed writes.  Writes with higher sequence numbers
+// may or may not be visible.  Zero is returned if mutations are not tracked.
+func MutationHighWater() uint64 {
+	s := &mutationSeq
+	s.Lock()
+	defer s.Unlock()
+	if s.store == nil {
+		return 0
+	}
+	hw := s.next - 1
+	for seq := range s.inflight {
+		if seq <= hw {
+			hw = seq - 1
+		}
+	}
+	return hw
+}
+
+// MutationStores returns the stores, keyed by alias, whose mutations are tracked.  Each
+// store holds an index of the key ranges changed by each mutation.
+func MutationStores() map[Alias]OrderedKeyValueDB {
+	stores := make(map[Alias]OrderedKeyValueDB, len(manager.tracked))
+	for alias, store := range manager.tracked {
+		stores[alias] = store
+	}
+	return stores
+}
+
+// ReadMutations calls f, in sequence order, with the key ranges changed by each mutation
+// with sequence number in (since, upto] that was indexed by the given store.
+func ReadMutations(db OrderedKeyValueDB, since, upto uint64, f func(seq uint64, ranges []MutationRange) error) error {
+	if since >= upto {
+		return nil
+	}
+	ch := make(chan *KeyValue, 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queryErr := make(chan error, 1)
+	go func() {
+		queryErr <- db.RawRangeQuery(ctx, mutationKey(since+1), mutationKey(upto), false, ch)
+		close(ch)
+	}()
+	var ferr error
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		if len(kv.K) != 9 {
+			ferr = fmt.Errorf("bad mutation index key %v", kv.K)
+			break
+		}
+		var ranges []MutationRange
+		if ranges, ferr = decodeMutationRanges(kv.V); ferr != nil {
+			break
+		}
+		if ferr = f(binary.BigEndian.Uint64(kv.K[1:]), ranges); ferr != nil {
+			break
+		}
+	}
+	if ferr != nil {
+		cancel()
+		for range ch {
+		}
+		return ferr
+	}
+	return <-queryErr
+}
+
+func mutationKey(seq uint64) Key {
+	k := make(Key, 9)
+	k[0] = mutationKeyPrefix
+	binary.BigEndian.PutUint64(k[1:], seq)
+	return k
+}
+
+func encodeMutationRanges(ranges []MutationRange) []byte {
+	var buf bytes.Buffer
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, r := range ranges {
+		for _, k := range []Key{r.Beg, r.End} {
+			n := binary.PutUvarint(lenBuf, uint64(len(k)))
+			buf.Write(lenBuf[:n])
+			buf.Write(k)
+		}
+	}
+	return buf.Bytes()
+}
+
+func decodeMutationRanges(v []byte) ([]MutationRange, error) {
+	var ranges []MutationRange
+	r := bytes.NewReader(v)
+	for r.Len() > 0 {
+		var keys [2]Key
+		for i := range keys {
+			size, err := binary.ReadUvarint(r)
+			if err != nil || uint64(r.Len()) < size {
+				return nil, fmt.Errorf("bad mutation index value")
+			}
+			keys[i] = make(Key, size)
+			r.Read(keys[i])
+		}
+		ranges = append(ranges, MutationRange{keys[0], keys[1]})
+	}
+	return ranges, nil
+}
+
+// allVersions returns the range covering all versions of an unversioned data key.
+// Longer keys with the same prefix are also covered, which is harmless since ranges
+// are only used to find keys whose values might have changed.
+func allVersions(unv Key) MutationRange {
+	end := make(Key, len(unv), len(unv)+dvid.VersionIDSize+dvid.ClientIDSize+2)
+	copy(end, unv)
+	end = append(end, bytes.Repeat([]byte{0xFF}, dvid.VersionIDSize+dvid.ClientIDSize+2)...)
+	return MutationRange{Beg: unv, End: end}
+}
+
+// tkeyRange returns the range for all versions of a type-specific key, or false if the
+// key isn't in the data key space.
+func tkeyRange(ctx Context, tk TKey) (MutationRange, bool) {
+	unv, _, err := ctx.SplitKey(tk)
+	if err != nil || len(unv) == 0 || unv[0] != dataKeyPrefix {
+		return MutationRange{}, false
+	}
+	return allVersions(unv), true
+}
+
+func rawKeyRange(k Key) (MutationRange, bool) {
+	if len(k) == 0 || k[0] != dataKeyPrefix {
+		return MutationRange{}, false
+	}
+	unv, _, err := SplitKey(k)
+	if err != nil {
+		return MutationRange{}, false
+	}
+	return allVersions(unv), true
+}
+
+// wrapMutationTracking returns a store that records in an index the key ranges of data
+// changed by each write.  Index entries are written before the changes so a crash can
+// only cause extra ranges to be recorded.  Only ordered stores that don't support
+// transactions or logs can be tracked.
+func wrapMutationTracking(store dvid.Store) (dvid.Store, error) {
+	db, ok := store.(OrderedKeyValueDB)
+	if !ok {
+		return nil, fmt.Errorf("store %s isn't an ordered key-value store", store)
+	}
+	if _, ok := store.(TransactionDB); ok {
+		return nil, fmt.Errorf("store %s supports transactions, which can't be tracked", store)
+	}
+	if _, ok := store.(WriteLog); ok {
+		return nil, fmt.Errorf("store %s is a write log, which can't be tracked", store)
+	}
+	s := &mutationTrackedStore{OrderedKeyValueDB: db}
+	if batcher, ok := store.(KeyValueBatcher); ok {
+		return &mutationTrackedBatchStore{s, batcher}, nil
+	}
+	return s, nil
+}
+
+type mutationTrackedStore struct {
+	OrderedKeyValueDB
+}
+
+// record allocates a sequence number and indexes the given ranges.  The returned
+// function must be called after the write completes.
+func (s *mutationTrackedStore) record(ranges ...MutationRange) (func(), error) {
+	if len(ranges) == 0 {
+		return func() {}, nil
+	}
+	seq, err := mutationSeq.alloc()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.OrderedKeyValueDB.RawPut(mutationKey(seq), encodeMutationRanges(ranges)); err != nil {
+		mutationSeq.done(seq)
+		return nil, err
+	}
+	return func() { mutationSeq.done(seq) }, nil
+}
+
+func (s *mutationTrackedStore) recordTKey(ctx Context, tk TKey) (func(), error) {
+	if r, ok := tkeyRange(ctx, tk); ok {
+		return s.record(r)
+	}
+	return s.record()
+}
+
+func (s *mutationTrackedStore) recordKey(k Key) (func(), error) {
+	if r, ok := rawKeyRange(k); ok {
+		return s.record(r)
+	}
+	return s.record()
+}
+
+func (s *mutationTrackedStore) Put(ctx Context, tk TKey, v []byte) error {
+	done, err := s.recordTKey(ctx, tk)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return s.OrderedKeyValueDB.Put(ctx, tk, v)
+}
+
+func (s *mutationTrackedStore) Delete(ctx Context, tk TKey) error {
+	done, err := s.recordTKey(ctx, tk)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return s.OrderedKeyValueDB.Delete(ctx, tk)
+}
+
+func (s *mutationTrackedStore) RawPut(k Key, v []byte) error {
+	done, err := s.recordKey(k)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return s.OrderedKeyValueDB.RawPut(k, v)
+}
+
+func (s *mutationTrackedStore) RawDelete(k Key) error {
+	done, err := s.recordKey(k)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return s.OrderedKeyValueDB.RawDelete(k)
+}
+
+func (s *mutationTrackedStore) PutRange(ctx Context, kvs []TKeyValue) error {
+	var ranges []MutationRange
+	for _, kv := range kvs {
+		if r, ok := tkeyRange(ctx, kv.K); ok {
+			ranges = append(ranges, r)
+		}
+	}
+	done, err := s.record(ranges...)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return s.OrderedKeyValueDB.PutRange(ctx, kvs)
+}
+
+func (s *mutationTrackedStore) DeleteRange(ctx Context, kStart, kEnd TKey) error {
+	var ranges []MutationRange
+	if beg, ok := tkeyRange(ctx, kStart); ok {
+		if end, ok := tkeyRange(ctx, kEnd); ok {
+			ranges = append(ranges, MutationRange{beg.Beg, end.End})
+		}
+	}
+	done, err := s.record(ranges...)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return s.OrderedKeyValueDB.DeleteRange(ctx, kStart, kEnd)
+}
+
+func (s *mutationTrackedStore) DeleteAll(ctx Context, allVersions bool) error {
+	var ranges []MutationRange
+	if beg, end := ctx.KeyRange(); len(beg) != 0 && beg[0] == dataKeyPrefix {
+		ranges = append(ranges, MutationRange{beg, end})
+	}
+	done, err := s.record(ranges...)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return s.OrderedKeyValueDB.DeleteAll(ctx, allVersions)
+}
+
+// CloneInstance records the clone's link, which is its only key until either instance
+// changes, so incremental backups restore the clone.
+func (s *mutationTrackedStore) CloneInstance(src, dst dvid.InstanceID) error {
+	link := cloneLinkKey(dst)
+	done, err := s.record(MutationRange{Beg: link, End: link})
+	if err != nil {
+		return err
+	}
+	defer done()
+	return CloneInstance(s.OrderedKeyValueDB, src, dst)
+}
+
+type mutationTrackedBatchStore struct {
+	*mutationTrackedStore
+	batcher KeyValueBatcher
+}
+
+func (s *mutationTrackedBatchStore) NewBatch(ctx Context) Batch {
+	return &mutationTrackedBatch{Batch: s.batcher.NewBatch(ctx), ctx: ctx, s: s.mutationTrackedStore}
+}
+
+type mutationTrackedBatch struct {
+	Batch
+	ctx    Context
+	s      *mutationTrackedStore
+	ranges []MutationRange
+}
+
+func (b *mutationTrackedBatch) Put(tk TKey, v []byte) {
+	if r, ok := tkeyRange(b.ctx, tk); ok {
+		b.ranges = append(b.ranges, r)
+	}
+	b.Batch.Put(tk, v)
+}
+
+func (b *mutationTrackedBatch) Delete(tk TKey) {
+	if r, ok := tkeyRange(b.ctx, tk); ok {
+		b.ranges = append(b.ranges, r)
+	}
+	b.Batch.Delete(tk)
+}
+
+func (b *mutationTrackedBatch) Commit() error {
+	done, err := b.s.record(b.ranges...)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return b.Batch.Commit()
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMutationTracking(t *testing.T) {
+	meta := &testKVStore{kv: make(map[string][]byte)}
+	if err := mutationSeq.init(meta); err != nil {
+		t.Fatalf("unable to initialize mutation sequence: %v\n", err)
+	}
+	defer func() {
+		mutationSeq.Lock()
+		mutationSeq.store = nil
+		mutationSeq.Unlock()
+	}()
+	mem := &testBatchStore{testKVStore: &testKVStore{kv: make(map[string][]byte)}}
+	store, err := wrapMutationTracking(mem)
+	if err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	db := store.(OrderedKeyValueDB)
+
+	// Metadata writes aren't in the data key space so aren't mutations.
+	var metaCtx MetadataContext
+	if err := db.Put(metaCtx, TKey("meta"), []byte("m")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if hw := MutationHighWater(); hw != 0 {
+		t.Errorf("expected no mutations after metadata put, got high-water mark %d\n", hw)
+	}
+
+	ctx := NewDataContext(&testData{instanceID: 1}, 1)
+	if err := db.Put(ctx, TKey("a"), []byte("a")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if err := db.Delete(ctx, TKey("b")); err != nil {
+		t.Fatalf("error on delete: %v\n", err)
+	}
+	batch := store.(KeyValueBatcher).NewBatch(ctx)
+	batch.Put(TKey("c"), []byte("c"))
+	batch.Put(TKey("d"), []byte("d"))
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("error on batch commit: %v\n", err)
+	}
+	hw := MutationHighWater()
+	if hw != 3 {
+		t.Fatalf("expected high-water mark 3, got %d\n", hw)
+	}
+	if v, err := meta.Get(metaCtx, NewTKey(MutationSeqClass, nil)); err != nil || len(v) != 8 {
+		t.Errorf("expected reserved sequence numbers to be persisted, got %v: %v\n", v, err)
+	}
+
+	expected := [][]string{{"a"}, {"b"}, {"c", "d"}}
+	var seqs []uint64
+	err = ReadMutations(db, 0, hw, func(seq uint64, ranges []MutationRange) error {
+		seqs = append(seqs, seq)
+		keys := expected[len(seqs)-1]
+		if len(ranges) != len(keys) {
+			t.Fatalf("expected %d ranges for mutation %d, got %d\n", len(keys), seq, len(ranges))
+		}
+		for i, r := range ranges {
+			k := ctx.ConstructKey(TKey(keys[i]))
+			if bytes.Compare(k, r.Beg) < 0 || bytes.Compare(k, r.End) > 0 {
+				t.Errorf("expected key %q in range of mutation %d\n", keys[i], seq)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error reading mutations: %v\n", err)
+	}
+	if len(seqs) != 3 || seqs[0] != 1 || seqs[2] != 3 {
+		t.Errorf("expected mutations 1 to 3, got %v\n", seqs)
+	}
+
+	var numRead int
+	err = ReadMutations(db, 2, hw, func(seq uint64, ranges []MutationRange) error {
+		numRead++
+		if seq != 3 {
+			t.Errorf("expected only mutation 3 after 2, got %d\n", seq)
+		}
+		return nil
+	})
+	if err != nil || numRead != 1 {
+		t.Errorf("expected one mutation after 2, got %d: %v\n", numRead, err)
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// RWLocker is a reader/writer lock, e.g., a *sync.RWMutex.
+type RWLocker interface {
+	sync.Locker
+	RLock()
+	RUnlock()
+}
+
+// number of shards in the node lock table, each with its own mutex.
+const nodeLockShards = 64
+
+// nodeID identifies a data instance at a version.
+type nodeID struct {
+	instance dvid.InstanceID
+	version  dvid.VersionID
+}
+
+// nodeLock is an entry in the node lock table that is removed once no goroutine holds
+// or awaits it.
+type nodeLock struct {
+	sync.RWMutex
+	refs int
+}
+
+type nodeLockShard struct {
+	sync.Mutex
+	locks map[nodeID]*nodeLock
+}
+
+var nodeLockTable [nodeLockShards]nodeLockShard
+
+func init() {
+	for i := range nodeLockTable {
+		nodeLockTable[i].locks = make(map[nodeID]*nodeLock)
+	}
+}
+
+func (id nodeID) shard() *nodeLockShard {
+	h := uint64(id.instance)*31 + uint64(id.version)
+	return &nodeLockTable[h%nodeLockShards]
+}
+
+// acquire returns the node's lock table entry, adding it if necessary, and counts a
+// reference to it.
+func (id nodeID) acquire() *nodeLock {
+	shard := id.shard()
+	shard.Lock()
+	defer shard.Unlock()
+	nl, found := shard.locks[id]
+	if !found {
+		nl = new(nodeLock)
+		shard.locks[id] = nl
+	}
+	nl.refs++
+	return nl
+}
+
+// release removes a reference to the node's lock table entry, removing the entry if it
+// was the last.
+func (id nodeID) release() *nodeLock {
+	shard := id.shard()
+	shard.Lock()
+	defer shard.Unlock()
+	nl := shard.locks[id]
+	if nl.refs--; nl.refs == 0 {
+		delete(shard.locks, id)
+	}
+	return nl
+}
+
+// NodeMutex is a reader/writer lock for a data instance at a version.  Locks for nodes
+// that aren't locked take no memory, so any number of nodes can be locked over time.
+// Like a sync.RWMutex, it may be unlocked by a different goroutine than locked it.
+type NodeMutex struct {
+	id nodeID
+}
+
+// NewNodeMutex returns the lock for the given data instance and version.
+func NewNodeMutex(instance dvid.InstanceID, version dvid.VersionID) NodeMutex {
+	return NodeMutex{nodeID{instance, version}}
+}
+
+// Lock locks the node for writing.
+func (m NodeMutex) Lock() {
+	m.id.acquire().Lock()
+}
+
+// Unlock unlocks the node for writing.
+func (m NodeMutex) Unlock() {
+	m.id.release().Unlock()
+}
+
+// RLock locks the node for reading.
+func (m NodeMutex) RLock() {
+	m.id.acquire().RLock()
+}
+
+// RUnlock undoes a single RLock call.
+func (m NodeMutex) RUnlock() {
+	m.id.release().RUnlock()
+}
+
+// lockedNodes returns the number of nodes in the lock table.
+func lockedNodes() int {
+	var n int
+	for i := range nodeLockTable {
+		shard := &nodeLockTable[i]
+		shard.Lock()
+		n += len(shard.locks)
+		shard.Unlock()
+	}
+	return n
+}
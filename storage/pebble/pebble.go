@@ -0,0 +1,1066 @@
+// +build pebble
+
+/*
+	Package pebble implements a storage engine using CockroachDB's Pebble, a pure Go
+	LSM key-value store inspired by leveldb and RocksDB.  It is the default embedded
+	store since it needs no cgo and is actively maintained.
+*/
+package pebble
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	humanize "github.com/janelia-flyem/go/go-humanize"
+
+	api "github.com/cockroachdb/pebble"
+)
+
+const (
+	// Default size of the block cache in bytes.
+	DefaultCacheSize = 536870912
+
+	// Default size of a memtable before it is flushed to a sorted on-disk file.
+	// Like the leveldb write buffer, larger values speed up bulk loads at the
+	// cost of memory and recovery time.
+	DefaultMemTableSize = 62914560
+
+	// Number of open files that can be used by the datastore.
+	DefaultMaxOpenFiles = 1024
+
+	// If Sync=true, each write is flushed to stable storage before returning.
+	// See basholeveldb for a discussion of crash semantics.
+	DefaultSync = false
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in pebble: %v\n", err)
+	}
+	e := Engine{"pebble", "CockroachDB Pebble pure Go LSM", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return false
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a pebble store. The passed Config must contain "path" string.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newPebbleDB(config)
+}
+
+func parseConfig(config dvid.StoreConfig) (path string, testing bool, err error) {
+	c := config.GetAll()
+
+	v, found := c["path"]
+	if !found {
+		err = fmt.Errorf("%q must be specified for pebble configuration", "path")
+		return
+	}
+	var ok bool
+	path, ok = v.(string)
+	if !ok {
+		err = fmt.Errorf("%q setting must be a string (%v)", "path", v)
+		return
+	}
+	v, found = c["testing"]
+	if found {
+		testing, ok = v.(bool)
+		if !ok {
+			err = fmt.Errorf("%q setting must be a bool (%v)", "testing", v)
+			return
+		}
+	}
+	if testing {
+		path = filepath.Join(os.TempDir(), path)
+	}
+	return
+}
+
+// intSetting returns an integer setting that may have been decoded from TOML or JSON.
+func intSetting(c map[string]interface{}, key string, defaultValue int) (int, error) {
+	v, found := c[key]
+	if !found {
+		return defaultValue, nil
+	}
+	switch i := v.(type) {
+	case int:
+		return i, nil
+	case int64:
+		return int(i), nil
+	case float64:
+		return int(i), nil
+	case string:
+		return strconv.Atoi(i)
+	default:
+		return 0, fmt.Errorf("%q setting must be an integer (%v)", key, v)
+	}
+}
+
+// getOptions returns pebble options and write options from the store configuration.
+// The returned cache must be released via Unref() after the database is opened.
+func getOptions(c map[string]interface{}) (*api.Options, *api.WriteOptions, *api.Cache, error) {
+	cacheSize, err := intSetting(c, "cachesize", 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if cacheSize == 0 {
+		cacheSize = DefaultCacheSize
+	} else {
+		cacheSize *= dvid.Mega
+	}
+	dvid.Infof("pebble cache size: %s\n", humanize.Bytes(uint64(cacheSize)))
+
+	memTableSize, err := intSetting(c, "memtablesize", 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if memTableSize == 0 {
+		memTableSize = DefaultMemTableSize
+	} else {
+		memTableSize *= dvid.Mega
+	}
+	dvid.Infof("pebble memtable size: %s\n", humanize.Bytes(uint64(memTableSize)))
+
+	maxOpenFiles, err := intSetting(c, "maxopenfiles", DefaultMaxOpenFiles)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	wo := api.NoSync
+	if DefaultSync {
+		wo = api.Sync
+	}
+	if v, found := c["sync"]; found {
+		sync, ok := v.(bool)
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("%q setting must be a bool (%v)", "sync", v)
+		}
+		if sync {
+			wo = api.Sync
+		} else {
+			wo = api.NoSync
+		}
+	}
+
+	cache := api.NewCache(int64(cacheSize))
+	opts := &api.Options{
+		Cache:        cache,
+		MemTableSize: memTableSize,
+		MaxOpenFiles: maxOpenFiles,
+	}
+	return opts.EnsureDefaults(), wo, cache, nil
+}
+
+// newPebbleDB returns a pebble backend, creating the database
+// at the path if it doesn't already exist.
+func (e Engine) newPebbleDB(config dvid.StoreConfig) (*PebbleDB, bool, error) {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Is there a database already at this path?  If not, create.
+	var created bool
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		dvid.Infof("Database not already at path (%s). Creating directory...\n", path)
+		created = true
+		if err := os.MkdirAll(path, 0744); err != nil {
+			return nil, true, fmt.Errorf("Can't make directory at %s: %v", path, err)
+		}
+	} else {
+		dvid.Infof("Found directory at %s (err = %v)\n", path, err)
+	}
+
+	opts, wo, cache, err := getOptions(config.GetAll())
+	if err != nil {
+		return nil, false, err
+	}
+	defer cache.Unref()
+
+	dvid.Infof("Opening pebble @ path %s\n", path)
+	pdb, err := api.Open(path, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	db := &PebbleDB{
+		directory: path,
+		config:    config,
+		wo:        wo,
+		pdb:       pdb,
+	}
+
+	// if we know it's newly created, just return.
+	if created {
+		return db, created, nil
+	}
+
+	// otherwise, check if there's been any metadata or we need to initialize it.
+	metadataExists, err := db.metadataExists()
+	if err != nil {
+		db.Close()
+		return nil, false, err
+	}
+	return db, !metadataExists, nil
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets pebble as the default key-value backend.  If another
+// engine is already set, it returns an error since only one key-value backend should
+// be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("pebble can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	if backend.Metadata != "" {
+		return fmt.Errorf("pebble can't be testable key-value.  Metadata already set to %s", backend.Metadata)
+	}
+	alias := storage.Alias("pebble")
+	backend.Metadata = alias
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"path":    fmt.Sprintf("dvid-test-pebble-%x", uuid.NewV4().Bytes()),
+		"testing": true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "pebble"}
+	return nil
+}
+
+// Delete implements the TestableEngine interface by providing a way to dispose
+// of testing databases.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Delete the directory if it exists
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("Can't delete old datastore %q: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// --- The Pebble implementation must satisfy a Engine interface ----
+
+type PebbleDB struct {
+	// Directory of datastore
+	directory string
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+
+	wo  *api.WriteOptions
+	pdb *api.DB
+}
+
+func (db *PebbleDB) String() string {
+	return fmt.Sprintf("pebble @ %s", db.directory)
+}
+
+// Close closes the pebble database.
+func (db *PebbleDB) Close() {
+	if db != nil && db.pdb != nil {
+		if err := db.pdb.Close(); err != nil {
+			dvid.Errorf("Error closing %s: %v\n", db, err)
+		}
+		db.pdb = nil
+	}
+}
+
+// Equal returns true if the pebble db matches the given store configuration.
+func (db *PebbleDB) Equal(config dvid.StoreConfig) bool {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.directory == path
+}
+
+func (db *PebbleDB) metadataExists() (bool, error) {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	it := db.pdb.NewIter(nil)
+	defer it.Close()
+
+	if it.SeekGE(keyBeg) && bytes.Compare(it.Key(), keyEnd) <= 0 {
+		return true, nil
+	}
+	if err := it.Error(); err != nil {
+		return false, err
+	}
+	dvid.Infof("No metadata found for %s...\n", db)
+	return false, nil
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *PebbleDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GET on nil PebbleDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+
+		// Get all versions of this key and return the most recent
+		values, err := db.getSingleKeyVersions(vctx, tk, false)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			return kv.V, err
+		}
+		return nil, err
+	}
+	key := ctx.ConstructKey(tk)
+	value, closer, err := db.pdb.Get(key)
+	if err == api.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v := append([]byte{}, value...)
+	closer.Close()
+	storage.StoreValueBytesRead <- len(v)
+	return v, nil
+}
+
+// Exists returns true if a value is stored for the key.  Missing unversioned keys are
+// usually rejected by the bloom filters, and no values are copied out of Pebble.
+func (db *PebbleDB) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists on nil PebbleDB")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		versions, err := db.getSingleKeyVersions(vctx, tk, true)
+		if err != nil {
+			return false, err
+		}
+		kv, err := vctx.VersionedKeyValue(versions)
+		return kv != nil, err
+	}
+	_, closer, err := db.pdb.Get(ctx.ConstructKey(tk))
+	if err == api.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	closer.Close()
+	return true, nil
+}
+
+// getSingleKeyVersions returns all versions of a key, without values if keysOnly is true.
+// These key-value pairs will be sorted in ascending key order and could include a tombstone
+// key.
+func (db *PebbleDB) getSingleKeyVersions(vctx storage.VersionedCtx, tk []byte, keysOnly bool) ([]*storage.KeyValue, error) {
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+
+	it := db.pdb.NewIter(nil)
+	defer it.Close()
+
+	values := []*storage.KeyValue{}
+	for it.SeekGE(begKey); it.Valid(); it.Next() {
+		itKey := append([]byte{}, it.Key()...)
+		storage.StoreKeyBytesRead <- len(itKey)
+		if bytes.Compare(itKey, endKey) > 0 {
+			return values, nil
+		}
+		if keysOnly {
+			values = append(values, &storage.KeyValue{K: itKey})
+			continue
+		}
+		itValue := append([]byte{}, it.Value()...)
+		storage.StoreValueBytesRead <- len(itValue)
+		values = append(values, &storage.KeyValue{K: itKey, V: itValue})
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+type errorableKV struct {
+	*storage.KeyValue
+	error
+}
+
+func sendKV(vctx storage.VersionedCtx, values []*storage.KeyValue, ch chan errorableKV) {
+	if len(values) != 0 {
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil {
+			ch <- errorableKV{nil, err}
+			return
+		}
+		if kv != nil {
+			ch <- errorableKV{kv, nil}
+		}
+	}
+}
+
+// versionedRange sends a range of key-value pairs for a particular version down a channel.
+func (db *PebbleDB) versionedRange(vctx storage.VersionedCtx, begTKey, endTKey storage.TKey, ch chan errorableKV, done <-chan struct{}, keysOnly bool) {
+	minKey, err := vctx.MinVersionKey(begTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxKey, err := vctx.MaxVersionKey(endTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(begTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+
+	it := db.pdb.NewIter(nil)
+	defer it.Close()
+
+	values := []*storage.KeyValue{}
+	for it.SeekGE(minKey); it.Valid(); it.Next() {
+		select {
+		case <-done: // only happens if we don't care about rest of data.
+			ch <- errorableKV{nil, nil}
+			return
+		default:
+		}
+		itKey := append([]byte{}, it.Key()...)
+		storage.StoreKeyBytesRead <- len(itKey)
+		var itValue []byte
+		if !keysOnly {
+			itValue = append([]byte{}, it.Value()...)
+			storage.StoreValueBytesRead <- len(itValue)
+		}
+
+		// Did we pass all versions for last key read?
+		if bytes.Compare(itKey, maxVersionKey) > 0 {
+			indexBytes, err := storage.TKeyFromKey(itKey)
+			if err != nil {
+				ch <- errorableKV{nil, err}
+				return
+			}
+			maxVersionKey, err = vctx.MaxVersionKey(indexBytes)
+			if err != nil {
+				ch <- errorableKV{nil, err}
+				return
+			}
+			sendKV(vctx, values, ch)
+			values = []*storage.KeyValue{}
+		}
+		// Did we pass the final key?
+		if bytes.Compare(itKey, maxKey) > 0 {
+			break
+		}
+		values = append(values, &storage.KeyValue{K: itKey, V: itValue})
+	}
+	if err := it.Error(); err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	sendKV(vctx, values, ch)
+	ch <- errorableKV{nil, nil}
+}
+
+// unversionedRange sends a range of key-value pairs down a channel.
+func (db *PebbleDB) unversionedRange(ctx storage.Context, begTKey, endTKey storage.TKey, ch chan errorableKV, done <-chan struct{}, keysOnly bool) {
+	begKey := ctx.ConstructKey(begTKey)
+	endKey := ctx.ConstructKey(endTKey)
+
+	it := db.pdb.NewIter(nil)
+	defer it.Close()
+
+	for it.SeekGE(begKey); it.Valid(); it.Next() {
+		itKey := append([]byte{}, it.Key()...)
+		storage.StoreKeyBytesRead <- len(itKey)
+		// Did we pass the final key?
+		if bytes.Compare(itKey, endKey) > 0 {
+			break
+		}
+		var itValue []byte
+		if !keysOnly {
+			itValue = append([]byte{}, it.Value()...)
+			storage.StoreValueBytesRead <- len(itValue)
+		}
+		select {
+		case <-done:
+			ch <- errorableKV{nil, nil}
+			return
+		case ch <- errorableKV{&storage.KeyValue{K: itKey, V: itValue}, nil}:
+		}
+	}
+	if err := it.Error(); err != nil {
+		ch <- errorableKV{nil, err}
+	} else {
+		ch <- errorableKV{nil, nil}
+	}
+}
+
+// rangeQuery launches a possibly versioned range query in a goroutine.
+func (db *PebbleDB) rangeQuery(ctx storage.Context, kStart, kEnd storage.TKey, done <-chan struct{}, keysOnly bool) chan errorableKV {
+	ch := make(chan errorableKV)
+	go func() {
+		if !ctx.Versioned() {
+			db.unversionedRange(ctx, kStart, kEnd, ch, done, keysOnly)
+		} else {
+			db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, keysOnly)
+		}
+	}()
+	return ch
+}
+
+// drain consumes any remaining results so the range goroutine can exit.
+func drain(ch chan errorableKV) {
+	go func() {
+		for result := range ch {
+			if result.KeyValue == nil {
+				return
+			}
+		}
+	}()
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *PebbleDB) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil PebbleDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	// Consume the keys.
+	values := []storage.TKey{}
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return nil, result.error
+			}
+			return values, nil
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return nil, err
+		}
+		values = append(values, tk)
+	}
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *PebbleDB) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, kch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	// Consume the keys.
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			kch <- nil
+			return result.error
+		}
+		kch <- result.KeyValue.K
+	}
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *PebbleDB) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil PebbleDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, false)
+
+	// Consume the key-value pairs.
+	values := []*storage.TKeyValue{}
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return nil, result.error
+			}
+			return values, nil
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return nil, err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: result.KeyValue.V})
+	}
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *PebbleDB) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	f = storage.CancelableChunkFunc(ctx, f)
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, false)
+
+	// Consume the key-value pairs.
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			return result.error
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return err
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tkv := storage.TKeyValue{K: tk, V: result.KeyValue.V}
+		chunk := &storage.Chunk{ChunkOp: op, TKeyValue: &tkv}
+		if err := f(chunk); err != nil {
+			drain(ch)
+			return err
+		}
+	}
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (db *PebbleDB) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil PebbleDB")
+	}
+	it := db.pdb.NewIter(nil)
+	defer it.Close()
+
+	for it.SeekGE(kStart); it.Valid(); it.Next() {
+		itKey := append([]byte{}, it.Key()...)
+		storage.StoreKeyBytesRead <- len(itKey)
+		// Did we pass the final key?
+		if bytes.Compare(itKey, kEnd) > 0 {
+			break
+		}
+		var itValue []byte
+		if !keysOnly {
+			itValue = append([]byte{}, it.Value()...)
+			storage.StoreValueBytesRead <- len(itValue)
+		}
+		select {
+		case out <- &storage.KeyValue{K: itKey, V: itValue}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	out <- nil
+	return it.Error()
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *PebbleDB) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	var err error
+	key := ctx.ConstructKey(tk)
+	if !ctx.Versioned() {
+		err = db.pdb.Set(key, v, db.wo)
+	} else {
+		batch := db.NewBatch(ctx)
+		batch.Put(tk, v)
+		if err = batch.Commit(); err != nil {
+			dvid.Criticalf("Error on batch commit of Put: %v\n", err)
+			err = fmt.Errorf("Error on batch commit of Put: %v", err)
+		}
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	return err
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *PebbleDB) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil PebbleDB")
+	}
+	if err := db.pdb.Set(k, v, db.wo); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *PebbleDB) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	if !ctx.Versioned() {
+		return db.pdb.Delete(ctx.ConstructKey(tk), db.wo)
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of Delete: %v\n", err)
+		return fmt.Errorf("Error on batch commit of Delete: %v", err)
+	}
+	return nil
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *PebbleDB) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil PebbleDB")
+	}
+	return db.pdb.Delete(k, db.wo)
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+func (db *PebbleDB) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of PutRange: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.
+func (db *PebbleDB) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+
+	const BATCH_SIZE = 10000
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	batch := db.NewBatch(ctx)
+	numKV := 0
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return result.error
+			}
+			break
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return err
+		}
+		batch.Delete(tk)
+		if (numKV+1)%BATCH_SIZE == 0 {
+			if err := batch.Commit(); err != nil {
+				drain(ch)
+				dvid.Criticalf("Error on batch commit of DeleteRange at key-value pair %d: %v\n", numKV, err)
+				return fmt.Errorf("Error on batch commit of DeleteRange at key-value pair %d: %v", numKV, err)
+			}
+			batch = db.NewBatch(ctx)
+		}
+		numKV++
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on last batch commit of DeleteRange: %v\n", err)
+		return fmt.Errorf("Error on last batch commit of DeleteRange: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", numKV, ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+// Deletion of all versions uses a pebble range deletion so it doesn't require a scan.
+func (db *PebbleDB) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	if allVersions {
+		minKey, maxKey := ctx.KeyRange()
+		if err := db.pdb.DeleteRange(minKey, maxKey, db.wo); err != nil {
+			return fmt.Errorf("Error on DELETE ALL for %s: %v", ctx, err)
+		}
+		dvid.Debugf("Deleted all key-value pairs via DELETE ALL for %s.\n", ctx)
+		return nil
+	}
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if !versioned {
+		return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+	}
+	return db.deleteSingleVersion(vctx)
+}
+
+func (db *PebbleDB) deleteSingleVersion(vctx storage.VersionedCtx) error {
+	minKey, err := vctx.MinVersionKey(storage.MinTKey(storage.TKeyMinClass))
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(storage.MaxTKey(storage.TKeyMaxClass))
+	if err != nil {
+		return err
+	}
+
+	it := db.pdb.NewIter(nil)
+	defer it.Close()
+
+	const BATCH_SIZE = 10000
+	batch := db.pdb.NewBatch()
+	numKV := 0
+	deleteVersion := vctx.VersionID()
+	for it.SeekGE(minKey); it.Valid(); it.Next() {
+		itKey := it.Key()
+		storage.StoreKeyBytesRead <- len(itKey)
+		// Did we pass the final key?
+		if bytes.Compare(itKey, maxKey) > 0 {
+			break
+		}
+		_, v, _, err := storage.DataKeyToLocalIDs(itKey)
+		if err != nil {
+			batch.Close()
+			return fmt.Errorf("Error on DELETE ALL for version %d: %v", deleteVersion, err)
+		}
+		if v != deleteVersion {
+			continue
+		}
+		batch.Delete(itKey, nil)
+		if (numKV+1)%BATCH_SIZE == 0 {
+			if err := batch.Commit(db.wo); err != nil {
+				batch.Close()
+				dvid.Criticalf("Error on batch commit of DeleteAll at key-value pair %d: %v\n", numKV, err)
+				return fmt.Errorf("Error on batch commit of DeleteAll at key-value pair %d: %v", numKV, err)
+			}
+			batch.Close()
+			batch = db.pdb.NewBatch()
+			dvid.Debugf("Deleted %d key-value pairs in ongoing DELETE ALL for %s.\n", numKV+1, vctx)
+		}
+		numKV++
+	}
+	defer batch.Close()
+	if err := it.Error(); err != nil {
+		return fmt.Errorf("Error iterating during DeleteAll for %s: %v", vctx, err)
+	}
+	if err := batch.Commit(db.wo); err != nil {
+		dvid.Criticalf("Error on last batch commit of DeleteAll: %v\n", err)
+		return fmt.Errorf("Error on last batch commit of DeleteAll: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", numKV, vctx)
+	return nil
+}
+
+// --- Batcher interface ----
+
+type goBatch struct {
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	*api.Batch
+	wo *api.WriteOptions
+}
+
+// NewBatch returns an implementation that allows batch writes
+func (db *PebbleDB) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil PebbleDB\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		vctx = nil
+	}
+	return &goBatch{ctx, vctx, db.pdb.NewBatch(), db.wo}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.Batch.Set(tombstone, dvid.EmptyValue(), nil)
+	}
+	batch.Batch.Delete(key, nil)
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.Batch.Delete(tombstone, nil)
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.Batch.Set(key, v, nil)
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	err := batch.Batch.Commit(batch.wo)
+	batch.Batch.Close()
+	return err
+}
+
+// ---- SizeViewer interface ------
+
+// GetApproximateSizes returns the estimated disk usage of each key range.
+func (db *PebbleDB) GetApproximateSizes(ranges []storage.KeyRange) ([]uint64, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetApproximateSizes on nil PebbleDB")
+	}
+	sizes := make([]uint64, len(ranges))
+	for i, kr := range ranges {
+		size, err := db.pdb.EstimateDiskUsage(kr.Start, kr.OpenEnd)
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = size
+	}
+	return sizes, nil
+}
+
+// ---- Compacter interface ------
+
+// Compact compacts the key range, discarding deleted and overwritten values.
+func (db *PebbleDB) Compact(r storage.KeyRange) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Compact on nil PebbleDB")
+	}
+	return db.pdb.Compact(r.Start, r.OpenEnd)
+}
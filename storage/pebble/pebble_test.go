@@ -0,0 +1,79 @@
+// +build pebble
+
+package pebble
+
+import (
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	api "github.com/cockroachdb/pebble"
+)
+
+func TestPebbleOptions(t *testing.T) {
+	opts, wo, cache, err := getOptions(map[string]interface{}{
+		"cachesize":    int64(16),
+		"memtablesize": "8",
+		"maxopenfiles": 100.0,
+		"sync":         true,
+	})
+	if err != nil {
+		t.Fatalf("error getting options: %v\n", err)
+	}
+	defer cache.Unref()
+	if opts.MemTableSize != 8*dvid.Mega || opts.MaxOpenFiles != 100 || wo != api.Sync {
+		t.Errorf("bad options: memtable size %d, max open files %d, sync %t\n", opts.MemTableSize, opts.MaxOpenFiles, wo.Sync)
+	}
+
+	opts, wo, cache, err = getOptions(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("error getting default options: %v\n", err)
+	}
+	defer cache.Unref()
+	if opts.MemTableSize != DefaultMemTableSize || opts.MaxOpenFiles != DefaultMaxOpenFiles || wo.Sync != DefaultSync {
+		t.Errorf("expected default options, got memtable size %d, max open files %d, sync %t\n", opts.MemTableSize, opts.MaxOpenFiles, wo.Sync)
+	}
+
+	for _, c := range []map[string]interface{}{{"cachesize": "lots"}, {"maxopenfiles": true}, {"sync": "yes"}} {
+		if _, _, _, err := getOptions(c); err == nil {
+			t.Errorf("expected error getting options for %v\n", c)
+		}
+	}
+}
+
+func TestPebbleReopen(t *testing.T) {
+	var e Engine
+	backend := new(storage.Backend)
+	if err := e.AddTestConfig(backend); err != nil {
+		t.Fatal(err)
+	}
+	config := backend.Stores[backend.DefaultKVDB]
+	defer e.Delete(config)
+
+	db, created, err := e.newPebbleDB(config)
+	if err != nil {
+		t.Fatalf("unable to open test store: %v\n", err)
+	}
+	if !created {
+		t.Errorf("expected new store to need metadata\n")
+	}
+	var ctx storage.MetadataContext
+	if err := db.Put(ctx, storage.TKey("a"), []byte("kept")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	db.Close()
+
+	// A reopened store keeps its data and doesn't need metadata initialized.
+	db, created, err = e.newPebbleDB(config)
+	if err != nil {
+		t.Fatalf("unable to reopen test store: %v\n", err)
+	}
+	defer db.Close()
+	if created {
+		t.Errorf("expected reopened store to have metadata\n")
+	}
+	if v, err := db.Get(ctx, storage.TKey("a")); err != nil || string(v) != "kept" {
+		t.Errorf("expected value after reopening, got %q: %v\n", v, err)
+	}
+}
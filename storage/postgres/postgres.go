@@ -0,0 +1,975 @@
+// +build postgres
+
+/*
+	Package postgres implements an ordered key-value storage engine on top of a
+	PostgreSQL table, allowing deployments with managed Postgres to keep DVID metadata
+	and small data instances there.
+
+	All key-value pairs are held in one table with a bytea primary key.  Postgres
+	compares bytea values bytewise, so range queries become BETWEEN scans over the
+	primary key index that return keys in the same order as other DVID ordered
+	key-value stores.
+*/
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// DefaultTable is the name of the key-value table if none is configured.
+	DefaultTable = "dvid_kv"
+
+	// DefaultMaxOpenConns limits the number of connections to the Postgres server.
+	DefaultMaxOpenConns = 32
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in postgres: %v\n", err)
+	}
+	e := Engine{"postgres", "PostgreSQL bytea-keyed table", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return false
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a postgres store. The passed Config must contain "connection",
+// a libpq connection string or URL, e.g., "postgres://dvid@dbhost/dvid?sslmode=require".
+// Optional settings are "table" for the key-value table name and "maxopenconns".
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newPostgresDB(config)
+}
+
+func parseConfig(config dvid.StoreConfig) (connection, table string, maxOpenConns int, err error) {
+	c := config.GetAll()
+
+	v, found := c["connection"]
+	if !found {
+		err = fmt.Errorf("%q must be specified for postgres configuration", "connection")
+		return
+	}
+	var ok bool
+	if connection, ok = v.(string); !ok {
+		err = fmt.Errorf("%q setting must be a string (%v)", "connection", v)
+		return
+	}
+	table = DefaultTable
+	if v, found = c["table"]; found {
+		if table, ok = v.(string); !ok || table == "" {
+			err = fmt.Errorf("%q setting must be a non-empty string (%v)", "table", v)
+			return
+		}
+	}
+	maxOpenConns = DefaultMaxOpenConns
+	if v, found = c["maxopenconns"]; found {
+		switch n := v.(type) {
+		case int64:
+			maxOpenConns = int(n)
+		case int:
+			maxOpenConns = n
+		default:
+			err = fmt.Errorf("%q setting must be an integer (%v)", "maxopenconns", v)
+			return
+		}
+	}
+	return
+}
+
+// newPostgresDB returns a postgres backend, creating the key-value table if it doesn't
+// already exist.
+func (e Engine) newPostgresDB(config dvid.StoreConfig) (*PostgresDB, bool, error) {
+	connection, table, maxOpenConns, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+	sdb, err := sql.Open("postgres", connection)
+	if err != nil {
+		return nil, false, err
+	}
+	sdb.SetMaxOpenConns(maxOpenConns)
+	if err := sdb.Ping(); err != nil {
+		sdb.Close()
+		return nil, false, fmt.Errorf("unable to connect to postgres: %v", err)
+	}
+	db := &PostgresDB{
+		connection: connection,
+		table:      table,
+		config:     config,
+		sdb:        sdb,
+	}
+
+	var exists bool
+	if err := sdb.QueryRow("SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+		db.Close()
+		return nil, false, err
+	}
+	if !exists {
+		dvid.Infof("Creating key-value table %q in postgres...\n", table)
+		if _, err := sdb.Exec(db.query("CREATE TABLE IF NOT EXISTS %s (k bytea PRIMARY KEY, v bytea)")); err != nil {
+			db.Close()
+			return nil, false, fmt.Errorf("unable to create key-value table %q: %v", table, err)
+		}
+		return db, true, nil
+	}
+
+	// otherwise, check if there's been any metadata or we need to initialize it.
+	metadataExists, err := db.metadataExists()
+	if err != nil {
+		db.Close()
+		return nil, false, err
+	}
+	return db, !metadataExists, nil
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets postgres as the default key-value backend and metadata store, using
+// the server given by the libpq connection string in the DVID_TEST_POSTGRES environment
+// variable.  Each test store uses a new table.  If another engine is already set, it
+// returns an error since only one key-value backend should be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("postgres can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	if backend.Metadata != "" {
+		return fmt.Errorf("postgres can't be testable key-value.  Metadata already set to %s", backend.Metadata)
+	}
+	connection := os.Getenv("DVID_TEST_POSTGRES")
+	if connection == "" {
+		return fmt.Errorf("postgres can't be testable key-value without a connection in DVID_TEST_POSTGRES")
+	}
+	alias := storage.Alias("postgres")
+	backend.Metadata = alias
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"connection": connection,
+		"table":      fmt.Sprintf("dvid_test_%x", uuid.NewV4().Bytes()),
+		"testing":    true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "postgres"}
+	return nil
+}
+
+// Delete implements the TestableEngine interface by dropping the key-value table.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	connection, table, _, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	sdb, err := sql.Open("postgres", connection)
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+	_, err = sdb.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", pq.QuoteIdentifier(table)))
+	return err
+}
+
+// --- The Postgres implementation must satisfy a Engine interface ----
+
+type PostgresDB struct {
+	// libpq connection string or URL
+	connection string
+
+	// Name of key-value table
+	table string
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+
+	sdb *sql.DB
+}
+
+func (db *PostgresDB) String() string {
+	return fmt.Sprintf("postgres table %q", db.table)
+}
+
+// Close closes the postgres connection pool.
+func (db *PostgresDB) Close() {
+	if db != nil && db.sdb != nil {
+		if err := db.sdb.Close(); err != nil {
+			dvid.Errorf("Error closing %s: %v\n", db, err)
+		}
+		db.sdb = nil
+	}
+}
+
+// Equal returns true if the postgres db matches the given store configuration.
+func (db *PostgresDB) Equal(config dvid.StoreConfig) bool {
+	connection, table, _, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.connection == connection && db.table == table
+}
+
+// query returns a SQL statement with the quoted table name substituted for %s.
+func (db *PostgresDB) query(format string) string {
+	return fmt.Sprintf(format, pq.QuoteIdentifier(db.table))
+}
+
+func (db *PostgresDB) metadataExists() (bool, error) {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	var n int
+	err := db.sdb.QueryRow(db.query("SELECT COUNT(*) FROM (SELECT k FROM %s WHERE k BETWEEN $1 AND $2 LIMIT 1) AS m"), []byte(keyBeg), []byte(keyEnd)).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		dvid.Infof("No metadata found for %s...\n", db)
+	}
+	return n != 0, nil
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *PostgresDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call Get on nil PostgresDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+
+		// Get all versions of this key and return the most recent
+		values, err := db.getSingleKeyVersions(vctx, tk, false)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			return kv.V, err
+		}
+		return nil, err
+	}
+	key := ctx.ConstructKey(tk)
+	var v []byte
+	err := db.sdb.QueryRow(db.query("SELECT v FROM %s WHERE k = $1"), []byte(key)).Scan(&v)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		v = []byte{}
+	}
+	storage.StoreValueBytesRead <- len(v)
+	return v, nil
+}
+
+// Exists returns true if a value is stored for the key without transferring the value.
+func (db *PostgresDB) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists on nil PostgresDB")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		versions, err := db.getSingleKeyVersions(vctx, tk, true)
+		if err != nil {
+			return false, err
+		}
+		kv, err := vctx.VersionedKeyValue(versions)
+		return kv != nil, err
+	}
+	var found bool
+	err := db.sdb.QueryRow(db.query("SELECT EXISTS (SELECT 1 FROM %s WHERE k = $1)"), []byte(ctx.ConstructKey(tk))).Scan(&found)
+	return found, err
+}
+
+// getSingleKeyVersions returns all versions of a key, without values if keysOnly is true.
+// These key-value pairs will be sorted in ascending key order and could include a tombstone
+// key.
+func (db *PostgresDB) getSingleKeyVersions(vctx storage.VersionedCtx, tk []byte, keysOnly bool) ([]*storage.KeyValue, error) {
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	query := "SELECT k, v FROM %s WHERE k BETWEEN $1 AND $2 ORDER BY k"
+	if keysOnly {
+		query = "SELECT k, NULL::bytea FROM %s WHERE k BETWEEN $1 AND $2 ORDER BY k"
+	}
+	rows, err := db.sdb.Query(db.query(query), []byte(begKey), []byte(endKey))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []*storage.KeyValue{}
+	for rows.Next() {
+		var k, v []byte
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		storage.StoreKeyBytesRead <- len(k)
+		storage.StoreValueBytesRead <- len(v)
+		values = append(values, &storage.KeyValue{K: k, V: v})
+	}
+	return values, rows.Err()
+}
+
+type errorableKV struct {
+	*storage.KeyValue
+	error
+}
+
+func sendKV(vctx storage.VersionedCtx, values []*storage.KeyValue, ch chan errorableKV) {
+	if len(values) != 0 {
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil {
+			ch <- errorableKV{nil, err}
+			return
+		}
+		if kv != nil {
+			ch <- errorableKV{kv, nil}
+		}
+	}
+}
+
+// scanRange returns rows with keys in [begKey, endKey] in ascending key order.
+func (db *PostgresDB) scanRange(begKey, endKey storage.Key, keysOnly bool) (*sql.Rows, error) {
+	if keysOnly {
+		return db.sdb.Query(db.query("SELECT k, NULL::bytea FROM %s WHERE k BETWEEN $1 AND $2 ORDER BY k"), []byte(begKey), []byte(endKey))
+	}
+	return db.sdb.Query(db.query("SELECT k, v FROM %s WHERE k BETWEEN $1 AND $2 ORDER BY k"), []byte(begKey), []byte(endKey))
+}
+
+// versionedRange sends a range of key-value pairs for a particular version down a channel.
+func (db *PostgresDB) versionedRange(vctx storage.VersionedCtx, begTKey, endTKey storage.TKey, ch chan errorableKV, done <-chan struct{}, keysOnly bool) {
+	minKey, err := vctx.MinVersionKey(begTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxKey, err := vctx.MaxVersionKey(endTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(begTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+
+	rows, err := db.scanRange(minKey, maxKey, keysOnly)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	defer rows.Close()
+
+	values := []*storage.KeyValue{}
+	for rows.Next() {
+		select {
+		case <-done: // only happens if we don't care about rest of data.
+			ch <- errorableKV{nil, nil}
+			return
+		default:
+		}
+		var itKey, itValue []byte
+		if err := rows.Scan(&itKey, &itValue); err != nil {
+			ch <- errorableKV{nil, err}
+			return
+		}
+		storage.StoreKeyBytesRead <- len(itKey)
+		storage.StoreValueBytesRead <- len(itValue)
+
+		// Did we pass all versions for last key read?
+		if bytes.Compare(itKey, maxVersionKey) > 0 {
+			indexBytes, err := storage.TKeyFromKey(itKey)
+			if err != nil {
+				ch <- errorableKV{nil, err}
+				return
+			}
+			maxVersionKey, err = vctx.MaxVersionKey(indexBytes)
+			if err != nil {
+				ch <- errorableKV{nil, err}
+				return
+			}
+			sendKV(vctx, values, ch)
+			values = []*storage.KeyValue{}
+		}
+		values = append(values, &storage.KeyValue{K: itKey, V: itValue})
+	}
+	if err := rows.Err(); err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	sendKV(vctx, values, ch)
+	ch <- errorableKV{nil, nil}
+}
+
+// unversionedRange sends a range of key-value pairs down a channel.
+func (db *PostgresDB) unversionedRange(ctx storage.Context, begTKey, endTKey storage.TKey, ch chan errorableKV, done <-chan struct{}, keysOnly bool) {
+	rows, err := db.scanRange(ctx.ConstructKey(begTKey), ctx.ConstructKey(endTKey), keysOnly)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itKey, itValue []byte
+		if err := rows.Scan(&itKey, &itValue); err != nil {
+			ch <- errorableKV{nil, err}
+			return
+		}
+		storage.StoreKeyBytesRead <- len(itKey)
+		storage.StoreValueBytesRead <- len(itValue)
+		select {
+		case <-done:
+			ch <- errorableKV{nil, nil}
+			return
+		case ch <- errorableKV{&storage.KeyValue{K: itKey, V: itValue}, nil}:
+		}
+	}
+	if err := rows.Err(); err != nil {
+		ch <- errorableKV{nil, err}
+	} else {
+		ch <- errorableKV{nil, nil}
+	}
+}
+
+// rangeQuery launches a possibly versioned range query in a goroutine.
+func (db *PostgresDB) rangeQuery(ctx storage.Context, kStart, kEnd storage.TKey, done <-chan struct{}, keysOnly bool) chan errorableKV {
+	ch := make(chan errorableKV)
+	go func() {
+		if !ctx.Versioned() {
+			db.unversionedRange(ctx, kStart, kEnd, ch, done, keysOnly)
+		} else {
+			db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, keysOnly)
+		}
+	}()
+	return ch
+}
+
+// drain consumes any remaining results so the range goroutine can exit.
+func drain(ch chan errorableKV) {
+	go func() {
+		for result := range ch {
+			if result.KeyValue == nil {
+				return
+			}
+		}
+	}()
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *PostgresDB) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil PostgresDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	// Consume the keys.
+	values := []storage.TKey{}
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return nil, result.error
+			}
+			return values, nil
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return nil, err
+		}
+		values = append(values, tk)
+	}
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *PostgresDB) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, kch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil PostgresDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	// Consume the keys.
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			kch <- nil
+			return result.error
+		}
+		kch <- result.KeyValue.K
+	}
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *PostgresDB) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil PostgresDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, false)
+
+	// Consume the key-value pairs.
+	values := []*storage.TKeyValue{}
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return nil, result.error
+			}
+			return values, nil
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return nil, err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: result.KeyValue.V})
+	}
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *PostgresDB) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil PostgresDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	f = storage.CancelableChunkFunc(ctx, f)
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, false)
+
+	// Consume the key-value pairs.
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			return result.error
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return err
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tkv := storage.TKeyValue{K: tk, V: result.KeyValue.V}
+		chunk := &storage.Chunk{ChunkOp: op, TKeyValue: &tkv}
+		if err := f(chunk); err != nil {
+			drain(ch)
+			return err
+		}
+	}
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (db *PostgresDB) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil PostgresDB")
+	}
+	rows, err := db.scanRange(kStart, kEnd, keysOnly)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itKey, itValue []byte
+		if err := rows.Scan(&itKey, &itValue); err != nil {
+			return err
+		}
+		storage.StoreKeyBytesRead <- len(itKey)
+		storage.StoreValueBytesRead <- len(itValue)
+		select {
+		case out <- &storage.KeyValue{K: itKey, V: itValue}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	out <- nil
+	return rows.Err()
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *PostgresDB) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil PostgresDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Put(tk, v)
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of Put: %v\n", err)
+		return fmt.Errorf("Error on batch commit of Put: %v", err)
+	}
+	return nil
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *PostgresDB) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil PostgresDB")
+	}
+	if _, err := db.sdb.Exec(db.query(upsertFormat), []byte(k), v); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *PostgresDB) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil PostgresDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of Delete: %v\n", err)
+		return fmt.Errorf("Error on batch commit of Delete: %v", err)
+	}
+	return nil
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *PostgresDB) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil PostgresDB")
+	}
+	_, err := db.sdb.Exec(db.query("DELETE FROM %s WHERE k = $1"), []byte(k))
+	return err
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+func (db *PostgresDB) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange on nil PostgresDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of PutRange: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.
+func (db *PostgresDB) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil PostgresDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	batch := db.NewBatch(ctx)
+	numKV := 0
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return result.error
+			}
+			break
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return err
+		}
+		batch.Delete(tk)
+		numKV++
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of DeleteRange: %v\n", err)
+		return fmt.Errorf("Error on batch commit of DeleteRange: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", numKV, ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+func (db *PostgresDB) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil PostgresDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	if allVersions {
+		minKey, maxKey := ctx.KeyRange()
+		res, err := db.sdb.Exec(db.query("DELETE FROM %s WHERE k >= $1 AND k < $2"), []byte(minKey), []byte(maxKey))
+		if err != nil {
+			return fmt.Errorf("Error on DELETE ALL for %s: %v", ctx, err)
+		}
+		numKV, _ := res.RowsAffected()
+		dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", numKV, ctx)
+		return nil
+	}
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if !versioned {
+		return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+	}
+	return db.deleteSingleVersion(vctx)
+}
+
+func (db *PostgresDB) deleteSingleVersion(vctx storage.VersionedCtx) error {
+	minKey, err := vctx.MinVersionKey(storage.MinTKey(storage.TKeyMinClass))
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(storage.MaxTKey(storage.TKeyMaxClass))
+	if err != nil {
+		return err
+	}
+	rows, err := db.scanRange(minKey, maxKey, true)
+	if err != nil {
+		return err
+	}
+	deleteVersion := vctx.VersionID()
+	var keys [][]byte
+	for rows.Next() {
+		var itKey, itValue []byte
+		if err := rows.Scan(&itKey, &itValue); err != nil {
+			rows.Close()
+			return err
+		}
+		_, v, _, err := storage.DataKeyToLocalIDs(itKey)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("Error on DELETE ALL for version %d: %v", deleteVersion, err)
+		}
+		if v == deleteVersion {
+			keys = append(keys, itKey)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Error scanning during DeleteAll for %s: %v", vctx, err)
+	}
+
+	tx, err := db.sdb.Begin()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, err := tx.Exec(db.query("DELETE FROM %s WHERE k = $1"), k); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Error on DELETE ALL for %s: %v", vctx, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		dvid.Criticalf("Error on commit of DeleteAll: %v\n", err)
+		return fmt.Errorf("Error on commit of DeleteAll: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", len(keys), vctx)
+	return nil
+}
+
+// --- Batcher interface ----
+
+const upsertFormat = "INSERT INTO %s (k, v) VALUES ($1, $2) ON CONFLICT (k) DO UPDATE SET v = EXCLUDED.v"
+
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+type goBatch struct {
+	db   *PostgresDB
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	ops  []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes.  The batch is
+// committed as a single Postgres transaction.
+func (db *PostgresDB) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil PostgresDB\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	var vctx storage.VersionedCtx
+	if ctx.Versioned() {
+		vctx, _ = ctx.(storage.VersionedCtx)
+	}
+	return &goBatch{db: db, ctx: ctx, vctx: vctx}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, value: dvid.EmptyValue()})
+	}
+	batch.ops = append(batch.ops, batchOp{key: key, delete: true})
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, delete: true})
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.ops = append(batch.ops, batchOp{key: key, value: v})
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	tx, err := batch.db.sdb.Begin()
+	if err != nil {
+		return err
+	}
+	putStmt, err := tx.Prepare(batch.db.query(upsertFormat))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer putStmt.Close()
+	delStmt, err := tx.Prepare(batch.db.query("DELETE FROM %s WHERE k = $1"))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer delStmt.Close()
+	for _, op := range batch.ops {
+		if op.delete {
+			_, err = delStmt.Exec(op.key)
+		} else {
+			_, err = putStmt.Exec(op.key, op.value)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	batch.ops = nil
+	return tx.Commit()
+}
@@ -0,0 +1,70 @@
+// +build postgres
+
+package postgres
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/storage/storetest"
+)
+
+func testConfig(c map[string]interface{}) dvid.StoreConfig {
+	var config dvid.Config
+	config.SetAll(c)
+	return dvid.StoreConfig{Config: config, Engine: "postgres"}
+}
+
+func TestPostgresConfig(t *testing.T) {
+	connection, table, maxOpenConns, err := parseConfig(testConfig(map[string]interface{}{"connection": "dbname=dvid"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connection != "dbname=dvid" || table != DefaultTable || maxOpenConns != DefaultMaxOpenConns {
+		t.Errorf("expected default table and connections, got %q, %q, %d\n", connection, table, maxOpenConns)
+	}
+	_, table, maxOpenConns, err = parseConfig(testConfig(map[string]interface{}{"connection": "dbname=dvid", "table": "kv", "maxopenconns": int64(4)}))
+	if err != nil || table != "kv" || maxOpenConns != 4 {
+		t.Errorf("expected table %q with 4 connections, got %q, %d: %v\n", "kv", table, maxOpenConns, err)
+	}
+	bad := []map[string]interface{}{
+		{},
+		{"connection": 5},
+		{"connection": "dbname=dvid", "table": ""},
+		{"connection": "dbname=dvid", "maxopenconns": "4"},
+	}
+	for _, c := range bad {
+		if _, _, _, err := parseConfig(testConfig(c)); err == nil {
+			t.Errorf("expected error parsing configuration %v\n", c)
+		}
+	}
+
+	// Table names are quoted in statements.
+	db := &PostgresDB{table: `kv"; DROP TABLE kv; --`}
+	if q := db.query("SELECT v FROM %s"); q != `SELECT v FROM "kv""; DROP TABLE kv; --"` {
+		t.Errorf("expected quoted table name in statement, got %s\n", q)
+	}
+}
+
+// checkEmptyValues checks that empty values, which are stored as empty bytea, are found.
+func checkEmptyValues(store dvid.Store) error {
+	db := store.(*PostgresDB)
+	var ctx storage.MetadataContext
+	if err := db.Put(ctx, storage.TKey("empty"), nil); err != nil {
+		return fmt.Errorf("error on put of empty value: %v", err)
+	}
+	v, err := db.Get(ctx, storage.TKey("empty"))
+	if err != nil || v == nil || len(v) != 0 {
+		return fmt.Errorf("expected empty value, got %v: %v", v, err)
+	}
+	if found, err := db.Exists(ctx, storage.TKey("empty")); err != nil || !found {
+		return fmt.Errorf("expected empty value to exist: %v", err)
+	}
+	return db.Delete(ctx, storage.TKey("empty"))
+}
+
+func TestPostgresStore(t *testing.T) {
+	storetest.RunEngine(t, "postgres", []storetest.Check{{Name: "EmptyValues", Run: checkEmptyValues}})
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+)
+
+// RangeStream delivers the key-value pairs of a range query as they are read from a
+// store, so huge ranges can be processed without holding all key-value pairs in memory.
+// Receive from C until it is closed, then check Err.  If the stream isn't read to
+// completion, Close must be called so the store can stop reading.
+type RangeStream struct {
+	// C receives key-value pairs in ascending key order and is closed when the range
+	// is complete, an error occurs, or the stream is closed.
+	C <-chan *TKeyValue
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error // set before C is closed.
+}
+
+// errStreamClosed is returned to range queries feeding a stream closed by its consumer.
+var errStreamClosed = errors.New("range stream closed")
+
+// StreamRange returns a RangeStream fed by the given range query, which is run in a
+// goroutine.  The query should call send for each key-value pair in order and stop
+// reading if send returns false, which means the stream was closed by its consumer or
+// the request associated with the storage Context was cancelled.  Any error returned by
+// the query or the request's cancellation is available from the stream's Err.
+func StreamRange(ctx Context, query func(send func(*TKeyValue) bool) error) *RangeStream {
+	reqCtx := RequestContext(ctx)
+	ch := make(chan *TKeyValue, 100)
+	s := &RangeStream{C: ch, done: make(chan struct{})}
+	go func() {
+		err := query(func(tkv *TKeyValue) bool {
+			select {
+			case ch <- tkv:
+				return true
+			case <-s.done:
+				return false
+			case <-reqCtx.Done():
+				return false
+			}
+		})
+		if err == nil || err == errStreamClosed {
+			err = reqCtx.Err()
+		}
+		s.err = err
+		close(ch)
+	}()
+	return s
+}
+
+// Close stops the stream.  Key-value pairs already read from the store may still be
+// received from C.
+func (s *RangeStream) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// Err returns any error that terminated the stream.  It should only be called after C
+// has been closed.
+func (s *RangeStream) Err() error {
+	return s.err
+}
+
+// Collect returns all remaining key-value pairs in the stream.
+func (s *RangeStream) Collect() ([]*TKeyValue, error) {
+	values := []*TKeyValue{}
+	for tkv := range s.C {
+		values = append(values, tkv)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Process sends each remaining key-value pair in the stream to a chunk handler, adding
+// to the operation's wait group, if any, for each chunk.  If f returns an error, the
+// stream is closed and the error returned.
+func (s *RangeStream) Process(op *ChunkOp, f ChunkFunc) error {
+	for tkv := range s.C {
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		if err := f(&Chunk{op, tkv}); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// RangeStreamer stores can stream range queries directly from their iterators.
+type RangeStreamer interface {
+	// GetRangeStream returns a stream of the key-value pairs spanning (kStart, kEnd) in
+	// ascending key order.  If the keys are versioned, only the key-value pairs visible
+	// in the context's version are sent.
+	GetRangeStream(ctx Context, kStart, kEnd TKey) (*RangeStream, error)
+}
+
+// GetRangeStream returns a stream of the key-value pairs spanning (kStart, kEnd) in
+// ascending key order.  Stores that aren't a RangeStreamer are streamed via ProcessRange,
+// which most engines already implement without materializing the range.
+func GetRangeStream(db OrderedKeyValueGetter, ctx Context, kStart, kEnd TKey) (*RangeStream, error) {
+	if streamer, ok := db.(RangeStreamer); ok {
+		return streamer.GetRangeStream(ctx, kStart, kEnd)
+	}
+	return StreamRange(ctx, func(send func(*TKeyValue) bool) error {
+		return db.ProcessRange(ctx, kStart, kEnd, nil, func(c *Chunk) error {
+			if !send(c.TKeyValue) {
+				return errStreamClosed
+			}
+			return nil
+		})
+	}), nil
+}
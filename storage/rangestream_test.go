@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func testStream(ctx Context, n int, queryErr error, stopped chan<- int) *RangeStream {
+	return StreamRange(ctx, func(send func(*TKeyValue) bool) error {
+		for i := 0; i < n; i++ {
+			if !send(&TKeyValue{K: TKey{byte(i)}, V: []byte{byte(i)}}) {
+				stopped <- i
+				return errStreamClosed
+			}
+		}
+		return queryErr
+	})
+}
+
+func TestRangeStream(t *testing.T) {
+	values, err := testStream(nil, 250, nil, nil).Collect()
+	if err != nil {
+		t.Fatalf("error collecting stream: %v\n", err)
+	}
+	if len(values) != 250 {
+		t.Fatalf("expected 250 key-value pairs, got %d\n", len(values))
+	}
+	for i, tkv := range values {
+		if tkv.K[0] != byte(i) {
+			t.Fatalf("key-value pair %d has bad key %v\n", i, tkv.K)
+		}
+	}
+
+	if _, err = testStream(nil, 10, fmt.Errorf("bad read"), nil).Collect(); err == nil {
+		t.Fatalf("expected error from failed range query\n")
+	}
+
+	// A handler error should close the stream and stop the range query.
+	stopped := make(chan int, 1)
+	var processed int
+	err = testStream(nil, 1000000, nil, stopped).Process(nil, func(c *Chunk) error {
+		processed++
+		if processed == 5 {
+			return fmt.Errorf("stop")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "stop" {
+		t.Fatalf("expected handler error, got %v\n", err)
+	}
+	if i := <-stopped; i >= 1000000 {
+		t.Fatalf("range query wasn't stopped\n")
+	}
+
+	// Cancelling the request should stop the range query with the request's error.
+	reqCtx, cancel := context.WithCancel(context.Background())
+	ctx := &DataContext{}
+	ctx.SetContext(reqCtx)
+	stream := testStream(ctx, 1000000, nil, stopped)
+	<-stream.C
+	cancel()
+	for range stream.C {
+	}
+	if err := stream.Err(); err != context.Canceled {
+		t.Fatalf("expected cancelled stream, got %v\n", err)
+	}
+	if i := <-stopped; i >= 1000000 {
+		t.Fatalf("range query wasn't stopped after cancellation\n")
+	}
+}
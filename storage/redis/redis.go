@@ -0,0 +1,1070 @@
+// +build redis
+
+/*
+	Package redis implements a storage engine on Redis so frequently mutated data like
+	annotations can live in memory-backed storage.  It is typically assigned to specific
+	data instances via the [backend] section of the TOML configuration.
+
+	Each DVID key is split into an unversioned part and a version suffix (see
+	storage.SplitKey).  All versions of a key are held in a single Redis hash named by
+	the unversioned part, with the version suffix as the hash field, so a versioned Get
+	is one HGETALL.
+
+	By default the store is an unordered KeyValueDB.  If "ordered" is set in the store
+	configuration, unversioned keys are also added to a sorted set with equal scores.
+	Redis orders such members bytewise, so ZRANGEBYLEX provides the range queries needed
+	by OrderedKeyValueDB at the cost of an extra write per key.
+*/
+package redis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	api "github.com/gomodule/redigo/redis"
+)
+
+const (
+	// DefaultAddress is the Redis server used if none is configured.
+	DefaultAddress = "localhost:6379"
+
+	// DefaultMaxIdle is the maximum number of idle connections kept in the pool.
+	DefaultMaxIdle = 16
+
+	// ScanChunkSize is the number of sorted set members read per range request.
+	ScanChunkSize = 1000
+
+	// indexName is appended to the store prefix to name the sorted set of keys.  Since
+	// DVID keys always start with a 0 or 1 byte, it can't collide with a data hash.
+	indexName = "index"
+)
+
+// delScript deletes a hash field and, once the hash is empty, its sorted set member.
+var delScript = api.NewScript(2, `
+redis.call('HDEL', KEYS[1], ARGV[1])
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	redis.call('ZREM', KEYS[2], ARGV[2])
+end
+return 0
+`)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in redis: %v\n", err)
+	}
+	e := Engine{"redis", "Redis in-memory key-value store", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return false
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a Redis store.  Optional settings:
+// "address": host:port of the Redis server (default "localhost:6379")
+// "password": password for AUTH
+// "database": Redis logical database number
+// "prefix": string prepended to all Redis keys so several stores can share a server
+// "ordered": if true, maintain a sorted index so the store is an OrderedKeyValueDB
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	db, err := newRedisDB(config)
+	if err != nil {
+		return nil, false, err
+	}
+	if !db.ordered {
+		return db, false, nil
+	}
+	odb := &OrderedRedisDB{db}
+	metadataExists, err := odb.metadataExists()
+	if err != nil {
+		db.Close()
+		return nil, false, err
+	}
+	return odb, !metadataExists, nil
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets an ordered redis store as the default key-value backend and metadata
+// store, using the server given by the DVID_TEST_REDIS environment variable.  Each test
+// store uses a new key prefix.  If another engine is already set, it returns an error
+// since only one key-value backend should be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("redis can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	if backend.Metadata != "" {
+		return fmt.Errorf("redis can't be testable key-value.  Metadata already set to %s", backend.Metadata)
+	}
+	address := os.Getenv("DVID_TEST_REDIS")
+	if address == "" {
+		return fmt.Errorf("redis can't be testable key-value without an address in DVID_TEST_REDIS")
+	}
+	alias := storage.Alias("redis")
+	backend.Metadata = alias
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"address": address,
+		"prefix":  fmt.Sprintf("dvid-test-%x/", uuid.NewV4().Bytes()),
+		"ordered": true,
+		"testing": true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "redis"}
+	return nil
+}
+
+// Delete implements the TestableEngine interface by deleting all Redis keys with the
+// store's prefix.  A store without a prefix isn't deleted since it may share the server.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	db, err := newRedisDB(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if db.prefix == "" {
+		return fmt.Errorf("can't delete redis store without a prefix")
+	}
+	conn := db.pool.Get()
+	defer conn.Close()
+	var cursor int
+	for {
+		values, err := api.Values(conn.Do("SCAN", cursor, "MATCH", db.prefix+"*", "COUNT", ScanChunkSize))
+		if err != nil {
+			return err
+		}
+		if cursor, err = api.Int(values[0], nil); err != nil {
+			return err
+		}
+		keys, err := api.ByteSlices(values[1], nil)
+		if err != nil {
+			return err
+		}
+		if len(keys) != 0 {
+			if _, err := conn.Do("DEL", api.Args{}.AddFlat(keys)...); err != nil {
+				return err
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+type redisConfig struct {
+	address  string
+	password string
+	database int
+	prefix   string
+	ordered  bool
+}
+
+func parseConfig(config dvid.StoreConfig) (rc redisConfig, err error) {
+	c := config.GetAll()
+	rc.address = DefaultAddress
+	for _, name := range []string{"address", "password", "prefix"} {
+		v, found := c[name]
+		if !found {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			err = fmt.Errorf("%q setting must be a string (%v)", name, v)
+			return
+		}
+		switch name {
+		case "address":
+			rc.address = s
+		case "password":
+			rc.password = s
+		case "prefix":
+			rc.prefix = s
+		}
+	}
+	if v, found := c["database"]; found {
+		switch n := v.(type) {
+		case int64:
+			rc.database = int(n)
+		case int:
+			rc.database = n
+		default:
+			err = fmt.Errorf("%q setting must be an integer (%v)", "database", v)
+			return
+		}
+	}
+	if v, found := c["ordered"]; found {
+		var ok bool
+		if rc.ordered, ok = v.(bool); !ok {
+			err = fmt.Errorf("%q setting must be a bool (%v)", "ordered", v)
+			return
+		}
+	}
+	return
+}
+
+func newRedisDB(config dvid.StoreConfig) (*RedisDB, error) {
+	rc, err := parseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	pool := &api.Pool{
+		MaxIdle:     DefaultMaxIdle,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (api.Conn, error) {
+			return api.Dial("tcp", rc.address, api.DialPassword(rc.password), api.DialDatabase(rc.database))
+		},
+		TestOnBorrow: func(c api.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	conn := pool.Get()
+	_, err = conn.Do("PING")
+	conn.Close()
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("unable to connect to redis @ %s: %v", rc.address, err)
+	}
+	return &RedisDB{
+		redisConfig: rc,
+		config:      config,
+		pool:        pool,
+	}, nil
+}
+
+// RedisDB is an unordered key-value store backed by Redis hashes.
+type RedisDB struct {
+	redisConfig
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+
+	pool *api.Pool
+}
+
+func (db *RedisDB) String() string {
+	return fmt.Sprintf("redis @ %s/%d (prefix %q)", db.address, db.database, db.prefix)
+}
+
+// Close closes all pooled Redis connections.
+func (db *RedisDB) Close() {
+	if db != nil && db.pool != nil {
+		if err := db.pool.Close(); err != nil {
+			dvid.Errorf("Error closing %s: %v\n", db, err)
+		}
+		db.pool = nil
+	}
+}
+
+// Equal returns true if the redis store matches the given store configuration.
+func (db *RedisDB) Equal(config dvid.StoreConfig) bool {
+	rc, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.address == rc.address && db.database == rc.database && db.prefix == rc.prefix
+}
+
+// hashKey returns the Redis key of the hash holding all versions of an unversioned key.
+func (db *RedisDB) hashKey(unversioned []byte) []byte {
+	k := make([]byte, 0, len(db.prefix)+len(unversioned))
+	k = append(k, db.prefix...)
+	return append(k, unversioned...)
+}
+
+// indexKey returns the Redis key of the sorted set of unversioned keys.
+func (db *RedisDB) indexKey() []byte {
+	return []byte(db.prefix + indexName)
+}
+
+// sendPut queues the commands that store a full key-value pair.
+func (db *RedisDB) sendPut(conn api.Conn, k storage.Key, v []byte) error {
+	unversioned, field, err := storage.SplitKey(k)
+	if err != nil {
+		return err
+	}
+	if err := conn.Send("HSET", db.hashKey(unversioned), field, v); err != nil {
+		return err
+	}
+	if db.ordered {
+		return conn.Send("ZADD", db.indexKey(), 0, []byte(unversioned))
+	}
+	return nil
+}
+
+// sendDelete queues the commands that delete a full key.
+func (db *RedisDB) sendDelete(conn api.Conn, k storage.Key) error {
+	unversioned, field, err := storage.SplitKey(k)
+	if err != nil {
+		return err
+	}
+	if db.ordered {
+		return delScript.Send(conn, db.hashKey(unversioned), db.indexKey(), field, []byte(unversioned))
+	}
+	return conn.Send("HDEL", db.hashKey(unversioned), field)
+}
+
+// getVersions returns all stored key-value pairs sharing an unversioned key in ascending
+// key order.  If keysOnly is true, the values are not read.
+func (db *RedisDB) getVersions(conn api.Conn, unversioned []byte, keysOnly bool) ([]*storage.KeyValue, error) {
+	var kvs []*storage.KeyValue
+	if keysOnly {
+		fields, err := api.ByteSlices(conn.Do("HKEYS", db.hashKey(unversioned)))
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			kvs = append(kvs, &storage.KeyValue{K: storage.MergeKey(append([]byte{}, unversioned...), field)})
+		}
+	} else {
+		pairs, err := api.ByteSlices(conn.Do("HGETALL", db.hashKey(unversioned)))
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(pairs); i += 2 {
+			k := storage.MergeKey(append([]byte{}, unversioned...), pairs[i])
+			kvs = append(kvs, &storage.KeyValue{K: k, V: pairs[i+1]})
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].K, kvs[j].K) < 0 })
+	return kvs, nil
+}
+
+// ---- KeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *RedisDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call Get on nil RedisDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	conn := db.pool.Get()
+	defer conn.Close()
+
+	unversioned, field, err := ctx.SplitKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		values, err := db.getVersions(conn, unversioned, false)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			storage.StoreValueBytesRead <- len(kv.V)
+			return kv.V, err
+		}
+		return nil, err
+	}
+	v, err := api.Bytes(conn.Do("HGET", db.hashKey(unversioned), field))
+	if err == api.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	storage.StoreValueBytesRead <- len(v)
+	return v, nil
+}
+
+// Exists returns true if a value is stored for the key without transferring the value.
+func (db *RedisDB) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists on nil RedisDB")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	conn := db.pool.Get()
+	defer conn.Close()
+
+	unversioned, field, err := ctx.SplitKey(tk)
+	if err != nil {
+		return false, err
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		versions, err := db.getVersions(conn, unversioned, true)
+		if err != nil {
+			return false, err
+		}
+		kv, err := vctx.VersionedKeyValue(versions)
+		return kv != nil, err
+	}
+	return api.Bool(conn.Do("HEXISTS", db.hashKey(unversioned), field))
+}
+
+// GetBatch returns the values of the given keys, with nil for keys not found.  Requests
+// for all keys are pipelined so the batch needs only one network round trip.
+func (db *RedisDB) GetBatch(ctx storage.Context, tks []storage.TKey) ([][]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetBatch on nil RedisDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetBatch()")
+	}
+	var vctx storage.VersionedCtx
+	if ctx.Versioned() {
+		var ok bool
+		if vctx, ok = ctx.(storage.VersionedCtx); !ok {
+			return nil, fmt.Errorf("Bad GetBatch(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+	}
+	conn := db.pool.Get()
+	defer conn.Close()
+
+	unversioned := make([][]byte, len(tks))
+	for i, tk := range tks {
+		unv, field, err := ctx.SplitKey(tk)
+		if err != nil {
+			return nil, err
+		}
+		unversioned[i] = unv
+		if vctx != nil {
+			err = conn.Send("HGETALL", db.hashKey(unv))
+		} else {
+			err = conn.Send("HGET", db.hashKey(unv), field)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(tks))
+	for i := range tks {
+		if vctx == nil {
+			v, err := api.Bytes(conn.Receive())
+			if err == api.ErrNil {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			storage.StoreValueBytesRead <- len(v)
+			values[i] = v
+			continue
+		}
+		pairs, err := api.ByteSlices(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		kvs := make([]*storage.KeyValue, 0, len(pairs)/2)
+		for j := 0; j+1 < len(pairs); j += 2 {
+			k := storage.MergeKey(append([]byte{}, unversioned[i]...), pairs[j])
+			kvs = append(kvs, &storage.KeyValue{K: k, V: pairs[j+1]})
+		}
+		sort.Slice(kvs, func(a, b int) bool { return bytes.Compare(kvs[a].K, kvs[b].K) < 0 })
+		kv, err := vctx.VersionedKeyValue(kvs)
+		if err != nil {
+			return nil, err
+		}
+		if kv != nil {
+			storage.StoreValueBytesRead <- len(kv.V)
+			values[i] = kv.V
+		}
+	}
+	return values, nil
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *RedisDB) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil RedisDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Put(tk, v)
+	return batch.Commit()
+}
+
+// Delete removes a value with given key.
+func (db *RedisDB) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil RedisDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	return batch.Commit()
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *RedisDB) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil RedisDB")
+	}
+	conn := db.pool.Get()
+	defer conn.Close()
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	if err := db.sendPut(conn, k, v); err != nil {
+		conn.Do("DISCARD")
+		return err
+	}
+	if _, err := conn.Do("EXEC"); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *RedisDB) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil RedisDB")
+	}
+	conn := db.pool.Get()
+	defer conn.Close()
+	if err := db.sendDelete(conn, k); err != nil {
+		return err
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	_, err := conn.Receive()
+	return err
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	key    storage.Key
+	value  []byte
+	delete bool
+}
+
+type goBatch struct {
+	db   *RedisDB
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	ops  []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes.  The batch is applied
+// atomically via a Redis MULTI/EXEC transaction.
+func (db *RedisDB) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil RedisDB\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	var vctx storage.VersionedCtx
+	if ctx.Versioned() {
+		vctx, _ = ctx.(storage.VersionedCtx)
+	}
+	return &goBatch{db: db, ctx: ctx, vctx: vctx}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, value: dvid.EmptyValue()})
+	}
+	batch.ops = append(batch.ops, batchOp{key: key, delete: true})
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, delete: true})
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.ops = append(batch.ops, batchOp{key: key, value: v})
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	if len(batch.ops) == 0 {
+		return nil
+	}
+	db := batch.db
+	conn := db.pool.Get()
+	defer conn.Close()
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	for _, op := range batch.ops {
+		var err error
+		if op.delete {
+			err = db.sendDelete(conn, op.key)
+		} else {
+			err = db.sendPut(conn, op.key, op.value)
+		}
+		if err != nil {
+			conn.Do("DISCARD")
+			return err
+		}
+	}
+	if _, err := conn.Do("EXEC"); err != nil {
+		dvid.Criticalf("Error on batch commit of %d operations to %s: %v\n", len(batch.ops), db, err)
+		return err
+	}
+	batch.ops = nil
+	return nil
+}
+
+// ---- OrderedRedisDB adds range queries via a sorted set index ------
+
+// OrderedRedisDB is a RedisDB with a sorted set index of keys that satisfies the
+// OrderedKeyValueDB interface.
+type OrderedRedisDB struct {
+	*RedisDB
+}
+
+func (db *OrderedRedisDB) metadataExists() (bool, error) {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	conn := db.pool.Get()
+	defer conn.Close()
+	n, err := api.Int(conn.Do("ZLEXCOUNT", db.indexKey(), lexBound(keyBeg, true), lexBound(keyEnd, false)))
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		dvid.Infof("No metadata found for %s...\n", db)
+	}
+	return n != 0, nil
+}
+
+// lexBound returns a ZRANGEBYLEX bound for a key.
+func lexBound(k []byte, inclusive bool) []byte {
+	bound := make([]byte, 0, len(k)+1)
+	if inclusive {
+		bound = append(bound, '[')
+	} else {
+		bound = append(bound, '(')
+	}
+	return append(bound, k...)
+}
+
+// scanRange calls f on each stored key-value pair whose unversioned key is in
+// [minBound, maxBound], given as ZRANGEBYLEX bounds, in ascending key order.  Hashes
+// for each chunk of index members are fetched in a single pipeline.  If f returns
+// false, the scan stops.
+func (db *OrderedRedisDB) scanRange(minBound, maxBound []byte, keysOnly bool, f func(*storage.KeyValue) bool) error {
+	conn := db.pool.Get()
+	defer conn.Close()
+	for {
+		members, err := api.ByteSlices(conn.Do("ZRANGEBYLEX", db.indexKey(), minBound, maxBound, "LIMIT", 0, ScanChunkSize))
+		if err != nil {
+			return err
+		}
+		for _, unversioned := range members {
+			kvs, err := db.getVersions(conn, unversioned, keysOnly)
+			if err != nil {
+				return err
+			}
+			for _, kv := range kvs {
+				storage.StoreKeyBytesRead <- len(kv.K)
+				storage.StoreValueBytesRead <- len(kv.V)
+				if !f(kv) {
+					return nil
+				}
+			}
+		}
+		if len(members) < ScanChunkSize {
+			return nil
+		}
+		minBound = lexBound(members[len(members)-1], false)
+	}
+}
+
+// rangeQuery calls f on each key-value pair visible in the context's version with
+// type-specific keys in [kStart, kEnd].
+func (db *OrderedRedisDB) rangeQuery(ctx storage.Context, kStart, kEnd storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	unvStart, _, err := ctx.SplitKey(kStart)
+	if err != nil {
+		return err
+	}
+	unvEnd, _, err := ctx.SplitKey(kEnd)
+	if err != nil {
+		return err
+	}
+	minBound, maxBound := lexBound(unvStart, true), lexBound(unvEnd, true)
+
+	var ferr error
+	if !ctx.Versioned() {
+		begKey, endKey := ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd)
+		err := db.scanRange(minBound, maxBound, keysOnly, func(kv *storage.KeyValue) bool {
+			if bytes.Compare(kv.K, begKey) < 0 || bytes.Compare(kv.K, endKey) > 0 {
+				return true
+			}
+			ferr = f(kv)
+			return ferr == nil
+		})
+		if err != nil {
+			return err
+		}
+		return ferr
+	}
+
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return fmt.Errorf("context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	var curUnversioned storage.Key
+	values := []*storage.KeyValue{}
+	sendKV := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil || kv == nil {
+			return err
+		}
+		return f(kv)
+	}
+	err = db.scanRange(minBound, maxBound, keysOnly, func(kv *storage.KeyValue) bool {
+		var unversioned storage.Key
+		if unversioned, _, ferr = storage.SplitKey(kv.K); ferr != nil {
+			return false
+		}
+		// Did we pass all versions for last key read?
+		if !bytes.Equal(unversioned, curUnversioned) {
+			if ferr = sendKV(); ferr != nil {
+				return false
+			}
+			curUnversioned = unversioned
+			values = []*storage.KeyValue{}
+		}
+		values = append(values, kv)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if ferr != nil {
+		return ferr
+	}
+	return sendKV()
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *OrderedRedisDB) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil OrderedRedisDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	keys := []storage.TKey{}
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, tk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *OrderedRedisDB) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, ch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil OrderedRedisDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		ch <- kv.K
+		return nil
+	})
+	ch <- nil
+	return err
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *OrderedRedisDB) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil OrderedRedisDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	values := []*storage.TKeyValue{}
+	err := db.rangeQuery(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: kv.V})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *OrderedRedisDB) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil OrderedRedisDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	f = storage.CancelableChunkFunc(ctx, f)
+	return db.rangeQuery(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tkv := storage.TKeyValue{K: tk, V: kv.V}
+		return f(&storage.Chunk{ChunkOp: op, TKeyValue: &tkv})
+	})
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (db *OrderedRedisDB) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil OrderedRedisDB")
+	}
+	// An unversioned key always sorts at or before the full keys built from it, so
+	// start the index scan at kStart's unversioned key and filter full keys.
+	minKey := kStart
+	if len(kStart) > dvid.VersionIDSize+dvid.ClientIDSize+1 {
+		if unversioned, _, err := storage.SplitKey(kStart); err == nil {
+			minKey = unversioned
+		}
+	}
+	var cancelled bool
+	err := db.scanRange(lexBound(minKey, true), lexBound(kEnd, true), keysOnly, func(kv *storage.KeyValue) bool {
+		if bytes.Compare(kv.K, kStart) < 0 || bytes.Compare(kv.K, kEnd) > 0 {
+			return true
+		}
+		select {
+		case out <- kv:
+			return true
+		case <-ctx.Done():
+			cancelled = true
+			return false
+		}
+	})
+	if err != nil || cancelled {
+		return err
+	}
+	out <- nil
+	return nil
+}
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+func (db *OrderedRedisDB) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange on nil OrderedRedisDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	return batch.Commit()
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.  For versioned
+// contexts, tombstones are written for the deleted keys.
+func (db *OrderedRedisDB) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil OrderedRedisDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+	var tkeys []storage.TKey
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		tkeys = append(tkeys, tk)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	const BATCH_SIZE = 10000
+	for start := 0; start < len(tkeys); start += BATCH_SIZE {
+		end := start + BATCH_SIZE
+		if end > len(tkeys) {
+			end = len(tkeys)
+		}
+		batch := db.NewBatch(ctx)
+		for _, tk := range tkeys[start:end] {
+			batch.Delete(tk)
+		}
+		if err := batch.Commit(); err != nil {
+			return fmt.Errorf("Error on batch commit of DeleteRange at key-value pair %d: %v", start, err)
+		}
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", len(tkeys), ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+func (db *OrderedRedisDB) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil OrderedRedisDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	var deleteVersion dvid.VersionID
+	if !allVersions {
+		vctx, versioned := ctx.(storage.VersionedCtx)
+		if !versioned {
+			return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+		}
+		deleteVersion = vctx.VersionID()
+	}
+	minKey, maxKey := ctx.KeyRange()
+
+	const BATCH_SIZE = 10000
+	var keys []storage.Key
+	var numKV int
+	var delErr error
+	deleteKeys := func() error {
+		conn := db.pool.Get()
+		defer conn.Close()
+		if err := conn.Send("MULTI"); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := db.sendDelete(conn, k); err != nil {
+				conn.Do("DISCARD")
+				return err
+			}
+		}
+		_, err := conn.Do("EXEC")
+		numKV += len(keys)
+		keys = nil
+		return err
+	}
+	err := db.scanRange(lexBound(minKey, true), lexBound(maxKey, false), true, func(kv *storage.KeyValue) bool {
+		if !allVersions {
+			_, v, _, err := storage.DataKeyToLocalIDs(kv.K)
+			if err != nil || v != deleteVersion {
+				return true
+			}
+		}
+		keys = append(keys, kv.K)
+		if len(keys) == BATCH_SIZE {
+			delErr = deleteKeys()
+		}
+		return delErr == nil
+	})
+	if err == nil {
+		err = delErr
+	}
+	if err == nil && len(keys) != 0 {
+		err = deleteKeys()
+	}
+	if err != nil {
+		return fmt.Errorf("Error on DELETE ALL for %s: %v", ctx, err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", numKV, ctx)
+	return nil
+}
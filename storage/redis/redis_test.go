@@ -0,0 +1,78 @@
+// +build redis
+
+package redis
+
+import (
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/storage/storetest"
+)
+
+func testConfig(c map[string]interface{}) dvid.StoreConfig {
+	var config dvid.Config
+	config.SetAll(c)
+	return dvid.StoreConfig{Config: config, Engine: "redis"}
+}
+
+func TestRedisConfig(t *testing.T) {
+	rc, err := parseConfig(testConfig(map[string]interface{}{}))
+	if err != nil || rc.address != DefaultAddress || rc.ordered {
+		t.Errorf("expected unordered store at default address, got %+v: %v\n", rc, err)
+	}
+	rc, err = parseConfig(testConfig(map[string]interface{}{"address": "cache:6379", "database": int64(2), "prefix": "dvid/", "ordered": true}))
+	if err != nil || rc.address != "cache:6379" || rc.database != 2 || rc.prefix != "dvid/" || !rc.ordered {
+		t.Errorf("bad parsed configuration %+v: %v\n", rc, err)
+	}
+	for _, c := range []map[string]interface{}{{"address": 6379}, {"database": "2"}, {"ordered": "yes"}} {
+		if _, err := parseConfig(testConfig(c)); err == nil {
+			t.Errorf("expected error parsing configuration %v\n", c)
+		}
+	}
+}
+
+func TestRedisKeys(t *testing.T) {
+	db := &RedisDB{redisConfig: redisConfig{prefix: "dvid/"}}
+	if k := string(db.hashKey([]byte("abc"))); k != "dvid/abc" {
+		t.Errorf("expected prefixed hash key, got %q\n", k)
+	}
+	if k := string(db.indexKey()); k != "dvid/"+indexName {
+		t.Errorf("expected prefixed index key, got %q\n", k)
+	}
+	if b := string(lexBound([]byte("k"), true)); b != "[k" {
+		t.Errorf("expected inclusive bound, got %q\n", b)
+	}
+	if b := string(lexBound([]byte("k"), false)); b != "(k" {
+		t.Errorf("expected exclusive bound, got %q\n", b)
+	}
+}
+
+// Unordered stores have no sorted set index, so only the unordered checks apply.
+func TestRedisUnordered(t *testing.T) {
+	var e Engine
+	backend := new(storage.Backend)
+	if err := e.AddTestConfig(backend); err != nil {
+		t.Skipf("no test store for redis: %v\n", err)
+	}
+	config := backend.Stores[backend.DefaultKVDB]
+	config.Set("ordered", false)
+	store, _, err := e.NewStore(config)
+	if err != nil {
+		t.Fatalf("unable to open unordered test store: %v\n", err)
+	}
+	defer func() {
+		store.Close()
+		if err := e.Delete(config); err != nil {
+			t.Errorf("unable to delete test store: %v\n", err)
+		}
+	}()
+	if _, ok := store.(storage.OrderedKeyValueDB); ok {
+		t.Fatalf("expected unordered store not to support range queries\n")
+	}
+	for _, check := range storetest.Conformance {
+		if err := check.Run(store); err != nil {
+			t.Errorf("%s: %v\n", check.Name, err)
+		}
+	}
+}
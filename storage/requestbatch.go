@@ -0,0 +1,193 @@
+/*
+	This file supports request batches, which collect the puts and deletes made for a
+	request so they can be written together once the request succeeds, e.g., all the
+	keyvalue writes of an atomic /api/batch request.  Writes through a data context whose
+	request context.Context holds a RequestBatch are queued if the store can batch, and each
+	store and data context, i.e., data instance and version, gets one storage Batch when
+	the request batch is committed.
+
+	Queued writes aren't visible to reads until committed.  Writes that can't be queued,
+	e.g., range deletes, TTL puts, patches by transactional stores, and writes to stores
+	that can't batch, are refused while the request batch is open, and the first refusal is
+	kept so the request can be rejected.  Writes made after the request batch is committed
+	or discarded, e.g., by background processing started by a request, are made directly.
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type requestBatchKey struct{}
+
+// WithRequestBatch returns a copy of the context.Context that queues writes of storage
+// contexts using it in the given request batch.
+func WithRequestBatch(c context.Context, rb *RequestBatch) context.Context {
+	return context.WithValue(c, requestBatchKey{}, rb)
+}
+
+// RequestBatch collects writes of a request, grouped by store and data context.  It's
+// safe for concurrent use.
+type RequestBatch struct {
+	sync.Mutex
+	groups []*batchGroup
+	index  map[batchGroupKey]*batchGroup
+	done   bool
+
+	unqueued error // first refused write that couldn't be queued.
+
+	committed []func() // called after the queued writes are made, e.g., to invalidate caches.
+}
+
+type batchGroupKey struct {
+	batcher KeyValueBatcher
+	ctx     string
+}
+
+// batchGroup is the queued writes for one store and data context.
+type batchGroup struct {
+	batcher KeyValueBatcher
+	ctx     Context
+	ops     []batchOp
+}
+
+type batchOp struct {
+	tk     TKey
+	v      []byte
+	delete bool
+}
+
+// NewRequestBatch returns an empty request batch.
+func NewRequestBatch() *RequestBatch {
+	return &RequestBatch{index: make(map[batchGroupKey]*batchGroup)}
+}
+
+// requestBatch returns the request batch of a storage context or nil if there is none.
+func requestBatch(ctx Context) *RequestBatch {
+	reqCtx, ok := ctx.(RequestCtx)
+	if !ok {
+		return nil
+	}
+	c := reqCtx.GetContext()
+	if c == nil {
+		return nil
+	}
+	rb, _ := c.Value(requestBatchKey{}).(*RequestBatch)
+	return rb
+}
+
+// queue adds writes to the request batch and returns false if it has been committed or
+// discarded, in which case the writes should be made directly.
+func (rb *RequestBatch) queue(batcher KeyValueBatcher, ctx Context, ops ...batchOp) bool {
+	rb.Lock()
+	defer rb.Unlock()
+	if rb.done {
+		return false
+	}
+	key := batchGroupKey{batcher, ctx.String()}
+	g, found := rb.index[key]
+	if !found {
+		g = &batchGroup{batcher: batcher, ctx: ctx}
+		rb.index[key] = g
+		rb.groups = append(rb.groups, g)
+	}
+	g.ops = append(g.ops, ops...)
+	return true
+}
+
+// refuseUnqueued returns an error, which is kept by the request batch, if the context's
+// request has an open request batch, since the given write to the store can't be queued.
+func refuseUnqueued(store interface{}, ctx Context, write string) error {
+	rb := requestBatch(ctx)
+	if rb == nil {
+		return nil
+	}
+	rb.Lock()
+	defer rb.Unlock()
+	if rb.done {
+		return nil
+	}
+	err := fmt.Errorf("%s to %s in %s can't be queued in a request batch", write, store, ctx)
+	if rb.unqueued == nil {
+		rb.unqueued = err
+	}
+	return err
+}
+
+// Unqueued returns the first write refused because it couldn't be queued, or nil if all
+// writes have been queued.
+func (rb *RequestBatch) Unqueued() error {
+	rb.Lock()
+	defer rb.Unlock()
+	return rb.unqueued
+}
+
+// afterCommit adds a function called once the queued writes are made and returns false if
+// the request batch has been committed or discarded.
+func (rb *RequestBatch) afterCommit(f func()) bool {
+	rb.Lock()
+	defer rb.Unlock()
+	if rb.done {
+		return false
+	}
+	rb.committed = append(rb.committed, f)
+	return true
+}
+
+// Writes returns the number of queued puts and deletes.
+func (rb *RequestBatch) Writes() int {
+	rb.Lock()
+	defer rb.Unlock()
+	var n int
+	for _, g := range rb.groups {
+		n += len(g.ops)
+	}
+	return n
+}
+
+// Commit writes the queued puts and deletes with one storage Batch for each store and
+// data context in the order they were first written.  If a batch fails, the later ones
+// aren't written.  Writes made after Commit aren't queued.
+func (rb *RequestBatch) Commit() error {
+	rb.Lock()
+	defer rb.Unlock()
+	if rb.done {
+		return fmt.Errorf("request batch already committed or discarded")
+	}
+	rb.done = true
+	defer func() {
+		for _, f := range rb.committed {
+			f()
+		}
+	}()
+	for i, g := range rb.groups {
+		if err := CheckWrite(g.ctx); err != nil {
+			return fmt.Errorf("batch %d of %d for %s refused: %v", i+1, len(rb.groups), g.ctx, err)
+		}
+		batch := g.batcher.NewBatch(g.ctx)
+		for _, op := range g.ops {
+			if op.delete {
+				batch.Delete(op.tk)
+			} else {
+				batch.Put(op.tk, op.v)
+			}
+		}
+		if err := batch.Commit(); err != nil {
+			return fmt.Errorf("batch %d of %d for %s failed: %v", i+1, len(rb.groups), g.ctx, err)
+		}
+	}
+	return nil
+}
+
+// Discard drops the queued puts and deletes.  Writes made after Discard aren't queued.
+func (rb *RequestBatch) Discard() {
+	rb.Lock()
+	rb.done = true
+	rb.groups = nil
+	rb.index = nil
+	rb.committed = nil
+	rb.Unlock()
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+// testBatchStore adds batches that count their commits to testKVStore.
+type testBatchStore struct {
+	*testKVStore
+	commits int
+}
+
+func (db *testBatchStore) NewBatch(ctx Context) Batch {
+	return &testBatch{db: db, ctx: ctx}
+}
+
+type testBatch struct {
+	db  *testBatchStore
+	ctx Context
+	ops []batchOp
+}
+
+func (b *testBatch) Put(tk TKey, v []byte) {
+	b.ops = append(b.ops, batchOp{tk: tk, v: v})
+}
+
+func (b *testBatch) Delete(tk TKey) {
+	b.ops = append(b.ops, batchOp{tk: tk, delete: true})
+}
+
+func (b *testBatch) Commit() error {
+	b.db.commits++
+	for _, op := range b.ops {
+		if op.delete {
+			b.db.Delete(b.ctx, op.tk)
+		} else {
+			b.db.Put(b.ctx, op.tk, op.v)
+		}
+	}
+	return nil
+}
+
+func TestRequestBatch(t *testing.T) {
+	mem := &testBatchStore{testKVStore: &testKVStore{kv: make(map[string][]byte)}}
+	store, err := wrapWriteGuard(mem)
+	if err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	db := store.(OrderedKeyValueDB)
+	if err := db.Put(NewDataContext(&testData{instanceID: 1}, 1), TKey("c"), []byte("c0")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+
+	rb := NewRequestBatch()
+	ctx := NewDataContext(&testData{instanceID: 1}, 1)
+	ctx.SetContext(WithRequestBatch(context.Background(), rb))
+	if err := db.Put(ctx, TKey("a"), []byte("a0")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	if err := db.PutRange(ctx, []TKeyValue{{K: TKey("b"), V: []byte("b0")}}); err != nil {
+		t.Fatalf("error on put range: %v\n", err)
+	}
+	if err := db.Delete(ctx, TKey("c")); err != nil {
+		t.Fatalf("error on delete: %v\n", err)
+	}
+	if rb.Writes() != 3 || len(mem.kv) != 1 {
+		t.Fatalf("expected 3 queued writes and none written, got %d queued and %d key-value pairs\n", rb.Writes(), len(mem.kv))
+	}
+	checkValue(t, db, ctx, "c", []byte("c0"))
+	if err := rb.Commit(); err != nil {
+		t.Fatalf("error committing request batch: %v\n", err)
+	}
+	if mem.commits != 1 {
+		t.Errorf("expected writes of one data context in one batch, got %d commits\n", mem.commits)
+	}
+	checkValue(t, db, ctx, "a", []byte("a0"))
+	checkValue(t, db, ctx, "b", []byte("b0"))
+	checkValue(t, db, ctx, "c", nil)
+	if err := rb.Commit(); err == nil {
+		t.Errorf("expected error committing request batch twice\n")
+	}
+
+	// Writes after the commit aren't queued.
+	if err := db.Put(ctx, TKey("d"), []byte("d0")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	checkValue(t, db, ctx, "d", []byte("d0"))
+
+	// Discarded writes are never made.
+	rb = NewRequestBatch()
+	ctx = NewDataContext(&testData{instanceID: 2}, 1)
+	ctx.SetContext(WithRequestBatch(context.Background(), rb))
+	if err := db.Put(ctx, TKey("a"), []byte("a1")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	rb.Discard()
+	checkValue(t, db, ctx, "a", nil)
+	if mem.commits != 1 {
+		t.Errorf("expected no commit of discarded batch, got %d commits\n", mem.commits)
+	}
+}
+
+func TestRequestBatchUnqueued(t *testing.T) {
+	mem := &testKVStore{kv: make(map[string][]byte)}
+	store, err := wrapWriteGuard(mem)
+	if err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	db := store.(OrderedKeyValueDB)
+
+	rb := NewRequestBatch()
+	ctx := NewDataContext(&testData{instanceID: 1}, 1)
+	ctx.SetContext(WithRequestBatch(context.Background(), rb))
+	if err := db.Put(ctx, TKey("a"), []byte("a0")); err == nil {
+		t.Fatalf("expected put to store that can't batch to be refused\n")
+	}
+	if rb.Unqueued() == nil {
+		t.Fatalf("expected request batch to keep refused write\n")
+	}
+	if len(mem.kv) != 0 {
+		t.Fatalf("expected no writes, got %d key-value pairs\n", len(mem.kv))
+	}
+
+	// Batches of stores that can batch are queued.
+	bmem := &testBatchStore{testKVStore: &testKVStore{kv: make(map[string][]byte)}}
+	if store, err = wrapWriteGuard(bmem); err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	rb = NewRequestBatch()
+	ctx.SetContext(WithRequestBatch(context.Background(), rb))
+	batch := store.(KeyValueBatcher).NewBatch(ctx)
+	batch.Put(TKey("b"), []byte("b0"))
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("error committing batch: %v\n", err)
+	}
+	if rb.Writes() != 1 || len(bmem.kv) != 0 {
+		t.Fatalf("expected batch put to be queued, got %d queued and %d key-value pairs\n", rb.Writes(), len(bmem.kv))
+	}
+	if err := store.(OrderedKeyValueDB).DeleteRange(ctx, TKey("a"), TKey("z")); err == nil {
+		t.Errorf("expected range delete in open request batch to be refused\n")
+	}
+}
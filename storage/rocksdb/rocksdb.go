@@ -0,0 +1,1371 @@
+// +build rocksdb
+
+/*
+	Package rocksdb implements a storage engine using Facebook's RocksDB.  Data keys can be
+	routed to separate column families by their type-specific key class, so large block
+	data doesn't share compactions with small, frequently mutated metadata-like keys.
+
+	Column families are specified in the store configuration as "<name>:<min class>-<max class>"
+	strings.  Any key not covered by a family, including all metadata keys, is stored in the
+	"default" column family:
+
+		[store.rocks]
+		engine = "rocksdb"
+		path = "/data/dbs/rocksdb"
+		families = ["blocks:200-255"]
+		blockcachesize = 1024        # MB
+		bloombits = 10
+		compactionstyle = "level"    # or "universal" or "fifo"
+*/
+package rocksdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	humanize "github.com/janelia-flyem/go/go-humanize"
+	"github.com/tecbot/gorocksdb"
+)
+
+const (
+	// Default size of LRU cache that caches frequently used uncompressed blocks.
+	DefaultBlockCacheSize = 536870912
+
+	// Default # bits for Bloom Filter.
+	DefaultBloomBits = 10
+
+	// Default amount of data to build up in memory before converting to a sorted on-disk file.
+	DefaultWriteBufferSize = 62914560
+
+	// Number of open files that can be used by the datastore.
+	DefaultMaxOpenFiles = 1024
+
+	// DefaultCompactionStyle is the compaction style used if none is specified.
+	DefaultCompactionStyle = "level"
+
+	// DefaultFamily is the name of the column family holding metadata and any data keys
+	// without an assigned family.
+	DefaultFamily = "default"
+
+	// DefaultSync determines whether writes are synced to disk before returning.
+	DefaultSync = false
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in rocksdb: %v\n", err)
+	}
+	e := Engine{"rocksdb", "RocksDB with column families per key class", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return false
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a rocksdb store. The passed Config must contain "path" string.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newRocksDB(config)
+}
+
+func parseConfig(config dvid.StoreConfig) (path string, testing bool, err error) {
+	c := config.GetAll()
+
+	v, found := c["path"]
+	if !found {
+		err = fmt.Errorf("%q must be specified for rocksdb configuration", "path")
+		return
+	}
+	var ok bool
+	path, ok = v.(string)
+	if !ok {
+		err = fmt.Errorf("%q setting must be a string (%v)", "path", v)
+		return
+	}
+	v, found = c["testing"]
+	if found {
+		testing, ok = v.(bool)
+		if !ok {
+			err = fmt.Errorf("%q setting must be a bool (%v)", "testing", v)
+			return
+		}
+	}
+	if testing {
+		path = filepath.Join(os.TempDir(), path)
+	}
+	return
+}
+
+// intSetting returns an integer setting that may have been decoded from TOML or JSON.
+func intSetting(c map[string]interface{}, key string, defaultValue int) (int, error) {
+	v, found := c[key]
+	if !found {
+		return defaultValue, nil
+	}
+	switch i := v.(type) {
+	case int:
+		return i, nil
+	case int64:
+		return int(i), nil
+	case float64:
+		return int(i), nil
+	case string:
+		return strconv.Atoi(i)
+	default:
+		return 0, fmt.Errorf("%q setting must be an integer (%v)", key, v)
+	}
+}
+
+// family is a column family and the inclusive range of TKeyClass assigned to it.
+type family struct {
+	name     string
+	min, max storage.TKeyClass
+}
+
+// parseFamilies parses "<name>:<min class>-<max class>" specifications.
+func parseFamilies(c map[string]interface{}) ([]family, error) {
+	v, found := c["families"]
+	if !found {
+		return nil, nil
+	}
+	specs, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%q setting must be a list of strings (%v)", "families", v)
+	}
+	var families []family
+	for _, s := range specs {
+		spec, ok := s.(string)
+		if !ok {
+			return nil, fmt.Errorf("family specification must be a string (%v)", s)
+		}
+		parts := strings.Split(spec, ":")
+		if len(parts) != 2 || parts[0] == "" || parts[0] == DefaultFamily {
+			return nil, fmt.Errorf("bad family specification %q, must be <name>:<min class>-<max class>", spec)
+		}
+		bounds := strings.Split(parts[1], "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("bad class range in family specification %q", spec)
+		}
+		min, err := strconv.ParseUint(bounds[0], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad min class in family specification %q: %v", spec, err)
+		}
+		max, err := strconv.ParseUint(bounds[1], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad max class in family specification %q: %v", spec, err)
+		}
+		if min > max {
+			return nil, fmt.Errorf("min class greater than max class in family specification %q", spec)
+		}
+		f := family{parts[0], storage.TKeyClass(min), storage.TKeyClass(max)}
+		for _, other := range families {
+			if other.name == f.name {
+				return nil, fmt.Errorf("family %q specified more than once", f.name)
+			}
+			if f.min <= other.max && other.min <= f.max {
+				return nil, fmt.Errorf("family %q has class range overlapping family %q", f.name, other.name)
+			}
+		}
+		families = append(families, f)
+	}
+	return families, nil
+}
+
+func getOptions(c map[string]interface{}) (*gorocksdb.Options, error) {
+	opts := gorocksdb.NewDefaultOptions()
+	opts.SetCreateIfMissing(true)
+	opts.SetCreateIfMissingColumnFamilies(true)
+
+	cacheSize, err := intSetting(c, "blockcachesize", 0)
+	if err != nil {
+		return nil, err
+	}
+	if cacheSize == 0 {
+		cacheSize = DefaultBlockCacheSize
+	} else {
+		cacheSize *= dvid.Mega
+	}
+	dvid.Infof("rocksdb block cache size: %s\n", humanize.Bytes(uint64(cacheSize)))
+
+	bloomBits, err := intSetting(c, "bloombits", DefaultBloomBits)
+	if err != nil {
+		return nil, err
+	}
+	bbto := gorocksdb.NewDefaultBlockBasedTableOptions()
+	bbto.SetBlockCache(gorocksdb.NewLRUCache(cacheSize))
+	bbto.SetFilterPolicy(gorocksdb.NewBloomFilter(bloomBits))
+	opts.SetBlockBasedTableFactory(bbto)
+
+	writeBufferSize, err := intSetting(c, "writebuffersize", 0)
+	if err != nil {
+		return nil, err
+	}
+	if writeBufferSize == 0 {
+		writeBufferSize = DefaultWriteBufferSize
+	} else {
+		writeBufferSize *= dvid.Mega
+	}
+	opts.SetWriteBufferSize(writeBufferSize)
+
+	maxOpenFiles, err := intSetting(c, "maxopenfiles", DefaultMaxOpenFiles)
+	if err != nil {
+		return nil, err
+	}
+	opts.SetMaxOpenFiles(maxOpenFiles)
+
+	style := DefaultCompactionStyle
+	if v, found := c["compactionstyle"]; found {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%q setting must be a string (%v)", "compactionstyle", v)
+		}
+		style = strings.ToLower(s)
+	}
+	switch style {
+	case "level":
+		opts.SetCompactionStyle(gorocksdb.LevelCompactionStyle)
+	case "universal":
+		opts.SetCompactionStyle(gorocksdb.UniversalCompactionStyle)
+	case "fifo":
+		opts.SetCompactionStyle(gorocksdb.FIFOCompactionStyle)
+	default:
+		return nil, fmt.Errorf("unknown rocksdb compaction style %q: use level, universal, or fifo", style)
+	}
+
+	// As with basholeveldb, compression is selectively applied on the DVID side.
+	opts.SetCompression(gorocksdb.NoCompression)
+	return opts, nil
+}
+
+// newRocksDB returns a rocksdb backend, creating the database
+// at the path if it doesn't already exist.
+func (e Engine) newRocksDB(config dvid.StoreConfig) (*RocksDB, bool, error) {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+	c := config.GetAll()
+	families, err := parseFamilies(c)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Is there a database already at this path?  If not, create.
+	var created bool
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		dvid.Infof("Database not already at path (%s). Creating directory...\n", path)
+		created = true
+		if err := os.MkdirAll(path, 0744); err != nil {
+			return nil, true, fmt.Errorf("Can't make directory at %s: %v", path, err)
+		}
+	} else {
+		dvid.Infof("Found directory at %s (err = %v)\n", path, err)
+	}
+
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	opts, err := getOptions(c)
+	if err != nil {
+		return nil, false, err
+	}
+	names := []string{DefaultFamily}
+	cfOpts := []*gorocksdb.Options{opts}
+	for _, f := range families {
+		names = append(names, f.name)
+		cfOpts = append(cfOpts, opts)
+	}
+
+	dvid.Infof("Opening rocksdb @ path %s with column families %v\n", path, names)
+	rdb, handles, err := gorocksdb.OpenDbColumnFamilies(opts, path, names, cfOpts)
+	if err != nil {
+		opts.Destroy()
+		return nil, false, err
+	}
+	wo := gorocksdb.NewDefaultWriteOptions()
+	wo.SetSync(DefaultSync)
+	db := &RocksDB{
+		directory: path,
+		config:    config,
+		options:   opts,
+		ro:        gorocksdb.NewDefaultReadOptions(),
+		wo:        wo,
+		rdb:       rdb,
+		handles:   handles,
+		families:  families,
+	}
+	db.classCF = make([]*gorocksdb.ColumnFamilyHandle, 256)
+	for class := range db.classCF {
+		db.classCF[class] = handles[0]
+	}
+	for i, f := range families {
+		for class := int(f.min); class <= int(f.max); class++ {
+			db.classCF[class] = handles[i+1]
+		}
+	}
+
+	// if we know it's newly created, just return.
+	if created {
+		return db, created, nil
+	}
+
+	// otherwise, check if there's been any metadata or we need to initialize it.
+	metadataExists, err := db.metadataExists()
+	if err != nil {
+		db.Close()
+		return nil, false, err
+	}
+	return db, !metadataExists, nil
+}
+
+// ---- RepairableEngine interface implementation ------
+
+// Repair tries to repair a damaged rocksdb.  Requires "path" string.  Implements
+// the RepairableEngine interface.
+func (e Engine) Repair(path string) error {
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	opts, err := getOptions(nil)
+	if err != nil {
+		return err
+	}
+	defer opts.Destroy()
+	return gorocksdb.RepairDb(path, opts)
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets rocksdb as the default key-value backend.  If another
+// engine is already set, it returns an error since only one key-value backend should
+// be tested via tags.  The test store uses a separate column family for the upper half
+// of the TKeyClass space so range queries are exercised across families.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("rocksdb can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	if backend.Metadata != "" {
+		return fmt.Errorf("rocksdb can't be testable key-value.  Metadata already set to %s", backend.Metadata)
+	}
+	alias := storage.Alias("rocksdb")
+	backend.Metadata = alias
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"path":     fmt.Sprintf("dvid-test-rocksdb-%x", uuid.NewV4().Bytes()),
+		"testing":  true,
+		"families": []interface{}{"upper:128-255"},
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "rocksdb"}
+	return nil
+}
+
+// Delete implements the TestableEngine interface by providing a way to dispose
+// of testing databases.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Delete the directory if it exists
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("Can't delete old datastore %q: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// --- The RocksDB implementation must satisfy a Engine interface ----
+
+type RocksDB struct {
+	// Directory of datastore
+	directory string
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+
+	options *gorocksdb.Options
+	ro      *gorocksdb.ReadOptions
+	wo      *gorocksdb.WriteOptions
+	rdb     *gorocksdb.DB
+
+	// handles[0] is the default column family, followed by handles for each family.
+	handles  []*gorocksdb.ColumnFamilyHandle
+	families []family
+
+	// column family handle for each of the 256 TKeyClass.
+	classCF []*gorocksdb.ColumnFamilyHandle
+}
+
+func (db *RocksDB) String() string {
+	return fmt.Sprintf("rocksdb @ %s", db.directory)
+}
+
+// Close closes the rocksdb and frees its options.
+func (db *RocksDB) Close() {
+	if db == nil || db.rdb == nil {
+		return
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	for _, h := range db.handles {
+		h.Destroy()
+	}
+	db.rdb.Close()
+	db.ro.Destroy()
+	db.wo.Destroy()
+	db.options.Destroy()
+	db.rdb = nil
+	db.handles = nil
+}
+
+// Equal returns true if the rocksdb matches the given store configuration.
+func (db *RocksDB) Equal(config dvid.StoreConfig) bool {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.directory == path
+}
+
+// familyFor returns the column family for a full key.
+func (db *RocksDB) familyFor(k storage.Key) *gorocksdb.ColumnFamilyHandle {
+	class, err := storage.DataKeyClass(k)
+	if err != nil {
+		return db.handles[0]
+	}
+	return db.classCF[class]
+}
+
+func (db *RocksDB) metadataExists() (bool, error) {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	dvid.StartCgo()
+	it := db.newIterator()
+	defer func() {
+		it.Close()
+		dvid.StopCgo()
+	}()
+
+	it.Seek(keyBeg)
+	if it.Valid() && bytes.Compare(it.Key(), keyEnd) <= 0 {
+		return true, nil
+	}
+	if err := it.Err(); err != nil {
+		return false, err
+	}
+	dvid.Infof("No metadata found for %s...\n", db)
+	return false, nil
+}
+
+// ---- Iteration across column families -----
+
+// mergedIterator iterates over all column families in key order, so range queries see
+// the same ordering as a database with a single column family.
+type mergedIterator struct {
+	its  []*gorocksdb.Iterator
+	keys [][]byte // current key of each iterator or nil if exhausted
+	cur  int      // index of iterator with smallest key or -1 if all are exhausted
+}
+
+func (db *RocksDB) newIterator() *mergedIterator {
+	m := &mergedIterator{
+		its:  make([]*gorocksdb.Iterator, len(db.handles)),
+		keys: make([][]byte, len(db.handles)),
+		cur:  -1,
+	}
+	for i, h := range db.handles {
+		m.its[i] = db.rdb.NewIteratorCF(db.ro, h)
+	}
+	return m
+}
+
+func (m *mergedIterator) load(i int) {
+	it := m.its[i]
+	if !it.Valid() {
+		m.keys[i] = nil
+		return
+	}
+	k := it.Key()
+	m.keys[i] = append([]byte{}, k.Data()...)
+	k.Free()
+}
+
+func (m *mergedIterator) pick() {
+	m.cur = -1
+	for i, k := range m.keys {
+		if k == nil {
+			continue
+		}
+		if m.cur < 0 || bytes.Compare(k, m.keys[m.cur]) < 0 {
+			m.cur = i
+		}
+	}
+}
+
+func (m *mergedIterator) Seek(k []byte) {
+	for i, it := range m.its {
+		it.Seek(k)
+		m.load(i)
+	}
+	m.pick()
+}
+
+func (m *mergedIterator) Valid() bool {
+	return m.cur >= 0
+}
+
+// Key returns the current key, which remains valid after iteration continues.
+func (m *mergedIterator) Key() []byte {
+	return m.keys[m.cur]
+}
+
+// Value returns a copy of the current value.
+func (m *mergedIterator) Value() []byte {
+	v := m.its[m.cur].Value()
+	value := append([]byte{}, v.Data()...)
+	v.Free()
+	return value
+}
+
+func (m *mergedIterator) Next() {
+	m.its[m.cur].Next()
+	m.load(m.cur)
+	m.pick()
+}
+
+func (m *mergedIterator) Err() error {
+	for _, it := range m.its {
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mergedIterator) Close() {
+	for _, it := range m.its {
+		it.Close()
+	}
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *RocksDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GET on nil RocksDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+
+		// Get all versions of this key and return the most recent
+		values, err := db.getSingleKeyVersions(vctx, tk, false)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			return kv.V, err
+		}
+		return nil, err
+	}
+	key := ctx.ConstructKey(tk)
+	dvid.StartCgo()
+	s, err := db.rdb.GetCF(db.ro, db.familyFor(key), key)
+	dvid.StopCgo()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Free()
+	if !s.Exists() {
+		return nil, nil
+	}
+	v := append([]byte{}, s.Data()...)
+	storage.StoreValueBytesRead <- len(v)
+	return v, nil
+}
+
+// Exists returns true if a value is stored for the key.  Missing unversioned keys are
+// usually rejected by the bloom filters, and no values are copied out of RocksDB.
+func (db *RocksDB) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists on nil RocksDB")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		versions, err := db.getSingleKeyVersions(vctx, tk, true)
+		if err != nil {
+			return false, err
+		}
+		kv, err := vctx.VersionedKeyValue(versions)
+		return kv != nil, err
+	}
+	key := ctx.ConstructKey(tk)
+	dvid.StartCgo()
+	s, err := db.rdb.GetCF(db.ro, db.familyFor(key), key)
+	dvid.StopCgo()
+	if err != nil {
+		return false, err
+	}
+	defer s.Free()
+	return s.Exists(), nil
+}
+
+// getSingleKeyVersions returns all versions of a key, without values if keysOnly is true.
+// These key-value pairs will be sorted in ascending key order and could include a tombstone
+// key.  All versions of a key share a TKeyClass and therefore a column family.
+func (db *RocksDB) getSingleKeyVersions(vctx storage.VersionedCtx, tk []byte, keysOnly bool) ([]*storage.KeyValue, error) {
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+
+	dvid.StartCgo()
+	it := db.rdb.NewIteratorCF(db.ro, db.familyFor(begKey))
+	defer func() {
+		it.Close()
+		dvid.StopCgo()
+	}()
+
+	values := []*storage.KeyValue{}
+	for it.Seek(begKey); it.Valid(); it.Next() {
+		k := it.Key()
+		itKey := append([]byte{}, k.Data()...)
+		k.Free()
+		storage.StoreKeyBytesRead <- len(itKey)
+		if bytes.Compare(itKey, endKey) > 0 {
+			return values, nil
+		}
+		if keysOnly {
+			values = append(values, &storage.KeyValue{K: itKey})
+			continue
+		}
+		v := it.Value()
+		itValue := append([]byte{}, v.Data()...)
+		v.Free()
+		storage.StoreValueBytesRead <- len(itValue)
+		values = append(values, &storage.KeyValue{K: itKey, V: itValue})
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+type errorableKV struct {
+	*storage.KeyValue
+	error
+}
+
+func sendKV(vctx storage.VersionedCtx, values []*storage.KeyValue, ch chan errorableKV) {
+	if len(values) != 0 {
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil {
+			ch <- errorableKV{nil, err}
+			return
+		}
+		if kv != nil {
+			ch <- errorableKV{kv, nil}
+		}
+	}
+}
+
+// versionedRange sends a range of key-value pairs for a particular version down a channel.
+func (db *RocksDB) versionedRange(vctx storage.VersionedCtx, begTKey, endTKey storage.TKey, ch chan errorableKV, done <-chan struct{}, keysOnly bool) {
+	dvid.StartCgo()
+	it := db.newIterator()
+	defer func() {
+		it.Close()
+		dvid.StopCgo()
+	}()
+
+	minKey, err := vctx.MinVersionKey(begTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxKey, err := vctx.MaxVersionKey(endTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(begTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+
+	values := []*storage.KeyValue{}
+	for it.Seek(minKey); it.Valid(); it.Next() {
+		select {
+		case <-done: // only happens if we don't care about rest of data.
+			ch <- errorableKV{nil, nil}
+			return
+		default:
+		}
+		itKey := it.Key()
+		storage.StoreKeyBytesRead <- len(itKey)
+		var itValue []byte
+		if !keysOnly {
+			itValue = it.Value()
+			storage.StoreValueBytesRead <- len(itValue)
+		}
+
+		// Did we pass all versions for last key read?
+		if bytes.Compare(itKey, maxVersionKey) > 0 {
+			indexBytes, err := storage.TKeyFromKey(itKey)
+			if err != nil {
+				ch <- errorableKV{nil, err}
+				return
+			}
+			maxVersionKey, err = vctx.MaxVersionKey(indexBytes)
+			if err != nil {
+				ch <- errorableKV{nil, err}
+				return
+			}
+			sendKV(vctx, values, ch)
+			values = []*storage.KeyValue{}
+		}
+		// Did we pass the final key?
+		if bytes.Compare(itKey, maxKey) > 0 {
+			break
+		}
+		values = append(values, &storage.KeyValue{K: itKey, V: itValue})
+	}
+	if err := it.Err(); err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	sendKV(vctx, values, ch)
+	ch <- errorableKV{nil, nil}
+}
+
+// unversionedRange sends a range of key-value pairs down a channel.
+func (db *RocksDB) unversionedRange(ctx storage.Context, begTKey, endTKey storage.TKey, ch chan errorableKV, done <-chan struct{}, keysOnly bool) {
+	dvid.StartCgo()
+	it := db.newIterator()
+	defer func() {
+		it.Close()
+		dvid.StopCgo()
+	}()
+
+	begKey := ctx.ConstructKey(begTKey)
+	endKey := ctx.ConstructKey(endTKey)
+	for it.Seek(begKey); it.Valid(); it.Next() {
+		itKey := it.Key()
+		storage.StoreKeyBytesRead <- len(itKey)
+		// Did we pass the final key?
+		if bytes.Compare(itKey, endKey) > 0 {
+			break
+		}
+		var itValue []byte
+		if !keysOnly {
+			itValue = it.Value()
+			storage.StoreValueBytesRead <- len(itValue)
+		}
+		select {
+		case <-done:
+			ch <- errorableKV{nil, nil}
+			return
+		case ch <- errorableKV{&storage.KeyValue{K: itKey, V: itValue}, nil}:
+		}
+	}
+	if err := it.Err(); err != nil {
+		ch <- errorableKV{nil, err}
+	} else {
+		ch <- errorableKV{nil, nil}
+	}
+}
+
+// rangeQuery launches a possibly versioned range query in a goroutine.
+func (db *RocksDB) rangeQuery(ctx storage.Context, kStart, kEnd storage.TKey, done <-chan struct{}, keysOnly bool) chan errorableKV {
+	ch := make(chan errorableKV)
+	go func() {
+		if !ctx.Versioned() {
+			db.unversionedRange(ctx, kStart, kEnd, ch, done, keysOnly)
+		} else {
+			db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, keysOnly)
+		}
+	}()
+	return ch
+}
+
+// drain consumes any remaining results so the range goroutine can exit.
+func drain(ch chan errorableKV) {
+	go func() {
+		for result := range ch {
+			if result.KeyValue == nil {
+				return
+			}
+		}
+	}()
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *RocksDB) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil RocksDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	// Consume the keys.
+	values := []storage.TKey{}
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return nil, result.error
+			}
+			return values, nil
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return nil, err
+		}
+		values = append(values, tk)
+	}
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *RocksDB) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, kch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil RocksDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	// Consume the keys.
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			kch <- nil
+			return result.error
+		}
+		kch <- result.KeyValue.K
+	}
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *RocksDB) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil RocksDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, false)
+
+	// Consume the key-value pairs.
+	values := []*storage.TKeyValue{}
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return nil, result.error
+			}
+			return values, nil
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return nil, err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: result.KeyValue.V})
+	}
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *RocksDB) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil RocksDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	f = storage.CancelableChunkFunc(ctx, f)
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, false)
+
+	// Consume the key-value pairs.
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			return result.error
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return err
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tkv := storage.TKeyValue{K: tk, V: result.KeyValue.V}
+		chunk := &storage.Chunk{ChunkOp: op, TKeyValue: &tkv}
+		if err := f(chunk); err != nil {
+			drain(ch)
+			return err
+		}
+	}
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (db *RocksDB) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil RocksDB")
+	}
+	dvid.StartCgo()
+	it := db.newIterator()
+	defer func() {
+		it.Close()
+		dvid.StopCgo()
+	}()
+
+	for it.Seek(kStart); it.Valid(); it.Next() {
+		itKey := it.Key()
+		storage.StoreKeyBytesRead <- len(itKey)
+		// Did we pass the final key?
+		if bytes.Compare(itKey, kEnd) > 0 {
+			break
+		}
+		var itValue []byte
+		if !keysOnly {
+			itValue = it.Value()
+			storage.StoreValueBytesRead <- len(itValue)
+		}
+		select {
+		case out <- &storage.KeyValue{K: itKey, V: itValue}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	out <- nil
+	return it.Err()
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *RocksDB) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil RocksDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Put(tk, v)
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of Put: %v\n", err)
+		return fmt.Errorf("Error on batch commit of Put: %v", err)
+	}
+	return nil
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *RocksDB) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil RocksDB")
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	if err := db.rdb.PutCF(db.wo, db.familyFor(k), k, v); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *RocksDB) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil RocksDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of Delete: %v\n", err)
+		return fmt.Errorf("Error on batch commit of Delete: %v", err)
+	}
+	return nil
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *RocksDB) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil RocksDB")
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	return db.rdb.DeleteCF(db.wo, db.familyFor(k), k)
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+func (db *RocksDB) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange on nil RocksDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of PutRange: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.
+func (db *RocksDB) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil RocksDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+
+	const BATCH_SIZE = 10000
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	batch := db.NewBatch(ctx)
+	numKV := 0
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return result.error
+			}
+			break
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return err
+		}
+		batch.Delete(tk)
+		if (numKV+1)%BATCH_SIZE == 0 {
+			if err := batch.Commit(); err != nil {
+				drain(ch)
+				dvid.Criticalf("Error on batch commit of DeleteRange at key-value pair %d: %v\n", numKV, err)
+				return fmt.Errorf("Error on batch commit of DeleteRange at key-value pair %d: %v", numKV, err)
+			}
+			batch = db.NewBatch(ctx)
+		}
+		numKV++
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on last batch commit of DeleteRange: %v\n", err)
+		return fmt.Errorf("Error on last batch commit of DeleteRange: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", numKV, ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+func (db *RocksDB) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil RocksDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+
+	var minKey, maxKey storage.Key
+	var err error
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if !allVersions && !versioned {
+		return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+	}
+	if versioned {
+		minKey, err = vctx.MinVersionKey(storage.MinTKey(storage.TKeyMinClass))
+		if err != nil {
+			return err
+		}
+		maxKey, err = vctx.MaxVersionKey(storage.MaxTKey(storage.TKeyMaxClass))
+		if err != nil {
+			return err
+		}
+	} else {
+		minKey, maxKey = ctx.KeyRange()
+	}
+
+	dvid.StartCgo()
+	it := db.newIterator()
+	defer func() {
+		it.Close()
+		dvid.StopCgo()
+	}()
+
+	const BATCH_SIZE = 10000
+	wb := gorocksdb.NewWriteBatch()
+	defer func() {
+		wb.Destroy()
+	}()
+
+	numKV := 0
+	deleteVersion := ctx.VersionID()
+	for it.Seek(minKey); it.Valid(); it.Next() {
+		itKey := it.Key()
+		storage.StoreKeyBytesRead <- len(itKey)
+		// Did we pass the final key?
+		if bytes.Compare(itKey, maxKey) > 0 {
+			break
+		}
+		if !allVersions {
+			_, v, _, err := storage.DataKeyToLocalIDs(itKey)
+			if err != nil {
+				return fmt.Errorf("Error on DELETE ALL for version %d: %v", deleteVersion, err)
+			}
+			if v != deleteVersion {
+				continue
+			}
+		}
+		wb.DeleteCF(db.familyFor(itKey), itKey)
+		if (numKV+1)%BATCH_SIZE == 0 {
+			if err := db.rdb.Write(db.wo, wb); err != nil {
+				dvid.Criticalf("Error on batch commit of DeleteAll at key-value pair %d: %v\n", numKV, err)
+				return fmt.Errorf("Error on batch commit of DeleteAll at key-value pair %d: %v", numKV, err)
+			}
+			wb.Clear()
+			dvid.Debugf("Deleted %d key-value pairs in ongoing DELETE ALL for %s.\n", numKV+1, ctx)
+		}
+		numKV++
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("Error iterating during DeleteAll for %s: %v", ctx, err)
+	}
+	if err := db.rdb.Write(db.wo, wb); err != nil {
+		dvid.Criticalf("Error on last batch commit of DeleteAll: %v\n", err)
+		return fmt.Errorf("Error on last batch commit of DeleteAll: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", numKV, ctx)
+	return nil
+}
+
+// --- Batcher interface ----
+
+type goBatch struct {
+	db   *RocksDB
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	*gorocksdb.WriteBatch
+}
+
+// NewBatch returns an implementation that allows batch writes
+func (db *RocksDB) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil RocksDB\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		vctx = nil
+	}
+	return &goBatch{db, ctx, vctx, gorocksdb.NewWriteBatch()}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	key := batch.ctx.ConstructKey(tk)
+	cf := batch.db.familyFor(key)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.WriteBatch.PutCF(cf, tombstone, dvid.EmptyValue())
+	}
+	batch.WriteBatch.DeleteCF(cf, key)
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	key := batch.ctx.ConstructKey(tk)
+	cf := batch.db.familyFor(key)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.WriteBatch.DeleteCF(cf, tombstone)
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.WriteBatch.PutCF(cf, key, v)
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	err := batch.db.rdb.Write(batch.db.wo, batch.WriteBatch)
+	batch.WriteBatch.Destroy()
+	return err
+}
+
+// ---- SizeViewer interface ------
+
+// GetApproximateSizes returns the approximate size of each key range summed across
+// all column families.
+func (db *RocksDB) GetApproximateSizes(ranges []storage.KeyRange) ([]uint64, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetApproximateSizes on nil RocksDB")
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	rr := make([]gorocksdb.Range, len(ranges))
+	for i, kr := range ranges {
+		rr[i] = gorocksdb.Range{
+			Start: []byte(kr.Start),
+			Limit: []byte(kr.OpenEnd),
+		}
+	}
+	sizes := make([]uint64, len(ranges))
+	for _, h := range db.handles {
+		cfSizes := db.rdb.GetApproximateSizesCF(h, rr)
+		for i, s := range cfSizes {
+			sizes[i] += s
+		}
+	}
+	return sizes, nil
+}
+
+// ---- Compacter interface ------
+
+// Compact compacts the key range in all column families, discarding deleted and
+// overwritten values.
+func (db *RocksDB) Compact(r storage.KeyRange) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Compact on nil RocksDB")
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	rr := gorocksdb.Range{
+		Start: []byte(r.Start),
+		Limit: []byte(r.OpenEnd),
+	}
+	for _, h := range db.handles {
+		db.rdb.CompactRangeCF(h, rr)
+	}
+	return nil
+}
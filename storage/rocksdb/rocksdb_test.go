@@ -0,0 +1,192 @@
+// +build rocksdb
+
+package rocksdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/storage/storetest"
+)
+
+func testConfig(c map[string]interface{}) dvid.StoreConfig {
+	var config dvid.Config
+	config.SetAll(c)
+	return dvid.StoreConfig{Config: config, Engine: "rocksdb"}
+}
+
+func TestRocksDBConfig(t *testing.T) {
+	path, testing, err := parseConfig(testConfig(map[string]interface{}{"path": "/data/rocks"}))
+	if err != nil || path != "/data/rocks" || testing {
+		t.Errorf("bad parsed configuration %q, %t: %v\n", path, testing, err)
+	}
+	path, testing, err = parseConfig(testConfig(map[string]interface{}{"path": "rocks", "testing": true}))
+	if err != nil || path != filepath.Join(os.TempDir(), "rocks") || !testing {
+		t.Errorf("expected test store in temp directory, got %q, %t: %v\n", path, testing, err)
+	}
+	for _, c := range []map[string]interface{}{{}, {"path": 1}, {"path": "rocks", "testing": "yes"}} {
+		if _, _, err := parseConfig(testConfig(c)); err == nil {
+			t.Errorf("expected error parsing configuration %v\n", c)
+		}
+	}
+
+	for _, v := range []interface{}{8, int64(8), 8.0, "8"} {
+		if i, err := intSetting(map[string]interface{}{"n": v}, "n", 0); err != nil || i != 8 {
+			t.Errorf("expected integer setting 8 from %v, got %d: %v\n", v, i, err)
+		}
+	}
+	if _, err := intSetting(map[string]interface{}{"n": true}, "n", 0); err == nil {
+		t.Errorf("expected error for non-integer setting\n")
+	}
+	for _, c := range []map[string]interface{}{{"compactionstyle": "tiered"}, {"compactionstyle": 1}, {"bloombits": "ten"}} {
+		if _, err := getOptions(c); err == nil {
+			t.Errorf("expected error getting options for %v\n", c)
+		}
+	}
+}
+
+func TestRocksDBFamilies(t *testing.T) {
+	families, err := parseFamilies(map[string]interface{}{"families": []interface{}{"blocks:200-255", "labels:10-10"}})
+	if err != nil {
+		t.Fatalf("error parsing families: %v\n", err)
+	}
+	expected := []family{{"blocks", 200, 255}, {"labels", 10, 10}}
+	if len(families) != len(expected) {
+		t.Fatalf("expected families %v, got %v\n", expected, families)
+	}
+	for i, f := range families {
+		if f != expected[i] {
+			t.Errorf("expected family %v, got %v\n", expected[i], f)
+		}
+	}
+	if families, err := parseFamilies(map[string]interface{}{}); err != nil || families != nil {
+		t.Errorf("expected no families by default, got %v: %v\n", families, err)
+	}
+
+	bad := [][]interface{}{
+		{"blocks"},
+		{":1-2"},
+		{"default:1-2"},
+		{"blocks:1"},
+		{"blocks:a-2"},
+		{"blocks:1-256"},
+		{"blocks:2-1"},
+		{"blocks:1-2", "blocks:3-4"},
+		{"blocks:1-5", "labels:5-6"},
+		{1},
+	}
+	for _, specs := range bad {
+		if _, err := parseFamilies(map[string]interface{}{"families": specs}); err == nil {
+			t.Errorf("expected error parsing families %v\n", specs)
+		}
+	}
+	if _, err := parseFamilies(map[string]interface{}{"families": "blocks:1-2"}); err == nil {
+		t.Errorf("expected error parsing families that aren't a list\n")
+	}
+}
+
+// checkFamilyRouting checks that data keys are stored in the column family of their
+// class, metadata stays in the default family, and range queries merge the families in
+// key order.  The test store puts classes 128-255 in a separate family.
+func checkFamilyRouting(store dvid.Store) error {
+	db := store.(*RocksDB)
+	if len(db.handles) != 2 {
+		return fmt.Errorf("expected default and one configured family, got %d handles", len(db.handles))
+	}
+	ctx := storetest.NewDataContext(10, 1)
+	lower := storage.NewTKey(1, []byte("lower"))
+	upper := storage.NewTKey(200, []byte("upper"))
+	meta := storage.TKey("routing")
+	if err := db.Put(ctx, lower, []byte("lower")); err != nil {
+		return fmt.Errorf("error on put: %v", err)
+	}
+	if err := db.Put(ctx, upper, []byte("upper")); err != nil {
+		return fmt.Errorf("error on put: %v", err)
+	}
+	var mctx storage.MetadataContext
+	if err := db.Put(mctx, meta, []byte("meta")); err != nil {
+		return fmt.Errorf("error on metadata put: %v", err)
+	}
+	defer db.Delete(mctx, meta)
+	defer db.DeleteAll(ctx, true)
+
+	routes := []struct {
+		k      storage.Key
+		family int
+	}{
+		{ctx.ConstructKey(lower), 0},
+		{ctx.ConstructKey(upper), 1},
+		{mctx.ConstructKey(meta), 0},
+	}
+	for _, route := range routes {
+		for i, h := range db.handles {
+			s, err := db.rdb.GetCF(db.ro, h, route.k)
+			if err != nil {
+				return fmt.Errorf("error getting key %v from family %d: %v", route.k, i, err)
+			}
+			found := s.Exists()
+			s.Free()
+			if found != (i == route.family) {
+				return fmt.Errorf("expected key %v only in family %d, found %t in family %d", route.k, route.family, found, i)
+			}
+		}
+	}
+
+	kvs, err := db.GetRange(ctx, storage.MinTKey(0), storage.MaxTKey(255))
+	if err != nil {
+		return fmt.Errorf("error on range across families: %v", err)
+	}
+	if len(kvs) != 2 || string(kvs[0].V) != "lower" || string(kvs[1].V) != "upper" {
+		return fmt.Errorf("expected values of both families in key order, got %v", kvs)
+	}
+	return nil
+}
+
+func TestRocksDBStore(t *testing.T) {
+	storetest.RunEngine(t, "rocksdb", []storetest.Check{{Name: "FamilyRouting", Run: checkFamilyRouting}})
+}
+
+func TestRocksDBReopen(t *testing.T) {
+	var e Engine
+	backend := new(storage.Backend)
+	if err := e.AddTestConfig(backend); err != nil {
+		t.Fatal(err)
+	}
+	config := backend.Stores[backend.DefaultKVDB]
+	defer e.Delete(config)
+
+	db, created, err := e.newRocksDB(config)
+	if err != nil {
+		t.Fatalf("unable to open test store: %v\n", err)
+	}
+	if !created {
+		t.Errorf("expected new store to need metadata\n")
+	}
+	ctx := storetest.NewDataContext(10, 1)
+	upper := storage.NewTKey(200, []byte("kept"))
+	if err := db.Put(ctx, upper, []byte("kept")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	var mctx storage.MetadataContext
+	if err := db.Put(mctx, storage.TKey("meta"), []byte("meta")); err != nil {
+		t.Fatalf("error on metadata put: %v\n", err)
+	}
+	db.Close()
+
+	// A reopened store finds values in their column families and has metadata.
+	db, created, err = e.newRocksDB(config)
+	if err != nil {
+		t.Fatalf("unable to reopen test store: %v\n", err)
+	}
+	defer db.Close()
+	if created {
+		t.Errorf("expected reopened store to have metadata\n")
+	}
+	if v, err := db.Get(ctx, upper); err != nil || string(v) != "kept" {
+		t.Errorf("expected value after reopening, got %q: %v\n", v, err)
+	}
+}
@@ -0,0 +1,508 @@
+// +build s3
+
+/*
+	Package s3 implements a storage engine for Amazon S3 or S3-compatible object stores.
+	Each key-value pair is stored as a single object whose name is the hex encoding of the
+	full key, so lexicographic listing of object names preserves DVID key order.
+
+	The engine is intended for immutable block data, e.g., locked historical versions,
+	while metadata stays in a local ordered key-value store.  It satisfies the
+	KeyValueGetter, KeyValueSetter, and KeyValueIngestable interfaces but does not provide
+	range queries.
+
+	Credentials are obtained from the standard AWS provider chain: environment variables,
+	the shared credentials file, or an EC2/ECS instance role.
+*/
+package s3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	api "github.com/aws/aws-sdk-go/service/s3"
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in s3: %v\n", err)
+	}
+	e := Engine{"s3", "Amazon S3 object store", ver}
+	storage.RegisterEngine(e)
+}
+
+const (
+	// INITKEY is the name of the object written to mark an initialized store.
+	INITKEY = "initialized"
+)
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return true
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns an S3 bucket store.  The passed Config must contain:
+// "bucket": name of an existing bucket
+// Optional settings:
+// "region": AWS region of the bucket
+// "prefix": object name prefix so multiple stores can share a bucket
+// "endpoint": URL of an S3-compatible service, e.g., a MinIO server
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newS3Store(config)
+}
+
+func stringSetting(c map[string]interface{}, key string) (string, error) {
+	v, found := c[key]
+	if !found {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%q setting must be a string (%v)", key, v)
+	}
+	return s, nil
+}
+
+func parseConfig(config dvid.StoreConfig) (*S3Store, error) {
+	c := config.GetAll()
+	bucket, err := stringSetting(c, "bucket")
+	if err != nil {
+		return nil, err
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("%q must be specified for s3 configuration", "bucket")
+	}
+	db := &S3Store{bucket: bucket}
+	if db.region, err = stringSetting(c, "region"); err != nil {
+		return nil, err
+	}
+	if db.prefix, err = stringSetting(c, "prefix"); err != nil {
+		return nil, err
+	}
+	if db.endpoint, err = stringSetting(c, "endpoint"); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// newS3Store sets up a client for the configured bucket, which must already exist.
+func (e Engine) newS3Store(config dvid.StoreConfig) (*S3Store, bool, error) {
+	db, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	awsConfig := aws.Config{}
+	if db.region != "" {
+		awsConfig.Region = aws.String(db.region)
+	}
+	if db.endpoint != "" {
+		awsConfig.Endpoint = aws.String(db.endpoint)
+		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to create AWS session for %s: %v", db, err)
+	}
+	db.client = api.New(sess)
+
+	// bucket must already exist -- check existence
+	if _, err = db.client.HeadBucket(&api.HeadBucketInput{Bucket: aws.String(db.bucket)}); err != nil {
+		return nil, false, fmt.Errorf("unable to access %s: %v", db, err)
+	}
+
+	// mark as initialized if this is a new store.
+	val, err := db.getObject(db.prefix + INITKEY)
+	if err != nil {
+		return nil, false, err
+	}
+	var created bool
+	if val == nil {
+		created = true
+		if err := db.putObject(db.prefix+INITKEY, []byte(e.semver.String())); err != nil {
+			return nil, false, err
+		}
+	}
+	dvid.Infof("Opened %s\n", db)
+	return db, created, nil
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets s3 as the default key-value backend, using the bucket given by the
+// DVID_TEST_S3_BUCKET environment variable and any endpoint of an S3-compatible store
+// given by DVID_TEST_S3_ENDPOINT.  Each test store uses a new prefix in the bucket.  The
+// metadata isn't set since it requires range queries.  If another engine is already set,
+// it returns an error since only one key-value backend should be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("s3 can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	bucket := os.Getenv("DVID_TEST_S3_BUCKET")
+	if bucket == "" {
+		return fmt.Errorf("s3 can't be testable key-value without a bucket in DVID_TEST_S3_BUCKET")
+	}
+	alias := storage.Alias("s3")
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"bucket":   bucket,
+		"region":   os.Getenv("DVID_TEST_S3_REGION"),
+		"endpoint": os.Getenv("DVID_TEST_S3_ENDPOINT"),
+		"prefix":   fmt.Sprintf("dvid-test-%x/", uuid.NewV4().Bytes()),
+		"testing":  true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "s3"}
+	return nil
+}
+
+// Delete removes the objects of a test store, whose prefix is only used by the test.  It's
+// a no-op for other stores since buckets are not deleted via DVID.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	testing, _, err := config.GetBool("testing")
+	if err != nil || !testing {
+		return err
+	}
+	store, _, err := e.newS3Store(config)
+	if err != nil {
+		return err
+	}
+	input := &api.ListObjectsV2Input{
+		Bucket: aws.String(store.bucket),
+		Prefix: aws.String(store.prefix),
+	}
+	var delErr error
+	err = store.client.ListObjectsV2Pages(input, func(page *api.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			if delErr = store.deleteObject(aws.StringValue(obj.Key)); delErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if delErr != nil {
+		return delErr
+	}
+	return err
+}
+
+// S3Store is a key-value store backed by an S3 bucket.
+type S3Store struct {
+	bucket   string
+	region   string
+	prefix   string
+	endpoint string
+	client   *api.S3
+}
+
+func (db *S3Store) String() string {
+	if db.endpoint != "" {
+		return fmt.Sprintf("s3 bucket %q @ %s", db.bucket, db.endpoint)
+	}
+	return fmt.Sprintf("s3 bucket %q", db.bucket)
+}
+
+// Close is a no-op since S3 clients hold no persistent connection.
+func (db *S3Store) Close() {}
+
+// Equal returns true if the S3 store matches the given store configuration.
+func (db *S3Store) Equal(config dvid.StoreConfig) bool {
+	other, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.bucket == other.bucket && db.prefix == other.prefix && db.endpoint == other.endpoint
+}
+
+// objectName returns the object name for a full key.
+func (db *S3Store) objectName(k storage.Key) string {
+	return db.prefix + hex.EncodeToString(k)
+}
+
+// keyFromObject returns the full key for a listed object name.
+func (db *S3Store) keyFromObject(name string) (storage.Key, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(name, db.prefix))
+	if err != nil {
+		return nil, fmt.Errorf("bad object name %q in %s: %v", name, db, err)
+	}
+	return storage.Key(b), nil
+}
+
+// isNotFound returns true for errors from getting a missing object or, since HEAD
+// responses have no body with an error code, the status of heading a missing object.
+func isNotFound(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == api.ErrCodeNoSuchKey || aerr.Code() == "NotFound"
+	}
+	return false
+}
+
+// getObject returns the value of an object or nil if it does not exist.
+func (db *S3Store) getObject(name string) ([]byte, error) {
+	out, err := db.client.GetObject(&api.GetObjectInput{
+		Bucket: aws.String(db.bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+func (db *S3Store) putObject(name string, value []byte) error {
+	_, err := db.client.PutObject(&api.PutObjectInput{
+		Bucket: aws.String(db.bucket),
+		Key:    aws.String(name),
+		Body:   bytes.NewReader(value),
+	})
+	return err
+}
+
+func (db *S3Store) deleteObject(name string) error {
+	_, err := db.client.DeleteObject(&api.DeleteObjectInput{
+		Bucket: aws.String(db.bucket),
+		Key:    aws.String(name),
+	})
+	return err
+}
+
+// getSingleKeyVersions returns all stored versions of a key, including tombstones.
+// Values are not fetched.
+func (db *S3Store) getSingleKeyVersions(vctx storage.VersionedCtx, tk storage.TKey) ([]*storage.KeyValue, error) {
+	unvKey, _, err := vctx.UnversionedKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	input := &api.ListObjectsV2Input{
+		Bucket: aws.String(db.bucket),
+		Prefix: aws.String(db.objectName(unvKey)),
+	}
+	var kvs []*storage.KeyValue
+	var keyErr error
+	err = db.client.ListObjectsV2Pages(input, func(page *api.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			k, err := db.keyFromObject(aws.StringValue(obj.Key))
+			if err != nil {
+				keyErr = err
+				return false
+			}
+			// The prefix also matches longer type-specific keys, so make sure this is the same TKey.
+			itTKey, err := storage.TKeyFromKey(k)
+			if err != nil {
+				keyErr = err
+				return false
+			}
+			if bytes.Equal(itTKey, tk) {
+				kvs = append(kvs, &storage.KeyValue{K: k})
+			}
+		}
+		return true
+	})
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	return kvs, err
+}
+
+// ---- KeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *S3Store) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call Get() on nil S3 store")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	key := ctx.ConstructKey(tk)
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		kvs, err := db.getSingleKeyVersions(vctx, tk)
+		if err != nil {
+			return nil, err
+		}
+		if len(kvs) == 0 {
+			return nil, nil
+		}
+		kv, err := vctx.VersionedKeyValue(kvs)
+		if err != nil || kv == nil {
+			return nil, err
+		}
+		key = kv.K
+	}
+	val, err := db.getObject(db.objectName(key))
+	if err != nil {
+		return nil, err
+	}
+	storage.StoreValueBytesRead <- len(val)
+	return val, nil
+}
+
+// Exists returns true if a value is stored for the key using a HEAD request or, for
+// versioned keys, a listing of the key's versions, so the object isn't downloaded.
+func (db *S3Store) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists() on nil S3 store")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		kvs, err := db.getSingleKeyVersions(vctx, tk)
+		if err != nil || len(kvs) == 0 {
+			return false, err
+		}
+		kv, err := vctx.VersionedKeyValue(kvs)
+		return kv != nil, err
+	}
+	_, err := db.client.HeadObject(&api.HeadObjectInput{
+		Bucket: aws.String(db.bucket),
+		Key:    aws.String(db.objectName(ctx.ConstructKey(tk))),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key in a possibly versioned context.
+func (db *S3Store) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put() on nil S3 store")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return fmt.Errorf("Bad Put(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		if err := db.deleteObject(db.objectName(vctx.TombstoneKey(tk))); err != nil {
+			return err
+		}
+	}
+	key := ctx.ConstructKey(tk)
+	if err := db.putObject(db.objectName(key), v); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete deletes a key-value pair so that subsequent Get on the key returns nil.
+// For versioned contexts, a tombstone is written for the current version.
+func (db *S3Store) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete() on nil S3 store")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return fmt.Errorf("Bad Delete(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		if err := db.putObject(db.objectName(vctx.TombstoneKey(tk)), dvid.EmptyValue()); err != nil {
+			return err
+		}
+	}
+	return db.deleteObject(db.objectName(ctx.ConstructKey(tk)))
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+func (db *S3Store) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut() on nil S3 store")
+	}
+	if err := db.putObject(db.objectName(k), v); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.
+func (db *S3Store) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete() on nil S3 store")
+	}
+	return db.deleteObject(db.objectName(k))
+}
+
+// ---- KeyValueIngestable interface ------
+
+// KeyValueIngest writes a key-value pair without tombstone bookkeeping.  Since
+// ingested data is expected to be immutable, e.g., a bulk load of a locked
+// version, only a single object write is needed per key-value pair.
+func (db *S3Store) KeyValueIngest(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call KeyValueIngest() on nil S3 store")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in KeyValueIngest()")
+	}
+	return db.RawPut(ctx.ConstructKey(tk), v)
+}
@@ -0,0 +1,76 @@
+// +build s3
+
+package s3
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	api "github.com/aws/aws-sdk-go/service/s3"
+)
+
+func testConfig(c map[string]interface{}) dvid.StoreConfig {
+	var config dvid.Config
+	config.SetAll(c)
+	return dvid.StoreConfig{Config: config, Engine: "s3"}
+}
+
+func TestS3Config(t *testing.T) {
+	for _, c := range []map[string]interface{}{{}, {"bucket": ""}, {"bucket": 3}, {"bucket": "b", "prefix": 3}} {
+		if _, err := parseConfig(testConfig(c)); err == nil {
+			t.Errorf("expected error parsing configuration %v\n", c)
+		}
+	}
+	db, err := parseConfig(testConfig(map[string]interface{}{"bucket": "b", "prefix": "p/", "endpoint": "http://minio:9000"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.bucket != "b" || db.prefix != "p/" || db.endpoint != "http://minio:9000" {
+		t.Errorf("bad parsed configuration: %s, prefix %q\n", db, db.prefix)
+	}
+	if !db.Equal(testConfig(map[string]interface{}{"bucket": "b", "prefix": "p/", "endpoint": "http://minio:9000"})) {
+		t.Errorf("expected store to equal its configuration\n")
+	}
+	if db.Equal(testConfig(map[string]interface{}{"bucket": "b", "prefix": "q/", "endpoint": "http://minio:9000"})) {
+		t.Errorf("expected store with other prefix to differ\n")
+	}
+}
+
+func TestS3ObjectNames(t *testing.T) {
+	db := &S3Store{bucket: "b", prefix: "dvid/"}
+
+	// Object listings are sorted by name, so names must sort in key order.
+	keys := []storage.Key{{0}, {0, 0}, {0, 0xff}, {1}, {0x10}, {0xff, 0}}
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = db.objectName(k)
+		k2, err := db.keyFromObject(names[i])
+		if err != nil {
+			t.Fatalf("error decoding object name %q: %v\n", names[i], err)
+		}
+		if !bytes.Equal(k, k2) {
+			t.Errorf("expected key %v from object name %q, got %v\n", k, names[i], k2)
+		}
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("expected object names in key order, got %v\n", names)
+	}
+	if _, err := db.keyFromObject("dvid/xyz"); err == nil {
+		t.Errorf("expected error decoding bad object name\n")
+	}
+
+	for _, code := range []string{api.ErrCodeNoSuchKey, "NotFound"} {
+		if !isNotFound(awserr.New(code, "missing", nil)) {
+			t.Errorf("expected %q error to be not found\n", code)
+		}
+	}
+	if isNotFound(awserr.New("AccessDenied", "denied", nil)) || isNotFound(fmt.Errorf("NotFound")) {
+		t.Errorf("expected other errors not to mean a missing object\n")
+	}
+}
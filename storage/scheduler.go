@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Priority is a class of requests that share storage and CPU resources.  Classes with
+// higher priority get proportionally more of a Scheduler's slots when requests of
+// several classes are waiting, but no class is starved.  Slots can also be reserved for
+// interactive requests so their latency doesn't depend on long-running bulk requests.
+type Priority uint8
+
+const (
+	// PriorityInteractive is for requests with a user waiting on them, e.g., tile reads.
+	PriorityInteractive Priority = iota
+
+	// PriorityNormal is the priority of requests that don't specify one.
+	PriorityNormal
+
+	// PriorityBulk is for batch jobs like ingestion or export, and is the default for
+	// HTTP requests that write data.
+	PriorityBulk
+
+	// PriorityBackground is for server jobs like the deletion of data instances.
+	PriorityBackground
+
+	numPriorities
+)
+
+// priorityStrides are the inverse of each class's share of slots under contention, so
+// interactive, normal, bulk, and background requests are granted slots in a 32:16:4:1
+// ratio.
+var priorityStrides = [numPriorities]uint64{1, 2, 8, 32}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityNormal:
+		return "normal"
+	case PriorityBulk:
+		return "bulk"
+	case PriorityBackground:
+		return "background"
+	default:
+		return fmt.Sprintf("priority %d", p)
+	}
+}
+
+// ParsePriority returns the Priority with the given name.
+func ParsePriority(s string) (Priority, error) {
+	for p := PriorityInteractive; p < numPriorities; p++ {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return PriorityNormal, fmt.Errorf("unknown priority %q, must be interactive, normal, bulk, or background", s)
+}
+
+type priorityKey struct{}
+
+type requestPriority struct {
+	p      Priority
+	client string
+}
+
+// WithPriority returns a copy of the context that carries the priority of a request
+// and the client, e.g., a user or remote host, that made it.
+func WithPriority(c context.Context, p Priority, client string) context.Context {
+	return context.WithValue(c, priorityKey{}, requestPriority{p, client})
+}
+
+// RequestPriority returns the priority and client set by WithPriority or PriorityNormal
+// and an empty client if none has been set.
+func RequestPriority(c context.Context) (Priority, string) {
+	if c != nil {
+		if rp, ok := c.Value(priorityKey{}).(requestPriority); ok {
+			return rp.p, rp.client
+		}
+	}
+	return PriorityNormal, ""
+}
+
+// Scheduler limits the number of concurrent operations to a number of slots.  Waiting
+// operations are granted slots by weighted fair queueing between priority classes and
+// round-robin between clients within a class, so one client submitting many operations
+// only delays other clients of its class by one operation per turn.
+type Scheduler struct {
+	mu       sync.Mutex
+	slots    int
+	reserved int // slots only granted to interactive operations
+	active   int
+	waiting  int
+	classes  [numPriorities]schedClass
+}
+
+type schedClass struct {
+	pass    uint64   // virtual time advanced by the class's stride on each grant
+	clients []string // clients with waiters in round-robin order
+	waiters map[string][]*schedWaiter
+}
+
+type schedWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// NewScheduler returns a Scheduler that allows the given number of concurrent operations.
+func NewScheduler(slots int) *Scheduler {
+	s := &Scheduler{slots: slots}
+	for p := range s.classes {
+		s.classes[p].waiters = make(map[string][]*schedWaiter)
+	}
+	return s
+}
+
+// Slots returns the maximum number of concurrent operations.
+func (s *Scheduler) Slots() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.slots
+}
+
+// SetSlots changes the maximum number of concurrent operations.  Operations already
+// running are not affected if the number is lowered.
+func (s *Scheduler) SetSlots(n int) {
+	s.mu.Lock()
+	s.slots = n
+	s.dispatch()
+	s.mu.Unlock()
+}
+
+// Reserved returns the number of slots reserved for interactive operations.
+func (s *Scheduler) Reserved() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reserved
+}
+
+// SetReserved reserves slots for interactive operations, so other operations can only
+// use the remaining slots.  At least one slot is always left for other operations.
+func (s *Scheduler) SetReserved(n int) {
+	s.mu.Lock()
+	s.reserved = n
+	s.dispatch()
+	s.mu.Unlock()
+}
+
+// canGrant returns true if a slot is free for an operation of the given priority.
+func (s *Scheduler) canGrant(p Priority) bool {
+	if p == PriorityInteractive {
+		return s.active < s.slots
+	}
+	shared := s.slots - s.reserved
+	if shared < 1 {
+		shared = 1
+	}
+	return s.active < shared
+}
+
+// Acquire blocks until a slot is granted to an operation of the given priority and
+// client or the context is cancelled, in which case the context's error is returned.
+// Every successful Acquire must be followed by a Release.
+func (s *Scheduler) Acquire(c context.Context, p Priority, client string) error {
+	if p >= numPriorities {
+		p = PriorityBackground
+	}
+	if c == nil {
+		c = context.Background()
+	}
+	s.mu.Lock()
+	if s.waiting == 0 && s.canGrant(p) {
+		s.active++
+		s.classes[p].pass += priorityStrides[p]
+		s.mu.Unlock()
+		return nil
+	}
+	w := &schedWaiter{ready: make(chan struct{})}
+	s.enqueue(p, client, w)
+	s.dispatch() // other waiters may only be held back by reserved slots.
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-c.Done():
+		s.mu.Lock()
+		if w.granted {
+			s.active--
+			s.dispatch()
+		} else {
+			s.remove(p, client, w)
+		}
+		s.mu.Unlock()
+		return c.Err()
+	}
+}
+
+// Release frees a slot granted by Acquire.
+func (s *Scheduler) Release() {
+	s.mu.Lock()
+	s.active--
+	s.dispatch()
+	s.mu.Unlock()
+}
+
+// enqueue adds a waiter.  A class that was idle starts no earlier than the virtual time
+// of the busy classes so it can't use credit accumulated while idle to monopolize slots.
+func (s *Scheduler) enqueue(p Priority, client string, w *schedWaiter) {
+	class := &s.classes[p]
+	if len(class.clients) == 0 {
+		var vtime uint64
+		var busy bool
+		for i := range s.classes {
+			if other := &s.classes[i]; len(other.clients) != 0 && (!busy || other.pass < vtime) {
+				vtime, busy = other.pass, true
+			}
+		}
+		if busy && vtime > class.pass {
+			class.pass = vtime
+		}
+	}
+	if len(class.waiters[client]) == 0 {
+		class.clients = append(class.clients, client)
+	}
+	class.waiters[client] = append(class.waiters[client], w)
+	s.waiting++
+}
+
+// remove deletes a waiter that was not granted a slot.
+func (s *Scheduler) remove(p Priority, client string, w *schedWaiter) {
+	class := &s.classes[p]
+	queue := class.waiters[client]
+	for i, other := range queue {
+		if other == w {
+			queue = append(queue[:i], queue[i+1:]...)
+			s.waiting--
+			break
+		}
+	}
+	if len(queue) != 0 {
+		class.waiters[client] = queue
+		return
+	}
+	delete(class.waiters, client)
+	for i, other := range class.clients {
+		if other == client {
+			class.clients = append(class.clients[:i], class.clients[i+1:]...)
+			break
+		}
+	}
+}
+
+// dispatch grants free slots to waiters, picking the class that would finish its next
+// grant at the earliest virtual time among those that can use a free slot, and the next
+// client of that class in round-robin order.
+func (s *Scheduler) dispatch() {
+	for s.waiting > 0 && s.active < s.slots {
+		var class *schedClass
+		var p Priority
+		var finish uint64
+		for i := range s.classes {
+			c := &s.classes[i]
+			if len(c.clients) == 0 || !s.canGrant(Priority(i)) {
+				continue
+			}
+			if f := c.pass + priorityStrides[i]; class == nil || f < finish {
+				class, p, finish = c, Priority(i), f
+			}
+		}
+		if class == nil {
+			return
+		}
+		client := class.clients[0]
+		queue := class.waiters[client]
+		w := queue[0]
+		class.clients = class.clients[1:]
+		if len(queue) > 1 {
+			class.waiters[client] = queue[1:]
+			class.clients = append(class.clients, client)
+		} else {
+			delete(class.waiters, client)
+		}
+		class.pass += priorityStrides[p]
+		s.waiting--
+		s.active++
+		w.granted = true
+		close(w.ready)
+	}
+}
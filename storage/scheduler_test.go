@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	s := NewScheduler(1)
+	bg := context.Background()
+	if err := s.Acquire(bg, PriorityBulk, "ingest"); err != nil {
+		t.Fatalf("error on acquire: %v\n", err)
+	}
+
+	// Queue many bulk requests from one client before a few interactive requests.
+	order := make(chan string, 20)
+	wait := func(p Priority, client string) {
+		if err := s.Acquire(bg, p, client); err != nil {
+			t.Errorf("error on acquire: %v\n", err)
+			return
+		}
+		order <- p.String() + " " + client
+		s.Release()
+	}
+	for i := 0; i < 10; i++ {
+		go wait(PriorityBulk, "ingest")
+	}
+	time.Sleep(10 * time.Millisecond)
+	go wait(PriorityBulk, "export")
+	go wait(PriorityInteractive, "viewer")
+	go wait(PriorityInteractive, "viewer")
+	time.Sleep(10 * time.Millisecond)
+
+	// A cancelled request should give up its place in the queue.
+	cancelled, cancel := context.WithCancel(bg)
+	errs := make(chan error)
+	go func() {
+		errs <- s.Acquire(cancelled, PriorityInteractive, "viewer")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected cancelled acquire, got %v\n", err)
+	}
+
+	s.Release()
+	var got []string
+	for i := 0; i < 13; i++ {
+		got = append(got, <-order)
+	}
+	if got[0] != "interactive viewer" || got[1] != "interactive viewer" {
+		t.Fatalf("expected interactive requests first, got %v\n", got)
+	}
+	for i, o := range got {
+		if o == "bulk export" {
+			if i > 3 {
+				t.Fatalf("expected bulk client to get a turn after one other bulk request, got %v\n", got)
+			}
+			break
+		}
+	}
+	if err := s.Acquire(bg, PriorityNormal, ""); err != nil {
+		t.Fatalf("error on acquire: %v\n", err)
+	}
+	s.Release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != 0 || s.waiting != 0 {
+		t.Fatalf("expected idle scheduler, got %d active and %d waiting\n", s.active, s.waiting)
+	}
+}
+
+func TestSchedulerReserved(t *testing.T) {
+	s := NewScheduler(2)
+	s.SetReserved(1)
+	bg := context.Background()
+
+	// Bulk and background operations share one slot, leaving the other for interactive ones.
+	if err := s.Acquire(bg, PriorityBulk, "ingest"); err != nil {
+		t.Fatalf("error on acquire: %v\n", err)
+	}
+	granted := make(chan Priority, 2)
+	for _, p := range []Priority{PriorityBackground, PriorityBulk} {
+		go func(p Priority) {
+			if err := s.Acquire(bg, p, "job"); err != nil {
+				t.Errorf("error on acquire: %v\n", err)
+				return
+			}
+			granted <- p
+		}(p)
+	}
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case p := <-granted:
+		t.Fatalf("expected %s operation to wait for shared slot\n", p)
+	default:
+	}
+
+	timeout, cancel := context.WithTimeout(bg, time.Second)
+	defer cancel()
+	if err := s.Acquire(timeout, PriorityInteractive, "viewer"); err != nil {
+		t.Fatalf("expected interactive operation to use reserved slot, got %v\n", err)
+	}
+	s.Release()
+
+	// Once the shared slot is released, waiting operations get it by priority.
+	s.Release()
+	if p := <-granted; p != PriorityBulk {
+		t.Fatalf("expected bulk before background operation, got %s\n", p)
+	}
+	s.Release()
+	if p := <-granted; p != PriorityBackground {
+		t.Fatalf("expected background operation, got %s\n", p)
+	}
+	s.Release()
+	if p, err := ParsePriority("background"); err != nil || p != PriorityBackground {
+		t.Fatalf("expected background priority, got %s, %v\n", p, err)
+	}
+}
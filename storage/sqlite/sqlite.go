@@ -0,0 +1,990 @@
+// +build sqlite
+
+/*
+	Package sqlite implements an ordered key-value storage engine on top of a single
+	SQLite database file in WAL mode.  It is intended for laptops and continuous
+	integration where building a leveldb variant is inconvenient.
+
+	All key-value pairs are held in one table with a BLOB primary key.  SQLite compares
+	BLOBs with memcmp(), so range queries become BETWEEN scans that return keys in the
+	same order as other DVID ordered key-value stores.
+*/
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// Number of milliseconds a connection waits on a locked database before failing.
+	DefaultBusyTimeout = 5000
+
+	// If Sync=true, each committed transaction is flushed to stable storage.  With
+	// WAL mode and synchronous=NORMAL, a power loss may roll back the most recent
+	// transactions but the database remains consistent.
+	DefaultSync = false
+
+	createTable = "CREATE TABLE IF NOT EXISTS kv (k BLOB PRIMARY KEY, v BLOB) WITHOUT ROWID"
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in sqlite: %v\n", err)
+	}
+	e := Engine{"sqlite", "SQLite single-file database", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return false
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a sqlite store. The passed Config must contain "path" string
+// giving the database file.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newSQLiteDB(config)
+}
+
+func parseConfig(config dvid.StoreConfig) (path string, testing bool, err error) {
+	c := config.GetAll()
+
+	v, found := c["path"]
+	if !found {
+		err = fmt.Errorf("%q must be specified for sqlite configuration", "path")
+		return
+	}
+	var ok bool
+	path, ok = v.(string)
+	if !ok {
+		err = fmt.Errorf("%q setting must be a string (%v)", "path", v)
+		return
+	}
+	v, found = c["testing"]
+	if found {
+		testing, ok = v.(bool)
+		if !ok {
+			err = fmt.Errorf("%q setting must be a bool (%v)", "testing", v)
+			return
+		}
+	}
+	if testing {
+		path = filepath.Join(os.TempDir(), path)
+	}
+	return
+}
+
+// dataSourceName returns the go-sqlite3 DSN for the database file with pragmas
+// set from the store configuration.
+func dataSourceName(path string, c map[string]interface{}) (string, error) {
+	sync := DefaultSync
+	if v, found := c["sync"]; found {
+		var ok bool
+		if sync, ok = v.(bool); !ok {
+			return "", fmt.Errorf("%q setting must be a bool (%v)", "sync", v)
+		}
+	}
+	synchronous := "NORMAL"
+	if sync {
+		synchronous = "FULL"
+	}
+	busyTimeout := int64(DefaultBusyTimeout)
+	if v, found := c["busytimeout"]; found {
+		switch t := v.(type) {
+		case int64:
+			busyTimeout = t
+		case int:
+			busyTimeout = int64(t)
+		default:
+			return "", fmt.Errorf("%q setting must be an integer # of milliseconds (%v)", "busytimeout", v)
+		}
+	}
+	return fmt.Sprintf("file:%s?_journal_mode=WAL&_synchronous=%s&_busy_timeout=%d", path, synchronous, busyTimeout), nil
+}
+
+// newSQLiteDB returns a sqlite backend, creating the database file if it doesn't
+// already exist.
+func (e Engine) newSQLiteDB(config dvid.StoreConfig) (*SQLiteDB, bool, error) {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var created bool
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		dvid.Infof("Database not already at path (%s). Creating...\n", path)
+		created = true
+		if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil {
+			return nil, true, fmt.Errorf("Can't make directory for %s: %v", path, err)
+		}
+	}
+
+	dsn, err := dataSourceName(path, config.GetAll())
+	if err != nil {
+		return nil, false, err
+	}
+	dvid.Infof("Opening sqlite @ path %s\n", path)
+	sdb, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := sdb.Exec(createTable); err != nil {
+		sdb.Close()
+		return nil, false, fmt.Errorf("unable to create key-value table in %s: %v", path, err)
+	}
+	db := &SQLiteDB{
+		path:   path,
+		config: config,
+		sdb:    sdb,
+	}
+
+	// if we know it's newly created, just return.
+	if created {
+		return db, created, nil
+	}
+
+	// otherwise, check if there's been any metadata or we need to initialize it.
+	metadataExists, err := db.metadataExists()
+	if err != nil {
+		db.Close()
+		return nil, false, err
+	}
+	return db, !metadataExists, nil
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets sqlite as the default key-value backend.  If another
+// engine is already set, it returns an error since only one key-value backend should
+// be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("sqlite can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	if backend.Metadata != "" {
+		return fmt.Errorf("sqlite can't be testable key-value.  Metadata already set to %s", backend.Metadata)
+	}
+	alias := storage.Alias("sqlite")
+	backend.Metadata = alias
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"path":    fmt.Sprintf("dvid-test-sqlite-%x.db", uuid.NewV4().Bytes()),
+		"testing": true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "sqlite"}
+	return nil
+}
+
+// Delete implements the TestableEngine interface by providing a way to dispose
+// of testing databases.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Delete the database file and any WAL or shared-memory files.
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Can't delete old datastore %q: %v", path+suffix, err)
+		}
+	}
+	return nil
+}
+
+// --- The SQLite implementation must satisfy a Engine interface ----
+
+type SQLiteDB struct {
+	// Path to database file
+	path string
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+
+	sdb *sql.DB
+}
+
+func (db *SQLiteDB) String() string {
+	return fmt.Sprintf("sqlite @ %s", db.path)
+}
+
+// Close closes the sqlite database.
+func (db *SQLiteDB) Close() {
+	if db != nil && db.sdb != nil {
+		if err := db.sdb.Close(); err != nil {
+			dvid.Errorf("Error closing %s: %v\n", db, err)
+		}
+		db.sdb = nil
+	}
+}
+
+// Equal returns true if the sqlite db matches the given store configuration.
+func (db *SQLiteDB) Equal(config dvid.StoreConfig) bool {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.path == path
+}
+
+func (db *SQLiteDB) metadataExists() (bool, error) {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	var n int
+	err := db.sdb.QueryRow("SELECT COUNT(*) FROM (SELECT k FROM kv WHERE k BETWEEN ? AND ? LIMIT 1)", []byte(keyBeg), []byte(keyEnd)).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		dvid.Infof("No metadata found for %s...\n", db)
+	}
+	return n != 0, nil
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *SQLiteDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call Get on nil SQLiteDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+
+		// Get all versions of this key and return the most recent
+		values, err := db.getSingleKeyVersions(vctx, tk, false)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			return kv.V, err
+		}
+		return nil, err
+	}
+	key := ctx.ConstructKey(tk)
+	var v []byte
+	err := db.sdb.QueryRow("SELECT v FROM kv WHERE k = ?", []byte(key)).Scan(&v)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		v = []byte{}
+	}
+	storage.StoreValueBytesRead <- len(v)
+	return v, nil
+}
+
+// Exists returns true if a value is stored for the key without reading the value.
+func (db *SQLiteDB) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists on nil SQLiteDB")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		versions, err := db.getSingleKeyVersions(vctx, tk, true)
+		if err != nil {
+			return false, err
+		}
+		kv, err := vctx.VersionedKeyValue(versions)
+		return kv != nil, err
+	}
+	var n int
+	err := db.sdb.QueryRow("SELECT COUNT(*) FROM kv WHERE k = ?", []byte(ctx.ConstructKey(tk))).Scan(&n)
+	return n != 0, err
+}
+
+// getSingleKeyVersions returns all versions of a key, without values if keysOnly is true.
+// These key-value pairs will be sorted in ascending key order and could include a tombstone
+// key.
+func (db *SQLiteDB) getSingleKeyVersions(vctx storage.VersionedCtx, tk []byte, keysOnly bool) ([]*storage.KeyValue, error) {
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	query := "SELECT k, v FROM kv WHERE k BETWEEN ? AND ? ORDER BY k"
+	if keysOnly {
+		query = "SELECT k, NULL FROM kv WHERE k BETWEEN ? AND ? ORDER BY k"
+	}
+	rows, err := db.sdb.Query(query, []byte(begKey), []byte(endKey))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []*storage.KeyValue{}
+	for rows.Next() {
+		var k, v []byte
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		storage.StoreKeyBytesRead <- len(k)
+		storage.StoreValueBytesRead <- len(v)
+		values = append(values, &storage.KeyValue{K: k, V: v})
+	}
+	return values, rows.Err()
+}
+
+type errorableKV struct {
+	*storage.KeyValue
+	error
+}
+
+func sendKV(vctx storage.VersionedCtx, values []*storage.KeyValue, ch chan errorableKV) {
+	if len(values) != 0 {
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil {
+			ch <- errorableKV{nil, err}
+			return
+		}
+		if kv != nil {
+			ch <- errorableKV{kv, nil}
+		}
+	}
+}
+
+// scanRange returns rows with keys in [begKey, endKey] in ascending key order.
+func (db *SQLiteDB) scanRange(begKey, endKey storage.Key, keysOnly bool) (*sql.Rows, error) {
+	if keysOnly {
+		return db.sdb.Query("SELECT k, NULL FROM kv WHERE k BETWEEN ? AND ? ORDER BY k", []byte(begKey), []byte(endKey))
+	}
+	return db.sdb.Query("SELECT k, v FROM kv WHERE k BETWEEN ? AND ? ORDER BY k", []byte(begKey), []byte(endKey))
+}
+
+// versionedRange sends a range of key-value pairs for a particular version down a channel.
+func (db *SQLiteDB) versionedRange(vctx storage.VersionedCtx, begTKey, endTKey storage.TKey, ch chan errorableKV, done <-chan struct{}, keysOnly bool) {
+	minKey, err := vctx.MinVersionKey(begTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxKey, err := vctx.MaxVersionKey(endTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(begTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+
+	rows, err := db.scanRange(minKey, maxKey, keysOnly)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	defer rows.Close()
+
+	values := []*storage.KeyValue{}
+	for rows.Next() {
+		select {
+		case <-done: // only happens if we don't care about rest of data.
+			ch <- errorableKV{nil, nil}
+			return
+		default:
+		}
+		var itKey, itValue []byte
+		if err := rows.Scan(&itKey, &itValue); err != nil {
+			ch <- errorableKV{nil, err}
+			return
+		}
+		storage.StoreKeyBytesRead <- len(itKey)
+		storage.StoreValueBytesRead <- len(itValue)
+
+		// Did we pass all versions for last key read?
+		if bytes.Compare(itKey, maxVersionKey) > 0 {
+			indexBytes, err := storage.TKeyFromKey(itKey)
+			if err != nil {
+				ch <- errorableKV{nil, err}
+				return
+			}
+			maxVersionKey, err = vctx.MaxVersionKey(indexBytes)
+			if err != nil {
+				ch <- errorableKV{nil, err}
+				return
+			}
+			sendKV(vctx, values, ch)
+			values = []*storage.KeyValue{}
+		}
+		values = append(values, &storage.KeyValue{K: itKey, V: itValue})
+	}
+	if err := rows.Err(); err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	sendKV(vctx, values, ch)
+	ch <- errorableKV{nil, nil}
+}
+
+// unversionedRange sends a range of key-value pairs down a channel.
+func (db *SQLiteDB) unversionedRange(ctx storage.Context, begTKey, endTKey storage.TKey, ch chan errorableKV, done <-chan struct{}, keysOnly bool) {
+	rows, err := db.scanRange(ctx.ConstructKey(begTKey), ctx.ConstructKey(endTKey), keysOnly)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itKey, itValue []byte
+		if err := rows.Scan(&itKey, &itValue); err != nil {
+			ch <- errorableKV{nil, err}
+			return
+		}
+		storage.StoreKeyBytesRead <- len(itKey)
+		storage.StoreValueBytesRead <- len(itValue)
+		select {
+		case <-done:
+			ch <- errorableKV{nil, nil}
+			return
+		case ch <- errorableKV{&storage.KeyValue{K: itKey, V: itValue}, nil}:
+		}
+	}
+	if err := rows.Err(); err != nil {
+		ch <- errorableKV{nil, err}
+	} else {
+		ch <- errorableKV{nil, nil}
+	}
+}
+
+// rangeQuery launches a possibly versioned range query in a goroutine.
+func (db *SQLiteDB) rangeQuery(ctx storage.Context, kStart, kEnd storage.TKey, done <-chan struct{}, keysOnly bool) chan errorableKV {
+	ch := make(chan errorableKV)
+	go func() {
+		if !ctx.Versioned() {
+			db.unversionedRange(ctx, kStart, kEnd, ch, done, keysOnly)
+		} else {
+			db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, keysOnly)
+		}
+	}()
+	return ch
+}
+
+// drain consumes any remaining results so the range goroutine can exit.
+func drain(ch chan errorableKV) {
+	go func() {
+		for result := range ch {
+			if result.KeyValue == nil {
+				return
+			}
+		}
+	}()
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *SQLiteDB) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil SQLiteDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	// Consume the keys.
+	values := []storage.TKey{}
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return nil, result.error
+			}
+			return values, nil
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return nil, err
+		}
+		values = append(values, tk)
+	}
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *SQLiteDB) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, kch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil SQLiteDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	// Consume the keys.
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			kch <- nil
+			return result.error
+		}
+		kch <- result.KeyValue.K
+	}
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *SQLiteDB) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil SQLiteDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, false)
+
+	// Consume the key-value pairs.
+	values := []*storage.TKeyValue{}
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return nil, result.error
+			}
+			return values, nil
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return nil, err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: result.KeyValue.V})
+	}
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *SQLiteDB) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil SQLiteDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	f = storage.CancelableChunkFunc(ctx, f)
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, false)
+
+	// Consume the key-value pairs.
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			return result.error
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return err
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tkv := storage.TKeyValue{K: tk, V: result.KeyValue.V}
+		chunk := &storage.Chunk{ChunkOp: op, TKeyValue: &tkv}
+		if err := f(chunk); err != nil {
+			drain(ch)
+			return err
+		}
+	}
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (db *SQLiteDB) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil SQLiteDB")
+	}
+	rows, err := db.scanRange(kStart, kEnd, keysOnly)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itKey, itValue []byte
+		if err := rows.Scan(&itKey, &itValue); err != nil {
+			return err
+		}
+		storage.StoreKeyBytesRead <- len(itKey)
+		storage.StoreValueBytesRead <- len(itValue)
+		select {
+		case out <- &storage.KeyValue{K: itKey, V: itValue}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	out <- nil
+	return rows.Err()
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *SQLiteDB) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil SQLiteDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Put(tk, v)
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of Put: %v\n", err)
+		return fmt.Errorf("Error on batch commit of Put: %v", err)
+	}
+	return nil
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *SQLiteDB) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil SQLiteDB")
+	}
+	if _, err := db.sdb.Exec("INSERT OR REPLACE INTO kv (k, v) VALUES (?, ?)", []byte(k), v); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *SQLiteDB) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil SQLiteDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of Delete: %v\n", err)
+		return fmt.Errorf("Error on batch commit of Delete: %v", err)
+	}
+	return nil
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *SQLiteDB) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil SQLiteDB")
+	}
+	_, err := db.sdb.Exec("DELETE FROM kv WHERE k = ?", []byte(k))
+	return err
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+func (db *SQLiteDB) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange on nil SQLiteDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of PutRange: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.
+func (db *SQLiteDB) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil SQLiteDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch := db.rangeQuery(ctx, kStart, kEnd, done, true)
+
+	batch := db.NewBatch(ctx)
+	numKV := 0
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				return result.error
+			}
+			break
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			drain(ch)
+			return err
+		}
+		batch.Delete(tk)
+		numKV++
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of DeleteRange: %v\n", err)
+		return fmt.Errorf("Error on batch commit of DeleteRange: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", numKV, ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+func (db *SQLiteDB) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil SQLiteDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	if allVersions {
+		minKey, maxKey := ctx.KeyRange()
+		res, err := db.sdb.Exec("DELETE FROM kv WHERE k >= ? AND k < ?", []byte(minKey), []byte(maxKey))
+		if err != nil {
+			return fmt.Errorf("Error on DELETE ALL for %s: %v", ctx, err)
+		}
+		numKV, _ := res.RowsAffected()
+		dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", numKV, ctx)
+		return nil
+	}
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if !versioned {
+		return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+	}
+	return db.deleteSingleVersion(vctx)
+}
+
+func (db *SQLiteDB) deleteSingleVersion(vctx storage.VersionedCtx) error {
+	minKey, err := vctx.MinVersionKey(storage.MinTKey(storage.TKeyMinClass))
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(storage.MaxTKey(storage.TKeyMaxClass))
+	if err != nil {
+		return err
+	}
+	rows, err := db.scanRange(minKey, maxKey, true)
+	if err != nil {
+		return err
+	}
+	deleteVersion := vctx.VersionID()
+	var keys [][]byte
+	for rows.Next() {
+		var itKey, itValue []byte
+		if err := rows.Scan(&itKey, &itValue); err != nil {
+			rows.Close()
+			return err
+		}
+		_, v, _, err := storage.DataKeyToLocalIDs(itKey)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("Error on DELETE ALL for version %d: %v", deleteVersion, err)
+		}
+		if v == deleteVersion {
+			keys = append(keys, itKey)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Error scanning during DeleteAll for %s: %v", vctx, err)
+	}
+
+	tx, err := db.sdb.Begin()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, err := tx.Exec("DELETE FROM kv WHERE k = ?", k); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Error on DELETE ALL for %s: %v", vctx, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		dvid.Criticalf("Error on commit of DeleteAll: %v\n", err)
+		return fmt.Errorf("Error on commit of DeleteAll: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", len(keys), vctx)
+	return nil
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+type goBatch struct {
+	db   *SQLiteDB
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	ops  []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes.  The batch is
+// committed as a single SQLite transaction.
+func (db *SQLiteDB) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil SQLiteDB\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	var vctx storage.VersionedCtx
+	if ctx.Versioned() {
+		vctx, _ = ctx.(storage.VersionedCtx)
+	}
+	return &goBatch{db: db, ctx: ctx, vctx: vctx}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, value: dvid.EmptyValue()})
+	}
+	batch.ops = append(batch.ops, batchOp{key: key, delete: true})
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, delete: true})
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.ops = append(batch.ops, batchOp{key: key, value: v})
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	tx, err := batch.db.sdb.Begin()
+	if err != nil {
+		return err
+	}
+	putStmt, err := tx.Prepare("INSERT OR REPLACE INTO kv (k, v) VALUES (?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer putStmt.Close()
+	delStmt, err := tx.Prepare("DELETE FROM kv WHERE k = ?")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer delStmt.Close()
+	for _, op := range batch.ops {
+		if op.delete {
+			_, err = delStmt.Exec(op.key)
+		} else {
+			_, err = putStmt.Exec(op.key, op.value)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	batch.ops = nil
+	return tx.Commit()
+}
@@ -0,0 +1,151 @@
+// +build sqlite
+
+package sqlite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/storage/storetest"
+)
+
+func testConfig(c map[string]interface{}) dvid.StoreConfig {
+	var config dvid.Config
+	config.SetAll(c)
+	return dvid.StoreConfig{Config: config, Engine: "sqlite"}
+}
+
+func TestSQLiteConfig(t *testing.T) {
+	path, testing, err := parseConfig(testConfig(map[string]interface{}{"path": "/data/dvid.db"}))
+	if err != nil || path != "/data/dvid.db" || testing {
+		t.Errorf("bad parsed configuration %q, %t: %v\n", path, testing, err)
+	}
+	path, testing, err = parseConfig(testConfig(map[string]interface{}{"path": "dvid.db", "testing": true}))
+	if err != nil || path != filepath.Join(os.TempDir(), "dvid.db") || !testing {
+		t.Errorf("expected test store in temp directory, got %q, %t: %v\n", path, testing, err)
+	}
+	for _, c := range []map[string]interface{}{{}, {"path": 1}, {"path": "dvid.db", "testing": "yes"}} {
+		if _, _, err := parseConfig(testConfig(c)); err == nil {
+			t.Errorf("expected error parsing configuration %v\n", c)
+		}
+	}
+
+	tests := []struct {
+		c   map[string]interface{}
+		dsn string
+	}{
+		{map[string]interface{}{}, "file:dvid.db?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000"},
+		{map[string]interface{}{"sync": true, "busytimeout": int64(100)}, "file:dvid.db?_journal_mode=WAL&_synchronous=FULL&_busy_timeout=100"},
+		{map[string]interface{}{"busytimeout": 20}, "file:dvid.db?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=20"},
+	}
+	for _, test := range tests {
+		dsn, err := dataSourceName("dvid.db", test.c)
+		if err != nil || dsn != test.dsn {
+			t.Errorf("expected DSN %q for %v, got %q: %v\n", test.dsn, test.c, dsn, err)
+		}
+	}
+	for _, c := range []map[string]interface{}{{"sync": "yes"}, {"busytimeout": "5s"}} {
+		if _, err := dataSourceName("dvid.db", c); err == nil {
+			t.Errorf("expected error getting DSN for %v\n", c)
+		}
+	}
+}
+
+// checkBlobKeys checks that the database is in WAL mode, that keys are ordered by
+// unsigned bytes as in other ordered stores, and that empty values aren't read as missing.
+func checkBlobKeys(store dvid.Store) error {
+	db := store.(*SQLiteDB)
+	var mode string
+	if err := db.sdb.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		return fmt.Errorf("expected WAL journal mode, got %q: %v", mode, err)
+	}
+
+	ctx := storetest.NewDataContext(10, 1)
+	keys := []storage.TKey{
+		storage.NewTKey(1, []byte{0x01}),
+		storage.NewTKey(1, []byte{0x7f}),
+		storage.NewTKey(1, []byte{0x80}),
+		storage.NewTKey(1, []byte{0xff, 0x00}),
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		if err := db.Put(ctx, keys[i], nil); err != nil {
+			return fmt.Errorf("error on put: %v", err)
+		}
+	}
+	defer db.DeleteAll(ctx, true)
+
+	found, err := db.KeysInRange(ctx, storage.MinTKey(1), storage.MaxTKey(1))
+	if err != nil {
+		return fmt.Errorf("error on keys in range: %v", err)
+	}
+	if len(found) != len(keys) {
+		return fmt.Errorf("expected %d keys, got %d", len(keys), len(found))
+	}
+	for i, tk := range found {
+		if string(tk) != string(keys[i]) {
+			return fmt.Errorf("expected key %v at position %d, got %v", keys[i], i, tk)
+		}
+	}
+	v, err := db.Get(ctx, keys[0])
+	if err != nil || v == nil || len(v) != 0 {
+		return fmt.Errorf("expected empty value, got %v: %v", v, err)
+	}
+	return nil
+}
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.RunEngine(t, "sqlite", []storetest.Check{{Name: "BlobKeys", Run: checkBlobKeys}})
+}
+
+func TestSQLiteReopen(t *testing.T) {
+	var e Engine
+	backend := new(storage.Backend)
+	if err := e.AddTestConfig(backend); err != nil {
+		t.Fatal(err)
+	}
+	config := backend.Stores[backend.DefaultKVDB]
+	path, _, err := parseConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, created, err := e.newSQLiteDB(config)
+	if err != nil {
+		t.Fatalf("unable to open test store: %v\n", err)
+	}
+	if !created {
+		t.Errorf("expected new store to need metadata\n")
+	}
+	var ctx storage.MetadataContext
+	if err := db.Put(ctx, storage.TKey("a"), []byte("kept")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	db.Close()
+
+	// A reopened store keeps its data and doesn't need metadata initialized.
+	db, created, err = e.newSQLiteDB(config)
+	if err != nil {
+		t.Fatalf("unable to reopen test store: %v\n", err)
+	}
+	if created {
+		t.Errorf("expected reopened store to have metadata\n")
+	}
+	if v, err := db.Get(ctx, storage.TKey("a")); err != nil || string(v) != "kept" {
+		t.Errorf("expected value after reopening, got %q: %v\n", v, err)
+	}
+	db.Close()
+
+	// Deleting the store removes the database and its WAL files.
+	if err := e.Delete(config); err != nil {
+		t.Fatalf("unable to delete test store: %v\n", err)
+	}
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if _, err := os.Stat(path + suffix); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be deleted\n", path+suffix)
+		}
+	}
+}
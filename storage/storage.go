@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/janelia-flyem/dvid/dvid"
@@ -31,7 +33,29 @@ type Backend struct {
 	Stores      map[Alias]dvid.StoreConfig
 	KVStore     map[dvid.DataSpecifier]Alias
 	LogStore    map[dvid.DataSpecifier]Alias
+	Tiers       map[dvid.DataSpecifier]TierConfig
 	Groupcache  GroupcacheConfig
+	LRUCache    LRUCacheConfig
+	WriteBack   WriteBackConfig
+	Encryption  EncryptionConfig
+	Dedup       DedupConfig
+	Clone       CloneConfig
+	Chunk       ChunkConfig
+
+	// TrackMutations indexes the keys changed by each write so incremental backups
+	// can copy only changed key-value pairs.
+	TrackMutations bool
+
+	// StoreMetrics wraps stores to record counts, bytes, and latencies of operations
+	// for each data instance.  See GetStoreMetrics.
+	StoreMetrics bool
+
+	// Tracing wraps stores to record a trace span for each operation within a traced
+	// request.  See dvid.StartTracing.
+	Tracing bool
+
+	// Compaction schedules periodic compaction of stores.
+	Compaction CompactionConfig
 }
 
 // StoreConfig returns a data specifier's assigned store configuration.
@@ -146,6 +170,18 @@ func GetTestableEngine() TestableEngine {
 	return nil
 }
 
+// GetTestableEngines returns all testable engines sorted by name.
+func GetTestableEngines() []TestableEngine {
+	var engines []TestableEngine
+	for _, e := range availEngines {
+		if testableEng, ok := e.(TestableEngine); ok {
+			engines = append(engines, testableEng)
+		}
+	}
+	sort.Slice(engines, func(i, j int) bool { return engines[i].GetName() < engines[j].GetName() })
+	return engines
+}
+
 // GetTestableBackend returns a storage backend that combines all testable engine configurations.
 func GetTestableBackend() (*Backend, error) {
 	var found bool
@@ -196,6 +232,44 @@ type SizeViewer interface {
 	GetApproximateSizes(ranges []KeyRange) ([]uint64, error)
 }
 
+// Compacter stores are able to compact a range of Key, reclaiming the space used by
+// deleted and overwritten key-value pairs.  This is typically implemented by LSM engines.
+type Compacter interface {
+	Compact(r KeyRange) error
+}
+
+// Compact compacts a range of keys in the store, which must support the Compacter interface.
+func Compact(store dvid.Store, r KeyRange) error {
+	c, ok := store.(Compacter)
+	if !ok {
+		return fmt.Errorf("store %s does not support compaction", store)
+	}
+	return c.Compact(r)
+}
+
+// ValueSizeLimiter stores declare the largest value they can store, or store efficiently.
+// Larger values are transparently split into chunks (see ChunkConfig).
+type ValueSizeLimiter interface {
+	MaxValueSize() int
+}
+
+// Cloner is a store that can clone all key-value pairs of one data instance to a new
+// data instance without copying them.  The clone must be in the same repo as the
+// source since they share version IDs.
+type Cloner interface {
+	CloneInstance(src, dst dvid.InstanceID) error
+}
+
+// CloneInstance clones the data of one instance to a new, empty instance in the store,
+// which must support the Cloner interface.
+func CloneInstance(store dvid.Store, src, dst dvid.InstanceID) error {
+	c, ok := store.(Cloner)
+	if !ok {
+		return fmt.Errorf("store %s does not support cloning of data instances", store)
+	}
+	return c.CloneInstance(src, dst)
+}
+
 // GetDataSizes returns a list of storage sizes in bytes for each data instance in the store.
 // A list of InstanceID can be optionally supplied so only those instances are queried.
 // This requires some scanning of the database so could take longer than normal requests,
@@ -235,3 +309,48 @@ func GetDataSizes(store dvid.Store, instances []dvid.InstanceID) (map[dvid.Insta
 	}
 	return getInstanceSizes(sv, ids)
 }
+
+// GetVersionSizes returns the bytes of keys and values stored for each version of a data
+// instance.  Unlike GetDataSizes, this requires a scan of all the instance's key-value
+// pairs, so it could take a long time for large instances.  Tombstones are included in
+// the bytes for their version.
+func GetVersionSizes(store dvid.Store, instance dvid.InstanceID) (map[dvid.VersionID]uint64, error) {
+	db, ok := store.(OrderedKeyValueGetter)
+	if !ok {
+		return nil, fmt.Errorf("cannot get version sizes for store %s, which is not an OrderedKeyValueGetter store", store)
+	}
+	begKey := constructDataKey(instance, 0, 0, minTKey)
+	endKey := constructDataKey(instance+1, 0, 0, minTKey)
+
+	ch := make(chan *KeyValue, 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queryErr := make(chan error, 1)
+	go func() {
+		queryErr <- db.RawRangeQuery(ctx, begKey, endKey, false, ch)
+		close(ch)
+	}()
+
+	sizes := make(map[dvid.VersionID]uint64)
+	var err error
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		var v dvid.VersionID
+		if _, v, _, err = DataKeyToLocalIDs(kv.K); err != nil {
+			break
+		}
+		sizes[v] += uint64(len(kv.K) + len(kv.V))
+	}
+	if err != nil {
+		cancel()
+		for range ch {
+		}
+		return nil, err
+	}
+	if err := <-queryErr; err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
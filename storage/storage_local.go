@@ -3,6 +3,7 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -36,6 +37,23 @@ type managerT struct {
 
 	// groupcache support
 	gcache groupcacheT
+
+	// read-through LRU cache support
+	lru lruT
+
+	// tiered hot/cold stores, which are not in stores since they have no alias.
+	tiered []*TieredStore
+
+	// write-back buffers, which must be flushed before the wrapped stores are closed.
+	writeback []*WriteBackStore
+
+	// stores whose mutations are indexed for incremental backup.
+	tracked map[Alias]OrderedKeyValueDB
+
+	// stores as opened by their engines, without any wrappers, for compaction.
+	engines map[Alias]dvid.Store
+
+	compaction compactionT
 }
 
 func AllStores() (map[Alias]dvid.Store, error) {
@@ -133,17 +151,61 @@ func GetAssignedStore(dataname dvid.InstanceName, root dvid.UUID, typename dvid.
 			return nil, fmt.Errorf("Cannot get assigned store for data %q, type %q", dataname, typename)
 		}
 	}
+	return wrapStore(store, dataname, root, typename), nil
+}
+
+// SameInstanceAssignment returns true if the backend configuration makes the same store
+// and log assignments for data instances with the given names and root UUID, so a data
+// instance can be renamed without changing the stores it will use after a restart.
+func SameInstanceAssignment(root dvid.UUID, name1, name2 dvid.InstanceName) bool {
+	if !manager.setup {
+		return true
+	}
+	dataid1 := dvid.GetDataSpecifier(name1, root)
+	dataid2 := dvid.GetDataSpecifier(name2, root)
+	return manager.instanceStore[dataid1] == manager.instanceStore[dataid2] &&
+		manager.instanceLog[dataid1] == manager.instanceLog[dataid2]
+}
+
+// GetAliasedStore returns the store with the given alias for a data instance that was
+// explicitly assigned a store on creation.  Like GetAssignedStore, the returned store may
+// include caching wrappers configured for the data instance or its type.
+func GetAliasedStore(alias Alias, dataname dvid.InstanceName, root dvid.UUID, typename dvid.TypeString) (dvid.Store, error) {
+	store, err := GetStoreByAlias(alias)
+	if err != nil {
+		return nil, err
+	}
+	return wrapStore(store, dataname, root, typename), nil
+}
+
+// wrapStore adds any caching wrappers configured for the data instance or its type.
+func wrapStore(store dvid.Store, dataname dvid.InstanceName, root dvid.UUID, typename dvid.TypeString) dvid.Store {
+	dataid := dvid.GetDataSpecifier(dataname, root)
+	var wrapped dvid.Store
+	var err error
+
+	// See if this is using the LRU cache and if so, establish a wrapper around it.
+	if manager.lru.uses(dataid, typename) {
+		wrapped, err = wrapLRUCache(store, manager.lru.cache)
+		if err != nil {
+			dvid.Errorf("Unable to wrap LRU cache around store %s for data instance %q (uuid %s): %v\n", store, dataname, root, err)
+		} else {
+			store = wrapped
+			dvid.Infof("Returning LRU cache-wrapped store %s for data instance %q @ %s\n", store, dataname, root)
+		}
+	}
 
 	// See if this is using caching and if so, establish a wrapper around it.
 	if _, supported := manager.gcache.supported[dataid]; supported {
-		store, err = wrapGroupcache(store, manager.gcache.cache)
+		wrapped, err = wrapGroupcache(store, manager.gcache.cache)
 		if err != nil {
 			dvid.Errorf("Unable to wrap groupcache around store %s for data instance %q (uuid %s): %v\n", store, dataname, root, err)
 		} else {
+			store = wrapped
 			dvid.Infof("Returning groupcache-wrapped store %s for data instance %q @ %s\n", store, dataname, root)
 		}
 	}
-	return store, nil
+	return store
 }
 
 // assignedStoreByType returns the store assigned to a particular datatype.
@@ -193,6 +255,13 @@ func assignedLogByType(typename dvid.TypeString) (WriteLog, error) {
 // Close handles any storage-specific shutdown procedures.
 func Close() {
 	if manager.setup {
+		manager.compaction.stop()
+		for _, store := range manager.writeback {
+			store.Close()
+		}
+		for _, store := range manager.tiered {
+			store.Close()
+		}
 		for alias, store := range manager.stores {
 			dvid.Infof("Closing store %q: %s...\n", alias, store)
 			store.Close()
@@ -200,6 +269,7 @@ func Close() {
 		manager.setup = false
 	}
 	manager = managerT{}
+	mutationSeq = mutationSeqT{}
 }
 
 // Initialize the storage systems.  Returns a bool + error where the bool is
@@ -207,8 +277,45 @@ func Close() {
 // The map of store configurations should be keyed by either a datatype name,
 // "default", or "metadata".
 func Initialize(cmdline dvid.Config, backend *Backend) (createdMetadata bool, err error) {
+	// Setup encryption at rest for any stores that require it.
+	aead, err := backend.Encryption.newAEAD()
+	if err != nil {
+		return false, fmt.Errorf("bad encryption configuration: %v", err)
+	}
+	encrypted := make(map[Alias]bool, len(backend.Encryption.Stores))
+	for _, alias := range backend.Encryption.Stores {
+		if _, found := backend.Stores[alias]; !found {
+			return false, fmt.Errorf("encryption specified for unknown store %q", alias)
+		}
+		encrypted[alias] = true
+	}
+	recordMetrics, recordSpans = backend.StoreMetrics, backend.Tracing
+	deduped := make(map[Alias]bool, len(backend.Dedup.Stores))
+	for _, alias := range backend.Dedup.Stores {
+		if _, found := backend.Stores[alias]; !found {
+			return false, fmt.Errorf("deduplication specified for unknown store %q", alias)
+		}
+		deduped[alias] = true
+	}
+	chunked := make(map[Alias]bool, len(backend.Chunk.Stores))
+	for _, alias := range backend.Chunk.Stores {
+		if _, found := backend.Stores[alias]; !found {
+			return false, fmt.Errorf("chunking specified for unknown store %q", alias)
+		}
+		chunked[alias] = true
+	}
+	cloned := make(map[Alias]bool, len(backend.Clone.Stores))
+	for _, alias := range backend.Clone.Stores {
+		if _, found := backend.Stores[alias]; !found {
+			return false, fmt.Errorf("cloning specified for unknown store %q", alias)
+		}
+		cloned[alias] = true
+	}
+
 	// Open all the backend stores
 	manager.stores = make(map[Alias]dvid.Store, len(backend.Stores))
+	manager.tracked = make(map[Alias]OrderedKeyValueDB)
+	manager.engines = make(map[Alias]dvid.Store, len(backend.Stores))
 	var gotDefault, gotMetadata, createdDefault, lastCreated bool
 	var lastStore dvid.Store
 	for alias, dbconfig := range backend.Stores {
@@ -223,6 +330,57 @@ func Initialize(cmdline dvid.Config, backend *Backend) (createdMetadata bool, er
 			fmt.Errorf("dbconfig: %v\n", dbconfig)
 			return false, fmt.Errorf("bad store %q: %v", alias, err)
 		}
+		manager.engines[alias] = store
+		if store, err = wrapWriteGuard(store); err != nil {
+			return false, fmt.Errorf("unable to check writes to store %q: %v", alias, err)
+		}
+		if backend.StoreMetrics || backend.Tracing {
+			if wrapped, err := wrapMetrics(store); err != nil {
+				dvid.Infof("Not collecting metrics or spans for store %q: %v\n", alias, err)
+			} else {
+				store = wrapped
+			}
+		}
+		// Chunking must see values after encryption, which enlarges them.
+		maxBytes, err := backend.Chunk.chunkThreshold(manager.engines[alias], chunked[alias])
+		if err != nil {
+			return false, fmt.Errorf("bad chunking of store %q: %v", alias, err)
+		}
+		if maxBytes > 0 {
+			if store, err = wrapChunking(store, maxBytes); err != nil {
+				return false, fmt.Errorf("unable to chunk store %q: %v", alias, err)
+			}
+			dvid.Infof("Splitting values larger than %d bytes into chunks in store %q\n", maxBytes, alias)
+		}
+		if encrypted[alias] {
+			if store, err = wrapEncryption(store, aead); err != nil {
+				return false, fmt.Errorf("unable to encrypt store %q: %v", alias, err)
+			}
+			dvid.Infof("Encrypting values in store %q\n", alias)
+		}
+		// Deduplication must see values before encryption since identical values
+		// are encrypted differently.
+		if deduped[alias] {
+			if store, err = wrapDedup(store, backend.Dedup.MinBytes); err != nil {
+				return false, fmt.Errorf("unable to deduplicate store %q: %v", alias, err)
+			}
+			dvid.Infof("Deduplicating values in store %q\n", alias)
+		}
+		if cloned[alias] {
+			if store, err = wrapClone(store); err != nil {
+				return false, fmt.Errorf("unable to support cloning in store %q: %v", alias, err)
+			}
+			dvid.Infof("Supporting copy-on-write cloning of data instances in store %q\n", alias)
+		}
+		if backend.TrackMutations {
+			if tracked, err := wrapMutationTracking(store); err != nil {
+				dvid.Infof("Not tracking mutations in store %q: %v\n", alias, err)
+			} else {
+				store = tracked
+				manager.tracked[alias] = tracked.(OrderedKeyValueDB)
+				dvid.Infof("Tracking mutations in store %q\n", alias)
+			}
+		}
 		if alias == backend.Metadata {
 			gotMetadata = true
 			createdMetadata = created
@@ -263,11 +421,18 @@ func Initialize(cmdline dvid.Config, backend *Backend) (createdMetadata bool, er
 	dvid.Infof("Default log store: %s\n", manager.defaultLog)
 	dvid.Infof("Metadata store: %s\n", manager.metadataStore)
 
+	if len(manager.tracked) != 0 {
+		if err = mutationSeq.init(manager.metadataStore); err != nil {
+			return false, fmt.Errorf("unable to track mutations: %v", err)
+		}
+	}
+
 	// Setup the groupcache if specified.
 	err = setupGroupcache(backend.Groupcache)
 	if err != nil {
 		return
 	}
+	setupLRUCache(backend.LRUCache)
 
 	// Make all data instance or datatype-specific store assignments.
 	manager.instanceStore = make(map[dvid.DataSpecifier]dvid.Store)
@@ -295,6 +460,72 @@ func Initialize(cmdline dvid.Config, backend *Backend) (createdMetadata bool, er
 			return
 		}
 	}
+	for dataspec, tc := range backend.Tiers {
+		hot, found := manager.stores[tc.Hot]
+		if !found {
+			err = fmt.Errorf("bad backend hot store alias: %q -> %q", dataspec, tc.Hot)
+			return
+		}
+		cold, found := manager.stores[tc.Cold]
+		if !found {
+			err = fmt.Errorf("bad backend cold store alias: %q -> %q", dataspec, tc.Cold)
+			return
+		}
+		var store *TieredStore
+		if store, err = NewTieredStore(hot, cold, tc.DemoteAfter); err != nil {
+			err = fmt.Errorf("bad tiered backend for %q: %v", dataspec, err)
+			return
+		}
+		manager.tiered = append(manager.tiered, store)
+
+		// Tiers override any store assignment for the datatype or data instance.
+		name := strings.Trim(string(dataspec), "\"")
+		parts := strings.Split(name, ":")
+		switch len(parts) {
+		case 1:
+			manager.datatypeStore[dvid.TypeString(name)] = store
+		case 2:
+			dataid := dvid.GetDataSpecifier(dvid.InstanceName(parts[0]), dvid.UUID(parts[1]))
+			manager.instanceStore[dataid] = store
+		default:
+			err = fmt.Errorf("bad backend data specification: %s", dataspec)
+			return
+		}
+		dvid.Infof("Assigned %s to %s\n", store, dataspec)
+	}
+	for _, dataspec := range backend.WriteBack.Instances {
+		name := strings.Trim(dataspec, "\"")
+		parts := strings.Split(name, ":")
+		var store dvid.Store
+		var found bool
+		var dataid dvid.DataSpecifier
+		switch len(parts) {
+		case 1:
+			if store, found = manager.datatypeStore[dvid.TypeString(name)]; !found {
+				store = manager.defaultKV
+			}
+		case 2:
+			dataid = dvid.GetDataSpecifier(dvid.InstanceName(parts[0]), dvid.UUID(parts[1]))
+			if store, found = manager.instanceStore[dataid]; !found {
+				store = manager.defaultKV
+			}
+		default:
+			err = fmt.Errorf("bad write-back data specification: %s", dataspec)
+			return
+		}
+		var wbstore *WriteBackStore
+		if wbstore, err = NewWriteBackStore(store, backend.WriteBack); err != nil {
+			err = fmt.Errorf("bad write-back for %q: %v", dataspec, err)
+			return
+		}
+		manager.writeback = append(manager.writeback, wbstore)
+		if len(parts) == 1 {
+			manager.datatypeStore[dvid.TypeString(name)] = wbstore
+		} else {
+			manager.instanceStore[dataid] = wbstore
+		}
+		dvid.Infof("Assigned %s to %s\n", wbstore, dataspec)
+	}
 	manager.instanceLog = make(map[dvid.DataSpecifier]WriteLog)
 	manager.datatypeLog = make(map[dvid.TypeString]WriteLog)
 	for dataspec, alias := range backend.LogStore {
@@ -328,6 +559,10 @@ func Initialize(cmdline dvid.Config, backend *Backend) (createdMetadata bool, er
 	}
 	manager.setup = true
 
+	if err = setupCompaction(backend.Compaction); err != nil {
+		return
+	}
+
 	// Setup the graph store
 	var store dvid.Store
 	store, err = assignedStoreByType("labelgraph")
@@ -372,6 +607,7 @@ func DeleteDataInstance(data dvid.Data) error {
 
 	dvid.Infof("Starting delete of instance %d: name %q, type %s\n", data.InstanceID(), data.DataName(), data.TypeName())
 	ctx := NewDataContext(data, 0)
+	ctx.SetContext(WithPriority(context.Background(), PriorityBackground, "delete data"))
 	if err := db.DeleteAll(ctx, true); err != nil {
 		return err
 	}
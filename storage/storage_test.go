@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestGetVersionSizes(t *testing.T) {
+	db := &testKVStore{kv: make(map[string][]byte)}
+	data := &testData{instanceID: 1}
+	expected := make(map[dvid.VersionID]uint64)
+	puts := []struct {
+		v  dvid.VersionID
+		tk TKey
+	}{{1, TKey("a")}, {1, TKey("b")}, {2, TKey("c")}}
+	value := []byte("some value")
+	for _, p := range puts {
+		ctx := NewDataContext(data, p.v)
+		db.Put(ctx, p.tk, value)
+		expected[p.v] += uint64(len(ctx.ConstructKey(p.tk)) + len(value))
+	}
+	ctx := NewDataContext(data, 2)
+	db.RawPut(ctx.TombstoneKey(TKey("a")), nil)
+	expected[2] += uint64(len(ctx.TombstoneKey(TKey("a"))))
+
+	// Other instances aren't included.
+	db.Put(NewDataContext(&testData{instanceID: 2}, 1), TKey("a"), []byte("other"))
+
+	sizes, err := GetVersionSizes(db, 1)
+	if err != nil {
+		t.Fatalf("error getting version sizes: %v\n", err)
+	}
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected version sizes %v, got %v\n", expected, sizes)
+	}
+}
@@ -0,0 +1,565 @@
+/*
+	Package storetest checks that a storage engine conforms to the storage interfaces it
+	implements.  The package's tests run the Conformance checks on a store of each
+	testable engine selected by build tags, e.g., "go test -tags pebble", skipping engines
+	backed by a service, e.g., S3 or Postgres, that isn't configured for tests.  Engines
+	give their own checks to RunEngine in their packages' tests.  Engines that can hold the
+	metadata are also tested by the datastore tests via build tags.
+*/
+
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// testData satisfies the dvid.Data interface for the data instances of the checks.
+type testData struct {
+	instanceID dvid.InstanceID
+	dataUUID   dvid.UUID
+	rootUUID   dvid.UUID
+	name       dvid.InstanceName
+	kvStore    dvid.Store
+	mutID      uint64
+}
+
+func newTestData(id dvid.InstanceID) *testData {
+	return &testData{
+		instanceID: id,
+		dataUUID:   dvid.NewUUID(),
+		rootUUID:   dvid.NewUUID(),
+		name:       dvid.InstanceName(fmt.Sprintf("storetest%d", id)),
+	}
+}
+
+func (d *testData) InstanceID() dvid.InstanceID {
+	return d.instanceID
+}
+
+func (d *testData) DataUUID() dvid.UUID {
+	return d.dataUUID
+}
+
+func (d *testData) DataName() dvid.InstanceName {
+	return d.name
+}
+
+func (d *testData) RootUUID() dvid.UUID {
+	return d.rootUUID
+}
+
+func (d *testData) RootVersionID() (dvid.VersionID, error) {
+	return 1, nil
+}
+
+func (d *testData) DAGRootUUID() (dvid.UUID, error) {
+	return d.rootUUID, nil
+}
+
+func (d *testData) TypeName() dvid.TypeString {
+	return "storetest"
+}
+
+func (d *testData) TypeURL() dvid.URLString {
+	return "github.com/janelia-flyem/dvid/storage/storetest"
+}
+
+func (d *testData) TypeVersion() string {
+	return "1.0"
+}
+
+func (d *testData) Versioned() bool {
+	return true
+}
+
+func (d *testData) KVStore() (dvid.Store, error) {
+	return d.kvStore, nil
+}
+
+func (d *testData) NewMutationID() uint64 {
+	return atomic.AddUint64(&d.mutID, 1)
+}
+
+func (d *testData) SetKVStore(kvStore dvid.Store) {
+	d.kvStore = kvStore
+}
+
+func (d *testData) SetInstanceID(id dvid.InstanceID) {
+	d.instanceID = id
+}
+
+func (d *testData) SetDataUUID(uuid dvid.UUID) {
+	d.dataUUID = uuid
+}
+
+func (d *testData) SetName(name dvid.InstanceName) {
+	d.name = name
+}
+
+func (d *testData) SetRootUUID(uuid dvid.UUID) {
+	d.rootUUID = uuid
+}
+
+func (d *testData) SetSync(syncs dvid.UUIDSet) {
+}
+
+// lineageCtx is a versioned data context whose versions are a single branch, so every
+// lower version is an ancestor.
+type lineageCtx struct {
+	*storage.DataContext
+}
+
+func (ctx lineageCtx) Versioned() bool {
+	return true
+}
+
+func (ctx lineageCtx) Head() bool {
+	return true
+}
+
+func (ctx lineageCtx) MasterVersion(v dvid.VersionID) bool {
+	return true
+}
+
+func (ctx lineageCtx) NumVersions() int32 {
+	return int32(ctx.VersionID())
+}
+
+func (ctx lineageCtx) VersionedKeyValue(values []*storage.KeyValue) (*storage.KeyValue, error) {
+	var found *storage.KeyValue
+	var foundV dvid.VersionID
+	for _, kv := range values {
+		v, err := ctx.VersionFromKey(kv.K)
+		if err != nil {
+			return nil, err
+		}
+		if v <= ctx.VersionID() && (found == nil || v > foundV) {
+			found, foundV = kv, v
+		}
+	}
+	if found == nil || found.K.IsTombstone() {
+		return nil, nil
+	}
+	return found, nil
+}
+
+// NewDataContext returns a context for a new data instance with the given ID, so
+// engine-specific checks can construct data keys.
+func NewDataContext(id dvid.InstanceID, v dvid.VersionID) *storage.DataContext {
+	return storage.NewDataContext(newTestData(id), v)
+}
+
+// tk returns a type-specific key for the checks.
+func tk(s string) storage.TKey {
+	return storage.NewTKey(1, []byte(s))
+}
+
+func checkValue(db storage.KeyValueGetter, ctx storage.Context, k string, expected []byte) error {
+	value, err := db.Get(ctx, tk(k))
+	if err != nil {
+		return fmt.Errorf("error on get of %q in %s: %v", k, ctx, err)
+	}
+	if !bytes.Equal(value, expected) {
+		return fmt.Errorf("expected %q for %q in %s, got %q", expected, k, ctx, value)
+	}
+	found, err := db.Exists(ctx, tk(k))
+	if err != nil {
+		return fmt.Errorf("error on exists of %q in %s: %v", k, ctx, err)
+	}
+	if found != (expected != nil) {
+		return fmt.Errorf("expected exists of %q in %s to be %t, got %t", k, ctx, expected != nil, found)
+	}
+	return nil
+}
+
+func checkKeys(db storage.OrderedKeyValueGetter, ctx storage.Context, expected ...string) error {
+	keys, err := db.KeysInRange(ctx, tk("a"), tk("z"))
+	if err != nil {
+		return fmt.Errorf("error on keys in range of %s: %v", ctx, err)
+	}
+	var got []string
+	for _, k := range keys {
+		name, err := k.ClassBytes(1)
+		if err != nil {
+			return err
+		}
+		got = append(got, string(name))
+	}
+	if !reflect.DeepEqual(got, expected) {
+		return fmt.Errorf("expected keys %v in %s, got %v", expected, ctx, got)
+	}
+	return nil
+}
+
+// Check is a named check of a store, returning the first failure.
+type Check struct {
+	Name string
+	Run  func(dvid.Store) error
+}
+
+// Conformance holds the checks of the storage interfaces a store implements: gets and
+// puts in unversioned and versioned contexts and raw puts for a storage.KeyValueDB,
+// range queries for a storage.OrderedKeyValueDB, batches for a storage.KeyValueBatcher,
+// and patches and key locks for a storage.TransactionDB.  Each check passes if the store
+// doesn't implement the interface it checks, and uses a new data instance whose key-value
+// pairs are deleted by the check if the store is ordered.
+var Conformance = []Check{
+	{"KeyValueDB", checkKeyValueDB},
+	{"OrderedKeyValueDB", checkOrderedKeyValueDB},
+	{"KeyValueBatcher", checkBatcher},
+	{"TransactionDB", checkTransactionDB},
+}
+
+// RunEngine opens a store with the test configuration of a registered engine and runs
+// each check as a subtest, deleting the store afterwards.  The test is skipped if the
+// engine's AddTestConfig fails, e.g., because no service is configured for tests, or
+// doesn't add a key-value store.
+func RunEngine(t *testing.T, name string, checks []Check) {
+	e, ok := storage.GetEngine(name).(storage.TestableEngine)
+	if !ok {
+		t.Fatalf("engine %q isn't registered or testable\n", name)
+	}
+	backend := new(storage.Backend)
+	if err := e.AddTestConfig(backend); err != nil {
+		t.Skipf("no test store for engine %q: %v\n", name, err)
+	}
+	if backend.DefaultKVDB == "" {
+		t.Skipf("engine %q has no key-value test store\n", name)
+	}
+	config := backend.Stores[backend.DefaultKVDB]
+	store, _, err := e.NewStore(config)
+	if err != nil {
+		t.Fatalf("unable to open test store for engine %q: %v\n", name, err)
+	}
+	defer func() {
+		store.Close()
+		if err := e.Delete(config); err != nil {
+			t.Errorf("unable to delete test store for engine %q: %v\n", name, err)
+		}
+	}()
+	if _, ok := store.(storage.KeyValueDB); !ok {
+		t.Fatalf("store %s of engine %q isn't a key-value db\n", store, name)
+	}
+	for _, check := range checks {
+		t.Run(check.Name, func(t *testing.T) {
+			if err := check.Run(store); err != nil {
+				t.Errorf("engine %q: %v\n", name, err)
+			}
+		})
+	}
+}
+
+func checkKeyValueDB(store dvid.Store) error {
+	db, ok := store.(storage.KeyValueDB)
+	if !ok {
+		return nil
+	}
+	ctx := storage.NewDataContext(newTestData(1), 1)
+	if err := checkValue(db, ctx, "a", nil); err != nil {
+		return err
+	}
+	if err := db.Put(ctx, tk("a"), []byte("a0")); err != nil {
+		return fmt.Errorf("error on put: %v", err)
+	}
+	if err := checkValue(db, ctx, "a", []byte("a0")); err != nil {
+		return err
+	}
+	if err := db.Put(ctx, tk("a"), []byte("a1")); err != nil {
+		return fmt.Errorf("error on replacing put: %v", err)
+	}
+	if err := checkValue(db, ctx, "a", []byte("a1")); err != nil {
+		return err
+	}
+	if err := db.Delete(ctx, tk("a")); err != nil {
+		return fmt.Errorf("error on delete: %v", err)
+	}
+	if err := checkValue(db, ctx, "a", nil); err != nil {
+		return err
+	}
+
+	// Versions inherit the values of their ancestors until they put or delete their own.
+	data := newTestData(2)
+	v1 := lineageCtx{storage.NewDataContext(data, 1)}
+	v2 := lineageCtx{storage.NewDataContext(data, 2)}
+	v3 := lineageCtx{storage.NewDataContext(data, 3)}
+	if err := db.Put(v1, tk("a"), []byte("a1")); err != nil {
+		return fmt.Errorf("error on versioned put: %v", err)
+	}
+	if err := checkValue(db, v2, "a", []byte("a1")); err != nil {
+		return err
+	}
+	if err := db.Put(v2, tk("a"), []byte("a2")); err != nil {
+		return fmt.Errorf("error on versioned put: %v", err)
+	}
+	if err := db.Delete(v3, tk("a")); err != nil {
+		return fmt.Errorf("error on versioned delete: %v", err)
+	}
+	for _, check := range []struct {
+		ctx      lineageCtx
+		expected []byte
+	}{{v1, []byte("a1")}, {v2, []byte("a2")}, {v3, nil}} {
+		if err := checkValue(db, check.ctx, "a", check.expected); err != nil {
+			return err
+		}
+	}
+	values, err := storage.GetBatch(db, v2, []storage.TKey{tk("b"), tk("a")})
+	if err != nil {
+		return fmt.Errorf("error on get batch: %v", err)
+	}
+	if len(values) != 2 || values[0] != nil || string(values[1]) != "a2" {
+		return fmt.Errorf("expected batch get of missing key and %q in %s, got %q", "a2", v2, values)
+	}
+
+	// Raw puts and deletes use full keys.
+	raw := storage.NewDataContext(newTestData(3), 1)
+	if err := db.RawPut(raw.ConstructKey(tk("r")), []byte("raw")); err != nil {
+		return fmt.Errorf("error on raw put: %v", err)
+	}
+	if err := checkValue(db, raw, "r", []byte("raw")); err != nil {
+		return err
+	}
+	if err := db.RawDelete(raw.ConstructKey(tk("r"))); err != nil {
+		return fmt.Errorf("error on raw delete: %v", err)
+	}
+	if err := checkValue(db, raw, "r", nil); err != nil {
+		return err
+	}
+
+	if ordered, ok := db.(storage.OrderedKeyValueDB); ok {
+		if err := ordered.DeleteAll(ctx, true); err != nil {
+			return fmt.Errorf("error on delete all: %v", err)
+		}
+		if err := ordered.DeleteAll(v1, true); err != nil {
+			return fmt.Errorf("error on delete all versions: %v", err)
+		}
+	}
+	return nil
+}
+
+func checkOrderedKeyValueDB(store dvid.Store) error {
+	db, ok := store.(storage.OrderedKeyValueDB)
+	if !ok {
+		return nil
+	}
+	ctx := storage.NewDataContext(newTestData(4), 1)
+	kvs := []storage.TKeyValue{
+		{K: tk("b"), V: []byte("b")},
+		{K: tk("c"), V: []byte("c")},
+		{K: tk("d"), V: []byte("d")},
+	}
+	if err := db.PutRange(ctx, kvs); err != nil {
+		return fmt.Errorf("error on put range: %v", err)
+	}
+	if err := checkKeys(db, ctx, "b", "c", "d"); err != nil {
+		return err
+	}
+	values, err := db.GetRange(ctx, tk("a"), tk("z"))
+	if err != nil {
+		return fmt.Errorf("error on get range: %v", err)
+	}
+	if len(values) != len(kvs) {
+		return fmt.Errorf("expected %d key-value pairs in range, got %d", len(kvs), len(values))
+	}
+	for i, kv := range values {
+		if !bytes.Equal(kv.K, kvs[i].K) || !bytes.Equal(kv.V, kvs[i].V) {
+			return fmt.Errorf("expected key-value pair %d of range to be %v, got %v", i, kvs[i], *kv)
+		}
+	}
+	var processed int
+	err = db.ProcessRange(ctx, tk("a"), tk("z"), nil, func(c *storage.Chunk) error {
+		processed++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error on process range: %v", err)
+	}
+	if processed != len(kvs) {
+		return fmt.Errorf("expected %d chunks processed, got %d", len(kvs), processed)
+	}
+	ch := make(storage.KeyChan, len(kvs)+1)
+	if err := db.SendKeysInRange(ctx, tk("a"), tk("z"), ch); err != nil {
+		return fmt.Errorf("error on send keys in range: %v", err)
+	}
+	if sent := len(ch); sent != len(kvs)+1 {
+		return fmt.Errorf("expected %d keys and a nil sent, got %d", len(kvs), sent)
+	}
+	kStart, kEnd := ctx.KeyRange()
+	out := make(chan *storage.KeyValue, len(kvs)+1)
+	if err := db.RawRangeQuery(context.Background(), kStart, kEnd, false, out); err != nil {
+		return fmt.Errorf("error on raw range query: %v", err)
+	}
+	for i := range kvs {
+		if kv := <-out; kv == nil || !bytes.Equal(kv.V, kvs[i].V) {
+			return fmt.Errorf("expected raw range query to send %q, got %v", kvs[i].V, kv)
+		}
+	}
+	if kv := <-out; kv != nil {
+		return fmt.Errorf("expected raw range query to send nil at end, got %v", kv)
+	}
+
+	// A cancelled raw range query returns without sending the final nil.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	blocked := make(chan *storage.KeyValue)
+	if err := db.RawRangeQuery(cancelled, kStart, kEnd, true, blocked); err != nil {
+		return fmt.Errorf("error on cancelled raw range query: %v", err)
+	}
+
+	if err := db.DeleteRange(ctx, tk("bz"), tk("cz")); err != nil {
+		return fmt.Errorf("error on delete range: %v", err)
+	}
+	if err := checkKeys(db, ctx, "b", "d"); err != nil {
+		return err
+	}
+
+	// A versioned range gets each key's value in the version.
+	data := newTestData(5)
+	v1 := lineageCtx{storage.NewDataContext(data, 1)}
+	v2 := lineageCtx{storage.NewDataContext(data, 2)}
+	if err := db.PutRange(v1, kvs); err != nil {
+		return fmt.Errorf("error on versioned put range: %v", err)
+	}
+	if err := db.Put(v2, tk("c"), []byte("c2")); err != nil {
+		return fmt.Errorf("error on versioned put: %v", err)
+	}
+	if err := db.Delete(v2, tk("d")); err != nil {
+		return fmt.Errorf("error on versioned delete: %v", err)
+	}
+	if err := checkKeys(db, v1, "b", "c", "d"); err != nil {
+		return err
+	}
+	if err := checkKeys(db, v2, "b", "c"); err != nil {
+		return err
+	}
+	values, err = db.GetRange(v2, tk("a"), tk("z"))
+	if err != nil {
+		return fmt.Errorf("error on versioned get range: %v", err)
+	}
+	if len(values) != 2 || string(values[1].V) != "c2" {
+		return fmt.Errorf("expected b and c2 in versioned range, got %v", values)
+	}
+
+	if err := db.DeleteAll(ctx, true); err != nil {
+		return fmt.Errorf("error on delete all: %v", err)
+	}
+	if err := checkKeys(db, ctx); err != nil {
+		return err
+	}
+	if err := db.DeleteAll(v2, true); err != nil {
+		return fmt.Errorf("error on delete all versions: %v", err)
+	}
+	return checkKeys(db, v1)
+}
+
+func checkBatcher(store dvid.Store) error {
+	db, ok := store.(storage.KeyValueDB)
+	batcher, canBatch := store.(storage.KeyValueBatcher)
+	if !ok || !canBatch {
+		return nil
+	}
+	ctx := storage.NewDataContext(newTestData(6), 1)
+	if err := db.Put(ctx, tk("a"), []byte("a")); err != nil {
+		return fmt.Errorf("error on put: %v", err)
+	}
+	batch := batcher.NewBatch(ctx)
+	batch.Put(tk("b"), []byte("b"))
+	batch.Put(tk("c"), []byte("c"))
+	batch.Delete(tk("a"))
+	if err := checkValue(db, ctx, "b", nil); err != nil {
+		return fmt.Errorf("batch written before commit: %v", err)
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("error on batch commit: %v", err)
+	}
+	for k, expected := range map[string][]byte{"a": nil, "b": []byte("b"), "c": []byte("c")} {
+		if err := checkValue(db, ctx, k, expected); err != nil {
+			return err
+		}
+	}
+	if ordered, ok := db.(storage.OrderedKeyValueDB); ok {
+		if err := ordered.DeleteAll(ctx, true); err != nil {
+			return fmt.Errorf("error on delete all: %v", err)
+		}
+	}
+	return nil
+}
+
+func checkTransactionDB(store dvid.Store) error {
+	db, ok := store.(storage.KeyValueDB)
+	tdb, isTx := store.(storage.TransactionDB)
+	if !ok || !isTx {
+		return nil
+	}
+	ctx := storage.NewDataContext(newTestData(7), 1)
+
+	// Concurrent patches are each applied once.
+	const numPatches = 20
+	errs := make(chan error, numPatches)
+	for i := 0; i < numPatches; i++ {
+		go func() {
+			errs <- tdb.Patch(ctx, tk("n"), func(v []byte) ([]byte, error) {
+				return append(append([]byte{}, v...), 'x'), nil
+			})
+		}()
+	}
+	for i := 0; i < numPatches; i++ {
+		if err := <-errs; err != nil {
+			return fmt.Errorf("error on patch: %v", err)
+		}
+	}
+	expected := bytes.Repeat([]byte("x"), numPatches)
+	if err := checkValue(db, ctx, "n", expected); err != nil {
+		return err
+	}
+
+	// A failed patch doesn't change the value.
+	err := tdb.Patch(ctx, tk("n"), func(v []byte) ([]byte, error) {
+		return nil, fmt.Errorf("patch failure")
+	})
+	if err == nil {
+		return fmt.Errorf("expected error from failed patch")
+	}
+	if err := checkValue(db, ctx, "n", expected); err != nil {
+		return err
+	}
+
+	// A locked key can't be locked again until it's unlocked.
+	lockKey := ctx.ConstructKey(tk("lock"))
+	if err := tdb.LockKey(lockKey); err != nil {
+		return fmt.Errorf("error on lock: %v", err)
+	}
+	locked := make(chan error)
+	go func() {
+		locked <- tdb.LockKey(lockKey)
+	}()
+	select {
+	case <-locked:
+		return fmt.Errorf("expected lock of locked key to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := tdb.UnlockKey(lockKey); err != nil {
+		return fmt.Errorf("error on unlock: %v", err)
+	}
+	if err := <-locked; err != nil {
+		return fmt.Errorf("error on lock after unlock: %v", err)
+	}
+	if err := tdb.UnlockKey(lockKey); err != nil {
+		return fmt.Errorf("error on unlock: %v", err)
+	}
+
+	if ordered, ok := db.(storage.OrderedKeyValueDB); ok {
+		if err := ordered.DeleteAll(ctx, true); err != nil {
+			return fmt.Errorf("error on delete all: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package storetest
+
+import (
+	"testing"
+
+	"github.com/janelia-flyem/dvid/storage"
+
+	// engines selected by build tags are registered through the datastore package.
+	_ "github.com/janelia-flyem/dvid/datastore"
+)
+
+func TestConformance(t *testing.T) {
+	engines := storage.GetTestableEngines()
+	if len(engines) == 0 {
+		t.Skip("no testable engines selected by build tags\n")
+	}
+	for _, e := range engines {
+		name := e.GetName()
+		t.Run(name, func(t *testing.T) {
+			RunEngine(t, name, Conformance)
+		})
+	}
+}
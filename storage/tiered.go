@@ -0,0 +1,656 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// TierAccessClass is the metadata TKey class used by tiered stores to record the last day
+// each unversioned data key was accessed in the hot store.  It is reserved so it will not
+// collide with metadata classes used by the datastore package.
+const TierAccessClass TKeyClass = 0xE0
+
+// TierMigrateInterval is the time between scans for demotable keys in a tiered store.
+var TierMigrateInterval = time.Hour
+
+// TierConfig specifies a hot and cold store pair for a datatype or data instance.
+type TierConfig struct {
+	Hot  Alias
+	Cold Alias
+
+	// DemoteAfter is how long a key must go untouched in the hot store before it is
+	// moved to the cold store.  If zero, keys are never demoted.
+	DemoteAfter time.Duration
+}
+
+// TieredStore routes writes to a hot store and reads through from hot to cold.  All versions
+// of a key are demoted together by a background migrator.  Deletions are written to both
+// stores so tombstones hide any stale cold versions.
+//
+// Versioned reads and range queries merge the keys of both tiers, preferring the hot
+// key-value pair if a key is in both, and then pick each key's version from all versions
+// in either tier, so a newer version in the cold store isn't hidden by an ancestor's
+// version in the hot store.
+type TieredStore struct {
+	hot  OrderedKeyValueDB
+	cold OrderedKeyValueDB
+
+	demoteAfter time.Duration
+
+	// Writes hold a read lock so a key being demoted never loses a concurrent write.
+	demoteMu sync.RWMutex
+
+	// keys whose access has already been recorded for the current day.
+	accessMu  sync.Mutex
+	accessDay uint32
+	accessed  map[string]struct{}
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewTieredStore returns a tiered store and starts its migrator if demoteAfter is non-zero.
+// Both stores must be ordered key-value stores.
+func NewTieredStore(hot, cold dvid.Store, demoteAfter time.Duration) (*TieredStore, error) {
+	hotdb, ok := hot.(OrderedKeyValueDB)
+	if !ok {
+		return nil, fmt.Errorf("hot store %q is not an ordered key-value store", hot)
+	}
+	colddb, ok := cold.(OrderedKeyValueDB)
+	if !ok {
+		return nil, fmt.Errorf("cold store %q is not an ordered key-value store", cold)
+	}
+	s := &TieredStore{
+		hot:         hotdb,
+		cold:        colddb,
+		demoteAfter: demoteAfter,
+		accessed:    make(map[string]struct{}),
+		done:        make(chan struct{}),
+	}
+	if demoteAfter > 0 {
+		s.wg.Add(1)
+		go s.migrate()
+	}
+	return s, nil
+}
+
+func (s *TieredStore) String() string {
+	return fmt.Sprintf("tiered store (hot %s, cold %s)", s.hot, s.cold)
+}
+
+// Close stops the migrator.  The hot and cold stores are closed by the storage manager.
+func (s *TieredStore) Close() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// Equal always returns false since a tiered store is not created from a store configuration.
+func (s *TieredStore) Equal(c dvid.StoreConfig) bool {
+	return false
+}
+
+// ---- KeyValueGetter interface ------
+
+func (s *TieredStore) Get(ctx Context, tk TKey) ([]byte, error) {
+	if ctx.Versioned() {
+		kv, err := s.versionedGet(ctx, tk, false)
+		if kv == nil {
+			return nil, err
+		}
+		return kv.V, err
+	}
+	v, err := s.hot.Get(ctx, tk)
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		s.touch(ctx.ConstructKey(tk))
+		return v, nil
+	}
+	return s.cold.Get(ctx, tk)
+}
+
+func (s *TieredStore) Exists(ctx Context, tk TKey) (bool, error) {
+	if ctx.Versioned() {
+		kv, err := s.versionedGet(ctx, tk, true)
+		return kv != nil, err
+	}
+	found, err := s.hot.Exists(ctx, tk)
+	if err != nil || found {
+		return found, err
+	}
+	return s.cold.Exists(ctx, tk)
+}
+
+// versionedGet returns the key-value pair of a versioned key from all its versions in
+// either tier or nil if there is none.  A key read from the hot store is touched.
+func (s *TieredStore) versionedGet(ctx Context, tk TKey, keysOnly bool) (*KeyValue, error) {
+	vctx, ok := ctx.(VersionedCtx)
+	if !ok {
+		return nil, fmt.Errorf("context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	minKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	maxKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	var values []*KeyValue
+	fromHot := make(map[*KeyValue]bool)
+	err = s.mergeRange(RequestContext(ctx), minKey, maxKey, keysOnly, func(kv *KeyValue, hot bool) error {
+		values = append(values, kv)
+		fromHot[kv] = hot
+		return nil
+	})
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	kv, err := vctx.VersionedKeyValue(values)
+	if kv != nil && fromHot[kv] {
+		s.touch(kv.K)
+	}
+	return kv, err
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// rawStream runs a raw range query of a tier in the background.  The returned channel is
+// closed after the query returns its error on the error channel.
+func rawStream(c context.Context, db OrderedKeyValueGetter, kStart, kEnd Key, keysOnly bool) (chan *KeyValue, chan error) {
+	ch := make(chan *KeyValue, 100)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- db.RawRangeQuery(c, kStart, kEnd, keysOnly, ch)
+		close(ch)
+	}()
+	return ch, errCh
+}
+
+func drain(ch chan *KeyValue) {
+	for range ch {
+	}
+}
+
+// mergeRange calls f with the key-value pairs of both tiers with full keys in the range in
+// ascending key order.  If a key is in both tiers, only the hot key-value pair is passed.
+func (s *TieredStore) mergeRange(c context.Context, kStart, kEnd Key, keysOnly bool, f func(kv *KeyValue, hot bool) error) error {
+	c, cancel := context.WithCancel(c)
+	defer cancel()
+	hotCh, hotErr := rawStream(c, s.hot, kStart, kEnd, keysOnly)
+	coldCh, coldErr := rawStream(c, s.cold, kStart, kEnd, keysOnly)
+	defer func() {
+		go drain(hotCh)
+		go drain(coldCh)
+	}()
+
+	hkv, ckv := <-hotCh, <-coldCh
+	for hkv != nil || ckv != nil {
+		var err error
+		switch {
+		case ckv == nil:
+			err = f(hkv, true)
+			hkv = <-hotCh
+		case hkv == nil:
+			err = f(ckv, false)
+			ckv = <-coldCh
+		default:
+			cmp := bytes.Compare(hkv.K, ckv.K)
+			if cmp <= 0 {
+				err = f(hkv, true)
+				hkv = <-hotCh
+				if cmp == 0 {
+					ckv = <-coldCh
+				}
+			} else {
+				err = f(ckv, false)
+				ckv = <-coldCh
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := <-hotErr; err != nil {
+		return err
+	}
+	return <-coldErr
+}
+
+// rangeQuery calls f with the key-value pairs of a context's keys in the range, merged
+// across tiers.  For versioned contexts, each key's pair is picked from all its versions.
+func (s *TieredStore) rangeQuery(ctx Context, kStart, kEnd TKey, keysOnly bool, f func(*KeyValue) error) error {
+	c := RequestContext(ctx)
+	if !ctx.Versioned() {
+		return s.mergeRange(c, ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd), keysOnly, func(kv *KeyValue, hot bool) error {
+			return f(kv)
+		})
+	}
+	vctx, ok := ctx.(VersionedCtx)
+	if !ok {
+		return fmt.Errorf("context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	minKey, err := vctx.MinVersionKey(kStart)
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(kEnd)
+	if err != nil {
+		return err
+	}
+	var unversioned Key
+	var values []*KeyValue
+	sendKV := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		values = nil
+		if err != nil || kv == nil {
+			return err
+		}
+		return f(kv)
+	}
+	err = s.mergeRange(c, minKey, maxKey, keysOnly, func(kv *KeyValue, hot bool) error {
+		unv, _, err := SplitKey(kv.K)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(unv, unversioned) {
+			if err := sendKV(); err != nil {
+				return err
+			}
+			unversioned = unv
+		}
+		values = append(values, kv)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return sendKV()
+}
+
+func (s *TieredStore) GetRange(ctx Context, kStart, kEnd TKey) ([]*TKeyValue, error) {
+	values := []*TKeyValue{}
+	err := s.rangeQuery(ctx, kStart, kEnd, false, func(kv *KeyValue) error {
+		tk, err := TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		values = append(values, &TKeyValue{K: tk, V: kv.V})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (s *TieredStore) KeysInRange(ctx Context, kStart, kEnd TKey) ([]TKey, error) {
+	keys := []TKey{}
+	err := s.rangeQuery(ctx, kStart, kEnd, true, func(kv *KeyValue) error {
+		tk, err := TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, tk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *TieredStore) SendKeysInRange(ctx Context, kStart, kEnd TKey, ch KeyChan) error {
+	err := s.rangeQuery(ctx, kStart, kEnd, true, func(kv *KeyValue) error {
+		ch <- kv.K
+		return nil
+	})
+	ch <- nil
+	return err
+}
+
+func (s *TieredStore) ProcessRange(ctx Context, kStart, kEnd TKey, op *ChunkOp, f ChunkFunc) error {
+	f = CancelableChunkFunc(ctx, f)
+	return s.rangeQuery(ctx, kStart, kEnd, false, func(kv *KeyValue) error {
+		tk, err := TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		return f(&Chunk{ChunkOp: op, TKeyValue: &TKeyValue{K: tk, V: kv.V}})
+	})
+}
+
+// RawRangeQuery sends the full keys of both tiers in the range, preferring the hot
+// key-value pair if a key is in both.  A nil is sent when the range is complete.
+func (s *TieredStore) RawRangeQuery(c context.Context, kStart, kEnd Key, keysOnly bool, out chan *KeyValue) error {
+	err := s.mergeRange(c, kStart, kEnd, keysOnly, func(kv *KeyValue, hot bool) error {
+		select {
+		case out <- kv:
+			return nil
+		case <-c.Done():
+			return c.Err()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	out <- nil
+	return nil
+}
+
+// ---- KeyValueSetter interface ------
+
+func (s *TieredStore) Put(ctx Context, tk TKey, v []byte) error {
+	s.demoteMu.RLock()
+	defer s.demoteMu.RUnlock()
+	if err := s.hot.Put(ctx, tk, v); err != nil {
+		return err
+	}
+	s.touch(ctx.ConstructKey(tk))
+	return nil
+}
+
+func (s *TieredStore) Delete(ctx Context, tk TKey) error {
+	s.demoteMu.RLock()
+	defer s.demoteMu.RUnlock()
+	if err := s.hot.Delete(ctx, tk); err != nil {
+		return err
+	}
+	return s.cold.Delete(ctx, tk)
+}
+
+func (s *TieredStore) RawPut(k Key, v []byte) error {
+	s.demoteMu.RLock()
+	defer s.demoteMu.RUnlock()
+	if err := s.hot.RawPut(k, v); err != nil {
+		return err
+	}
+	s.touch(k)
+	return nil
+}
+
+func (s *TieredStore) RawDelete(k Key) error {
+	s.demoteMu.RLock()
+	defer s.demoteMu.RUnlock()
+	if err := s.hot.RawDelete(k); err != nil {
+		return err
+	}
+	return s.cold.RawDelete(k)
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+func (s *TieredStore) PutRange(ctx Context, kvs []TKeyValue) error {
+	s.demoteMu.RLock()
+	defer s.demoteMu.RUnlock()
+	if err := s.hot.PutRange(ctx, kvs); err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		s.touch(ctx.ConstructKey(kv.K))
+	}
+	return nil
+}
+
+func (s *TieredStore) DeleteRange(ctx Context, kStart, kEnd TKey) error {
+	s.demoteMu.RLock()
+	defer s.demoteMu.RUnlock()
+	if err := s.hot.DeleteRange(ctx, kStart, kEnd); err != nil {
+		return err
+	}
+	return s.cold.DeleteRange(ctx, kStart, kEnd)
+}
+
+func (s *TieredStore) DeleteAll(ctx Context, allVersions bool) error {
+	s.demoteMu.RLock()
+	defer s.demoteMu.RUnlock()
+	if err := s.hot.DeleteAll(ctx, allVersions); err != nil {
+		return err
+	}
+	return s.cold.DeleteAll(ctx, allVersions)
+}
+
+// ---- KeyValueBatcher interface ------
+
+type tieredBatch struct {
+	s       *TieredStore
+	ctx     Context
+	hot     Batch
+	puts    []TKey
+	deletes []TKey
+}
+
+// NewBatch returns a batch that is written to the hot store.  Deletions are applied to the
+// cold store after the hot batch commits.  Returns nil if the hot store cannot batch.
+func (s *TieredStore) NewBatch(ctx Context) Batch {
+	batcher, ok := s.hot.(KeyValueBatcher)
+	if !ok {
+		dvid.Criticalf("hot store %q of tiered store does not support batches\n", s.hot)
+		return nil
+	}
+	return &tieredBatch{s: s, ctx: ctx, hot: batcher.NewBatch(ctx)}
+}
+
+func (b *tieredBatch) Put(tk TKey, v []byte) {
+	b.hot.Put(tk, v)
+	b.puts = append(b.puts, tk)
+}
+
+func (b *tieredBatch) Delete(tk TKey) {
+	b.hot.Delete(tk)
+	b.deletes = append(b.deletes, tk)
+}
+
+func (b *tieredBatch) Commit() error {
+	b.s.demoteMu.RLock()
+	defer b.s.demoteMu.RUnlock()
+	if err := b.hot.Commit(); err != nil {
+		return err
+	}
+	for _, tk := range b.deletes {
+		if err := b.s.cold.Delete(b.ctx, tk); err != nil {
+			return err
+		}
+	}
+	for _, tk := range b.puts {
+		b.s.touch(b.ctx.ConstructKey(tk))
+	}
+	return nil
+}
+
+// ---- access tracking and demotion ------
+
+func today() uint32 {
+	return uint32(time.Now().Unix() / 86400)
+}
+
+// accessTKey returns the metadata key recording access of the given data key, which
+// covers all versions of the key.
+func accessTKey(k Key) (TKey, bool) {
+	if len(k) == 0 || k[0] != dataKeyPrefix {
+		return nil, false
+	}
+	unv, _, err := SplitKey(k)
+	if err != nil {
+		return nil, false
+	}
+	return NewTKey(TierAccessClass, unv[1:]), true
+}
+
+// touch records the access of a data key, writing to the hot store at most once a day per key.
+func (s *TieredStore) touch(k Key) {
+	tk, ok := accessTKey(k)
+	if !ok {
+		return
+	}
+	day := today()
+	s.accessMu.Lock()
+	if day != s.accessDay {
+		s.accessDay = day
+		s.accessed = make(map[string]struct{})
+	}
+	if _, found := s.accessed[string(tk)]; found {
+		s.accessMu.Unlock()
+		return
+	}
+	s.accessed[string(tk)] = struct{}{}
+	s.accessMu.Unlock()
+
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, day)
+	if err := s.hot.Put(MetadataContext{}, tk, buf); err != nil {
+		dvid.Errorf("unable to record access in tiered store %s: %v\n", s, err)
+	}
+}
+
+func (s *TieredStore) migrate() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(TierMigrateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.demoteStale(); err != nil {
+				dvid.Errorf("error demoting keys in %s: %v\n", s, err)
+			}
+		}
+	}
+}
+
+func (s *TieredStore) cutoffDay() uint32 {
+	days := uint32(s.demoteAfter / (24 * time.Hour))
+	if days == 0 {
+		days = 1
+	}
+	return today() - days
+}
+
+// demoteStale moves all keys whose last access was before the demotion cutoff to the cold store.
+func (s *TieredStore) demoteStale() error {
+	cutoff := s.cutoffDay()
+	var stale []TKey
+	err := s.hot.ProcessRange(MetadataContext{}, MinTKey(TierAccessClass), MaxTKey(TierAccessClass), nil, func(c *Chunk) error {
+		if c == nil || c.TKeyValue == nil || len(c.V) != 4 {
+			return nil
+		}
+		if binary.LittleEndian.Uint32(c.V) < cutoff {
+			stale = append(stale, c.K)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var demoted int
+	for _, tk := range stale {
+		select {
+		case <-s.done:
+			return nil
+		default:
+		}
+		moved, err := s.demoteKey(tk, cutoff)
+		if err != nil {
+			return err
+		}
+		if moved {
+			demoted++
+		}
+	}
+	if demoted > 0 {
+		dvid.Infof("Demoted %d keys from hot to cold store in %s\n", demoted, s)
+	}
+	return nil
+}
+
+// tombstonePair returns the key with data and tombstone marks swapped.
+func tombstonePair(k Key) Key {
+	pair := make(Key, len(k))
+	copy(pair, k)
+	switch k[len(k)-1] {
+	case MarkData:
+		pair[len(k)-1] = MarkTombstone
+	case MarkTombstone:
+		pair[len(k)-1] = MarkData
+	}
+	return pair
+}
+
+// demoteKey moves all versions of the unversioned key named by the access record to the cold
+// store.  Returns false if the key was accessed since the stale scan.
+func (s *TieredStore) demoteKey(accessKey TKey, cutoff uint32) (bool, error) {
+	s.demoteMu.Lock()
+	defer s.demoteMu.Unlock()
+
+	ctx := MetadataContext{}
+	v, err := s.hot.Get(ctx, accessKey)
+	if err != nil {
+		return false, err
+	}
+	if len(v) == 4 && binary.LittleEndian.Uint32(v) >= cutoff {
+		return false, nil
+	}
+	b, err := accessKey.ClassBytes(TierAccessClass)
+	if err != nil {
+		return false, err
+	}
+	unv := append([]byte{dataKeyPrefix}, b...)
+	suffixLen := dvid.VersionIDSize + dvid.ClientIDSize + 1
+	begKey := append(append(Key{}, unv...), bytes.Repeat([]byte{0x00}, suffixLen)...)
+	endKey := append(append(Key{}, unv...), bytes.Repeat([]byte{0xFF}, suffixLen)...)
+
+	ch := make(chan *KeyValue, 100)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.hot.RawRangeQuery(context.Background(), begKey, endKey, false, ch)
+		close(ch)
+	}()
+	var kvs []*KeyValue
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		if len(kv.K) == len(unv)+suffixLen {
+			kvs = append(kvs, kv)
+		}
+	}
+	if err := <-errCh; err != nil {
+		return false, err
+	}
+
+	// Write cold before deleting hot so a key is always readable from one of the tiers.
+	for _, kv := range kvs {
+		if err := s.cold.RawPut(kv.K, kv.V); err != nil {
+			return false, err
+		}
+		if err := s.cold.RawDelete(tombstonePair(kv.K)); err != nil {
+			return false, err
+		}
+	}
+	for _, kv := range kvs {
+		if err := s.hot.RawDelete(kv.K); err != nil {
+			return false, err
+		}
+	}
+	if err := s.hot.Delete(ctx, accessKey); err != nil {
+		return false, err
+	}
+	s.accessMu.Lock()
+	delete(s.accessed, string(accessKey))
+	s.accessMu.Unlock()
+	return true, nil
+}
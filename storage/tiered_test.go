@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// lineageCtx is a versioned data context whose versions are a single branch, so every
+// lower version is an ancestor.
+type lineageCtx struct {
+	*DataContext
+}
+
+func (ctx lineageCtx) Versioned() bool {
+	return true
+}
+
+func (ctx lineageCtx) Head() bool {
+	return true
+}
+
+func (ctx lineageCtx) MasterVersion(v dvid.VersionID) bool {
+	return true
+}
+
+func (ctx lineageCtx) NumVersions() int32 {
+	return int32(ctx.VersionID())
+}
+
+func (ctx lineageCtx) VersionedKeyValue(values []*KeyValue) (*KeyValue, error) {
+	var found *KeyValue
+	var foundV dvid.VersionID
+	for _, kv := range values {
+		v, err := ctx.VersionFromKey(kv.K)
+		if err != nil {
+			return nil, err
+		}
+		if v <= ctx.VersionID() && (found == nil || v > foundV) {
+			found, foundV = kv, v
+		}
+	}
+	if found == nil || found.K.IsTombstone() {
+		return nil, nil
+	}
+	return found, nil
+}
+
+func TestTieredStoreVersions(t *testing.T) {
+	hot := &testKVStore{kv: make(map[string][]byte)}
+	cold := &testKVStore{kv: make(map[string][]byte)}
+	s, err := NewTieredStore(hot, cold, 0)
+	if err != nil {
+		t.Fatalf("unable to create tiered store: %v\n", err)
+	}
+	defer s.Close()
+
+	data := &testData{instanceID: 1}
+	v1 := lineageCtx{NewDataContext(data, 1)}
+	v3 := lineageCtx{NewDataContext(data, 3)}
+
+	// A newer version in cold must not be hidden by an ancestor's version in hot.
+	hot.RawPut(v1.ConstructKeyVersion(TKey("a"), 1), []byte("a1"))
+	cold.RawPut(v1.ConstructKeyVersion(TKey("a"), 2), []byte("a2"))
+	// A tombstone in hot hides an older version in cold.
+	cold.RawPut(v1.ConstructKeyVersion(TKey("b"), 1), []byte("b1"))
+	hot.RawPut(v1.TombstoneKeyVersion(TKey("b"), 3), nil)
+	hot.RawPut(v1.ConstructKeyVersion(TKey("c"), 3), []byte("c3"))
+	cold.RawPut(v1.ConstructKeyVersion(TKey("d"), 1), []byte("d1"))
+
+	checkValue(t, s, v3, "a", []byte("a2"))
+	checkValue(t, s, v1, "a", []byte("a1"))
+	checkValue(t, s, v3, "b", nil)
+	checkValue(t, s, v1, "b", []byte("b1"))
+	if found, err := s.Exists(v3, TKey("d")); err != nil || !found {
+		t.Errorf("expected d to exist in cold tier: %v\n", err)
+	}
+
+	kvs, err := s.GetRange(v3, TKey("a"), TKey("z"))
+	if err != nil {
+		t.Fatalf("error on get range: %v\n", err)
+	}
+	got := make(map[string]string)
+	for _, kv := range kvs {
+		got[string(kv.K)] = string(kv.V)
+	}
+	expected := map[string]string{"a": "a2", "c": "c3", "d": "d1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected range %v, got %v\n", expected, got)
+	}
+	keys, err := s.KeysInRange(v1, TKey("a"), TKey("z"))
+	if err != nil {
+		t.Fatalf("error on keys in range: %v\n", err)
+	}
+	if !reflect.DeepEqual(keys, []TKey{TKey("a"), TKey("b"), TKey("d")}) {
+		t.Errorf("expected keys a, b, and d in version 1, got %v\n", keys)
+	}
+	var processed []string
+	err = s.ProcessRange(v3, TKey("a"), TKey("z"), nil, func(c *Chunk) error {
+		processed = append(processed, string(c.K))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error on process range: %v\n", err)
+	}
+	if !reflect.DeepEqual(processed, []string{"a", "c", "d"}) {
+		t.Errorf("expected keys a, c, and d processed, got %v\n", processed)
+	}
+	ch := make(KeyChan, 10)
+	if err := s.SendKeysInRange(v3, TKey("a"), TKey("z"), ch); err != nil {
+		t.Fatalf("error on send keys in range: %v\n", err)
+	}
+	var sent int
+	for k := range ch {
+		if k == nil {
+			break
+		}
+		sent++
+	}
+	if sent != 3 {
+		t.Errorf("expected 3 keys sent, got %d\n", sent)
+	}
+
+	if err := s.DeleteAll(v3, true); err != nil {
+		t.Fatalf("error on delete all: %v\n", err)
+	}
+	if kvs, err := s.GetRange(v3, TKey("a"), TKey("z")); err != nil || len(kvs) != 0 {
+		t.Errorf("expected no key-value pairs after delete all, got %d: %v\n", len(kvs), err)
+	}
+}
+
+func TestTieredStoreUnversioned(t *testing.T) {
+	hot := &testKVStore{kv: make(map[string][]byte)}
+	cold := &testKVStore{kv: make(map[string][]byte)}
+	s, err := NewTieredStore(hot, cold, 0)
+	if err != nil {
+		t.Fatalf("unable to create tiered store: %v\n", err)
+	}
+	defer s.Close()
+
+	ctx := NewDataContext(&testData{instanceID: 2}, 1)
+	cold.Put(ctx, TKey("a"), []byte("stale"))
+	cold.Put(ctx, TKey("b"), []byte("b"))
+	if err := s.Put(ctx, TKey("a"), []byte("fresh")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	kvs, err := s.GetRange(ctx, TKey("a"), TKey("z"))
+	if err != nil {
+		t.Fatalf("error on get range: %v\n", err)
+	}
+	if len(kvs) != 2 || string(kvs[0].V) != "fresh" || string(kvs[1].V) != "b" {
+		t.Errorf("expected hot value to replace cold value in range, got %v\n", kvs)
+	}
+}
@@ -0,0 +1,802 @@
+// +build tikv
+
+/*
+	Package tikv implements an ordered key-value storage engine on a TiKV cluster using
+	the raw key-value API, so block storage for a single DVID server can scale
+	horizontally across TiKV nodes.  DVID keys are stored without any re-encoding, so
+	RawRangeQuery and RawPut see the same key layout as other ordered engines and can be
+	used for DVID-to-DVID transfers.
+
+	The raw API is not transactional: batch writes are grouped by region and sent in
+	parallel, so a batch that fails may be partially applied.
+*/
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/semver"
+
+	"github.com/tikv/client-go/v2/rawkv"
+)
+
+const (
+	// ScanChunkSize is the maximum number of key-value pairs read per scan request.
+	ScanChunkSize = 1000
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in tikv: %v\n", err)
+	}
+	e := Engine{"tikv", "TiKV distributed key-value store", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return true
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a TiKV store.  The passed Config must contain "pd", a list of
+// placement driver addresses, e.g., ["pd1:2379", "pd2:2379"].
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newTiKV(config)
+}
+
+func parseConfig(config dvid.StoreConfig) ([]string, error) {
+	c := config.GetAll()
+	v, found := c["pd"]
+	if !found {
+		return nil, fmt.Errorf("%q must be specified for tikv configuration", "pd")
+	}
+	var addrs []string
+	switch pd := v.(type) {
+	case string:
+		addrs = strings.Split(pd, ",")
+	case []interface{}:
+		for _, addr := range pd {
+			s, ok := addr.(string)
+			if !ok {
+				return nil, fmt.Errorf("%q setting must be a list of strings (%v)", "pd", v)
+			}
+			addrs = append(addrs, s)
+		}
+	case []string:
+		addrs = pd
+	default:
+		return nil, fmt.Errorf("%q setting must be a list of strings (%v)", "pd", v)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("at least one placement driver address must be given for tikv configuration")
+	}
+	return addrs, nil
+}
+
+func (e Engine) newTiKV(config dvid.StoreConfig) (*TiKV, bool, error) {
+	pdAddrs, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+	ctx := context.Background()
+	client, err := rawkv.NewClientWithOpts(ctx, pdAddrs)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to connect to TiKV placement drivers %v: %v", pdAddrs, err)
+	}
+	db := &TiKV{
+		pdAddrs: pdAddrs,
+		config:  config,
+		client:  client,
+		ctx:     ctx,
+	}
+	metadataExists, err := db.metadataExists()
+	if err != nil {
+		db.Close()
+		return nil, false, err
+	}
+	return db, !metadataExists, nil
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig sets tikv as the default key-value backend and metadata store, using the
+// placement drivers given by the DVID_TEST_TIKV_PD environment variable.  Since keys
+// aren't prefixed, the cluster must be only used for tests.  If another engine is already
+// set, it returns an error since only one key-value backend should be tested via tags.
+func (e Engine) AddTestConfig(backend *storage.Backend) error {
+	if backend.DefaultKVDB != "" {
+		return fmt.Errorf("tikv can't be testable key-value.  DefaultKVDB already set to %s", backend.DefaultKVDB)
+	}
+	if backend.Metadata != "" {
+		return fmt.Errorf("tikv can't be testable key-value.  Metadata already set to %s", backend.Metadata)
+	}
+	pd := os.Getenv("DVID_TEST_TIKV_PD")
+	if pd == "" {
+		return fmt.Errorf("tikv can't be testable key-value without placement drivers in DVID_TEST_TIKV_PD")
+	}
+	alias := storage.Alias("tikv")
+	backend.Metadata = alias
+	backend.DefaultKVDB = alias
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"pd":      pd,
+		"testing": true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "tikv"}
+	return nil
+}
+
+// Delete implements the TestableEngine interface by deleting all keys of a test store.
+// Other stores aren't deleted since the cluster may hold other data.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	testing, _, err := config.GetBool("testing")
+	if err != nil {
+		return err
+	}
+	if !testing {
+		return fmt.Errorf("can't delete tikv store that isn't for testing")
+	}
+	db, _, err := e.newTiKV(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.client.DeleteRange(db.ctx, []byte{}, []byte{})
+}
+
+// --- The TiKV implementation must satisfy a Engine interface ----
+
+type TiKV struct {
+	pdAddrs []string
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+
+	client *rawkv.Client
+	ctx    context.Context
+}
+
+func (db *TiKV) String() string {
+	return fmt.Sprintf("tikv @ %s", strings.Join(db.pdAddrs, ","))
+}
+
+// Close closes the TiKV client.
+func (db *TiKV) Close() {
+	if db != nil && db.client != nil {
+		if err := db.client.Close(); err != nil {
+			dvid.Errorf("Error closing %s: %v\n", db, err)
+		}
+		db.client = nil
+	}
+}
+
+// Equal returns true if the TiKV store matches the given store configuration.
+func (db *TiKV) Equal(config dvid.StoreConfig) bool {
+	pdAddrs, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return strings.Join(db.pdAddrs, ",") == strings.Join(pdAddrs, ",")
+}
+
+func (db *TiKV) metadataExists() (bool, error) {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	keys, _, err := db.client.Scan(db.ctx, keyBeg, keyEnd, 1, rawkv.ScanKeyOnly())
+	if err != nil {
+		return false, err
+	}
+	if len(keys) == 0 {
+		dvid.Infof("No metadata found for %s...\n", db)
+	}
+	return len(keys) != 0, nil
+}
+
+// scanRange calls f on each key-value pair with keys in [kStart, kEnd] in ascending order.
+// The range is read in chunks of ScanChunkSize.  If f returns false, the scan stops.
+func (db *TiKV) scanRange(kStart, kEnd storage.Key, keysOnly bool, f func(*storage.KeyValue) bool) error {
+	begin := append([]byte{}, kStart...)
+	end := append(append([]byte{}, kEnd...), 0) // make end inclusive
+	var opts []rawkv.RawOption
+	if keysOnly {
+		opts = append(opts, rawkv.ScanKeyOnly())
+	}
+	for {
+		keys, values, err := db.client.Scan(db.ctx, begin, end, ScanChunkSize, opts...)
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			storage.StoreKeyBytesRead <- len(k)
+			var v []byte
+			if !keysOnly {
+				v = values[i]
+				storage.StoreValueBytesRead <- len(v)
+			}
+			if !f(&storage.KeyValue{K: k, V: v}) {
+				return nil
+			}
+		}
+		if len(keys) < ScanChunkSize {
+			return nil
+		}
+		begin = append(append([]byte{}, keys[len(keys)-1]...), 0)
+	}
+}
+
+// getSingleKeyVersions returns all versions of a key, without values if keysOnly is true.
+// These key-value pairs will be sorted in ascending key order and could include a tombstone
+// key.
+func (db *TiKV) getSingleKeyVersions(vctx storage.VersionedCtx, tk storage.TKey, keysOnly bool) ([]*storage.KeyValue, error) {
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	values := []*storage.KeyValue{}
+	err = db.scanRange(begKey, endKey, keysOnly, func(kv *storage.KeyValue) bool {
+		values = append(values, kv)
+		return true
+	})
+	return values, err
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *TiKV) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call Get on nil TiKV")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+
+		// Get all versions of this key and return the most recent
+		values, err := db.getSingleKeyVersions(vctx, tk, false)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			return kv.V, err
+		}
+		return nil, err
+	}
+	v, err := db.client.Get(db.ctx, ctx.ConstructKey(tk))
+	if err != nil {
+		return nil, err
+	}
+	storage.StoreValueBytesRead <- len(v)
+	return v, nil
+}
+
+// Exists returns true if a value is stored for the key using key-only scans, so values
+// aren't transferred.
+func (db *TiKV) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists on nil TiKV")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if !ctx.Versioned() {
+		key := ctx.ConstructKey(tk)
+		var found bool
+		err := db.scanRange(key, key, true, func(*storage.KeyValue) bool {
+			found = true
+			return false
+		})
+		return found, err
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	versions, err := db.getSingleKeyVersions(vctx, tk, true)
+	if err != nil {
+		return false, err
+	}
+	kv, err := vctx.VersionedKeyValue(versions)
+	return kv != nil, err
+}
+
+
+// rangeQuery calls f on each key-value pair visible in the context's version with
+// type-specific keys in [kStart, kEnd].
+func (db *TiKV) rangeQuery(ctx storage.Context, kStart, kEnd storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	var ferr error
+	if !ctx.Versioned() {
+		err := db.scanRange(ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd), keysOnly, func(kv *storage.KeyValue) bool {
+			ferr = f(kv)
+			return ferr == nil
+		})
+		if err != nil {
+			return err
+		}
+		return ferr
+	}
+
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return fmt.Errorf("context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	minKey, err := vctx.MinVersionKey(kStart)
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(kEnd)
+	if err != nil {
+		return err
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(kStart)
+	if err != nil {
+		return err
+	}
+	values := []*storage.KeyValue{}
+	sendKV := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil || kv == nil {
+			return err
+		}
+		return f(kv)
+	}
+	err = db.scanRange(minKey, maxKey, keysOnly, func(kv *storage.KeyValue) bool {
+		// Did we pass all versions for last key read?
+		if bytes.Compare(kv.K, maxVersionKey) > 0 {
+			var tk storage.TKey
+			if tk, ferr = storage.TKeyFromKey(kv.K); ferr != nil {
+				return false
+			}
+			if maxVersionKey, ferr = vctx.MaxVersionKey(tk); ferr != nil {
+				return false
+			}
+			if ferr = sendKV(); ferr != nil {
+				return false
+			}
+			values = []*storage.KeyValue{}
+		}
+		values = append(values, kv)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if ferr != nil {
+		return ferr
+	}
+	return sendKV()
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *TiKV) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil TiKV")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	keys := []storage.TKey{}
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, tk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *TiKV) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, ch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil TiKV")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		ch <- kv.K
+		return nil
+	})
+	ch <- nil
+	return err
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *TiKV) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil TiKV")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	values := []*storage.TKeyValue{}
+	err := db.rangeQuery(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: kv.V})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *TiKV) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil TiKV")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	f = storage.CancelableChunkFunc(ctx, f)
+	return db.rangeQuery(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tkv := storage.TKeyValue{K: tk, V: kv.V}
+		return f(&storage.Chunk{ChunkOp: op, TKeyValue: &tkv})
+	})
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (db *TiKV) RawRangeQuery(ctx context.Context, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil TiKV")
+	}
+	var cancelled bool
+	err := db.scanRange(kStart, kEnd, keysOnly, func(kv *storage.KeyValue) bool {
+		select {
+		case out <- kv:
+			return true
+		case <-ctx.Done():
+			cancelled = true
+			return false
+		}
+	})
+	if err != nil || cancelled {
+		return err
+	}
+	out <- nil
+	return nil
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *TiKV) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil TiKV")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Put(tk, v)
+	return batch.Commit()
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *TiKV) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil TiKV")
+	}
+	if err := db.client.Put(db.ctx, k, v); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *TiKV) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil TiKV")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	batch := db.NewBatch(ctx)
+	batch.Delete(tk)
+	return batch.Commit()
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *TiKV) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil TiKV")
+	}
+	return db.client.Delete(db.ctx, k)
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+func (db *TiKV) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange on nil TiKV")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	return batch.Commit()
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.  For versioned
+// contexts, tombstones are written for the deleted keys.
+func (db *TiKV) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil TiKV")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+	var tkeys []storage.TKey
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		tkeys = append(tkeys, tk)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	const BATCH_SIZE = 10000
+	for start := 0; start < len(tkeys); start += BATCH_SIZE {
+		end := start + BATCH_SIZE
+		if end > len(tkeys) {
+			end = len(tkeys)
+		}
+		batch := db.NewBatch(ctx)
+		for _, tk := range tkeys[start:end] {
+			batch.Delete(tk)
+		}
+		if err := batch.Commit(); err != nil {
+			return fmt.Errorf("Error on batch commit of DeleteRange at key-value pair %d: %v", start, err)
+		}
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", len(tkeys), ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+// Deletion of all versions uses a TiKV range deletion so it doesn't require a scan.
+func (db *TiKV) DeleteAll(ctx storage.Context, allVersions bool) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil TiKV")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	if allVersions {
+		minKey, maxKey := ctx.KeyRange()
+		if err := db.client.DeleteRange(db.ctx, minKey, maxKey); err != nil {
+			return fmt.Errorf("Error on DELETE ALL for %s: %v", ctx, err)
+		}
+		return nil
+	}
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if !versioned {
+		return fmt.Errorf("Can't ask for versioned delete from unversioned context: %s", ctx)
+	}
+	minKey, err := vctx.MinVersionKey(storage.MinTKey(storage.TKeyMinClass))
+	if err != nil {
+		return err
+	}
+	maxKey, err := vctx.MaxVersionKey(storage.MaxTKey(storage.TKeyMaxClass))
+	if err != nil {
+		return err
+	}
+	const BATCH_SIZE = 10000
+	deleteVersion := vctx.VersionID()
+	var keys [][]byte
+	var numKV int
+	var delErr error
+	err = db.scanRange(minKey, maxKey, true, func(kv *storage.KeyValue) bool {
+		_, v, _, err := storage.DataKeyToLocalIDs(kv.K)
+		if err != nil || v != deleteVersion {
+			return true
+		}
+		keys = append(keys, kv.K)
+		if len(keys) == BATCH_SIZE {
+			if delErr = db.client.BatchDelete(db.ctx, keys); delErr != nil {
+				return false
+			}
+			numKV += len(keys)
+			keys = nil
+		}
+		return true
+	})
+	if err == nil {
+		err = delErr
+	}
+	if err == nil && len(keys) != 0 {
+		err = db.client.BatchDelete(db.ctx, keys)
+		numKV += len(keys)
+	}
+	if err != nil {
+		return fmt.Errorf("Error on DELETE ALL for version %d: %v", deleteVersion, err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", numKV, vctx)
+	return nil
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	key    storage.Key
+	value  []byte
+	delete bool
+}
+
+type goBatch struct {
+	db   *TiKV
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	ops  []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes.
+func (db *TiKV) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil TiKV\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	var vctx storage.VersionedCtx
+	if ctx.Versioned() {
+		vctx, _ = ctx.(storage.VersionedCtx)
+	}
+	return &goBatch{db: db, ctx: ctx, vctx: vctx}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, value: dvid.EmptyValue()})
+	}
+	batch.ops = append(batch.ops, batchOp{key: key, delete: true})
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.ops = append(batch.ops, batchOp{key: tombstone, delete: true})
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.ops = append(batch.ops, batchOp{key: key, value: v})
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	// Deletes and puts of the same key (e.g., tombstones) must keep their relative order,
+	// so only the latest operation on each key is sent.
+	latest := make(map[string]int, len(batch.ops))
+	for i, op := range batch.ops {
+		latest[string(op.key)] = i
+	}
+	var putKeys, putValues, delKeys [][]byte
+	for i, op := range batch.ops {
+		if latest[string(op.key)] != i {
+			continue
+		}
+		if op.delete {
+			delKeys = append(delKeys, op.key)
+		} else {
+			putKeys = append(putKeys, op.key)
+			putValues = append(putValues, op.value)
+		}
+	}
+	db := batch.db
+	if len(delKeys) != 0 {
+		if err := db.client.BatchDelete(db.ctx, delKeys); err != nil {
+			dvid.Criticalf("Error on batch delete of %d keys in %s: %v\n", len(delKeys), db, err)
+			return err
+		}
+	}
+	if len(putKeys) != 0 {
+		if err := db.client.BatchPut(db.ctx, putKeys, putValues); err != nil {
+			dvid.Criticalf("Error on batch put of %d keys in %s: %v\n", len(putKeys), db, err)
+			return err
+		}
+	}
+	batch.ops = nil
+	return nil
+}
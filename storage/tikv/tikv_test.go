@@ -0,0 +1,70 @@
+// +build tikv
+
+package tikv
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/storage/storetest"
+)
+
+func testConfig(c map[string]interface{}) dvid.StoreConfig {
+	var config dvid.Config
+	config.SetAll(c)
+	return dvid.StoreConfig{Config: config, Engine: "tikv"}
+}
+
+func TestTiKVConfig(t *testing.T) {
+	expected := []string{"pd1:2379", "pd2:2379"}
+	for _, pd := range []interface{}{"pd1:2379,pd2:2379", []interface{}{"pd1:2379", "pd2:2379"}, expected} {
+		addrs, err := parseConfig(testConfig(map[string]interface{}{"pd": pd}))
+		if err != nil {
+			t.Errorf("error parsing placement drivers %v: %v\n", pd, err)
+			continue
+		}
+		if !reflect.DeepEqual(addrs, expected) {
+			t.Errorf("expected placement drivers %v from %v, got %v\n", expected, pd, addrs)
+		}
+	}
+	for _, c := range []map[string]interface{}{{}, {"pd": 2379}, {"pd": []interface{}{"pd1:2379", 2}}, {"pd": []string{}}} {
+		if _, err := parseConfig(testConfig(c)); err == nil {
+			t.Errorf("expected error parsing configuration %v\n", c)
+		}
+	}
+
+	db := &TiKV{pdAddrs: expected}
+	if !db.Equal(testConfig(map[string]interface{}{"pd": "pd1:2379,pd2:2379"})) {
+		t.Errorf("expected store to equal its configuration\n")
+	}
+
+	// Stores not used for tests may share the cluster so aren't deleted.
+	var e Engine
+	if err := e.Delete(testConfig(map[string]interface{}{"pd": "pd1:2379"})); err == nil {
+		t.Errorf("expected error deleting store not for testing\n")
+	}
+}
+
+// checkRawKeys checks that DVID keys are stored unchanged, so clusters can be read
+// directly by other DVID servers.
+func checkRawKeys(store dvid.Store) error {
+	db := store.(*TiKV)
+	var ctx storage.MetadataContext
+	k := ctx.ConstructKey(storage.TKey("raw"))
+	if err := db.RawPut(k, []byte("v")); err != nil {
+		return fmt.Errorf("error on raw put: %v", err)
+	}
+	defer db.RawDelete(k)
+	v, err := db.client.Get(db.ctx, k)
+	if err != nil || string(v) != "v" {
+		return fmt.Errorf("expected value at unchanged key, got %q: %v", v, err)
+	}
+	return nil
+}
+
+func TestTiKVStore(t *testing.T) {
+	storetest.RunEngine(t, "tikv", []storetest.Check{{Name: "RawKeys", Run: checkRawKeys}})
+}
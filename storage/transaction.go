@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// The functions below provide TransactionDB semantics for any KeyValueDB, using the
+// store's native implementation if it has one.  Otherwise, operations are emulated by an
+// atomic compare-and-swap that holds a striped lock within this DVID server, so emulated
+// operations are only atomic with respect to each other and when a single DVID server
+// writes the keys.
+
+// IsShared returns true if the store implements TransactionDB and may be shared with
+// other DVID servers, so in-memory state derived from its data must be reloaded or
+// modified through the store while holding its locks.
+func IsShared(store dvid.Store) bool {
+	if _, ok := store.(TransactionDB); !ok {
+		return false
+	}
+	if ldb, ok := store.(LocalDB); ok && ldb.IsLocal() {
+		return false
+	}
+	return true
+}
+
+// number of lock stripes for emulated compare-and-swap.
+const txStripes = 256
+
+var txLocks [txStripes]sync.Mutex
+
+const (
+	lockRetryDelay    = time.Millisecond
+	maxLockRetryDelay = 100 * time.Millisecond
+)
+
+// emulatedLockValue is stored in emulated lock keys.  It isn't empty since some engines
+// can't distinguish an empty value from a missing key.
+var emulatedLockValue = []byte{1}
+
+// CompareAndSwap atomically writes a new value for the key if the value visible through
+// the context is equal to old, where a nil old value means the key must be missing.  It
+// returns true if the value was written.  The compare-and-swap is only atomic with
+// respect to other callers of CompareAndSwap, LockKey, and Patch within this DVID server.
+func CompareAndSwap(db KeyValueDB, ctx Context, tk TKey, old, new []byte) (bool, error) {
+	lock := &txLocks[keyStripe(ctx.ConstructKey(tk))%txStripes]
+	lock.Lock()
+	defer lock.Unlock()
+
+	cur, err := db.Get(ctx, tk)
+	if err != nil {
+		return false, err
+	}
+	if (cur == nil) != (old == nil) || !bytes.Equal(cur, old) {
+		return false, nil
+	}
+	if err := db.Put(ctx, tk, new); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// LockKey uses the full key as a lock, blocking with exponential backoff until any
+// other holder unlocks it.
+func LockKey(db KeyValueDB, k Key) error {
+	if tdb, ok := db.(TransactionDB); ok {
+		return tdb.LockKey(k)
+	}
+	var ctx rawKeyContext
+	delay := lockRetryDelay
+	for {
+		acquired, err := CompareAndSwap(db, ctx, TKey(k), nil, emulatedLockValue)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			return nil
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxLockRetryDelay {
+			delay = maxLockRetryDelay
+		}
+	}
+}
+
+// UnlockKey releases a lock acquired by LockKey.
+func UnlockKey(db KeyValueDB, k Key) error {
+	if tdb, ok := db.(TransactionDB); ok {
+		return tdb.UnlockKey(k)
+	}
+	return db.RawDelete(k)
+}
+
+// Patch replaces the value visible through the context with the result of applying f
+// to it.  If the store is changed by another patch while f runs, f is applied again to
+// the new value, so f must not have side effects beyond computing the new value.
+func Patch(db KeyValueDB, ctx Context, tk TKey, f PatchFunc) error {
+	if tdb, ok := db.(TransactionDB); ok {
+		return tdb.Patch(ctx, tk, f)
+	}
+	for {
+		old, err := db.Get(ctx, tk)
+		if err != nil {
+			return err
+		}
+		val, err := f(old)
+		if err != nil {
+			return err
+		}
+		swapped, err := CompareAndSwap(db, ctx, tk, old, val)
+		if err != nil || swapped {
+			return err
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEmulatedTransactions(t *testing.T) {
+	db := &testKVStore{kv: make(map[string][]byte)}
+	var ctx MetadataContext
+	tk := TKey("counter")
+
+	// Concurrent patches should not lose increments.
+	const numPatches = 100
+	var wg sync.WaitGroup
+	for i := 0; i < numPatches; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := Patch(db, ctx, tk, func(v []byte) ([]byte, error) {
+				var n uint64
+				if v != nil {
+					n = binary.LittleEndian.Uint64(v)
+				}
+				buf := make([]byte, 8)
+				binary.LittleEndian.PutUint64(buf, n+1)
+				return buf, nil
+			})
+			if err != nil {
+				t.Errorf("error on patch: %v\n", err)
+			}
+		}()
+	}
+	wg.Wait()
+	v, err := db.Get(ctx, tk)
+	if err != nil {
+		t.Fatalf("error on get: %v\n", err)
+	}
+	if n := binary.LittleEndian.Uint64(v); n != numPatches {
+		t.Fatalf("expected counter %d after patches, got %d\n", numPatches, n)
+	}
+
+	// Only one holder of a lock key at a time.
+	lockKey := ctx.ConstructKey(TKey("lock"))
+	var holders, maxHolders int
+	var mu sync.Mutex
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := LockKey(db, lockKey); err != nil {
+				t.Errorf("error on lock: %v\n", err)
+				return
+			}
+			mu.Lock()
+			if holders++; holders > maxHolders {
+				maxHolders = holders
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			holders--
+			mu.Unlock()
+			if err := UnlockKey(db, lockKey); err != nil {
+				t.Errorf("error on unlock: %v\n", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxHolders != 1 {
+		t.Fatalf("expected a single lock holder at a time, got %d\n", maxHolders)
+	}
+	if v, _ := db.Get(ctx, TKey("lock")); v != nil {
+		t.Fatalf("expected lock key to be deleted after unlocking\n")
+	}
+}
@@ -0,0 +1,442 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// WriteBackConfig handles settings for write-back buffering of Put and Delete.
+type WriteBackConfig struct {
+	MB        int      // Buffered bytes that trigger a flush.
+	FlushMS   int      `toml:"flush_ms"` // Maximum time in milliseconds between flushes.
+	Instances []string // Data instances "<name>:<uuid>" or datatype names that buffer writes.
+}
+
+const (
+	// DefaultWriteBackMB is used if a write-back buffer size isn't set.
+	DefaultWriteBackMB = 64
+
+	// DefaultWriteBackFlushMS is used if a write-back flush interval isn't set.
+	DefaultWriteBackFlushMS = 1000
+)
+
+type wbOp struct {
+	group string // identifies the context for batching
+	ctx   Context
+	tk    TKey
+	v     []byte
+	del   bool
+}
+
+func (op *wbOp) size() int {
+	return len(op.tk) + len(op.v)
+}
+
+// WriteBackStore absorbs Put and Delete into an in-memory buffer that is flushed to the
+// wrapped store via batches when either the buffer size or the flush interval is exceeded.
+// Gets see buffered writes in their version.  Since a version's reads can resolve to
+// ancestor versions, gets of versioned data flush the buffer first if it holds writes to
+// other versions of the same data.  Range queries and range writes flush the buffer first so
+// they are consistent with prior writes.
+//
+// Writes are checked against their contexts when buffered, so writes to locked nodes are
+// refused to the caller.  Writes refused when flushed, e.g., because the node was locked
+// in the meantime, are dropped instead of retried.  Buffered writes are lost if the server
+// crashes before a flush, so this should only be used for ingestion that can be replayed.
+type WriteBackStore struct {
+	db      OrderedKeyValueDB
+	batcher KeyValueBatcher
+
+	maxBytes int
+	interval time.Duration
+
+	mu       sync.RWMutex
+	pending  map[string]*wbOp // keyed by full key
+	flushing map[string]*wbOp // writes being committed, still visible to Get
+	curBytes int
+
+	// versions with pending or flushing writes, by data group of versioned contexts.
+	pendingVersions  map[string]map[dvid.VersionID]struct{}
+	flushingVersions map[string]map[dvid.VersionID]struct{}
+
+	flushMu sync.Mutex // serializes flushes
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewWriteBackStore returns a store that buffers writes to the passed store, which must
+// be an ordered key-value store that supports batches.
+func NewWriteBackStore(store dvid.Store, config WriteBackConfig) (*WriteBackStore, error) {
+	db, ok := store.(OrderedKeyValueDB)
+	if !ok {
+		return nil, fmt.Errorf("store %s doesn't implement OrderedKeyValueDB", store)
+	}
+	batcher, ok := store.(KeyValueBatcher)
+	if !ok {
+		return nil, fmt.Errorf("store %s doesn't implement KeyValueBatcher", store)
+	}
+	mb := config.MB
+	if mb <= 0 {
+		mb = DefaultWriteBackMB
+	}
+	flushMS := config.FlushMS
+	if flushMS <= 0 {
+		flushMS = DefaultWriteBackFlushMS
+	}
+	s := &WriteBackStore{
+		db:       db,
+		batcher:  batcher,
+		maxBytes: mb << 20,
+		interval: time.Duration(flushMS) * time.Millisecond,
+		pending:  make(map[string]*wbOp),
+		done:     make(chan struct{}),
+
+		pendingVersions: make(map[string]map[dvid.VersionID]struct{}),
+	}
+	s.wg.Add(1)
+	go s.flusher()
+	return s, nil
+}
+
+func (s *WriteBackStore) String() string {
+	return fmt.Sprintf("write-back buffer of %s", s.db)
+}
+
+// Close flushes buffered writes and stops the background flusher.  The wrapped store
+// is closed by the storage manager.
+func (s *WriteBackStore) Close() {
+	close(s.done)
+	s.wg.Wait()
+	if err := s.Flush(); err != nil {
+		dvid.Errorf("unable to flush %s on close: %v\n", s, err)
+	}
+}
+
+// Equal always returns false since a write-back store is not created from a store configuration.
+func (s *WriteBackStore) Equal(c dvid.StoreConfig) bool {
+	return false
+}
+
+func (s *WriteBackStore) flusher() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				dvid.Errorf("unable to flush %s: %v\n", s, err)
+			}
+		}
+	}
+}
+
+// contextGroup returns an identifier for all contexts that construct the same keys.
+func contextGroup(ctx Context) string {
+	group := string(ctx.ConstructKey(nil))
+	if ctx.Versioned() {
+		return "v" + group
+	}
+	return "u" + group
+}
+
+// dataGroup returns an identifier for all versions of a versioned context's data.
+func dataGroup(ctx Context) string {
+	return string(ctx.ConstructKeyVersion(nil, 0))
+}
+
+// addVersion records a pending write to the version of a versioned context.  Caller must
+// hold the lock.
+func (s *WriteBackStore) addVersion(ctx Context) {
+	if !ctx.Versioned() {
+		return
+	}
+	group := dataGroup(ctx)
+	versions, found := s.pendingVersions[group]
+	if !found {
+		versions = make(map[dvid.VersionID]struct{})
+		s.pendingVersions[group] = versions
+	}
+	versions[ctx.VersionID()] = struct{}{}
+}
+
+// crossesVersions returns true if there are pending or flushing writes to versions of the
+// context's data other than its own, which its reads may resolve to.
+func (s *WriteBackStore) crossesVersions(ctx Context) bool {
+	if !ctx.Versioned() {
+		return false
+	}
+	group := dataGroup(ctx)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, versions := range []map[dvid.VersionID]struct{}{s.pendingVersions[group], s.flushingVersions[group]} {
+		for v := range versions {
+			if v != ctx.VersionID() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// buffer adds operations to the pending writes, flushing if the buffer is full.
+func (s *WriteBackStore) buffer(ops ...*wbOp) error {
+	s.mu.Lock()
+	for _, op := range ops {
+		key := string(op.ctx.ConstructKey(op.tk))
+		if old, found := s.pending[key]; found {
+			s.curBytes -= old.size()
+		}
+		s.pending[key] = op
+		s.curBytes += op.size()
+		s.addVersion(op.ctx)
+	}
+	full := s.curBytes >= s.maxBytes
+	s.mu.Unlock()
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush commits all buffered writes to the wrapped store.
+func (s *WriteBackStore) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	if len(s.pending) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	s.flushing = s.pending
+	s.pending = make(map[string]*wbOp)
+	s.curBytes = 0
+	s.flushingVersions = s.pendingVersions
+	s.pendingVersions = make(map[string]map[dvid.VersionID]struct{})
+	s.mu.Unlock()
+
+	groups := make(map[string][]*wbOp)
+	for _, op := range s.flushing {
+		groups[op.group] = append(groups[op.group], op)
+	}
+	var err error
+	var failed []*wbOp
+	for _, ops := range groups {
+		if werr := CheckWrite(ops[0].ctx); werr != nil {
+			dvid.Errorf("dropping %d buffered writes to %s in %s: %v\n", len(ops), ops[0].ctx, s, werr)
+			continue
+		}
+		batch := s.batcher.NewBatch(ops[0].ctx)
+		for _, op := range ops {
+			if op.del {
+				batch.Delete(op.tk)
+			} else {
+				batch.Put(op.tk, op.v)
+			}
+		}
+		if cerr := batch.Commit(); cerr != nil {
+			if err == nil {
+				err = cerr
+			}
+			failed = append(failed, ops...)
+		}
+	}
+
+	s.mu.Lock()
+	// Requeue failed writes that weren't superseded so they are retried on the next flush.
+	for _, op := range failed {
+		key := string(op.ctx.ConstructKey(op.tk))
+		if _, found := s.pending[key]; !found {
+			s.pending[key] = op
+			s.curBytes += op.size()
+			s.addVersion(op.ctx)
+		}
+	}
+	s.flushing = nil
+	s.flushingVersions = nil
+	s.mu.Unlock()
+	return err
+}
+
+// ---- KeyValueGetter interface ------
+
+func (s *WriteBackStore) Get(ctx Context, tk TKey) ([]byte, error) {
+	key := string(ctx.ConstructKey(tk))
+	s.mu.RLock()
+	op, found := s.pending[key]
+	if !found {
+		op, found = s.flushing[key]
+	}
+	s.mu.RUnlock()
+	if found {
+		if op.del {
+			return nil, nil
+		}
+		return op.v, nil
+	}
+	if s.crossesVersions(ctx) {
+		if err := s.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	return s.db.Get(ctx, tk)
+}
+
+func (s *WriteBackStore) Exists(ctx Context, tk TKey) (bool, error) {
+	key := string(ctx.ConstructKey(tk))
+	s.mu.RLock()
+	op, found := s.pending[key]
+	if !found {
+		op, found = s.flushing[key]
+	}
+	s.mu.RUnlock()
+	if found {
+		return !op.del, nil
+	}
+	if s.crossesVersions(ctx) {
+		if err := s.Flush(); err != nil {
+			return false, err
+		}
+	}
+	return s.db.Exists(ctx, tk)
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+func (s *WriteBackStore) GetRange(ctx Context, kStart, kEnd TKey) ([]*TKeyValue, error) {
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+	return s.db.GetRange(ctx, kStart, kEnd)
+}
+
+func (s *WriteBackStore) KeysInRange(ctx Context, kStart, kEnd TKey) ([]TKey, error) {
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+	return s.db.KeysInRange(ctx, kStart, kEnd)
+}
+
+func (s *WriteBackStore) SendKeysInRange(ctx Context, kStart, kEnd TKey, ch KeyChan) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.db.SendKeysInRange(ctx, kStart, kEnd, ch)
+}
+
+func (s *WriteBackStore) ProcessRange(ctx Context, kStart, kEnd TKey, op *ChunkOp, f ChunkFunc) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.db.ProcessRange(ctx, kStart, kEnd, op, f)
+}
+
+func (s *WriteBackStore) RawRangeQuery(ctx context.Context, kStart, kEnd Key, keysOnly bool, out chan *KeyValue) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.db.RawRangeQuery(ctx, kStart, kEnd, keysOnly, out)
+}
+
+// ---- KeyValueSetter interface ------
+
+// check returns an error if a write through the context is refused or, since buffered
+// writes are flushed after the request, if the context's request has an open RequestBatch.
+func (s *WriteBackStore) check(ctx Context) error {
+	if err := CheckWrite(ctx); err != nil {
+		return err
+	}
+	return refuseUnqueued(s, ctx, "buffered write")
+}
+
+func (s *WriteBackStore) Put(ctx Context, tk TKey, v []byte) error {
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+	// copy the value since callers may reuse the slice after Put returns.
+	buf := make([]byte, len(v))
+	copy(buf, v)
+	return s.buffer(&wbOp{group: contextGroup(ctx), ctx: ctx, tk: tk, v: buf})
+}
+
+func (s *WriteBackStore) Delete(ctx Context, tk TKey) error {
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+	return s.buffer(&wbOp{group: contextGroup(ctx), ctx: ctx, tk: tk, del: true})
+}
+
+func (s *WriteBackStore) RawPut(k Key, v []byte) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.db.RawPut(k, v)
+}
+
+func (s *WriteBackStore) RawDelete(k Key) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.db.RawDelete(k)
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+func (s *WriteBackStore) PutRange(ctx Context, kvs []TKeyValue) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.db.PutRange(ctx, kvs)
+}
+
+func (s *WriteBackStore) DeleteRange(ctx Context, kStart, kEnd TKey) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.db.DeleteRange(ctx, kStart, kEnd)
+}
+
+func (s *WriteBackStore) DeleteAll(ctx Context, allVersions bool) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.db.DeleteAll(ctx, allVersions)
+}
+
+// ---- KeyValueBatcher interface ------
+
+type writeBackBatch struct {
+	s   *WriteBackStore
+	ctx Context
+	ops []*wbOp
+}
+
+// NewBatch returns a batch whose operations are added to the write-back buffer on commit.
+func (s *WriteBackStore) NewBatch(ctx Context) Batch {
+	return &writeBackBatch{s: s, ctx: ctx}
+}
+
+func (b *writeBackBatch) Put(tk TKey, v []byte) {
+	buf := make([]byte, len(v))
+	copy(buf, v)
+	b.ops = append(b.ops, &wbOp{group: contextGroup(b.ctx), ctx: b.ctx, tk: tk, v: buf})
+}
+
+func (b *writeBackBatch) Delete(tk TKey) {
+	b.ops = append(b.ops, &wbOp{group: contextGroup(b.ctx), ctx: b.ctx, tk: tk, del: true})
+}
+
+func (b *writeBackBatch) Commit() error {
+	if err := b.s.check(b.ctx); err != nil {
+		return err
+	}
+	return b.s.buffer(b.ops...)
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+// lockableCtx is a data context whose writes are refused once locked.
+type lockableCtx struct {
+	*DataContext
+	locked bool
+}
+
+func (ctx *lockableCtx) CheckWrite() error {
+	if ctx.locked {
+		return fmt.Errorf("%s is locked", ctx.DataContext)
+	}
+	return nil
+}
+
+func TestWriteBackLockedWrites(t *testing.T) {
+	mem := &testBatchStore{testKVStore: &testKVStore{kv: make(map[string][]byte)}}
+	store, err := wrapWriteGuard(mem)
+	if err != nil {
+		t.Fatalf("unable to wrap store: %v\n", err)
+	}
+	wb, err := NewWriteBackStore(store, WriteBackConfig{FlushMS: 3600000})
+	if err != nil {
+		t.Fatalf("unable to create write-back store: %v\n", err)
+	}
+	defer wb.Close()
+
+	ctx := &lockableCtx{DataContext: NewDataContext(&testData{instanceID: 1}, 1), locked: true}
+	if err := wb.Put(ctx, TKey("a"), []byte("a0")); err == nil {
+		t.Fatalf("expected put to locked context to be refused\n")
+	}
+	if err := wb.Delete(ctx, TKey("a")); err == nil {
+		t.Fatalf("expected delete in locked context to be refused\n")
+	}
+	batch := wb.NewBatch(ctx)
+	batch.Put(TKey("a"), []byte("a0"))
+	if err := batch.Commit(); err == nil {
+		t.Fatalf("expected batch commit to locked context to be refused\n")
+	}
+
+	// Writes buffered before the lock are dropped on flush rather than blocking the store.
+	ctx.locked = false
+	if err := wb.Put(ctx, TKey("b"), []byte("b0")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	ctx.locked = true
+	if err := wb.Flush(); err != nil {
+		t.Fatalf("expected refused writes to be dropped on flush, got %v\n", err)
+	}
+	if err := wb.RawPut(Key("raw"), []byte("raw")); err != nil {
+		t.Fatalf("error on raw put after dropped writes: %v\n", err)
+	}
+	ctx.locked = false
+	checkValue(t, wb, ctx, "b", nil)
+	if len(mem.kv) != 1 || mem.commits != 0 {
+		t.Errorf("expected only raw put to be written, got %d key-value pairs and %d commits\n", len(mem.kv), mem.commits)
+	}
+}
+
+// versionedTestCtx is a data context for versioned data.
+type versionedTestCtx struct {
+	*DataContext
+}
+
+func (ctx versionedTestCtx) Versioned() bool {
+	return true
+}
+
+func TestWriteBackCrossVersionGet(t *testing.T) {
+	mem := &testBatchStore{testKVStore: &testKVStore{kv: make(map[string][]byte)}}
+	wb, err := NewWriteBackStore(mem, WriteBackConfig{FlushMS: 3600000})
+	if err != nil {
+		t.Fatalf("unable to create write-back store: %v\n", err)
+	}
+	defer wb.Close()
+
+	data := &testData{instanceID: 1}
+	parent := versionedTestCtx{NewDataContext(data, 1)}
+	child := versionedTestCtx{NewDataContext(data, 2)}
+	if err := wb.Put(parent, TKey("a"), []byte("a1")); err != nil {
+		t.Fatalf("error on put: %v\n", err)
+	}
+	checkValue(t, wb, parent, "a", []byte("a1"))
+	if mem.commits != 0 {
+		t.Fatalf("expected get in same version to use buffer, got %d commits\n", mem.commits)
+	}
+
+	// A get in another version, which may be a descendant, must see the parent's write.
+	checkValue(t, wb, child, "a", nil)
+	if mem.commits != 1 {
+		t.Fatalf("expected get in another version to flush buffer, got %d commits\n", mem.commits)
+	}
+	if v := mem.kv[string(parent.ConstructKey(TKey("a")))]; string(v) != "a1" {
+		t.Errorf("expected flushed parent value, got %q\n", v)
+	}
+	if wb.crossesVersions(child) {
+		t.Errorf("expected no buffered versions after flush\n")
+	}
+}
@@ -0,0 +1,363 @@
+/*
+	This file implements a store wrapper that lets contexts refuse writes, e.g., puts and
+	deletes of versioned data in locked nodes.  Raw operations on full keys and deletion of
+	all of a data instance's key-value pairs aren't checked since they are used to move or
+	remove data across versions, e.g., during version deletion, push, and import.
+
+	Puts and deletes, including those of batches, are queued instead of written if the
+	context's request has a RequestBatch and the store can batch.  Other writes in such a
+	request are refused.
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// WriteChecker is implemented by contexts that can refuse writes.  Stores check the
+// context before each put or delete and return any error without writing.
+type WriteChecker interface {
+	CheckWrite() error
+}
+
+// CheckWrite returns an error if writes through the given context are refused.
+func CheckWrite(ctx Context) error {
+	if checker, ok := ctx.(WriteChecker); ok {
+		return checker.CheckWrite()
+	}
+	return nil
+}
+
+// wrapWriteGuard returns a store that checks contexts before writes to the passed store.
+// Ordered and batch interfaces are preserved, as are the transaction and request buffer
+// interfaces of ordered batch stores, whose patches and buffered writes are also checked.
+// Batch gets, TTL puts, streaming ranges, and size queries are passed through, failing if
+// the wrapped store doesn't support them.  Logs are returned as-is since their appends
+// aren't made through contexts.
+func wrapWriteGuard(store dvid.Store) (dvid.Store, error) {
+	if _, ok := store.(KeyValueDB); !ok {
+		if _, ok := store.(WriteLog); ok {
+			return store, nil
+		}
+		return nil, fmt.Errorf("store %s doesn't implement KeyValueDB", store)
+	}
+	txDB, isTx := store.(TransactionDB)
+	requester, canBuffer := store.(KeyValueRequester)
+	batcher, canBatch := store.(KeyValueBatcher)
+	if db, ok := store.(OrderedKeyValueDB); ok {
+		s := guardedOrderedStore{guardedStore{db, batcher}, db}
+		if !canBatch {
+			if isTx || canBuffer {
+				return nil, fmt.Errorf("store %s is transactional or buffers requests but can't batch", store)
+			}
+			return s, nil
+		}
+		bs := guardedOrderedBatchStore{s}
+		switch {
+		case isTx && canBuffer:
+			return guardedTxRequesterStore{guardedTxStore{bs, guardedTx{txDB}}, guardedRequester{requester}}, nil
+		case isTx:
+			return guardedTxStore{bs, guardedTx{txDB}}, nil
+		case canBuffer:
+			return guardedRequesterStore{bs, guardedRequester{requester}}, nil
+		}
+		return bs, nil
+	}
+	if isTx || canBuffer {
+		return nil, fmt.Errorf("store %s is transactional or buffers requests but isn't ordered", store)
+	}
+	s := guardedStore{store.(KeyValueDB), batcher}
+	if canBatch {
+		return guardedBatchStore{s}, nil
+	}
+	return s, nil
+}
+
+type guardedStore struct {
+	KeyValueDB
+	batcher KeyValueBatcher // nil if the store can't batch.
+}
+
+// queue adds writes to the request batch of the context, if any, and returns false if
+// they should be written directly.
+func (s guardedStore) queue(ctx Context, ops ...batchOp) bool {
+	if s.batcher == nil {
+		return false
+	}
+	rb := requestBatch(ctx)
+	return rb != nil && rb.queue(s.batcher, ctx, ops...)
+}
+
+func (s guardedStore) GetBatch(ctx Context, tks []TKey) ([][]byte, error) {
+	return GetBatch(s.KeyValueDB, ctx, tks)
+}
+
+func (s guardedStore) Put(ctx Context, tk TKey, v []byte) error {
+	if err := CheckWrite(ctx); err != nil {
+		return err
+	}
+	if s.queue(ctx, batchOp{tk: tk, v: v}) {
+		return nil
+	}
+	if err := refuseUnqueued(s.KeyValueDB, ctx, "put"); err != nil {
+		return err
+	}
+	return s.KeyValueDB.Put(ctx, tk, v)
+}
+
+func (s guardedStore) PutTTL(ctx Context, tk TKey, v []byte, ttl time.Duration) error {
+	ttlPutter, ok := s.KeyValueDB.(TTLPutter)
+	if !ok {
+		return fmt.Errorf("store %s doesn't support TTLs", s.KeyValueDB)
+	}
+	if err := CheckWrite(ctx); err != nil {
+		return err
+	}
+	if err := refuseUnqueued(s.KeyValueDB, ctx, "TTL put"); err != nil {
+		return err
+	}
+	return ttlPutter.PutTTL(ctx, tk, v, ttl)
+}
+
+func (s guardedStore) Delete(ctx Context, tk TKey) error {
+	if err := CheckWrite(ctx); err != nil {
+		return err
+	}
+	if s.queue(ctx, batchOp{tk: tk, delete: true}) {
+		return nil
+	}
+	if err := refuseUnqueued(s.KeyValueDB, ctx, "delete"); err != nil {
+		return err
+	}
+	return s.KeyValueDB.Delete(ctx, tk)
+}
+
+type guardedBatchStore struct {
+	guardedStore
+}
+
+func (s guardedBatchStore) NewBatch(ctx Context) Batch {
+	return &guardedBatch{s.guardedStore, ctx, nil}
+}
+
+type guardedOrderedStore struct {
+	guardedStore
+	db OrderedKeyValueDB
+}
+
+func (s guardedOrderedStore) GetRange(ctx Context, kStart, kEnd TKey) ([]*TKeyValue, error) {
+	return s.db.GetRange(ctx, kStart, kEnd)
+}
+
+func (s guardedOrderedStore) GetRangeStream(ctx Context, kStart, kEnd TKey) (*RangeStream, error) {
+	return GetRangeStream(s.db, ctx, kStart, kEnd)
+}
+
+func (s guardedOrderedStore) KeysInRange(ctx Context, kStart, kEnd TKey) ([]TKey, error) {
+	return s.db.KeysInRange(ctx, kStart, kEnd)
+}
+
+func (s guardedOrderedStore) SendKeysInRange(ctx Context, kStart, kEnd TKey, ch KeyChan) error {
+	return s.db.SendKeysInRange(ctx, kStart, kEnd, ch)
+}
+
+func (s guardedOrderedStore) ProcessRange(ctx Context, kStart, kEnd TKey, op *ChunkOp, f ChunkFunc) error {
+	return s.db.ProcessRange(ctx, kStart, kEnd, op, f)
+}
+
+func (s guardedOrderedStore) RawRangeQuery(ctx context.Context, kStart, kEnd Key, keysOnly bool, out chan *KeyValue) error {
+	return s.db.RawRangeQuery(ctx, kStart, kEnd, keysOnly, out)
+}
+
+func (s guardedOrderedStore) PutRange(ctx Context, kvs []TKeyValue) error {
+	if err := CheckWrite(ctx); err != nil {
+		return err
+	}
+	if s.batcher != nil && requestBatch(ctx) != nil {
+		ops := make([]batchOp, len(kvs))
+		for i, kv := range kvs {
+			ops[i] = batchOp{tk: kv.K, v: kv.V}
+		}
+		if s.queue(ctx, ops...) {
+			return nil
+		}
+	}
+	if err := refuseUnqueued(s.db, ctx, "put range"); err != nil {
+		return err
+	}
+	return s.db.PutRange(ctx, kvs)
+}
+
+func (s guardedOrderedStore) DeleteRange(ctx Context, kStart, kEnd TKey) error {
+	if err := CheckWrite(ctx); err != nil {
+		return err
+	}
+	if err := refuseUnqueued(s.db, ctx, "range delete"); err != nil {
+		return err
+	}
+	return s.db.DeleteRange(ctx, kStart, kEnd)
+}
+
+func (s guardedOrderedStore) DeleteAll(ctx Context, allVersions bool) error {
+	return s.db.DeleteAll(ctx, allVersions)
+}
+
+func (s guardedOrderedStore) GetApproximateSizes(ranges []KeyRange) ([]uint64, error) {
+	sv, ok := s.db.(SizeViewer)
+	if !ok {
+		return nil, fmt.Errorf("store %s can't report sizes", s.db)
+	}
+	return sv.GetApproximateSizes(ranges)
+}
+
+type guardedOrderedBatchStore struct {
+	guardedOrderedStore
+}
+
+func (s guardedOrderedBatchStore) NewBatch(ctx Context) Batch {
+	return &guardedBatch{s.guardedStore, ctx, nil}
+}
+
+// guardedBatch checks its context when committed so none of its puts or deletes are
+// written if refused.  Its puts and deletes are queued if the context's request has a
+// RequestBatch, else written with a batch of the store.
+type guardedBatch struct {
+	s   guardedStore
+	ctx Context
+	ops []batchOp
+}
+
+func (b *guardedBatch) Put(tk TKey, v []byte) {
+	b.ops = append(b.ops, batchOp{tk: tk, v: v})
+}
+
+func (b *guardedBatch) Delete(tk TKey) {
+	b.ops = append(b.ops, batchOp{tk: tk, delete: true})
+}
+
+func (b *guardedBatch) Commit() error {
+	if err := CheckWrite(b.ctx); err != nil {
+		return err
+	}
+	if b.s.queue(b.ctx, b.ops...) {
+		return nil
+	}
+	batch := b.s.batcher.NewBatch(b.ctx)
+	for _, op := range b.ops {
+		if op.delete {
+			batch.Delete(op.tk)
+		} else {
+			batch.Put(op.tk, op.v)
+		}
+	}
+	return batch.Commit()
+}
+
+// guardedTx checks the context of patches.  Key locks have no context and are passed
+// through.
+type guardedTx struct {
+	tx TransactionDB
+}
+
+func (g guardedTx) LockKey(k Key) error {
+	return g.tx.LockKey(k)
+}
+
+func (g guardedTx) UnlockKey(k Key) error {
+	return g.tx.UnlockKey(k)
+}
+
+func (g guardedTx) Patch(ctx Context, tk TKey, f PatchFunc) error {
+	if err := CheckWrite(ctx); err != nil {
+		return err
+	}
+	if err := refuseUnqueued(g.tx, ctx, "patch"); err != nil {
+		return err
+	}
+	return g.tx.Patch(ctx, tk, f)
+}
+
+// IsLocal passes through whether the transactional store is only within this server.
+func (g guardedTx) IsLocal() bool {
+	ldb, ok := g.tx.(LocalDB)
+	return ok && ldb.IsLocal()
+}
+
+type guardedTxStore struct {
+	guardedOrderedBatchStore
+	guardedTx
+}
+
+// guardedRequester returns request buffers that check contexts before buffering writes.
+type guardedRequester struct {
+	requester KeyValueRequester
+}
+
+func (g guardedRequester) NewBuffer(ctx Context) RequestBuffer {
+	return guardedBuffer{g.requester.NewBuffer(ctx)}
+}
+
+type guardedRequesterStore struct {
+	guardedOrderedBatchStore
+	guardedRequester
+}
+
+type guardedTxRequesterStore struct {
+	guardedTxStore
+	guardedRequester
+}
+
+// guardedBuffer refuses writes when they are buffered, so a refused write is never
+// queued for the flush.
+type guardedBuffer struct {
+	RequestBuffer
+}
+
+// check returns an error if a write through the context is refused or, since buffered
+// writes can't be queued, if the context's request has an open RequestBatch.
+func (b guardedBuffer) check(ctx Context) error {
+	if err := CheckWrite(ctx); err != nil {
+		return err
+	}
+	return refuseUnqueued(b.RequestBuffer, ctx, "buffered write")
+}
+
+func (b guardedBuffer) Put(ctx Context, tk TKey, v []byte) error {
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	return b.RequestBuffer.Put(ctx, tk, v)
+}
+
+func (b guardedBuffer) Delete(ctx Context, tk TKey) error {
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	return b.RequestBuffer.Delete(ctx, tk)
+}
+
+func (b guardedBuffer) PutRange(ctx Context, kvs []TKeyValue) error {
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	return b.RequestBuffer.PutRange(ctx, kvs)
+}
+
+func (b guardedBuffer) DeleteRange(ctx Context, kStart, kEnd TKey) error {
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	return b.RequestBuffer.DeleteRange(ctx, kStart, kEnd)
+}
+
+func (b guardedBuffer) PutCallback(ctx Context, tk TKey, v []byte, ch chan error) error {
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	return b.RequestBuffer.PutCallback(ctx, tk, v, ch)
+}